@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gorm.io/gorm"
+)
+
+// maxA11yPages caps the pages of one audit
+const maxA11yPages = 500
+
+// maxA11yPromptFindings caps the findings listed in a fix prompt
+const maxA11yPromptFindings = 50
+
+// A11yFinding is one accessibility problem on a page. Rules and impacts follow axe-core,
+// so findings read the same as those of browser tools.
+type A11yFinding struct {
+	Rule     string `json:"rule"`
+	Impact   string `json:"impact"` // critical, serious, moderate or minor
+	Message  string `json:"message"`
+	Selector string `json:"selector,omitempty"`
+	HTML     string `json:"html,omitempty"` // Start tag of the element
+}
+
+// A11yReport is the latest audit of a page
+type A11yReport struct {
+	ID         uint          `gorm:"primaryKey" json:"-"`
+	ProjectID  string        `gorm:"uniqueIndex:idx_a11y_page" json:"projectId,omitempty"`
+	Path       string        `gorm:"uniqueIndex:idx_a11y_page" json:"path"` // Page file relative to the workspace
+	AuditID    string        `gorm:"index" json:"auditId"`
+	Findings   []A11yFinding `gorm:"serializer:json" json:"findings,omitempty"`
+	Violations int           `json:"violations"`
+	Error      string        `json:"error,omitempty"` // Why the page could not be audited
+	CreatedAt  int64         `json:"createdAt"`
+}
+
+// A11yAuditRequest is the body of POST /api/tools/a11y
+type A11yAuditRequest struct {
+	ProjectID string   `json:"projectId,omitempty" validate:"omitempty,projectid"`
+	Pages     []string `json:"pages,omitempty" validate:"max=500,dive,max=512"` // Page names or HTML files; every page of the site when empty
+	FixPrompt bool     `json:"fixPrompt,omitempty"`                             // Also write a prompt for an AI command fixing the findings
+}
+
+// A11yAuditResult is the data of POST /api/tools/a11y
+type A11yAuditResult struct {
+	AuditID    string       `json:"auditId"`
+	Reports    []A11yReport `json:"reports"`
+	Violations int          `json:"violations"`
+	FixPrompt  string       `json:"fixPrompt,omitempty"`
+}
+
+// A11yReportList is the data of GET /api/tools/a11y/reports
+type A11yReportList struct {
+	Reports []A11yReport `json:"reports"`
+}
+
+// a11yPages resolves the pages of a request to HTML files of the workspace. Page
+// names use the file of their metadata, otherwise <name>.html.
+func a11yPages(db *gorm.DB, workspaceDir string, pages []string) ([]string, error) {
+	var paths []string
+	if len(pages) == 0 {
+		err := walkSitePages(workspaceDir, func(_, rel string, _ fs.DirEntry) error {
+			if len(paths) == maxA11yPages {
+				return newAPIError(400, "TOO_MANY_PAGES", fmt.Sprintf("The site has more than %d pages; select the pages to audit", maxA11yPages), nil)
+			}
+			paths = append(paths, rel)
+			return nil
+		})
+		return paths, err
+	}
+
+	seen := make(map[string]bool, len(pages))
+	for _, page := range pages {
+		rel := page
+		if ext := strings.ToLower(path.Ext(page)); ext != ".html" && ext != ".htm" {
+			var err error
+			if rel, err = lookupPageFile(db, page); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := resolvePathInDir(workspaceDir, rel); err != nil {
+			return nil, newAPIError(400, "INVALID_PATH", "Page files must stay inside the workspace", page)
+		}
+		if rel = path.Clean("/" + rel)[1:]; !seen[rel] {
+			seen[rel] = true
+			paths = append(paths, rel)
+		}
+	}
+	return paths, nil
+}
+
+// a11yChecker collects the findings of one document
+type a11yChecker struct {
+	findings  []A11yFinding
+	ids       map[string]int
+	labelFor  map[string]bool
+	headings  []int
+	hasTitle  bool
+	hasLang   bool
+	reportIDs map[string]bool
+}
+
+func (a *a11yChecker) add(n *html.Node, rule, impact, message string) {
+	finding := A11yFinding{Rule: rule, Impact: impact, Message: message}
+	if n != nil {
+		finding.Selector = cssPath(n)
+		finding.HTML = startTag(n)
+	}
+	a.findings = append(a.findings, finding)
+}
+
+func attr(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func attrText(n *html.Node, name string) string {
+	value, _ := attr(n, name)
+	return strings.TrimSpace(value)
+}
+
+// startTag renders the start tag of an element for a finding
+func startTag(n *html.Node) string {
+	var b strings.Builder
+	b.WriteString("<" + n.Data)
+	for _, a := range n.Attr {
+		fmt.Fprintf(&b, ` %s="%s"`, a.Key, html.EscapeString(a.Val))
+	}
+	b.WriteString(">")
+	tag := b.String()
+	if len(tag) > 200 {
+		tag = tag[:197] + "..."
+	}
+	return tag
+}
+
+// cssPath returns a selector of an element, anchored at the closest ancestor with an ID
+func cssPath(n *html.Node) string {
+	var parts []string
+	for ; n != nil && n.Type == html.ElementNode; n = n.Parent {
+		if id := attrText(n, "id"); id != "" {
+			parts = append(parts, n.Data+"#"+id)
+			break
+		}
+		if n.DataAtom == atom.Html || n.DataAtom == atom.Body || n.DataAtom == atom.Head {
+			parts = append(parts, n.Data)
+			break
+		}
+		index, count := 0, 0
+		for sibling := n.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+			if sibling.Type == html.ElementNode && sibling.Data == n.Data {
+				count++
+				if sibling == n {
+					index = count
+				}
+			}
+		}
+		if count > 1 {
+			parts = append(parts, fmt.Sprintf("%s:nth-of-type(%d)", n.Data, index))
+		} else {
+			parts = append(parts, n.Data)
+		}
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, " > ")
+}
+
+// accessibleName approximates the name assistive technology announces for an element:
+// its ARIA label, its text and the alt text of its images, or its title
+func accessibleName(n *html.Node) string {
+	if label := attrText(n, "aria-label"); label != "" {
+		return label
+	}
+	if attrText(n, "aria-labelledby") != "" {
+		return "labelledby"
+	}
+	var text strings.Builder
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		switch {
+		case node.Type == html.TextNode:
+			text.WriteString(node.Data)
+		case node.Type == html.ElementNode && attrText(node, "aria-hidden") == "true":
+			return
+		case node.Type == html.ElementNode && (node.DataAtom == atom.Img || (node.DataAtom == atom.Input && attrText(node, "type") == "image")):
+			text.WriteString(attrText(node, "alt"))
+		case node.Type == html.ElementNode && node.DataAtom == atom.Svg:
+			for child := node.FirstChild; child != nil; child = child.NextSibling {
+				if child.Type == html.ElementNode && child.Data == "title" && child.FirstChild != nil {
+					text.WriteString(child.FirstChild.Data)
+				}
+			}
+			return
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	if name := strings.TrimSpace(text.String()); name != "" {
+		return name
+	}
+	return attrText(n, "title")
+}
+
+// collect records the IDs and label targets of the document before it is checked
+func (a *a11yChecker) collect(n *html.Node) {
+	if n.Type == html.ElementNode {
+		if id := attrText(n, "id"); id != "" {
+			a.ids[id]++
+		}
+		if n.DataAtom == atom.Label {
+			if target := attrText(n, "for"); target != "" {
+				a.labelFor[target] = true
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		a.collect(child)
+	}
+}
+
+// check runs the element rules on n and its descendants
+func (a *a11yChecker) check(n *html.Node, inLabel bool) {
+	if n.Type == html.ElementNode {
+		hidden := attrText(n, "aria-hidden") == "true"
+		role := attrText(n, "role")
+		switch n.DataAtom {
+		case atom.Html:
+			a.hasLang = attrText(n, "lang") != ""
+		case atom.Title:
+			if n.Parent != nil && n.Parent.DataAtom == atom.Head && strings.TrimSpace(accessibleName(n)) != "" {
+				a.hasTitle = true
+			}
+		case atom.Meta:
+			if strings.EqualFold(attrText(n, "name"), "viewport") {
+				a.checkViewport(n)
+			}
+		case atom.Img:
+			if _, ok := attr(n, "alt"); !ok && !hidden && role != "presentation" && role != "none" {
+				a.add(n, "image-alt", "critical", "Image has no alt text (alt=\"\" marks a decorative image)")
+			}
+		case atom.A:
+			if _, ok := attr(n, "href"); ok && !hidden && accessibleName(n) == "" {
+				a.add(n, "link-name", "serious", "Link has no text that describes where it goes")
+			}
+		case atom.Button:
+			if !hidden && accessibleName(n) == "" {
+				a.add(n, "button-name", "critical", "Button has no text or label")
+			}
+		case atom.Input:
+			a.checkInput(n, inLabel)
+		case atom.Select, atom.Textarea:
+			if !a.labelled(n, inLabel) {
+				a.add(n, "label", "critical", "Form field has no label")
+			}
+		case atom.Iframe, atom.Frame:
+			if attrText(n, "title") == "" && !hidden {
+				a.add(n, "frame-title", "serious", "Frame has no title describing its content")
+			}
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			level := int(n.Data[1] - '0')
+			if len(a.headings) > 0 && level > a.headings[len(a.headings)-1]+1 {
+				a.add(n, "heading-order", "moderate", fmt.Sprintf("Heading level jumps from h%d to h%d", a.headings[len(a.headings)-1], level))
+			}
+			a.headings = append(a.headings, level)
+			if accessibleName(n) == "" {
+				a.add(n, "empty-heading", "minor", "Heading is empty")
+			}
+		}
+		if tabindex, err := strconv.Atoi(attrText(n, "tabindex")); err == nil && tabindex > 0 {
+			a.add(n, "tabindex", "serious", "tabindex above 0 changes the keyboard order of the page")
+		}
+		if id := attrText(n, "id"); id != "" && a.ids[id] > 1 && !a.reportIDs[id] {
+			a.reportIDs[id] = true
+			a.add(n, "duplicate-id", "minor", fmt.Sprintf("ID %q is used by %d elements", id, a.ids[id]))
+		}
+		if n.DataAtom == atom.Label {
+			inLabel = true
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		a.check(child, inLabel)
+	}
+}
+
+// labelled reports whether a form field has a label
+func (a *a11yChecker) labelled(n *html.Node, inLabel bool) bool {
+	id := attrText(n, "id")
+	return inLabel || (id != "" && a.labelFor[id]) || attrText(n, "aria-label") != "" ||
+		attrText(n, "aria-labelledby") != "" || attrText(n, "title") != ""
+}
+
+func (a *a11yChecker) checkInput(n *html.Node, inLabel bool) {
+	switch strings.ToLower(attrText(n, "type")) {
+	case "hidden":
+	case "image":
+		if attrText(n, "alt") == "" && attrText(n, "aria-label") == "" {
+			a.add(n, "input-image-alt", "critical", "Image button has no alt text")
+		}
+	case "submit", "reset":
+		// Browsers label these Submit and Reset when they have no value
+	case "button":
+		if attrText(n, "value") == "" && attrText(n, "aria-label") == "" && attrText(n, "title") == "" {
+			a.add(n, "input-button-name", "critical", "Button has no value or label")
+		}
+	default:
+		if !a.labelled(n, inLabel) {
+			a.add(n, "label", "critical", "Form field has no label")
+		}
+	}
+}
+
+// checkViewport flags viewport settings that keep people from zooming
+func (a *a11yChecker) checkViewport(n *html.Node) {
+	for _, part := range strings.Split(attrText(n, "content"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.ToLower(strings.TrimSpace(value))
+		if key == "user-scalable" && (value == "no" || value == "0") {
+			a.add(n, "meta-viewport", "critical", "The viewport disables zooming (user-scalable=no)")
+		}
+		if scale, err := strconv.ParseFloat(value, 64); key == "maximum-scale" && err == nil && scale < 2 {
+			a.add(n, "meta-viewport", "critical", "The viewport limits zooming (maximum-scale below 2)")
+		}
+	}
+}
+
+// auditHTML checks a page with static rules for the most common WCAG failures
+func auditHTML(page []byte) ([]A11yFinding, error) {
+	doc, err := html.Parse(strings.NewReader(string(page)))
+	if err != nil {
+		return nil, err
+	}
+	a := &a11yChecker{ids: map[string]int{}, labelFor: map[string]bool{}, reportIDs: map[string]bool{}}
+	a.collect(doc)
+	a.check(doc, false)
+
+	if !a.hasLang {
+		a.add(nil, "html-has-lang", "serious", "The <html> element has no lang attribute")
+	}
+	if !a.hasTitle {
+		a.add(nil, "document-title", "serious", "The page has no <title>")
+	}
+	if len(a.headings) > 0 && !slices.Contains(a.headings, 1) {
+		a.add(nil, "page-has-heading-one", "moderate", "The page has no h1 heading")
+	}
+	return a.findings, nil
+}
+
+// a11yFixPrompt writes the prompt of an AI command that fixes the findings of an audit
+func a11yFixPrompt(reports []A11yReport) string {
+	var b strings.Builder
+	b.WriteString("Fix these accessibility problems found by an audit of the site. Keep the design and the content as they are; ")
+	b.WriteString("change only the markup needed, and write alt text and labels that describe the element in the language of the page.\n")
+	listed := 0
+	for _, report := range reports {
+		if len(report.Findings) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s:\n", report.Path)
+		for _, finding := range report.Findings {
+			if listed == maxA11yPromptFindings {
+				fmt.Fprintf(&b, "\n(and more; run the audit again after fixing these)\n")
+				return b.String()
+			}
+			listed++
+			fmt.Fprintf(&b, "- [%s] %s", finding.Rule, finding.Message)
+			if finding.Selector != "" {
+				fmt.Fprintf(&b, " at %s", finding.Selector)
+			}
+			if finding.HTML != "" {
+				fmt.Fprintf(&b, ": %s", finding.HTML)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// auditPage audits one file and stores it as the latest report of the page
+func auditPage(db *gorm.DB, workspaceDir, projectID, auditID, rel string) A11yReport {
+	report := A11yReport{ProjectID: projectID, Path: rel, AuditID: auditID, CreatedAt: time.Now().Unix()}
+	file, err := resolvePathInDir(workspaceDir, rel)
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(file)
+	}
+	if err == nil {
+		report.Findings, err = auditHTML(data)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		report.Error = "page file not found"
+	} else if err != nil {
+		report.Error = err.Error()
+	}
+	report.Violations = len(report.Findings)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("project_id = ? AND path = ?", projectID, rel).Delete(&A11yReport{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&report).Error
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to store the accessibility report of %s: %v", rel, err)
+	}
+	return report
+}
+
+// AuditAccessibility checks pages of the workspace for accessibility problems and
+// stores a report per page. With Accept: text/event-stream the findings are streamed
+// as each page is checked.
+func AuditAccessibility(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[A11yAuditRequest](c)
+		workspaceDir, err := resolveWorkspaceDir(db, req.ProjectID)
+		if err != nil {
+			if errors.Is(err, errProjectNotFound) {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", req.ProjectID)
+			}
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+		paths, err := a11yPages(db, workspaceDir, req.Pages)
+		if err != nil {
+			return err
+		}
+
+		auditID := fmt.Sprintf("a11y_%d_%s", time.Now().Unix(), uuid.New().String()[:8])
+		run := func(emit func(event fiber.Map)) A11yAuditResult {
+			result := A11yAuditResult{AuditID: auditID, Reports: make([]A11yReport, 0, len(paths))}
+			for _, rel := range paths {
+				report := auditPage(db, workspaceDir, req.ProjectID, auditID, rel)
+				for _, finding := range report.Findings {
+					emit(fiber.Map{"type": "finding", "path": rel, "finding": finding})
+				}
+				emit(fiber.Map{"type": "page", "path": rel, "violations": report.Violations, "error": report.Error})
+				result.Reports = append(result.Reports, report)
+				result.Violations += report.Violations
+			}
+			if req.FixPrompt && result.Violations > 0 {
+				result.FixPrompt = a11yFixPrompt(result.Reports)
+			}
+			log.Printf("♿ Accessibility audit %s: %d violation(s) on %d page(s)", auditID, result.Violations, len(paths))
+			return result
+		}
+
+		if !strings.Contains(c.Get(fiber.HeaderAccept), "text/event-stream") {
+			return c.JSON(APIResponse[A11yAuditResult]{Success: true, Data: run(func(fiber.Map) {})})
+		}
+
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			emit := func(event fiber.Map) {
+				data, _ := json.Marshal(event)
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				w.Flush()
+			}
+			emit(fiber.Map{"type": "started", "auditId": auditID, "pages": len(paths)})
+			result := run(emit)
+			emit(fiber.Map{"type": "done", "auditId": auditID, "violations": result.Violations, "fixPrompt": result.FixPrompt})
+		})
+		return nil
+	}
+}
+
+// ListA11yReports returns the latest report of each audited page (?projectId=). Findings
+// are included when ?path= selects a page.
+func ListA11yReports(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := db.Where("project_id = ?", c.Query("projectId")).Order("path")
+		if rel := c.Query("path"); rel != "" {
+			query = query.Where("path = ?", path.Clean("/" + rel)[1:])
+		} else {
+			query = query.Omit("findings")
+		}
+
+		reports := []A11yReport{}
+		if err := query.Find(&reports).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load accessibility reports", err.Error())
+		}
+		return c.JSON(APIResponse[A11yReportList]{Success: true, Data: A11yReportList{Reports: reports}})
+	}
+}
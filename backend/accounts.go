@@ -0,0 +1,571 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// AuthSession is a sign-in of a user with a password or an OAuth provider. Requests
+// carry its short-lived access token, and the refresh token gets a new pair of tokens
+// until the session expires or is signed out. Only hashes of the tokens are stored.
+type AuthSession struct {
+	ID              string `gorm:"primaryKey" json:"id"`
+	UserID          string `gorm:"index" json:"userId"`
+	Provider        string `json:"provider"` // password, github or google
+	AccessHash      string `gorm:"uniqueIndex" json:"-"`
+	AccessExpiresAt int64  `json:"accessExpiresAt"`
+	RefreshHash     string `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt       int64  `gorm:"index" json:"expiresAt"` // When the refresh token stops working
+	IP              string `json:"ip"`
+	UserAgent       string `json:"userAgent"`
+	CreatedAt       int64  `json:"createdAt"`
+	LastUsedAt      int64  `json:"lastUsedAt"`
+}
+
+// Prefixes that tell session tokens from API tokens (se_)
+const (
+	accessTokenPrefix  = "sea_"
+	refreshTokenPrefix = "ser_"
+)
+
+// SignupRequest is the body of POST /api/auth/signup
+type SignupRequest struct {
+	Name     string `json:"name" validate:"required,max=200"`
+	Email    string `json:"email" validate:"required,email,max=320"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// LoginRequest is the body of POST /api/auth/login
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email,max=320"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// RefreshRequest is the body of POST /api/auth/refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+// PasswordRequest is the body of PUT /api/me/password. currentPassword is required once
+// the account has a password.
+type PasswordRequest struct {
+	CurrentPassword string `json:"currentPassword,omitempty" validate:"max=72"`
+	NewPassword     string `json:"newPassword" validate:"required,min=8,max=72"`
+}
+
+// AuthTokens is the answer to a sign-in or refresh
+type AuthTokens struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"` // Bearer
+	ExpiresIn    int64  `json:"expiresIn"` // Seconds the access token is valid
+	SessionID    string `json:"sessionId"`
+	User         *User  `json:"user"`
+}
+
+// CurrentUserInfo is the answer of GET /api/me
+type CurrentUserInfo struct {
+	User        *User            `json:"user"`
+	Role        Role             `json:"role"`
+	AuthEnabled bool             `json:"authEnabled"`
+	Providers   []string         `json:"providers,omitempty"` // Ways the user can sign in
+	SessionID   string           `json:"sessionId,omitempty"` // Set for requests with a session token
+	Teams       []TeamMembership `json:"teams,omitempty"`     // Projects the user has a team role on
+}
+
+// getAccessTokenTTL returns how long access tokens are valid (accounts.accessTtl, AUTH_ACCESS_TTL)
+// Falls back to 15 minutes
+func getAccessTokenTTL() time.Duration {
+	return appConfig.Accounts.AccessTTL.Duration()
+}
+
+// getRefreshTokenTTL returns how long a session can be refreshed (accounts.refreshTtl, AUTH_REFRESH_TTL)
+// Falls back to 30 days
+func getRefreshTokenTTL() time.Duration {
+	return appConfig.Accounts.RefreshTTL.Duration()
+}
+
+// isSignupEnabled reports whether anyone may create an account with a password or an
+// OAuth provider (accounts.signup, AUTH_SIGNUP)
+// Falls back to false: only users an admin created can sign in
+func isSignupEnabled() bool {
+	return appConfig.Accounts.Signup
+}
+
+// getSignupRole returns the role of accounts created by signing up (accounts.signupRole, AUTH_SIGNUP_ROLE)
+// Falls back to viewer
+func getSignupRole() Role {
+	return appConfig.Accounts.SignupRole
+}
+
+// validate checks the account settings
+func (a AccountConfig) validate() []error {
+	var errs []error
+	if !a.SignupRole.Valid() {
+		errs = append(errs, fmt.Errorf("accounts.signupRole must be none, viewer, editor or admin, got %q", a.SignupRole))
+	}
+	if a.AccessTTL <= 0 || a.RefreshTTL <= 0 {
+		errs = append(errs, errors.New("accounts.accessTtl and accounts.refreshTtl must be positive"))
+	}
+	if a.AccessTTL > a.RefreshTTL {
+		errs = append(errs, fmt.Errorf("accounts.accessTtl (%s) must not exceed accounts.refreshTtl (%s)", a.AccessTTL.Duration(), a.RefreshTTL.Duration()))
+	}
+	for _, setting := range []struct{ name, value string }{
+		{"accounts.publicUrl", a.PublicURL}, {"accounts.loginRedirect", a.LoginRedirect},
+	} {
+		if setting.value != "" {
+			if err := validateHTTPURL(setting.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", setting.name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// getLoginRateLimitSettings reads AUTH_LOGIN_RATE_LIMIT_PER_MINUTE and AUTH_LOGIN_RATE_LIMIT_BURST
+// Defaults to 10 attempts per minute with a burst of 5; a rate of 0 disables limiting
+func getLoginRateLimitSettings() (perMinute, burst int) {
+	perMinute, burst = 10, 5
+	if n, err := strconv.Atoi(os.Getenv("AUTH_LOGIN_RATE_LIMIT_PER_MINUTE")); err == nil && n >= 0 {
+		perMinute = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUTH_LOGIN_RATE_LIMIT_BURST")); err == nil && n > 0 {
+		burst = n
+	}
+	return perMinute, burst
+}
+
+// loginRateLimiter limits sign-in attempts per IP. It is replaced by a redisRateLimiter
+// in Redis mode.
+var loginRateLimiter AIRateLimiter = NewRateLimiter(getLoginRateLimitSettings())
+
+// RateLimitLogin rejects clients that try to sign in too often with 429
+func RateLimitLogin() fiber.Handler {
+	perMinute, _ := getLoginRateLimitSettings()
+	return func(c *fiber.Ctx) error {
+		if perMinute == 0 {
+			return c.Next()
+		}
+
+		allowed, wait := loginRateLimiter.Allow("login:" + c.IP())
+		if allowed {
+			return c.Next()
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Set("Retry-After", strconv.Itoa(retryAfter))
+		return sendError(c, 429, ErrCodeRateLimited, "Too many sign-in attempts, please try again later", fiber.Map{
+			"retryAfter": retryAfter,
+		})
+	}
+}
+
+// randomToken returns prefix followed by n random bytes in hex
+func randomToken(prefix string, n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(raw), nil
+}
+
+// hashPassword returns the bcrypt hash of a password
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// dummyPasswordHash is compared against when there is no password to check
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword(uuid.New().String())
+	return hash
+})
+
+// normalizeEmail returns an email address in the form accounts are looked up by
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// userByEmail returns the user with an email address, or nil
+func userByEmail(db *gorm.DB, email string) (*User, error) {
+	var user User
+	if err := db.Limit(1).Find(&user, "LOWER(email) = ?", normalizeEmail(email)).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == "" {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// newAccount creates a user who signed up with the given role. Accounts without an API
+// token get a random one nobody knows, since token hashes are unique; an admin can
+// rotate it.
+func newAccount(db *gorm.DB, user *User, role Role) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	user.ID = "usr_" + uuid.New().String()[:8]
+	user.Role = role
+	user.TokenHash = hashToken(token)
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	if err := db.Create(user).Error; err != nil {
+		return err
+	}
+	log.Printf("👤 %s signed up as %s user %s", user.Email, user.Role, user.ID)
+	return nil
+}
+
+// startSession signs a user in and returns their first pair of tokens
+func startSession(c *fiber.Ctx, db *gorm.DB, user *User, provider string) (*AuthTokens, error) {
+	now := time.Now()
+	session := AuthSession{
+		ID:        "sess_" + uuid.New().String()[:8],
+		UserID:    user.ID,
+		Provider:  provider,
+		ExpiresAt: now.Add(getRefreshTokenTTL()).Unix(),
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		CreatedAt: now.Unix(),
+	}
+	tokens, err := issueTokens(&session)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	db.Model(user).Update("last_login_at", now.Unix())
+
+	c.Locals("user", user)
+	recordAudit(db, c, AuditUserLogin, user.ID, nil, nil, provider)
+	log.Printf("🔑 User %s signed in with %s", user.ID, provider)
+	tokens.User = user
+	return tokens, nil
+}
+
+// issueTokens gives a session a new pair of tokens; the old ones stop working
+func issueTokens(session *AuthSession) (*AuthTokens, error) {
+	access, err := randomToken(accessTokenPrefix, 24)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken(refreshTokenPrefix, 32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ttl := getAccessTokenTTL()
+	session.AccessHash = hashToken(access)
+	session.AccessExpiresAt = now.Add(ttl).Unix()
+	session.RefreshHash = hashToken(refresh)
+	session.LastUsedAt = now.Unix()
+	return &AuthTokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(ttl.Seconds()),
+		SessionID:    session.ID,
+	}, nil
+}
+
+// userForToken returns the user of an API or access token and, for an access token, its
+// session
+func userForToken(db *gorm.DB, token string) (*User, *AuthSession, error) {
+	if !strings.HasPrefix(token, accessTokenPrefix) {
+		var user User
+		if err := db.Limit(1).Find(&user, "token_hash = ?", hashToken(token)).Error; err != nil {
+			return nil, nil, newAPIError(500, "DATABASE_ERROR", "Failed to look up user", err.Error())
+		}
+		if user.ID == "" {
+			return nil, nil, newAPIError(401, "INVALID_TOKEN", "The API token is not valid", "")
+		}
+		return &user, nil, nil
+	}
+
+	var session AuthSession
+	if err := db.Limit(1).Find(&session, "access_hash = ?", hashToken(token)).Error; err != nil {
+		return nil, nil, newAPIError(500, "DATABASE_ERROR", "Failed to look up session", err.Error())
+	}
+	if session.ID == "" {
+		return nil, nil, newAPIError(401, "INVALID_TOKEN", "The access token is not valid", "")
+	}
+	if session.AccessExpiresAt <= time.Now().Unix() {
+		return nil, nil, newAPIError(401, "TOKEN_EXPIRED", "The access token has expired", "Get a new one from POST /api/auth/refresh")
+	}
+	var user User
+	if err := db.Limit(1).Find(&user, "id = ?", session.UserID).Error; err != nil {
+		return nil, nil, newAPIError(500, "DATABASE_ERROR", "Failed to look up user", err.Error())
+	}
+	if user.ID == "" {
+		return nil, nil, newAPIError(401, "INVALID_TOKEN", "The user of the session no longer exists", "")
+	}
+	return &user, &session, nil
+}
+
+// currentSession returns the session of a request made with an access token, or nil
+func currentSession(c *fiber.Ctx) *AuthSession {
+	session, _ := c.Locals("authSession").(*AuthSession)
+	return session
+}
+
+// pruneAuthSessions deletes sessions whose refresh token expired
+func pruneAuthSessions(db *gorm.DB) int {
+	result := db.Where("expires_at <= ?", time.Now().Unix()).Delete(&AuthSession{})
+	if result.Error != nil {
+		log.Printf("⚠️ Cleanup failed to prune expired sessions: %v", result.Error)
+		return 0
+	}
+	return int(result.RowsAffected)
+}
+
+// Signup creates an account with an email and password when AUTH_SIGNUP is set and
+// signs it in
+func Signup(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !isSignupEnabled() {
+			return sendError(c, 403, "SIGNUP_DISABLED", "Accounts are created by an admin", "")
+		}
+		req := validatedBody[SignupRequest](c)
+
+		existing, err := userByEmail(db, req.Email)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to look up user", err.Error())
+		}
+		if existing != nil {
+			return sendError(c, 409, "EMAIL_TAKEN", "An account with this email already exists", "")
+		}
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			return sendError(c, 500, "PASSWORD_ERROR", "Failed to hash the password", err.Error())
+		}
+		user := &User{Name: strings.TrimSpace(req.Name), Email: normalizeEmail(req.Email), PasswordHash: hash}
+		if err := newAccount(db, user, getSignupRole()); err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create user", err.Error())
+		}
+		c.Locals("user", user)
+		recordAudit(db, c, AuditUserCreate, user.ID, nil, user, "signup")
+
+		tokens, err := startSession(c, db, user, "password")
+		if err != nil {
+			return sendError(c, 500, "SESSION_ERROR", "Failed to sign in", err.Error())
+		}
+		return c.Status(201).JSON(APIResponse[AuthTokens]{Success: true, Data: *tokens})
+	}
+}
+
+// Login signs a user in with their email and password
+func Login(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[LoginRequest](c)
+
+		user, err := userByEmail(db, req.Email)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to look up user", err.Error())
+		}
+		// Unknown emails and accounts without a password take as long as a wrong password
+		hash, known := dummyPasswordHash(), user != nil && user.PasswordHash != ""
+		if known {
+			hash = user.PasswordHash
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil || !known {
+			return sendError(c, 401, "INVALID_CREDENTIALS", "The email or password is wrong", "")
+		}
+
+		tokens, err := startSession(c, db, user, "password")
+		if err != nil {
+			return sendError(c, 500, "SESSION_ERROR", "Failed to sign in", err.Error())
+		}
+		return c.JSON(APIResponse[AuthTokens]{Success: true, Data: *tokens})
+	}
+}
+
+// RefreshSession trades a refresh token for a new pair of tokens. The refresh token can
+// only be used once.
+func RefreshSession(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[RefreshRequest](c)
+
+		var session AuthSession
+		if err := db.Limit(1).Find(&session, "refresh_hash = ?", hashToken(req.RefreshToken)).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to look up session", err.Error())
+		}
+		if session.ID == "" {
+			return sendError(c, 401, "INVALID_REFRESH_TOKEN", "The refresh token is not valid", "")
+		}
+		if session.ExpiresAt <= time.Now().Unix() {
+			db.Delete(&session)
+			return sendError(c, 401, "SESSION_EXPIRED", "The session has expired; sign in again", "")
+		}
+		var user User
+		if err := db.First(&user, "id = ?", session.UserID).Error; err != nil {
+			return sendError(c, 401, "INVALID_REFRESH_TOKEN", "The user of the session no longer exists", "")
+		}
+
+		oldHash := session.RefreshHash
+		tokens, err := issueTokens(&session)
+		if err != nil {
+			return sendError(c, 500, "TOKEN_ERROR", "Failed to generate tokens", err.Error())
+		}
+		// Only the first of concurrent refreshes with the same token wins
+		result := db.Model(&AuthSession{}).Where("id = ? AND refresh_hash = ?", session.ID, oldHash).Updates(map[string]interface{}{
+			"access_hash":       session.AccessHash,
+			"access_expires_at": session.AccessExpiresAt,
+			"refresh_hash":      session.RefreshHash,
+			"last_used_at":      session.LastUsedAt,
+		})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to refresh session", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 401, "INVALID_REFRESH_TOKEN", "The refresh token is not valid", "")
+		}
+		tokens.User = &user
+		return c.JSON(APIResponse[AuthTokens]{Success: true, Data: *tokens})
+	}
+}
+
+// Logout ends the session of the access token the request was made with
+func Logout(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		session := currentSession(c)
+		if session == nil {
+			return sendError(c, 400, "NO_SESSION", "The request was not made with an access token", "")
+		}
+		if err := db.Delete(&AuthSession{}, "id = ?", session.ID).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to sign out", err.Error())
+		}
+		log.Printf("🔑 User %s signed out of session %s", session.UserID, session.ID)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Signed out",
+		})
+	}
+}
+
+// userProviders returns the ways a user can sign in
+func userProviders(user *User) []string {
+	var providers []string
+	if user.PasswordHash != "" {
+		providers = append(providers, "password")
+	}
+	if user.GitHubID != "" {
+		providers = append(providers, "github")
+	}
+	if user.GoogleID != "" {
+		providers = append(providers, "google")
+	}
+	return providers
+}
+
+// GetMe returns the signed-in user, their role and teams, and how they can sign in
+func GetMe(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		info := CurrentUserInfo{User: currentUser(c), Role: currentRole(c), AuthEnabled: getAuthEnabled()}
+		if info.User != nil {
+			info.Providers = userProviders(info.User)
+			info.Teams = userTeams(db, info.User.ID)
+		}
+		if session := currentSession(c); session != nil {
+			info.SessionID = session.ID
+		}
+		return c.JSON(APIResponse[CurrentUserInfo]{Success: true, Data: info})
+	}
+}
+
+// RequireAccount lets through requests made with a user's token, whatever their role:
+// viewers too manage their own password and sessions, which Authenticate would reject
+// as writes. It is used on routes registered before Authenticate.
+func RequireAccount(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := requestToken(c)
+		if token == "" {
+			return sendError(c, 401, "AUTH_REQUIRED", "Sign in first", "Send Authorization: Bearer <token>")
+		}
+		user, session, err := userForToken(db, token)
+		if err != nil {
+			return err
+		}
+		c.Locals("user", user)
+		c.Locals("authSession", session)
+		c.Locals("role", user.Role)
+		return c.Next()
+	}
+}
+
+// ChangePassword sets the password of the signed-in user. Their other sessions are
+// signed out.
+func ChangePassword(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		req := validatedBody[PasswordRequest](c)
+		if user.PasswordHash != "" && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)) != nil {
+			return sendError(c, 403, "INVALID_CREDENTIALS", "The current password is wrong", "")
+		}
+		hash, err := hashPassword(req.NewPassword)
+		if err != nil {
+			return sendError(c, 500, "PASSWORD_ERROR", "Failed to hash the password", err.Error())
+		}
+		if err := db.Model(user).Updates(map[string]interface{}{"password_hash": hash, "updated_at": time.Now().Unix()}).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update user", err.Error())
+		}
+
+		others := db.Where("user_id = ?", user.ID)
+		if session := currentSession(c); session != nil {
+			others = others.Where("id <> ?", session.ID)
+		}
+		others.Delete(&AuthSession{})
+		recordAudit(db, c, AuditUserUpdate, user.ID, nil, nil, "password changed")
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Password changed",
+		})
+	}
+}
+
+// ListMySessions returns the sessions of the signed-in user, newest first
+func ListMySessions(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		var sessions []AuthSession
+		if err := db.Where("user_id = ? AND expires_at > ?", user.ID, time.Now().Unix()).Order("created_at DESC").Find(&sessions).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load sessions", err.Error())
+		}
+		return c.JSON(APIResponse[[]AuthSession]{Success: true, Data: sessions})
+	}
+}
+
+// DeleteMySession signs one of the user's sessions out
+func DeleteMySession(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		result := db.Where("id = ? AND user_id = ?", c.Params("id"), user.ID).Delete(&AuthSession{})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete session", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", c.Params("id"))
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Session signed out",
+		})
+	}
+}
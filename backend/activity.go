@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ActivityEvent is an entry of the activity feed, which follows what AI commands did
+type ActivityEvent struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	At        int64  `gorm:"index" json:"at"`
+	Type      string `gorm:"index" json:"type"` // command, file or tool
+	Name      string `json:"name"`              // Scope of the command, or the tool
+	Action    string `json:"action"`            // e.g. started, completed, failed, created, modified, deleted, succeeded
+	Target    string `json:"target"`            // Page, file, content block or what the tool was run on
+	CommandID string `gorm:"index" json:"commandId,omitempty"`
+	ProjectID string `json:"projectId,omitempty"`
+}
+
+// Types of activity events
+const (
+	ActivityCommand = "command" // A command started or reached a final status
+	ActivityFile    = "file"    // A command created, changed or deleted a workspace file
+	ActivityTool    = "tool"    // A tool the provider ran for a command finished
+)
+
+// maxActivityEvents caps the activity events returned per request
+const maxActivityEvents = 500
+
+// getActivityKeep returns how many activity events are kept from ACTIVITY_KEEP
+// Falls back to 1000
+func getActivityKeep() int {
+	if n, err := strconv.Atoi(getEnvDefault("ACTIVITY_KEEP", "1000")); err == nil && n > 0 {
+		return n
+	}
+	return 1000
+}
+
+// recordActivity adds an event to the activity feed
+func recordActivity(db *gorm.DB, event ActivityEvent) {
+	if event.At == 0 {
+		event.At = time.Now().Unix()
+	}
+	if err := db.Create(&event).Error; err != nil {
+		log.Printf("⚠️ Failed to record activity %s %s %s: %v", event.Type, event.Action, event.Target, err)
+	}
+}
+
+// recordCommandActivity records that a command reached its final status, with an event
+// for each workspace file it changed
+func recordCommandActivity(db *gorm.DB, command *AICommand) {
+	recordActivity(db, ActivityEvent{
+		Type:      ActivityCommand,
+		Name:      command.Scope,
+		Action:    command.Status,
+		Target:    command.Page,
+		CommandID: command.ID,
+		ProjectID: command.ProjectID,
+	})
+
+	var result CommandResult
+	if command.Result == "" || json.Unmarshal([]byte(command.Result), &result) != nil {
+		return
+	}
+	for _, change := range result.Changes {
+		recordActivity(db, ActivityEvent{
+			Type:      ActivityFile,
+			Name:      command.Scope,
+			Action:    change.Type,
+			Target:    change.Target,
+			CommandID: command.ID,
+			ProjectID: command.ProjectID,
+		})
+	}
+}
+
+// pruneActivity deletes all but the newest ACTIVITY_KEEP activity events
+func pruneActivity(db *gorm.DB) int {
+	var cutoff []uint
+	db.Model(&ActivityEvent{}).Order("id DESC").Offset(getActivityKeep()).Limit(1).Pluck("id", &cutoff)
+	if len(cutoff) == 0 {
+		return 0
+	}
+	result := db.Where("id <= ?", cutoff[0]).Delete(&ActivityEvent{})
+	if result.Error != nil {
+		log.Printf("⚠️ Cleanup failed to prune activity events: %v", result.Error)
+		return 0
+	}
+	return int(result.RowsAffected)
+}
+
+// activityMarkdown renders events as the list command-summary.md used to hold
+func activityMarkdown(events []ActivityEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Activity - Last %d Events\n\n", len(events))
+	for _, event := range events {
+		name := event.Name
+		if name == "" {
+			name = event.Type
+		}
+		fmt.Fprintf(&b, "- `[%s]` **%s** → %s | Target: `%s`", time.Unix(event.At, 0).Format("2006-01-02 15:04:05"), name, event.Action, event.Target)
+		if event.CommandID != "" {
+			fmt.Fprintf(&b, " | Command: `%s`", event.CommandID)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// ListActivity returns the activity feed, newest first, filtered by ?type= (comma
+// separated), ?commandId= and ?projectId=. ?limit= (default 50) and ?before= (an event
+// ID) page back. With ?format=markdown the events come as a Markdown list.
+func ListActivity(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		format := c.Query("format", "json")
+		if format != "json" && format != "markdown" && format != "md" {
+			return sendError(c, 400, "INVALID_FORMAT", "format must be json or markdown", format)
+		}
+		query := db.Model(&ActivityEvent{}).Order("id DESC")
+
+		if types := c.Query("type"); types != "" {
+			query = query.Where("type IN ?", strings.Split(types, ","))
+		}
+		if commandID := c.Query("commandId"); commandID != "" {
+			query = query.Where("command_id = ?", commandID)
+		}
+		if projectID := c.Query("projectId"); projectID != "" {
+			query = query.Where("project_id = ?", projectID)
+		}
+		if value := c.Query("before"); value != "" {
+			before, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return sendError(c, 400, "INVALID_CURSOR", "before must be an event ID", value)
+			}
+			query = query.Where("id < ?", before)
+		}
+
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > maxActivityEvents {
+			limit = maxActivityEvents
+		}
+
+		var events []ActivityEvent
+		if err := query.Limit(limit).Find(&events).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load activity", err.Error())
+		}
+
+		if format != "json" {
+			c.Set(fiber.HeaderContentType, "text/markdown; charset=utf-8")
+			return c.SendString(activityMarkdown(events))
+		}
+
+		data := fiber.Map{"events": events}
+		if len(events) == limit {
+			data["nextBefore"] = events[len(events)-1].ID
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
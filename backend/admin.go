@@ -0,0 +1,272 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ContentStats counts content blocks by state
+type ContentStats struct {
+	Total     int64 `json:"total"`
+	Edited    int64 `json:"edited"`
+	Unedited  int64 `json:"unedited"`
+	Published int64 `json:"published"`
+	Pages     int64 `json:"pages"`
+}
+
+// CommandDay counts the commands created on one day (UTC)
+type CommandDay struct {
+	Date   string `json:"date"`
+	Total  int    `json:"total"`
+	Failed int    `json:"failed"`
+}
+
+// CommandStats summarizes AI commands created in the reporting window
+type CommandStats struct {
+	Total              int            `json:"total"`
+	ByStatus           map[string]int `json:"byStatus"`
+	FailureRate        float64        `json:"failureRate"`        // failed and timed out / finished
+	AverageDurationSec float64        `json:"averageDurationSec"` // From submission to completion
+	PerDay             []CommandDay   `json:"perDay"`
+}
+
+// SessionStats counts what is running right now
+type SessionStats struct {
+	AgentSessions      int `json:"agentSessions"` // Kept in memory, running or finished
+	RunningAgents      int `json:"runningAgents"`
+	ProcessingCommands int `json:"processingCommands"`
+	ContentSubscribers int `json:"contentSubscribers"`
+	RunningPreviews    int `json:"runningPreviews"`
+}
+
+// DiskUsage is the size of one workspace
+type DiskUsage struct {
+	ProjectID  string `json:"projectId,omitempty"` // Empty for the global workspace
+	Path       string `json:"path"`
+	Bytes      int64  `json:"bytes"`
+	Files      int64  `json:"files"`
+	Error      string `json:"error,omitempty"`
+	MeasuredAt int64  `json:"measuredAt"`
+}
+
+// diskUsageTTL is how long a measured workspace size is reused; walking
+// node_modules on every dashboard refresh would be slow
+const diskUsageTTL = time.Minute
+
+var (
+	diskUsageCache   = make(map[string]DiskUsage)
+	diskUsageCacheMu sync.Mutex
+)
+
+// measureDiskUsage adds up the size of every regular file below path
+func measureDiskUsage(path string) DiskUsage {
+	diskUsageCacheMu.Lock()
+	cached, ok := diskUsageCache[path]
+	diskUsageCacheMu.Unlock()
+	if ok && time.Since(time.Unix(cached.MeasuredAt, 0)) < diskUsageTTL {
+		return cached
+	}
+
+	usage := DiskUsage{Path: path, MeasuredAt: time.Now().Unix()}
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil // Removed while walking
+		}
+		usage.Bytes += info.Size()
+		usage.Files++
+		return nil
+	})
+	if err != nil {
+		usage.Error = err.Error()
+	}
+
+	diskUsageCacheMu.Lock()
+	diskUsageCache[path] = usage
+	diskUsageCacheMu.Unlock()
+	return usage
+}
+
+func contentStats(db *gorm.DB) (ContentStats, error) {
+	var stats ContentStats
+	if err := db.Model(&Content{}).Count(&stats.Total).Error; err != nil {
+		return stats, err
+	}
+	if err := db.Model(&Content{}).Where("is_edited = ?", true).Count(&stats.Edited).Error; err != nil {
+		return stats, err
+	}
+	if err := db.Model(&Content{}).Where("is_published = ?", true).Count(&stats.Published).Error; err != nil {
+		return stats, err
+	}
+	if err := db.Model(&Content{}).Distinct("page").Count(&stats.Pages).Error; err != nil {
+		return stats, err
+	}
+	stats.Unedited = stats.Total - stats.Edited
+	return stats, nil
+}
+
+// commandStats aggregates the commands created in the last days days
+func commandStats(db *gorm.DB, days int) (CommandStats, error) {
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -(days - 1))
+
+	var commands []AICommand
+	err := db.Select("status", "created_at", "completed_at").
+		Where("created_at >= ?", start.Unix()).Find(&commands).Error
+	if err != nil {
+		return CommandStats{}, err
+	}
+
+	// Every day of the window is listed, including the ones without commands
+	stats := CommandStats{ByStatus: make(map[string]int), PerDay: make([]CommandDay, days)}
+	perDay := make(map[string]*CommandDay, days)
+	for i := range stats.PerDay {
+		stats.PerDay[i].Date = start.AddDate(0, 0, i).Format("2006-01-02")
+		perDay[stats.PerDay[i].Date] = &stats.PerDay[i]
+	}
+
+	var finished, failed, timed int
+	var duration int64
+	for _, command := range commands {
+		stats.Total++
+		stats.ByStatus[command.Status]++
+		isFailure := command.Status == "failed" || command.Status == "timed_out" || command.Status == StatusResourceLimit
+
+		if day := perDay[time.Unix(command.CreatedAt, 0).UTC().Format("2006-01-02")]; day != nil {
+			day.Total++
+			if isFailure {
+				day.Failed++
+			}
+		}
+
+		switch command.Status {
+		case "completed", "failed", "timed_out", StatusResourceLimit, "interrupted":
+			finished++
+			if isFailure {
+				failed++
+			}
+		}
+		if command.Status == "completed" && command.CompletedAt >= command.CreatedAt {
+			duration += command.CompletedAt - command.CreatedAt
+			timed++
+		}
+	}
+	if finished > 0 {
+		stats.FailureRate = float64(failed) / float64(finished)
+	}
+	if timed > 0 {
+		stats.AverageDurationSec = float64(duration) / float64(timed)
+	}
+	return stats, nil
+}
+
+func sessionStats() SessionStats {
+	var stats SessionStats
+
+	sessMu.RLock()
+	stats.AgentSessions = len(sessions)
+	for _, session := range sessions {
+		session.mu.Lock()
+		if session.isRunning {
+			stats.RunningAgents++
+		}
+		session.mu.Unlock()
+	}
+	sessMu.RUnlock()
+
+	commandMu.RLock()
+	for _, session := range commandSessions {
+		session.mu.RLock()
+		if session.isProcessing {
+			stats.ProcessingCommands++
+		}
+		session.mu.RUnlock()
+	}
+	commandMu.RUnlock()
+
+	subscribersMu.RLock()
+	for _, subs := range contentSubscribers {
+		stats.ContentSubscribers += len(subs)
+	}
+	subscribersMu.RUnlock()
+
+	previewMu.Lock()
+	for _, preview := range previews {
+		if preview.Status == "running" {
+			stats.RunningPreviews++
+		}
+	}
+	previewMu.Unlock()
+	return stats
+}
+
+// workspaceDiskUsage measures the global workspace and every project workspace
+func workspaceDiskUsage(db *gorm.DB) ([]DiskUsage, error) {
+	var projects []Project
+	if err := db.Select("id", "workspace_path").Order("id").Find(&projects).Error; err != nil {
+		return nil, err
+	}
+
+	usage := []DiskUsage{measureDiskUsage(getWorkspaceDir())}
+	for _, project := range projects {
+		u := measureDiskUsage(project.WorkspacePath)
+		u.ProjectID = project.ID
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+func adminStatsError(c *fiber.Ctx, err error) error {
+	return sendError(c, 500, "DATABASE_ERROR", "Failed to collect statistics", err.Error())
+}
+
+// GetAdminStats returns aggregate numbers for an admin dashboard. ?days= sets the
+// window of the command statistics (default 14, at most 90).
+func GetAdminStats(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		days := 14
+		if value := c.Query("days"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 90 {
+				return sendError(c, 400, "INVALID_DAYS", "days must be between 1 and 90", nil)
+			}
+			days = n
+		}
+
+		content, err := contentStats(db)
+		if err != nil {
+			return adminStatsError(c, err)
+		}
+		commands, err := commandStats(db, days)
+		if err != nil {
+			return adminStatsError(c, err)
+		}
+		disk, err := workspaceDiskUsage(db)
+		if err != nil {
+			return adminStatsError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"content":     content,
+				"commands":    commands,
+				"sessions":    sessionStats(),
+				"disk":        disk,
+				"generatedAt": time.Now().Unix(),
+			},
+		})
+	}
+}
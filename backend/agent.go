@@ -3,29 +3,113 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // AgentSession represents an active AI agent process
 type AgentSession struct {
-	ID        string
-	Command   string
-	Args      []string
-	Process   *exec.Cmd
-	Context   context.Context
-	Cancel    context.CancelFunc
-	Output    chan string
-	Error     chan error
-	StartTime time.Time
-	mu        sync.Mutex
-	isRunning bool
+	ID         string
+	Kind       string // SessionKindAgent, SessionKindBuild, SessionKindDeploy or SessionKindPreview
+	UserID     string // Who started the session; empty for previews
+	Command    string
+	Args       []string
+	Process    *exec.Cmd
+	WorkDir    string
+	Env        ChildEnv
+	PTY        bool   // Run under a pseudo-terminal
+	Rows       uint16 // Initial terminal size (PTY mode)
+	Cols       uint16
+	Timeout    time.Duration
+	Sandbox    string         // Isolation of the run (agentSandbox setting); empty for builds and deployments
+	Limits     *ProcessLimits // Resource limits of the process; nil for the PROCESS_* defaults
+	Context    context.Context
+	Cancel     context.CancelFunc
+	output     *outputBuffer      // Everything the process printed, for late and repeated reads
+	broadcast  *outputBroadcaster // Wakes SSE subscribers when output is buffered
+	StartTime  time.Time
+	EndTime    time.Time
+	mu         sync.Mutex
+	isRunning  bool
+	stdin      stdinWriter
+	ptmx       *os.File                          // Terminal master while a PTY session runs
+	exitErr    error                             // Why the process failed; nil after a clean exit
+	limiter    *processLimiter                   // Enforces Limits on the running process
+	onExit     func(err error)                   // Called once the process has exited and the output is complete
+	run        func(session *AgentSession) error // Runs instead of Command when set
+	db         *gorm.DB                          // Stores the session record
+	adoptedPID int                               // Process group re-adopted after a restart; 0 for processes started here
+}
+
+// outputBroadcaster fans out "new output" signals to every SSE subscriber of a session.
+// Subscribers read the lines from the session buffer at their own position, so a slow
+// client neither blocks the process nor takes lines away from the other clients.
+type outputBroadcaster struct {
+	mu     sync.Mutex
+	subs   map[chan struct{}]struct{}
+	closed bool
+}
+
+func newOutputBroadcaster() *outputBroadcaster {
+	return &outputBroadcaster{subs: make(map[chan struct{}]struct{})}
+}
+
+// subscribe returns a channel that receives a signal after new output and is
+// closed when the process exits
+func (b *outputBroadcaster) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subs[ch] = struct{}{}
+	}
+	return ch
+}
+
+func (b *outputBroadcaster) unsubscribe(ch chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, ch)
+}
+
+// notify wakes every subscriber; pending signals are coalesced
+func (b *outputBroadcaster) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close tells every subscriber that no more output will follow
+func (b *outputBroadcaster) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = nil
 }
 
 // Global session manager
@@ -36,94 +120,233 @@ var (
 
 // AgentRunRequest represents the request to start an AI agent
 type AgentRunRequest struct {
-	Command string   `json:"command"` // The CLI command to run
-	Args    []string `json:"args"`    // Command arguments
+	Command   string   `json:"command" validate:"required,max=256,singleline"`     // The CLI command to run
+	Args      []string `json:"args" validate:"max=64,dive,max=8192"`               // Command arguments
+	ProjectID string   `json:"projectId,omitempty" validate:"omitempty,projectid"` // Run in the project's workspace
+	PTY       bool     `json:"pty,omitempty"`                                      // Run under a pseudo-terminal and stream raw terminal output
+	Rows      uint16   `json:"rows,omitempty" validate:"max=1000"`                 // Initial terminal size in PTY mode (default 24x80)
+	Cols      uint16   `json:"cols,omitempty" validate:"max=1000"`
+
+	Cwd            string            `json:"cwd,omitempty" validate:"max=4096,singleline"`                       // Working directory, relative to the workspace or absolute inside AGENT_SANDBOX_ROOT
+	Env            map[string]string `json:"env,omitempty" validate:"max=64,dive,keys,max=128,endkeys,max=8192"` // Extra variables, limited to AGENT_ENV_ALLOWLIST
+	TimeoutSeconds int               `json:"timeoutSeconds,omitempty" validate:"min=0"`                          // Kill the process after this long (default AGENT_TIMEOUT)
+}
+
+// AgentInputRequest represents text sent to the stdin of a running agent
+type AgentInputRequest struct {
+	Input string `json:"input"`         // Text to send (a newline is appended)
+	EOF   bool   `json:"eof,omitempty"` // Close stdin after sending
 }
 
 // RunAgent starts a new AI agent process
-func RunAgent() fiber.Handler {
+func RunAgent(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var req AgentRunRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(400).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		req := validatedBody[AgentRunRequest](c)
+
+		var workDir string
+		if req.ProjectID != "" {
+			dir, err := resolveWorkspaceDir(db, req.ProjectID)
+			if err != nil {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", nil)
+			}
+			workDir = dir
+		}
+		workspaceDir := workDir
+		if workspaceDir == "" {
+			workspaceDir = getWorkspaceDir()
 		}
 
-		if req.Command == "" {
-			return c.Status(400).JSON(fiber.Map{
-				"error": "Command is required",
-			})
+		isolation, err := resolveAgentIsolation(db, req.ProjectID)
+		if err != nil {
+			return sendError(c, 400, "INVALID_SANDBOX", "Invalid agent sandbox: "+err.Error(), nil)
 		}
 
-		// Create session ID
-		sessionID := uuid.New().String()
+		// Sandboxed runs start in the caller's own directory and may not leave it
+		root, base := getAgentSandboxRoot(workspaceDir), workspaceDir
+		var userDir string
+		if isolation.Mode != IsolationNone {
+			userDir, err = agentUserDir(req.ProjectID, currentUserID(c))
+			if err != nil {
+				return sendError(c, 500, "WORKSPACE_ERROR", err.Error(), nil)
+			}
+			root, base, workDir = userDir, userDir, userDir
+		}
+		if req.Cwd != "" {
+			dir, err := resolveAgentCwd(root, base, req.Cwd)
+			if err != nil {
+				return sendError(c, 400, "INVALID_PATH", "Invalid cwd: "+err.Error(), nil)
+			}
+			workDir = dir
+		}
 
-		// Create context with cancel
-		ctx, cancel := context.WithCancel(context.Background())
+		if err := validateAgentEnv(req.Env); err != nil {
+			return sendError(c, 400, "INVALID_ENV", "Invalid env: "+err.Error(), nil)
+		}
 
-		// Create session
-		session := &AgentSession{
-			ID:        sessionID,
-			Command:   req.Command,
-			Args:      req.Args,
-			Context:   ctx,
-			Cancel:    cancel,
-			Output:    make(chan string, 100),
-			Error:     make(chan error, 10),
-			StartTime: time.Now(),
-			isRunning: true,
+		timeout, err := agentTimeout(req.TimeoutSeconds)
+		if err != nil {
+			return sendError(c, 400, "INVALID_TIMEOUT", err.Error(), nil)
 		}
 
-		// Store session
-		sessMu.Lock()
-		sessions[sessionID] = session
-		sessMu.Unlock()
+		env, err := buildChildEnv(db, req.ProjectID)
+		if err != nil {
+			return sendError(c, 500, "ENV_ERROR", "Failed to prepare environment: "+err.Error(), nil)
+		}
+		env = env.withOverrides(req.Env)
+		limits := getProcessLimits()
 
-		// Start the process in a goroutine
-		go startAgentProcess(session)
+		session := &AgentSession{
+			ID:      uuid.New().String(),
+			Kind:    SessionKindAgent,
+			UserID:  currentUserID(c),
+			Command: req.Command,
+			Args:    req.Args,
+			WorkDir: workDir,
+			Env:     env,
+			PTY:     req.PTY,
+			Rows:    req.Rows,
+			Cols:    req.Cols,
+			Timeout: timeout,
+			Sandbox: isolation.Mode,
+			Limits:  &limits,
+			db:      db,
+		}
+		if isolation.Mode != IsolationNone {
+			isolation.apply(session, userDir, workspaceDir)
+		}
+		launchAgent(session)
+		sessionID := session.ID
+		recordAudit(db, c, AuditAgentRun, sessionID, nil, append([]string{req.Command}, req.Args...),
+			strings.TrimSpace(req.Command+" "+strings.Join(req.Args, " ")))
 
 		return c.JSON(fiber.Map{
 			"session_id": sessionID,
 			"status":     "started",
 			"command":    req.Command,
 			"args":       req.Args,
+			"cwd":        workDir,
+			"sandbox":    isolation.Mode,
+			"timeout":    timeout.Seconds(),
 		})
 	}
 }
 
+// launchAgent fills in the runtime state of a session, registers it and starts the process
+func launchAgent(session *AgentSession) {
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+
+	// The deadline kills runaway processes; long-lived servers run without one
+	if session.Timeout > 0 {
+		session.Context, session.Cancel = context.WithTimeout(context.Background(), session.Timeout)
+	} else {
+		session.Context, session.Cancel = context.WithCancel(context.Background())
+	}
+	if session.Limits == nil {
+		limits := getProcessLimits()
+		session.Limits = &limits
+	}
+	session.output = newOutputBuffer(getAgentOutputBufferSize())
+	session.broadcast = newOutputBroadcaster()
+	session.StartTime = time.Now()
+	session.isRunning = true
+
+	// Store session
+	sessMu.Lock()
+	sessions[session.ID] = session
+	sessMu.Unlock()
+	session.saveRecord()
+	if cluster != nil {
+		cluster.claim(session.Kind, session.ID) // IDs are new, or adopted from this instance
+	}
+
+	// Start the process in a goroutine
+	activeRuns.Add(1)
+	go startAgentProcess(session)
+}
+
 // startAgentProcess spawns and manages the AI agent process
 func startAgentProcess(session *AgentSession) {
+	defer activeRuns.Done()
 	defer func() {
 		session.mu.Lock()
 		session.isRunning = false
+		session.EndTime = time.Now()
 		session.mu.Unlock()
-		close(session.Output)
-		close(session.Error)
+		session.broadcast.close()
+		if session.db != nil {
+			finishSessionRecord(session.db, session.ID, sessionOutcome(session, session.exitErr))
+		}
+		if cluster != nil {
+			cluster.release(session.Kind, session.ID)
+		}
+		if session.onExit != nil {
+			session.onExit(session.exitErr)
+		}
 	}()
 
+	// In-process tasks reuse the session output and lifecycle without a child process
+	if session.run != nil {
+		finishAgentProcess(session, session.run(session))
+		return
+	}
+
+	// Kill the process once it exceeds the output limit; CPU and memory are capped by its cgroup
+	session.limiter = newProcessLimiter("agent-"+session.ID, *session.Limits, session.Cancel)
+	defer session.limiter.release()
+
 	// Create command with context for cancellation
 	cmd := exec.CommandContext(session.Context, session.Command, session.Args...)
+	cmd.Dir = session.WorkDir
+	cmd.Env = session.Env.Vars
 	session.Process = cmd
 
+	// Stop the whole process group on interrupt or timeout so children holding the
+	// output open do not keep the session alive
+	killGroupOnCancel(cmd)
+
+	if session.PTY {
+		if err := session.limiter.prepare(cmd); err != nil {
+			session.startFailed(err)
+			return
+		}
+		runAgentPTY(session, cmd, session.Rows, session.Cols)
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // PTY mode gets its own session instead
+	if err := session.limiter.prepare(cmd); err != nil {
+		session.startFailed(err)
+		return
+	}
+
 	// Create pipes for stdout and stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		session.Error <- fmt.Errorf("failed to create stdout pipe: %w", err)
+		session.startFailed(fmt.Errorf("failed to create stdout pipe: %w", err))
 		return
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		session.Error <- fmt.Errorf("failed to create stderr pipe: %w", err)
+		session.startFailed(fmt.Errorf("failed to create stderr pipe: %w", err))
+		return
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		session.startFailed(fmt.Errorf("failed to create stdin pipe: %w", err))
 		return
 	}
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
-		session.Error <- fmt.Errorf("failed to start command: %w", err)
+		session.startFailed(fmt.Errorf("failed to start command: %w", err))
 		return
 	}
+	session.processStarted(cmd.Process.Pid)
+	session.stdin.attach(stdin)
+	defer session.stdin.detach()
 
 	// Read stdout and stderr concurrently
 	var wg sync.WaitGroup
@@ -132,17 +355,8 @@ func startAgentProcess(session *AgentSession) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
-			select {
-			case session.Output <- line:
-			case <-session.Context.Done():
-				return
-			}
-		}
-		if err := scanner.Err(); err != nil && err != io.EOF {
-			session.Error <- fmt.Errorf("stdout error: %w", err)
+		if err := session.readOutput(stdout, ""); err != nil {
+			session.emitError(fmt.Errorf("stdout error: %w", err))
 		}
 	}()
 
@@ -150,36 +364,89 @@ func startAgentProcess(session *AgentSession) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			line := scanner.Text()
-			select {
-			case session.Output <- fmt.Sprintf("[STDERR] %s", line):
-			case <-session.Context.Done():
-				return
-			}
-		}
-		if err := scanner.Err(); err != nil && err != io.EOF {
-			session.Error <- fmt.Errorf("stderr error: %w", err)
+		if err := session.readOutput(stderr, "[STDERR] "); err != nil {
+			session.emitError(fmt.Errorf("stderr error: %w", err))
 		}
 	}()
 
-	// Wait for command to complete
-	err = cmd.Wait()
+	// Drain the pipes before waiting: Wait closes them and would drop buffered output
 	wg.Wait()
+	finishAgentProcess(session, cmd.Wait())
+}
 
+// sessionOutcome classifies how a finished session ended: succeeded, interrupted, timed_out,
+// resource_limit_exceeded or failed
+func sessionOutcome(session *AgentSession, err error) string {
+	switch {
+	case err == nil && session.adoptedPID != 0:
+		return "exited" // Not a child of this backend, so its exit status is unknown
+	case err == nil:
+		return "succeeded"
+	case session.limiter.err() != nil:
+		return StatusResourceLimit
+	case errors.Is(session.Context.Err(), context.Canceled):
+		return "interrupted"
+	case errors.Is(session.Context.Err(), context.DeadlineExceeded):
+		return "timed_out"
+	default:
+		return "failed"
+	}
+}
+
+// startFailed reports a process that could not be started
+func (s *AgentSession) startFailed(err error) {
+	s.exitErr = err
+	s.emitError(err)
+}
+
+// finishAgentProcess reports how the process exited
+func finishAgentProcess(session *AgentSession, err error) {
+	session.exitErr = err
 	if err != nil {
-		if session.Context.Err() == context.Canceled {
-			session.Output <- "[INTERRUPTED] Process was interrupted by user"
+		if limitErr := session.limiter.err(); limitErr != nil {
+			session.emitError(limitErr)
+		} else if session.Context.Err() == context.Canceled {
+			session.emit("[INTERRUPTED] Process was interrupted by user")
+		} else if session.Context.Err() == context.DeadlineExceeded {
+			session.emitError(fmt.Errorf("process exceeded timeout of %s", session.Timeout))
 		} else {
-			session.Error <- fmt.Errorf("command failed: %w", err)
+			session.emitError(fmt.Errorf("command failed: %w", err))
 		}
+	} else if session.adoptedPID != 0 {
+		session.emit("[EXITED] Process exited; its exit status is unknown because it was started before the backend restarted")
 	} else {
-		session.Output <- "[COMPLETED] Process finished successfully"
+		session.emit("[COMPLETED] Process finished successfully")
+	}
+}
+
+// saveRecord persists a session that has just been launched, so a restart can tell
+// whether its process was left behind
+func (s *AgentSession) saveRecord() {
+	if s.db == nil || s.adoptedPID != 0 {
+		return // Adopted sessions keep the record of the run that started them
+	}
+	record := &SessionRecord{
+		ID:        s.ID,
+		Kind:      s.Kind,
+		UserID:    s.UserID,
+		Command:   s.Command,
+		StartedAt: s.StartTime.Unix(),
 	}
+	if s.Timeout > 0 {
+		record.Deadline = s.StartTime.Add(s.Timeout).Unix()
+	}
+	saveSessionRecord(s.db, record)
 }
 
-// StreamAgent streams the output of a running AI agent using Server-Sent Events
+// processStarted records the process group of a session once its process runs
+func (s *AgentSession) processStarted(pid int) {
+	if s.db != nil {
+		recordSessionPID(s.db, s.ID, pid)
+	}
+}
+
+// StreamAgent streams the output of an AI agent using Server-Sent Events.
+// Any number of clients can follow the same session; each receives the full stream.
 func StreamAgent() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		sessionID := c.Params("sessionId")
@@ -188,10 +455,19 @@ func StreamAgent() fiber.Handler {
 		session, exists := sessions[sessionID]
 		sessMu.RUnlock()
 
-		if !exists {
-			return c.Status(404).JSON(fiber.Map{
-				"error": "Session not found",
-			})
+		if !exists && !runsElsewhere(SessionKindAgent, sessionID) {
+			return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", nil)
+		}
+
+		// Replay from ?from= (or the SSE Last-Event-ID on reconnect); 0 sends the whole stream
+		from, err := strconv.ParseInt(c.Query("from", "0"), 10, 64)
+		if lastID := c.Get("Last-Event-ID"); lastID != "" {
+			if id, idErr := strconv.ParseInt(lastID, 10, 64); idErr == nil {
+				from, err = id+1, nil
+			}
+		}
+		if err != nil || from < 0 {
+			return sendError(c, 400, "INVALID_RANGE", "from must be a non-negative integer", nil)
 		}
 
 		// Set headers for SSE
@@ -200,40 +476,70 @@ func StreamAgent() fiber.Handler {
 		c.Set("Connection", "keep-alive")
 		c.Set("Transfer-Encoding", "chunked")
 
+		// The request context is recycled once the handler returns, so grab the channel now
+		serverDone := c.Context().Done()
+
+		if !exists {
+			c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+				followAgent(w, sessionID, from, serverDone)
+			})
+			return nil
+		}
+
 		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			wake := session.broadcast.subscribe()
+			defer session.broadcast.unsubscribe(wake)
+
 			// Send initial connection message
 			fmt.Fprintf(w, "data: {\"type\":\"connected\",\"session_id\":\"%s\"}\n\n", sessionID)
 			w.Flush()
 
+			// writePending sends every buffered line after the cursor
+			cursor := from
+			writePending := func() error {
+				for {
+					lines, _, _ := session.output.since(cursor, maxAgentOutputPage)
+					if len(lines) == 0 {
+						return w.Flush()
+					}
+					for _, line := range lines {
+						writeAgentEvent(w, line)
+						cursor = line.Seq + 1
+					}
+					if err := w.Flush(); err != nil {
+						return err
+					}
+				}
+			}
+
 			// Create ticker for keep-alive
 			ticker := time.NewTicker(15 * time.Second)
 			defer ticker.Stop()
 
 			for {
+				// A failed flush means the client disconnected
+				if err := writePending(); err != nil {
+					return
+				}
+
 				select {
-				case line, ok := <-session.Output:
+				case _, ok := <-wake:
 					if !ok {
-						// Channel closed, send completion and exit
+						// Process exited: send what is left, then completion
+						if err := writePending(); err != nil {
+							return
+						}
 						fmt.Fprintf(w, "data: {\"type\":\"closed\"}\n\n")
 						w.Flush()
 						return
 					}
-					// Send output line
-					fmt.Fprintf(w, "data: {\"type\":\"output\",\"data\":%q}\n\n", line)
-					w.Flush()
-
-				case err := <-session.Error:
-					// Send error
-					fmt.Fprintf(w, "data: {\"type\":\"error\",\"error\":%q}\n\n", err.Error())
-					w.Flush()
 
 				case <-ticker.C:
 					// Send keep-alive ping
 					fmt.Fprintf(w, ": keep-alive\n\n")
-					w.Flush()
 
-				case <-c.Context().Done():
-					// Client disconnected
+				case <-serverDone:
+					// Server shutting down
 					return
 				}
 			}
@@ -243,8 +549,22 @@ func StreamAgent() fiber.Handler {
 	}
 }
 
-// InterruptAgent stops a running AI agent process
-func InterruptAgent() fiber.Handler {
+// writeAgentEvent sends an output line as an SSE event
+func writeAgentEvent(w *bufio.Writer, line AgentOutputLine) {
+	// JSON-encode the text: terminal output contains escape sequences
+	data, _ := json.Marshal(line.Data)
+	if line.Type == "error" {
+		fmt.Fprintf(w, "id: %d\ndata: {\"type\":\"error\",\"error\":%s}\n\n", line.Seq, data)
+	} else if line.Continued {
+		fmt.Fprintf(w, "id: %d\ndata: {\"type\":%q,\"data\":%s,\"continued\":true}\n\n", line.Seq, line.Type, data)
+	} else {
+		fmt.Fprintf(w, "id: %d\ndata: {\"type\":%q,\"data\":%s}\n\n", line.Seq, line.Type, data)
+	}
+}
+
+// InterruptAgent stops a running AI agent process. Only the user who started the
+// session and admins may stop it.
+func InterruptAgent(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		sessionID := c.Params("sessionId")
 
@@ -252,10 +572,14 @@ func InterruptAgent() fiber.Handler {
 		session, exists := sessions[sessionID]
 		sessMu.RUnlock()
 
+		if !exists && runsElsewhere(SessionKindAgent, sessionID) {
+			return interruptRemoteAgent(c, db, sessionID)
+		}
 		if !exists {
-			return c.Status(404).JSON(fiber.Map{
-				"error": "Session not found",
-			})
+			return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", nil)
+		}
+		if !ownsOrAdmin(c, session.UserID) {
+			return sendError(c, 403, "NOT_SESSION_OWNER", "Only the user who started the session or an admin can interrupt it", nil)
 		}
 
 		session.mu.Lock()
@@ -279,8 +603,44 @@ func InterruptAgent() fiber.Handler {
 	}
 }
 
+// SendAgentInput forwards text to the stdin of a running agent
+func SendAgentInput() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sessionID := c.Params("sessionId")
+
+		var req AgentInputRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", nil)
+		}
+
+		sessMu.RLock()
+		session, exists := sessions[sessionID]
+		sessMu.RUnlock()
+
+		if !exists {
+			return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", nil)
+		}
+
+		if req.Input != "" || !req.EOF {
+			if err := session.stdin.WriteLine(req.Input); err != nil {
+				return sendError(c, 409, "INPUT_UNAVAILABLE", err.Error(), nil)
+			}
+		}
+		if req.EOF {
+			if err := session.stdin.Close(); err != nil {
+				return sendError(c, 409, "INPUT_UNAVAILABLE", err.Error(), nil)
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"status":     "sent",
+			"session_id": sessionID,
+		})
+	}
+}
+
 // GetAgentStatus returns the status of an AI agent session
-func GetAgentStatus() fiber.Handler {
+func GetAgentStatus(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		sessionID := c.Params("sessionId")
 
@@ -289,48 +649,79 @@ func GetAgentStatus() fiber.Handler {
 		sessMu.RUnlock()
 
 		if !exists {
-			return c.Status(404).JSON(fiber.Map{
-				"error": "Session not found",
-			})
+			return recordedAgentStatus(c, db, sessionID)
 		}
 
 		session.mu.Lock()
 		isRunning := session.isRunning
 		session.mu.Unlock()
 
-		return c.JSON(fiber.Map{
+		status := fiber.Map{
 			"session_id": session.ID,
 			"command":    session.Command,
 			"args":       session.Args,
 			"is_running": isRunning,
+			"pty":        session.PTY,
+			"cwd":        session.WorkDir,
+			"sandbox":    session.Sandbox,
+			"timeout":    session.Timeout.Seconds(),
 			"start_time": session.StartTime,
 			"uptime":     time.Since(session.StartTime).Seconds(),
-		})
+		}
+		if !isRunning {
+			status["outcome"] = sessionOutcome(session, session.exitErr)
+		}
+		return c.JSON(status)
 	}
 }
 
-// CleanupSessions removes old completed sessions
-func CleanupSessions() fiber.Handler {
+// recordedAgentStatus answers for a session that is not in memory, e.g. one that was
+// running when the backend restarted or one that runs on another instance, from its
+// session record
+func recordedAgentStatus(c *fiber.Ctx, db *gorm.DB, sessionID string) error {
+	var record SessionRecord
+	if err := db.Limit(1).Find(&record, "id = ? AND kind <> ?", sessionID, SessionKindCommand).Error; err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to load session", err.Error())
+	}
+	if record.ID == "" {
+		return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", nil)
+	}
+
+	running := runsElsewhere(record.Kind, record.ID)
+	status := fiber.Map{
+		"session_id": record.ID,
+		"command":    record.Command,
+		"is_running": running,
+		"start_time": time.Unix(record.StartedAt, 0),
+	}
+	if running {
+		status["instance"] = record.Instance
+	} else {
+		status["outcome"] = record.Status
+	}
+	if record.EndedAt > 0 {
+		status["end_time"] = time.Unix(record.EndedAt, 0)
+	}
+	if record.Error != "" {
+		status["error"] = record.Error
+	}
+	return c.JSON(status)
+}
+
+// CleanupSessions runs a cleanup pass immediately (the scheduler also runs it periodically)
+func CleanupSessions(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		sessMu.Lock()
-		defer sessMu.Unlock()
-
-		cleaned := 0
-		for id, session := range sessions {
-			session.mu.Lock()
-			isRunning := session.isRunning
-			session.mu.Unlock()
-
-			// Remove sessions that have been completed for more than 1 hour
-			if !isRunning && time.Since(session.StartTime) > time.Hour {
-				delete(sessions, id)
-				cleaned++
-			}
-		}
+		settings := getCleanupSettings()
+		before := cleanupStatsSnapshot()
+		after := runCleanup(db, settings)
+
+		sessMu.RLock()
+		active := len(sessions)
+		sessMu.RUnlock()
 
 		return c.JSON(fiber.Map{
-			"cleaned": cleaned,
-			"active":  len(sessions),
+			"cleaned": after.AgentSessionsPruned - before.AgentSessionsPruned,
+			"active":  active,
 		})
 	}
 }
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Agent isolation modes, set per project with the "agentSandbox" setting or for
+// every agent run with AGENT_ISOLATION
+const (
+	IsolationNone      = "none"      // Run in the workspace with the backend's permissions
+	IsolationDirectory = "directory" // Run in a per-user directory; the process can still read the rest of the host
+	IsolationNsjail    = "nsjail"    // Run in the per-user directory inside nsjail, with read-only system paths
+	IsolationDocker    = "docker"    // Run in the per-user directory inside a throwaway container
+)
+
+// Paths of the per-user directory and the project workspace inside nsjail and docker sandboxes
+const (
+	sandboxMountPath   = "/sandbox"
+	sandboxSiteMount   = "/site"
+	defaultSandboxPath = "/usr,/bin,/sbin,/lib,/lib32,/lib64,/etc"
+)
+
+// isolationStrength orders the modes, so a project can only ask for more isolation than
+// AGENT_ISOLATION
+var isolationStrength = map[string]int{
+	IsolationNone: 0, IsolationDirectory: 1, IsolationNsjail: 2, IsolationDocker: 2,
+}
+
+// hostEnvKeys name host-specific variables that are not passed into containers
+var hostEnvKeys = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "LOGNAME": true, "SHELL": true, "TMPDIR": true,
+}
+
+// sandboxNameUnsafe matches characters that may not appear in a sandbox directory name
+var sandboxNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// AgentIsolation is the "agentSandbox" project setting
+type AgentIsolation struct {
+	Mode      string `json:"mode"`                // none, directory, nsjail or docker
+	Image     string `json:"image,omitempty"`     // docker: image to run (default AGENT_ISOLATION_IMAGE)
+	Network   *bool  `json:"network,omitempty"`   // nsjail, docker: allow network access (default AGENT_ISOLATION_NETWORK)
+	Workspace string `json:"workspace,omitempty"` // nsjail, docker: mount the project workspace at /site, "ro" or "rw"
+}
+
+// getAgentIsolation returns the isolation of agent runs (isolation.mode, AGENT_ISOLATION)
+// Falls back to none
+func getAgentIsolation() string {
+	return appConfig.Isolation.Mode
+}
+
+// getAgentIsolationDir returns the directory holding the per-user sandboxes (isolation.dir, AGENT_ISOLATION_DIR)
+// Falls back to sandboxes (relative to the working directory)
+func getAgentIsolationDir() string {
+	return appConfig.Isolation.Dir
+}
+
+// getAgentIsolationImage returns the image of docker sandboxes (isolation.image, AGENT_ISOLATION_IMAGE)
+// Falls back to node:20
+func getAgentIsolationImage() string {
+	return appConfig.Isolation.Image
+}
+
+// getAgentIsolationImages returns the images the "agentSandbox" setting may choose besides
+// AGENT_ISOLATION_IMAGE (isolation.images, AGENT_ISOLATION_IMAGES)
+// Falls back to none
+func getAgentIsolationImages() []string {
+	return appConfig.Isolation.Images
+}
+
+// allowedSandboxImage reports whether a project may run its docker sandbox in image.
+// Values starting with - would be read as docker flags.
+func allowedSandboxImage(image string) bool {
+	if image == "" || strings.HasPrefix(image, "-") {
+		return false
+	}
+	return image == getAgentIsolationImage() || slices.Contains(getAgentIsolationImages(), image)
+}
+
+// getAgentIsolationNetwork returns whether sandboxed agents may use the network
+// (isolation.network, AGENT_ISOLATION_NETWORK)
+// Falls back to true
+func getAgentIsolationNetwork() bool {
+	return appConfig.Isolation.Network
+}
+
+// getAgentIsolationPaths returns the host paths mounted read-only into nsjail sandboxes
+// (isolation.paths, AGENT_ISOLATION_PATHS)
+// Falls back to the system directories (/usr, /bin, /lib, /etc, ...)
+func getAgentIsolationPaths() []string {
+	return appConfig.Isolation.Paths
+}
+
+// validate checks the isolation settings
+func (i IsolationConfig) validate() []error {
+	var errs []error
+	if _, ok := isolationStrength[i.Mode]; !ok {
+		errs = append(errs, fmt.Errorf("isolation.mode must be none, directory, nsjail or docker, got %q", i.Mode))
+	}
+	if i.Dir == "" {
+		errs = append(errs, fmt.Errorf("isolation.dir must not be empty"))
+	}
+	for _, image := range append([]string{i.Image}, i.Images...) {
+		if image == "" || strings.HasPrefix(image, "-") {
+			errs = append(errs, fmt.Errorf("isolation: invalid image %q", image))
+		}
+	}
+	for _, p := range i.Paths {
+		if !filepath.IsAbs(p) {
+			errs = append(errs, fmt.Errorf("isolation.paths: %q is not an absolute path", p))
+		}
+	}
+	return errs
+}
+
+// resolveAgentIsolation returns the project's "agentSandbox" setting, or AGENT_ISOLATION
+// when the project has none. A project can't choose less isolation than AGENT_ISOLATION,
+// network access that AGENT_ISOLATION_NETWORK denies, or an image that isn't allowed.
+func resolveAgentIsolation(db *gorm.DB, projectID string) (*AgentIsolation, error) {
+	isolation := &AgentIsolation{Mode: getAgentIsolation()}
+	if projectID != "" {
+		var project Project
+		if err := db.First(&project, "id = ?", projectID).Error; err != nil {
+			return nil, err
+		}
+		if raw, ok := project.Settings["agentSandbox"]; ok {
+			data, err := json.Marshal(raw)
+			if err != nil {
+				return nil, err
+			}
+			isolation = &AgentIsolation{}
+			if err := json.Unmarshal(data, isolation); err != nil {
+				return nil, fmt.Errorf("invalid agentSandbox setting: %w", err)
+			}
+		}
+	}
+
+	switch isolation.Mode {
+	case "":
+		isolation.Mode = IsolationNone
+	case IsolationNone, IsolationDirectory, IsolationNsjail, IsolationDocker:
+	default:
+		return nil, fmt.Errorf("unknown agent sandbox mode %q (use none, directory, nsjail or docker)", isolation.Mode)
+	}
+	if isolation.Workspace != "" && isolation.Workspace != "ro" && isolation.Workspace != "rw" {
+		return nil, fmt.Errorf("agentSandbox workspace must be ro or rw")
+	}
+	if global := getAgentIsolation(); isolationStrength[isolation.Mode] < isolationStrength[global] {
+		log.Printf("⚠️ Project %s asks for agent sandbox %s, using AGENT_ISOLATION=%s", projectID, isolation.Mode, global)
+		isolation.Mode = global
+	}
+	if network := getAgentIsolationNetwork(); isolation.Network == nil || !network {
+		isolation.Network = &network
+	}
+	if isolation.Image == "" {
+		isolation.Image = getAgentIsolationImage()
+	} else if !allowedSandboxImage(isolation.Image) {
+		return nil, fmt.Errorf("agentSandbox image %q is not allowed (use AGENT_ISOLATION_IMAGE or one of AGENT_ISOLATION_IMAGES)", isolation.Image)
+	}
+	return isolation, nil
+}
+
+// agentUserDir returns (and creates) the sandbox directory of a user in a project
+func agentUserDir(projectID, userID string) (string, error) {
+	if projectID == "" {
+		projectID = "_default"
+	}
+	if userID == "" {
+		userID = "anonymous"
+	}
+	dir, err := filepath.Abs(filepath.Join(getAgentIsolationDir(),
+		sandboxNameUnsafe.ReplaceAllString(projectID, "_"),
+		sandboxNameUnsafe.ReplaceAllString(userID, "_")))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create sandbox directory: %w", err)
+	}
+	return dir, nil
+}
+
+// sandboxPath maps a directory below the user's sandbox to its path inside the sandbox
+func sandboxPath(userDir, dir string) string {
+	rel, err := filepath.Rel(userDir, dir)
+	if err != nil || rel == "." {
+		return sandboxMountPath
+	}
+	return path.Join(sandboxMountPath, filepath.ToSlash(rel))
+}
+
+// apply rewrites a session so its command runs inside the user's sandbox.
+// session.WorkDir must already be resolved inside userDir.
+func (iso *AgentIsolation) apply(session *AgentSession, userDir, workspaceDir string) {
+	switch iso.Mode {
+	case IsolationDirectory:
+		session.Env = session.Env.withOverrides(map[string]string{"HOME": userDir})
+
+	case IsolationNsjail:
+		args := []string{
+			"--mode", "o", "--quiet", "--time_limit", "0",
+			"--rlimit_as", "soft", "--rlimit_cpu", "soft", "--rlimit_fsize", "soft", "--rlimit_nofile", "soft",
+			"--bindmount", userDir + ":" + sandboxMountPath,
+			"--cwd", sandboxPath(userDir, session.WorkDir),
+			"--tmpfsmount", "/tmp",
+		}
+		for _, p := range getAgentIsolationPaths() {
+			if _, err := os.Stat(p); err == nil {
+				args = append(args, "--bindmount_ro", p)
+			}
+		}
+		if workspaceDir != "" && iso.Workspace != "" {
+			mount := "--bindmount_ro"
+			if iso.Workspace == "rw" {
+				mount = "--bindmount"
+			}
+			args = append(args, mount, workspaceDir+":"+sandboxSiteMount)
+		}
+		if *iso.Network {
+			args = append(args, "--disable_clone_newnet")
+		}
+
+		// nsjail starts from an empty environment; --env KEY copies a variable without
+		// putting its value on the command line
+		session.Env = session.Env.withOverrides(map[string]string{"HOME": sandboxMountPath, "TMPDIR": "/tmp"})
+		for _, kv := range session.Env.Vars {
+			key, _, _ := strings.Cut(kv, "=")
+			args = append(args, "--env", key)
+		}
+		session.Args = append(append(args, "--", session.Command), session.Args...)
+		session.Command = "nsjail"
+		session.WorkDir = userDir
+
+	case IsolationDocker:
+		name := "agent-" + session.ID
+		args := []string{
+			"run", "--rm", "-i", "--init", "--name", name,
+			"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+			"-v", userDir + ":" + sandboxMountPath,
+			"-w", sandboxPath(userDir, session.WorkDir),
+			"-e", "HOME=" + sandboxMountPath,
+		}
+		if session.PTY {
+			args = append(args, "-t")
+		}
+		if workspaceDir != "" && iso.Workspace != "" {
+			args = append(args, "-v", workspaceDir+":"+sandboxSiteMount+":"+iso.Workspace)
+		}
+		if !*iso.Network {
+			args = append(args, "--network", "none")
+		}
+		// The container has its own cgroup, so the CPU and memory limits become docker flags
+		if session.Limits != nil {
+			args = append(args, dockerLimitArgs(*session.Limits)...)
+			limits := ProcessLimits{Output: session.Limits.Output}
+			session.Limits = &limits
+		}
+		// -e KEY takes the value from the docker client, so secrets stay off the command line
+		for _, kv := range session.Env.Vars {
+			if key, _, _ := strings.Cut(kv, "="); !hostEnvKeys[key] {
+				args = append(args, "-e", key)
+			}
+		}
+		session.Args = append(append(args, iso.Image, session.Command), session.Args...)
+		session.Command = "docker"
+		session.WorkDir = userDir
+
+		// Killing the docker client leaves the container running, so remove it explicitly
+		onExit := session.onExit
+		session.onExit = func(err error) {
+			removeDockerContainer(name)
+			if onExit != nil {
+				onExit(err)
+			}
+		}
+	}
+}
+
+// stopDockerContainer sends SIGTERM to a container and SIGKILL once the grace period is
+// over; the container exits with its init process, taking every process in it along
+func stopDockerContainer(name string, grace time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace+30*time.Second)
+	defer cancel()
+	seconds := strconv.Itoa(int(math.Ceil(grace.Seconds())))
+	if out, err := exec.CommandContext(ctx, "docker", "stop", "--time", seconds, name).CombinedOutput(); err != nil &&
+		!strings.Contains(string(out), "No such container") {
+		log.Printf("⚠️ Failed to stop container %s: %v", name, err)
+	}
+}
+
+// removeDockerContainer force-removes a container; it is usually gone already
+func removeDockerContainer(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, "docker", "rm", "-f", name).CombinedOutput(); err != nil &&
+		!strings.Contains(string(out), "No such container") {
+		log.Printf("⚠️ Failed to remove container %s: %v", name, err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AgentOutputLine is one buffered line of agent output
+type AgentOutputLine struct {
+	Seq       int64     `json:"seq"`
+	Type      string    `json:"type"` // output, terminal (raw PTY chunk), warning or error
+	Data      string    `json:"data"`
+	Continued bool      `json:"continued,omitempty"` // The line goes on in the next output line
+	Time      time.Time `json:"time"`
+}
+
+// outputBuffer keeps the last lines of an agent session so clients can attach late.
+// Sequence numbers keep increasing when old lines are evicted.
+type outputBuffer struct {
+	mu    sync.Mutex
+	lines []AgentOutputLine
+	start int   // Index of the oldest line in lines
+	count int   // Number of buffered lines
+	next  int64 // Sequence number of the next line
+}
+
+// getAgentOutputBufferSize returns the number of lines kept per session from AGENT_OUTPUT_BUFFER
+// Falls back to 10000
+func getAgentOutputBufferSize() int {
+	if n, err := strconv.Atoi(getEnvDefault("AGENT_OUTPUT_BUFFER", "10000")); err == nil && n > 0 {
+		return n
+	}
+	return 10000
+}
+
+func newOutputBuffer(size int) *outputBuffer {
+	return &outputBuffer{lines: make([]AgentOutputLine, size)}
+}
+
+// append stores a line, evicting the oldest one when the buffer is full
+func (b *outputBuffer) append(line AgentOutputLine) AgentOutputLine {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	line.Seq, line.Time = b.next, time.Now()
+	b.next++
+
+	if b.count < len(b.lines) {
+		b.lines[(b.start+b.count)%len(b.lines)] = line
+		b.count++
+	} else {
+		b.lines[b.start] = line
+		b.start = (b.start + 1) % len(b.lines)
+	}
+	return line
+}
+
+// since returns up to limit lines with a sequence number of at least from, plus the
+// sequence numbers of the oldest buffered line and of the next line to be written
+func (b *outputBuffer) since(from int64, limit int) ([]AgentOutputLine, int64, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	oldest := b.next - int64(b.count)
+	if from < oldest {
+		from = oldest
+	}
+
+	var out []AgentOutputLine
+	for seq := from; seq < b.next && len(out) < limit; seq++ {
+		out = append(out, b.lines[(b.start+int(seq-oldest))%len(b.lines)])
+	}
+	return out, oldest, b.next
+}
+
+// text joins the buffered lines into a plain-text log
+func (b *outputBuffer) text() string {
+	lines, _, _ := b.since(0, len(b.lines))
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line.Data)
+		if line.Type != "terminal" && !line.Continued {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// emit records an output line and wakes the SSE subscribers
+func (s *AgentSession) emit(line string) {
+	s.appendOutput(AgentOutputLine{Type: "output", Data: line})
+}
+
+// emitPart records a part of an output line too long to send at once
+func (s *AgentSession) emitPart(part string, continued bool) {
+	s.appendOutput(AgentOutputLine{Type: "output", Data: part, Continued: continued})
+}
+
+// emitTerminal records a chunk of raw terminal output (PTY mode) and wakes the SSE subscribers
+func (s *AgentSession) emitTerminal(chunk string) {
+	s.appendOutput(AgentOutputLine{Type: "terminal", Data: chunk})
+}
+
+// emitWarning records a warning line and wakes the SSE subscribers
+func (s *AgentSession) emitWarning(message string) {
+	s.appendOutput(AgentOutputLine{Type: "warning", Data: message})
+}
+
+// emitError records an error line and wakes the SSE subscribers
+func (s *AgentSession) emitError(err error) {
+	s.appendOutput(AgentOutputLine{Type: "error", Data: err.Error()})
+}
+
+// readOutput records the lines of a process stream until it ends. Lines longer than
+// OUTPUT_MAX_LINE_SIZE are recorded in parts after a warning.
+func (s *AgentSession) readOutput(r io.Reader, prefix string) error {
+	maxLine := getMaxLineSize()
+	err := readLines(r, maxLine, func(line string, chunk int, more bool) bool {
+		if chunk == 0 {
+			line = prefix + line
+		}
+		if !s.limiter.allowOutput(len(line) + 1) {
+			return true
+		}
+		if chunk == 0 && more {
+			s.emitWarning(fmt.Sprintf("Output line longer than %d bytes, sending it in parts", maxLine))
+		}
+		if chunk == 0 && !more {
+			s.emit(line)
+		} else {
+			s.emitPart(line, more)
+		}
+		return true
+	})
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// appendOutput buffers a line, wakes the SSE subscribers and, in Redis mode, sends it
+// to the followers on other instances
+func (s *AgentSession) appendOutput(line AgentOutputLine) {
+	line = s.output.append(line)
+	s.broadcast.notify()
+	if cluster != nil {
+		cluster.publishOutput(s.ID, line)
+	}
+}
+
+// maxAgentOutputPage caps the number of lines returned per request
+const maxAgentOutputPage = 1000
+
+// GetAgentOutput returns buffered output of a session from ?from= on, so clients
+// can attach after the process started or catch up after a reconnect
+func GetAgentOutput() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sessionID := c.Params("sessionId")
+
+		sessMu.RLock()
+		session, exists := sessions[sessionID]
+		sessMu.RUnlock()
+
+		if !exists && !runsElsewhere(SessionKindAgent, sessionID) {
+			return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", nil)
+		}
+
+		from, err := strconv.ParseInt(c.Query("from", "0"), 10, 64)
+		if err != nil || from < 0 {
+			return sendError(c, 400, "INVALID_RANGE", "from must be a non-negative integer", nil)
+		}
+		limit := c.QueryInt("limit", maxAgentOutputPage)
+		if limit <= 0 || limit > maxAgentOutputPage {
+			limit = maxAgentOutputPage
+		}
+
+		if !exists {
+			// Runs on another instance, which keeps its output in Redis
+			lines, err := cluster.agentOutput(sessionID, from, limit)
+			if err != nil {
+				return sendError(c, 503, "STREAM_UNAVAILABLE", "Failed to read the session output", err.Error())
+			}
+			next := from
+			if len(lines) > 0 {
+				next = lines[len(lines)-1].Seq + 1
+			}
+			return c.JSON(fiber.Map{
+				"session_id": sessionID,
+				"lines":      lines,
+				"next":       next,
+				"truncated":  len(lines) > 0 && lines[0].Seq > from,
+				"is_running": true,
+			})
+		}
+
+		// Read the running flag first so a finished session never reports missing lines
+		session.mu.Lock()
+		isRunning := session.isRunning
+		session.mu.Unlock()
+
+		lines, oldest, next := session.output.since(from, limit)
+		if len(lines) > 0 {
+			next = lines[len(lines)-1].Seq + 1
+		}
+		if lines == nil {
+			lines = []AgentOutputLine{}
+		}
+
+		return c.JSON(fiber.Map{
+			"session_id": session.ID,
+			"lines":      lines,
+			"next":       next,
+			"truncated":  from < oldest, // Lines before oldest were evicted from the buffer
+			"is_running": isRunning,
+		})
+	}
+}
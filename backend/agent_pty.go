@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Default terminal size when the run request does not give one
+const (
+	defaultPTYRows = 24
+	defaultPTYCols = 80
+)
+
+// AgentResizeRequest changes the terminal size of a PTY session
+type AgentResizeRequest struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// ptyInput writes user input to the terminal; closing it sends Ctrl-D
+// instead of closing the master, which would also end the output
+type ptyInput struct {
+	f *os.File
+}
+
+func (p ptyInput) Write(b []byte) (int, error) {
+	return p.f.Write(b)
+}
+
+func (p ptyInput) Close() error {
+	_, err := p.f.Write([]byte{4})
+	return err
+}
+
+// runAgentPTY runs the command under a pseudo-terminal and streams the raw terminal
+// output, escape sequences included, as it arrives
+func runAgentPTY(session *AgentSession, cmd *exec.Cmd, rows, cols uint16) {
+	if rows == 0 {
+		rows = defaultPTYRows
+	}
+	if cols == 0 {
+		cols = defaultPTYCols
+	}
+
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: rows, Cols: cols})
+	if err != nil {
+		session.startFailed(fmt.Errorf("failed to start command: %w", err))
+		return
+	}
+	defer ptmx.Close()
+	session.processStarted(cmd.Process.Pid) // Leads its own session, and so its group
+
+	session.mu.Lock()
+	session.ptmx = ptmx
+	session.mu.Unlock()
+	defer func() {
+		session.mu.Lock()
+		session.ptmx = nil
+		session.mu.Unlock()
+	}()
+
+	session.stdin.attach(ptyInput{f: ptmx})
+	defer session.stdin.detach()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := ptmx.Read(buf)
+		if n > 0 && session.limiter.allowOutput(n) {
+			session.emitTerminal(string(buf[:n]))
+		}
+		if err != nil {
+			// Linux reports EIO once the last process holding the terminal exits
+			if err != io.EOF && !errors.Is(err, syscall.EIO) {
+				session.emitError(fmt.Errorf("terminal read error: %w", err))
+			}
+			break
+		}
+	}
+
+	finishAgentProcess(session, cmd.Wait())
+}
+
+// ResizeAgent changes the window size of a PTY session (the process receives SIGWINCH)
+func ResizeAgent() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sessionID := c.Params("sessionId")
+
+		var req AgentResizeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", nil)
+		}
+		if req.Rows == 0 || req.Cols == 0 {
+			return sendError(c, 400, "INVALID_SIZE", "rows and cols are required", nil)
+		}
+
+		sessMu.RLock()
+		session, exists := sessions[sessionID]
+		sessMu.RUnlock()
+
+		if !exists {
+			return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", nil)
+		}
+
+		session.mu.Lock()
+		ptmx := session.ptmx
+		session.mu.Unlock()
+
+		if ptmx == nil {
+			return sendError(c, 409, "NOT_A_TERMINAL", "Session is not running in a terminal", nil)
+		}
+		if err := pty.Setsize(ptmx, &pty.Winsize{Rows: req.Rows, Cols: req.Cols}); err != nil {
+			return sendError(c, 500, "RESIZE_FAILED", err.Error(), nil)
+		}
+
+		return c.JSON(fiber.Map{
+			"status":     "resized",
+			"session_id": sessionID,
+			"rows":       req.Rows,
+			"cols":       req.Cols,
+		})
+	}
+}
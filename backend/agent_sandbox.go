@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultAgentEnvAllowlist lists the variables a run request may set
+const defaultAgentEnvAllowlist = "NODE_ENV,CI,DEBUG,FORCE_COLOR,NO_COLOR,TERM,COLUMNS,LINES,LANG,LC_*,TZ"
+
+// getAgentSandboxRoot returns the directory agents may run in from AGENT_SANDBOX_ROOT
+// Falls back to base (the project or global workspace)
+func getAgentSandboxRoot(base string) string {
+	return getEnvDefault("AGENT_SANDBOX_ROOT", base)
+}
+
+// resolveAgentCwd resolves a requested working directory against base and checks that it
+// is an existing directory inside root (symlinks are followed)
+func resolveAgentCwd(root, base, cwd string) (string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	dir := cwd
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("cwd does not exist: %s", cwd)
+	}
+	if !isWithinDir(root, resolved) {
+		return "", fmt.Errorf("cwd must be inside %s", root)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("cwd is not a directory: %s", cwd)
+	}
+	return resolved, nil
+}
+
+// validateAgentEnv rejects variables that are not on the allowlist (agentEnvAllowlist, AGENT_ENV_ALLOWLIST)
+func validateAgentEnv(env map[string]string) error {
+	patterns := appConfig.AgentEnvAllowlist
+
+	var rejected []string
+	for key := range env {
+		if !envKeyPattern.MatchString(key) || !envPassesThrough(key, patterns) {
+			rejected = append(rejected, key)
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return fmt.Errorf("variables not allowed: %s", strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
+// withOverrides returns the environment with the given variables set
+func (e ChildEnv) withOverrides(overrides map[string]string) ChildEnv {
+	if len(overrides) == 0 {
+		return e
+	}
+
+	vars := make([]string, 0, len(e.Vars)+len(overrides))
+	for _, kv := range e.Vars {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, replaced := overrides[key]; !replaced {
+			vars = append(vars, kv)
+		}
+	}
+	for key, value := range overrides {
+		vars = append(vars, key+"="+value)
+	}
+	sort.Strings(vars)
+	return ChildEnv{Vars: vars, Secrets: e.Secrets}
+}
+
+// agentTimeout returns the time limit of an agent run. Zero uses timeouts.agent
+// (AGENT_TIMEOUT); requests may not exceed timeouts.agentMax (AGENT_MAX_TIMEOUT).
+func agentTimeout(seconds int) (time.Duration, error) {
+	maxTimeout := appConfig.Timeouts.AgentMax.Duration()
+	if seconds < 0 {
+		return 0, fmt.Errorf("timeoutSeconds must not be negative")
+	}
+	if seconds == 0 {
+		return appConfig.Timeouts.Agent.Duration(), nil
+	}
+	timeout := time.Duration(seconds) * time.Second
+	if timeout > maxTimeout {
+		return 0, fmt.Errorf("timeoutSeconds must not exceed %d", int(maxTimeout.Seconds()))
+	}
+	return timeout, nil
+}
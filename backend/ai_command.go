@@ -1,16 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"os"
-	"os/exec"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
@@ -20,33 +19,151 @@ import (
 
 // AICommandRequest represents the request to execute an AI command
 type AICommandRequest struct {
-	Prompt  string         `json:"prompt"`
-	Scope   string         `json:"scope"` // current-page, new-page, global
-	Context CommandContext `json:"context"`
+	Prompt   string         `json:"prompt" validate:"prompt"`
+	Scope    string         `json:"scope" validate:"required,oneof=current-page new-page global component"`
+	Provider string         `json:"provider,omitempty" validate:"max=64"` // claude-cli, anthropic, openai (defaults to AI_PROVIDER)
+	Context  CommandContext `json:"context"`
+
+	// ConversationID continues a previous conversation; a new one is started when empty
+	ConversationID string `json:"conversationId,omitempty" validate:"max=64"`
+
+	// Priority and RunAt (RFC 3339) order and delay the command in the job queue (AI_QUEUE)
+	Priority string `json:"priority,omitempty" validate:"omitempty,oneof=high normal low"`
+	RunAt    string `json:"runAt,omitempty" validate:"max=64"`
 }
 
 // CommandContext provides context about the command execution environment
 type CommandContext struct {
-	Page      string `json:"page"`
-	Timestamp string `json:"timestamp"`
-	UserID    string `json:"userId,omitempty"`
-	ProjectID string `json:"projectId,omitempty"`
+	Page      string `json:"page" validate:"omitempty,pagename"`
+	Timestamp string `json:"timestamp" validate:"max=64"`
+	UserID    string `json:"userId,omitempty" validate:"max=128"` // Set from the token of the request; a value sent by the client is ignored
+	ProjectID string `json:"projectId,omitempty" validate:"omitempty,projectid"`
+
+	// Target of a component-scoped command: an editable block ID and/or a CSS selector
+	ComponentID string `json:"componentId,omitempty" validate:"omitempty,contentid"`
+	Selector    string `json:"selector,omitempty" validate:"max=512,singleline"`
+}
+
+// QueuedCommand is the data of a response that created an AI command
+type QueuedCommand struct {
+	CommandID      string   `json:"commandId"`
+	ConversationID string   `json:"conversationId,omitempty"`
+	RetryOf        string   `json:"retryOf,omitempty"`
+	Status         string   `json:"status"`             // queued or pending_approval
+	Reasons        []string `json:"reasons,omitempty"`  // Why the command waits for approval
+	Replayed       bool     `json:"replayed,omitempty"` // Answer to a repeated Idempotency-Key
+	RunAt          int64    `json:"runAt,omitempty"`    // When the job queue starts the command
+	Message        string   `json:"message"`
+	WSURL          string   `json:"wsUrl"` // Stream that runs the command
+
+	// With AUTH_ENABLED, the token in wsUrl that lets the creator open the stream
+	StreamToken          string `json:"streamToken,omitempty"`
+	StreamTokenExpiresAt int64  `json:"streamTokenExpiresAt,omitempty"`
+}
+
+// withStream sets the stream URL of the command, with a stream token for its creator
+func (queued QueuedCommand) withStream(c *fiber.Ctx, userID string) QueuedCommand {
+	var token StreamToken
+	queued.WSURL, token = streamURL(c, "command:"+queued.CommandID, userID)
+	queued.StreamToken, queued.StreamTokenExpiresAt = token.Token, token.ExpiresAt
+	return queued
+}
+
+// CommandState is the data of a response that changed the status of a command
+type CommandState struct {
+	CommandID string `json:"commandId"`
+	Status    string `json:"status"`
+}
+
+// CommandUsage is the tokens and cost a provider reported for a command
+type CommandUsage struct {
+	Model        string  `json:"model"`
+	InputTokens  int64   `json:"inputTokens"`
+	OutputTokens int64   `json:"outputTokens"`
+	CostUSD      float64 `json:"costUsd"`
+}
+
+// CommandStatus is the data of GET /api/ai/command/:commandId/status. Fields after
+// completedAt are only set when they apply to the command.
+type CommandStatus struct {
+	CommandID   string `json:"commandId"`
+	Status      string `json:"status"`
+	Prompt      string `json:"prompt"`
+	Scope       string `json:"scope"`
+	CreatedAt   int64  `json:"createdAt"`
+	CompletedAt int64  `json:"completedAt"`
+
+	Result         *CommandResult `json:"result,omitempty"`
+	Error          string         `json:"error,omitempty"`
+	CommitSHA      string         `json:"commitSha,omitempty"`
+	HasSnapshot    *bool          `json:"hasSnapshot,omitempty"`
+	RolledBackAt   int64          `json:"rolledBackAt,omitempty"`
+	UndoneAt       int64          `json:"undoneAt,omitempty"`
+	Usage          *CommandUsage  `json:"usage,omitempty"`
+	ApprovalReason string         `json:"approvalReason,omitempty"`
+	ReviewedBy     string         `json:"reviewedBy,omitempty"`
+	ReviewedAt     int64          `json:"reviewedAt,omitempty"`
+	RetryOf        string         `json:"retryOf,omitempty"`
+	Priority       string         `json:"priority,omitempty"`
+	RunAt          int64          `json:"runAt,omitempty"`
+	Attempts       int            `json:"attempts,omitempty"`    // Runs by the job queue
+	Branch         string         `json:"branch,omitempty"`      // AI_GIT_BRANCHES
+	MergeSHA       string         `json:"mergeSha,omitempty"`    // Merge commit of the branch
+	MergedAt       int64          `json:"mergedAt,omitempty"`    // Branch merged into the workspace
+	DiscardedAt    int64          `json:"discardedAt,omitempty"` // Branch deleted without merging
+	ComponentID    string         `json:"componentId,omitempty"` // component scope
+	Selector       string         `json:"selector,omitempty"`    // component scope
+	ContentID      string         `json:"contentId,omitempty"`   // translate scope
+	Page           string         `json:"page,omitempty"`        // translate scope
+	Locale         string         `json:"locale,omitempty"`      // translate scope
+	SourceLocale   string         `json:"sourceLocale,omitempty"`
 }
 
 // AICommand represents a stored command in the database
 type AICommand struct {
-	ID            string `gorm:"primaryKey"`
-	Prompt        string `gorm:"type:text"`
-	Scope         string
-	Page          string
-	UserID        string
-	ProjectID     string
-	Status        string // queued, processing, completed, failed, interrupted
-	Result        string `gorm:"type:text"` // JSON-encoded result
-	ErrorMessage  string `gorm:"type:text"`
-	CreatedAt     int64
-	CompletedAt   int64
-	ProcessingLog string `gorm:"type:text"` // Stream of progress updates
+	ID             string `gorm:"primaryKey"`
+	Prompt         string `gorm:"type:text;serializer:encrypted"`
+	Scope          string
+	Provider       string
+	Page           string
+	UserID         string
+	ProjectID      string
+	Status         string // pending_approval, queued, processing, completed, failed, interrupted, timed_out, resource_limit_exceeded, rejected
+	Result         string `gorm:"type:text;serializer:encrypted"` // JSON-encoded result
+	ErrorMessage   string `gorm:"type:text"`
+	CreatedAt      int64
+	CompletedAt    int64
+	ProcessingLog  string `gorm:"type:text"` // Unused; progress updates are stored as CommandLogEntry rows
+	CommitSHA      string // Workspace commit created for this command (AI_GIT_AUTOCOMMIT)
+	ConversationID string `gorm:"index"`
+	ComponentID    string // Targeted block for the component scope
+	Selector       string // CSS selector of the targeted block
+	ApprovalReason string `gorm:"type:text"` // Why the approval policy held the command
+	ReviewedBy     string
+	ReviewNote     string `gorm:"type:text"`
+	ReviewedAt     int64
+	IdempotencyKey string `gorm:"index"` // Caller-scoped Idempotency-Key header of the submission
+	RequestHash    string // SHA-256 of the submitted body, to detect reused keys
+	SnapshotPath   string // Archive of the workspace taken before the command ran
+	RolledBackAt   int64  // When the workspace was restored from the snapshot
+	Model          string // Model reported by the provider
+	InputTokens    int64
+	OutputTokens   int64
+	CostUSD        float64 // Reported by the provider, or estimated from the token counts
+	BatchID        string  `gorm:"index"` // Batch the command belongs to, if any
+	RetryOf        string  `gorm:"index"` // Command this one was retried from
+	Locale         string  // Target locale of a translate command
+	SourceLocale   string  // Locale a translate command translates from
+	UndoneAt       int64   // When the files and blocks the command changed were restored
+	Priority       string  // high, normal or low: queue of the command in the job queue
+	RunAt          int64   // Earliest start of a command scheduled through the job queue
+	Attempts       int     // Runs by the job queue, retries included
+	Branch         string  // Branch the command ran on (AI_GIT_BRANCHES)
+	BaseSHA        string  // Workspace commit the branch started from
+	MergeSHA       string  // Merge commit of the branch in the workspace
+	MergedAt       int64   // When the branch was merged into the workspace
+	DiscardedAt    int64   // When the branch was deleted without merging it
+	RunMs          int64   // Time the CLI ran, counted against CLI minute quotas
 }
 
 // AICommandSession manages an active AI command execution
@@ -60,40 +177,67 @@ type AICommandSession struct {
 	mu            sync.RWMutex
 	isProcessing  bool
 	progressQueue chan ProgressUpdate
+	db            *gorm.DB          // Stores progress updates in the command log
+	logSeq        int64             // Sequence number of the next log entry
+	stdin         stdinWriter       // Answers to clarifying questions from the CLI
+	permissions   permissionPrompts // Tools waiting for approval (AI_PERMISSION_MODE=prompt)
+	retry         bool              // The job queue runs the command again if it fails
+	publishMu     sync.Mutex        // Orders log entries and queued updates
+	dropped       int               // Output lines dropped from the queue since the last marker
+	droppedFrom   int64             // Log sequence numbers of the first and last dropped lines
+	droppedTo     int64
 }
 
 // ProgressUpdate represents a real-time progress update
 type ProgressUpdate struct {
-	Type      string      `json:"type"` // status, thinking, output, tool_use, result, error, complete
+	Type      string      `json:"type"` // status, thinking, output, tool_use, tool_result, result, error, complete
 	Timestamp string      `json:"timestamp"`
 	Data      interface{} `json:"data,omitempty"`
 	Message   string      `json:"message,omitempty"`
+	Continued bool        `json:"continued,omitempty"` // The output line goes on in the next output message
 }
 
 // WebSocket message types
 const (
-	WSMsgTypeStatus   = "status"
-	WSMsgTypeThinking = "thinking"
-	WSMsgTypeOutput   = "output"
-	WSMsgTypeToolUse  = "tool_use"
-	WSMsgTypeResult   = "result"
-	WSMsgTypeError    = "error"
-	WSMsgTypeComplete = "complete"
-	WSMsgTypePing     = "ping"
+	WSMsgTypeStatus      = "status"
+	WSMsgTypeThinking    = "thinking"
+	WSMsgTypeOutput      = "output"
+	WSMsgTypeOutputBatch = "output_batch" // Output lines sent in quick succession
+	WSMsgTypeToolUse     = "tool_use"
+	WSMsgTypeToolResult  = "tool_result"
+	WSMsgTypeResult      = "result"
+	WSMsgTypeError       = "error"
+	WSMsgTypeComplete    = "complete"
+	WSMsgTypePing        = "ping"
+	WSMsgTypeInput       = "input"
+	WSMsgTypeDiff        = "diff" // Changes of a command that ran on its own branch
+	WSMsgTypeWarning     = "warning"
 )
 
-// getWorkspaceDir returns the workspace directory from environment variable
-// Falls back to /workspace/code if CLAUDE_WORKSPACE_DIR is not set
+// getWorkspaceDir returns the workspace directory (workspace, CLAUDE_WORKSPACE_DIR)
+// Falls back to /workspace/code
 func getWorkspaceDir() string {
-	if dir := os.Getenv("CLAUDE_WORKSPACE_DIR"); dir != "" {
-		return dir
+	return appConfig.Workspace
+}
+
+// getCommandTimeout returns the maximum run time of a single AI command (timeouts.command, AI_COMMAND_TIMEOUT)
+// Falls back to 10 minutes
+func getCommandTimeout() time.Duration {
+	return appConfig.Timeouts.Command.Duration()
+}
+
+// highLogText returns a prompt or result as HIGH logs show it: the text, or only its
+// length once SECRETS_KEY encrypts prompts and results at rest
+func highLogText(text string) string {
+	if _, err := getSecretsKey(); err == nil {
+		return fmt.Sprintf("[%d characters redacted]", utf8.RuneCountInString(text))
 	}
-	return "/workspace/code"
+	return text
 }
 
-// isHighLogLevel returns true if LOG_LEVEL is set to HIGH
+// isHighLogLevel returns true if the log level (logLevel, LOG_LEVEL) is HIGH
 func isHighLogLevel() bool {
-	return os.Getenv("LOG_LEVEL") == "HIGH"
+	return appConfig.LogLevel == "HIGH"
 }
 
 // Global command sessions
@@ -105,86 +249,177 @@ var (
 // ExecuteAICommand handles the POST endpoint for executing AI commands
 func ExecuteAICommand(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var req AICommandRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(400).JSON(fiber.Map{
-				"success": false,
-				"error": fiber.Map{
-					"code":    "INVALID_REQUEST",
-					"message": "Invalid request body",
-					"details": err.Error(),
-				},
-			})
+		return submitAICommand(c, db, *validatedBody[AICommandRequest](c))
+	}
+}
+
+// submitAICommand validates a command request and creates the command. It backs
+// POST /api/ai/command and the other endpoints that submit normal commands.
+func submitAICommand(c *fiber.Ctx, db *gorm.DB, req AICommandRequest) error {
+	// Validate request
+	if req.Prompt == "" {
+		return sendError(c, 400, "MISSING_PROMPT", "Prompt is required", nil)
+	}
+
+	if req.Scope != "current-page" && req.Scope != "new-page" && req.Scope != "global" && req.Scope != "component" {
+		return sendError(c, 400, "INVALID_SCOPE", "Invalid scope value provided", "Scope must be one of: current-page, new-page, global, component")
+	}
+
+	if req.Scope == "component" {
+		if err := validateComponentTarget(req.Context); err != nil {
+			return sendError(c, 400, "INVALID_COMPONENT", "Invalid component target", err.Error())
 		}
+	} else {
+		// Only component commands target a single block
+		req.Context.ComponentID = ""
+		req.Context.Selector = ""
+	}
 
-		// Validate request
-		if req.Prompt == "" {
-			return c.Status(400).JSON(fiber.Map{
-				"success": false,
-				"error": fiber.Map{
-					"code":    "MISSING_PROMPT",
-					"message": "Prompt is required",
-				},
-			})
+	if req.Provider == "" {
+		req.Provider = getDefaultProvider()
+	}
+	if _, err := getProvider(req.Provider); err != nil {
+		return sendError(c, 400, "INVALID_PROVIDER", "Invalid AI provider", err.Error())
+	}
+	if req.Priority == "" {
+		req.Priority = PriorityNormal
+	}
+	runAt, err := parseRunAt(req.RunAt)
+	if err != nil {
+		return err
+	}
+
+	// Commands run in the name of whoever signed in, never of a user the client names
+	req.Context.UserID = currentUserID(c)
+
+	// Log incoming command
+	log.Printf("📥 AI Command Received: %d-character prompt | Scope: %s | Page: %s", utf8.RuneCountInString(req.Prompt), req.Scope, req.Context.Page)
+
+	// High-level logging: log full request
+	if isHighLogLevel() {
+		logged := req
+		logged.Prompt = highLogText(req.Prompt)
+		reqJSON, _ := json.MarshalIndent(logged, "", "  ")
+		log.Printf("🔍 [HIGH LOG] Full Request Body:\n%s", string(reqJSON))
+	}
+
+	// Commands of unknown projects would run in the wrong site
+	if _, err := resolveWorkspaceDir(db, req.Context.ProjectID); err != nil {
+		status, code := 500, "DATABASE_ERROR"
+		if errors.Is(err, errProjectNotFound) {
+			status, code = 404, "PROJECT_NOT_FOUND"
 		}
+		return sendError(c, status, code, "Failed to resolve project workspace", err.Error())
+	}
 
-		if req.Scope != "current-page" && req.Scope != "new-page" && req.Scope != "global" {
-			return c.Status(400).JSON(fiber.Map{
-				"success": false,
-				"error": fiber.Map{
-					"code":    "INVALID_SCOPE",
-					"message": "Invalid scope value provided",
-					"details": "Scope must be one of: current-page, new-page, global",
-				},
-			})
+	// A retried submission returns the command created the first time
+	var idempotencyKey, bodyHash string
+	if key := c.Get("Idempotency-Key"); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			return sendError(c, 400, "INVALID_IDEMPOTENCY_KEY", fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength), nil)
 		}
+		idempotencyKey = idempotencyScope(c, key)
+		bodyHash = requestHash(c.Body())
 
-		// Log incoming command
-		log.Printf("📥 AI Command Received: \"%s\" | Scope: %s | Page: %s", req.Prompt, req.Scope, req.Context.Page)
-
-		// High-level logging: log full request
-		if isHighLogLevel() {
-			reqJSON, _ := json.MarshalIndent(req, "", "  ")
-			log.Printf("🔍 [HIGH LOG] Full Request Body:\n%s", string(reqJSON))
-		}
-
-		// Create command record
-		commandID := fmt.Sprintf("cmd_%d_%s", time.Now().Unix(), uuid.New().String()[:8])
-		command := &AICommand{
-			ID:        commandID,
-			Prompt:    req.Prompt,
-			Scope:     req.Scope,
-			Page:      req.Context.Page,
-			UserID:    req.Context.UserID,
-			ProjectID: req.Context.ProjectID,
-			Status:    "queued",
-			CreatedAt: time.Now().Unix(),
-		}
-
-		// Save to database
-		if err := db.Create(command).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{
-				"success": false,
-				"error": fiber.Map{
-					"code":    "DATABASE_ERROR",
-					"message": "Failed to create command",
-					"details": err.Error(),
-				},
-			})
+		if !claimIdempotencyKey(idempotencyKey) {
+			return sendError(c, 409, "IDEMPOTENCY_IN_PROGRESS", "A request with this Idempotency-Key is still being processed", nil)
 		}
+		defer releaseIdempotencyKey(idempotencyKey)
 
-		// Return immediate response with command ID
-		return c.JSON(fiber.Map{
-			"success": true,
-			"message": "Command queued successfully",
-			"data": fiber.Map{
-				"commandId": commandID,
-				"status":    "queued",
-				"message":   "Connect to WebSocket to receive real-time updates",
-				"wsUrl":     fmt.Sprintf("ws://localhost:9000/api/ai/command/%s/stream", commandID),
-			},
+		existing, err := findIdempotentCommand(db, idempotencyKey)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to look up Idempotency-Key", err.Error())
+		}
+		if existing != nil {
+			return idempotentReplay(c, existing, bodyHash)
+		}
+	}
+
+	// Spending limits block new commands until the month is over
+	if err := checkBudget(db, req.Context.ProjectID); err != nil {
+		return budgetErrorResponse(c, err)
+	}
+	// So do the plans of the user and of the project's team, until the day or month is over
+	if err := checkQuota(db, req.Context.UserID, req.Context.ProjectID); err != nil {
+		return quotaErrorResponse(c, err)
+	}
+
+	// Attach the command to its conversation
+	conversation, err := resolveConversation(db, req)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return sendError(c, 404, "CONVERSATION_NOT_FOUND", "Conversation not found", nil)
+	}
+	if err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to create conversation", err.Error())
+	}
+
+	// Destructive commands wait for a reviewer before they may run
+	status := "queued"
+	reasons := approvalReasons(req)
+	if len(reasons) > 0 {
+		status = "pending_approval"
+	}
+
+	// Create command record
+	commandID := fmt.Sprintf("cmd_%d_%s", time.Now().Unix(), uuid.New().String()[:8])
+	command := &AICommand{
+		ID:        commandID,
+		Prompt:    req.Prompt,
+		Scope:     req.Scope,
+		Provider:  req.Provider,
+		Page:      req.Context.Page,
+		UserID:    req.Context.UserID,
+		ProjectID: req.Context.ProjectID,
+		Status:    status,
+		CreatedAt: time.Now().Unix(),
+
+		ConversationID: conversation.ID,
+		ComponentID:    req.Context.ComponentID,
+		Selector:       req.Context.Selector,
+		ApprovalReason: strings.Join(reasons, "; "),
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    bodyHash,
+		Priority:       req.Priority,
+		RunAt:          runAt,
+	}
+
+	// Save to database
+	if err := db.Create(command).Error; err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to create command", err.Error())
+	}
+	recordAudit(db, c, AuditCommandExecute, commandID, nil, command.Prompt, fmt.Sprintf("scope %s, %s", command.Scope, status))
+
+	if status == "pending_approval" {
+		log.Printf("🛂 Command [%s] requires approval: %s", commandID, command.ApprovalReason)
+		return c.JSON(APIResponse[QueuedCommand]{
+			Success: true,
+			Message: "Command requires approval",
+			Data: QueuedCommand{
+				CommandID:      commandID,
+				ConversationID: conversation.ID,
+				Status:         status,
+				Reasons:        reasons,
+				Message:        "Connect to WebSocket to be notified once the command is approved or rejected",
+			}.withStream(c, req.Context.UserID),
 		})
 	}
+
+	if err := dispatchCommand(db, command); err != nil {
+		return queueErrorResponse(c, err)
+	}
+
+	// Return immediate response with command ID
+	return c.JSON(APIResponse[QueuedCommand]{
+		Success: true,
+		Message: "Command queued successfully",
+		Data: QueuedCommand{
+			CommandID:      commandID,
+			ConversationID: conversation.ID,
+			Status:         "queued",
+			RunAt:          command.RunAt,
+			Message:        "Connect to WebSocket to receive real-time updates",
+		}.withStream(c, req.Context.UserID),
+	})
 }
 
 // StreamAICommand handles WebSocket streaming for AI command execution
@@ -199,23 +434,41 @@ func StreamAICommand(db *gorm.DB) fiber.Handler {
 			return
 		}
 
-		// Create session
-		ctx, cancel := context.WithCancel(context.Background())
-		session := &AICommandSession{
-			ID:            commandID,
-			Command:       &command,
-			Context:       ctx,
-			Cancel:        cancel,
-			Status:        "processing",
-			StartTime:     time.Now(),
-			isProcessing:  true,
-			progressQueue: make(chan ProgressUpdate, 100),
+		// Hold the stream until a reviewer decides on commands caught by the approval policy
+		if command.Status == "pending_approval" || command.Status == "rejected" {
+			if !awaitApproval(conn, db, &command) {
+				return
+			}
 		}
 
-		// Store session
-		commandMu.Lock()
-		commandSessions[commandID] = session
-		commandMu.Unlock()
+		// With the job queue a worker runs the command, and the stream follows it there
+		if commandQueue != nil {
+			if command.Status == "queued" {
+				data := fiber.Map{"commandId": commandID, "status": "queued"}
+				if command.RunAt > 0 {
+					data["runAt"] = command.RunAt
+				}
+				sendWSMessage(conn, ProgressUpdate{
+					Type:      WSMsgTypeStatus,
+					Timestamp: time.Now().Format(time.RFC3339),
+					Message:   "Waiting for a worker",
+					Data:      data,
+				})
+			}
+			followCommand(conn, db, &command)
+			return
+		}
+
+		// Create and store the session; a command runs at most once at a time
+		session, ok := newCommandSession(db, &command)
+		if !ok && cluster != nil {
+			followCommand(conn, db, &command) // Stream the run from whichever instance has it
+			return
+		}
+		if !ok {
+			sendWSError(conn, "COMMAND_RUNNING", "Command is already running", "Use GET /api/ai/command/"+commandID+"/log to follow it")
+			return
+		}
 
 		// Send initial status
 		sendWSMessage(conn, ProgressUpdate{
@@ -229,178 +482,396 @@ func StreamAICommand(db *gorm.DB) fiber.Handler {
 		})
 
 		// Start AI processing in background
+		activeRuns.Add(1)
 		go processAICommand(session, db)
 
 		// Handle incoming messages (for interrupt/ping)
 		go handleWSMessages(conn, session)
 
 		// Stream progress updates to client
-		streamProgressUpdates(conn, session)
+		if err := streamProgressUpdates(conn, session); isSendTimeout(err) {
+			// The client stopped reading; finish the command without it
+			log.Printf("🐢 Command %s: client too slow, streaming stopped after %s", commandID, getWSSendTimeout())
+			go func() {
+				for range session.progressQueue {
+				}
+				cleanup(session)
+			}()
+			return
+		}
+
+		// Close the socket cleanly so clients can tell completion from a dropped connection
+		closeCode := websocket.CloseNormalClosure
+		if shuttingDown.Load() {
+			closeCode = websocket.CloseGoingAway
+		}
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""), time.Now().Add(time.Second))
 
 		// Cleanup
 		cleanup(session)
-	})
+	}, streamWSConfig())
 }
 
-// processAICommand executes the AI command using Claude CLI
+// newCommandSession registers a session for the command.
+// It returns false when the command is already being processed.
+func newCommandSession(db *gorm.DB, command *AICommand) (*AICommandSession, bool) {
+	commandMu.Lock()
+	defer commandMu.Unlock()
+	if _, running := commandSessions[command.ID]; running {
+		return nil, false
+	}
+	if cluster != nil && !cluster.claim(SessionKindCommand, command.ID) {
+		return nil, false // Running on another instance
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &AICommandSession{
+		ID:            command.ID,
+		Command:       command,
+		Context:       ctx,
+		Cancel:        cancel,
+		Status:        "processing",
+		StartTime:     time.Now(),
+		isProcessing:  true,
+		progressQueue: make(chan ProgressUpdate, 100),
+		db:            db,
+		logSeq:        nextCommandLogSeq(db, command.ID),
+	}
+	commandSessions[command.ID] = session
+	saveSessionRecord(db, &SessionRecord{ID: command.ID, Kind: SessionKindCommand, UserID: command.UserID})
+	return session, true
+}
+
+// runCommandHeadless processes a command without a WebSocket client, e.g. for the
+// scheduler; its progress is only kept in the command log
+func runCommandHeadless(db *gorm.DB, command *AICommand) bool {
+	session, ok := newCommandSession(db, command)
+	if !ok {
+		return false
+	}
+
+	activeRuns.Add(1)
+	go processAICommand(session, db)
+	go func() {
+		for range session.progressQueue {
+		}
+		cleanup(session)
+	}()
+	return true
+}
+
+// processAICommand executes the AI command using the selected provider
 func processAICommand(session *AICommandSession, db *gorm.DB) {
+	defer activeRuns.Done()
 	defer func() {
 		session.mu.Lock()
 		session.isProcessing = false
 		session.mu.Unlock()
 		close(session.progressQueue)
 	}()
+	defer func() {
+		// Report the final state to the project's chat channels; a command the job
+		// queue retries has not reached it yet
+		if session.Command.Status == "queued" {
+			return
+		}
+		finished := *session.Command
+		go notifyCommandFinished(db, &finished, time.Since(session.StartTime))
+		recordCommandActivity(db, &finished)
+	}()
+	defer batchCommandChanged(db, session.Command.BatchID)
 
 	command := session.Command
 
 	// Log processing start
-	log.Printf("🔄 Processing Command [%s]: \"%s\" | Scope: %s | Page: %s", command.ID, command.Prompt, command.Scope, command.Page)
+	log.Printf("🔄 Processing Command [%s]: %d-character prompt | Scope: %s | Page: %s", command.ID, utf8.RuneCountInString(command.Prompt), command.Scope, command.Page)
 
 	// Update status to processing
 	command.Status = "processing"
 	db.Save(command)
+	recordActivity(db, ActivityEvent{Type: ActivityCommand, Name: command.Scope, Action: "started", Target: command.Page, CommandID: command.ID, ProjectID: command.ProjectID})
+
+	// Resolve the AI backend for this command
+	provider, err := getProvider(command.Provider)
+	if err != nil {
+		handleCommandError(session, command, db, err)
+		return
+	}
 
 	// Send status update
-	session.progressQueue <- ProgressUpdate{
+	session.publish(ProgressUpdate{
 		Type:      WSMsgTypeStatus,
 		Timestamp: time.Now().Format(time.RFC3339),
-		Message:   "Starting Claude CLI...",
-	}
+		Message:   fmt.Sprintf("Starting %s...", provider.Name()),
+	})
 
 	// Build the prompt for Claude
 	prompt := buildClaudePrompt(command)
-	workspaceDir := getWorkspaceDir()
-	log.Printf("🤖 Calling Claude CLI with prompt: %s | Workspace: %s", prompt, workspaceDir)
-
-	// Create command with context for cancellation
-	cmd := exec.CommandContext(session.Context, "claude", prompt)
-	cmd.Dir = workspaceDir // Set working directory from environment variable
-
-	// High-level logging: log full Claude command details
-	if isHighLogLevel() {
-		log.Printf("🔍 [HIGH LOG] ================================")
-		log.Printf("🔍 [HIGH LOG] CLAUDE CLI COMMAND DETAILS")
-		log.Printf("🔍 [HIGH LOG] ================================")
-		log.Printf("🔍 [HIGH LOG] Command ID: %s", command.ID)
-		log.Printf("🔍 [HIGH LOG] Executable: claude")
-		log.Printf("🔍 [HIGH LOG] Arguments: [%s]", prompt)
-		log.Printf("🔍 [HIGH LOG] Working Directory: %s", workspaceDir)
-		log.Printf("🔍 [HIGH LOG] Full Command: claude %s", prompt)
-		log.Printf("🔍 [HIGH LOG] Original Prompt: %s", command.Prompt)
-		log.Printf("🔍 [HIGH LOG] Scope: %s", command.Scope)
-		log.Printf("🔍 [HIGH LOG] Page: %s", command.Page)
-		log.Printf("🔍 [HIGH LOG] Environment Variables:")
-		for _, env := range os.Environ() {
-			log.Printf("🔍 [HIGH LOG]   %s", env)
+	translate := command.Scope == ScopeTranslate
+	var sources []Content
+	if translate {
+		if sources, prompt, err = prepareTranslation(db, command); err != nil {
+			handleCommandError(session, command, db, err)
+			return
 		}
-		log.Printf("🔍 [HIGH LOG] ================================")
+		session.publish(ProgressUpdate{
+			Type:      WSMsgTypeStatus,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   fmt.Sprintf("Translating %d block(s) from %s to %s...", len(sources), command.SourceLocale, command.Locale),
+		})
 	}
-
-	// Create pipes for stdout and stderr
-	stdout, err := cmd.StdoutPipe()
+	workspaceDir, err := resolveWorkspaceDir(db, command.ProjectID)
 	if err != nil {
-		handleCommandError(session, command, db, fmt.Errorf("failed to create stdout pipe: %w", err))
+		handleCommandError(session, command, db, err)
 		return
 	}
-
-	stderr, err := cmd.StderrPipe()
+	// Run on a branch of its own when enabled; a command that does not complete leaves
+	// nothing behind, and a retry starts from a new branch
+	workDir := prepareCommandBranch(session, db, workspaceDir)
+	if command.Branch != "" {
+		defer func() {
+			if command.Status != "completed" {
+				discardCommandBranch(db, command, workspaceDir)
+			}
+		}()
+		session.publish(ProgressUpdate{
+			Type:      WSMsgTypeStatus,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   "Working on branch " + command.Branch,
+			Data:      fiber.Map{"branch": command.Branch, "base": command.BaseSHA},
+		})
+	}
+	// The CLI edits the workspace itself, so it can also describe its work in a result file
+	_, writesResultFile := provider.(*ClaudeCLIProvider)
+	writesResultFile = writesResultFile && !translate
+	var menus []MenuRequest
+	if writesResultFile {
+		prompt += resultFileInstructions(command.ID)
+		prepareResultFile(workDir, command.ID)
+		// Menus live in the database, so the CLI edits a copy that is read back afterwards
+		if menus, err = prepareMenuFile(db, workDir, command.ID); err != nil {
+			log.Printf("⚠️ Failed to write the menu file for command [%s]: %v", command.ID, err)
+		} else {
+			prompt += menuFileInstructions(command.ID)
+			defer removeMenuFile(workDir, command.ID)
+		}
+	}
+	childEnv, err := buildChildEnv(db, command.ProjectID)
 	if err != nil {
-		handleCommandError(session, command, db, fmt.Errorf("failed to create stderr pipe: %w", err))
+		handleCommandError(session, command, db, err)
 		return
 	}
+	log.Printf("🤖 Calling %s for command [%s] with a %d-character prompt | Workspace: %s", provider.Name(), command.ID, utf8.RuneCountInString(prompt), workDir)
+
+	// Enforce the per-command timeout on top of user cancellation
+	timeout := getCommandTimeout()
+	runCtx, cancelRun := context.WithTimeout(session.Context, timeout)
+	defer cancelRun()
+
+	// Kill the CLI once it exceeds the output limit; CPU and memory are capped by its cgroup
+	limiter := newProcessLimiter(command.ID, getProcessLimits(), cancelRun)
+	defer limiter.release()
+
+	// Snapshot the workspace so the files actually changed can be reported.
+	// Translations only write content, so the workspace is left alone.
+	var before WorkspaceSnapshot
+	if !translate {
+		if before, err = snapshotWorkspace(workDir); err != nil {
+			log.Printf("⚠️ Failed to snapshot workspace before command [%s]: %v", command.ID, err)
+		}
+	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		handleCommandError(session, command, db, fmt.Errorf("failed to start Claude CLI: %w", err))
-		return
+	// Archive the workspace so the command can be rolled back; the changes of a branch
+	// are dropped by discarding it instead
+	if !translate && command.Branch == "" {
+		if path, err := archiveWorkspace(command.ID, workspaceDir); err != nil {
+			log.Printf("⚠️ Failed to archive workspace before command [%s]: %v", command.ID, err)
+			session.publish(ProgressUpdate{
+				Type:      WSMsgTypeStatus,
+				Timestamp: time.Now().Format(time.RFC3339),
+				Message:   "Workspace snapshot failed; this command cannot be rolled back",
+			})
+		} else if path != "" {
+			command.SnapshotPath = path
+			db.Model(command).Update("snapshot_path", path)
+			go pruneSnapshots(db)
+		}
 	}
 
-	log.Printf("✅ Claude CLI process started")
+	// Screenshot the targeted page so the change can be judged visually afterwards
+	visualDiff := !translate && command.Page != "" && isVisualDiffEnabled()
+	if visualDiff {
+		captureCommandScreenshot(runCtx, db, command, workDir, "before")
+	}
 
-	// Read stdout and stderr concurrently
-	var wg sync.WaitGroup
+	// The answer of a translation is collected and parsed once the provider is done
+	var answer strings.Builder
 
-	// Read stdout
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
+	// Stream provider output to the client
+	tools := newToolTracker(db, command, workDir)
+	emit := func(event ProviderEvent) {
+		if event.Usage != nil {
+			recordCommandUsage(db, command, event.Usage)
+			return
+		}
 
-			// Log to stdout
-			if isHighLogLevel() {
-				log.Printf("🔍 [HIGH LOG] Claude stdout: %s", line)
-			} else {
-				log.Printf("📤 Claude: %s", line)
-			}
+		if event.Tool != nil {
+			publishTool(session, tools, event.Tool)
+			return
+		}
 
-			// Stream output to client
-			select {
-			case session.progressQueue <- ProgressUpdate{
-				Type:      WSMsgTypeOutput,
+		if event.Warning != "" {
+			log.Printf("⚠️ %s %s: %s", provider.Name(), event.Stream, event.Warning)
+			session.publish(ProgressUpdate{
+				Type:      WSMsgTypeWarning,
 				Timestamp: time.Now().Format(time.RFC3339),
-				Data:      line,
-			}:
-			case <-session.Context.Done():
-				return
-			}
-		}
-		if err := scanner.Err(); err != nil && err != io.EOF {
-			log.Printf("❌ Error reading stdout: %v", err)
+				Message:   event.Warning,
+				Data:      fiber.Map{"stream": event.Stream},
+			})
+			return
 		}
-	}()
 
-	// Read stderr
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			line := scanner.Text()
+		if !limiter.allowOutput(len(event.Text) + 1) {
+			return
+		}
+		if translate && event.Stream == "stdout" {
+			answer.WriteString(event.Text)
+			if !event.Continued {
+				answer.WriteString("\n")
+			}
+		}
 
-			// Log to stdout
+		data := event.Text
+		if event.Stream == "stderr" {
 			if isHighLogLevel() {
-				log.Printf("🔍 [HIGH LOG] Claude stderr: %s", line)
+				log.Printf("🔍 [HIGH LOG] %s stderr: %s", provider.Name(), event.Text)
 			} else {
-				log.Printf("⚠️ Claude stderr: %s", line)
+				log.Printf("⚠️ %s stderr: %s", provider.Name(), event.Text)
 			}
-
-			// Stream to client as output
-			select {
-			case session.progressQueue <- ProgressUpdate{
-				Type:      WSMsgTypeOutput,
-				Timestamp: time.Now().Format(time.RFC3339),
-				Data:      fmt.Sprintf("[stderr] %s", line),
-			}:
-			case <-session.Context.Done():
-				return
+			if event.Part == 0 {
+				data = fmt.Sprintf("[stderr] %s", event.Text)
 			}
+		} else if isHighLogLevel() {
+			log.Printf("🔍 [HIGH LOG] %s stdout: %s", provider.Name(), event.Text)
+		} else {
+			log.Printf("📤 %s: %s", provider.Name(), event.Text)
 		}
-		if err := scanner.Err(); err != nil && err != io.EOF {
-			log.Printf("❌ Error reading stderr: %v", err)
-		}
-	}()
 
-	// Wait for command to complete
-	cmdErr := cmd.Wait()
-	wg.Wait()
+		// Never blocks: with a slow client the line is dropped from the stream instead
+		session.publish(ProgressUpdate{
+			Type:      WSMsgTypeOutput,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Data:      data,
+			Continued: event.Continued,
+		})
+	}
+
+	// Continue the CLI session of the conversation if there is one. The CLI keeps its
+	// sessions per directory, so a command in a worktree starts a session of its own.
+	sessionID, resume := conversationSessionArgs(db, command)
+	if command.Branch != "" {
+		sessionID, resume = "", false
+	}
+
+	// Tools that match the permission policy wait for the client to approve them
+	var permission func(context.Context, string, json.RawMessage) PermissionDecision
+	if isPermissionPromptEnabled() {
+		permission = askPermission(session, newPermissionPolicy(db, command, workDir))
+	}
+
+	runStarted := time.Now()
+	cmdErr := provider.Run(runCtx, ProviderRequest{
+		CommandID:      command.ID,
+		Prompt:         prompt,
+		OriginalPrompt: command.Prompt,
+		Scope:          command.Scope,
+		Page:           command.Page,
+		WorkDir:        workDir,
+		SessionID:      sessionID,
+		Resume:         resume,
+		Env:            childEnv,
+		Limiter:        limiter,
+		AttachStdin:    session.stdin.attach,
+		Started: func(pid int) {
+			recordSessionPID(db, command.ID, pid)
+		},
+		Permission: permission,
+	}, emit)
+	session.stdin.detach()
+	tools.close()
+	recordRunTime(db, command, time.Since(runStarted))
+
+	if (cmdErr == nil || runCtx.Err() != nil) && command.Branch == "" {
+		markConversationStarted(db, command.ConversationID)
+	}
 
 	// Handle completion
 	executionTime := time.Since(session.StartTime).Seconds()
 
 	if cmdErr != nil {
-		if session.Context.Err() == context.Canceled {
+		if limitErr := limiter.err(); limitErr != nil {
+			// Killed for exceeding PROCESS_MEMORY_LIMIT or PROCESS_OUTPUT_LIMIT
+			log.Printf("🛑 Command Exceeded Resource Limit [%s]: %v", command.ID, limitErr)
+			command.Status = StatusResourceLimit
+			command.ErrorMessage = limitErr.Error()
+			command.CompletedAt = time.Now().Unix()
+			db.Save(command)
+
+			session.publish(ProgressUpdate{
+				Type:      WSMsgTypeError,
+				Timestamp: time.Now().Format(time.RFC3339),
+				Message:   command.ErrorMessage,
+				Data: fiber.Map{
+					"error": command.ErrorMessage,
+				},
+			})
+			session.publish(ProgressUpdate{
+				Type:      WSMsgTypeComplete,
+				Timestamp: time.Now().Format(time.RFC3339),
+				Message:   "Command exceeded a resource limit",
+				Data: fiber.Map{
+					"commandId":     command.ID,
+					"status":        StatusResourceLimit,
+					"executionTime": executionTime,
+				},
+			})
+		} else if session.Context.Err() == context.Canceled {
 			// Interrupted by user
 			log.Printf("⚠️ Command Interrupted [%s]", command.ID)
 			command.Status = "interrupted"
 			db.Save(command)
 
-			session.progressQueue <- ProgressUpdate{
+			session.publish(ProgressUpdate{
 				Type:      WSMsgTypeStatus,
 				Timestamp: time.Now().Format(time.RFC3339),
 				Message:   "Command was interrupted",
-			}
+			})
+		} else if runCtx.Err() == context.DeadlineExceeded {
+			// Killed by the watchdog
+			log.Printf("⏱️ Command Timed Out [%s] after %s", command.ID, timeout)
+			command.Status = "timed_out"
+			command.ErrorMessage = fmt.Sprintf("command exceeded timeout of %s", timeout)
+			command.CompletedAt = time.Now().Unix()
+			db.Save(command)
+
+			session.publish(ProgressUpdate{
+				Type:      WSMsgTypeError,
+				Timestamp: time.Now().Format(time.RFC3339),
+				Message:   command.ErrorMessage,
+				Data: fiber.Map{
+					"error": command.ErrorMessage,
+				},
+			})
+			session.publish(ProgressUpdate{
+				Type:      WSMsgTypeComplete,
+				Timestamp: time.Now().Format(time.RFC3339),
+				Message:   "Command timed out",
+				Data: fiber.Map{
+					"commandId":     command.ID,
+					"status":        "timed_out",
+					"executionTime": executionTime,
+				},
+			})
 		} else {
 			// Error occurred
 			log.Printf("❌ Command Failed [%s]: %v", command.ID, cmdErr)
@@ -409,29 +880,30 @@ func processAICommand(session *AICommandSession, db *gorm.DB) {
 		return
 	}
 
+	// Save the translations; an answer that cannot be used fails the command
+	var result *CommandResult
+	if translate {
+		if result, err = applyTranslations(db, command, sources, answer.String()); err != nil {
+			handleCommandError(session, command, db, err)
+			return
+		}
+	}
+
 	// Success
 	log.Printf("✅ Command Completed [%s]: %.2fs", command.ID, executionTime)
 
 	command.Status = "completed"
 	command.CompletedAt = time.Now().Unix()
 
-	// Create result
-	result := fiber.Map{
-		"action":        fmt.Sprintf("Executed command for %s", command.Page),
-		"affectedPages": []string{command.Page},
-		"changes": []fiber.Map{
-			{
-				"type":        "update",
-				"target":      command.Page,
-				"description": "Applied changes via Claude CLI",
-			},
-		},
+	if !translate {
+		result = workspaceCommandResult(db, command, workDir, before, writesResultFile, menus)
 	}
+
 	resultJSON, _ := json.Marshal(result)
 	command.Result = string(resultJSON)
 	db.Save(command)
 
-	// High-level logging: log full result
+	// High-level logging: log full result, unless results are encrypted at rest
 	if isHighLogLevel() {
 		log.Printf("🔍 [HIGH LOG] ================================")
 		log.Printf("🔍 [HIGH LOG] COMMAND COMPLETED SUCCESSFULLY")
@@ -440,19 +912,31 @@ func processAICommand(session *AICommandSession, db *gorm.DB) {
 		log.Printf("🔍 [HIGH LOG] Execution Time: %.2fs", executionTime)
 		log.Printf("🔍 [HIGH LOG] Status: %s", command.Status)
 		resultPretty, _ := json.MarshalIndent(result, "🔍 [HIGH LOG] ", "  ")
-		log.Printf("🔍 [HIGH LOG] Result:\n🔍 [HIGH LOG] %s", string(resultPretty))
+		log.Printf("🔍 [HIGH LOG] Result:\n🔍 [HIGH LOG] %s", highLogText(string(resultPretty)))
 		log.Printf("🔍 [HIGH LOG] ================================")
 	}
 
+	if visualDiff && captureCommandScreenshot(session.Context, db, command, workDir, "after") {
+		session.publish(ProgressUpdate{
+			Type:      WSMsgTypeStatus,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   "Before and after screenshots of the page are ready",
+			Data:      fiber.Map{"visualDiff": "/api/ai/command/" + command.ID + "/visual-diff"},
+		})
+	}
+
 	// Send result
-	session.progressQueue <- ProgressUpdate{
+	session.publish(ProgressUpdate{
 		Type:      WSMsgTypeResult,
 		Timestamp: time.Now().Format(time.RFC3339),
 		Data:      result,
+	})
+	if command.Branch != "" {
+		sendBranchDiff(session, workspaceDir)
 	}
 
 	// Send completion
-	session.progressQueue <- ProgressUpdate{
+	session.publish(ProgressUpdate{
 		Type:      WSMsgTypeComplete,
 		Timestamp: time.Now().Format(time.RFC3339),
 		Message:   "Command completed successfully",
@@ -461,20 +945,78 @@ func processAICommand(session *AICommandSession, db *gorm.DB) {
 			"status":        "completed",
 			"executionTime": executionTime,
 		},
+	})
+}
+
+// workspaceCommandResult builds the result of a command from the files that changed
+// in the workspace and the model's result file, records them for undo and commits them
+func workspaceCommandResult(db *gorm.DB, command *AICommand, workspaceDir string, before WorkspaceSnapshot, writesResultFile bool, menus []MenuRequest) *CommandResult {
+	// Read the model's result and menu files before they could end up in the commit
+	var report *CommandReport
+	var reportProblems []ValidationProblem
+	var changedMenus []MenuRequest
+	if writesResultFile {
+		report, reportProblems = readCommandReport(workspaceDir, command.ID)
+		var menuProblems []ValidationProblem
+		if menus != nil {
+			changedMenus, menuProblems = readMenuFile(workspaceDir, command.ID, menus)
+			reportProblems = append(reportProblems, menuProblems...)
+		}
+		logReportProblems(command.ID, reportProblems)
 	}
+
+	// Create result from the files that actually changed
+	after, err := snapshotWorkspace(workspaceDir)
+	if err != nil {
+		log.Printf("⚠️ Failed to snapshot workspace after command [%s]: %v", command.ID, err)
+	}
+	changes := detectChanges(before, after)
+	log.Printf("📂 Command [%s] changed %d file(s)", command.ID, len(changes))
+	// Undo restores files in the workspace, which a branch only reaches when merged
+	if before != nil && command.Branch == "" {
+		saveUndoEntries(db, command.ID, fileUndoEntries(command.ID, before, after, changes))
+	}
+
+	result := buildCommandResult(command, changes, report, reportProblems)
+	result.Menus = changedMenus
+	// Like its files, the menus of a branch only change when the branch is merged
+	if command.Branch == "" {
+		if err := applyCommandMenus(db, command, changedMenus); err != nil {
+			log.Printf("⚠️ Failed to save the menus changed by command [%s]: %v", command.ID, err)
+		}
+	}
+
+	// Commit the workspace so the change can be inspected and reverted
+	if sha := commitCommandChanges(command, workspaceDir); sha != "" {
+		command.CommitSHA = sha
+		result.CommitSHA = sha
+	}
+	result.Branch = command.Branch
+	return result
 }
 
-// buildClaudePrompt builds the prompt for Claude CLI based on the command
-func buildClaudePrompt(command *AICommand) string {
-	// Build a prompt that includes scope and page context
-	prompt := command.Prompt
+// maxComponentTargetLength caps component IDs and selectors passed into prompts
+const maxComponentTargetLength = 512
 
-	// Add context about the scope and page
-	if command.Scope != "" && command.Page != "" {
-		prompt = fmt.Sprintf("Scope: %s | Page: %s | Task: %s", command.Scope, command.Page, command.Prompt)
+// validateComponentTarget checks the block targeted by a component-scoped command
+func validateComponentTarget(ctx CommandContext) error {
+	if ctx.ComponentID == "" && ctx.Selector == "" {
+		return fmt.Errorf("context.componentId or context.selector is required for the component scope")
+	}
+	for field, value := range map[string]string{"componentId": ctx.ComponentID, "selector": ctx.Selector} {
+		if len(value) > maxComponentTargetLength {
+			return fmt.Errorf("context.%s is too long (max %d characters)", field, maxComponentTargetLength)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("context.%s must be a single line", field)
+		}
 	}
+	return nil
+}
 
-	return prompt
+// buildClaudePrompt builds the prompt for Claude CLI from the template of the command's scope
+func buildClaudePrompt(command *AICommand) string {
+	return renderPrompt(command)
 }
 
 // handleCommandError handles errors during command execution
@@ -484,33 +1026,37 @@ func handleCommandError(session *AICommandSession, command *AICommand, db *gorm.
 
 	command.Status = "failed"
 	command.ErrorMessage = errMsg
+	message := "Command failed"
+	if session.retry {
+		command.Status = "queued" // Back in the job queue for the next attempt
+		message = "Command failed; the job queue will retry it"
+	}
 	db.Save(command)
 
-	session.progressQueue <- ProgressUpdate{
+	session.publish(ProgressUpdate{
 		Type:      WSMsgTypeError,
 		Timestamp: time.Now().Format(time.RFC3339),
 		Message:   errMsg,
 		Data: fiber.Map{
 			"error": errMsg,
 		},
-	}
+	})
 
-	session.progressQueue <- ProgressUpdate{
+	session.publish(ProgressUpdate{
 		Type:      WSMsgTypeComplete,
 		Timestamp: time.Now().Format(time.RFC3339),
-		Message:   "Command failed",
+		Message:   message,
 		Data: fiber.Map{
 			"commandId": command.ID,
-			"status":    "failed",
+			"status":    command.Status,
 		},
-	}
+	})
 }
 
 // handleWSMessages handles incoming WebSocket messages from the client
 func handleWSMessages(conn *websocket.Conn, session *AICommandSession) {
 	for {
-		var msg map[string]interface{}
-		err := conn.ReadJSON(&msg)
+		msg, err := readWSMessage(conn)
 		if err != nil {
 			return
 		}
@@ -534,35 +1080,71 @@ func handleWSMessages(conn *websocket.Conn, session *AICommandSession) {
 				Type:      WSMsgTypePing,
 				Timestamp: time.Now().Format(time.RFC3339),
 			})
+
+		case WSMsgTypeApprove, WSMsgTypeDeny:
+			if err := answerPermission(session, msgType, msg); err != nil {
+				sendWSError(conn, "PERMISSION_NOT_FOUND", err.Error(), "")
+			}
+
+		case WSMsgTypeInput:
+			// Forward the answer to the running CLI; "eof": true closes its stdin
+			var err error
+			if eof, _ := msg["eof"].(bool); eof {
+				err = session.stdin.Close()
+			} else {
+				text, _ := msg["data"].(string)
+				err = session.stdin.WriteLine(text)
+			}
+			if err != nil {
+				sendWSMessage(conn, ProgressUpdate{
+					Type:      WSMsgTypeError,
+					Timestamp: time.Now().Format(time.RFC3339),
+					Message:   err.Error(),
+					Data: fiber.Map{
+						"code":  "INPUT_FAILED",
+						"error": err.Error(),
+					},
+				})
+			}
 		}
 	}
 }
 
-// streamProgressUpdates streams progress updates from the queue to the WebSocket
-func streamProgressUpdates(conn *websocket.Conn, session *AICommandSession) {
+// streamProgressUpdates streams progress updates from the queue to the WebSocket,
+// batching output lines that arrive in quick succession. It returns the error of a
+// failed send.
+func streamProgressUpdates(conn *websocket.Conn, session *AICommandSession) error {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
+	batcher := newOutputBatcher(conn)
 
 	for {
 		select {
 		case update, ok := <-session.progressQueue:
 			if !ok {
 				// Channel closed, processing complete
-				return
+				return batcher.flush()
 			}
-			if err := sendWSMessage(conn, update); err != nil {
-				return
+			if err := batcher.send(update); err != nil {
+				return err
+			}
+
+		case <-batcher.due:
+			if err := batcher.flush(); err != nil {
+				return err
 			}
 
 		case <-ticker.C:
 			// Send keep-alive ping
-			sendWSMessage(conn, ProgressUpdate{
+			if err := batcher.send(ProgressUpdate{
 				Type:      WSMsgTypePing,
 				Timestamp: time.Now().Format(time.RFC3339),
-			})
+			}); err != nil {
+				return err
+			}
 
 		case <-session.Context.Done():
-			return
+			return batcher.flush()
 		}
 	}
 }
@@ -570,17 +1152,13 @@ func streamProgressUpdates(conn *websocket.Conn, session *AICommandSession) {
 // Helper functions
 
 func sendWSMessage(conn *websocket.Conn, update ProgressUpdate) error {
-	return conn.WriteJSON(update)
+	return writeWSValue(conn, update)
 }
 
 func sendWSError(conn *websocket.Conn, code, message, details string) {
-	conn.WriteJSON(fiber.Map{
+	writeWSValue(conn, fiber.Map{
 		"type":  WSMsgTypeError,
-		"error": fiber.Map{
-			"code":    code,
-			"message": message,
-			"details": details,
-		},
+		"error": newAPIError(0, code, message, details),
 	})
 	conn.Close()
 }
@@ -590,6 +1168,10 @@ func cleanup(session *AICommandSession) {
 	commandMu.Lock()
 	delete(commandSessions, session.ID)
 	commandMu.Unlock()
+	finishSessionRecord(session.db, session.ID, session.Command.Status)
+	if cluster != nil {
+		cluster.release(SessionKindCommand, session.ID)
+	}
 }
 
 // GetAICommandStatus returns the status of a command
@@ -599,43 +1181,77 @@ func GetAICommandStatus(db *gorm.DB) fiber.Handler {
 
 		var command AICommand
 		if err := db.First(&command, "id = ?", commandID).Error; err != nil {
-			return c.Status(404).JSON(fiber.Map{
-				"success": false,
-				"error": fiber.Map{
-					"code":    "COMMAND_NOT_FOUND",
-					"message": "Command not found",
-				},
-			})
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", nil)
 		}
 
-		response := fiber.Map{
-			"success": true,
-			"data": fiber.Map{
-				"commandId":  command.ID,
-				"status":     command.Status,
-				"prompt":     command.Prompt,
-				"scope":      command.Scope,
-				"createdAt":  command.CreatedAt,
-				"completedAt": command.CompletedAt,
-			},
+		status := CommandStatus{
+			CommandID:      command.ID,
+			Status:         command.Status,
+			Prompt:         command.Prompt,
+			Scope:          command.Scope,
+			CreatedAt:      command.CreatedAt,
+			CompletedAt:    command.CompletedAt,
+			Error:          command.ErrorMessage,
+			CommitSHA:      command.CommitSHA,
+			RolledBackAt:   command.RolledBackAt,
+			UndoneAt:       command.UndoneAt,
+			ApprovalReason: command.ApprovalReason,
+			RetryOf:        command.RetryOf,
+			Priority:       command.Priority,
+			RunAt:          command.RunAt,
+			Attempts:       command.Attempts,
+			Branch:         command.Branch,
+			MergeSHA:       command.MergeSHA,
+			MergedAt:       command.MergedAt,
+			DiscardedAt:    command.DiscardedAt,
 		}
 
 		if command.Result != "" {
-			var result map[string]interface{}
-			json.Unmarshal([]byte(command.Result), &result)
-			response["data"].(fiber.Map)["result"] = result
+			if result, err := parseCommandResult(command.Result); err == nil {
+				status.Result = result
+			}
 		}
 
-		if command.ErrorMessage != "" {
-			response["data"].(fiber.Map)["error"] = command.ErrorMessage
+		if command.SnapshotPath != "" {
+			exists := snapshotExists(command.SnapshotPath)
+			status.HasSnapshot = &exists
 		}
 
-		return c.JSON(response)
+		if command.InputTokens > 0 || command.OutputTokens > 0 {
+			status.Usage = &CommandUsage{
+				Model:        command.Model,
+				InputTokens:  command.InputTokens,
+				OutputTokens: command.OutputTokens,
+				CostUSD:      command.CostUSD,
+			}
+		}
+
+		if command.ApprovalReason != "" {
+			status.ReviewedBy = command.ReviewedBy
+			status.ReviewedAt = command.ReviewedAt
+		}
+
+		if command.Scope == "component" {
+			status.ComponentID = command.ComponentID
+			status.Selector = command.Selector
+		}
+
+		if command.Scope == ScopeTranslate {
+			status.ContentID = command.ComponentID
+			status.Page = command.Page
+			status.Locale = command.Locale
+			status.SourceLocale = command.SourceLocale
+		}
+
+		return c.JSON(APIResponse[CommandStatus]{
+			Success: true,
+			Data:    status,
+		})
 	}
 }
 
-// InterruptAICommand interrupts a running command
-func InterruptAICommand() fiber.Handler {
+// InterruptAICommand interrupts a running command on behalf of its creator or an admin
+func InterruptAICommand(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		commandID := c.Params("commandId")
 
@@ -643,24 +1259,28 @@ func InterruptAICommand() fiber.Handler {
 		session, exists := commandSessions[commandID]
 		commandMu.RUnlock()
 
+		if !exists && runsElsewhere(SessionKindCommand, commandID) {
+			return interruptRemoteCommand(c, db, commandID)
+		}
+		if !exists && commandQueue != nil {
+			return interruptQueuedCommand(c, db, commandID)
+		}
 		if !exists {
-			return c.Status(404).JSON(fiber.Map{
-				"success": false,
-				"error": fiber.Map{
-					"code":    "SESSION_NOT_FOUND",
-					"message": "Command session not found or already completed",
-				},
-			})
+			return sendError(c, 404, "SESSION_NOT_FOUND", "Command session not found or already completed", nil)
+		}
+		if !ownsOrAdmin(c, session.Command.UserID) {
+			return sendError(c, 403, "NOT_COMMAND_OWNER", "Only the creator of the command or an admin can interrupt it", nil)
 		}
 
 		session.Cancel()
-
-		return c.JSON(fiber.Map{
-			"success": true,
-			"message": "Command interrupted successfully",
-			"data": fiber.Map{
-				"commandId": commandID,
-				"status":    "interrupted",
+		recordAudit(db, c, AuditCommandInterrupt, commandID, nil, nil, "")
+
+		return c.JSON(APIResponse[CommandState]{
+			Success: true,
+			Message: "Command interrupted successfully",
+			Data: CommandState{
+				CommandID: commandID,
+				Status:    "interrupted",
 			},
 		})
 	}
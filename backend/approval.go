@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"gorm.io/gorm"
+)
+
+// ApprovalRule flags commands that need a reviewer before they run.
+// A rule matches when the scope is listed (or no scopes are given) and the
+// prompt matches the pattern (or no pattern is given).
+type ApprovalRule struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes,omitempty"`
+	Pattern string   `json:"pattern,omitempty"` // Go regular expression matched against the prompt
+	Reason  string   `json:"reason"`
+
+	re *regexp.Regexp
+}
+
+// ApprovalPolicy is the set of rules loaded from APPROVAL_POLICY_FILE
+type ApprovalPolicy struct {
+	Enabled bool           `json:"enabled"`
+	Rules   []ApprovalRule `json:"rules"`
+}
+
+// defaultApprovalRules require approval for site-wide changes and deletions
+var defaultApprovalRules = []ApprovalRule{
+	{
+		Name:   "global-scope",
+		Scopes: []string{"global"},
+		Reason: "Global commands can change every page of the site",
+	},
+	{
+		Name:    "deletion",
+		Pattern: `(?i)\b(delete|remove|drop|wipe|erase|destroy)\b`,
+		Reason:  "The prompt asks to delete content or files",
+	},
+}
+
+var (
+	approvalPolicy     *ApprovalPolicy
+	approvalPolicyOnce sync.Once
+
+	// approvalWaiters are streams blocked on a pending command, keyed by command ID
+	approvalWaiters = make(map[string][]chan string)
+	approvalMu      sync.Mutex
+)
+
+// loadApprovalPolicy reads the policy once. APPROVAL_POLICY=off disables approvals;
+// APPROVAL_POLICY_FILE replaces the default rules with a JSON file ({"rules": [...]}).
+func loadApprovalPolicy() *ApprovalPolicy {
+	approvalPolicyOnce.Do(func() {
+		approvalPolicy = &ApprovalPolicy{Enabled: os.Getenv("APPROVAL_POLICY") != "off", Rules: defaultApprovalRules}
+
+		if path := os.Getenv("APPROVAL_POLICY_FILE"); path != "" {
+			var loaded ApprovalPolicy
+			data, err := os.ReadFile(path)
+			if err == nil {
+				err = json.Unmarshal(data, &loaded)
+			}
+			if err != nil {
+				log.Printf("⚠️ Failed to load approval policy %s, using defaults: %v", path, err)
+			} else {
+				approvalPolicy.Rules = loaded.Rules
+			}
+		}
+
+		rules := approvalPolicy.Rules[:0:0]
+		for _, rule := range approvalPolicy.Rules {
+			if rule.Pattern != "" {
+				re, err := regexp.Compile(rule.Pattern)
+				if err != nil {
+					log.Printf("⚠️ Skipping approval rule %s: %v", rule.Name, err)
+					continue
+				}
+				rule.re = re
+			} else if len(rule.Scopes) == 0 {
+				log.Printf("⚠️ Skipping approval rule %s: needs scopes or a pattern", rule.Name)
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		approvalPolicy.Rules = rules
+	})
+	return approvalPolicy
+}
+
+// matches reports whether the rule applies to the command
+func (r ApprovalRule) matches(scope, prompt string) bool {
+	if len(r.Scopes) > 0 {
+		found := false
+		for _, s := range r.Scopes {
+			if s == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return r.re == nil || r.re.MatchString(prompt)
+}
+
+// approvalReasons returns why a command needs approval; empty means it can run directly
+func approvalReasons(req AICommandRequest) []string {
+	policy := loadApprovalPolicy()
+	if !policy.Enabled {
+		return nil
+	}
+
+	var reasons []string
+	for _, rule := range policy.Rules {
+		if rule.matches(req.Scope, req.Prompt) {
+			reasons = append(reasons, rule.Reason)
+		}
+	}
+	return reasons
+}
+
+// watchApproval registers for the decision on a pending command
+func watchApproval(commandID string) chan string {
+	ch := make(chan string, 1)
+	approvalMu.Lock()
+	approvalWaiters[commandID] = append(approvalWaiters[commandID], ch)
+	approvalMu.Unlock()
+	return ch
+}
+
+func unwatchApproval(commandID string, ch chan string) {
+	approvalMu.Lock()
+	defer approvalMu.Unlock()
+	waiters := approvalWaiters[commandID]
+	for i, w := range waiters {
+		if w == ch {
+			approvalWaiters[commandID] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(approvalWaiters[commandID]) == 0 {
+		delete(approvalWaiters, commandID)
+	}
+}
+
+// notifyApproval wakes every stream waiting on the command with the new status
+func notifyApproval(commandID, status string) {
+	approvalMu.Lock()
+	defer approvalMu.Unlock()
+	for _, ch := range approvalWaiters[commandID] {
+		ch <- status
+	}
+	delete(approvalWaiters, commandID)
+}
+
+// awaitApproval holds a stream open until the pending command is approved or rejected.
+// It returns true (with command reloaded) when the command may run.
+func awaitApproval(conn *websocket.Conn, db *gorm.DB, command *AICommand) bool {
+	decision := watchApproval(command.ID)
+	defer unwatchApproval(command.ID, decision)
+
+	// Re-read after registering so a decision made in between is not missed
+	if err := db.First(command, "id = ?", command.ID).Error; err != nil {
+		sendWSError(conn, "COMMAND_NOT_FOUND", "Command not found", err.Error())
+		return false
+	}
+
+	if command.Status == "pending_approval" {
+		sendWSMessage(conn, ProgressUpdate{
+			Type:      WSMsgTypeStatus,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   "Waiting for approval",
+			Data: fiber.Map{
+				"commandId": command.ID,
+				"status":    "pending_approval",
+				"reason":    command.ApprovalReason,
+			},
+		})
+
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+	wait:
+		for {
+			select {
+			case <-decision:
+				break wait
+			case <-ticker.C:
+				if shuttingDown.Load() {
+					return false
+				}
+				// Keep-alive; a failed write means the client went away
+				if err := sendWSMessage(conn, ProgressUpdate{Type: WSMsgTypePing, Timestamp: time.Now().Format(time.RFC3339)}); err != nil {
+					return false
+				}
+			}
+		}
+
+		if err := db.First(command, "id = ?", command.ID).Error; err != nil {
+			sendWSError(conn, "COMMAND_NOT_FOUND", "Command not found", err.Error())
+			return false
+		}
+	}
+
+	if command.Status == "rejected" {
+		sendWSMessage(conn, ProgressUpdate{
+			Type:      WSMsgTypeComplete,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   "Command was rejected",
+			Data: fiber.Map{
+				"commandId":  command.ID,
+				"status":     "rejected",
+				"reviewedBy": command.ReviewedBy,
+				"note":       command.ReviewNote,
+			},
+		})
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		return false
+	}
+
+	sendWSMessage(conn, ProgressUpdate{
+		Type:      WSMsgTypeStatus,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Message:   "Command approved",
+		Data: fiber.Map{
+			"commandId":  command.ID,
+			"status":     "approved",
+			"reviewedBy": command.ReviewedBy,
+		},
+	})
+	return true
+}
+
+// ReviewRequest is the body of approve and reject requests
+type ReviewRequest struct {
+	Reviewer string `json:"reviewer"`
+	Note     string `json:"note"`
+}
+
+// ReviewResult is the data of the response of an approve or reject request
+type ReviewResult struct {
+	CommandID  string `json:"commandId"`
+	Status     string `json:"status"` // queued or rejected
+	ReviewedBy string `json:"reviewedBy"`
+}
+
+// PendingApproval is a command waiting for a reviewer
+type PendingApproval struct {
+	CommandID string `json:"commandId"`
+	Prompt    string `json:"prompt"`
+	Scope     string `json:"scope"`
+	Page      string `json:"page"`
+	UserID    string `json:"userId"`
+	Reason    string `json:"reason"` // Rules of the approval policy the command matched
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// PendingApprovals is the data of GET /api/ai/approvals
+type PendingApprovals struct {
+	Commands []PendingApproval `json:"commands"`
+	Policy   *ApprovalPolicy   `json:"policy"`
+}
+
+// reviewCommand moves a pending command to its approved (queued) or rejected state
+func reviewCommand(db *gorm.DB, approve bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandID := c.Params("commandId")
+
+		var req ReviewRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+			}
+		}
+
+		var command AICommand
+		if err := db.First(&command, "id = ?", commandID).Error; err != nil {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", nil)
+		}
+
+		status := "queued"
+		updates := map[string]interface{}{
+			"status":      status,
+			"reviewed_by": req.Reviewer,
+			"review_note": req.Note,
+			"reviewed_at": time.Now().Unix(),
+		}
+		if !approve {
+			status = "rejected"
+			updates["status"] = status
+			updates["error_message"] = "Rejected by reviewer"
+			if req.Note != "" {
+				updates["error_message"] = fmt.Sprintf("Rejected by reviewer: %s", req.Note)
+			}
+			updates["completed_at"] = time.Now().Unix()
+		}
+
+		// Only the first decision wins
+		result := db.Model(&AICommand{}).Where("id = ? AND status = ?", commandID, "pending_approval").Updates(updates)
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update command", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 409, "NOT_PENDING_APPROVAL", "Command is not awaiting approval", "Current status: "+command.Status)
+		}
+
+		log.Printf("🛂 Command [%s] %s by %q", commandID, status, req.Reviewer)
+		notifyApproval(commandID, status)
+
+		// Approved commands go to the job queue; without one, approved batch commands go
+		// back to the batch workers and the others run in the stream that waits for them
+		if approve && commandQueue != nil {
+			command.Status = status
+			dispatchCommand(db, &command)
+		} else if approve && command.BatchID != "" {
+			enqueueBatchCommands(db, []string{commandID})
+		}
+		if command.BatchID != "" {
+			batchCommandChanged(db, command.BatchID)
+		}
+
+		return c.JSON(APIResponse[ReviewResult]{
+			Success: true,
+			Data: ReviewResult{
+				CommandID:  commandID,
+				Status:     status,
+				ReviewedBy: req.Reviewer,
+			},
+		})
+	}
+}
+
+// ApproveAICommand lets a pending command run
+func ApproveAICommand(db *gorm.DB) fiber.Handler {
+	return reviewCommand(db, true)
+}
+
+// RejectAICommand discards a pending command
+func RejectAICommand(db *gorm.DB) fiber.Handler {
+	return reviewCommand(db, false)
+}
+
+// ListPendingApprovals returns the commands awaiting a reviewer, oldest first
+func ListPendingApprovals(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var commands []AICommand
+		if err := db.Where("status = ?", "pending_approval").Order("created_at").Find(&commands).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load commands", err.Error())
+		}
+
+		items := make([]PendingApproval, 0, len(commands))
+		for _, command := range commands {
+			items = append(items, PendingApproval{
+				CommandID: command.ID,
+				Prompt:    command.Prompt,
+				Scope:     command.Scope,
+				Page:      command.Page,
+				UserID:    command.UserID,
+				Reason:    command.ApprovalReason,
+				CreatedAt: command.CreatedAt,
+			})
+		}
+
+		return c.JSON(APIResponse[PendingApprovals]{
+			Success: true,
+			Data: PendingApprovals{
+				Commands: items,
+				Policy:   loadApprovalPolicy(),
+			},
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Asset is an uploaded image or file that can be embedded in edited content
+type Asset struct {
+	ID          string `gorm:"primaryKey" json:"id"`
+	Page        string `gorm:"index" json:"page"` // Page the asset was uploaded for (optional)
+	Filename    string `json:"filename"`          // Name of the uploaded file
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width,omitempty"` // Pixel dimensions for images
+	Height      int    `json:"height,omitempty"`
+	Checksum    string `json:"checksum"` // SHA-256 of the file
+	StorageKey  string `json:"-"`        // Key in the asset storage backend
+	CreatedAt   int64  `json:"createdAt"`
+
+	ProcessingStatus string         `json:"processingStatus"` // pending, processing, completed, failed, skipped
+	ProcessingError  string         `gorm:"type:text" json:"processingError,omitempty"`
+	Variants         []AssetVariant `gorm:"foreignKey:AssetID" json:"variants,omitempty"`
+}
+
+// allowedAssetTypes maps the content types accepted by POST /api/assets to their
+// storage extension. SVG is deliberately excluded since it can carry scripts.
+var allowedAssetTypes = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/avif":      ".avif",
+	"application/pdf": ".pdf",
+}
+
+// getAssetMaxSize returns the upload size limit in bytes (assets.maxSize, ASSET_MAX_SIZE)
+// Defaults to 10 MB
+func getAssetMaxSize() int {
+	return appConfig.Assets.MaxSize
+}
+
+// assetURL is the path the asset is served from
+func assetURL(asset Asset) string {
+	return "/api/assets/" + asset.ID
+}
+
+// assetResponse builds the JSON representation of an asset
+func assetResponse(asset Asset) fiber.Map {
+	variants := make([]fiber.Map, 0, len(asset.Variants))
+	for _, v := range asset.Variants {
+		variants = append(variants, fiber.Map{
+			"width":       v.Width,
+			"height":      v.Height,
+			"format":      v.Format,
+			"contentType": v.ContentType,
+			"size":        v.Size,
+			"url":         fmt.Sprintf("%s?w=%d&format=%s", assetURL(asset), v.Width, v.Format),
+		})
+	}
+
+	return fiber.Map{
+		"id":          asset.ID,
+		"page":        asset.Page,
+		"filename":    asset.Filename,
+		"contentType": asset.ContentType,
+		"size":        asset.Size,
+		"width":       asset.Width,
+		"height":      asset.Height,
+		"checksum":    asset.Checksum,
+		"url":         assetURL(asset),
+		"createdAt":   asset.CreatedAt,
+
+		"processingStatus": asset.ProcessingStatus,
+		"processingError":  asset.ProcessingError,
+		"variants":         variants,
+	}
+}
+
+// detectAssetType sniffs the content type, falling back to the file extension
+// for formats net/http does not recognize (e.g. AVIF)
+func detectAssetType(data []byte, filename string) string {
+	contentType := http.DetectContentType(data)
+	if contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); byExt != "" {
+			contentType = byExt
+		}
+	}
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = strings.TrimSpace(contentType[:i])
+	}
+	return contentType
+}
+
+// UploadAsset stores a file sent as multipart form field "file"
+func UploadAsset(db *gorm.DB, store AssetStorage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return sendError(c, 400, "FILE_REQUIRED", "Multipart field file is required", nil)
+		}
+		if header.Size > int64(getAssetMaxSize()) {
+			return sendError(c, 413, "FILE_TOO_LARGE", "File exceeds the upload size limit", nil)
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			return sendError(c, 400, "INVALID_FILE", "Failed to read uploaded file", nil)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return sendError(c, 400, "INVALID_FILE", "Failed to read uploaded file", nil)
+		}
+
+		contentType := detectAssetType(data, header.Filename)
+		ext, ok := allowedAssetTypes[contentType]
+		if !ok {
+			return sendError(c, 415, "UNSUPPORTED_TYPE", "Unsupported file type: "+contentType, nil)
+		}
+
+		checksum := sha256.Sum256(data)
+		asset := Asset{
+			ID:          uuid.New().String(),
+			Page:        c.FormValue("page"),
+			Filename:    filepath.Base(header.Filename),
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Checksum:    hex.EncodeToString(checksum[:]),
+			CreatedAt:   time.Now().Unix(),
+		}
+		asset.StorageKey = asset.ID + "/original" + ext
+		asset.ProcessingStatus = AssetProcessingSkipped
+		if isResizableImage(contentType) {
+			asset.ProcessingStatus = AssetProcessingPending
+		}
+
+		if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			asset.Width = config.Width
+			asset.Height = config.Height
+			if err := checkImageSize(config.Width, config.Height); err != nil && asset.ProcessingStatus == AssetProcessingPending {
+				asset.ProcessingStatus = AssetProcessingSkipped
+				asset.ProcessingError = err.Error()
+			}
+		}
+
+		if err := store.Put(c.Context(), asset.StorageKey, bytes.NewReader(data), asset.Size, contentType); err != nil {
+			log.Printf("❌ Failed to store asset %s: %v", asset.ID, err)
+			return sendError(c, 500, "STORAGE_ERROR", "Failed to store file", nil)
+		}
+
+		if asset.Page != "" {
+			touchPage(db, asset.Page)
+		}
+		if err := db.Create(&asset).Error; err != nil {
+			store.Delete(c.Context(), asset.StorageKey)
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to save asset", nil)
+		}
+
+		if asset.ProcessingStatus == AssetProcessingPending {
+			enqueueAssetProcessing(asset.ID)
+		}
+
+		log.Printf("📥 Stored asset %s (%s, %d bytes)", asset.ID, contentType, asset.Size)
+		return c.Status(201).JSON(fiber.Map{
+			"success": true,
+			"data":    assetResponse(asset),
+		})
+	}
+}
+
+// ListAssets returns uploaded assets, newest first (?page=&limit=)
+func ListAssets(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := db.Model(&Asset{})
+		if page := c.Query("page"); page != "" {
+			query = query.Where("page = ?", page)
+		}
+
+		limit := c.QueryInt("limit", 50)
+		if limit <= 0 || limit > 200 {
+			limit = 50
+		}
+
+		var assets []Asset
+		if err := query.Preload("Variants").Order("created_at DESC").Limit(limit).Find(&assets).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load assets", nil)
+		}
+
+		items := make([]fiber.Map, 0, len(assets))
+		for _, asset := range assets {
+			items = append(items, assetResponse(asset))
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"assets": items,
+			},
+		})
+	}
+}
+
+// GetAsset serves the asset file, or its metadata with ?meta=true.
+// ?w= and ?format= (jpeg, png, webp, avif, auto) select a generated variant.
+func GetAsset(db *gorm.DB, store AssetStorage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var asset Asset
+		if err := db.Preload("Variants").First(&asset, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "ASSET_NOT_FOUND", "Asset not found", nil)
+		}
+
+		if c.QueryBool("meta") {
+			return c.JSON(fiber.Map{
+				"success": true,
+				"data":    assetResponse(asset),
+			})
+		}
+
+		width := c.QueryInt("w", 0)
+		format := c.Query("format")
+		if format != "" && format != "auto" && variantFormats[format] == "" {
+			return sendError(c, 400, "INVALID_FORMAT", "format must be one of jpeg, png, webp, avif, auto", nil)
+		}
+
+		r, contentType, size, err := openVariant(c.Context(), store, asset, width, format, c.Get("Accept"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return sendError(c, 404, "ASSET_NOT_FOUND", "Asset file is missing from storage", nil)
+			}
+			log.Printf("❌ Failed to open asset %s: %v", asset.ID, err)
+			return sendError(c, 500, "STORAGE_ERROR", "Failed to read file", nil)
+		}
+
+		// Variants are only cached briefly until processing has finished
+		c.Set("Content-Type", contentType)
+		if asset.ProcessingStatus == AssetProcessingPending || asset.ProcessingStatus == AssetProcessingProcessing {
+			c.Set("Cache-Control", "no-cache")
+		} else {
+			c.Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		if format == "auto" {
+			c.Set("Vary", "Accept")
+		}
+		c.Set("X-Content-Type-Options", "nosniff")
+		return c.SendStream(r, int(size))
+	}
+}
+
+// DeleteAsset removes an asset and its stored file
+func DeleteAsset(db *gorm.DB, store AssetStorage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var asset Asset
+		if err := db.First(&asset, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "ASSET_NOT_FOUND", "Asset not found", nil)
+		}
+
+		if err := deleteAssetVariants(db, store, asset.ID); err != nil {
+			log.Printf("❌ Failed to delete variants of asset %s: %v", asset.ID, err)
+			return sendError(c, 500, "STORAGE_ERROR", "Failed to delete file", nil)
+		}
+		if err := store.Delete(c.Context(), asset.StorageKey); err != nil {
+			log.Printf("❌ Failed to delete asset file %s: %v", asset.ID, err)
+			return sendError(c, 500, "STORAGE_ERROR", "Failed to delete file", nil)
+		}
+		if err := db.Delete(&asset).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete asset", nil)
+		}
+		recordAudit(db, c, AuditAssetDelete, asset.ID, asset.Checksum, nil, asset.Filename)
+
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: asset.ID, Deleted: true},
+		})
+	}
+}
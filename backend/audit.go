@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// AuditEvent records one mutating action. Rows are only ever inserted; the
+// hooks below refuse updates and deletes through GORM.
+type AuditEvent struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	At         int64  `gorm:"index" json:"at"`
+	Actor      string `gorm:"index" json:"actor"` // User ID, or "anonymous"
+	Role       Role   `json:"role,omitempty"`
+	IP         string `json:"ip"`
+	Action     string `gorm:"index" json:"action"`  // e.g. content.update, command.execute
+	Target     string `gorm:"index" json:"target"`  // ID of the content block, command, session, ...
+	BeforeHash string `json:"beforeHash,omitempty"` // SHA-256 of the target's state before the action
+	AfterHash  string `json:"afterHash,omitempty"`  // SHA-256 of the state after it
+	Detail     string `gorm:"type:text;serializer:encrypted" json:"detail,omitempty"`
+}
+
+// Audited actions
+const (
+	AuditContentUpdate    = "content.update"
+	AuditContentReset     = "content.reset"
+	AuditContentPublish   = "content.publish"
+	AuditContentUnpublish = "content.unpublish"
+	AuditContentImport    = "content.import"
+	AuditContentDelete    = "content.delete"
+	AuditContentRestore   = "content.restore"
+	AuditPageCreate       = "page.create"
+	AuditPageDelete       = "page.delete"
+	AuditPageUpdate       = "page.update"
+	AuditAssetDelete      = "asset.delete"
+	AuditTemplateDelete   = "template.delete"
+	AuditMenuDelete       = "menu.delete"
+	AuditSubmissionDelete = "submission.delete"
+	AuditProjectDelete    = "project.delete"
+	AuditCommandExecute   = "command.execute"
+	AuditCommandInterrupt = "command.interrupt"
+	AuditCommandUndo      = "command.undo"
+	AuditCommandMerge     = "command.merge"
+	AuditCommandDiscard   = "command.discard"
+	AuditBatchExecute     = "command.batch"
+	AuditAgentRun         = "agent.run"
+	AuditUserCreate       = "user.create"
+	AuditUserUpdate       = "user.update"
+	AuditUserDelete       = "user.delete"
+	AuditUserLogin        = "user.login"
+	AuditTeamInvite       = "team.invite"
+	AuditTeamJoin         = "team.join"
+	AuditTeamUpdate       = "team.update"
+	AuditTeamRemove       = "team.remove"
+	AuditPlanCreate       = "plan.create"
+	AuditPlanUpdate       = "plan.update"
+	AuditPlanDelete       = "plan.delete"
+	AuditPlanAssign       = "plan.assign"
+	AuditBackupCreate     = "backup.create"
+	AuditBackupRestore    = "backup.restore"
+)
+
+var errAuditAppendOnly = errors.New("audit events are append-only")
+
+func (AuditEvent) BeforeUpdate(*gorm.DB) error { return errAuditAppendOnly }
+func (AuditEvent) BeforeDelete(*gorm.DB) error { return errAuditAppendOnly }
+
+// maxAuditEvents caps one page of GET /api/admin/audit
+const maxAuditEvents = 500
+
+// auditHash fingerprints a state so changes can be verified without storing the
+// content itself. Strings are hashed as they are, other values as JSON; nil and ""
+// (nothing there) hash to "".
+func auditHash(state interface{}) string {
+	var data []byte
+	switch v := state.(type) {
+	case nil:
+		return ""
+	case string:
+		if v == "" {
+			return ""
+		}
+		data = []byte(v)
+	default:
+		data, _ = json.Marshal(v)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordAudit appends an event for the caller of c. Failures are logged but never
+// fail the action itself, which has already happened.
+func recordAudit(db *gorm.DB, c *fiber.Ctx, action, target string, before, after interface{}, detail string) {
+	event := AuditEvent{
+		At:         time.Now().Unix(),
+		Actor:      "anonymous",
+		IP:         c.IP(),
+		Action:     action,
+		Target:     target,
+		BeforeHash: auditHash(before),
+		AfterHash:  auditHash(after),
+		Detail:     detail,
+	}
+	if user := currentUser(c); user != nil {
+		event.Actor = user.ID
+	}
+	if getAuthEnabled() {
+		event.Role = currentRole(c)
+	}
+	if err := db.Create(&event).Error; err != nil {
+		log.Printf("⚠️ Failed to record audit event %s %s: %v", action, target, err)
+	}
+}
+
+// ListAuditEvents returns audit events, newest first, filtered by ?actor=, ?action=
+// (exact, or a prefix ending in "." such as "content."), ?target=, ?from= and ?to=
+// (RFC 3339 or YYYY-MM-DD). ?limit= (default 100) and ?before= (an event ID) page back.
+func ListAuditEvents(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := db.Model(&AuditEvent{}).Order("id DESC")
+
+		if actor := c.Query("actor"); actor != "" {
+			query = query.Where("actor = ?", actor)
+		}
+		if action := c.Query("action"); strings.HasSuffix(action, ".") {
+			query = query.Where("action LIKE ?", action+"%")
+		} else if action != "" {
+			query = query.Where("action = ?", action)
+		}
+		if target := c.Query("target"); target != "" {
+			query = query.Where("target = ?", target)
+		}
+		if value := c.Query("from"); value != "" {
+			from, err := parseUsageTime(value)
+			if err != nil {
+				return sendError(c, 400, "INVALID_RANGE", "from must be an RFC 3339 time or YYYY-MM-DD date", value)
+			}
+			query = query.Where("at >= ?", from.Unix())
+		}
+		if value := c.Query("to"); value != "" {
+			to, err := parseUsageTime(value)
+			if err != nil {
+				return sendError(c, 400, "INVALID_RANGE", "to must be an RFC 3339 time or YYYY-MM-DD date", value)
+			}
+			if !strings.Contains(value, "T") {
+				to = to.AddDate(0, 0, 1) // A date includes the whole day
+			}
+			query = query.Where("at < ?", to.Unix())
+		}
+		if value := c.Query("before"); value != "" {
+			before, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return sendError(c, 400, "INVALID_CURSOR", "before must be an event ID", value)
+			}
+			query = query.Where("id < ?", before)
+		}
+
+		limit := c.QueryInt("limit", 100)
+		if limit < 1 || limit > maxAuditEvents {
+			limit = maxAuditEvents
+		}
+
+		var events []AuditEvent
+		if err := query.Limit(limit).Find(&events).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load audit events", err.Error())
+		}
+
+		data := fiber.Map{"events": events}
+		if len(events) == limit {
+			data["nextBefore"] = events[len(events)-1].ID
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
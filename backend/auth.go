@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Role decides what a user may do. Each role includes the permissions of the ones below it.
+type Role string
+
+const (
+	RoleNone   Role = "none"   // Nothing outside the projects of their teams, e.g. invited clients
+	RoleViewer Role = "viewer" // Read content, pages, assets and command status
+	RoleEditor Role = "editor" // Edit content and run page-scoped AI commands, builds and deployments
+	RoleAdmin  Role = "admin"  // Global AI commands, agents, cleanup, approvals and user management
+)
+
+var roleRanks = map[Role]int{
+	RoleNone:   0,
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether r includes the permissions of required
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[required]
+}
+
+// User is someone who may call the API. Requests identify the user with an API
+// token or the access token of a session (see AuthSession); only hashes are stored.
+type User struct {
+	ID            string `gorm:"primaryKey" json:"id"`
+	Name          string `json:"name"`
+	Email         string `gorm:"index" json:"email,omitempty"`
+	Role          Role   `gorm:"index" json:"role"`
+	TokenHash     string `gorm:"uniqueIndex" json:"-"`
+	PasswordHash  string `json:"-"`                               // bcrypt; empty for accounts without a password
+	EmailVerified bool   `json:"emailVerified"`                   // Created by an admin or confirmed by an OAuth provider
+	GitHubID      string `gorm:"column:github_id;index" json:"-"` // Linked GitHub account
+	GoogleID      string `gorm:"index" json:"-"`                  // Linked Google account
+	AvatarURL     string `json:"avatarUrl,omitempty"`
+	LastLoginAt   int64  `json:"lastLoginAt,omitempty"`
+	PlanID        string `gorm:"index" json:"planId,omitempty"` // Quota plan; QUOTA_DEFAULT_PLAN when empty
+	CreatedAt     int64  `json:"createdAt"`
+	UpdatedAt     int64  `json:"updatedAt"`
+}
+
+// UserRequest is the body of POST and PUT /api/admin/users
+type UserRequest struct {
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Role      Role   `json:"role"`
+	Password  string `json:"password,omitempty"`  // Lets the user sign in with their email; at least 8 characters
+	Invite    bool   `json:"invite,omitempty"`    // POST only: email the token to the user
+	ProjectID string `json:"projectId,omitempty"` // Project whose email settings send the invite
+}
+
+// bootstrapAdminID is the user created from AUTH_ADMIN_TOKEN
+const bootstrapAdminID = "admin"
+
+// getAuthEnabled reports whether requests must carry a user token (authEnabled, AUTH_ENABLED)
+// Falls back to false: every caller acts as an admin, as before roles existed
+func getAuthEnabled() bool {
+	return appConfig.AuthEnabled
+}
+
+// getAnonymousRole returns the role of requests without a token from AUTH_ANONYMOUS_ROLE
+// Falls back to none: such requests are rejected with 401
+func getAnonymousRole() Role {
+	return Role(os.Getenv("AUTH_ANONYMOUS_ROLE"))
+}
+
+// hashToken returns the stored form of an API token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken generates an API token; it is only shown once, when created
+func newToken() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "se_" + hex.EncodeToString(raw), nil
+}
+
+// ensureBootstrapAdmin creates or updates the "admin" user from AUTH_ADMIN_TOKEN, so
+// the first admin can sign in and create the other users
+func ensureBootstrapAdmin(db *gorm.DB) {
+	token := os.Getenv("AUTH_ADMIN_TOKEN")
+	if token == "" {
+		if getAuthEnabled() {
+			var admins int64
+			db.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins)
+			if admins == 0 {
+				log.Printf("⚠️ AUTH_ENABLED is set but there is no admin user; set AUTH_ADMIN_TOKEN to create one")
+			}
+		}
+		return
+	}
+
+	now := time.Now().Unix()
+	admin := User{ID: bootstrapAdminID, Name: "Administrator", Role: RoleAdmin, CreatedAt: now}
+	err := db.Where(User{ID: bootstrapAdminID}).Attrs(admin).
+		Assign(User{Role: RoleAdmin, TokenHash: hashToken(token), UpdatedAt: now}).
+		FirstOrCreate(&admin).Error
+	if err != nil {
+		log.Printf("⚠️ Failed to create admin user from AUTH_ADMIN_TOKEN: %v", err)
+	}
+}
+
+// currentUser returns the user who made the request, or nil without auth or for anonymous requests
+func currentUser(c *fiber.Ctx) *User {
+	user, _ := c.Locals("user").(*User)
+	return user
+}
+
+// currentRole returns the role the request runs with
+func currentRole(c *fiber.Ctx) Role {
+	if role, ok := c.Locals("role").(Role); ok {
+		return role
+	}
+	return RoleAdmin // Auth disabled
+}
+
+// currentProjectRole returns the role the request runs with on the project of its route,
+// which a team role can raise above currentRole
+func currentProjectRole(c *fiber.Ctx) Role {
+	if role, ok := c.Locals("projectRole").(Role); ok {
+		return role
+	}
+	return currentRole(c)
+}
+
+// currentUserID returns the ID of the signed-in user, or "" for anonymous requests and
+// the admin token
+func currentUserID(c *fiber.Ctx) string {
+	if user := currentUser(c); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// ownsOrAdmin reports whether the request may act on something started by ownerID:
+// its owner and admins may, and with auth disabled everyone is an admin
+func ownsOrAdmin(c *fiber.Ctx, ownerID string) bool {
+	return currentUserID(c) == ownerID || currentRole(c).Allows(RoleAdmin)
+}
+
+// requestToken reads the API token from the Authorization header, or from ?token= for
+// clients that cannot set headers (WebSocket, EventSource, iframes). The query parameter
+// is removed so it does not reach logs or proxied preview servers.
+func requestToken(c *fiber.Ctx) string {
+	if header := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	token := c.Query("token")
+	if token != "" {
+		c.Request().URI().QueryArgs().Del("token")
+	}
+	return token
+}
+
+func forbidden(c *fiber.Ctx, required Role) error {
+	return sendError(c, 403, "FORBIDDEN", "This action requires the "+string(required)+" role", "role: "+string(currentRole(c)))
+}
+
+// accountRoutes are read by every signed-in user, whatever their role, so that users
+// with the none role see their account and teams
+var accountRoutes = map[string]bool{"/api/me": true, "/api/auth/me": true}
+
+// Authenticate resolves the user of every request. With AUTH_ENABLED set, reads need
+// at least the viewer role and every other method the editor role; routes that need
+// more add RequireRole. Without it everyone acts as an admin, but a token still tells
+// who the caller is.
+func Authenticate(db *gorm.DB) fiber.Handler {
+	enabled := getAuthEnabled()
+	anonymous := getAnonymousRole()
+
+	return func(c *fiber.Ctx) error {
+		if c.Method() == fiber.MethodOptions {
+			return c.Next()
+		}
+		if !enabled {
+			// Only expired or unknown access tokens are reported, so the client refreshes them
+			if token := requestToken(c); token != "" {
+				user, session, err := userForToken(db, token)
+				if err != nil && strings.HasPrefix(token, accessTokenPrefix) {
+					return err
+				}
+				if user != nil {
+					c.Locals("user", user)
+					c.Locals("authSession", session)
+				}
+			}
+			return c.Next()
+		}
+
+		role := anonymous
+		if token := requestToken(c); token != "" {
+			user, session, err := userForToken(db, token)
+			if err != nil {
+				return err
+			}
+			c.Locals("user", user)
+			c.Locals("authSession", session)
+			role = user.Role
+		} else if token := c.Query(streamTokenQuery); token != "" {
+			user, err := authenticateStreamToken(c, db, token)
+			if err != nil {
+				return sendError(c, 401, "INVALID_STREAM_TOKEN", "The stream token is not valid for this stream", err.Error())
+			}
+			if user != nil {
+				c.Locals("user", user)
+				role = user.Role
+			}
+		} else if !anonymous.Allows(RoleViewer) {
+			return sendError(c, 401, "AUTH_REQUIRED", "An API token is required", "Send Authorization: Bearer <token>")
+		}
+		c.Locals("role", role)
+
+		// Team members act with their team role on the routes of its project. The role
+		// only counts for this request's project: RequireRole keeps checking the user's own.
+		if user := currentUser(c); user != nil {
+			if projectID, ok := requestProject(c, db); ok && projectID != "" {
+				role = higherRole(role, teamRole(db, projectID, user.ID))
+				c.Locals("projectRole", role)
+			}
+		}
+
+		required := RoleEditor
+		if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+			required = RoleViewer
+			if currentUser(c) != nil && accountRoutes[c.Path()] {
+				required = RoleNone
+			}
+		}
+		if !role.Allows(required) {
+			return forbidden(c, required)
+		}
+		return c.Next()
+	}
+}
+
+// RequireRole rejects requests whose user lacks the given role
+func RequireRole(required Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !currentRole(c).Allows(required) {
+			return forbidden(c, required)
+		}
+		return c.Next()
+	}
+}
+
+// RequireProjectRole rejects requests whose user lacks the given role on the project of
+// the route, as their own role or their role on the project's team
+func RequireProjectRole(required Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !currentProjectRole(c).Allows(required) {
+			return forbidden(c, required)
+		}
+		return c.Next()
+	}
+}
+
+// RequireScopeRole lets only admins submit AI commands with the global scope;
+// other scopes are limited to a single page or block
+func RequireScopeRole() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			Scope string `json:"scope"`
+		}
+		if c.BodyParser(&body) == nil && body.Scope == "global" && !currentRole(c).Allows(RoleAdmin) {
+			return forbidden(c, RoleAdmin)
+		}
+		return c.Next()
+	}
+}
+
+// countAdmins is used to keep at least one admin around
+func countAdmins(db *gorm.DB) (int64, error) {
+	var admins int64
+	err := db.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error
+	return admins, err
+}
+
+// ListUsers returns every user
+func ListUsers(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var users []User
+		if err := db.Order("created_at").Find(&users).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load users", err.Error())
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    users,
+		})
+	}
+}
+
+// inviteEmail tells a new user their token. editorURL, the page the admin created the
+// user from, is left out when unknown.
+func inviteEmail(user User, token, editorURL string) EmailMessage {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Hello %s,\n\nYou have been given %s access to the site editor.\n\n", user.Name, user.Role)
+	if editorURL != "" {
+		fmt.Fprintf(&text, "Open %s and sign in with this API token:\n\n", editorURL)
+	} else {
+		text.WriteString("Sign in with this API token:\n\n")
+	}
+	fmt.Fprintf(&text, "    %s\n\nKeep it secret: anyone with the token can act as you. Ask an admin for a new one if it leaks.\n", token)
+	return EmailMessage{
+		To:      []string{user.Email},
+		Subject: "Your site editor access",
+		Text:    text.String(),
+	}
+}
+
+// CreateUser adds a user and returns their API token. The token cannot be read again
+// later. With invite set, the token is also emailed to the user.
+func CreateUser(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req UserRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			return sendError(c, 400, "MISSING_NAME", "name is required", "")
+		}
+		if !req.Role.Valid() {
+			return sendError(c, 400, "INVALID_ROLE", "role must be none, viewer, editor or admin", string(req.Role))
+		}
+		if req.Invite {
+			if _, err := mail.ParseAddress(req.Email); err != nil {
+				return sendError(c, 400, "INVALID_EMAIL", "invite needs a valid email", req.Email)
+			}
+			if req.ProjectID != "" {
+				if err := db.First(&Project{}, "id = ?", req.ProjectID).Error; err != nil {
+					return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", req.ProjectID)
+				}
+			}
+		}
+		if err := checkAccountEmail(db, req.Email, ""); err != nil {
+			return sendError(c, 409, "EMAIL_TAKEN", err.Error(), req.Email)
+		}
+		passwordHash, err := userPasswordHash(req)
+		if err != nil {
+			return sendError(c, 400, "INVALID_PASSWORD", err.Error(), "")
+		}
+
+		token, err := newToken()
+		if err != nil {
+			return sendError(c, 500, "TOKEN_ERROR", "Failed to generate token", err.Error())
+		}
+		now := time.Now().Unix()
+		user := User{
+			ID:            "usr_" + uuid.New().String()[:8],
+			Name:          req.Name,
+			Email:         strings.TrimSpace(req.Email),
+			Role:          req.Role,
+			TokenHash:     hashToken(token),
+			PasswordHash:  passwordHash,
+			EmailVerified: req.Email != "", // The admin vouches for the address
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := db.Create(&user).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create user", err.Error())
+		}
+
+		log.Printf("👤 Created %s user %s (%s)", user.Role, user.ID, user.Name)
+		recordAudit(db, c, AuditUserCreate, user.ID, nil, user, string(user.Role))
+
+		data := fiber.Map{
+			"user":  user,
+			"token": token,
+		}
+		if req.Invite {
+			// The user exists either way; a failed invite is reported for the admin to pass the token on
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			err := sendEmail(ctx, db, req.ProjectID, inviteEmail(user, token, c.Get(fiber.HeaderOrigin)))
+			if err != nil {
+				log.Printf("⚠️ Failed to email the invite of user %s: %v", user.ID, err)
+				data["inviteError"] = err.Error()
+			} else {
+				log.Printf("✉️ Emailed the invite of user %s", user.ID)
+			}
+			data["invited"] = err == nil
+		}
+		return c.Status(201).JSON(fiber.Map{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
+
+// loadUser fetches the user named by :id, answering 404 itself when it does not exist
+func loadUser(c *fiber.Ctx, db *gorm.DB) (*User, error) {
+	var user User
+	if err := db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, sendError(c, 404, "USER_NOT_FOUND", "User not found", c.Params("id"))
+		}
+		return nil, sendError(c, 500, "DATABASE_ERROR", "Failed to load user", err.Error())
+	}
+	return &user, nil
+}
+
+// checkAccountEmail rejects an email address another account signs in with
+func checkAccountEmail(db *gorm.DB, email, userID string) error {
+	if strings.TrimSpace(email) == "" {
+		return nil
+	}
+	existing, err := userByEmail(db, email)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != userID {
+		return fmt.Errorf("user %s already has this email", existing.ID)
+	}
+	return nil
+}
+
+// userPasswordHash hashes the password of a user request, or returns "" without one
+func userPasswordHash(req UserRequest) (string, error) {
+	if req.Password == "" {
+		return "", nil
+	}
+	if len(req.Password) < 8 || len(req.Password) > 72 {
+		return "", errors.New("password must be 8 to 72 characters")
+	}
+	return hashPassword(req.Password)
+}
+
+// UpdateUser changes a user's name, email, role or password. A new password signs the
+// user out of their sessions.
+func UpdateUser(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req UserRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if req.Role != "" && !req.Role.Valid() {
+			return sendError(c, 400, "INVALID_ROLE", "role must be none, viewer, editor or admin", string(req.Role))
+		}
+
+		user, err := loadUser(c, db)
+		if user == nil {
+			return err
+		}
+		if user.Role == RoleAdmin && req.Role != "" && req.Role != RoleAdmin {
+			if admins, err := countAdmins(db); err != nil || admins <= 1 {
+				return sendError(c, 409, "LAST_ADMIN", "The last admin cannot be demoted", user.ID)
+			}
+		}
+		if err := checkAccountEmail(db, req.Email, user.ID); err != nil {
+			return sendError(c, 409, "EMAIL_TAKEN", err.Error(), req.Email)
+		}
+		passwordHash, err := userPasswordHash(req)
+		if err != nil {
+			return sendError(c, 400, "INVALID_PASSWORD", err.Error(), "")
+		}
+
+		before := *user
+		if name := strings.TrimSpace(req.Name); name != "" {
+			user.Name = name
+		}
+		if req.Email != "" && !strings.EqualFold(strings.TrimSpace(req.Email), user.Email) {
+			user.Email = strings.TrimSpace(req.Email)
+			user.EmailVerified = true
+		}
+		if req.Role != "" {
+			user.Role = req.Role
+		}
+		if passwordHash != "" {
+			user.PasswordHash = passwordHash
+		}
+		user.UpdatedAt = time.Now().Unix()
+		if err := db.Save(user).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update user", err.Error())
+		}
+		if passwordHash != "" {
+			db.Where("user_id = ?", user.ID).Delete(&AuthSession{})
+		}
+		recordAudit(db, c, AuditUserUpdate, user.ID, before, user, string(user.Role))
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    user,
+		})
+	}
+}
+
+// RotateUserToken replaces a user's API token; the old one stops working at once
+func RotateUserToken(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := loadUser(c, db)
+		if user == nil {
+			return err
+		}
+		token, err := newToken()
+		if err != nil {
+			return sendError(c, 500, "TOKEN_ERROR", "Failed to generate token", err.Error())
+		}
+		err = db.Model(user).Updates(map[string]interface{}{
+			"token_hash": hashToken(token),
+			"updated_at": time.Now().Unix(),
+		}).Error
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update user", err.Error())
+		}
+		recordAudit(db, c, AuditUserUpdate, user.ID, nil, nil, "token rotated")
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"user":  user,
+				"token": token,
+			},
+		})
+	}
+}
+
+// DeleteUser removes a user and their team memberships; their token and sessions stop
+// working at once
+func DeleteUser(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := loadUser(c, db)
+		if user == nil {
+			return err
+		}
+		if user.Role == RoleAdmin {
+			if admins, err := countAdmins(db); err != nil || admins <= 1 {
+				return sendError(c, 409, "LAST_ADMIN", "The last admin cannot be deleted", user.ID)
+			}
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("user_id = ?", user.ID).Delete(&AuthSession{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&TeamMember{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(user).Error
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete user", err.Error())
+		}
+
+		log.Printf("👤 Deleted user %s (%s)", user.ID, user.Name)
+		recordAudit(db, c, AuditUserDelete, user.ID, user, nil, user.Name)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "User deleted",
+		})
+	}
+}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// backupVersion is the layout of the backup archives this build writes
+const backupVersion = 1
+
+// BackupRequest is the optional body of POST /api/admin/backup
+type BackupRequest struct {
+	Upload bool `json:"upload"` // Also upload the backup to the BACKUP_S3_* bucket
+}
+
+// BackupManifest describes the contents of a backup archive (manifest.json)
+type BackupManifest struct {
+	Version    int               `json:"version"`
+	CreatedAt  int64             `json:"createdAt"`
+	Driver     string            `json:"driver"`
+	Database   bool              `json:"database"`   // database.db holds a copy of the database (SQLite only)
+	Migrations []string          `json:"migrations"` // Migrations applied to the database when it was copied
+	Workspaces []BackupWorkspace `json:"workspaces"`
+	Assets     bool              `json:"assets"` // assets.tar.gz holds the uploads of disk asset storage
+}
+
+// BackupWorkspace is a workspace archived in a backup
+type BackupWorkspace struct {
+	ProjectID string `json:"projectId,omitempty"` // Empty for the global workspace
+	Path      string `json:"path"`                // Directory of the workspace when it was backed up
+	Archive   string `json:"archive"`             // Path of the archive within the backup
+}
+
+// BackupInfo describes a backup kept in BACKUP_DIR
+type BackupInfo struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	CreatedAt   int64  `json:"createdAt"`
+	DownloadURL string `json:"downloadUrl"`
+	S3Key       string `json:"s3Key,omitempty"` // Set when the backup was uploaded
+}
+
+// RestorePlan reports what a restore changed, or would change in a dry run
+type RestorePlan struct {
+	Backup     string             `json:"backup"`
+	DryRun     bool               `json:"dryRun"`
+	Manifest   BackupManifest     `json:"manifest"`
+	Tables     []RestoreTable     `json:"tables,omitempty"`
+	Workspaces []RestoreWorkspace `json:"workspaces,omitempty"`
+	Assets     *RestoreWorkspace  `json:"assets,omitempty"`
+	Warnings   []string           `json:"warnings,omitempty"`
+	RestoredAt int64              `json:"restoredAt,omitempty"`
+}
+
+// RestoreTable compares a table of the backup with the live one
+type RestoreTable struct {
+	Name    string `json:"name"`
+	Rows    int64  `json:"rows"`    // Rows in the backup, which replace the live ones
+	Current int64  `json:"current"` // Rows in the live table
+}
+
+// RestoreWorkspace reports the files of a directory that a restore writes back and deletes
+type RestoreWorkspace struct {
+	ProjectID string `json:"projectId,omitempty"`
+	Path      string `json:"path"`
+	Files     int    `json:"files"`   // Files written back from the backup
+	Deleted   int    `json:"deleted"` // Files that are not in the backup
+}
+
+// getBackupDir returns where backups are kept (backup.dir, BACKUP_DIR)
+// Falls back to ./backups
+func getBackupDir() string {
+	return appConfig.Backup.Dir
+}
+
+// getBackupKeep returns how many backups are kept (backup.keep, BACKUP_KEEP); 0 keeps all of them
+// Falls back to 10
+func getBackupKeep() int {
+	return appConfig.Backup.Keep
+}
+
+// getBackupInterval returns how often a backup is made (backup.interval, BACKUP_INTERVAL)
+// Falls back to 0, which leaves backups to POST /api/admin/backup
+func getBackupInterval() time.Duration {
+	return appConfig.Backup.Interval.Duration()
+}
+
+// backupSkipDirs are left out of workspace backups. Unlike snapshots, backups keep the
+// git history of the workspace.
+var backupSkipDirs = map[string]bool{
+	"node_modules": true,
+	".next":        true,
+	resultFileDir:  true,
+}
+
+// restoreSkipTables keep their live rows on a restore. The schema stays that of this
+// build, and the audit trail also records the restore itself.
+var restoreSkipTables = map[string]bool{
+	"schema_migrations": true,
+	"audit_events":      true,
+}
+
+// backupNamePattern matches the names of backup archives
+var backupNamePattern = regexp.MustCompile(`^backup_\d+_[0-9a-f]{8}\.tar\.gz$`)
+
+// backupMu keeps backups and restores from running at the same time
+var backupMu sync.Mutex
+
+func isSQLiteDriver(driver string) bool {
+	return driver == "sqlite" || driver == "sqlite3"
+}
+
+// createBackup archives the database, the workspaces and the disk assets into one
+// file in BACKUP_DIR, uploads it when asked to and prunes old backups
+func createBackup(db *gorm.DB, upload bool) (BackupInfo, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	if err := os.MkdirAll(getBackupDir(), 0755); err != nil {
+		return BackupInfo{}, err
+	}
+	tmp, err := os.MkdirTemp(getBackupDir(), ".backup-")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	now := time.Now()
+	manifest := BackupManifest{Version: backupVersion, CreatedAt: now.Unix(), Driver: getDBDriver()}
+	if manifest.Migrations, err = appliedMigrations(db); err != nil {
+		return BackupInfo{}, err
+	}
+
+	// Other databases are left to their own dump tools
+	if isSQLiteDriver(manifest.Driver) && !isMemorySQLite(getDatabaseURL()) {
+		if err := db.Exec("VACUUM INTO ?", filepath.Join(tmp, "database.db")).Error; err != nil {
+			return BackupInfo{}, fmt.Errorf("failed to copy the database: %w", err)
+		}
+		manifest.Database = true
+	}
+
+	// The global workspace and the workspaces of projects that have one of their own
+	workspaces := []BackupWorkspace{{Path: getWorkspaceDir(), Archive: "workspaces/global.tar.gz"}}
+	var projects []Project
+	if err := db.Select("id", "workspace_path").Find(&projects).Error; err != nil {
+		return BackupInfo{}, err
+	}
+	for _, project := range projects {
+		if project.WorkspacePath == "" || slices.ContainsFunc(workspaces, func(w BackupWorkspace) bool { return w.Path == project.WorkspacePath }) {
+			continue
+		}
+		workspaces = append(workspaces, BackupWorkspace{
+			ProjectID: project.ID,
+			Path:      project.WorkspacePath,
+			Archive:   "workspaces/" + sandboxNameUnsafe.ReplaceAllString(project.ID, "_") + ".tar.gz",
+		})
+	}
+	for _, workspace := range workspaces {
+		if info, err := os.Stat(workspace.Path); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := archiveDir(workspace.Path, filepath.Join(tmp, filepath.FromSlash(workspace.Archive)), backupSkipDirs); err != nil {
+			return BackupInfo{}, fmt.Errorf("failed to archive workspace %s: %w", workspace.Path, err)
+		}
+		manifest.Workspaces = append(manifest.Workspaces, workspace)
+	}
+
+	if dir, ok := diskAssetDir(); ok {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			if err := archiveDir(dir, filepath.Join(tmp, "assets.tar.gz"), nil); err != nil {
+				return BackupInfo{}, fmt.Errorf("failed to archive assets: %w", err)
+			}
+			manifest.Assets = true
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "manifest.json"), data, 0644); err != nil {
+		return BackupInfo{}, err
+	}
+
+	name := fmt.Sprintf("backup_%d_%s.tar.gz", now.Unix(), uuid.New().String()[:8])
+	path := filepath.Join(getBackupDir(), name)
+	if err := archiveDir(tmp, path, nil); err != nil {
+		return BackupInfo{}, err
+	}
+	info, err := backupInfo(name)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	log.Printf("💾 Backup %s created (%d bytes)", name, info.Size)
+	pruneBackups()
+
+	if upload {
+		if info.S3Key, err = uploadBackup(path, name); err != nil {
+			return info, fmt.Errorf("backup %s was created but the upload failed: %w", name, err)
+		}
+		log.Printf("💾 Backup %s uploaded to %s", name, info.S3Key)
+	}
+	return info, nil
+}
+
+// diskAssetDir returns the directory of disk asset storage, if assets are kept on disk
+func diskAssetDir() (string, bool) {
+	if appConfig.Assets.Storage != "disk" {
+		return "", false
+	}
+	return appConfig.Assets.Dir, true
+}
+
+// uploadBackup copies a backup to the bucket of the BACKUP_S3_* variables and returns its key
+func uploadBackup(path, name string) (string, error) {
+	store, err := newS3Storage("BACKUP_S3")
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if err := store.Put(context.Background(), name, f, info.Size(), "application/gzip"); err != nil {
+		return "", err
+	}
+	return store.object(name), nil
+}
+
+// backupInfo describes the backup with the given name
+func backupInfo(name string) (BackupInfo, error) {
+	info, err := os.Stat(filepath.Join(getBackupDir(), name))
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	createdAt, _ := strconv.ParseInt(strings.Split(name, "_")[1], 10, 64)
+	return BackupInfo{
+		Name:        name,
+		Size:        info.Size(),
+		CreatedAt:   createdAt,
+		DownloadURL: "/api/admin/backups/" + name,
+	}, nil
+}
+
+// listBackups returns the backups in BACKUP_DIR, newest first
+func listBackups() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(getBackupDir())
+	if os.IsNotExist(err) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !backupNamePattern.MatchString(entry.Name()) {
+			continue
+		}
+		if info, err := backupInfo(entry.Name()); err == nil {
+			backups = append(backups, info)
+		}
+	}
+	slices.SortFunc(backups, func(a, b BackupInfo) int {
+		if a.CreatedAt != b.CreatedAt {
+			return int(b.CreatedAt - a.CreatedAt)
+		}
+		return strings.Compare(b.Name, a.Name)
+	})
+	return backups, nil
+}
+
+// pruneBackups deletes all but the newest BACKUP_KEEP backups. Uploaded copies are left
+// to the lifecycle rules of the bucket.
+func pruneBackups() {
+	keep := getBackupKeep()
+	backups, err := listBackups()
+	if err != nil || keep == 0 || len(backups) <= keep {
+		return
+	}
+	for _, backup := range backups[keep:] {
+		if err := os.Remove(filepath.Join(getBackupDir(), backup.Name)); err != nil {
+			log.Printf("⚠️ Failed to remove backup %s: %v", backup.Name, err)
+		}
+	}
+}
+
+// StartBackupScheduler makes a backup every BACKUP_INTERVAL, uploaded when BACKUP_S3_BUCKET
+// is set
+func StartBackupScheduler(db *gorm.DB) {
+	interval := getBackupInterval()
+	if interval <= 0 {
+		return
+	}
+	upload := os.Getenv("BACKUP_S3_BUCKET") != ""
+
+	log.Printf("💾 Backups scheduled every %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if shuttingDown.Load() {
+			return
+		}
+		if _, err := createBackup(db, upload); err != nil {
+			log.Printf("❌ Scheduled backup failed: %v", err)
+		}
+	}
+}
+
+// openBackup unpacks a backup archive into a temporary directory, which the caller
+// removes, and reads its manifest
+func openBackup(archive string) (string, BackupManifest, error) {
+	var manifest BackupManifest
+	dir, err := os.MkdirTemp(getBackupDir(), ".restore-")
+	if err != nil {
+		return "", manifest, err
+	}
+	if err := extractArchive(archive, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", manifest, fmt.Errorf("failed to unpack the backup: %w", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", manifest, fmt.Errorf("the archive has no valid manifest.json: %w", err)
+	}
+	return dir, manifest, nil
+}
+
+// restoreBackup replaces the database rows, the workspaces and the disk assets with those
+// of an unpacked backup. A dry run only reports what would change.
+func restoreBackup(db *gorm.DB, dir string, manifest BackupManifest, dryRun bool) (RestorePlan, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	plan := RestorePlan{DryRun: dryRun, Manifest: manifest}
+	for _, m := range pendingMigrations(manifest.Migrations) {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("The backup predates migration %s (%s); columns it adds get their defaults", m.ID, m.Description))
+	}
+
+	if manifest.Database {
+		if !isSQLiteDriver(getDBDriver()) {
+			plan.Warnings = append(plan.Warnings, "The database was not restored: the backup holds a SQLite database and this server uses "+getDBDriver())
+		} else {
+			tables, err := restoreTables(db, filepath.Join(dir, "database.db"), dryRun)
+			if err != nil {
+				return plan, fmt.Errorf("failed to restore the database: %w", err)
+			}
+			plan.Tables = tables
+		}
+	} else {
+		plan.Warnings = append(plan.Warnings, "The backup holds no copy of the "+manifest.Driver+" database; restore it from a dump of its own")
+	}
+
+	// Workspaces go where the restored projects keep them
+	for _, workspace := range manifest.Workspaces {
+		target, err := resolveWorkspaceDir(db, workspace.ProjectID)
+		if err != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("The workspace of project %s was not restored: %v", workspace.ProjectID, err))
+			continue
+		}
+		restored, err := restoreDir(filepath.Join(dir, filepath.FromSlash(workspace.Archive)), target, backupSkipDirs, dryRun)
+		if err != nil {
+			return plan, fmt.Errorf("failed to restore workspace %s: %w", target, err)
+		}
+		restored.ProjectID = workspace.ProjectID
+		plan.Workspaces = append(plan.Workspaces, restored)
+	}
+
+	if manifest.Assets {
+		if assetDir, ok := diskAssetDir(); ok {
+			restored, err := restoreDir(filepath.Join(dir, "assets.tar.gz"), assetDir, nil, dryRun)
+			if err != nil {
+				return plan, fmt.Errorf("failed to restore assets: %w", err)
+			}
+			plan.Assets = &restored
+		} else {
+			plan.Warnings = append(plan.Warnings, "The assets were not restored: the backup holds disk assets and this server uses "+appConfig.Assets.Storage+" storage")
+		}
+	}
+
+	if !dryRun {
+		plan.RestoredAt = time.Now().Unix()
+	}
+	return plan, nil
+}
+
+// restoreDir puts a directory back to an archive, see restoreWorkspace, or only counts
+// the files in a dry run
+func restoreDir(archive, dir string, skipDirs map[string]bool, dryRun bool) (RestoreWorkspace, error) {
+	result := RestoreWorkspace{Path: dir}
+	if !dryRun {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return result, err
+		}
+		var err error
+		result.Files, result.Deleted, err = restoreWorkspace(archive, dir, skipDirs)
+		return result, err
+	}
+
+	files, err := archivedFiles(archive)
+	if err != nil {
+		return result, err
+	}
+	result.Files = len(files)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return result, nil
+	}
+	stale, err := staleFiles(dir, files, skipDirs)
+	result.Deleted = len(stale)
+	return result, err
+}
+
+// restoreTables replaces the rows of the live tables with those of a backed up SQLite
+// database in one transaction. Columns the backup doesn't have get their defaults, and
+// tables it doesn't have are emptied. The search triggers keep the full-text index of
+// the contents in step.
+func restoreTables(db *gorm.DB, path string, dryRun bool) ([]RestoreTable, error) {
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	names, err := db.Migrator().GetTables()
+	if err != nil {
+		return nil, err
+	}
+	var backupNames []string
+	if err := queryStrings(src, &backupNames, "SELECT name FROM sqlite_master WHERE type = 'table'"); err != nil {
+		return nil, err
+	}
+
+	var tables []RestoreTable
+	for _, name := range names {
+		if restoreSkipTables[name] || strings.HasPrefix(name, "sqlite_") || strings.HasPrefix(name, "content_fts") {
+			continue
+		}
+		table := RestoreTable{Name: name}
+		if err := db.Table(name).Count(&table.Current).Error; err != nil {
+			return nil, err
+		}
+		if slices.Contains(backupNames, name) {
+			if err := src.QueryRow("SELECT COUNT(*) FROM " + quoteIdent(name)).Scan(&table.Rows); err != nil {
+				return nil, err
+			}
+		}
+		tables = append(tables, table)
+	}
+	if dryRun {
+		return tables, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range tables {
+			if err := tx.Exec("DELETE FROM " + quoteIdent(table.Name)).Error; err != nil {
+				return err
+			}
+			if !slices.Contains(backupNames, table.Name) {
+				continue
+			}
+			if err := copyRows(tx, src, table.Name); err != nil {
+				return fmt.Errorf("table %s: %w", table.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// copyRows inserts the rows of a backed up table into the live one, copying the columns
+// both of them have
+func copyRows(tx *gorm.DB, src *sql.DB, table string) error {
+	liveColumns, err := tx.Migrator().ColumnTypes(table)
+	if err != nil {
+		return err
+	}
+	probe, err := src.Query("SELECT * FROM " + quoteIdent(table) + " LIMIT 0")
+	if err != nil {
+		return err
+	}
+	backupColumns, err := probe.Columns()
+	probe.Close()
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for _, column := range liveColumns {
+		if slices.Contains(backupColumns, column.Name()) {
+			columns = append(columns, quoteIdent(column.Name()))
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	rows, err := src.Query("SELECT " + strings.Join(columns, ", ") + " FROM " + quoteIdent(table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Stay below the 999 variables older SQLite builds allow in a statement
+	batchSize := max(1, 900/len(columns))
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	insert := "INSERT INTO " + quoteIdent(table) + " (" + strings.Join(columns, ", ") + ") VALUES "
+	var args []interface{}
+	count := 0
+	flush := func() error {
+		if count == 0 {
+			return nil
+		}
+		values := strings.TrimSuffix(strings.Repeat(placeholders+", ", count), ", ")
+		err := tx.Exec(insert+values, args...).Error
+		args, count = args[:0], 0
+		return err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		args = append(args, values...)
+		if count++; count == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// quoteIdent quotes a table or column name for SQLite
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func queryStrings(db *sql.DB, dest *[]string, query string) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return err
+		}
+		*dest = append(*dest, s)
+	}
+	return rows.Err()
+}
+
+// commandsRunning reports whether an AI command is running, which a restore would
+// pull the workspace and the database out from under
+func commandsRunning() bool {
+	commandMu.RLock()
+	defer commandMu.RUnlock()
+	for _, session := range commandSessions {
+		session.mu.RLock()
+		processing := session.isProcessing
+		session.mu.RUnlock()
+		if processing {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateBackup archives the database, the workspaces and the disk assets. With
+// {"upload": true} the backup is also uploaded to the BACKUP_S3_* bucket.
+func CreateBackup(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req BackupRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+			}
+		}
+
+		info, err := createBackup(db, req.Upload)
+		if err != nil {
+			log.Printf("❌ Backup failed: %v", err)
+			if info.Name != "" {
+				return sendError(c, 502, "BACKUP_UPLOAD_FAILED", "The backup was created but could not be uploaded", err.Error())
+			}
+			return sendError(c, 500, "BACKUP_FAILED", "Failed to create the backup", err.Error())
+		}
+		recordAudit(db, c, AuditBackupCreate, info.Name, nil, nil, info.S3Key)
+		return c.Status(201).JSON(APIResponse[BackupInfo]{Success: true, Data: info})
+	}
+}
+
+// ListBackups returns the backups kept in BACKUP_DIR, newest first
+func ListBackups() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		backups, err := listBackups()
+		if err != nil {
+			return sendError(c, 500, "BACKUP_DIR_UNREADABLE", "Failed to list backups", err.Error())
+		}
+		return c.JSON(APIResponse[[]BackupInfo]{Success: true, Data: backups})
+	}
+}
+
+// DownloadBackup sends a backup archive
+func DownloadBackup() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		if !backupNamePattern.MatchString(name) {
+			return sendError(c, 400, "INVALID_BACKUP_NAME", "Invalid backup name", "")
+		}
+		path := filepath.Join(getBackupDir(), name)
+		if _, err := os.Stat(path); err != nil {
+			return sendError(c, 404, "BACKUP_NOT_FOUND", "Backup not found", "")
+		}
+		return c.Download(path, name)
+	}
+}
+
+// RestoreBackup restores a backup, either one in BACKUP_DIR given by ?name= or an
+// archive uploaded as the multipart field "file". With ?dryRun=true it only reports
+// what would change.
+func RestoreBackup(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		dryRun := c.QueryBool("dryRun", false)
+
+		var archive, name string
+		if header, err := c.FormFile("file"); err == nil {
+			if err := os.MkdirAll(getBackupDir(), 0755); err != nil {
+				return sendError(c, 500, "RESTORE_FAILED", "Failed to store the uploaded backup", err.Error())
+			}
+			upload, err := os.CreateTemp(getBackupDir(), ".upload-*.tar.gz")
+			if err != nil {
+				return sendError(c, 500, "RESTORE_FAILED", "Failed to store the uploaded backup", err.Error())
+			}
+			upload.Close()
+			defer os.Remove(upload.Name())
+			if err := c.SaveFile(header, upload.Name()); err != nil {
+				return sendError(c, 500, "RESTORE_FAILED", "Failed to store the uploaded backup", err.Error())
+			}
+			archive, name = upload.Name(), header.Filename
+		} else if name = c.Query("name"); name != "" {
+			if !backupNamePattern.MatchString(name) {
+				return sendError(c, 400, "INVALID_BACKUP_NAME", "Invalid backup name", "")
+			}
+			archive = filepath.Join(getBackupDir(), name)
+			if _, err := os.Stat(archive); err != nil {
+				return sendError(c, 404, "BACKUP_NOT_FOUND", "Backup not found", "")
+			}
+		} else {
+			return sendError(c, 400, "INVALID_REQUEST", "Upload a backup as \"file\" or name one with ?name=", "")
+		}
+
+		dir, manifest, err := openBackup(archive)
+		if err != nil {
+			return sendError(c, 400, "INVALID_BACKUP", "The file is not a valid backup", err.Error())
+		}
+		defer os.RemoveAll(dir)
+		if manifest.Version > backupVersion {
+			return sendError(c, 409, "BACKUP_TOO_NEW", "The backup was made by a newer version", manifest.Version)
+		}
+		if unknown := unknownMigrations(manifest.Migrations); len(unknown) > 0 {
+			return sendError(c, 409, "BACKUP_TOO_NEW", "The backup has migrations this version doesn't know", unknown)
+		}
+		if !dryRun && commandsRunning() {
+			return sendError(c, 409, "COMMANDS_RUNNING", "Wait for the running commands to finish before restoring", "")
+		}
+
+		plan, err := restoreBackup(db, dir, manifest, dryRun)
+		plan.Backup = name
+		if err != nil {
+			log.Printf("❌ Restore of backup %s failed: %v", name, err)
+			return sendError(c, 500, "RESTORE_FAILED", "Failed to restore the backup", err.Error())
+		}
+		if !dryRun {
+			log.Printf("♻️ Restored backup %s (%d table(s), %d workspace(s))", name, len(plan.Tables), len(plan.Workspaces))
+			recordAudit(db, c, AuditBackupRestore, name, nil, nil, strings.Join(plan.Warnings, "\n"))
+		}
+		return c.JSON(APIResponse[RestorePlan]{Success: true, Data: plan})
+	}
+}
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BatchCommand runs one prompt as a separate AICommand per page or content block.
+// Progress and the report are derived from the child commands (AICommand.BatchID).
+type BatchCommand struct {
+	ID          string `gorm:"primaryKey" json:"id"`
+	Prompt      string `gorm:"type:text;serializer:encrypted" json:"prompt"`
+	Provider    string `json:"provider"`
+	UserID      string `json:"userId,omitempty"`
+	ProjectID   string `json:"projectId,omitempty"`
+	Status      string `json:"status"` // running, completed, interrupted
+	Total       int    `json:"total"`
+	Summary     string `gorm:"type:text" json:"summary,omitempty"` // Set once every command has finished
+	CreatedAt   int64  `json:"createdAt"`
+	CompletedAt int64  `json:"completedAt,omitempty"`
+}
+
+// BatchCommandRequest is the body of POST /api/ai/batch
+type BatchCommandRequest struct {
+	Prompt     string   `json:"prompt"`
+	Provider   string   `json:"provider,omitempty"`
+	Pages      []string `json:"pages,omitempty"`      // One current-page command per page
+	ContentIDs []string `json:"contentIds,omitempty"` // One component command per content block
+	ProjectID  string   `json:"projectId,omitempty"`
+}
+
+// BatchTargetResult is the outcome of one child command
+type BatchTargetResult struct {
+	CommandID    string  `json:"commandId"`
+	Target       string  `json:"target"` // Page, or content ID for component commands
+	Status       string  `json:"status"`
+	Error        string  `json:"error,omitempty"`
+	FilesChanged int     `json:"filesChanged,omitempty"`
+	CostUSD      float64 `json:"costUsd,omitempty"`
+}
+
+// BatchProgress is the aggregate state of a batch, sent on every change
+type BatchProgress struct {
+	BatchID      string              `json:"batchId"`
+	Status       string              `json:"status"`
+	Total        int                 `json:"total"`
+	Finished     int                 `json:"finished"`
+	ByStatus     map[string]int      `json:"byStatus"`
+	FilesChanged int                 `json:"filesChanged"`
+	CostUSD      float64             `json:"costUsd"`
+	Summary      string              `json:"summary,omitempty"`
+	Commands     []BatchTargetResult `json:"commands"`
+}
+
+// WSMsgTypeProgress carries a BatchProgress on the batch stream
+const WSMsgTypeProgress = "progress"
+
+// maxBatchTargets caps the commands created by one batch
+const maxBatchTargets = 200
+
+// batchJobs feeds queued child command IDs to the batch workers
+var batchJobs = make(chan string, 1000)
+
+var (
+	batchWatchers   = make(map[string][]chan BatchProgress)
+	batchWatchersMu sync.Mutex
+	batchFinishMu   sync.Mutex // Serializes finishing so a batch is summarized once
+
+	batchStreamsDone     = make(chan struct{}) // Closed on shutdown to end every batch stream
+	batchStreamsDoneOnce sync.Once
+)
+
+// getBatchWorkers returns how many batch commands run at the same time from AI_BATCH_WORKERS
+// Falls back to 2
+func getBatchWorkers() int {
+	if n, err := strconv.Atoi(os.Getenv("AI_BATCH_WORKERS")); err == nil && n > 0 {
+		return n
+	}
+	return 2
+}
+
+// enqueueBatchCommands hands commands to the workers without blocking the caller. With
+// the job queue they go there instead of to the batch workers.
+func enqueueBatchCommands(db *gorm.DB, ids []string) {
+	if commandQueue != nil {
+		go dispatchBatchCommands(db, ids)
+		return
+	}
+	go func() {
+		for _, id := range ids {
+			batchJobs <- id
+		}
+	}()
+}
+
+// dispatchBatchCommands hands the child commands of a batch to the job queue
+func dispatchBatchCommands(db *gorm.DB, ids []string) {
+	var commands []AICommand
+	if err := db.Where("id IN ?", ids).Order("created_at").Find(&commands).Error; err != nil {
+		log.Printf("⚠️ Failed to load batch commands to enqueue: %v", err)
+		return
+	}
+	dispatchCommands(db, commands)
+}
+
+// StartBatchWorkers starts the batch workers and re-queues child commands a previous
+// run left queued. With the job queue the workers take batch commands from there.
+func StartBatchWorkers(db *gorm.DB) {
+	if commandQueue != nil {
+		return
+	}
+	var pending []string
+	db.Model(&AICommand{}).Where("batch_id <> '' AND status = ?", "queued").Order("created_at").Pluck("id", &pending)
+	if len(pending) > 0 {
+		log.Printf("📦 Re-queued %d batch command(s) left by a previous run", len(pending))
+		enqueueBatchCommands(db, pending)
+	}
+
+	for i := 0; i < getBatchWorkers(); i++ {
+		go func() {
+			for id := range batchJobs {
+				if shuttingDown.Load() {
+					continue
+				}
+				runBatchCommand(db, id)
+			}
+		}()
+	}
+}
+
+// runBatchCommand processes one child command to the end. Commands that are no
+// longer queued (interrupted, awaiting approval, or started over a stream) are skipped.
+func runBatchCommand(db *gorm.DB, id string) {
+	var command AICommand
+	if err := db.First(&command, "id = ?", id).Error; err != nil || command.Status != "queued" {
+		return
+	}
+
+	// The budget may run out while a large batch is working through its pages
+	if err := checkBudget(db, command.ProjectID); err != nil {
+		db.Model(&command).Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": err.Error(),
+			"completed_at":  time.Now().Unix(),
+		})
+		batchCommandChanged(db, command.BatchID)
+		return
+	}
+
+	session, ok := newCommandSession(db, &command)
+	if !ok {
+		return
+	}
+	command.Status = "processing"
+	db.Model(&command).Update("status", command.Status)
+	batchCommandChanged(db, command.BatchID)
+
+	drained := make(chan struct{})
+	go func() {
+		for range session.progressQueue {
+		}
+		close(drained)
+	}()
+
+	activeRuns.Add(1)
+	processAICommand(session, db) // Reports the finished command through batchCommandChanged
+	<-drained
+	cleanup(session)
+}
+
+// batchTarget names what a child command worked on
+func batchTarget(command AICommand) string {
+	if command.Scope == "component" && command.ComponentID != "" {
+		return command.ComponentID
+	}
+	return command.Page
+}
+
+// batchSummary describes the outcome in one line, e.g.
+// `"Update the footer" completed on 34 of 36 pages, 2 failed`
+func batchSummary(batch BatchCommand, progress BatchProgress, pages, blocks bool) string {
+	unit := "targets"
+	switch {
+	case pages && !blocks:
+		unit = "pages"
+	case blocks && !pages:
+		unit = "content blocks"
+	}
+
+	summary := fmt.Sprintf("%q completed on %d of %d %s", batch.Prompt, progress.ByStatus["completed"], progress.Total, unit)
+	var rest []string
+	for _, status := range []string{"failed", "timed_out", StatusResourceLimit, "interrupted", "rejected"} {
+		if n := progress.ByStatus[status]; n > 0 {
+			rest = append(rest, fmt.Sprintf("%d %s", n, strings.ReplaceAll(status, "_", " ")))
+		}
+	}
+	if len(rest) > 0 {
+		summary += ", " + strings.Join(rest, ", ")
+	}
+	return summary
+}
+
+// batchProgress aggregates the child commands of a batch
+func batchProgress(db *gorm.DB, batch BatchCommand) (BatchProgress, error) {
+	var commands []AICommand
+	err := db.Select("id", "scope", "page", "component_id", "status", "error_message", "result", "cost_usd").
+		Where("batch_id = ?", batch.ID).Order("created_at, id").Find(&commands).Error
+	if err != nil {
+		return BatchProgress{}, err
+	}
+
+	progress := BatchProgress{
+		BatchID:  batch.ID,
+		Status:   batch.Status,
+		Total:    len(commands),
+		ByStatus: make(map[string]int),
+		Summary:  batch.Summary,
+		Commands: make([]BatchTargetResult, 0, len(commands)),
+	}
+	var pages, blocks bool
+	for _, command := range commands {
+		result := BatchTargetResult{
+			CommandID: command.ID,
+			Target:    batchTarget(command),
+			Status:    command.Status,
+			Error:     command.ErrorMessage,
+			CostUSD:   command.CostUSD,
+		}
+		if command.Result != "" {
+			if parsed, err := parseCommandResult(command.Result); err == nil {
+				result.FilesChanged = len(parsed.Changes)
+			}
+		}
+
+		progress.ByStatus[command.Status]++
+		if isTerminalStatus(command.Status) {
+			progress.Finished++
+		}
+		progress.FilesChanged += result.FilesChanged
+		progress.CostUSD += result.CostUSD
+		progress.Commands = append(progress.Commands, result)
+
+		if command.Scope == "component" {
+			blocks = true
+		} else {
+			pages = true
+		}
+	}
+
+	if progress.Summary == "" && progress.Finished == progress.Total {
+		progress.Summary = batchSummary(batch, progress, pages, blocks)
+	}
+	return progress, nil
+}
+
+// batchCommandChanged is called whenever a child command changes state. It finishes
+// the batch once every command is done and notifies the streams.
+func batchCommandChanged(db *gorm.DB, batchID string) {
+	if batchID == "" {
+		return
+	}
+
+	batchFinishMu.Lock()
+	defer batchFinishMu.Unlock()
+
+	var batch BatchCommand
+	if err := db.First(&batch, "id = ?", batchID).Error; err != nil {
+		return
+	}
+	progress, err := batchProgress(db, batch)
+	if err != nil {
+		log.Printf("⚠️ Failed to aggregate batch %s: %v", batchID, err)
+		return
+	}
+
+	if progress.Finished == progress.Total && batch.CompletedAt == 0 {
+		if batch.Status == "running" {
+			batch.Status = "completed"
+		}
+		batch.Summary = progress.Summary
+		batch.CompletedAt = time.Now().Unix()
+		db.Model(&batch).Updates(map[string]interface{}{
+			"status":       batch.Status,
+			"summary":      batch.Summary,
+			"completed_at": batch.CompletedAt,
+		})
+		progress.Status = batch.Status
+		log.Printf("📦 Batch %s %s: %s", batch.ID, batch.Status, batch.Summary)
+	}
+
+	notifyBatch(progress)
+}
+
+func watchBatch(batchID string) chan BatchProgress {
+	ch := make(chan BatchProgress, 16)
+	batchWatchersMu.Lock()
+	batchWatchers[batchID] = append(batchWatchers[batchID], ch)
+	batchWatchersMu.Unlock()
+	return ch
+}
+
+func unwatchBatch(batchID string, ch chan BatchProgress) {
+	batchWatchersMu.Lock()
+	defer batchWatchersMu.Unlock()
+	watchers := batchWatchers[batchID]
+	for i, w := range watchers {
+		if w == ch {
+			batchWatchers[batchID] = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+	if len(batchWatchers[batchID]) == 0 {
+		delete(batchWatchers, batchID)
+	}
+}
+
+// notifyBatch sends progress to every stream of the batch, on every instance in Redis
+// mode. Each update carries the whole state, so a slow stream only loses intermediate ones.
+func notifyBatch(progress BatchProgress) {
+	if cluster != nil {
+		if err := cluster.publishBatch(progress); err == nil {
+			return // Delivered by the listener of every instance, this one included
+		}
+	}
+	deliverBatch(progress)
+}
+
+// deliverBatch sends progress to the streams of the batch on this instance
+func deliverBatch(progress BatchProgress) {
+	batchWatchersMu.Lock()
+	defer batchWatchersMu.Unlock()
+	for _, ch := range batchWatchers[progress.BatchID] {
+		select {
+		case ch <- progress:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- progress
+		}
+	}
+}
+
+// closeAllBatchStreams ends the batch streams (used on shutdown)
+func closeAllBatchStreams() int {
+	batchWatchersMu.Lock()
+	count := 0
+	for _, watchers := range batchWatchers {
+		count += len(watchers)
+	}
+	batchWatchersMu.Unlock()
+
+	batchStreamsDoneOnce.Do(func() { close(batchStreamsDone) })
+	return count
+}
+
+// batchChildRequests turns the targets of a batch into command requests, dropping duplicates
+func batchChildRequests(req BatchCommandRequest, userID string) ([]AICommandRequest, error) {
+	var children []AICommandRequest
+	seen := make(map[string]bool)
+	for _, page := range req.Pages {
+		if page = strings.TrimSpace(page); page == "" || seen["page:"+page] {
+			continue
+		}
+		seen["page:"+page] = true
+		children = append(children, AICommandRequest{
+			Prompt:   req.Prompt,
+			Scope:    "current-page",
+			Provider: req.Provider,
+			Context:  CommandContext{Page: page, UserID: userID, ProjectID: req.ProjectID},
+		})
+	}
+	for _, id := range req.ContentIDs {
+		if id = strings.TrimSpace(id); id == "" || seen["content:"+id] {
+			continue
+		}
+		seen["content:"+id] = true
+		ctx := CommandContext{Page: pageFromContentID(id), UserID: userID, ProjectID: req.ProjectID, ComponentID: id}
+		if err := validateComponentTarget(ctx); err != nil {
+			return nil, err
+		}
+		children = append(children, AICommandRequest{
+			Prompt:   req.Prompt,
+			Scope:    "component",
+			Provider: req.Provider,
+			Context:  ctx,
+		})
+	}
+	return children, nil
+}
+
+// CreateBatchCommand runs one prompt on many pages or content blocks
+func CreateBatchCommand(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req BatchCommandRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if req.Prompt == "" {
+			return sendError(c, 400, "MISSING_PROMPT", "Prompt is required", "")
+		}
+
+		var userID string
+		if user := currentUser(c); user != nil {
+			userID = user.ID
+		}
+		children, err := batchChildRequests(req, userID)
+		if err != nil {
+			return sendError(c, 400, "INVALID_COMPONENT", "Invalid content ID", err.Error())
+		}
+		if len(children) == 0 {
+			return sendError(c, 400, "MISSING_TARGETS", "At least one page or content ID is required", "")
+		}
+		if len(children) > maxBatchTargets {
+			return sendError(c, 400, "TOO_MANY_TARGETS", fmt.Sprintf("A batch can target at most %d pages and content blocks", maxBatchTargets), "")
+		}
+
+		if req.Provider == "" {
+			req.Provider = getDefaultProvider()
+		}
+		if _, err := getProvider(req.Provider); err != nil {
+			return sendError(c, 400, "INVALID_PROVIDER", "Invalid AI provider", err.Error())
+		}
+		if _, err := resolveWorkspaceDir(db, req.ProjectID); err != nil {
+			if errors.Is(err, errProjectNotFound) {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", err.Error())
+			}
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+		if err := checkBudget(db, req.ProjectID); err != nil {
+			return budgetErrorResponse(c, err)
+		}
+		if err := checkQuotaFor(db, userID, req.ProjectID, int64(len(children))); err != nil {
+			return quotaErrorResponse(c, err)
+		}
+
+		now := time.Now().Unix()
+		batch := BatchCommand{
+			ID:        "batch_" + uuid.New().String()[:8],
+			Prompt:    req.Prompt,
+			Provider:  req.Provider,
+			UserID:    userID,
+			ProjectID: req.ProjectID,
+			Status:    "running",
+			Total:     len(children),
+			CreatedAt: now,
+		}
+
+		var queued []string
+		pendingApproval := 0
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&batch).Error; err != nil {
+				return err
+			}
+			for _, child := range children {
+				child.Provider = req.Provider
+				conversation, err := resolveConversation(tx, child)
+				if err != nil {
+					return err
+				}
+
+				// Batch commands follow the approval policy like any other command
+				status := "queued"
+				reasons := approvalReasons(child)
+				if len(reasons) > 0 {
+					status = "pending_approval"
+					pendingApproval++
+				}
+
+				command := AICommand{
+					ID:        fmt.Sprintf("cmd_%d_%s", now, uuid.New().String()[:8]),
+					Prompt:    child.Prompt,
+					Scope:     child.Scope,
+					Provider:  child.Provider,
+					Page:      child.Context.Page,
+					UserID:    child.Context.UserID,
+					ProjectID: child.Context.ProjectID,
+					Status:    status,
+					CreatedAt: now,
+
+					ConversationID: conversation.ID,
+					ComponentID:    child.Context.ComponentID,
+					ApprovalReason: strings.Join(reasons, "; "),
+					BatchID:        batch.ID,
+					Priority:       PriorityLow,
+				}
+				if err := tx.Create(&command).Error; err != nil {
+					return err
+				}
+				if status == "queued" {
+					queued = append(queued, command.ID)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create batch", err.Error())
+		}
+
+		log.Printf("📦 Batch %s created: %d command(s), %d awaiting approval", batch.ID, batch.Total, pendingApproval)
+		recordAudit(db, c, AuditBatchExecute, batch.ID, nil, batch.Prompt, fmt.Sprintf("%d command(s)", batch.Total))
+		enqueueBatchCommands(db, queued)
+
+		url, token := streamURL(c, "batch:"+batch.ID, batch.UserID)
+		data := fiber.Map{
+			"batchId":         batch.ID,
+			"status":          batch.Status,
+			"total":           batch.Total,
+			"pendingApproval": pendingApproval,
+			"wsUrl":           url,
+		}
+		if token.Token != "" {
+			data["streamToken"] = token.Token
+			data["streamTokenExpiresAt"] = token.ExpiresAt
+		}
+		return c.Status(201).JSON(fiber.Map{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
+
+// GetBatchCommand returns a batch with the state of every child command
+func GetBatchCommand(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var batch BatchCommand
+		if err := db.First(&batch, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "BATCH_NOT_FOUND", "Batch not found", "")
+		}
+		progress, err := batchProgress(db, batch)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load batch commands", err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"batch":    batch,
+				"progress": progress,
+			},
+		})
+	}
+}
+
+// InterruptBatchCommand stops a batch: commands that have not started are marked
+// interrupted and running ones are cancelled
+func InterruptBatchCommand(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var batch BatchCommand
+		if err := db.First(&batch, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "BATCH_NOT_FOUND", "Batch not found", "")
+		}
+		if batch.Status != "running" {
+			return sendError(c, 409, "BATCH_FINISHED", "Batch is not running", "Current status: "+batch.Status)
+		}
+
+		db.Model(&batch).Update("status", "interrupted")
+		skipped := db.Model(&AICommand{}).Where("batch_id = ? AND status IN ?", batch.ID, []string{"queued", "pending_approval"}).Updates(map[string]interface{}{
+			"status":        "interrupted",
+			"error_message": "Batch was interrupted",
+			"completed_at":  time.Now().Unix(),
+		}).RowsAffected
+
+		var running []string
+		db.Model(&AICommand{}).Where("batch_id = ? AND status = ?", batch.ID, "processing").Pluck("id", &running)
+		commandMu.RLock()
+		for _, id := range running {
+			if session, ok := commandSessions[id]; ok {
+				session.Cancel()
+			}
+		}
+		commandMu.RUnlock()
+
+		recordAudit(db, c, AuditCommandInterrupt, batch.ID, nil, nil, fmt.Sprintf("%d skipped, %d cancelled", skipped, len(running)))
+		batchCommandChanged(db, batch.ID)
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"batchId":   batch.ID,
+				"status":    "interrupted",
+				"skipped":   skipped,
+				"cancelled": len(running),
+			},
+		})
+	}
+}
+
+// StreamBatchCommand sends the aggregate progress of a batch over WebSocket: the
+// current state on connect, a "progress" message whenever a command starts or
+// finishes, and "complete" with the summary once all are done
+func StreamBatchCommand(db *gorm.DB) fiber.Handler {
+	return websocket.New(func(conn *websocket.Conn) {
+		batchID := conn.Params("id")
+
+		updates := watchBatch(batchID)
+		defer unwatchBatch(batchID, updates)
+
+		var batch BatchCommand
+		if err := db.First(&batch, "id = ?", batchID).Error; err != nil {
+			sendWSError(conn, "BATCH_NOT_FOUND", "Batch not found", err.Error())
+			return
+		}
+		progress, err := batchProgress(db, batch)
+		if err != nil {
+			sendWSError(conn, "DATABASE_ERROR", "Failed to load batch commands", err.Error())
+			return
+		}
+
+		// Notice when the client goes away
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+	stream:
+		for {
+			msgType := WSMsgTypeProgress
+			if progress.Finished == progress.Total {
+				msgType = WSMsgTypeComplete
+			}
+			if err := sendWSMessage(conn, ProgressUpdate{
+				Type:      msgType,
+				Timestamp: time.Now().Format(time.RFC3339),
+				Message:   progress.Summary,
+				Data:      progress,
+			}); err != nil || msgType == WSMsgTypeComplete {
+				break
+			}
+
+			for {
+				select {
+				case progress = <-updates:
+					continue stream
+				case <-ticker.C:
+					// Send keep-alive ping
+					sendWSMessage(conn, ProgressUpdate{
+						Type:      WSMsgTypePing,
+						Timestamp: time.Now().Format(time.RFC3339),
+					})
+				case <-batchStreamsDone:
+					break stream
+				case <-gone:
+					return
+				}
+			}
+		}
+
+		closeCode := websocket.CloseNormalClosure
+		if shuttingDown.Load() {
+			closeCode = websocket.CloseGoingAway
+		}
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""), time.Now().Add(time.Second))
+	}, streamWSConfig())
+}
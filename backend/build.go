@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Build is one run of a project's build command. The output lives in the agent
+// session it ran in and can be streamed from /api/agent/stream/:sessionId.
+type Build struct {
+	ID         string `gorm:"primaryKey" json:"id"`
+	ProjectID  string `gorm:"index" json:"projectId"`
+	Command    string `json:"command"`
+	SessionID  string `json:"sessionId"`
+	Status     string `gorm:"index" json:"status"` // running, succeeded, failed, interrupted, timed_out, resource_limit_exceeded
+	ExitCode   *int   `json:"exitCode,omitempty"`
+	Error      string `gorm:"type:text" json:"error,omitempty"`
+	StartedAt  int64  `gorm:"index" json:"startedAt"`
+	FinishedAt int64  `json:"finishedAt,omitempty"`
+}
+
+// BuildRequest is the body of POST /api/build
+type BuildRequest struct {
+	ProjectID string `json:"projectId,omitempty"` // Empty builds the global workspace
+}
+
+// buildMu serializes the running-build check so a project never builds twice at once
+var buildMu sync.Mutex
+
+// getBuildCommand returns the project's build command from its "buildCommand" setting,
+// then BUILD_COMMAND. Falls back to npm run build
+func getBuildCommand(project *Project) string {
+	if project != nil {
+		if command, ok := project.Settings["buildCommand"].(string); ok && strings.TrimSpace(command) != "" {
+			return command
+		}
+	}
+	return getEnvDefault("BUILD_COMMAND", "npm run build")
+}
+
+// getBuildTimeout returns how long a build may run (timeouts.build, BUILD_TIMEOUT)
+// Falls back to 15 minutes
+func getBuildTimeout() time.Duration {
+	return appConfig.Timeouts.Build.Duration()
+}
+
+// finishBuild records the outcome of a build once its process has exited
+func finishBuild(db *gorm.DB, build *Build, session *AgentSession, err error) {
+	updates := map[string]interface{}{
+		"status":      sessionOutcome(session, err),
+		"finished_at": time.Now().Unix(),
+	}
+	var exitErr *exec.ExitError
+	if err == nil {
+		updates["exit_code"] = 0
+	} else {
+		if errors.As(err, &exitErr) {
+			updates["exit_code"] = exitErr.ExitCode()
+		}
+		updates["error"] = err.Error()
+	}
+	if dbErr := db.Model(&Build{}).Where("id = ?", build.ID).Updates(updates).Error; dbErr != nil {
+		log.Printf("⚠️ Failed to record result of build %s: %v", build.ID, dbErr)
+		return
+	}
+	log.Printf("🏗️ Build %s %s", build.ID, updates["status"])
+}
+
+// failAbandonedBuilds marks builds left running by a previous backend process as failed.
+// In Redis mode, those another live instance runs are left alone.
+func failAbandonedBuilds(db *gorm.DB) {
+	query := db.Model(&Build{}).Where("status = ?", "running")
+	if cluster != nil {
+		var running []Build
+		db.Where("status = ?", "running").Find(&running)
+		for _, row := range running {
+			if runsElsewhere(SessionKindAgent, row.SessionID) {
+				query = query.Where("id <> ?", row.ID)
+			}
+		}
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":      "failed",
+		"error":       "backend restarted while the build was running",
+		"finished_at": time.Now().Unix(),
+	})
+	if result.Error != nil {
+		log.Printf("⚠️ Failed to clean up abandoned builds: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("🧹 Marked %d abandoned build(s) as failed", result.RowsAffected)
+	}
+}
+
+// buildResponse adds the stream URL while the build's session is still around
+func buildResponse(build *Build) fiber.Map {
+	data := fiber.Map{"build": build}
+
+	sessMu.RLock()
+	_, exists := sessions[build.SessionID]
+	sessMu.RUnlock()
+	if exists || runsElsewhere(SessionKindAgent, build.SessionID) {
+		data["streamUrl"] = "/api/agent/stream/" + build.SessionID
+		data["outputUrl"] = "/api/agent/output/" + build.SessionID
+	}
+	return data
+}
+
+// StartBuild runs the project's build command as an agent session
+func StartBuild(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req BuildRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+			}
+		}
+
+		var project *Project
+		workDir := getWorkspaceDir()
+		if req.ProjectID != "" {
+			project = &Project{}
+			if err := db.First(project, "id = ?", req.ProjectID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", req.ProjectID)
+				}
+				return sendError(c, 500, "DATABASE_ERROR", "Failed to load project", err.Error())
+			}
+			workDir = project.WorkspacePath
+		}
+
+		env, err := buildChildEnv(db, req.ProjectID)
+		if err != nil {
+			return sendError(c, 500, "ENV_ERROR", "Failed to prepare environment", err.Error())
+		}
+
+		buildMu.Lock()
+		defer buildMu.Unlock()
+
+		var running Build
+		err = db.Where("project_id = ? AND status = ?", req.ProjectID, "running").Limit(1).Find(&running).Error
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to check running builds", err.Error())
+		}
+		if running.ID != "" {
+			return sendError(c, 409, "BUILD_RUNNING", "A build is already running for this project", running.ID)
+		}
+
+		command := getBuildCommand(project)
+		build := &Build{
+			ID:        "build_" + uuid.New().String()[:8],
+			ProjectID: req.ProjectID,
+			Command:   command,
+			Status:    "running",
+			StartedAt: time.Now().Unix(),
+		}
+
+		session := &AgentSession{
+			Kind:    SessionKindBuild,
+			UserID:  currentUserID(c),
+			Command: "sh",
+			Args:    []string{"-c", command},
+			WorkDir: workDir,
+			Env:     env,
+			Timeout: getBuildTimeout(),
+			db:      db,
+		}
+		session.onExit = func(err error) {
+			finishBuild(db, build, session, err)
+		}
+
+		// The build sees the page metadata set since the last one
+		if changed, err := applyAllPageMeta(db, req.ProjectID, workDir); err != nil {
+			log.Printf("⚠️ Failed to write page metadata before build %s: %v", build.ID, err)
+		} else if changed > 0 {
+			log.Printf("🔖 Wrote the metadata of %d page(s) before build %s", changed, build.ID)
+		}
+
+		// The session ID is only known once it is launched, so the row is
+		// created first and filled in right after
+		if err := db.Create(build).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create build", err.Error())
+		}
+		launchAgent(session)
+		build.SessionID = session.ID
+		db.Model(&Build{}).Where("id = ?", build.ID).Update("session_id", session.ID)
+
+		log.Printf("🏗️ Build %s started for project %q: %s", build.ID, req.ProjectID, command)
+		return c.Status(202).JSON(fiber.Map{
+			"success": true,
+			"data":    buildResponse(build),
+		})
+	}
+}
+
+// GetLatestBuild returns the most recent build of a project (?projectId=, empty for the global workspace)
+func GetLatestBuild(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Query("projectId")
+
+		var build Build
+		err := db.Where("project_id = ?", projectID).Order("started_at DESC").Limit(1).Find(&build).Error
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load builds", err.Error())
+		}
+		if build.ID == "" {
+			return sendError(c, 404, "BUILD_NOT_FOUND", "No builds for this project", fmt.Sprintf("projectId=%q", projectID))
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    buildResponse(&build),
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxTrackedFileSize is the largest file whose content is kept for line counts
+const maxTrackedFileSize = 256 * 1024
+
+// snapshotSkipDirs are never walked when snapshotting the workspace
+var snapshotSkipDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	".next":        true,
+	resultFileDir:  true, // Result files of running commands
+}
+
+// pageExtensions identify files that represent pages of the site
+var pageExtensions = map[string]bool{
+	".html":   true,
+	".htm":    true,
+	".md":     true,
+	".mdx":    true,
+	".jsx":    true,
+	".tsx":    true,
+	".vue":    true,
+	".svelte": true,
+	".astro":  true,
+}
+
+// FileState records what a workspace file looked like at snapshot time
+type FileState struct {
+	Size    int64
+	ModTime int64
+	Hash    string
+	Content *string // Only kept for small files
+}
+
+// WorkspaceSnapshot maps slash-separated relative paths to their state
+type WorkspaceSnapshot map[string]FileState
+
+// FileChange describes a file created, modified or deleted by a command
+type FileChange struct {
+	Type         string `json:"type"` // created, modified, deleted
+	Target       string `json:"target"`
+	Description  string `json:"description"`
+	LinesAdded   int    `json:"linesAdded"`
+	LinesRemoved int    `json:"linesRemoved"`
+}
+
+// snapshotWorkspace walks dir and hashes every regular file
+func snapshotWorkspace(dir string) (WorkspaceSnapshot, error) {
+	snapshot := WorkspaceSnapshot{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries rather than failing the whole snapshot
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if path != dir && snapshotSkipDirs[entry.Name()] {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		sum := sha256.Sum256(data)
+		state := FileState{
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+			Hash:    hex.EncodeToString(sum[:]),
+		}
+		if info.Size() <= maxTrackedFileSize {
+			content := string(data)
+			state.Content = &content
+		}
+		snapshot[filepath.ToSlash(rel)] = state
+		return nil
+	})
+	return snapshot, err
+}
+
+// detectChanges compares two snapshots and returns the changed files sorted by path
+func detectChanges(before, after WorkspaceSnapshot) []FileChange {
+	changes := []FileChange{}
+
+	for path, newState := range after {
+		oldState, existed := before[path]
+		switch {
+		case !existed:
+			change := FileChange{Type: "created", Target: path, Description: "File created"}
+			if newState.Content != nil {
+				change.LinesAdded = len(splitLines(*newState.Content))
+			}
+			changes = append(changes, change)
+		case oldState.Hash != newState.Hash:
+			change := FileChange{Type: "modified", Target: path, Description: "File modified"}
+			if oldState.Content != nil && newState.Content != nil {
+				change.LinesAdded, change.LinesRemoved = diffStats(diffTokens(splitLines(*oldState.Content), splitLines(*newState.Content)))
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	for path, oldState := range before {
+		if _, exists := after[path]; !exists {
+			change := FileChange{Type: "deleted", Target: path, Description: "File deleted"}
+			if oldState.Content != nil {
+				change.LinesRemoved = len(splitLines(*oldState.Content))
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Target < changes[j].Target
+	})
+	return changes
+}
+
+// affectedPagesFromChanges lists the changed files that are pages of the site
+func affectedPagesFromChanges(changes []FileChange) []string {
+	pages := []string{}
+	for _, change := range changes {
+		if pageExtensions[strings.ToLower(filepath.Ext(change.Target))] {
+			pages = append(pages, change.Target)
+		}
+	}
+	return pages
+}
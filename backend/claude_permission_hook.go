@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// permissionSocketEnv tells the permission hook where the backend waits for its requests
+const permissionSocketEnv = "SITE_EDITOR_PERMISSION_SOCKET"
+
+// claudeHookInput is the part of a PreToolUse hook's stdin the backend uses
+type claudeHookInput struct {
+	ToolName  string          `json:"tool_name"`
+	ToolInput json.RawMessage `json:"tool_input"`
+}
+
+// claudeHookOutput is what a PreToolUse hook prints to allow or deny a tool
+type claudeHookOutput struct {
+	HookSpecificOutput struct {
+		HookEventName            string `json:"hookEventName"`
+		PermissionDecision       string `json:"permissionDecision"` // allow or deny
+		PermissionDecisionReason string `json:"permissionDecisionReason,omitempty"`
+	} `json:"hookSpecificOutput"`
+}
+
+// startClaudePermissionHook has the CLI ask before each tool: it listens on a Unix socket
+// for the PreToolUse hook, which runs this binary with -permission-hook, and returns the
+// CLI arguments and environment variable that install the hook. The hook needs the
+// binary and the socket, so it only works with the host execution driver.
+func startClaudePermissionHook(ctx context.Context, req ProviderRequest) ([]string, string, func(), error) {
+	if driver := getExecutionDriver(); driver != ExecutionHost {
+		return nil, "", nil, fmt.Errorf("AI_PERMISSION_MODE=prompt needs AI_EXECUTION_DRIVER=host, not %s", driver)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to locate the permission hook: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "site-editor-permissions-")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	socket := filepath.Join(dir, "hook.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", nil, fmt.Errorf("failed to listen for the permission hook: %w", err)
+	}
+
+	// The CLI must not give up on the hook before the request has timed out
+	settings := map[string]interface{}{
+		"hooks": map[string]interface{}{
+			"PreToolUse": []interface{}{map[string]interface{}{
+				"matcher": "*",
+				"hooks": []interface{}{map[string]interface{}{
+					"type":    "command",
+					"command": "'" + strings.ReplaceAll(exe, "'", `'\''`) + "' -permission-hook",
+					"timeout": int((getPermissionTimeout() + time.Minute).Seconds()),
+				}},
+			}},
+		},
+	}
+	data, _ := json.Marshal(settings)
+	settingsFile := filepath.Join(dir, "settings.json")
+	if err := os.WriteFile(settingsFile, data, 0600); err != nil {
+		listener.Close()
+		os.RemoveAll(dir)
+		return nil, "", nil, err
+	}
+
+	// Requests still waiting when the CLI is gone are given up before cleanup returns
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveClaudePermissionHook(ctx, conn, req)
+			}()
+		}
+	}()
+
+	cleanup := func() {
+		listener.Close()
+		cancel()
+		wg.Wait()
+		os.RemoveAll(dir)
+	}
+	return []string{"--settings", settingsFile}, permissionSocketEnv + "=" + socket, cleanup, nil
+}
+
+// serveClaudePermissionHook answers one hook request with the decision of the command
+func serveClaudePermissionHook(ctx context.Context, conn net.Conn, req ProviderRequest) {
+	defer conn.Close()
+	var input claudeHookInput
+	if err := json.NewDecoder(conn).Decode(&input); err != nil {
+		log.Printf("⚠️ Invalid permission hook request of command [%s]: %v", req.CommandID, err)
+		return
+	}
+	decision := req.Permission(ctx, input.ToolName, input.ToolInput)
+	json.NewEncoder(conn).Encode(decision)
+}
+
+// runPermissionHook handles -permission-hook: the CLI runs it before each tool with the
+// tool on stdin, and it prints whether the command lets the tool run. Without an answer
+// from the backend the tool is denied.
+func runPermissionHook() error {
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	decision := PermissionDecision{Decided: true, Reason: "site-editor did not answer the permission request"}
+	if conn, err := net.Dial("unix", os.Getenv(permissionSocketEnv)); err == nil {
+		conn.Write(append(input, '\n'))
+		if json.NewDecoder(conn).Decode(&decision) != nil {
+			decision = PermissionDecision{Decided: true, Reason: "site-editor did not answer the permission request"}
+		}
+		conn.Close()
+	}
+	if !decision.Decided {
+		return nil // The CLI applies its own permission rules
+	}
+
+	var output claudeHookOutput
+	output.HookSpecificOutput.HookEventName = "PreToolUse"
+	output.HookSpecificOutput.PermissionDecision = "deny"
+	if decision.Allow {
+		output.HookSpecificOutput.PermissionDecision = "allow"
+	}
+	output.HookSpecificOutput.PermissionDecisionReason = decision.Reason
+	return json.NewEncoder(os.Stdout).Encode(output)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// CleanupSettings controls the background cleanup scheduler
+type CleanupSettings struct {
+	Interval         time.Duration // CLEANUP_INTERVAL
+	SessionRetention time.Duration // CLEANUP_SESSION_RETENTION: keep finished agent sessions and session records this long
+	StaleCommandAge  time.Duration // CLEANUP_STALE_COMMAND_AGE: processing rows older than this without a session are failed
+	TrashRetention   time.Duration // CLEANUP_TRASH_RETENTION: deleted content is purged after this long
+}
+
+// MarshalJSON renders the durations in Go duration notation ("5m0s")
+func (s CleanupSettings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"interval":         s.Interval.String(),
+		"sessionRetention": s.SessionRetention.String(),
+		"staleCommandAge":  s.StaleCommandAge.String(),
+		"trashRetention":   s.TrashRetention.String(),
+	})
+}
+
+// CleanupStats summarizes what the scheduler has done since startup
+type CleanupStats struct {
+	Runs                 int64           `json:"runs"`
+	LastRun              time.Time       `json:"lastRun"`
+	LastDuration         float64         `json:"lastDurationMs"`
+	AgentSessionsPruned  int64           `json:"agentSessionsPruned"`
+	SessionRecordsPruned int64           `json:"sessionRecordsPruned"`
+	CommandSessions      int64           `json:"commandSessionsExpired"`
+	StaleCommandsFailed  int64           `json:"staleCommandsFailed"`
+	RateLimitPruned      int64           `json:"rateLimitBucketsPruned"`
+	ContentLocksExpired  int64           `json:"contentLocksExpired"`
+	TrashPurged          int64           `json:"trashPurged"`
+	BranchesDiscarded    int64           `json:"branchesDiscarded"`
+	ActivityPruned       int64           `json:"activityPruned"`
+	AuthSessionsPruned   int64           `json:"authSessionsPruned"`
+	InvitesPruned        int64           `json:"invitesPruned"`
+	Settings             CleanupSettings `json:"settings"`
+}
+
+var (
+	cleanupStats   CleanupStats
+	cleanupStatsMu sync.Mutex
+)
+
+// getEnvDuration parses a Go duration from key, falling back when unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// getCleanupSettings reads the scheduler settings from the environment
+func getCleanupSettings() CleanupSettings {
+	return CleanupSettings{
+		Interval:         getEnvDuration("CLEANUP_INTERVAL", 5*time.Minute),
+		SessionRetention: getEnvDuration("CLEANUP_SESSION_RETENTION", time.Hour),
+		StaleCommandAge:  getEnvDuration("CLEANUP_STALE_COMMAND_AGE", getCommandTimeout()+5*time.Minute),
+		TrashRetention:   getEnvDuration("CLEANUP_TRASH_RETENTION", 30*24*time.Hour),
+	}
+}
+
+// pruneAgentSessions removes agent sessions that finished more than retention ago
+func pruneAgentSessions(retention time.Duration) int {
+	sessMu.Lock()
+	defer sessMu.Unlock()
+
+	pruned := 0
+	for id, session := range sessions {
+		session.mu.Lock()
+		finished := !session.isRunning && !session.EndTime.IsZero() && time.Since(session.EndTime) > retention
+		session.mu.Unlock()
+
+		if finished {
+			delete(sessions, id)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// expireCommandSessions drops command sessions that outlived the command timeout
+func expireCommandSessions(maxAge time.Duration) int {
+	commandMu.Lock()
+	defer commandMu.Unlock()
+
+	expired := 0
+	for id, session := range commandSessions {
+		if time.Since(session.StartTime) > maxAge {
+			session.Cancel()
+			delete(commandSessions, id)
+			expired++
+		}
+	}
+	return expired
+}
+
+// failStaleCommands marks commands stuck in processing without a live session as failed
+// (e.g. after a crash); with olderThan == 0 every orphaned row is failed
+func failStaleCommands(db *gorm.DB, olderThan time.Duration) int {
+	var stuck []AICommand
+	query := db.Where("status = ?", "processing")
+	if olderThan > 0 {
+		query = query.Where("created_at < ?", time.Now().Add(-olderThan).Unix())
+	}
+	if err := query.Find(&stuck).Error; err != nil {
+		log.Printf("⚠️ Cleanup failed to query stale commands: %v", err)
+		return 0
+	}
+
+	failed := 0
+	for _, command := range stuck {
+		commandMu.RLock()
+		_, live := commandSessions[command.ID]
+		commandMu.RUnlock()
+		if live || runsElsewhere(SessionKindCommand, command.ID) {
+			continue
+		}
+
+		db.Model(&AICommand{}).Where("id = ? AND status = ?", command.ID, "processing").Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": "command was abandoned (backend restarted or session lost)",
+			"completed_at":  time.Now().Unix(),
+		})
+		failed++
+	}
+	return failed
+}
+
+// runCleanup performs one cleanup pass and records its statistics
+func runCleanup(db *gorm.DB, settings CleanupSettings) CleanupStats {
+	start := time.Now()
+
+	agents := pruneAgentSessions(settings.SessionRetention)
+	records := pruneSessionRecords(db, settings.SessionRetention)
+	lost := orphanLostSessions(db)
+	commands := expireCommandSessions(settings.StaleCommandAge)
+	stale := failStaleCommands(db, settings.StaleCommandAge)
+	buckets := aiRateLimiter.Prune() + formRateLimiter.Prune() + loginRateLimiter.Prune()
+	locks := pruneContentLocks()
+	trashed := purgeTrash(db, settings.TrashRetention)
+	branches := discardAbandonedBranches(db)
+	activity := pruneActivity(db)
+	signIns := pruneAuthSessions(db)
+	invites := pruneTeamInvites(db)
+
+	if agents+commands+stale+trashed > 0 {
+		log.Printf("🧹 Cleanup: pruned %d agent session(s), expired %d command session(s), failed %d stale command(s), purged %d trashed content block(s)", agents, commands, stale, trashed)
+	}
+	if branches > 0 {
+		log.Printf("🧹 Cleanup: discarded %d branch(es) of commands that did not complete", branches)
+	}
+	if lost > 0 {
+		log.Printf("🧹 Cleanup: marked %d session(s) of stopped instances as orphaned", lost)
+	}
+
+	cleanupStatsMu.Lock()
+	defer cleanupStatsMu.Unlock()
+	cleanupStats.Runs++
+	cleanupStats.LastRun = start
+	cleanupStats.LastDuration = float64(time.Since(start).Microseconds()) / 1000
+	cleanupStats.AgentSessionsPruned += int64(agents)
+	cleanupStats.SessionRecordsPruned += int64(records)
+	cleanupStats.CommandSessions += int64(commands)
+	cleanupStats.StaleCommandsFailed += int64(stale)
+	cleanupStats.RateLimitPruned += int64(buckets)
+	cleanupStats.ContentLocksExpired += int64(locks)
+	cleanupStats.TrashPurged += int64(trashed)
+	cleanupStats.BranchesDiscarded += int64(branches)
+	cleanupStats.ActivityPruned += int64(activity)
+	cleanupStats.AuthSessionsPruned += int64(signIns)
+	cleanupStats.InvitesPruned += int64(invites)
+	cleanupStats.Settings = settings
+	return cleanupStats
+}
+
+// StartCleanupScheduler fails commands orphaned by a previous run, then prunes on a ticker
+func StartCleanupScheduler(db *gorm.DB) {
+	settings := getCleanupSettings()
+
+	// Nothing can be running yet, so every processing row is a leftover from a crash
+	if orphaned := failStaleCommands(db, 0); orphaned > 0 {
+		log.Printf("🧹 Marked %d command(s) left processing by a previous run as failed", orphaned)
+	}
+
+	log.Printf("🧹 Cleanup scheduler running every %s", settings.Interval)
+	ticker := time.NewTicker(settings.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if shuttingDown.Load() {
+			return
+		}
+		runCleanup(db, settings)
+	}
+}
+
+// cleanupStatsSnapshot returns a copy of the current statistics
+func cleanupStatsSnapshot() CleanupStats {
+	cleanupStatsMu.Lock()
+	defer cleanupStatsMu.Unlock()
+	return cleanupStats
+}
+
+// GetCleanupStats returns the cleanup scheduler statistics and settings
+func GetCleanupStats() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stats := cleanupStatsSnapshot()
+		stats.Settings = getCleanupSettings()
+
+		sessMu.RLock()
+		agentSessions := len(sessions)
+		sessMu.RUnlock()
+		commandMu.RLock()
+		activeCommands := len(commandSessions)
+		commandMu.RUnlock()
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"stats":           stats,
+				"agentSessions":   agentSessions,
+				"commandSessions": activeCommands,
+			},
+		})
+	}
+}
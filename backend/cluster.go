@@ -0,0 +1,676 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Redis mode: with REDIS_URL set, several backend instances can run behind a load
+// balancer. A session still runs in the instance that started it, but the registry of
+// running sessions, their progress and the rate-limit buckets live in Redis, so any
+// instance can stream, inspect and interrupt a session started on another one.
+
+// cluster is the Redis connection of this instance; nil when REDIS_URL is not set
+var cluster *redisCluster
+
+// clusterSessionTTL is how long a registry entry outlives the last heartbeat of its
+// instance, so the sessions of an instance that died are released
+const clusterSessionTTL = 30 * time.Second
+
+// clusterTimeout bounds every Redis call made while serving a request or a session
+const clusterTimeout = 2 * time.Second
+
+type redisCluster struct {
+	client   *redis.Client
+	instance string // INSTANCE_ID: owner of the sessions this process runs
+	prefix   string // REDIS_PREFIX: prepended to every key and channel
+
+	mu    sync.Mutex
+	owned map[string]bool // Registry keys of the sessions running here
+}
+
+// clusterEvent is a message on the progress channel of a session
+type clusterEvent struct {
+	Seq    int64            `json:"seq"`
+	Update *ProgressUpdate  `json:"update,omitempty"` // Command progress
+	Line   *AgentOutputLine `json:"line,omitempty"`   // Agent output
+	End    bool             `json:"end,omitempty"`    // The session has ended
+}
+
+// clusterControl asks the instance running a session to act on it
+type clusterControl struct {
+	Kind   string `json:"kind"` // SessionKindCommand or an agent session kind
+	ID     string `json:"id"`
+	Action string `json:"action"`           // interrupt, input, approve or deny
+	Data   string `json:"data,omitempty"`   // Input, or the ID of the permission request
+	EOF    bool   `json:"eof,omitempty"`    // Close the stdin of the process instead of writing Data
+	Reason string `json:"reason,omitempty"` // Why a permission request was denied
+}
+
+// claimScript registers a session unless another instance runs it. Entries of this
+// instance are left from before a restart and may be claimed again.
+var claimScript = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// refreshScript extends the entries of the sessions an instance still owns
+var refreshScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call('GET', key) == ARGV[1] then
+		redis.call('PEXPIRE', key, ARGV[2])
+	end
+end
+return 0
+`)
+
+// releaseScript removes the entry of a session, if the instance still owns it
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// getInstanceID names this backend in the session registry from INSTANCE_ID
+// Falls back to the hostname, so a restarted instance recognizes its own sessions
+func getInstanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "site-editor"
+}
+
+// validate checks the Redis settings
+func (r RedisConfig) validate() []error {
+	var errs []error
+	if r.URL != "" {
+		if _, err := redis.ParseURL(r.URL); err != nil {
+			errs = append(errs, fmt.Errorf("redis.url: %w", err))
+		}
+	}
+	if r.Prefix == "" {
+		errs = append(errs, errors.New("redis.prefix must not be empty"))
+	}
+	return errs
+}
+
+// StartCluster connects to REDIS_URL and starts the heartbeat of the session registry
+// and the listeners for control messages and batch progress. Without REDIS_URL the
+// backend runs alone and keeps everything in memory.
+func StartCluster() error {
+	url := appConfig.Redis.URL
+	if url == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	c := &redisCluster{
+		client:   redis.NewClient(opts),
+		instance: getInstanceID(),
+		prefix:   appConfig.Redis.Prefix,
+		owned:    make(map[string]bool),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	control := c.client.Subscribe(ctx, c.controlChannel(c.instance), c.key("batch"))
+	if _, err := control.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to Redis: %w", err)
+	}
+	go c.listen(control)
+	go c.heartbeat()
+
+	if getAuthEnabled() && appConfig.Streams.TokenSecret == "" {
+		log.Printf("⚠️ STREAM_TOKEN_SECRET is not set; stream tokens issued by one instance are rejected by the others")
+	}
+	if perMinute, burst := getRateLimitSettings(); perMinute > 0 {
+		aiRateLimiter = newRedisRateLimiter(c, perMinute, burst)
+	}
+	if perMinute, burst := getFormRateLimitSettings(); perMinute > 0 {
+		formRateLimiter = newRedisRateLimiter(c, perMinute, burst)
+	}
+	if perMinute, burst := getLoginRateLimitSettings(); perMinute > 0 {
+		loginRateLimiter = newRedisRateLimiter(c, perMinute, burst)
+	}
+	cluster = c
+	log.Printf("🔗 Redis mode: instance %s sharing sessions, streams and rate limits", c.instance)
+	return nil
+}
+
+func (c *redisCluster) key(parts ...string) string {
+	key := c.prefix
+	for i, part := range parts {
+		if i > 0 {
+			key += ":"
+		}
+		key += part
+	}
+	return key
+}
+
+func (c *redisCluster) sessionKey(kind, id string) string {
+	if kind != SessionKindCommand {
+		kind = SessionKindAgent // Builds, deployments and previews share the agent session IDs
+	}
+	return c.key("session", kind, id)
+}
+
+func (c *redisCluster) progressChannel(kind, id string) string {
+	if kind != SessionKindCommand {
+		kind = SessionKindAgent
+	}
+	return c.key("progress", kind, id)
+}
+
+func (c *redisCluster) controlChannel(instance string) string {
+	return c.key("control", instance)
+}
+
+func (c *redisCluster) outputKey(id string) string {
+	return c.key("output", id)
+}
+
+// claim registers a session as running on this instance. It returns false when
+// another instance runs it.
+func (c *redisCluster) claim(kind, id string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	key := c.sessionKey(kind, id)
+	claimed, err := claimScript.Run(ctx, c.client, []string{key}, c.instance, clusterSessionTTL.Milliseconds()).Int()
+	if err != nil {
+		// Without Redis nobody else can see the session anyway; run it here
+		log.Printf("⚠️ Failed to register session %s in Redis: %v", id, err)
+		claimed = 1
+	}
+	if claimed == 0 {
+		return false
+	}
+	c.mu.Lock()
+	c.owned[key] = true
+	c.mu.Unlock()
+	return true
+}
+
+// release removes a session that has ended from the registry and tells its followers
+func (c *redisCluster) release(kind, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	key := c.sessionKey(kind, id)
+	c.mu.Lock()
+	delete(c.owned, key)
+	c.mu.Unlock()
+
+	if err := releaseScript.Run(ctx, c.client, []string{key}, c.instance).Err(); err != nil {
+		log.Printf("⚠️ Failed to release session %s in Redis: %v", id, err)
+	}
+	c.publish(ctx, c.progressChannel(kind, id), clusterEvent{End: true})
+	if kind != SessionKindCommand {
+		c.client.Expire(ctx, c.outputKey(id), getCleanupSettings().SessionRetention)
+	}
+}
+
+// owner returns the instance running a session, or "" when none does
+func (c *redisCluster) owner(kind, id string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	owner, err := c.client.Get(ctx, c.sessionKey(kind, id)).Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("⚠️ Failed to look up session %s in Redis: %v", id, err)
+	}
+	return owner
+}
+
+// heartbeat keeps the registry entries of the sessions running here alive
+func (c *redisCluster) heartbeat() {
+	ticker := time.NewTicker(clusterSessionTTL / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		keys := make([]string, 0, len(c.owned))
+		for key := range c.owned {
+			keys = append(keys, key)
+		}
+		c.mu.Unlock()
+		if len(keys) == 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+		if err := refreshScript.Run(ctx, c.client, keys, c.instance, clusterSessionTTL.Milliseconds()).Err(); err != nil {
+			log.Printf("⚠️ Failed to refresh %d session(s) in Redis: %v", len(keys), err)
+		}
+		cancel()
+	}
+}
+
+func (c *redisCluster) publish(ctx context.Context, channel string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, channel, payload).Err()
+}
+
+// publishProgress sends a command progress update to the followers on other connections
+func (c *redisCluster) publishProgress(commandID string, seq int64, update ProgressUpdate) {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if err := c.publish(ctx, c.progressChannel(SessionKindCommand, commandID), clusterEvent{Seq: seq, Update: &update}); err != nil {
+		log.Printf("⚠️ Failed to publish progress of command [%s]: %v", commandID, err)
+	}
+}
+
+// publishOutput keeps an agent output line in the session's Redis buffer, capped like
+// the in-memory one, and sends it to the followers on other instances
+func (c *redisCluster) publishOutput(sessionID string, line AgentOutputLine) {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	payload, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	event, _ := json.Marshal(clusterEvent{Seq: line.Seq, Line: &line})
+
+	key := c.outputKey(sessionID)
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, payload)
+		pipe.LTrim(ctx, key, -int64(getAgentOutputBufferSize()), -1)
+		pipe.Publish(ctx, c.progressChannel(SessionKindAgent, sessionID), event)
+		return nil
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to publish output of session %s: %v", sessionID, err)
+	}
+}
+
+// agentOutput returns the buffered output of an agent session running on another instance
+func (c *redisCluster) agentOutput(sessionID string, from int64, limit int) ([]AgentOutputLine, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	payloads, err := c.client.LRange(ctx, c.outputKey(sessionID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	lines := []AgentOutputLine{}
+	for _, payload := range payloads {
+		var line AgentOutputLine
+		if json.Unmarshal([]byte(payload), &line) == nil && line.Seq >= from && len(lines) < limit {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// follow subscribes to the progress of a session. The subscription is active once
+// follow returns, so nothing published afterwards is missed.
+func (c *redisCluster) follow(ctx context.Context, kind, id string) (*redis.PubSub, error) {
+	sub := c.client.Subscribe(ctx, c.progressChannel(kind, id))
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	return sub, nil
+}
+
+// control sends an action to the instance running a session. It returns false when no
+// instance runs it.
+func (c *redisCluster) control(msg clusterControl) bool {
+	owner := c.owner(msg.Kind, msg.ID)
+	if owner == "" {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if err := c.publish(ctx, c.controlChannel(owner), msg); err != nil {
+		log.Printf("⚠️ Failed to send %s to session %s: %v", msg.Action, msg.ID, err)
+		return false
+	}
+	return true
+}
+
+// listen handles the control messages sent to this instance and the batch progress
+// published by any instance
+func (c *redisCluster) listen(sub *redis.PubSub) {
+	batchChannel := c.key("batch")
+	for msg := range sub.Channel() {
+		if msg.Channel == batchChannel {
+			var progress BatchProgress
+			if json.Unmarshal([]byte(msg.Payload), &progress) == nil {
+				deliverBatch(progress)
+			}
+			continue
+		}
+
+		var ctl clusterControl
+		if err := json.Unmarshal([]byte(msg.Payload), &ctl); err != nil {
+			continue
+		}
+		if err := handleClusterControl(ctl); err != nil {
+			log.Printf("⚠️ %s for session %s from another instance failed: %v", ctl.Action, ctl.ID, err)
+		}
+	}
+}
+
+// publishBatch sends batch progress to the streams of every instance
+func (c *redisCluster) publishBatch(progress BatchProgress) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	return c.publish(ctx, c.key("batch"), progress)
+}
+
+// handleClusterControl applies a control message to a session running here
+func handleClusterControl(ctl clusterControl) error {
+	if ctl.Kind == SessionKindCommand {
+		commandMu.RLock()
+		session, exists := commandSessions[ctl.ID]
+		commandMu.RUnlock()
+		if !exists {
+			return fmt.Errorf("command is not running here")
+		}
+		switch ctl.Action {
+		case "interrupt":
+			session.Cancel()
+		case WSMsgTypeInput:
+			if ctl.EOF {
+				return session.stdin.Close()
+			}
+			return session.stdin.WriteLine(ctl.Data)
+		case WSMsgTypeApprove, WSMsgTypeDeny:
+			return session.permissions.answer(ctl.Data, ctl.Action == WSMsgTypeApprove, ctl.Reason)
+		}
+		return nil
+	}
+
+	sessMu.RLock()
+	session, exists := sessions[ctl.ID]
+	sessMu.RUnlock()
+	if !exists {
+		return fmt.Errorf("session is not running here")
+	}
+	if ctl.Action == "interrupt" {
+		session.Cancel()
+	}
+	return nil
+}
+
+// runsElsewhere reports whether another live instance runs a session
+func runsElsewhere(kind, id string) bool {
+	if cluster == nil {
+		return false
+	}
+	owner := cluster.owner(kind, id)
+	return owner != "" && owner != cluster.instance
+}
+
+// followCommand streams a command that runs on another instance or connection: the
+// stored log first, then the updates as they are published. Interrupts and answers from
+// the client are forwarded to the instance running it. A command waiting in the job
+// queue is followed until a worker has run it, retries included.
+func followCommand(conn *websocket.Conn, db *gorm.DB, command *AICommand) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := cluster.follow(ctx, SessionKindCommand, command.ID)
+	if err != nil {
+		sendWSError(conn, "STREAM_UNAVAILABLE", "Failed to follow the command", err.Error())
+		return
+	}
+	defer sub.Close()
+	ended := cluster.owner(SessionKindCommand, command.ID) == "" && !awaitingWorker(db, command.ID)
+
+	// Replay what was logged before the subscription; later entries come from Redis
+	var entries []CommandLogEntry
+	if err := db.Where("command_id = ?", command.ID).Order("seq").Find(&entries).Error; err != nil {
+		sendWSError(conn, "DATABASE_ERROR", "Failed to load command log", err.Error())
+		return
+	}
+	batcher := newOutputBatcher(conn)
+	var next int64
+	for _, entry := range entries {
+		update := ProgressUpdate{Type: entry.Type, Timestamp: entry.Timestamp, Message: entry.Message, Continued: entry.Continued}
+		if entry.Data != "" {
+			update.Data = json.RawMessage(entry.Data)
+		}
+		if err := batcher.send(update); err != nil {
+			return
+		}
+		next = entry.Seq + 1
+	}
+
+	interrupted := make(chan struct{})
+	go func() {
+		defer cancel()
+		for {
+			msg, err := readWSMessage(conn)
+			if err != nil {
+				return
+			}
+			ctl := clusterControl{Kind: SessionKindCommand, ID: command.ID}
+			switch msg["type"] {
+			case "interrupt":
+				ctl.Action = "interrupt"
+			case WSMsgTypeInput:
+				ctl.Action = WSMsgTypeInput
+				ctl.Data, _ = msg["data"].(string)
+				ctl.EOF, _ = msg["eof"].(bool)
+			case WSMsgTypeApprove, WSMsgTypeDeny:
+				ctl.Action = msg["type"].(string)
+				ctl.Data, _ = msg["id"].(string)
+				ctl.Reason, _ = msg["reason"].(string)
+			case "ping":
+				sendWSMessage(conn, ProgressUpdate{Type: WSMsgTypePing, Timestamp: time.Now().Format(time.RFC3339)})
+				continue
+			default:
+				continue
+			}
+			if cluster.control(ctl) || ctl.Action != "interrupt" || commandQueue == nil {
+				continue
+			}
+			// Nobody runs the command yet; take it out of the job queue
+			if cancelled, _ := cancelQueuedCommand(db, command); cancelled {
+				close(interrupted)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	events := sub.Channel()
+	for !ended {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			var event clusterEvent
+			if json.Unmarshal([]byte(msg.Payload), &event) != nil {
+				continue
+			}
+			if event.End {
+				ended = !awaitingWorker(db, command.ID)
+				continue
+			}
+			if event.Update == nil || event.Seq < next {
+				continue
+			}
+			if err := batcher.send(*event.Update); err != nil {
+				return
+			}
+			next = event.Seq + 1
+
+		case <-batcher.due:
+			if err := batcher.flush(); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			// The instance running the command may have died without saying so
+			if shuttingDown.Load() || (cluster.owner(SessionKindCommand, command.ID) == "" && !awaitingWorker(db, command.ID)) {
+				ended = true
+				continue
+			}
+			batcher.send(ProgressUpdate{Type: WSMsgTypePing, Timestamp: time.Now().Format(time.RFC3339)})
+
+		case <-interrupted:
+			batcher.send(ProgressUpdate{
+				Type:      WSMsgTypeStatus,
+				Timestamp: time.Now().Format(time.RFC3339),
+				Message:   "Command was interrupted",
+			})
+			ended = true
+
+		case <-ctx.Done():
+			return
+		}
+	}
+	batcher.flush()
+
+	closeCode := websocket.CloseNormalClosure
+	if shuttingDown.Load() {
+		closeCode = websocket.CloseGoingAway
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""), time.Now().Add(time.Second))
+}
+
+// followAgent streams an agent session that runs on another instance as SSE: the lines
+// buffered in Redis from from on, then the lines as they are published
+func followAgent(w *bufio.Writer, sessionID string, from int64, serverDone <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := cluster.follow(ctx, SessionKindAgent, sessionID)
+	if err != nil {
+		log.Printf("⚠️ Failed to follow session %s: %v", sessionID, err)
+		return
+	}
+	defer sub.Close()
+	ended := cluster.owner(SessionKindAgent, sessionID) == ""
+
+	fmt.Fprintf(w, "data: {\"type\":\"connected\",\"session_id\":\"%s\"}\n\n", sessionID)
+	cursor := from
+	lines, err := cluster.agentOutput(sessionID, from, math.MaxInt)
+	if err != nil {
+		log.Printf("⚠️ Failed to read the output of session %s: %v", sessionID, err)
+	}
+	for _, line := range lines {
+		writeAgentEvent(w, line)
+		cursor = line.Seq + 1
+	}
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	events := sub.Channel()
+	for !ended {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			var event clusterEvent
+			if json.Unmarshal([]byte(msg.Payload), &event) != nil {
+				continue
+			}
+			if event.End {
+				ended = true
+				continue
+			}
+			if event.Line == nil || event.Seq < cursor {
+				continue
+			}
+			writeAgentEvent(w, *event.Line)
+			cursor = event.Seq + 1
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			// The instance running the session may have died without saying so
+			if cluster.owner(SessionKindAgent, sessionID) == "" {
+				ended = true
+				continue
+			}
+			fmt.Fprintf(w, ": keep-alive\n\n")
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+		case <-serverDone:
+			return
+		}
+	}
+	fmt.Fprintf(w, "data: {\"type\":\"closed\"}\n\n")
+	w.Flush()
+}
+
+// interruptRemoteCommand forwards an interrupt to the instance running a command
+func interruptRemoteCommand(c *fiber.Ctx, db *gorm.DB, commandID string) error {
+	var command AICommand
+	if err := db.Limit(1).Find(&command, "id = ?", commandID).Error; err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to load command", err.Error())
+	}
+	if !ownsOrAdmin(c, command.UserID) {
+		return sendError(c, 403, "NOT_COMMAND_OWNER", "Only the creator of the command or an admin can interrupt it", nil)
+	}
+	if !cluster.control(clusterControl{Kind: SessionKindCommand, ID: commandID, Action: "interrupt"}) {
+		return sendError(c, 404, "SESSION_NOT_FOUND", "Command session not found or already completed", nil)
+	}
+	recordAudit(db, c, AuditCommandInterrupt, commandID, nil, nil, "")
+
+	return c.JSON(APIResponse[CommandState]{
+		Success: true,
+		Message: "Command interrupted successfully",
+		Data: CommandState{
+			CommandID: commandID,
+			Status:    "interrupted",
+		},
+	})
+}
+
+// interruptRemoteAgent forwards an interrupt to the instance running an agent session
+func interruptRemoteAgent(c *fiber.Ctx, db *gorm.DB, sessionID string) error {
+	var record SessionRecord
+	if err := db.Limit(1).Find(&record, "id = ? AND kind <> ?", sessionID, SessionKindCommand).Error; err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to load session", err.Error())
+	}
+	if record.ID == "" {
+		return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", nil)
+	}
+	if !ownsOrAdmin(c, record.UserID) {
+		return sendError(c, 403, "NOT_SESSION_OWNER", "Only the user who started the session or an admin can interrupt it", nil)
+	}
+	if !cluster.control(clusterControl{Kind: record.Kind, ID: sessionID, Action: "interrupt"}) {
+		return sendError(c, 404, "SESSION_NOT_FOUND", "Session not found", nil)
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "interrupted",
+		"session_id": sessionID,
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"gorm.io/gorm"
+)
+
+// Content subscription message types
+const (
+	ContentMsgSnapshot    = "content_snapshot"
+	ContentMsgUpdated     = "content_updated"
+	ContentMsgPublished   = "content_published"
+	ContentMsgUnpublished = "content_unpublished"
+	ContentMsgDeleted     = "content_deleted"
+	ContentMsgRestored    = "content_restored"
+)
+
+// contentSubscriber is one open editor tab listening to a content block
+type contentSubscriber struct {
+	send chan ProgressUpdate
+	done chan struct{}
+	once sync.Once
+}
+
+// close stops the subscriber's writer; safe to call more than once
+func (s *contentSubscriber) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+var (
+	contentSubscribers = make(map[string]map[*contentSubscriber]struct{})
+	subscribersMu      sync.RWMutex
+)
+
+func subscribeContent(id string) *contentSubscriber {
+	sub := &contentSubscriber{
+		send: make(chan ProgressUpdate, 32),
+		done: make(chan struct{}),
+	}
+
+	subscribersMu.Lock()
+	if contentSubscribers[id] == nil {
+		contentSubscribers[id] = make(map[*contentSubscriber]struct{})
+	}
+	contentSubscribers[id][sub] = struct{}{}
+	subscribersMu.Unlock()
+	return sub
+}
+
+func unsubscribeContent(id string, sub *contentSubscriber) {
+	subscribersMu.Lock()
+	delete(contentSubscribers[id], sub)
+	if len(contentSubscribers[id]) == 0 {
+		delete(contentSubscribers, id)
+	}
+	subscribersMu.Unlock()
+	sub.close()
+}
+
+// broadcastContent notifies every subscriber of the block; source is the
+// X-Client-ID of the writer so tabs can ignore their own changes
+func broadcastContent(msgType string, content Content, source string) {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+
+	subs := contentSubscribers[content.ID]
+	if len(subs) == 0 {
+		return
+	}
+
+	update := ProgressUpdate{
+		Type:      msgType,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Data: fiber.Map{
+			"content": contentResponse(content, ContentStateDraft),
+			"source":  source,
+		},
+	}
+	for sub := range subs {
+		select {
+		case sub.send <- update:
+		default:
+			// Subscriber is too slow; drop it rather than blocking the writer
+			log.Printf("⚠️ Dropping slow subscriber of content %s", content.ID)
+			sub.close()
+		}
+	}
+}
+
+// closeAllSubscribers disconnects every subscriber (used on shutdown)
+func closeAllSubscribers() int {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+
+	count := 0
+	for _, subs := range contentSubscribers {
+		for sub := range subs {
+			sub.close()
+			count++
+		}
+	}
+	return count
+}
+
+// SubscribeContent streams changes of a content block over WebSocket
+func SubscribeContent(db *gorm.DB) fiber.Handler {
+	return websocket.New(func(conn *websocket.Conn) {
+		id := conn.Params("id")
+
+		sub := subscribeContent(id)
+		defer unsubscribeContent(id, sub)
+
+		// Send the current state so a tab that just opened is in sync
+		snapshot := emptyContentResponse(id)
+		locale, err := normalizeLocale(conn.Query("locale", getDefaultLocale()))
+		if err != nil {
+			locale = getDefaultLocale()
+		}
+		if content, err := findLocalizedContent(db, id, localeFallbacks(locale)); err == nil {
+			snapshot = localizedResponse(content, ContentStateDraft, locale)
+		}
+		if err := sendWSMessage(conn, ProgressUpdate{
+			Type:      ContentMsgSnapshot,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Data: fiber.Map{
+				"content": snapshot,
+			},
+		}); err != nil {
+			return
+		}
+
+		// Answer pings and notice when the client goes away
+		pings := make(chan struct{}, 1)
+		go func() {
+			defer sub.close()
+			for {
+				msg, err := readWSMessage(conn)
+				if err != nil {
+					return
+				}
+				if msgType, _ := msg["type"].(string); msgType == "ping" {
+					select {
+					case pings <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			var err error
+			select {
+			case update := <-sub.send:
+				err = sendWSMessage(conn, update)
+
+			case <-pings:
+				err = sendWSMessage(conn, ProgressUpdate{
+					Type:      WSMsgTypePing,
+					Timestamp: time.Now().Format(time.RFC3339),
+				})
+
+			case <-ticker.C:
+				// Send keep-alive ping
+				err = sendWSMessage(conn, ProgressUpdate{
+					Type:      WSMsgTypePing,
+					Timestamp: time.Now().Format(time.RFC3339),
+				})
+
+			case <-sub.done:
+				closeCode := websocket.CloseNormalClosure
+				if shuttingDown.Load() {
+					closeCode = websocket.CloseGoingAway
+				}
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}, streamWSConfig())
+}
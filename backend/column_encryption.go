@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// encryptedModels have string columns tagged serializer:encrypted: the prompts and
+// results of commands, their output and tools, conversation titles, audit details and
+// the prompts of favorites, macros, schedules and batches
+var encryptedModels = []interface{}{&AICommand{}, &CommandLogEntry{}, &ToolEvent{}, &Conversation{}, &AuditEvent{},
+	&PromptFavorite{}, &Macro{}, &ScheduledCommand{}, &BatchCommand{}}
+
+func init() {
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+// encryptedSerializer encrypts a string column with SECRETS_KEY, like the values of
+// project variables, so that a copy of the database doesn't give away what the column
+// holds. Without a key values are stored as they are. Values stored before the key was
+// set are read as they are until --encrypt encrypts them.
+type encryptedSerializer struct{}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("column %s: unexpected type %T for an encrypted value", field.DBName, dbValue)
+	}
+	if strings.HasPrefix(stored, secretPrefix) {
+		plain, err := decryptSecret(stored)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", field.DBName, err)
+		}
+		stored = plain
+	}
+	field.ReflectValueOf(ctx, dst).SetString(stored)
+	return nil
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plain, _ := fieldValue.(string)
+	if plain == "" {
+		return plain, nil
+	}
+	if _, err := getSecretsKey(); errors.Is(err, errSecretsKeyMissing) {
+		return plain, nil
+	}
+	return encryptSecret(plain)
+}
+
+// encryptExistingRows encrypts the values of the encrypted columns that were stored
+// before SECRETS_KEY was set and returns how many it encrypted
+func encryptExistingRows(db *gorm.DB) (int64, error) {
+	if _, err := getSecretsKey(); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, model := range encryptedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return total, err
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.TagSettings["SERIALIZER"] != "encrypted" {
+				continue
+			}
+			n, err := encryptColumn(db, stmt.Schema.Table, stmt.Schema.PrioritizedPrimaryField.DBName, field.DBName)
+			total += n
+			if err != nil {
+				return total, fmt.Errorf("failed to encrypt %s.%s: %w", stmt.Schema.Table, field.DBName, err)
+			}
+			if n > 0 {
+				log.Printf("🔐 Encrypted %d value(s) of %s.%s", n, stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+	return total, nil
+}
+
+// encryptColumn encrypts the plain values of one column in batches. The rows are
+// updated without their model, which audit events would refuse.
+func encryptColumn(db *gorm.DB, table, key, column string) (int64, error) {
+	var count int64
+	for {
+		var rows []map[string]interface{}
+		err := db.Table(table).Select(key, column).
+			Where(column+" <> '' AND "+column+" NOT LIKE ?", secretPrefix+"%").
+			Limit(500).Find(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return count, err
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				plain := row[column]
+				if b, ok := plain.([]byte); ok {
+					plain = string(b)
+				}
+				encrypted, err := encryptSecret(fmt.Sprint(plain))
+				if err != nil {
+					return err
+				}
+				if err := tx.Table(table).Where(key+" = ?", row[key]).Update(column, encrypted).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		count += int64(len(rows))
+	}
+}
+
+// runEncryptCommand handles --encrypt, which encrypts the existing rows and exits
+// without starting the server
+func runEncryptCommand() error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+
+	if pending, _, err := migrationState(db); err != nil {
+		return err
+	} else if len(pending) > 0 {
+		return fmt.Errorf("%d pending migration(s); apply them with --migrate first", len(pending))
+	}
+	n, err := encryptExistingRows(db)
+	if err != nil {
+		return err
+	}
+	log.Printf("🔐 %d value(s) encrypted", n)
+	return nil
+}
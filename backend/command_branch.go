@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// With AI_GIT_BRANCHES, commands in a git workspace run in a worktree of their own on
+// the branch ai/<command ID>, so commands running side by side do not overwrite each
+// other's files. The workspace only changes once a branch is merged.
+
+// maxBranchDiffBytes caps the patch of a branch diff
+const maxBranchDiffBytes = 256 << 10
+
+// branchMergeMu serializes merges, which check out into the shared workspace
+var branchMergeMu sync.Mutex
+
+// isGitBranchesEnabled returns true if commands run on their own branch (git.branches, AI_GIT_BRANCHES)
+func isGitBranchesEnabled() bool {
+	return appConfig.Git.Branches
+}
+
+// getWorktreeDir returns the directory that holds the worktrees of commands (git.worktreeDir, AI_GIT_WORKTREE_DIR)
+func getWorktreeDir() string {
+	return appConfig.Git.WorktreeDir
+}
+
+// commandWorktreePath returns the worktree a command runs in
+func commandWorktreePath(commandID string) (string, error) {
+	return filepath.Abs(filepath.Join(getWorktreeDir(), commandID))
+}
+
+// prepareCommandBranch creates the branch and worktree of a command and returns the
+// directory the command runs in. Commands that cannot have a branch run in the
+// workspace itself.
+func prepareCommandBranch(session *AICommandSession, db *gorm.DB, workspaceDir string) string {
+	command := session.Command
+	if !isGitBranchesEnabled() || command.Scope == ScopeTranslate || !isGitRepo(workspaceDir) {
+		return workspaceDir
+	}
+
+	base, err := runGit(workspaceDir, "rev-parse", "HEAD")
+	if err != nil {
+		log.Printf("⚠️ Workspace has no commit to branch from; command [%s] runs in the workspace: %v", command.ID, err)
+		return workspaceDir
+	}
+	base = strings.TrimSpace(base)
+	dir, err := commandWorktreePath(command.ID)
+	if err != nil {
+		log.Printf("⚠️ Failed to resolve the worktree of command [%s]: %v", command.ID, err)
+		return workspaceDir
+	}
+
+	// A retry by the job queue starts over from the current workspace
+	branch := "ai/" + command.ID
+	removeCommandWorktree(workspaceDir, dir)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		log.Printf("⚠️ Failed to create the worktree directory for command [%s]: %v", command.ID, err)
+		return workspaceDir
+	}
+	if _, err := runGit(workspaceDir, "worktree", "add", "-q", "-B", branch, dir, base); err != nil {
+		log.Printf("⚠️ Failed to create branch %s; command [%s] runs in the workspace: %v", branch, command.ID, err)
+		return workspaceDir
+	}
+
+	session.mu.Lock()
+	command.Branch = branch
+	command.BaseSHA = base
+	command.DiscardedAt = 0
+	session.mu.Unlock()
+	db.Model(command).Updates(map[string]interface{}{"branch": branch, "base_sha": base, "discarded_at": 0})
+
+	log.Printf("🌿 Command [%s] runs on branch %s from %s", command.ID, branch, base)
+	return dir
+}
+
+// removeCommandWorktree deletes the worktree at dir, if there is one
+func removeCommandWorktree(workspaceDir, dir string) {
+	if _, err := os.Stat(dir); err == nil {
+		if _, err := runGit(workspaceDir, "worktree", "remove", "--force", dir); err != nil {
+			os.RemoveAll(dir)
+		}
+	}
+	runGit(workspaceDir, "worktree", "prune")
+}
+
+// releaseCommandBranch deletes the worktree and branch of a command
+func releaseCommandBranch(workspaceDir string, command *AICommand) {
+	if dir, err := commandWorktreePath(command.ID); err == nil {
+		removeCommandWorktree(workspaceDir, dir)
+	}
+	if _, err := runGit(workspaceDir, "branch", "-q", "-D", command.Branch); err != nil && !strings.Contains(err.Error(), "not found") {
+		log.Printf("⚠️ Failed to delete branch %s: %v", command.Branch, err)
+	}
+}
+
+// discardCommandBranch releases the branch of a command and records that its changes
+// were dropped
+func discardCommandBranch(db *gorm.DB, command *AICommand, workspaceDir string) int64 {
+	releaseCommandBranch(workspaceDir, command)
+	now := time.Now().Unix()
+	command.DiscardedAt = now
+	db.Model(&AICommand{}).Where("id = ?", command.ID).Update("discarded_at", now)
+	return now
+}
+
+// discardAbandonedBranches releases the branches of commands that ended without
+// completing, for example because the backend stopped while they ran
+func discardAbandonedBranches(db *gorm.DB) int {
+	var commands []AICommand
+	err := db.Where("branch <> '' AND merged_at = 0 AND discarded_at = 0 AND status NOT IN ?",
+		[]string{"completed", "processing", "queued"}).Find(&commands).Error
+	if err != nil {
+		log.Printf("⚠️ Cleanup failed to query command branches: %v", err)
+		return 0
+	}
+
+	discarded := 0
+	for _, command := range commands {
+		workspaceDir, err := resolveWorkspaceDir(db, command.ProjectID)
+		if err != nil {
+			continue
+		}
+		discardCommandBranch(db, &command, workspaceDir)
+		discarded++
+	}
+	return discarded
+}
+
+// BranchDiff is what a command changed on its branch
+type BranchDiff struct {
+	CommandID string        `json:"commandId"`
+	Branch    string        `json:"branch"`
+	Base      string        `json:"base"`           // Workspace commit the branch started from
+	Head      string        `json:"head,omitempty"` // Commit of the command; empty when it changed nothing
+	Files     []GitFileStat `json:"files"`
+	Diff      string        `json:"diff"`
+	Truncated bool          `json:"truncated,omitempty"` // The patch was cut at 256 KiB
+}
+
+// commandBranchDiff returns the changes of a command relative to the commit its
+// branch started from
+func commandBranchDiff(workspaceDir string, command *AICommand) (*BranchDiff, error) {
+	diff := &BranchDiff{
+		CommandID: command.ID,
+		Branch:    command.Branch,
+		Base:      command.BaseSHA,
+		Head:      command.CommitSHA,
+		Files:     []GitFileStat{},
+	}
+	if command.CommitSHA == "" {
+		return diff, nil
+	}
+
+	stat, err := runGit(workspaceDir, "diff", "--numstat", command.BaseSHA, command.CommitSHA)
+	if err != nil {
+		return nil, err
+	}
+	diff.Files = parseNumstat(stat)
+
+	patch, err := runGit(workspaceDir, "diff", "--patch", command.BaseSHA, command.CommitSHA)
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) > maxBranchDiffBytes {
+		patch = patch[:maxBranchDiffBytes]
+		diff.Truncated = true
+	}
+	diff.Diff = patch
+	return diff, nil
+}
+
+// sendBranchDiff streams the changes of a command that ran on a branch
+func sendBranchDiff(session *AICommandSession, workspaceDir string) {
+	diff, err := commandBranchDiff(workspaceDir, session.Command)
+	if err != nil {
+		log.Printf("⚠️ Failed to diff branch %s: %v", session.Command.Branch, err)
+		return
+	}
+	session.publish(ProgressUpdate{
+		Type:      WSMsgTypeDiff,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Message:   fmt.Sprintf("%d file(s) changed on %s", len(diff.Files), diff.Branch),
+		Data:      diff,
+	})
+}
+
+// loadBranchCommand loads the command of a branch request and checks that its branch
+// can still be merged or discarded
+func loadBranchCommand(c *fiber.Ctx, db *gorm.DB) (*AICommand, error) {
+	var command AICommand
+	if err := db.Limit(1).Find(&command, "id = ?", c.Params("commandId")).Error; err != nil {
+		return nil, newAPIError(500, "DATABASE_ERROR", "Failed to load command", err.Error())
+	}
+	if command.ID == "" {
+		return nil, newAPIError(404, "COMMAND_NOT_FOUND", "Command not found", nil)
+	}
+	if command.Branch == "" {
+		return nil, newAPIError(409, "NO_COMMAND_BRANCH", "This command did not run on a branch of its own", nil)
+	}
+	if command.MergedAt != 0 {
+		return nil, newAPIError(409, "BRANCH_MERGED", "The branch of this command has already been merged", nil)
+	}
+	if command.DiscardedAt != 0 {
+		return nil, newAPIError(409, "BRANCH_DISCARDED", "The branch of this command has been discarded", nil)
+	}
+	return &command, nil
+}
+
+// GetCommandDiff returns the changes a command made on its branch
+func GetCommandDiff(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var command AICommand
+		if err := db.Limit(1).Find(&command, "id = ?", c.Params("commandId")).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load command", err.Error())
+		}
+		if command.ID == "" {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", nil)
+		}
+		if command.Branch == "" {
+			return sendError(c, 409, "NO_COMMAND_BRANCH", "This command did not run on a branch of its own", nil)
+		}
+
+		workspaceDir, err := resolveWorkspaceDir(db, command.ProjectID)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+		diff, err := commandBranchDiff(workspaceDir, &command)
+		if err != nil {
+			return gitFailed(c, err)
+		}
+		return c.JSON(APIResponse[BranchDiff]{Success: true, Data: *diff})
+	}
+}
+
+// BranchResult is the data of a response that merged or discarded a command branch
+type BranchResult struct {
+	CommandID   string `json:"commandId"`
+	Branch      string `json:"branch"`
+	MergeSHA    string `json:"mergeSha,omitempty"` // Empty when the command changed nothing
+	MergedAt    int64  `json:"mergedAt,omitempty"`
+	DiscardedAt int64  `json:"discardedAt,omitempty"`
+}
+
+// MergeCommandBranch merges the branch of a completed command into the branch checked
+// out in the workspace. A merge that conflicts is aborted and leaves the workspace as
+// it was.
+func MergeCommandBranch(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		command, err := loadBranchCommand(c, db)
+		if err != nil {
+			return err
+		}
+		if !ownsOrAdmin(c, command.UserID) {
+			return sendError(c, 403, "NOT_COMMAND_OWNER", "Only the creator of the command can merge it", nil)
+		}
+		if command.Status != "completed" {
+			return sendError(c, 409, "COMMAND_NOT_COMPLETED", "Only completed commands can be merged", "Current status: "+command.Status)
+		}
+		if workspaceBusy(command.ProjectID) {
+			return sendError(c, 409, "WORKSPACE_BUSY", "A command is running in this workspace", "")
+		}
+		workspaceDir, err := resolveWorkspaceDir(db, command.ProjectID)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+
+		branchMergeMu.Lock()
+		defer branchMergeMu.Unlock()
+
+		mergeSHA := ""
+		if command.CommitSHA != "" {
+			message := fmt.Sprintf("Merge %s: %s\n\nCommand: %s", command.Branch, command.Prompt, command.ID)
+			if _, err := runGit(workspaceDir, "merge", "--no-ff", "-q", "-m", message, command.CommitSHA); err != nil {
+				conflicts, _ := runGit(workspaceDir, "diff", "--name-only", "--diff-filter=U")
+				runGit(workspaceDir, "merge", "--abort")
+				if files := strings.Fields(conflicts); len(files) > 0 {
+					return sendError(c, 409, "MERGE_CONFLICT", "The branch conflicts with changes in the workspace", files)
+				}
+				return sendError(c, 409, "MERGE_FAILED", "Could not merge the branch", err.Error())
+			}
+			head, err := runGit(workspaceDir, "rev-parse", "HEAD")
+			if err != nil {
+				return gitFailed(c, err)
+			}
+			mergeSHA = strings.TrimSpace(head)
+		}
+
+		releaseCommandBranch(workspaceDir, command)
+		if result, err := parseCommandResult(command.Result); err == nil {
+			if err := applyCommandMenus(db, command, result.Menus); err != nil {
+				log.Printf("⚠️ Failed to save the menus changed by command [%s]: %v", command.ID, err)
+			}
+		}
+		now := time.Now().Unix()
+		db.Model(&AICommand{}).Where("id = ?", command.ID).Updates(map[string]interface{}{
+			"merged_at": now,
+			"merge_sha": mergeSHA,
+		})
+		recordAudit(db, c, AuditCommandMerge, command.ID, command.BaseSHA, mergeSHA, command.Branch)
+
+		log.Printf("🔀 Merged branch %s of command [%s] into the workspace", command.Branch, command.ID)
+		return c.JSON(APIResponse[BranchResult]{
+			Success: true,
+			Data: BranchResult{
+				CommandID: command.ID,
+				Branch:    command.Branch,
+				MergeSHA:  mergeSHA,
+				MergedAt:  now,
+			},
+		})
+	}
+}
+
+// DiscardCommandBranch deletes the branch of a command without merging it
+func DiscardCommandBranch(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		command, err := loadBranchCommand(c, db)
+		if err != nil {
+			return err
+		}
+		if !ownsOrAdmin(c, command.UserID) {
+			return sendError(c, 403, "NOT_COMMAND_OWNER", "Only the creator of the command can discard it", nil)
+		}
+		if command.Status == "processing" || command.Status == "queued" {
+			return sendError(c, 409, "COMMAND_RUNNING", "Interrupt the command before discarding its branch", "Current status: "+command.Status)
+		}
+		workspaceDir, err := resolveWorkspaceDir(db, command.ProjectID)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+
+		now := discardCommandBranch(db, command, workspaceDir)
+		recordAudit(db, c, AuditCommandDiscard, command.ID, command.CommitSHA, nil, command.Branch)
+
+		log.Printf("🗑️ Discarded branch %s of command [%s]", command.Branch, command.ID)
+		return c.JSON(APIResponse[BranchResult]{
+			Success: true,
+			Data: BranchResult{
+				CommandID:   command.ID,
+				Branch:      command.Branch,
+				DiscardedAt: now,
+			},
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// CommandLogEntry is one progress update of a command, kept after the stream closes
+type CommandLogEntry struct {
+	ID        uint   `gorm:"primaryKey"`
+	CommandID string `gorm:"uniqueIndex:idx_command_log_seq"`
+	Seq       int64  `gorm:"uniqueIndex:idx_command_log_seq"` // Position in the stream, starting at 0
+	Type      string
+	Message   string `gorm:"type:text;serializer:encrypted"`
+	Data      string `gorm:"type:text;serializer:encrypted"` // JSON-encoded ProgressUpdate.Data
+	Continued bool
+	Timestamp string
+}
+
+// maxCommandLogPage caps the number of entries returned per log request
+const maxCommandLogPage = 1000
+
+// record stores the update in the command log and returns it for sending.
+// It is only called from the processing goroutine, so the sequence needs no lock.
+func (s *AICommandSession) record(update ProgressUpdate) ProgressUpdate {
+	entry := CommandLogEntry{
+		CommandID: s.ID,
+		Seq:       s.logSeq,
+		Type:      update.Type,
+		Message:   update.Message,
+		Continued: update.Continued,
+		Timestamp: update.Timestamp,
+	}
+	if update.Data != nil {
+		if data, err := json.Marshal(update.Data); err == nil {
+			entry.Data = string(data)
+		}
+	}
+	s.logSeq++
+
+	if s.db != nil {
+		if err := s.db.Create(&entry).Error; err != nil {
+			log.Printf("⚠️ Failed to store log entry %d of command [%s]: %v", entry.Seq, s.ID, err)
+		}
+	}
+	if cluster != nil {
+		cluster.publishProgress(s.ID, entry.Seq, update)
+	}
+	return update
+}
+
+// nextCommandLogSeq continues numbering after any entries of an earlier run of the command
+func nextCommandLogSeq(db *gorm.DB, commandID string) int64 {
+	var last struct{ Max *int64 }
+	db.Model(&CommandLogEntry{}).Select("MAX(seq) AS max").Where("command_id = ?", commandID).Scan(&last)
+	if last.Max == nil {
+		return 0
+	}
+	return *last.Max + 1
+}
+
+// isTerminalStatus reports whether a command has finished and its log will not grow
+func isTerminalStatus(status string) bool {
+	switch status {
+	case "completed", "failed", "interrupted", "timed_out", StatusResourceLimit, "rejected":
+		return true
+	}
+	return false
+}
+
+// GetAICommandLog returns the stored progress updates of a command from ?offset= on
+func GetAICommandLog(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandID := c.Params("commandId")
+
+		var command AICommand
+		if err := db.First(&command, "id = ?", commandID).Error; err != nil {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", nil)
+		}
+
+		offset, err := strconv.ParseInt(c.Query("offset", "0"), 10, 64)
+		if err != nil || offset < 0 {
+			return sendError(c, 400, "INVALID_OFFSET", "offset must be a non-negative integer", nil)
+		}
+		limit := c.QueryInt("limit", maxCommandLogPage)
+		if limit <= 0 || limit > maxCommandLogPage {
+			limit = maxCommandLogPage
+		}
+
+		var entries []CommandLogEntry
+		if err := db.Where("command_id = ? AND seq >= ?", commandID, offset).Order("seq").Limit(limit).Find(&entries).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load command log", err.Error())
+		}
+
+		updates := make([]fiber.Map, 0, len(entries))
+		nextOffset := offset
+		for _, entry := range entries {
+			item := fiber.Map{
+				"seq":       entry.Seq,
+				"type":      entry.Type,
+				"timestamp": entry.Timestamp,
+			}
+			if entry.Message != "" {
+				item["message"] = entry.Message
+			}
+			if entry.Data != "" {
+				item["data"] = json.RawMessage(entry.Data)
+			}
+			if entry.Continued {
+				item["continued"] = true
+			}
+			updates = append(updates, item)
+			nextOffset = entry.Seq + 1
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"commandId":  command.ID,
+				"status":     command.Status,
+				"entries":    updates,
+				"nextOffset": nextOffset,
+				"complete":   isTerminalStatus(command.Status) && len(entries) < limit,
+			},
+		})
+	}
+}
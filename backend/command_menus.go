@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxCommandMenus caps the menus in the menu file of a command
+const maxCommandMenus = 50
+
+// CommandMenuFile is the file, next to the result file, in which a command finds the
+// menus of the site and edits them
+type CommandMenuFile struct {
+	Menus []MenuRequest `json:"menus" validate:"max=50,dive"`
+}
+
+// commandMenuFile returns the path of a command's menu file relative to the workspace
+func commandMenuFile(commandID string) string {
+	return path.Join(resultFileDir, "menus-"+sandboxNameUnsafe.ReplaceAllString(commandID, "_")+".json")
+}
+
+// menuNodeRequests turns rendered items back into the form they are sent in
+func menuNodeRequests(nodes []MenuNode) []MenuNodeRequest {
+	requests := make([]MenuNodeRequest, 0, len(nodes))
+	for _, node := range nodes {
+		requests = append(requests, MenuNodeRequest{
+			ID:       node.ID,
+			Label:    node.Label,
+			URL:      node.URL,
+			Target:   node.Target,
+			Children: menuNodeRequests(node.Children),
+		})
+	}
+	return requests
+}
+
+// prepareMenuFile writes the current menus into the workspace for the command to
+// edit and returns them, so the edit can be told apart from the menus as they were
+func prepareMenuFile(db *gorm.DB, workDir, commandID string) ([]MenuRequest, error) {
+	var menus []Menu
+	if err := db.Order("name").Limit(maxCommandMenus).Find(&menus).Error; err != nil {
+		return nil, err
+	}
+	file := CommandMenuFile{Menus: make([]MenuRequest, 0, len(menus))}
+	for _, menu := range menus {
+		tree, err := loadMenuTree(db, menu)
+		if err != nil {
+			return nil, err
+		}
+		file.Menus = append(file.Menus, MenuRequest{Name: tree.Name, Title: tree.Title, Items: menuNodeRequests(tree.Items)})
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	target := filepath.Join(workDir, filepath.FromSlash(commandMenuFile(commandID)))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		return nil, err
+	}
+	return file.Menus, nil
+}
+
+// menuFileInstructions tells the model to edit menus in the menu file
+func menuFileInstructions(commandID string) string {
+	return fmt.Sprintf("\n\nThe navigation menus of the site are stored in %s (relative to the current directory), not in the HTML. "+
+		"To change a menu, edit that file: keep the id of the items you keep, leave out the id of new items and nest items in children. "+
+		"A menu is added by appending it to the list; menus you remove from the file are kept. "+
+		"Do not edit the file if the navigation does not change.", commandMenuFile(commandID))
+}
+
+// removeMenuFile removes the menu file of a command that did not get to read it back
+func removeMenuFile(workDir, commandID string) {
+	os.Remove(filepath.Join(workDir, filepath.FromSlash(commandMenuFile(commandID))))
+}
+
+// readMenuFile reads, checks and removes the menu file of a command and returns the
+// menus that differ from before. Nothing is returned when the file is left unchanged.
+func readMenuFile(workDir, commandID string, before []MenuRequest) ([]MenuRequest, []ValidationProblem) {
+	file := filepath.Join(workDir, filepath.FromSlash(commandMenuFile(commandID)))
+	defer os.Remove(file)
+
+	info, err := os.Lstat(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err == nil && !info.Mode().IsRegular() {
+		return nil, []ValidationProblem{{Field: "menus", Code: "not_a_file", Message: "menu file is not a regular file"}}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, []ValidationProblem{{Field: "menus", Code: "unreadable", Message: err.Error()}}
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxResultFileSize+1))
+	if err != nil {
+		return nil, []ValidationProblem{{Field: "menus", Code: "unreadable", Message: err.Error()}}
+	}
+	if len(data) > maxResultFileSize {
+		return nil, []ValidationProblem{{Field: "menus", Code: "too_large", Message: fmt.Sprintf("menu file is larger than %d bytes", maxResultFileSize)}}
+	}
+
+	var edited CommandMenuFile
+	if err := json.Unmarshal(data, &edited); err != nil {
+		return nil, []ValidationProblem{{Field: "menus", Code: "invalid_json", Message: err.Error()}}
+	}
+	if problems := validateRequest(&edited); len(problems) > 0 {
+		return nil, problems
+	}
+
+	previous := make(map[string]string, len(before))
+	for _, menu := range before {
+		encoded, _ := json.Marshal(menu)
+		previous[menu.Name] = string(encoded)
+	}
+	var changed []MenuRequest
+	seen := make(map[string]bool, len(edited.Menus))
+	for _, menu := range edited.Menus {
+		if seen[menu.Name] {
+			return nil, []ValidationProblem{{Field: "menus", Code: "duplicate", Message: "menu " + menu.Name + " is listed twice"}}
+		}
+		seen[menu.Name] = true
+		if err := checkMenuNodes(menu.Items); err != nil {
+			apiErr := err.(*APIError)
+			return nil, []ValidationProblem{{Field: "menus", Code: strings.ToLower(apiErr.Code), Message: fmt.Sprintf("menu %s: %s (%v)", menu.Name, apiErr.Message, apiErr.Details)}}
+		}
+		if encoded, _ := json.Marshal(menu); previous[menu.Name] != string(encoded) {
+			changed = append(changed, menu)
+		}
+	}
+	return changed, nil
+}
+
+// applyCommandMenus saves the menus a command changed, creating the menus it added
+func applyCommandMenus(db *gorm.DB, command *AICommand, menus []MenuRequest) error {
+	if len(menus) == 0 {
+		return nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, req := range menus {
+			menu, err := findMenu(tx, req.Name)
+			if apiErr, ok := err.(*APIError); ok && apiErr.Status == 404 {
+				now := time.Now().Unix()
+				menu = Menu{ID: "menu_" + uuid.New().String()[:8], Name: req.Name, CreatedBy: command.UserID, CreatedAt: now, UpdatedAt: now}
+				err = tx.Create(&menu).Error
+			}
+			if err != nil {
+				return err
+			}
+			menu.Title = strings.TrimSpace(req.Title)
+			if err := tx.Model(&Menu{}).Where("id = ?", menu.ID).Update("title", menu.Title).Error; err != nil {
+				return err
+			}
+			if err := replaceMenuItems(tx, &menu, req.Items); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("🧭 Command [%s] changed %d menu(s)", command.ID, len(menus))
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// resultFileDir is the directory, relative to the workspace, the model writes its
+// result file to. It is left out of workspace snapshots.
+const resultFileDir = ".site-editor"
+
+// maxResultFileSize caps the result file read back from the workspace
+const maxResultFileSize = 256 * 1024
+
+// commandReportSchema is the JSON Schema of the result file written by the model
+const commandReportSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Site editor command result",
+  "type": "object",
+  "required": ["summary"],
+  "additionalProperties": false,
+  "properties": {
+    "summary": {"type": "string", "minLength": 1, "maxLength": 2000, "description": "One or two sentences on what was done"},
+    "filesChanged": {
+      "type": "array",
+      "maxItems": 500,
+      "items": {
+        "type": "object",
+        "required": ["path", "description"],
+        "additionalProperties": false,
+        "properties": {
+          "path": {"type": "string", "minLength": 1, "description": "Path relative to the workspace"},
+          "description": {"type": "string", "maxLength": 500}
+        }
+      }
+    },
+    "pagesCreated": {
+      "type": "array",
+      "maxItems": 100,
+      "items": {
+        "type": "object",
+        "required": ["path"],
+        "additionalProperties": false,
+        "properties": {
+          "path": {"type": "string", "minLength": 1, "description": "File of the page, relative to the workspace"},
+          "url": {"type": "string", "description": "URL path the page is served at, such as /about"},
+          "title": {"type": "string", "maxLength": 200}
+        }
+      }
+    },
+    "contentBlocks": {
+      "type": "array",
+      "maxItems": 500,
+      "items": {
+        "type": "object",
+        "required": ["id", "action"],
+        "additionalProperties": false,
+        "properties": {
+          "id": {"type": "string", "minLength": 1, "description": "ID of the editable block"},
+          "page": {"type": "string"},
+          "action": {"enum": ["created", "updated", "deleted"]},
+          "description": {"type": "string", "maxLength": 500}
+        }
+      }
+    },
+    "followUps": {
+      "type": "array",
+      "maxItems": 10,
+      "items": {"type": "string", "minLength": 1, "maxLength": 500},
+      "description": "Suggested next commands for the user"
+    }
+  }
+}`
+
+// commandReportValidator is the compiled commandReportSchema
+var commandReportValidator = func() *jsonschema.Schema {
+	schema, err := compileSchema(commandReportSchema)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}()
+
+// CommandReport is the result file the model writes at the end of a command
+type CommandReport struct {
+	Summary       string               `json:"summary"`
+	FilesChanged  []ReportedFile       `json:"filesChanged"`
+	PagesCreated  []CreatedPage        `json:"pagesCreated"`
+	ContentBlocks []ContentBlockChange `json:"contentBlocks"`
+	FollowUps     []string             `json:"followUps"`
+}
+
+// ReportedFile is a file the model says it changed
+type ReportedFile struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+// CreatedPage is a page added by a command
+type CreatedPage struct {
+	Path  string `json:"path"`
+	URL   string `json:"url,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// ContentBlockChange is an editable block created, updated or deleted by a command
+type ContentBlockChange struct {
+	ID          string `json:"id"`
+	Page        string `json:"page,omitempty"`
+	Action      string `json:"action"` // created, updated, deleted
+	Description string `json:"description,omitempty"`
+}
+
+// CommandResult is the stored result of a completed command (AICommand.Result). The
+// file changes come from the workspace; the rest comes from the model's result file.
+type CommandResult struct {
+	Action         string               `json:"action"`
+	AffectedPages  []string             `json:"affectedPages"`
+	Changes        []FileChange         `json:"changes"`
+	PagesCreated   []CreatedPage        `json:"pagesCreated"`
+	ContentBlocks  []ContentBlockChange `json:"contentBlocks"`
+	FollowUps      []string             `json:"followUps"`
+	NewPageURL     string               `json:"newPageUrl,omitempty"` // new-page scope
+	CommitSHA      string               `json:"commitSha,omitempty"`
+	Branch         string               `json:"branch,omitempty"`         // Branch to merge (AI_GIT_BRANCHES)
+	Reported       bool                 `json:"reported"`                 // The model wrote a valid result file
+	ReportProblems []ValidationProblem  `json:"reportProblems,omitempty"` // Why the result file was rejected
+	Translation    *TranslationResult   `json:"translation,omitempty"`    // translate scope
+	Menus          []MenuRequest        `json:"menus,omitempty"`          // Menus the command changed in its menu file
+}
+
+// parseCommandResult decodes AICommand.Result
+func parseCommandResult(raw string) (*CommandResult, error) {
+	var result CommandResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// commandResultFile returns the path of a command's result file relative to the workspace
+func commandResultFile(commandID string) string {
+	return path.Join(resultFileDir, "result-"+sandboxNameUnsafe.ReplaceAllString(commandID, "_")+".json")
+}
+
+// resultFileInstructions tells the model to describe its work in the result file
+func resultFileInstructions(commandID string) string {
+	var schema bytes.Buffer
+	json.Compact(&schema, []byte(commandReportSchema))
+	return fmt.Sprintf("\n\nWhen you are done, write a JSON summary of your work to %s (relative to the current directory), "+
+		"creating the directory if needed. It must match this JSON Schema: %s\n"+
+		"List every file you changed with paths relative to the current directory, the pages you created with the URL they are served at, "+
+		"the editable content blocks you created, updated or deleted, and up to three follow-up commands the user might want next. "+
+		"Do not mention the summary file in your answer.", commandResultFile(commandID), schema.String())
+}
+
+// prepareResultFile removes a stale result file left by an earlier run of the command
+func prepareResultFile(workDir, commandID string) {
+	os.Remove(filepath.Join(workDir, filepath.FromSlash(commandResultFile(commandID))))
+}
+
+// readCommandReport reads, validates and removes the result file of a command. It
+// returns nil without problems when the model wrote none.
+func readCommandReport(workDir, commandID string) (*CommandReport, []ValidationProblem) {
+	file := filepath.Join(workDir, filepath.FromSlash(commandResultFile(commandID)))
+	defer os.Remove(file)
+
+	info, err := os.Lstat(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err == nil && !info.Mode().IsRegular() {
+		return nil, []ValidationProblem{{Field: "result", Code: "not_a_file", Message: "result file is not a regular file"}}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, []ValidationProblem{{Field: "result", Code: "unreadable", Message: err.Error()}}
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxResultFileSize+1))
+	if err != nil {
+		return nil, []ValidationProblem{{Field: "result", Code: "unreadable", Message: err.Error()}}
+	}
+	if len(data) > maxResultFileSize {
+		return nil, []ValidationProblem{{Field: "result", Code: "too_large", Message: fmt.Sprintf("result file is larger than %d bytes", maxResultFileSize)}}
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, []ValidationProblem{{Field: "result", Code: "invalid_json", Message: err.Error()}}
+	}
+	if err := commandReportValidator.Validate(doc); err != nil {
+		return nil, schemaProblems("result", err)
+	}
+	var report CommandReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, []ValidationProblem{{Field: "result", Code: "invalid_json", Message: err.Error()}}
+	}
+	return &report, nil
+}
+
+// buildCommandResult combines the files that actually changed with the model's report.
+// Descriptions from the report replace the generic ones of the files it names.
+func buildCommandResult(command *AICommand, changes []FileChange, report *CommandReport, problems []ValidationProblem) *CommandResult {
+	result := &CommandResult{
+		Action:         fmt.Sprintf("Changed %d file(s) for %s", len(changes), command.Page),
+		AffectedPages:  affectedPagesFromChanges(changes),
+		Changes:        changes,
+		PagesCreated:   []CreatedPage{},
+		ContentBlocks:  []ContentBlockChange{},
+		FollowUps:      []string{},
+		ReportProblems: problems,
+	}
+	if report == nil {
+		return result
+	}
+
+	result.Reported = true
+	result.Action = report.Summary
+	descriptions := make(map[string]string, len(report.FilesChanged))
+	for _, file := range report.FilesChanged {
+		if file.Description != "" {
+			descriptions[cleanReportedPath(file.Path)] = file.Description
+		}
+	}
+	for i, change := range result.Changes {
+		if description, ok := descriptions[change.Target]; ok {
+			result.Changes[i].Description = description
+		}
+	}
+
+	for _, page := range report.PagesCreated {
+		page.Path = cleanReportedPath(page.Path)
+		result.PagesCreated = append(result.PagesCreated, page)
+	}
+	if report.ContentBlocks != nil {
+		result.ContentBlocks = report.ContentBlocks
+	}
+	if report.FollowUps != nil {
+		result.FollowUps = report.FollowUps
+	}
+	if command.Scope == "new-page" {
+		for _, page := range result.PagesCreated {
+			if page.URL != "" {
+				result.NewPageURL = page.URL
+				break
+			}
+		}
+	}
+	return result
+}
+
+// cleanReportedPath normalizes a workspace path from the model to the form of FileChange.Target
+func cleanReportedPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+// GetCommandResultSchema returns the JSON Schema of the result file, which is also
+// the shape of the model-reported fields of a command result
+func GetCommandResultSchema() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "application/schema+json")
+		return c.SendString(commandReportSchema)
+	}
+}
+
+// logReportProblems logs why a result file was rejected
+func logReportProblems(commandID string, problems []ValidationProblem) {
+	for _, problem := range problems {
+		log.Printf("⚠️ Invalid result file for command [%s]: %s: %s", commandID, problem.Field, problem.Message)
+	}
+}
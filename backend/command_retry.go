@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// retryableStatuses are the final states a command can be retried from
+var retryableStatuses = map[string]bool{
+	"failed":      true,
+	"interrupted": true,
+	"timed_out":   true,
+
+	StatusResourceLimit: true,
+}
+
+// RetryAICommand queues a copy of a failed or interrupted command with the same
+// prompt, scope, target and conversation. The copy records the original in RetryOf.
+func RetryAICommand(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandID := c.Params("commandId")
+
+		var original AICommand
+		if err := db.First(&original, "id = ?", commandID).Error; err != nil {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", "")
+		}
+		if !retryableStatuses[original.Status] {
+			return sendError(c, 409, "NOT_RETRYABLE", "Only failed, interrupted, timed out or resource-limited commands can be retried", "Current status: "+original.Status)
+		}
+
+		req := AICommandRequest{
+			Prompt:   original.Prompt,
+			Scope:    original.Scope,
+			Provider: original.Provider,
+			Context: CommandContext{
+				Page:        original.Page,
+				UserID:      original.UserID,
+				ProjectID:   original.ProjectID,
+				ComponentID: original.ComponentID,
+				Selector:    original.Selector,
+			},
+			ConversationID: original.ConversationID,
+		}
+		// Same checks as a new submission, since the role or settings may have changed
+		if req.Scope == "global" && !currentRole(c).Allows(RoleAdmin) {
+			return forbidden(c, RoleAdmin)
+		}
+		req.Context.UserID = currentUserID(c)
+		if _, err := getProvider(req.Provider); err != nil {
+			return sendError(c, 400, "INVALID_PROVIDER", "Invalid AI provider", err.Error())
+		}
+		if _, err := resolveWorkspaceDir(db, req.Context.ProjectID); err != nil {
+			if errors.Is(err, errProjectNotFound) {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", err.Error())
+			}
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+		if err := checkBudget(db, req.Context.ProjectID); err != nil {
+			return budgetErrorResponse(c, err)
+		}
+		if err := checkQuota(db, req.Context.UserID, req.Context.ProjectID); err != nil {
+			return quotaErrorResponse(c, err)
+		}
+
+		// Continue the original conversation, or start over if it was deleted
+		conversation, err := resolveConversation(db, req)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			req.ConversationID = ""
+			conversation, err = resolveConversation(db, req)
+		}
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create conversation", err.Error())
+		}
+
+		status := "queued"
+		reasons := approvalReasons(req)
+		if len(reasons) > 0 {
+			status = "pending_approval"
+		}
+
+		retryID := fmt.Sprintf("cmd_%d_%s", time.Now().Unix(), uuid.New().String()[:8])
+		command := &AICommand{
+			ID:        retryID,
+			Prompt:    req.Prompt,
+			Scope:     req.Scope,
+			Provider:  req.Provider,
+			Page:      req.Context.Page,
+			UserID:    req.Context.UserID,
+			ProjectID: req.Context.ProjectID,
+			Status:    status,
+			CreatedAt: time.Now().Unix(),
+
+			ConversationID: conversation.ID,
+			ComponentID:    req.Context.ComponentID,
+			Selector:       req.Context.Selector,
+			ApprovalReason: strings.Join(reasons, "; "),
+			RetryOf:        original.ID,
+			Locale:         original.Locale,
+			SourceLocale:   original.SourceLocale,
+			Priority:       original.Priority,
+		}
+		if err := db.Create(command).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create command", err.Error())
+		}
+		if err := dispatchCommand(db, command); err != nil {
+			return queueErrorResponse(c, err)
+		}
+
+		log.Printf("🔁 Command [%s] retries [%s] (%s)", retryID, original.ID, original.Status)
+		recordAudit(db, c, AuditCommandExecute, retryID, nil, command.Prompt, fmt.Sprintf("retry of %s, %s", original.ID, status))
+
+		data := QueuedCommand{
+			CommandID:      retryID,
+			RetryOf:        original.ID,
+			ConversationID: conversation.ID,
+			Status:         status,
+		}.withStream(c, command.UserID)
+		if status == "pending_approval" {
+			data.Reasons = reasons
+			data.Message = "Connect to WebSocket to be notified once the command is approved or rejected"
+		} else {
+			data.Message = "Connect to WebSocket to receive real-time updates"
+		}
+
+		return c.Status(201).JSON(APIResponse[QueuedCommand]{
+			Success: true,
+			Message: "Command queued for retry",
+			Data:    data,
+		})
+	}
+}
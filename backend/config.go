@@ -0,0 +1,865 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+// Config holds the settings read once at startup: defaults, overridden by the config
+// file (CONFIG_FILE, default config.yaml) and then by environment variables. The
+// variable of each setting is noted next to it.
+type Config struct {
+	Host                string         `yaml:"host" json:"host"`                               // HOST
+	Port                int            `yaml:"port" json:"port"`                               // PORT
+	LogLevel            string         `yaml:"logLevel" json:"logLevel"`                       // LOG_LEVEL
+	AuthEnabled         bool           `yaml:"authEnabled" json:"authEnabled"`                 // AUTH_ENABLED
+	Workspace           string         `yaml:"workspace" json:"workspace"`                     // CLAUDE_WORKSPACE_DIR
+	Database            DatabaseConfig `yaml:"database" json:"database"`                       // DB_*, DATABASE_URL, SQLITE_WAL
+	DefaultLocale       string         `yaml:"defaultLocale" json:"defaultLocale"`             // DEFAULT_LOCALE
+	CORS                CORSConfig     `yaml:"cors" json:"cors"`                               // CORS_*
+	TLS                 TLSConfig      `yaml:"tls" json:"tls"`                                 // TLS_*
+	WSOrigins           []string       `yaml:"wsOrigins" json:"wsOrigins"`                     // WS_ALLOWED_ORIGINS
+	AgentEnvAllowlist   []string       `yaml:"agentEnvAllowlist" json:"agentEnvAllowlist"`     // AGENT_ENV_ALLOWLIST
+	ChildEnvPassthrough []string       `yaml:"childEnvPassthrough" json:"childEnvPassthrough"` // CHILD_ENV_PASSTHROUGH
+	Timeouts            TimeoutConfig  `yaml:"timeouts" json:"timeouts"`
+
+	Assets      AssetConfig      `yaml:"assets" json:"assets"`           // ASSET_STORAGE, ASSET_DIR, ASSET_MAX_SIZE, ASSET_MAX_PIXELS, ASSET_VARIANT_*, ASSET_WORKERS
+	Execution   ExecutionConfig  `yaml:"execution" json:"execution"`     // AI_EXECUTION_DRIVER, AI_DOCKER_*
+	Processes   ProcessConfig    `yaml:"processes" json:"processes"`     // PROCESS_*_LIMIT, PROCESS_CGROUP_ROOT
+	Git         GitConfig        `yaml:"git" json:"git"`                 // AI_GIT_*
+	Redis       RedisConfig      `yaml:"redis" json:"redis"`             // REDIS_URL, REDIS_PREFIX
+	Streams     StreamConfig     `yaml:"streams" json:"streams"`         // STREAM_TOKEN_*, WS_COMPRESSION, WS_SEND_TIMEOUT, OUTPUT_MAX_LINE_SIZE
+	Email       EmailConfig      `yaml:"email" json:"email"`             // EMAIL_*, FORM_EMAIL_TO, SMTP_*, MAILGUN_*, SES_*
+	Isolation   IsolationConfig  `yaml:"isolation" json:"isolation"`     // AGENT_ISOLATION*
+	Queue       QueueConfig      `yaml:"queue" json:"queue"`             // AI_QUEUE*
+	Permissions PermissionConfig `yaml:"permissions" json:"permissions"` // AI_PERMISSION_*
+	Accounts    AccountConfig    `yaml:"accounts" json:"accounts"`       // AUTH_SIGNUP*, AUTH_*_TTL, AUTH_PUBLIC_URL, AUTH_LOGIN_REDIRECT
+	Teams       TeamConfig       `yaml:"teams" json:"teams"`             // TEAM_INVITE_*
+	Quotas      QuotaConfig      `yaml:"quotas" json:"quotas"`           // QUOTA_DEFAULT_PLAN
+	Notify      NotifyConfig     `yaml:"notify" json:"notify"`           // NOTIFY_MIN_DURATION
+	Backup      BackupConfig     `yaml:"backup" json:"backup"`           // BACKUP_DIR, BACKUP_KEEP, BACKUP_INTERVAL
+}
+
+// DatabaseConfig selects the database and sizes its connection pool
+type DatabaseConfig struct {
+	Driver          string         `yaml:"driver" json:"driver"`                   // sqlite, postgres or mysql
+	URL             string         `yaml:"url" json:"url"`                         // DSN; a file name for sqlite
+	MaxOpenConns    int            `yaml:"maxOpenConns" json:"maxOpenConns"`       // DB_MAX_OPEN_CONNS: 0 for no limit
+	MaxIdleConns    int            `yaml:"maxIdleConns" json:"maxIdleConns"`       // DB_MAX_IDLE_CONNS
+	ConnMaxLifetime configDuration `yaml:"connMaxLifetime" json:"connMaxLifetime"` // DB_CONN_MAX_LIFETIME: 0 keeps connections open
+	SQLiteWAL       bool           `yaml:"sqliteWal" json:"sqliteWal"`             // SQLITE_WAL: false keeps the journal mode of the file
+	BusyTimeout     configDuration `yaml:"busyTimeout" json:"busyTimeout"`         // DB_BUSY_TIMEOUT: how long SQLite waits for a lock
+}
+
+// TLSConfig enables HTTPS, and with it wss:// for the command stream, with a
+// certificate from disk or from Let's Encrypt
+type TLSConfig struct {
+	CertFile         string   `yaml:"certFile" json:"certFile"`                 // TLS_CERT_FILE: PEM certificate chain
+	KeyFile          string   `yaml:"keyFile" json:"keyFile"`                   // TLS_KEY_FILE: PEM private key
+	AutocertDomains  []string `yaml:"autocertDomains" json:"autocertDomains"`   // TLS_AUTOCERT_DOMAINS: names to get certificates for
+	AutocertEmail    string   `yaml:"autocertEmail" json:"autocertEmail"`       // TLS_AUTOCERT_EMAIL: contact for expiry notices
+	AutocertCacheDir string   `yaml:"autocertCacheDir" json:"autocertCacheDir"` // TLS_AUTOCERT_CACHE_DIR: account key and certificates
+	RedirectPort     int      `yaml:"redirectPort" json:"redirectPort"`         // TLS_REDIRECT_PORT: plain HTTP port redirecting to HTTPS; 0 for none
+}
+
+// CORSConfig is the CORS policy of the HTTP API
+type CORSConfig struct {
+	Origins          []string `yaml:"origins" json:"origins"`                   // CORS_ALLOWED_ORIGINS
+	Methods          []string `yaml:"methods" json:"methods"`                   // CORS_ALLOWED_METHODS
+	AllowCredentials bool     `yaml:"allowCredentials" json:"allowCredentials"` // CORS_ALLOW_CREDENTIALS: cookies and Authorization from browsers
+}
+
+// TimeoutConfig holds the time limits of commands, processes and shutdown
+type TimeoutConfig struct {
+	Command   configDuration `yaml:"command" json:"command"`     // AI_COMMAND_TIMEOUT
+	Agent     configDuration `yaml:"agent" json:"agent"`         // AGENT_TIMEOUT
+	AgentMax  configDuration `yaml:"agentMax" json:"agentMax"`   // AGENT_MAX_TIMEOUT
+	Build     configDuration `yaml:"build" json:"build"`         // BUILD_TIMEOUT
+	Deploy    configDuration `yaml:"deploy" json:"deploy"`       // DEPLOY_TIMEOUT
+	Shutdown  configDuration `yaml:"shutdown" json:"shutdown"`   // SHUTDOWN_TIMEOUT
+	KillGrace configDuration `yaml:"killGrace" json:"killGrace"` // PROCESS_KILL_GRACE
+}
+
+// AssetConfig selects where uploads are stored, limits them and selects the image
+// variants generated for them
+type AssetConfig struct {
+	Storage        string   `yaml:"storage" json:"storage"`               // ASSET_STORAGE: disk or s3 (ASSET_S3_*)
+	Dir            string   `yaml:"dir" json:"dir"`                       // ASSET_DIR: directory of disk storage
+	MaxSize        int      `yaml:"maxSize" json:"maxSize"`               // ASSET_MAX_SIZE: upload limit in bytes
+	MaxPixels      int64    `yaml:"maxPixels" json:"maxPixels"`           // ASSET_MAX_PIXELS: largest image decoded for variants
+	VariantWidths  []int    `yaml:"variantWidths" json:"variantWidths"`   // ASSET_VARIANT_WIDTHS
+	VariantFormats []string `yaml:"variantFormats" json:"variantFormats"` // ASSET_VARIANT_FORMATS: besides the original format
+	Workers        int      `yaml:"workers" json:"workers"`               // ASSET_WORKERS
+}
+
+// ExecutionConfig selects where the Claude CLI of AI commands runs
+type ExecutionConfig struct {
+	Driver         string `yaml:"driver" json:"driver"`                 // AI_EXECUTION_DRIVER: host or docker
+	DockerImage    string `yaml:"dockerImage" json:"dockerImage"`       // AI_DOCKER_IMAGE: needs the claude CLI on its PATH
+	DockerStateDir string `yaml:"dockerStateDir" json:"dockerStateDir"` // AI_DOCKER_STATE_DIR: HOME of the containers
+	DockerNetwork  string `yaml:"dockerNetwork" json:"dockerNetwork"`   // AI_DOCKER_NETWORK: empty for Docker's bridge network
+}
+
+// ProcessConfig limits the resources of spawned processes; zero is unlimited
+type ProcessConfig struct {
+	CPULimit    float64        `yaml:"cpuLimit" json:"cpuLimit"`       // PROCESS_CPU_LIMIT: cores
+	MemoryLimit configByteSize `yaml:"memoryLimit" json:"memoryLimit"` // PROCESS_MEMORY_LIMIT
+	OutputLimit configByteSize `yaml:"outputLimit" json:"outputLimit"` // PROCESS_OUTPUT_LIMIT: bytes of stdout and stderr
+	CgroupRoot  string         `yaml:"cgroupRoot" json:"cgroupRoot"`   // PROCESS_CGROUP_ROOT
+}
+
+// GitConfig holds what AI commands do in workspaces that are git repositories
+type GitConfig struct {
+	AutoCommit  bool   `yaml:"autoCommit" json:"autoCommit"`   // AI_GIT_AUTOCOMMIT
+	Branches    bool   `yaml:"branches" json:"branches"`       // AI_GIT_BRANCHES: a worktree and branch per command
+	WorktreeDir string `yaml:"worktreeDir" json:"worktreeDir"` // AI_GIT_WORKTREE_DIR
+}
+
+// RedisConfig connects the instances of a cluster
+type RedisConfig struct {
+	URL    string `yaml:"url" json:"url"`       // REDIS_URL: empty runs alone
+	Prefix string `yaml:"prefix" json:"prefix"` // REDIS_PREFIX: prepended to every key and channel
+}
+
+// StreamConfig holds the settings of command streams
+type StreamConfig struct {
+	TokenSecret string         `yaml:"tokenSecret" json:"tokenSecret"` // STREAM_TOKEN_SECRET: empty signs with a random key
+	TokenTTL    configDuration `yaml:"tokenTtl" json:"tokenTtl"`       // STREAM_TOKEN_TTL
+	Compression bool           `yaml:"compression" json:"compression"` // WS_COMPRESSION: permessage-deflate
+	SendTimeout configDuration `yaml:"sendTimeout" json:"sendTimeout"` // WS_SEND_TIMEOUT
+	MaxLineSize int            `yaml:"maxLineSize" json:"maxLineSize"` // OUTPUT_MAX_LINE_SIZE: bytes
+}
+
+// EmailConfig holds the default email settings and the accounts of its drivers
+type EmailConfig struct {
+	Driver  string        `yaml:"driver" json:"driver"`   // EMAIL_DRIVER: smtp, mailgun or ses; smtp when smtp.host is set
+	From    string        `yaml:"from" json:"from"`       // EMAIL_FROM, SMTP_FROM
+	FormTo  []string      `yaml:"formTo" json:"formTo"`   // FORM_EMAIL_TO
+	SMTP    SMTPConfig    `yaml:"smtp" json:"smtp"`       // SMTP_*
+	Mailgun MailgunConfig `yaml:"mailgun" json:"mailgun"` // MAILGUN_*
+	SES     SESConfig     `yaml:"ses" json:"ses"`         // SES_*
+}
+
+// SMTPConfig is the account of the smtp email driver
+type SMTPConfig struct {
+	Host     string `yaml:"host" json:"host"`         // SMTP_HOST
+	Port     int    `yaml:"port" json:"port"`         // SMTP_PORT
+	Username string `yaml:"username" json:"username"` // SMTP_USERNAME
+	Password string `yaml:"password" json:"password"` // SMTP_PASSWORD
+}
+
+// MailgunConfig is the account of the mailgun email driver
+type MailgunConfig struct {
+	Domain string `yaml:"domain" json:"domain"` // MAILGUN_DOMAIN
+	APIKey string `yaml:"apiKey" json:"apiKey"` // MAILGUN_API_KEY
+	Region string `yaml:"region" json:"region"` // MAILGUN_REGION: us or eu
+}
+
+// SESConfig is the account of the ses email driver
+type SESConfig struct {
+	Region    string `yaml:"region" json:"region"`       // SES_REGION, AWS_REGION
+	AccessKey string `yaml:"accessKey" json:"accessKey"` // SES_ACCESS_KEY
+	SecretKey string `yaml:"secretKey" json:"secretKey"` // SES_SECRET_KEY
+}
+
+// IsolationConfig is the sandbox of agent runs of projects without an "agentSandbox"
+// setting, and the least isolation such a setting may choose
+type IsolationConfig struct {
+	Mode    string   `yaml:"mode" json:"mode"`       // AGENT_ISOLATION: none, directory, nsjail or docker
+	Dir     string   `yaml:"dir" json:"dir"`         // AGENT_ISOLATION_DIR: per-user sandbox directories
+	Image   string   `yaml:"image" json:"image"`     // AGENT_ISOLATION_IMAGE: image of docker sandboxes
+	Images  []string `yaml:"images" json:"images"`   // AGENT_ISOLATION_IMAGES: other images projects may choose
+	Network bool     `yaml:"network" json:"network"` // AGENT_ISOLATION_NETWORK
+	Paths   []string `yaml:"paths" json:"paths"`     // AGENT_ISOLATION_PATHS: read-only mounts of nsjail sandboxes
+}
+
+// QueueConfig selects where AI commands run
+type QueueConfig struct {
+	Mode     string `yaml:"mode" json:"mode"`         // AI_QUEUE: local or asynq
+	Workers  int    `yaml:"workers" json:"workers"`   // AI_QUEUE_WORKERS: 0 only enqueues
+	MaxRetry int    `yaml:"maxRetry" json:"maxRetry"` // AI_QUEUE_MAX_RETRY
+}
+
+// PermissionConfig decides which tools of the Claude CLI wait for the client's approval
+type PermissionConfig struct {
+	Mode    string         `yaml:"mode" json:"mode"`       // AI_PERMISSION_MODE: off or prompt
+	Tools   []string       `yaml:"tools" json:"tools"`     // AI_PERMISSION_TOOLS: * for every tool
+	Writes  string         `yaml:"writes" json:"writes"`   // AI_PERMISSION_WRITES: outside-page, all or none
+	Timeout configDuration `yaml:"timeout" json:"timeout"` // AI_PERMISSION_TIMEOUT
+}
+
+// AccountConfig holds the settings of signed in users
+type AccountConfig struct {
+	Signup        bool           `yaml:"signup" json:"signup"`               // AUTH_SIGNUP
+	SignupRole    Role           `yaml:"signupRole" json:"signupRole"`       // AUTH_SIGNUP_ROLE
+	AccessTTL     configDuration `yaml:"accessTtl" json:"accessTtl"`         // AUTH_ACCESS_TTL
+	RefreshTTL    configDuration `yaml:"refreshTtl" json:"refreshTtl"`       // AUTH_REFRESH_TTL
+	PublicURL     string         `yaml:"publicUrl" json:"publicUrl"`         // AUTH_PUBLIC_URL: empty uses the address of the request
+	LoginRedirect string         `yaml:"loginRedirect" json:"loginRedirect"` // AUTH_LOGIN_REDIRECT
+}
+
+// TeamConfig holds the settings of team invites
+type TeamConfig struct {
+	InviteTTL configDuration `yaml:"inviteTtl" json:"inviteTtl"` // TEAM_INVITE_TTL
+	InviteURL string         `yaml:"inviteUrl" json:"inviteUrl"` // TEAM_INVITE_URL: empty uses the page the invite was sent from
+}
+
+// QuotaConfig holds the settings of quota plans
+type QuotaConfig struct {
+	DefaultPlan string `yaml:"defaultPlan" json:"defaultPlan"` // QUOTA_DEFAULT_PLAN: plan of users without one
+}
+
+// NotifyConfig holds the defaults of notification channels
+type NotifyConfig struct {
+	MinDuration configDuration `yaml:"minDuration" json:"minDuration"` // NOTIFY_MIN_DURATION
+}
+
+// BackupConfig holds where backups go and how often they are made
+type BackupConfig struct {
+	Dir      string         `yaml:"dir" json:"dir"`           // BACKUP_DIR
+	Keep     int            `yaml:"keep" json:"keep"`         // BACKUP_KEEP: 0 keeps all of them
+	Interval configDuration `yaml:"interval" json:"interval"` // BACKUP_INTERVAL: 0 leaves backups to POST /api/admin/backup
+}
+
+// configDuration is a duration written as "15m" or as a number of seconds
+type configDuration time.Duration
+
+func (d configDuration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d *configDuration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := parseConfigDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = parsed
+	return nil
+}
+
+func (d configDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// parseConfigDuration accepts a Go duration ("15m") or a number of seconds ("900")
+func parseConfigDuration(value string) (configDuration, error) {
+	value = strings.TrimSpace(value)
+	if d, err := time.ParseDuration(value); err == nil {
+		return configDuration(d), nil
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return configDuration(time.Duration(secs) * time.Second), nil
+	}
+	return 0, fmt.Errorf("invalid duration %q (use e.g. 15m or a number of seconds)", value)
+}
+
+// configByteSize is a size written as 512M, 2G or a number of bytes
+type configByteSize int64
+
+func (s *configByteSize) UnmarshalYAML(node *yaml.Node) error {
+	n, err := parseByteSize(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w (use e.g. 512M or a number of bytes)", node.Line, err)
+	}
+	*s = configByteSize(n)
+	return nil
+}
+
+// appConfig is the effective configuration; main replaces it with loadConfig before
+// anything reads it
+var appConfig = defaultConfig()
+
+// appConfigFile and appConfigEnv record where appConfig came from, for GET /api/admin/config
+var (
+	appConfigFile string
+	appConfigEnv  []string
+)
+
+func defaultConfig() *Config {
+	return &Config{
+		Port:      9000,
+		Workspace: "/workspace/code",
+		Database: DatabaseConfig{
+			Driver:       "sqlite",
+			URL:          "content.db",
+			MaxIdleConns: 2,
+			SQLiteWAL:    true,
+			BusyTimeout:  configDuration(5 * time.Second),
+		},
+		DefaultLocale: "en",
+		CORS: CORSConfig{
+			Origins: []string{"*"},
+			Methods: []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD"},
+		},
+		TLS:                 TLSConfig{AutocertCacheDir: "autocert"},
+		WSOrigins:           []string{"*"},
+		AgentEnvAllowlist:   splitConfigList(defaultAgentEnvAllowlist),
+		ChildEnvPassthrough: splitConfigList(defaultEnvPassthrough),
+		Timeouts: TimeoutConfig{
+			Command:   configDuration(10 * time.Minute),
+			Agent:     configDuration(30 * time.Minute),
+			AgentMax:  configDuration(2 * time.Hour),
+			Build:     configDuration(15 * time.Minute),
+			Deploy:    configDuration(30 * time.Minute),
+			Shutdown:  configDuration(30 * time.Second),
+			KillGrace: configDuration(5 * time.Second),
+		},
+		Assets: AssetConfig{
+			Storage:        "disk",
+			Dir:            "uploads",
+			MaxSize:        10 << 20,
+			MaxPixels:      50_000_000,
+			VariantWidths:  []int{320, 640, 1024, 1920},
+			VariantFormats: []string{"webp", "avif"},
+			Workers:        2,
+		},
+		Execution: ExecutionConfig{Driver: ExecutionHost, DockerStateDir: "docker-home"},
+		Processes: ProcessConfig{CgroupRoot: "/sys/fs/cgroup/site-editor"},
+		Git:       GitConfig{WorktreeDir: "worktrees"},
+		Redis:     RedisConfig{Prefix: "site-editor:"},
+		Streams: StreamConfig{
+			TokenTTL:    configDuration(10 * time.Minute),
+			Compression: true,
+			SendTimeout: configDuration(10 * time.Second),
+			MaxLineSize: 4 << 20,
+		},
+		Email: EmailConfig{
+			SMTP:    SMTPConfig{Port: 587},
+			Mailgun: MailgunConfig{Region: "us"},
+		},
+		Isolation: IsolationConfig{
+			Mode:    IsolationNone,
+			Dir:     "sandboxes",
+			Image:   "node:20",
+			Network: true,
+			Paths:   splitConfigList(defaultSandboxPath),
+		},
+		Queue:       QueueConfig{Mode: "local", Workers: 2, MaxRetry: 2},
+		Permissions: PermissionConfig{Mode: "off", Tools: []string{"Bash"}, Writes: "outside-page", Timeout: configDuration(5 * time.Minute)},
+		Accounts: AccountConfig{
+			SignupRole: RoleViewer,
+			AccessTTL:  configDuration(15 * time.Minute),
+			RefreshTTL: configDuration(30 * 24 * time.Hour),
+		},
+		Teams:  TeamConfig{InviteTTL: configDuration(7 * 24 * time.Hour)},
+		Notify: NotifyConfig{MinDuration: configDuration(time.Minute)},
+		Backup: BackupConfig{Dir: "backups", Keep: 10},
+	}
+}
+
+// getConfigFile returns the path of the config file from CONFIG_FILE
+// Falls back to config.yaml, which may be missing
+func getConfigFile() string {
+	return getEnvDefault("CONFIG_FILE", "config.yaml")
+}
+
+// loadConfig reads the config file and the environment and validates the result.
+// A missing config.yaml is fine; a missing CONFIG_FILE is not.
+func loadConfig() (*Config, string, []string, error) {
+	cfg := defaultConfig()
+
+	path := getConfigFile()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, "", nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case os.IsNotExist(err) && os.Getenv("CONFIG_FILE") == "":
+		path = ""
+	default:
+		return nil, "", nil, err
+	}
+
+	overridden, envErr := cfg.applyEnv()
+	if err := errors.Join(envErr, cfg.validate()); err != nil {
+		return nil, "", nil, err
+	}
+	return cfg, path, overridden, nil
+}
+
+// applyEnv overrides settings with the environment variables that are set and returns
+// their names
+func (cfg *Config) applyEnv() ([]string, error) {
+	overridden := []string{}
+	var errs []error
+	lookup := func(key string) (string, bool) {
+		value := strings.TrimSpace(os.Getenv(key))
+		if value == "" {
+			return "", false
+		}
+		overridden = append(overridden, key)
+		return value, true
+	}
+	str := func(key string, dst *string) {
+		if value, ok := lookup(key); ok {
+			*dst = value
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if value, ok := lookup(key); ok {
+			*dst = splitConfigList(value)
+		}
+	}
+	integer := func(key string, dst *int) {
+		if value, ok := lookup(key); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid number %q", key, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if value, ok := lookup(key); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid boolean %q", key, value))
+				return
+			}
+			*dst = b
+		}
+	}
+	number := func(key string, dst *float64) {
+		if value, ok := lookup(key); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid number %q", key, value))
+				return
+			}
+			*dst = f
+		}
+	}
+	size := func(key string, dst *configByteSize) {
+		if value, ok := lookup(key); ok {
+			n, err := parseByteSize(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w (use e.g. 512M or a number of bytes)", key, err))
+				return
+			}
+			*dst = configByteSize(n)
+		}
+	}
+	duration := func(key string, dst *configDuration) {
+		if value, ok := lookup(key); ok {
+			d, err := parseConfigDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	if value, ok := lookup("PORT"); ok {
+		if port, err := strconv.Atoi(value); err == nil {
+			cfg.Port = port
+		} else {
+			errs = append(errs, fmt.Errorf("PORT: invalid port %q", value))
+		}
+	}
+	if value, ok := lookup("TLS_REDIRECT_PORT"); ok {
+		if port, err := strconv.Atoi(value); err == nil {
+			cfg.TLS.RedirectPort = port
+		} else {
+			errs = append(errs, fmt.Errorf("TLS_REDIRECT_PORT: invalid port %q", value))
+		}
+	}
+	if value, ok := lookup("CORS_ALLOW_CREDENTIALS"); ok {
+		if allow, err := strconv.ParseBool(value); err == nil {
+			cfg.CORS.AllowCredentials = allow
+		} else {
+			errs = append(errs, fmt.Errorf("CORS_ALLOW_CREDENTIALS: invalid boolean %q", value))
+		}
+	}
+	str("HOST", &cfg.Host)
+	str("LOG_LEVEL", &cfg.LogLevel)
+	str("CLAUDE_WORKSPACE_DIR", &cfg.Workspace)
+	boolean("AUTH_ENABLED", &cfg.AuthEnabled)
+	str("DB_DRIVER", &cfg.Database.Driver)
+	str("DATABASE_URL", &cfg.Database.URL)
+	integer("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	integer("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	duration("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+	boolean("SQLITE_WAL", &cfg.Database.SQLiteWAL)
+	duration("DB_BUSY_TIMEOUT", &cfg.Database.BusyTimeout)
+	str("DEFAULT_LOCALE", &cfg.DefaultLocale)
+	list("CORS_ALLOWED_ORIGINS", &cfg.CORS.Origins)
+	list("CORS_ALLOWED_METHODS", &cfg.CORS.Methods)
+	list("WS_ALLOWED_ORIGINS", &cfg.WSOrigins)
+	str("TLS_CERT_FILE", &cfg.TLS.CertFile)
+	str("TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	list("TLS_AUTOCERT_DOMAINS", &cfg.TLS.AutocertDomains)
+	str("TLS_AUTOCERT_EMAIL", &cfg.TLS.AutocertEmail)
+	str("TLS_AUTOCERT_CACHE_DIR", &cfg.TLS.AutocertCacheDir)
+	list("AGENT_ENV_ALLOWLIST", &cfg.AgentEnvAllowlist)
+	list("CHILD_ENV_PASSTHROUGH", &cfg.ChildEnvPassthrough)
+	duration("AI_COMMAND_TIMEOUT", &cfg.Timeouts.Command)
+	duration("AGENT_TIMEOUT", &cfg.Timeouts.Agent)
+	duration("AGENT_MAX_TIMEOUT", &cfg.Timeouts.AgentMax)
+	duration("BUILD_TIMEOUT", &cfg.Timeouts.Build)
+	duration("DEPLOY_TIMEOUT", &cfg.Timeouts.Deploy)
+	duration("SHUTDOWN_TIMEOUT", &cfg.Timeouts.Shutdown)
+	duration("PROCESS_KILL_GRACE", &cfg.Timeouts.KillGrace)
+
+	str("ASSET_STORAGE", &cfg.Assets.Storage)
+	str("ASSET_DIR", &cfg.Assets.Dir)
+	integer("ASSET_MAX_SIZE", &cfg.Assets.MaxSize)
+	if value, ok := lookup("ASSET_MAX_PIXELS"); ok {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			cfg.Assets.MaxPixels = n
+		} else {
+			errs = append(errs, fmt.Errorf("ASSET_MAX_PIXELS: invalid number %q", value))
+		}
+	}
+	if value, ok := lookup("ASSET_VARIANT_WIDTHS"); ok {
+		cfg.Assets.VariantWidths = nil
+		for _, item := range splitConfigList(value) {
+			if w, err := strconv.Atoi(item); err == nil {
+				cfg.Assets.VariantWidths = append(cfg.Assets.VariantWidths, w)
+			} else {
+				errs = append(errs, fmt.Errorf("ASSET_VARIANT_WIDTHS: invalid width %q", item))
+			}
+		}
+	}
+	list("ASSET_VARIANT_FORMATS", &cfg.Assets.VariantFormats)
+	integer("ASSET_WORKERS", &cfg.Assets.Workers)
+	str("AI_EXECUTION_DRIVER", &cfg.Execution.Driver)
+	str("AI_DOCKER_IMAGE", &cfg.Execution.DockerImage)
+	str("AI_DOCKER_STATE_DIR", &cfg.Execution.DockerStateDir)
+	str("AI_DOCKER_NETWORK", &cfg.Execution.DockerNetwork)
+	number("PROCESS_CPU_LIMIT", &cfg.Processes.CPULimit)
+	size("PROCESS_MEMORY_LIMIT", &cfg.Processes.MemoryLimit)
+	size("PROCESS_OUTPUT_LIMIT", &cfg.Processes.OutputLimit)
+	str("PROCESS_CGROUP_ROOT", &cfg.Processes.CgroupRoot)
+	boolean("AI_GIT_AUTOCOMMIT", &cfg.Git.AutoCommit)
+	boolean("AI_GIT_BRANCHES", &cfg.Git.Branches)
+	str("AI_GIT_WORKTREE_DIR", &cfg.Git.WorktreeDir)
+	str("REDIS_URL", &cfg.Redis.URL)
+	str("REDIS_PREFIX", &cfg.Redis.Prefix)
+	str("STREAM_TOKEN_SECRET", &cfg.Streams.TokenSecret)
+	duration("STREAM_TOKEN_TTL", &cfg.Streams.TokenTTL)
+	boolean("WS_COMPRESSION", &cfg.Streams.Compression)
+	duration("WS_SEND_TIMEOUT", &cfg.Streams.SendTimeout)
+	integer("OUTPUT_MAX_LINE_SIZE", &cfg.Streams.MaxLineSize)
+	str("EMAIL_DRIVER", &cfg.Email.Driver)
+	str("SMTP_FROM", &cfg.Email.From) // Older name of EMAIL_FROM, which wins
+	str("EMAIL_FROM", &cfg.Email.From)
+	list("FORM_EMAIL_TO", &cfg.Email.FormTo)
+	str("SMTP_HOST", &cfg.Email.SMTP.Host)
+	integer("SMTP_PORT", &cfg.Email.SMTP.Port)
+	str("SMTP_USERNAME", &cfg.Email.SMTP.Username)
+	str("SMTP_PASSWORD", &cfg.Email.SMTP.Password)
+	str("MAILGUN_DOMAIN", &cfg.Email.Mailgun.Domain)
+	str("MAILGUN_API_KEY", &cfg.Email.Mailgun.APIKey)
+	str("MAILGUN_REGION", &cfg.Email.Mailgun.Region)
+	str("AWS_REGION", &cfg.Email.SES.Region) // Used when SES_REGION is not set
+	str("SES_REGION", &cfg.Email.SES.Region)
+	str("SES_ACCESS_KEY", &cfg.Email.SES.AccessKey)
+	str("SES_SECRET_KEY", &cfg.Email.SES.SecretKey)
+	str("AGENT_ISOLATION", &cfg.Isolation.Mode)
+	str("AGENT_ISOLATION_DIR", &cfg.Isolation.Dir)
+	str("AGENT_ISOLATION_IMAGE", &cfg.Isolation.Image)
+	list("AGENT_ISOLATION_IMAGES", &cfg.Isolation.Images)
+	boolean("AGENT_ISOLATION_NETWORK", &cfg.Isolation.Network)
+	list("AGENT_ISOLATION_PATHS", &cfg.Isolation.Paths)
+	str("AI_QUEUE", &cfg.Queue.Mode)
+	integer("AI_QUEUE_WORKERS", &cfg.Queue.Workers)
+	integer("AI_QUEUE_MAX_RETRY", &cfg.Queue.MaxRetry)
+	str("AI_PERMISSION_MODE", &cfg.Permissions.Mode)
+	list("AI_PERMISSION_TOOLS", &cfg.Permissions.Tools)
+	str("AI_PERMISSION_WRITES", &cfg.Permissions.Writes)
+	duration("AI_PERMISSION_TIMEOUT", &cfg.Permissions.Timeout)
+	boolean("AUTH_SIGNUP", &cfg.Accounts.Signup)
+	if value, ok := lookup("AUTH_SIGNUP_ROLE"); ok {
+		cfg.Accounts.SignupRole = Role(value)
+	}
+	duration("AUTH_ACCESS_TTL", &cfg.Accounts.AccessTTL)
+	duration("AUTH_REFRESH_TTL", &cfg.Accounts.RefreshTTL)
+	str("AUTH_PUBLIC_URL", &cfg.Accounts.PublicURL)
+	str("AUTH_LOGIN_REDIRECT", &cfg.Accounts.LoginRedirect)
+	duration("TEAM_INVITE_TTL", &cfg.Teams.InviteTTL)
+	str("TEAM_INVITE_URL", &cfg.Teams.InviteURL)
+	str("QUOTA_DEFAULT_PLAN", &cfg.Quotas.DefaultPlan)
+	duration("NOTIFY_MIN_DURATION", &cfg.Notify.MinDuration)
+	str("BACKUP_DIR", &cfg.Backup.Dir)
+	integer("BACKUP_KEEP", &cfg.Backup.Keep)
+	duration("BACKUP_INTERVAL", &cfg.Backup.Interval)
+
+	return overridden, errors.Join(errs...)
+}
+
+// hostnamePattern matches a DNS name such as localhost or editor.internal
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+
+// httpMethodPattern matches an HTTP method name
+var httpMethodPattern = regexp.MustCompile(`^[A-Z]+$`)
+
+// allowlistEntryPattern matches a variable name, optionally ending in * to match a prefix
+var allowlistEntryPattern = regexp.MustCompile(`^(\*|[A-Za-z_][A-Za-z0-9_]*\*?)$`)
+
+// validate reports every invalid setting at once
+func (cfg *Config) validate() error {
+	var errs []error
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", cfg.Port))
+	}
+	if host := strings.Trim(cfg.Host, "[]"); host != "" && net.ParseIP(host) == nil && !hostnamePattern.MatchString(host) {
+		errs = append(errs, fmt.Errorf("host must be a hostname or IP address without port, got %q", cfg.Host))
+	}
+	switch strings.ToUpper(cfg.LogLevel) {
+	case "", "NORMAL", "HIGH":
+		cfg.LogLevel = strings.ToUpper(cfg.LogLevel)
+	default:
+		errs = append(errs, fmt.Errorf("logLevel must be HIGH or empty, got %q", cfg.LogLevel))
+	}
+
+	if locale, err := normalizeLocale(cfg.DefaultLocale); err != nil {
+		errs = append(errs, fmt.Errorf("defaultLocale: %w", err))
+	} else {
+		cfg.DefaultLocale = locale
+	}
+	if cfg.Workspace == "" {
+		errs = append(errs, errors.New("workspace must not be empty"))
+	} else if info, err := os.Stat(cfg.Workspace); err == nil && !info.IsDir() {
+		errs = append(errs, fmt.Errorf("workspace %s is not a directory", cfg.Workspace))
+	} else if os.IsNotExist(err) {
+		log.Printf("⚠️ Workspace %s does not exist yet", cfg.Workspace)
+	}
+
+	if err := checkDBDriver(cfg.Database.Driver); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Database.URL == "" {
+		errs = append(errs, errors.New("database url must not be empty"))
+	}
+	if cfg.Database.MaxOpenConns < 0 {
+		errs = append(errs, fmt.Errorf("database.maxOpenConns must not be negative, got %d", cfg.Database.MaxOpenConns))
+	}
+	if cfg.Database.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("database.maxIdleConns must not be negative, got %d", cfg.Database.MaxIdleConns))
+	}
+	if cfg.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database.connMaxLifetime must not be negative"))
+	}
+	if cfg.Database.BusyTimeout <= 0 {
+		errs = append(errs, errors.New("database.busyTimeout must be positive"))
+	}
+
+	for _, setting := range []struct {
+		name    string
+		origins []string
+	}{{"cors.origins", cfg.CORS.Origins}, {"wsOrigins", cfg.WSOrigins}} {
+		if len(setting.origins) == 0 {
+			errs = append(errs, fmt.Errorf("%s must not be empty (use * to allow any origin)", setting.name))
+		}
+		for _, origin := range setting.origins {
+			if err := validateOrigin(origin); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", setting.name, err))
+			}
+		}
+	}
+	if len(cfg.CORS.Origins) > 1 && slices.Contains(cfg.CORS.Origins, "*") {
+		errs = append(errs, errors.New("cors.origins: * must be the only entry"))
+	}
+	if cfg.CORS.AllowCredentials && slices.Contains(cfg.CORS.Origins, "*") {
+		errs = append(errs, errors.New("cors.allowCredentials needs explicit cors.origins instead of *"))
+	}
+	if len(cfg.CORS.Methods) == 0 {
+		errs = append(errs, errors.New("cors.methods must not be empty"))
+	}
+	for i, method := range cfg.CORS.Methods {
+		method = strings.ToUpper(method)
+		if !httpMethodPattern.MatchString(method) {
+			errs = append(errs, fmt.Errorf("cors.methods: invalid method %q", cfg.CORS.Methods[i]))
+		}
+		cfg.CORS.Methods[i] = method
+	}
+
+	errs = append(errs, cfg.TLS.validate(cfg.Port)...)
+
+	for _, setting := range []struct {
+		name     string
+		patterns []string
+	}{{"agentEnvAllowlist", cfg.AgentEnvAllowlist}, {"childEnvPassthrough", cfg.ChildEnvPassthrough}} {
+		for _, pattern := range setting.patterns {
+			if !allowlistEntryPattern.MatchString(pattern) {
+				errs = append(errs, fmt.Errorf("%s: invalid entry %q (use a variable name or a prefix ending in *)", setting.name, pattern))
+			}
+		}
+	}
+
+	t := cfg.Timeouts
+	for _, timeout := range []struct {
+		name  string
+		value configDuration
+	}{
+		{"command", t.Command}, {"agent", t.Agent}, {"agentMax", t.AgentMax}, {"build", t.Build},
+		{"deploy", t.Deploy}, {"shutdown", t.Shutdown}, {"killGrace", t.KillGrace},
+	} {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("timeouts.%s must be positive", timeout.name))
+		}
+	}
+	if t.Agent > t.AgentMax {
+		errs = append(errs, fmt.Errorf("timeouts.agent (%s) must not exceed timeouts.agentMax (%s)", t.Agent.Duration(), t.AgentMax.Duration()))
+	}
+
+	errs = append(errs, cfg.Assets.validate()...)
+	errs = append(errs, cfg.Execution.validate()...)
+	errs = append(errs, cfg.Processes.validate()...)
+	if cfg.Git.WorktreeDir == "" {
+		errs = append(errs, errors.New("git.worktreeDir must not be empty"))
+	}
+	errs = append(errs, cfg.Redis.validate()...)
+
+	if cfg.Streams.TokenTTL <= 0 {
+		errs = append(errs, errors.New("streams.tokenTtl must be positive"))
+	}
+	if cfg.Streams.SendTimeout <= 0 {
+		errs = append(errs, errors.New("streams.sendTimeout must be positive"))
+	}
+	if cfg.Streams.MaxLineSize <= 0 {
+		errs = append(errs, fmt.Errorf("streams.maxLineSize must be positive, got %d", cfg.Streams.MaxLineSize))
+	}
+	errs = append(errs, cfg.Email.validate()...)
+
+	errs = append(errs, cfg.Isolation.validate()...)
+	errs = append(errs, cfg.Queue.validate()...)
+	if cfg.Queue.Mode == "asynq" && cfg.Redis.URL == "" {
+		errs = append(errs, errors.New("queue.mode asynq needs redis.url"))
+	}
+	errs = append(errs, cfg.Permissions.validate()...)
+	errs = append(errs, cfg.Accounts.validate()...)
+
+	if cfg.Teams.InviteTTL <= 0 {
+		errs = append(errs, errors.New("teams.inviteTtl must be positive"))
+	}
+	if cfg.Teams.InviteURL != "" {
+		if err := validateHTTPURL(cfg.Teams.InviteURL); err != nil {
+			errs = append(errs, fmt.Errorf("teams.inviteUrl: %w", err))
+		}
+	}
+	if plan := cfg.Quotas.DefaultPlan; plan != "" && !projectIDPattern.MatchString(plan) {
+		errs = append(errs, fmt.Errorf("quotas.defaultPlan: invalid plan ID %q", plan))
+	}
+	if cfg.Notify.MinDuration < 0 {
+		errs = append(errs, errors.New("notify.minDuration must not be negative"))
+	}
+	if cfg.Backup.Dir == "" {
+		errs = append(errs, errors.New("backup.dir must not be empty"))
+	}
+	if cfg.Backup.Keep < 0 {
+		errs = append(errs, fmt.Errorf("backup.keep must not be negative, got %d", cfg.Backup.Keep))
+	}
+	if cfg.Backup.Interval < 0 {
+		errs = append(errs, errors.New("backup.interval must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateHTTPURL accepts an absolute http or https URL
+func validateHTTPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q (use e.g. https://editor.example.com)", raw)
+	}
+	return nil
+}
+
+// validateOrigin accepts *, an origin such as https://editor.example.com, or a
+// subdomain pattern such as https://*.example.com
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return fmt.Errorf("invalid origin %q (use e.g. https://editor.example.com)", origin)
+	}
+	return nil
+}
+
+// splitConfigList splits a comma-separated setting, dropping empty entries
+func splitConfigList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// dsnPasswordPattern matches the password of a key=value DSN (postgres)
+var dsnPasswordPattern = regexp.MustCompile(`(?i)(password=)('[^']*'|\S+)`)
+
+// listenAddress returns the host:port the server listens on; an empty host listens on
+// every interface
+func (cfg *Config) listenAddress() string {
+	return net.JoinHostPort(strings.Trim(cfg.Host, "[]"), strconv.Itoa(cfg.Port))
+}
+
+// redactDSN hides the password of a database URL or DSN
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	// user:password@tcp(host)/db (mysql)
+	if at := strings.LastIndex(dsn, "@"); at > 0 {
+		if user, _, ok := strings.Cut(dsn[:at], ":"); ok {
+			return user + ":xxxxx" + dsn[at:]
+		}
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+// redactSecret hides a secret setting, showing only whether it is set
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "xxxxx"
+}
+
+// GetConfig returns the effective configuration with secrets redacted, the config file
+// it was read from and the environment variables that override it
+func GetConfig() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := *appConfig
+		cfg.Database.URL = redactDSN(cfg.Database.URL)
+		cfg.Redis.URL = redactDSN(cfg.Redis.URL)
+		cfg.Streams.TokenSecret = redactSecret(cfg.Streams.TokenSecret)
+		cfg.Email.SMTP.Password = redactSecret(cfg.Email.SMTP.Password)
+		cfg.Email.Mailgun.APIKey = redactSecret(cfg.Email.Mailgun.APIKey)
+		cfg.Email.SES.SecretKey = redactSecret(cfg.Email.SES.SecretKey)
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"config":      cfg,
+				"file":        appConfigFile,
+				"envOverride": appConfigEnv,
+			},
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Diff modes of GET /api/content/:id/diff
+const (
+	DiffModeWord = "word" // Words, whitespace and punctuation
+	DiffModeHTML = "html" // Like word, but tags and entities are never split
+)
+
+// maxContentDiffTokens caps the tokens left after the common prefix and suffix are
+// removed; larger changes are reported as a single replacement
+const maxContentDiffTokens = 4000
+
+var (
+	wordTokenPattern = regexp.MustCompile(`\s+|[\p{L}\p{N}_]+|.`)
+	htmlTokenPattern = regexp.MustCompile(`<[^>]*>|&#?[A-Za-z0-9]+;|\s+|[\p{L}\p{N}_]+|.`)
+)
+
+// ContentDiffOp is a run of tokens that are equal, deleted or inserted
+type ContentDiffOp struct {
+	Op   string `json:"op"` // equal, delete, insert
+	Text string `json:"text"`
+}
+
+// ContentDiffStats counts the words a diff adds and removes
+type ContentDiffStats struct {
+	WordsAdded   int `json:"words_added"`
+	WordsRemoved int `json:"words_removed"`
+}
+
+// ContentDiffResponse is the response of GET /api/content/:id/diff
+type ContentDiffResponse struct {
+	ID      string           `json:"id"`
+	Type    string           `json:"type"`
+	From    string           `json:"from"`
+	To      string           `json:"to"`
+	Mode    string           `json:"mode"`
+	Changed bool             `json:"changed"`
+	Coarse  bool             `json:"coarse"` // Too large a change, shown as one replacement
+	Ops     []ContentDiffOp  `json:"ops"`
+	HTML    string           `json:"html"` // The diff marked up with <del> and <ins>
+	Stats   ContentDiffStats `json:"stats"`
+}
+
+// contentVersion returns the text of a block in the given state: original, edited
+// (the draft) or published
+func contentVersion(content Content, state string) (string, bool) {
+	switch state {
+	case "original":
+		return content.OriginalContent, true
+	case "edited", ContentStateDraft:
+		return draftContent(content), true
+	case ContentStatePublished:
+		return liveContent(content), true
+	}
+	return "", false
+}
+
+// tokenizeContent splits text into diff tokens for the mode
+func tokenizeContent(text, mode string) []string {
+	if mode == DiffModeHTML {
+		return htmlTokenPattern.FindAllString(text, -1)
+	}
+	return wordTokenPattern.FindAllString(text, -1)
+}
+
+// diffContent diffs two texts token by token and merges the tokens into runs. coarse
+// reports that the change was too large and is shown as one replacement.
+func diffContent(oldText, newText, mode string) (ops []DiffOp, coarse bool) {
+	a, b := tokenizeContent(oldText, mode), tokenizeContent(newText, mode)
+
+	// Common prefix and suffix are cheap to find and usually most of the text
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, token := range a[:prefix] {
+		ops = append(ops, DiffOp{Kind: DiffEqual, Text: token})
+	}
+
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(middleA)+len(middleB) > maxContentDiffTokens {
+		coarse = true
+		for _, token := range middleA {
+			ops = append(ops, DiffOp{Kind: DiffDelete, Text: token})
+		}
+		for _, token := range middleB {
+			ops = append(ops, DiffOp{Kind: DiffInsert, Text: token})
+		}
+	} else {
+		ops = append(ops, diffTokens(middleA, middleB)...)
+	}
+
+	for _, token := range a[len(a)-suffix:] {
+		ops = append(ops, DiffOp{Kind: DiffEqual, Text: token})
+	}
+	return cleanupDiff(ops), coarse
+}
+
+// cleanupDiff folds whitespace that is equal only between two changes into the change,
+// so "old words" replaced by "new text" is one change instead of two, and lists the
+// deletions of each change before its insertions
+func cleanupDiff(ops []DiffOp) []DiffOp {
+	cleaned := make([]DiffOp, 0, len(ops))
+	var deleted, inserted []DiffOp
+	flush := func() {
+		cleaned = append(cleaned, deleted...)
+		cleaned = append(cleaned, inserted...)
+		deleted, inserted = deleted[:0], inserted[:0]
+	}
+
+	for i := 0; i < len(ops); i++ {
+		op := ops[i]
+		switch op.Kind {
+		case DiffDelete:
+			deleted = append(deleted, op)
+		case DiffInsert:
+			inserted = append(inserted, op)
+		default:
+			// A whitespace run with changes on both sides joins them
+			end := i
+			for end < len(ops) && ops[end].Kind == DiffEqual && strings.TrimSpace(ops[end].Text) == "" {
+				end++
+			}
+			if end > i && (len(deleted) > 0 || len(inserted) > 0) && end < len(ops) && ops[end].Kind != DiffEqual {
+				for _, space := range ops[i:end] {
+					deleted = append(deleted, DiffOp{Kind: DiffDelete, Text: space.Text})
+					inserted = append(inserted, DiffOp{Kind: DiffInsert, Text: space.Text})
+				}
+				i = end - 1
+				continue
+			}
+			flush()
+			cleaned = append(cleaned, op)
+		}
+	}
+	flush()
+	return cleaned
+}
+
+// contentDiffOpNames maps DiffOp kinds to ContentDiffOp.Op
+var contentDiffOpNames = map[byte]string{
+	DiffEqual:  "equal",
+	DiffDelete: "delete",
+	DiffInsert: "insert",
+}
+
+// mergeDiffOps joins consecutive tokens of the same kind
+func mergeDiffOps(ops []DiffOp) []ContentDiffOp {
+	merged := []ContentDiffOp{}
+	for _, op := range ops {
+		name := contentDiffOpNames[op.Kind]
+		if n := len(merged); n > 0 && merged[n-1].Op == name {
+			merged[n-1].Text += op.Text
+		} else {
+			merged = append(merged, ContentDiffOp{Op: name, Text: op.Text})
+		}
+	}
+	return merged
+}
+
+// diffStatsWords counts inserted and deleted tokens that are not whitespace or markup
+func diffStatsWords(ops []DiffOp) (added, removed int) {
+	for _, op := range ops {
+		if strings.TrimSpace(op.Text) == "" || isMarkupToken(op.Text) {
+			continue
+		}
+		switch op.Kind {
+		case DiffInsert:
+			added++
+		case DiffDelete:
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// isMarkupToken reports whether an html mode token is a tag
+func isMarkupToken(token string) bool {
+	return len(token) > 1 && strings.HasPrefix(token, "<") && strings.HasSuffix(token, ">")
+}
+
+// renderHTMLDiff marks up the new HTML with <del> and <ins> around changed text.
+// Deleted tags are dropped and inserted tags kept, so the markup has the new structure.
+func renderHTMLDiff(ops []DiffOp) string {
+	var out strings.Builder
+	wrap := ""
+	setWrap := func(tag string) {
+		if wrap == tag {
+			return
+		}
+		if wrap != "" {
+			out.WriteString("</" + wrap + ">")
+		}
+		if tag != "" {
+			out.WriteString("<" + tag + ">")
+		}
+		wrap = tag
+	}
+
+	for _, op := range ops {
+		switch {
+		case isMarkupToken(op.Text) && op.Kind == DiffDelete:
+			// Dropped without closing <del>, so deleted text stays in one run
+		case isMarkupToken(op.Text):
+			setWrap("")
+			out.WriteString(op.Text)
+		case op.Kind == DiffDelete:
+			setWrap("del")
+			out.WriteString(op.Text)
+		case op.Kind == DiffInsert:
+			setWrap("ins")
+			out.WriteString(op.Text)
+		default:
+			setWrap("")
+			out.WriteString(op.Text)
+		}
+	}
+	setWrap("")
+	return out.String()
+}
+
+// renderTextDiff marks up a plain text diff with <del> and <ins>, escaping the text
+func renderTextDiff(ops []ContentDiffOp) string {
+	var out strings.Builder
+	for _, op := range ops {
+		text := html.EscapeString(op.Text)
+		switch op.Op {
+		case "delete":
+			out.WriteString("<del>" + text + "</del>")
+		case "insert":
+			out.WriteString("<ins>" + text + "</ins>")
+		default:
+			out.WriteString(text)
+		}
+	}
+	return out.String()
+}
+
+// GetContentDiff diffs two states of a content block (?from=original&to=edited by
+// default; edited, original or published) so editors can see what changed
+func GetContentDiff(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+
+		var content Content
+		if err := db.First(&content, "id = ? AND locale = ?", id, locale).Error; err != nil {
+			return sendError(c, 404, "CONTENT_NOT_FOUND", "Content not found", nil)
+		}
+
+		from, to := c.Query("from", "original"), c.Query("to", "edited")
+		oldText, okFrom := contentVersion(content, from)
+		newText, okTo := contentVersion(content, to)
+		if !okFrom || !okTo {
+			return sendError(c, 400, "INVALID_STATE", "from and to must be original, edited or published", nil)
+		}
+
+		// Rich text is HTML; the other types are diffed as text
+		mode := c.Query("mode")
+		if mode == "" {
+			mode = DiffModeWord
+			if content.Type == ContentTypeRichText || content.Type == "" {
+				mode = DiffModeHTML
+			}
+		}
+		if mode != DiffModeWord && mode != DiffModeHTML {
+			return sendError(c, 400, "INVALID_MODE", "mode must be word or html", nil)
+		}
+
+		tokens, coarse := diffContent(oldText, newText, mode)
+		ops := mergeDiffOps(tokens)
+		added, removed := diffStatsWords(tokens)
+		rendered := renderTextDiff(ops)
+		if mode == DiffModeHTML {
+			rendered = renderHTMLDiff(tokens)
+		}
+
+		return c.JSON(ContentDiffResponse{
+			ID:      content.ID,
+			Type:    content.Type,
+			From:    from,
+			To:      to,
+			Mode:    mode,
+			Changed: oldText != newText,
+			Coarse:  coarse,
+			Ops:     ops,
+			HTML:    rendered,
+			Stats: ContentDiffStats{
+				WordsAdded:   added,
+				WordsRemoved: removed,
+			},
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// contentExportVersion is the format version written into exports
+const contentExportVersion = 1
+
+// ContentExport is the manifest of an export: every content block plus asset metadata.
+// Zip exports also carry the original asset files under assets/.
+type ContentExport struct {
+	Version    int       `json:"version"`
+	ExportedAt int64     `json:"exportedAt"`
+	Content    []Content `json:"content"`
+	Assets     []Asset   `json:"assets"`
+}
+
+// ImportResult counts what an import did
+type ImportResult struct {
+	Created int      `json:"created"`
+	Updated int      `json:"updated"`
+	Skipped int      `json:"skipped"`
+	Locked  []string `json:"locked,omitempty"` // Blocks left alone because someone is editing them
+
+	Sanitized []string `json:"sanitized,omitempty"` // Blocks the content sanitizer changed
+
+	AssetsCreated int `json:"assetsCreated"`
+	AssetsSkipped int `json:"assetsSkipped"` // Already present, or no file in the import
+}
+
+// Conflict strategies for blocks that already exist
+const (
+	ImportSkip      = "skip"      // Keep the existing block
+	ImportOverwrite = "overwrite" // Replace it with the imported one
+	ImportMerge     = "merge"     // Keep whichever draft and published version is newer
+)
+
+// exportAssetPath is where an asset's original file is stored inside a zip export
+func exportAssetPath(asset Asset) string {
+	return "assets/" + asset.ID + "/original" + allowedAssetTypes[asset.ContentType]
+}
+
+// ExportContent returns all content blocks and asset metadata as JSON, or with
+// ?format=zip a zip archive that also contains the asset files. ?page= limits the export to a page
+func ExportContent(db *gorm.DB, store AssetStorage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		format := c.Query("format", "json")
+		if format != "json" && format != "zip" {
+			return sendError(c, 400, "INVALID_FORMAT", "format must be json or zip", nil)
+		}
+
+		contentQuery := db.Order("id")
+		assetQuery := db.Order("created_at")
+		if page := c.Query("page"); page != "" {
+			contentQuery = contentQuery.Where("page = ?", page)
+			assetQuery = assetQuery.Where("page = ?", page)
+		}
+
+		export := ContentExport{
+			Version:    contentExportVersion,
+			ExportedAt: time.Now().Unix(),
+		}
+		if err := contentQuery.Find(&export.Content).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load content", nil)
+		}
+		if err := assetQuery.Find(&export.Assets).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load assets", nil)
+		}
+
+		stamp := time.Now().Format("20060102-150405")
+		log.Printf("📤 Exporting %d content block(s) and %d asset(s) as %s", len(export.Content), len(export.Assets), format)
+
+		if format == "json" {
+			c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="content-%s.json"`, stamp))
+			return c.JSON(export)
+		}
+
+		assets := export.Assets
+		c.Set(fiber.HeaderContentType, "application/zip")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="content-%s.zip"`, stamp))
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			zw := zip.NewWriter(w)
+			defer zw.Close()
+
+			manifest, err := zw.Create("content.json")
+			if err != nil {
+				return
+			}
+			if err := json.NewEncoder(manifest).Encode(export); err != nil {
+				return
+			}
+
+			for _, asset := range assets {
+				if err := writeExportAsset(zw, store, asset); err != nil {
+					log.Printf("⚠️ Export skipped file of asset %s: %v", asset.ID, err)
+				}
+			}
+		})
+		return nil
+	}
+}
+
+func writeExportAsset(zw *zip.Writer, store AssetStorage, asset Asset) error {
+	r, err := store.Open(context.Background(), asset.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   exportAssetPath(asset),
+		Method: zip.Store, // Images and PDFs are already compressed
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// readImport parses an uploaded export: a JSON manifest, or a zip with content.json and asset files
+func readImport(data []byte) (*ContentExport, *zip.Reader, error) {
+	var export ContentExport
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON export: %w", err)
+		}
+		return &export, nil, nil
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid zip export: %w", err)
+	}
+	manifest, err := zr.Open("content.json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("zip export has no content.json")
+	}
+	defer manifest.Close()
+	if err := json.NewDecoder(manifest).Decode(&export); err != nil {
+		return nil, nil, fmt.Errorf("invalid content.json: %w", err)
+	}
+	return &export, zr, nil
+}
+
+// mergeContent applies an imported block to an existing one under the conflict
+// strategy; false means the existing block is kept as it is
+func mergeContent(existing *Content, imported Content, strategy string) bool {
+	switch strategy {
+	case ImportOverwrite:
+		version := existing.Version
+		*existing = imported
+		existing.Version = version
+		existing.DeletedAt = gorm.DeletedAt{}
+		return true
+
+	case ImportMerge:
+		changed := false
+		if existing.OriginalContent == "" && imported.OriginalContent != "" {
+			existing.OriginalContent = imported.OriginalContent
+			changed = true
+		}
+		if imported.IsEdited && imported.UpdatedAt > existing.UpdatedAt {
+			existing.EditedContent = imported.EditedContent
+			existing.IsEdited = true
+			existing.UpdatedAt = imported.UpdatedAt
+			changed = true
+		}
+		if imported.IsPublished && imported.PublishedAt > existing.PublishedAt {
+			existing.PublishedContent = imported.PublishedContent
+			existing.IsPublished = true
+			existing.PublishedAt = imported.PublishedAt
+			changed = true
+		}
+		return changed
+	}
+	return false
+}
+
+// importAssets stores the assets of a zip import that do not exist yet, keeping their IDs
+// so content referencing /api/assets/:id keeps working
+func importAssets(ctx context.Context, db *gorm.DB, store AssetStorage, assets []Asset, zr *zip.Reader, result *ImportResult) error {
+	for _, asset := range assets {
+		ext, ok := allowedAssetTypes[asset.ContentType]
+		if asset.ID == "" || strings.ContainsAny(asset.ID, "/\\.") || !ok || zr == nil {
+			result.AssetsSkipped++
+			continue
+		}
+
+		var count int64
+		if err := db.Model(&Asset{}).Where("id = ?", asset.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			result.AssetsSkipped++
+			continue
+		}
+
+		f, err := zr.Open(exportAssetPath(asset))
+		if err != nil {
+			result.AssetsSkipped++
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(f, int64(getAssetMaxSize())+1))
+		f.Close()
+		if err != nil || len(data) > getAssetMaxSize() {
+			result.AssetsSkipped++
+			continue
+		}
+
+		asset.Size = int64(len(data))
+		asset.StorageKey = asset.ID + "/original" + ext
+		asset.Variants = nil
+		asset.ProcessingStatus = AssetProcessingSkipped
+		asset.ProcessingError = ""
+		if isResizableImage(asset.ContentType) {
+			asset.ProcessingStatus = AssetProcessingPending
+		}
+
+		if err := store.Put(ctx, asset.StorageKey, bytes.NewReader(data), asset.Size, asset.ContentType); err != nil {
+			return fmt.Errorf("store asset %s: %w", asset.ID, err)
+		}
+		if asset.Page != "" {
+			touchPage(db, asset.Page)
+		}
+		if err := db.Create(&asset).Error; err != nil {
+			store.Delete(ctx, asset.StorageKey)
+			return err
+		}
+		if asset.ProcessingStatus == AssetProcessingPending {
+			enqueueAssetProcessing(asset.ID)
+		}
+		result.AssetsCreated++
+	}
+	return nil
+}
+
+// ImportContent loads an export made by ExportContent, sent as the request body or as
+// multipart field "file". ?strategy= (skip, overwrite, merge) decides what happens to
+// blocks that already exist; blocks locked by an editor are always left alone.
+func ImportContent(db *gorm.DB, store AssetStorage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		strategy := c.Query("strategy", ImportSkip)
+		if strategy != ImportSkip && strategy != ImportOverwrite && strategy != ImportMerge {
+			return sendError(c, 400, "INVALID_STRATEGY", "strategy must be skip, overwrite or merge", nil)
+		}
+
+		data := c.Body()
+		if header, err := c.FormFile("file"); err == nil {
+			file, err := header.Open()
+			if err != nil {
+				return sendError(c, 400, "INVALID_FILE", "Failed to read uploaded file", nil)
+			}
+			data, err = io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return sendError(c, 400, "INVALID_FILE", "Failed to read uploaded file", nil)
+			}
+		}
+
+		export, zr, err := readImport(data)
+		if err != nil {
+			return sendError(c, 400, "INVALID_FILE", err.Error(), nil)
+		}
+		if export.Version > contentExportVersion {
+			return sendError(c, 400, "UNSUPPORTED_VERSION", fmt.Sprintf("Unsupported export version %d", export.Version), nil)
+		}
+
+		var result ImportResult
+		if err := importAssets(c.Context(), db, store, export.Assets, zr, &result); err != nil {
+			log.Printf("❌ Import failed to store assets: %v", err)
+			return sendError(c, 500, "STORAGE_ERROR", "Failed to import assets", nil)
+		}
+
+		var saved []Content
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, imported := range export.Content {
+				if imported.ID == "" {
+					result.Skipped++
+					continue
+				}
+				if _, locked := lockHeldByOther(imported.ID, ""); locked {
+					result.Locked = append(result.Locked, imported.ID)
+					continue
+				}
+				if imported.Page == "" {
+					imported.Page = pageFromContentID(imported.ID)
+				}
+				if imported.Type == "" {
+					imported.Type = ContentTypeRichText // Exported before blocks had types
+				}
+				if !contentTypes[imported.Type] {
+					result.Skipped++
+					continue
+				}
+				if imported.Locale == "" {
+					imported.Locale = getDefaultLocale() // Exported before translations
+				}
+				locale, err := normalizeLocale(imported.Locale)
+				if err != nil {
+					result.Skipped++
+					continue
+				}
+				imported.Locale = locale
+
+				var existing Content
+				err = tx.Unscoped().Limit(1).Find(&existing, "id = ? AND locale = ?", imported.ID, imported.Locale).Error
+				if err != nil {
+					return err
+				}
+				if existing.DeletedAt.Valid {
+					// Left in the trash; restoring it is a separate decision
+					result.Skipped++
+					continue
+				}
+
+				if existing.ID == "" {
+					imported.Version = 1
+					imported.DeletedAt = gorm.DeletedAt{}
+					if sanitizeImported(&imported) {
+						result.Sanitized = append(result.Sanitized, imported.ID)
+					}
+					if err := touchPage(tx, imported.Page); err != nil {
+						return err
+					}
+					if err := tx.Create(&imported).Error; err != nil {
+						return err
+					}
+					saved = append(saved, imported)
+					result.Created++
+					continue
+				}
+
+				if !mergeContent(&existing, imported, strategy) {
+					result.Skipped++
+					continue
+				}
+				existing.Version++
+				if sanitizeImported(&existing) {
+					result.Sanitized = append(result.Sanitized, existing.ID)
+				}
+				if err := touchPage(tx, existing.Page); err != nil {
+					return err
+				}
+				if err := tx.Select("*").Save(&existing).Error; err != nil {
+					return err
+				}
+				saved = append(saved, existing)
+				result.Updated++
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("❌ Import failed: %v", err)
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to import content", nil)
+		}
+
+		// Notify subscribers only once the transaction has committed
+		for _, content := range saved {
+			broadcastContent(ContentMsgUpdated, content, c.Get("X-Client-ID"))
+		}
+
+		recordAudit(db, c, AuditContentImport, "content", nil, export, fmt.Sprintf("%s: %d created, %d updated, %d skipped, %d asset(s)",
+			strategy, result.Created, result.Updated, result.Skipped, result.AssetsCreated))
+		log.Printf("📥 Imported content (%s): %d created, %d updated, %d skipped, %d locked, %d asset(s)",
+			strategy, result.Created, result.Updated, result.Skipped, len(result.Locked), result.AssetsCreated)
+		return c.JSON(result)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// TrashedContentResponse is a content block in the trash
+type TrashedContentResponse struct {
+	ContentResponse
+	DeletedAt int64 `json:"deleted_at"`
+	PurgeAt   int64 `json:"purge_at"` // When the cleanup removes it for good
+}
+
+// TrashListResponse is the response of GET /api/content/trash
+type TrashListResponse struct {
+	Items []TrashedContentResponse `json:"items"`
+}
+
+// ContentDeletedResponse is the response of DELETE /api/content/:id
+type ContentDeletedResponse struct {
+	ID      string `json:"id"`
+	Locale  string `json:"locale"`
+	Deleted bool   `json:"deleted"`
+}
+
+// trashedContentResponse adds when the block was deleted and will be purged
+func trashedContentResponse(content Content, retention time.Duration) TrashedContentResponse {
+	return TrashedContentResponse{
+		ContentResponse: contentResponse(content, ContentStateDraft),
+		DeletedAt:       content.DeletedAt.Time.Unix(),
+		PurgeAt:         content.DeletedAt.Time.Add(retention).Unix(),
+	}
+}
+
+// purgeTrash permanently removes content that has been in the trash longer than retention
+func purgeTrash(db *gorm.DB, retention time.Duration) int {
+	result := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-retention)).Delete(&Content{})
+	if result.Error != nil {
+		log.Printf("⚠️ Cleanup failed to purge the content trash: %v", result.Error)
+		return 0
+	}
+	return int(result.RowsAffected)
+}
+
+// DeleteContent moves a content block to the trash. A block locked by another editor
+// can only be deleted with that lock's token (?lock_token=).
+func DeleteContent(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+
+		if lock, locked := lockHeldByOther(id, c.Query("lock_token")); locked {
+			return contentLockedError(c, 423, lock)
+		}
+
+		var content Content
+		if err := db.First(&content, "id = ? AND locale = ?", id, locale).Error; err != nil {
+			return sendError(c, 404, "CONTENT_NOT_FOUND", "Content not found", nil)
+		}
+		if err := db.Delete(&content).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete content", nil)
+		}
+
+		log.Printf("🗑️ Content moved to trash: %s", id)
+		recordAudit(db, c, AuditContentDelete, id, draftContent(content), nil, localeDetail(content, ""))
+		broadcastContent(ContentMsgDeleted, content, c.Get("X-Client-ID"))
+		return c.JSON(ContentDeletedResponse{
+			ID:      id,
+			Locale:  content.Locale,
+			Deleted: true,
+		})
+	}
+}
+
+// ListTrash returns deleted content blocks, most recently deleted first (?page= filters by page)
+func ListTrash(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC")
+		if page := c.Query("page"); page != "" {
+			query = query.Where("page = ?", page)
+		}
+
+		var contents []Content
+		if err := query.Find(&contents).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load trash", nil)
+		}
+
+		retention := getCleanupSettings().TrashRetention
+		items := make([]TrashedContentResponse, 0, len(contents))
+		for _, content := range contents {
+			items = append(items, trashedContentResponse(content, retention))
+		}
+
+		return c.JSON(TrashListResponse{Items: items})
+	}
+}
+
+// RestoreContent takes a content block out of the trash
+func RestoreContent(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+
+		var content Content
+		err = db.Unscoped().Where("deleted_at IS NOT NULL").First(&content, "id = ? AND locale = ?", id, locale).Error
+		if err != nil {
+			return sendError(c, 404, "CONTENT_NOT_FOUND", "Content not found in trash", nil)
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// The page may have been purged while the block was in the trash
+			if err := touchPage(tx, content.Page); err != nil {
+				return err
+			}
+			content.Version++
+			content.UpdatedAt = time.Now().Unix()
+			return tx.Unscoped().Model(&Content{}).Where("id = ? AND locale = ?", id, locale).Updates(map[string]interface{}{
+				"deleted_at": nil,
+				"version":    content.Version,
+				"updated_at": content.UpdatedAt,
+			}).Error
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to restore content", nil)
+		}
+		content.DeletedAt = gorm.DeletedAt{}
+
+		log.Printf("♻️ Content restored from trash: %s", id)
+		recordAudit(db, c, AuditContentRestore, id, nil, draftContent(content), localeDetail(content, ""))
+		broadcastContent(ContentMsgRestored, content, c.Get("X-Client-ID"))
+		return c.JSON(contentResponse(content, ContentStateDraft))
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+	"gorm.io/gorm"
+)
+
+// Content types. The type decides how a block is validated and sanitized on save.
+const (
+	ContentTypeRichText  = "richtext"  // HTML, sanitized with CONTENT_SANITIZER
+	ContentTypePlainText = "plaintext" // Text without markup
+	ContentTypeMarkdown  = "markdown"  // Markdown source, rendered by the server
+	ContentTypeJSON      = "json"      // A JSON document, optionally checked against the block's schema
+	ContentTypeImageRef  = "image-ref" // The ID of an uploaded asset
+)
+
+var contentTypes = map[string]bool{
+	ContentTypeRichText:  true,
+	ContentTypePlainText: true,
+	ContentTypeMarkdown:  true,
+	ContentTypeJSON:      true,
+	ContentTypeImageRef:  true,
+}
+
+// ValidationProblem describes one reason a content block was rejected
+type ValidationProblem struct {
+	Field   string `json:"field"` // content, type or schema; JSON content adds the pointer (content/items/0)
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ContentValidationError is returned when content does not match its type
+type ContentValidationError struct {
+	ID       string
+	Type     string
+	Problems []ValidationProblem
+}
+
+func (e *ContentValidationError) Error() string {
+	return fmt.Sprintf("content %s is not valid %s (%d problem(s))", e.ID, e.Type, len(e.Problems))
+}
+
+// markdown renders GitHub flavoured Markdown. Raw HTML is passed through here and
+// removed by the sanitizer afterwards, see renderMarkdown.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+// compileMarkdown renders Markdown source to HTML without sanitizing it
+func compileMarkdown(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// compileSchema compiles a JSON Schema such as the schema of a block. References to
+// other documents are refused so a schema cannot make the server read files or fetch URLs.
+func compileSchema(schema string) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema references are not allowed: %s", url)
+	}
+	if err := compiler.AddResource("content.json", strings.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile("content.json")
+}
+
+// schemaProblems flattens a schema validation error into its leaf causes, reported
+// below field
+func schemaProblems(field string, err error) []ValidationProblem {
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return []ValidationProblem{{Field: field, Code: "schema_violation", Message: err.Error()}}
+	}
+
+	var problems []ValidationProblem
+	var walk func(*jsonschema.ValidationError)
+	walk = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			problems = append(problems, ValidationProblem{
+				Field:   field + ve.InstanceLocation,
+				Code:    "schema_violation",
+				Message: ve.Message,
+			})
+		}
+		for _, cause := range ve.Causes {
+			walk(cause)
+		}
+	}
+	walk(ve)
+	return problems
+}
+
+// validateContent checks the edited content of a block against its type. Empty
+// content is always accepted so a block can be cleared.
+func validateContent(db *gorm.DB, content Content) []ValidationProblem {
+	if !contentTypes[content.Type] {
+		return []ValidationProblem{{
+			Field:   "type",
+			Code:    "unknown_type",
+			Message: fmt.Sprintf("type must be one of richtext, plaintext, markdown, json or image-ref, got %q", content.Type),
+		}}
+	}
+	if content.Schema != "" && content.Type != ContentTypeJSON {
+		return []ValidationProblem{{Field: "schema", Code: "schema_not_allowed", Message: "Only json blocks can have a schema"}}
+	}
+
+	var schema *jsonschema.Schema
+	if content.Schema != "" {
+		var err error
+		if schema, err = compileSchema(content.Schema); err != nil {
+			return []ValidationProblem{{Field: "schema", Code: "invalid_schema", Message: err.Error()}}
+		}
+	}
+
+	value := content.EditedContent
+	if value == "" {
+		return nil
+	}
+
+	switch content.Type {
+	case ContentTypePlainText:
+		if htmlTagPattern.MatchString(value) {
+			return []ValidationProblem{{Field: "content", Code: "markup_not_allowed", Message: "Plain text must not contain HTML tags"}}
+		}
+	case ContentTypeMarkdown:
+		if _, err := compileMarkdown(value); err != nil {
+			return []ValidationProblem{{Field: "content", Code: "invalid_markdown", Message: err.Error()}}
+		}
+	case ContentTypeJSON:
+		var doc interface{}
+		if err := json.Unmarshal([]byte(value), &doc); err != nil {
+			return []ValidationProblem{{Field: "content", Code: "invalid_json", Message: err.Error()}}
+		}
+		if schema != nil {
+			if err := schema.Validate(doc); err != nil {
+				return schemaProblems("content", err)
+			}
+		}
+	case ContentTypeImageRef:
+		var count int64
+		if err := db.Model(&Asset{}).Where("id = ?", value).Count(&count).Error; err != nil {
+			return []ValidationProblem{{Field: "content", Code: "asset_lookup_failed", Message: err.Error()}}
+		}
+		if count == 0 {
+			return []ValidationProblem{{Field: "content", Code: "unknown_asset", Message: fmt.Sprintf("No asset with ID %q", value)}}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Conversation groups follow-up AI commands that share Claude CLI context
+type Conversation struct {
+	ID              string `gorm:"primaryKey" json:"id"`
+	ClaudeSessionID string `json:"claudeSessionId"`                   // Passed to claude --session-id / --resume
+	HasSession      bool   `json:"hasSession"`                        // True once the CLI session has been created
+	Title           string `gorm:"serializer:encrypted" json:"title"` // First prompt of the conversation
+	UserID          string `gorm:"index" json:"userId,omitempty"`
+	ProjectID       string `json:"projectId,omitempty"`
+	Page            string `json:"page"`
+	CommandCount    int    `json:"commandCount"`
+	CreatedAt       int64  `json:"createdAt"`
+	UpdatedAt       int64  `json:"updatedAt"`
+}
+
+// maxConversationTitle caps the length of the conversation title taken from the first prompt
+const maxConversationTitle = 120
+
+// newConversation creates a conversation for a command that did not continue an existing one
+func newConversation(db *gorm.DB, req AICommandRequest) (*Conversation, error) {
+	title := req.Prompt
+	if len(title) > maxConversationTitle {
+		title = title[:maxConversationTitle] + "…"
+	}
+
+	now := time.Now().Unix()
+	conversation := &Conversation{
+		ID:              fmt.Sprintf("conv_%d_%s", now, uuid.New().String()[:8]),
+		ClaudeSessionID: uuid.New().String(),
+		Title:           title,
+		UserID:          req.Context.UserID,
+		ProjectID:       req.Context.ProjectID,
+		Page:            req.Context.Page,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	return conversation, db.Create(conversation).Error
+}
+
+// resolveConversation loads the requested conversation or starts a new one,
+// counting the new command against it
+func resolveConversation(db *gorm.DB, req AICommandRequest) (*Conversation, error) {
+	var conversation Conversation
+	if req.ConversationID == "" {
+		created, err := newConversation(db, req)
+		if err != nil {
+			return nil, err
+		}
+		conversation = *created
+	} else if err := db.First(&conversation, "id = ?", req.ConversationID).Error; err != nil {
+		return nil, err
+	}
+
+	conversation.CommandCount++
+	conversation.UpdatedAt = time.Now().Unix()
+	return &conversation, db.Model(&conversation).Updates(map[string]interface{}{
+		"command_count": conversation.CommandCount,
+		"updated_at":    conversation.UpdatedAt,
+	}).Error
+}
+
+// conversationSessionArgs returns the Claude CLI session parameters for a command
+func conversationSessionArgs(db *gorm.DB, command *AICommand) (sessionID string, resume bool) {
+	if command.ConversationID == "" {
+		return "", false
+	}
+	var conversation Conversation
+	if err := db.First(&conversation, "id = ?", command.ConversationID).Error; err != nil {
+		return "", false
+	}
+	return conversation.ClaudeSessionID, conversation.HasSession
+}
+
+// markConversationStarted records that the CLI session now exists and can be resumed
+func markConversationStarted(db *gorm.DB, conversationID string) {
+	if conversationID == "" {
+		return
+	}
+	db.Model(&Conversation{}).Where("id = ?", conversationID).Update("has_session", true)
+}
+
+// ListConversations returns conversations, most recent first (?userId=&page=&limit=)
+func ListConversations(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := db.Model(&Conversation{})
+		if userID := c.Query("userId"); userID != "" {
+			query = query.Where("user_id = ?", userID)
+		}
+		if page := c.Query("page"); page != "" {
+			query = query.Where("page = ?", page)
+		}
+
+		limit := c.QueryInt("limit", 50)
+		if limit <= 0 || limit > 200 {
+			limit = 50
+		}
+
+		var conversations []Conversation
+		if err := query.Order("updated_at DESC").Limit(limit).Find(&conversations).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load conversations", err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"conversations": conversations,
+			},
+		})
+	}
+}
+
+// GetConversation returns a conversation together with its commands
+func GetConversation(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var conversation Conversation
+		if err := db.First(&conversation, "id = ?", c.Params("conversationId")).Error; err != nil {
+			return sendError(c, 404, "CONVERSATION_NOT_FOUND", "Conversation not found", nil)
+		}
+
+		var commands []AICommand
+		db.Where("conversation_id = ?", conversation.ID).Order("created_at").Find(&commands)
+
+		items := make([]fiber.Map, 0, len(commands))
+		for _, command := range commands {
+			items = append(items, fiber.Map{
+				"commandId":   command.ID,
+				"prompt":      command.Prompt,
+				"status":      command.Status,
+				"createdAt":   command.CreatedAt,
+				"completedAt": command.CompletedAt,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"conversation": conversation,
+				"commands":     items,
+			},
+		})
+	}
+}
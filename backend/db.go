@@ -1,26 +1,114 @@
 package main
 
 import (
-	"gorm.io/driver/sqlite"
+	"fmt"
+	"log"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 type Content struct {
-	ID              string `gorm:"primaryKey" json:"id"`
-	OriginalContent string `gorm:"type:text" json:"original_content"` // Content from HTML
-	EditedContent   string `gorm:"type:text" json:"edited_content"`   // User-modified content
-	IsEdited        bool   `json:"is_edited"`                         // True if user has edited
-	UpdatedAt       int64  `json:"updated_at"`
+	ID               string `gorm:"primaryKey" json:"id"`
+	Locale           string `gorm:"primaryKey;size:35" json:"locale"`      // Language tag such as en or fr-CA; one row per translation
+	Page             string `gorm:"index" json:"page"`                     // Page namespace (prefix of "page:element" IDs)
+	Type             string `gorm:"not null;default:richtext" json:"type"` // richtext, plaintext, markdown, json or image-ref
+	Schema           string `gorm:"type:text" json:"schema,omitempty"`     // JSON Schema checked on save (json blocks only)
+	OriginalContent  string `gorm:"type:text" json:"original_content"`     // Content from HTML
+	EditedContent    string `gorm:"type:text" json:"edited_content"`       // User-modified content
+	IsEdited         bool   `json:"is_edited"`                             // True if user has edited
+	PublishedContent string `gorm:"type:text" json:"published_content"`    // Content visible on the live site
+	IsPublished      bool   `json:"is_published"`                          // True once content has been published
+	PublishedAt      int64  `json:"published_at"`
+	Version          int64  `gorm:"not null;default:1" json:"version"` // Incremented on every edit (optimistic concurrency)
+	UpdatedAt        int64  `gorm:"index" json:"updated_at"`
+
+	// Set when the block is moved to the trash; GORM leaves such rows out of every query
+	// unless it is told to go Unscoped
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+}
+
+// getDBDriver returns the database driver (database.driver, DB_DRIVER): sqlite, postgres or mysql
+// Falls back to sqlite
+func getDBDriver() string {
+	return appConfig.Database.Driver
+}
+
+// getDatabaseURL returns the DSN (database.url, DATABASE_URL)
+// Falls back to content.db for sqlite
+func getDatabaseURL() string {
+	return appConfig.Database.URL
+}
+
+// openDialector returns the GORM dialector for the configured driver
+func openDialector(driver, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case "sqlite", "sqlite3":
+		return openSQLite(dsn)
+	case "postgres", "postgresql":
+		return postgres.Open(dsn), nil
+	case "mysql":
+		return mysql.Open(dsn), nil
+	default:
+		return nil, checkDBDriver(driver)
+	}
+}
+
+// checkDBDriver returns an error for a driver openDialector doesn't support, without
+// opening the database
+func checkDBDriver(driver string) error {
+	switch driver {
+	case "sqlite", "sqlite3", "postgres", "postgresql", "mysql":
+		return nil
+	}
+	return fmt.Errorf("unsupported DB_DRIVER %q (expected sqlite, postgres or mysql)", driver)
+}
+
+// openDB connects to the configured database without touching its schema
+func openDB() (*gorm.DB, error) {
+	driver := getDBDriver()
+	dialector, err := openDialector(driver, getDatabaseURL())
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure connection pooling
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	pool := appConfig.Database
+	if pool.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	if pool.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime.Duration())
+	}
+
+	log.Printf("🗄️ Database driver: %s", driver)
+	return db, nil
 }
 
 func InitDB() (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open("content.db"), &gorm.Config{})
+	db, err := openDB()
 	if err != nil {
 		return nil, err
 	}
 
-	// Auto migrate the schema
-	db.AutoMigrate(&Content{}, &AICommand{})
+	// Apply the pending schema migrations, see migrations.go
+	if err := checkMigrations(db); err != nil {
+		closeDB(db)
+		return nil, err
+	}
+	backfillContentPages(db)
+	setupContentSearch(db, getDBDriver())
 
 	return db, nil
 }
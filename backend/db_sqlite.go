@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Retries of a write that finds the database locked by another process
+const (
+	sqliteLockRetries = 4
+	sqliteLockBackoff = 50 * time.Millisecond
+)
+
+// isSQLiteWALEnabled returns false when WAL mode is turned off (database.sqliteWal, SQLITE_WAL)
+func isSQLiteWALEnabled() bool {
+	return appConfig.Database.SQLiteWAL
+}
+
+// getSQLiteBusyTimeout returns how long SQLite waits for a lock (database.busyTimeout, DB_BUSY_TIMEOUT)
+// Falls back to 5s
+func getSQLiteBusyTimeout() time.Duration {
+	return appConfig.Database.BusyTimeout.Duration()
+}
+
+// isMemorySQLite reports whether the DSN opens an in-memory database, which exists once
+// per connection and so can't be shared by two pools
+func isMemorySQLite(dsn string) bool {
+	return strings.Contains(dsn, ":memory:") || strings.Contains(dsn, "mode=memory")
+}
+
+// sqliteDSN adds the busy timeout, WAL mode and write transactions that take the lock up
+// front to a DSN, leaving alone what the DSN sets itself
+func sqliteDSN(dsn string, writer bool) string {
+	var params []string
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if strings.Contains(dsn, name+"=") {
+				return true
+			}
+		}
+		return false
+	}
+	if !has("_busy_timeout", "_timeout") {
+		params = append(params, fmt.Sprintf("_busy_timeout=%d", getSQLiteBusyTimeout().Milliseconds()))
+	}
+	if writer && isSQLiteWALEnabled() && !has("_journal_mode", "_journal") {
+		params = append(params, "_journal_mode=WAL")
+	}
+	if writer && !has("_txlock") {
+		params = append(params, "_txlock=immediate")
+	}
+	if len(params) == 0 {
+		return dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + strings.Join(params, "&")
+}
+
+// sqlitePool sends the statements of GORM to two pools on the same file. SQLite runs one
+// writer at a time and fails writers that wait longer than the busy timeout with
+// "database is locked". Writes and transactions therefore go to a pool with a single
+// connection, whose callers database/sql serves in turn. Reads use the other pool; in
+// WAL mode they don't wait for the writer.
+type sqlitePool struct {
+	writer *sql.DB
+	reader *sql.DB
+}
+
+// openSQLite returns the dialector for a SQLite database, with the single-writer pool
+// unless the database lives in memory
+func openSQLite(dsn string) (gorm.Dialector, error) {
+	if isMemorySQLite(dsn) {
+		return sqlite.Open(dsn), nil
+	}
+	writer, err := sql.Open(sqlite.DriverName, sqliteDSN(dsn, true))
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	reader, err := sql.Open(sqlite.DriverName, sqliteDSN(dsn, false))
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	// Switch the file to WAL before the readers open it
+	var mode string
+	if err := writer.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		writer.Close()
+		reader.Close()
+		return nil, err
+	}
+	log.Printf("🗄️ SQLite journal mode: %s", mode)
+	return sqlite.New(sqlite.Config{Conn: &sqlitePool{writer: writer, reader: reader}}), nil
+}
+
+// isSQLiteLocked reports whether an error is SQLite's transient lock error
+func isSQLiteLocked(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// retryLocked runs a write again with growing pauses while another process, such as a
+// second backend on the same file, keeps the database locked past the busy timeout
+func retryLocked(ctx context.Context, write func() error) error {
+	backoff := sqliteLockBackoff
+	for attempt := 0; ; attempt++ {
+		err := write()
+		if !isSQLiteLocked(err) || attempt == sqliteLockRetries {
+			return err
+		}
+		log.Printf("⏳ Database locked, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// isWriteQuery reports whether a statement changes the database. GORM runs INSERT ...
+// RETURNING as a query.
+func isWriteQuery(query string) bool {
+	keyword, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	switch strings.ToUpper(keyword) {
+	case "INSERT", "UPDATE", "DELETE", "REPLACE", "UPSERT", "CREATE", "DROP", "ALTER":
+		return true
+	}
+	return false
+}
+
+func (p *sqlitePool) pool(query string) *sql.DB {
+	if isWriteQuery(query) {
+		return p.writer
+	}
+	return p.reader
+}
+
+func (p *sqlitePool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.pool(query).PrepareContext(ctx, query)
+}
+
+func (p *sqlitePool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := retryLocked(ctx, func() (err error) {
+		result, err = p.writer.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+func (p *sqlitePool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if !isWriteQuery(query) {
+		return p.reader.QueryContext(ctx, query, args...)
+	}
+	var rows *sql.Rows
+	err := retryLocked(ctx, func() (err error) {
+		rows, err = p.writer.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (p *sqlitePool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.pool(query).QueryRowContext(ctx, query, args...)
+}
+
+// BeginTx starts a transaction on the writer, so it holds the write lock from its first
+// statement on instead of failing when it gets to its first write
+func (p *sqlitePool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var tx *sql.Tx
+	err := retryLocked(ctx, func() (err error) {
+		tx, err = p.writer.BeginTx(ctx, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// GetDBConn returns the reader pool, which the pool settings of DB_MAX_OPEN_CONNS and
+// friends apply to; the writer keeps its single connection
+func (p *sqlitePool) GetDBConn() (*sql.DB, error) {
+	return p.reader, nil
+}
+
+func (p *sqlitePool) Ping() error {
+	return p.reader.Ping()
+}
+
+// closeDB closes the connections of the database. Closing the SQLite writer last
+// checkpoints the WAL into the database file.
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+	if pool, ok := db.ConnPool.(*sqlitePool); ok {
+		pool.writer.Close()
+	}
+}
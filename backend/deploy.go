@@ -0,0 +1,539 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxDeploymentLog caps the output kept in the database per deployment
+const maxDeploymentLog = 256 << 10
+
+// Deployment is one publish of a project's build output to production. The files sent
+// are archived first so a later rollback can send exactly the same files again.
+type Deployment struct {
+	ID           string `gorm:"primaryKey" json:"id"`
+	ProjectID    string `gorm:"index" json:"projectId"`
+	Driver       string `json:"driver"` // rsync, s3 or hook
+	Target       string `json:"target"` // Human-readable destination
+	SessionID    string `json:"sessionId"`
+	Status       string `gorm:"index" json:"status"` // running, succeeded, failed, interrupted, timed_out, resource_limit_exceeded
+	ExitCode     *int   `json:"exitCode,omitempty"`
+	Error        string `gorm:"type:text" json:"error,omitempty"`
+	ArtifactPath string `json:"-"`
+	HasArtifact  bool   `gorm:"-" json:"hasArtifact"`
+	RollbackOf   string `json:"rollbackOf,omitempty"` // Deployment whose artifact was redeployed
+	Log          string `gorm:"type:text" json:"log,omitempty"`
+	StartedAt    int64  `gorm:"index" json:"startedAt"`
+	FinishedAt   int64  `json:"finishedAt,omitempty"`
+}
+
+// AfterFind reports whether the artifact of a deployment is still on disk
+func (d *Deployment) AfterFind(tx *gorm.DB) error {
+	if d.ArtifactPath != "" {
+		_, err := os.Stat(d.ArtifactPath)
+		d.HasArtifact = err == nil
+	}
+	return nil
+}
+
+// DeployConfig is the "deploy" setting of a project
+type DeployConfig struct {
+	Driver    string `json:"driver"`
+	SourceDir string `json:"sourceDir"` // Relative to the workspace; default dist
+
+	// rsync
+	Target  string `json:"target"`  // user@host:/var/www/site
+	SSHPort int    `json:"sshPort"` // Default 22
+
+	// s3
+	Bucket   string `json:"bucket"`
+	Prefix   string `json:"prefix"`
+	Endpoint string `json:"endpoint"` // Default s3.amazonaws.com
+	Region   string `json:"region"`
+	UseSSL   *bool  `json:"useSSL"` // Default true
+
+	// hook
+	Command string `json:"command"`
+}
+
+// DeployRequest is the body of POST /api/deploy and /api/deploy/rollback
+type DeployRequest struct {
+	ProjectID    string `json:"projectId"`
+	DeploymentID string `json:"deploymentId,omitempty"` // Rollback only: deployment to restore
+}
+
+// deployMu serializes the running-deployment check so a project never deploys twice at once
+var deployMu sync.Mutex
+
+// getDeployArtifactDir returns where deployment archives are kept from DEPLOY_ARTIFACT_DIR
+// Falls back to ./deployments
+func getDeployArtifactDir() string {
+	return getEnvDefault("DEPLOY_ARTIFACT_DIR", "deployments")
+}
+
+// getDeployTimeout returns how long a deployment may run (timeouts.deploy, DEPLOY_TIMEOUT)
+// Falls back to 30 minutes
+func getDeployTimeout() time.Duration {
+	return appConfig.Timeouts.Deploy.Duration()
+}
+
+// getDeployKeepArtifacts returns how many archives are kept per project from DEPLOY_KEEP_ARTIFACTS
+// Falls back to 5
+func getDeployKeepArtifacts() int {
+	if n, err := strconv.Atoi(getEnvDefault("DEPLOY_KEEP_ARTIFACTS", "5")); err == nil && n > 0 {
+		return n
+	}
+	return 5
+}
+
+// projectDeployConfig reads and checks the "deploy" setting of a project
+func projectDeployConfig(project *Project) (*DeployConfig, error) {
+	raw, ok := project.Settings["deploy"]
+	if !ok {
+		return nil, fmt.Errorf("project has no deploy setting")
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var config DeployConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid deploy setting: %w", err)
+	}
+	if config.SourceDir == "" {
+		config.SourceDir = "dist"
+	}
+	if _, err := deployDriverFor(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// archiveDir writes the files below dir into a gzipped tarball at dest,
+// leaving out directories whose name is in skipDirs
+func archiveDir(dir, dest string, skipDirs map[string]bool) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if info.IsDir() && skipDirs[info.Name()] {
+			return filepath.SkipDir
+		}
+		// Only directories and regular files are archived
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		os.Remove(dest)
+	}
+	return err
+}
+
+// extractArchive unpacks a deployment archive into dir
+func extractArchive(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !isWithinDir(dir, target) {
+			return fmt.Errorf("archive entry %s escapes the target directory", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			// Replace rather than truncate, which fails on read-only files such as git objects
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pruneDeployArtifacts deletes all but the newest archives of successful deployments of a project
+func pruneDeployArtifacts(db *gorm.DB, projectID string) {
+	var old []Deployment
+	db.Where("project_id = ? AND status = ? AND artifact_path <> '' AND rollback_of = ''", projectID, "succeeded").
+		Order("started_at DESC").Offset(getDeployKeepArtifacts()).Find(&old)
+	for _, d := range old {
+		if err := os.Remove(d.ArtifactPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ Failed to remove deployment artifact %s: %v", d.ArtifactPath, err)
+		}
+	}
+}
+
+// finishDeployment records the outcome and output of a deployment once it has ended
+func finishDeployment(db *gorm.DB, deployment *Deployment, session *AgentSession, err error) {
+	output := session.output.text()
+	if len(output) > maxDeploymentLog {
+		output = output[len(output)-maxDeploymentLog:]
+	}
+	updates := map[string]interface{}{
+		"status":      sessionOutcome(session, err),
+		"log":         output,
+		"finished_at": time.Now().Unix(),
+	}
+	var exitErr *exec.ExitError
+	if err == nil {
+		updates["exit_code"] = 0
+	} else {
+		if errors.As(err, &exitErr) {
+			updates["exit_code"] = exitErr.ExitCode()
+		}
+		updates["error"] = err.Error()
+	}
+	if dbErr := db.Model(&Deployment{}).Where("id = ?", deployment.ID).Updates(updates).Error; dbErr != nil {
+		log.Printf("⚠️ Failed to record result of deployment %s: %v", deployment.ID, dbErr)
+		return
+	}
+	log.Printf("🚀 Deployment %s %s", deployment.ID, updates["status"])
+
+	// Only what went live is worth rolling back to
+	if updates["status"] != "succeeded" && deployment.RollbackOf == "" {
+		os.Remove(deployment.ArtifactPath)
+	}
+	pruneDeployArtifacts(db, deployment.ProjectID)
+}
+
+// failAbandonedDeployments marks deployments left running by a previous backend process as failed.
+// In Redis mode, those another live instance runs are left alone.
+func failAbandonedDeployments(db *gorm.DB) {
+	query := db.Model(&Deployment{}).Where("status = ?", "running")
+	if cluster != nil {
+		var running []Deployment
+		db.Where("status = ?", "running").Find(&running)
+		for _, row := range running {
+			if runsElsewhere(SessionKindAgent, row.SessionID) {
+				query = query.Where("id <> ?", row.ID)
+			}
+		}
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":      "failed",
+		"error":       "backend restarted while the deployment was running",
+		"finished_at": time.Now().Unix(),
+	})
+	if result.Error != nil {
+		log.Printf("⚠️ Failed to clean up abandoned deployments: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("🧹 Marked %d abandoned deployment(s) as failed", result.RowsAffected)
+	}
+}
+
+// deploymentResponse adds the stream URL while the deployment's session is still around
+func deploymentResponse(deployment *Deployment) fiber.Map {
+	data := fiber.Map{"deployment": deployment}
+
+	sessMu.RLock()
+	_, exists := sessions[deployment.SessionID]
+	sessMu.RUnlock()
+	if exists || runsElsewhere(SessionKindAgent, deployment.SessionID) {
+		data["streamUrl"] = "/api/agent/stream/" + deployment.SessionID
+		data["outputUrl"] = "/api/agent/output/" + deployment.SessionID
+	}
+	return data
+}
+
+// loadDeployProject loads the project of a deploy request and its deploy setting
+func loadDeployProject(c *fiber.Ctx, db *gorm.DB) (*DeployRequest, *Project, *DeployConfig, error) {
+	var req DeployRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, nil, nil, sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+	}
+	if req.ProjectID == "" {
+		return nil, nil, nil, sendError(c, 400, "MISSING_PROJECT", "projectId is required", "")
+	}
+
+	var project Project
+	if err := db.First(&project, "id = ?", req.ProjectID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil, sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", req.ProjectID)
+		}
+		return nil, nil, nil, sendError(c, 500, "DATABASE_ERROR", "Failed to load project", err.Error())
+	}
+
+	config, err := projectDeployConfig(&project)
+	if err != nil {
+		return nil, nil, nil, sendError(c, 400, "INVALID_DEPLOY_CONFIG", "Project deploy setting is missing or invalid", err.Error())
+	}
+	return &req, &project, config, nil
+}
+
+// startDeployment launches the driver for sourceDir as an agent session. The caller holds
+// deployMu and has checked that no deployment of the project is running; cleanup runs
+// once the session has ended.
+func startDeployment(c *fiber.Ctx, db *gorm.DB, project *Project, config *DeployConfig, deployment *Deployment, sourceDir string, cleanup func()) error {
+	env, err := buildChildEnv(db, project.ID)
+	if err != nil {
+		cleanup()
+		return sendError(c, 500, "ENV_ERROR", "Failed to prepare environment", err.Error())
+	}
+
+	driver, _ := deployDriverFor(config)
+	session := &AgentSession{
+		Kind:    SessionKindDeploy,
+		UserID:  currentUserID(c),
+		WorkDir: project.WorkspacePath,
+		Env: env.withOverrides(map[string]string{
+			"DEPLOY_ID":          deployment.ID,
+			"DEPLOY_PROJECT_ID":  project.ID,
+			"DEPLOY_SOURCE_DIR":  sourceDir,
+			"DEPLOY_ROLLBACK_OF": deployment.RollbackOf,
+		}),
+		Timeout: getDeployTimeout(),
+		db:      db,
+	}
+	deployment.Driver = config.Driver
+	deployment.Target = driver.describe(config)
+	driverCleanup, err := driver.prepare(session, config, sourceDir)
+	if err != nil {
+		cleanup()
+		return sendError(c, 500, "DEPLOY_ERROR", "Failed to prepare deployment", err.Error())
+	}
+	session.onExit = func(err error) {
+		finishDeployment(db, deployment, session, err)
+		driverCleanup()
+		cleanup()
+	}
+
+	if err := db.Create(deployment).Error; err != nil {
+		driverCleanup()
+		cleanup()
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to create deployment", err.Error())
+	}
+	launchAgent(session)
+	deployment.SessionID = session.ID
+	db.Model(&Deployment{}).Where("id = ?", deployment.ID).Update("session_id", session.ID)
+
+	log.Printf("🚀 Deployment %s of project %s started (%s to %s)", deployment.ID, project.ID, deployment.Driver, deployment.Target)
+	return c.Status(202).JSON(fiber.Map{
+		"success": true,
+		"data":    deploymentResponse(deployment),
+	})
+}
+
+// deploymentRunning answers 409 when a deployment of the project is in progress
+func deploymentRunning(c *fiber.Ctx, db *gorm.DB, projectID string) (bool, error) {
+	var running Deployment
+	if err := db.Where("project_id = ? AND status = ?", projectID, "running").Limit(1).Find(&running).Error; err != nil {
+		return true, sendError(c, 500, "DATABASE_ERROR", "Failed to check running deployments", err.Error())
+	}
+	if running.ID != "" {
+		return true, sendError(c, 409, "DEPLOY_RUNNING", "A deployment is already running for this project", running.ID)
+	}
+	return false, nil
+}
+
+// StartDeploy archives the project's build output and publishes it with the configured driver
+func StartDeploy(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		_, project, config, err := loadDeployProject(c, db)
+		if project == nil {
+			return err
+		}
+
+		sourceDir := filepath.Join(project.WorkspacePath, filepath.FromSlash(config.SourceDir))
+		if !isWithinDir(project.WorkspacePath, sourceDir) {
+			return sendError(c, 400, "INVALID_DEPLOY_CONFIG", "sourceDir must be inside the workspace", config.SourceDir)
+		}
+		if info, err := os.Stat(sourceDir); err != nil || !info.IsDir() {
+			return sendError(c, 400, "SOURCE_NOT_FOUND", "Build output not found; run a build first", config.SourceDir)
+		}
+
+		deployMu.Lock()
+		defer deployMu.Unlock()
+		if busy, err := deploymentRunning(c, db, project.ID); busy {
+			return err
+		}
+
+		deployment := &Deployment{
+			ID:        "dep_" + uuid.New().String()[:8],
+			ProjectID: project.ID,
+			Status:    "running",
+			StartedAt: time.Now().Unix(),
+		}
+		deployment.ArtifactPath = filepath.Join(getDeployArtifactDir(), project.ID, deployment.ID+".tar.gz")
+		if err := archiveDir(sourceDir, deployment.ArtifactPath, nil); err != nil {
+			return sendError(c, 500, "ARTIFACT_ERROR", "Failed to archive build output", err.Error())
+		}
+		deployment.HasArtifact = true
+
+		return startDeployment(c, db, project, config, deployment, sourceDir, func() {})
+	}
+}
+
+// RollbackDeploy redeploys the archive of an earlier deployment: the one given in
+// deploymentId, or else the last successful deployment before the current one
+func RollbackDeploy(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req, project, config, err := loadDeployProject(c, db)
+		if project == nil {
+			return err
+		}
+
+		deployMu.Lock()
+		defer deployMu.Unlock()
+		if busy, err := deploymentRunning(c, db, project.ID); busy {
+			return err
+		}
+
+		var target Deployment
+		query := db.Where("project_id = ? AND artifact_path <> ''", project.ID)
+		if req.DeploymentID != "" {
+			err = query.Where("id = ?", req.DeploymentID).Limit(1).Find(&target).Error
+		} else {
+			// Skip the deployment that is live now
+			err = query.Where("status = ?", "succeeded").Order("finished_at DESC").Offset(1).Limit(1).Find(&target).Error
+		}
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load deployments", err.Error())
+		}
+		if target.ID == "" {
+			return sendError(c, 404, "NO_ROLLBACK_TARGET", "No earlier deployment to roll back to", req.DeploymentID)
+		}
+		if !target.HasArtifact {
+			return sendError(c, 410, "ARTIFACT_GONE", "The artifact of this deployment has been pruned", target.ID)
+		}
+
+		dir, err := os.MkdirTemp("", "deploy-rollback-")
+		if err != nil {
+			return sendError(c, 500, "ARTIFACT_ERROR", "Failed to create a directory for the artifact", err.Error())
+		}
+		if err := extractArchive(target.ArtifactPath, dir); err != nil {
+			os.RemoveAll(dir)
+			return sendError(c, 500, "ARTIFACT_ERROR", "Failed to unpack the artifact", err.Error())
+		}
+
+		deployment := &Deployment{
+			ID:           "dep_" + uuid.New().String()[:8],
+			ProjectID:    project.ID,
+			Status:       "running",
+			ArtifactPath: target.ArtifactPath,
+			HasArtifact:  true,
+			RollbackOf:   target.ID,
+			StartedAt:    time.Now().Unix(),
+		}
+		return startDeployment(c, db, project, config, deployment, dir, func() {
+			os.RemoveAll(dir)
+		})
+	}
+}
+
+// ListDeployments returns the recent deployments of a project (?projectId=), newest first
+func ListDeployments(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var deployments []Deployment
+		err := db.Omit("log").Where("project_id = ?", c.Query("projectId")).
+			Order("started_at DESC").Limit(50).Find(&deployments).Error
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load deployments", err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"deployments": deployments,
+			},
+		})
+	}
+}
+
+// GetDeployment returns a deployment with its stored log
+func GetDeployment(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var deployment Deployment
+		if err := db.First(&deployment, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "DEPLOYMENT_NOT_FOUND", "Deployment not found", "")
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    deploymentResponse(&deployment),
+		})
+	}
+}
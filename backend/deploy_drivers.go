@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// deployDriver publishes a directory of build output somewhere
+type deployDriver interface {
+	// validate checks the driver's part of the deploy setting
+	validate(config *DeployConfig) error
+	// describe names the destination for the deployment record
+	describe(config *DeployConfig) string
+	// prepare sets up the session to publish sourceDir; the returned func cleans up afterwards
+	prepare(session *AgentSession, config *DeployConfig, sourceDir string) (func(), error)
+}
+
+// deployDrivers lists the supported values of the "driver" deploy setting
+var deployDrivers = map[string]deployDriver{
+	"rsync": rsyncDeployDriver{},
+	"s3":    s3DeployDriver{},
+	"hook":  hookDeployDriver{},
+}
+
+func deployDriverFor(config *DeployConfig) (deployDriver, error) {
+	driver, ok := deployDrivers[config.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown deploy driver %q (use rsync, s3 or hook)", config.Driver)
+	}
+	if err := driver.validate(config); err != nil {
+		return nil, err
+	}
+	return driver, nil
+}
+
+// rsyncDeployDriver mirrors the output to a server over SSH. The private key may be
+// stored in the project variable DEPLOY_SSH_KEY; otherwise the backend's SSH setup is used.
+type rsyncDeployDriver struct{}
+
+func (rsyncDeployDriver) validate(config *DeployConfig) error {
+	if config.Target == "" || !strings.Contains(config.Target, ":") {
+		return fmt.Errorf("rsync deploys need a target such as user@host:/var/www/site")
+	}
+	if strings.HasPrefix(config.Target, "-") {
+		return fmt.Errorf("invalid rsync target")
+	}
+	return nil
+}
+
+func (rsyncDeployDriver) describe(config *DeployConfig) string {
+	return config.Target
+}
+
+func (rsyncDeployDriver) prepare(session *AgentSession, config *DeployConfig, sourceDir string) (func(), error) {
+	port := config.SSHPort
+	if port == 0 {
+		port = 22
+	}
+	ssh := fmt.Sprintf("ssh -p %d -o BatchMode=yes -o StrictHostKeyChecking=accept-new", port)
+
+	cleanup := func() {}
+	if key := session.Env.Lookup("DEPLOY_SSH_KEY"); key != "" {
+		f, err := os.CreateTemp("", "deploy-key-")
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasSuffix(key, "\n") {
+			key += "\n"
+		}
+		_, err = f.WriteString(key)
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+			return nil, err
+		}
+		ssh += " -o IdentitiesOnly=yes -i " + f.Name()
+		cleanup = func() { os.Remove(f.Name()) }
+	}
+
+	session.Command = "rsync"
+	session.Args = []string{"-rlz", "--delete", "--stats", "-e", ssh, sourceDir + "/", config.Target}
+	return cleanup, nil
+}
+
+// s3DeployDriver uploads the output to an S3-compatible bucket (AWS S3, MinIO, R2, ...)
+// and removes objects under the prefix that are no longer part of the site. Credentials
+// come from the project variables DEPLOY_S3_ACCESS_KEY and DEPLOY_S3_SECRET_KEY.
+type s3DeployDriver struct{}
+
+func (s3DeployDriver) validate(config *DeployConfig) error {
+	if config.Bucket == "" {
+		return fmt.Errorf("s3 deploys need a bucket")
+	}
+	return nil
+}
+
+func (s3DeployDriver) describe(config *DeployConfig) string {
+	return "s3://" + path.Join(config.Bucket, strings.Trim(config.Prefix, "/"))
+}
+
+func (s3DeployDriver) prepare(session *AgentSession, config *DeployConfig, sourceDir string) (func(), error) {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(session.Env.Lookup("DEPLOY_S3_ACCESS_KEY"), session.Env.Lookup("DEPLOY_S3_SECRET_KEY"), ""),
+		Secure: config.UseSSL == nil || *config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	prefix := strings.Trim(config.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	session.Command = "s3"
+	session.Args = []string{"sync", sourceDir, "s3://" + config.Bucket + "/" + prefix}
+	session.run = func(session *AgentSession) error {
+		return syncDirToBucket(session, client, config.Bucket, prefix, sourceDir)
+	}
+	return func() {}, nil
+}
+
+// syncDirToBucket uploads every file below dir and deletes the objects under prefix it did not upload
+func syncDirToBucket(session *AgentSession, client *minio.Client, bucket, prefix, dir string) error {
+	ctx := session.Context
+	uploaded := make(map[string]bool)
+
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		key := prefix + filepath.ToSlash(rel)
+
+		contentType := mime.TypeByExtension(filepath.Ext(file))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		if _, err := client.FPutObject(ctx, bucket, key, file, minio.PutObjectOptions{ContentType: contentType}); err != nil {
+			return fmt.Errorf("upload %s: %w", key, err)
+		}
+		uploaded[key] = true
+		session.emit(fmt.Sprintf("upload: %s (%d bytes)", key, info.Size()))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("list %s: %w", bucket, object.Err)
+		}
+		if uploaded[object.Key] {
+			continue
+		}
+		if err := client.RemoveObject(ctx, bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("delete %s: %w", object.Key, err)
+		}
+		removed++
+		session.emit("delete: " + object.Key)
+	}
+
+	session.emit(fmt.Sprintf("Uploaded %d file(s), deleted %d stale object(s)", len(uploaded), removed))
+	return nil
+}
+
+// hookDeployDriver runs a shell command from the workspace, e.g. a deploy script or a
+// hosting provider's CLI. It finds the files to publish in $DEPLOY_SOURCE_DIR.
+type hookDeployDriver struct{}
+
+func (hookDeployDriver) validate(config *DeployConfig) error {
+	if strings.TrimSpace(config.Command) == "" {
+		return fmt.Errorf("hook deploys need a command")
+	}
+	return nil
+}
+
+func (hookDeployDriver) describe(config *DeployConfig) string {
+	return config.Command
+}
+
+func (hookDeployDriver) prepare(session *AgentSession, config *DeployConfig, sourceDir string) (func(), error) {
+	session.Command = "sh"
+	session.Args = []string{"-c", config.Command}
+	return func() {}, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diff operation kinds
+const (
+	DiffEqual  = ' '
+	DiffDelete = '-'
+	DiffInsert = '+'
+)
+
+// DiffOp is a single token of a diff (a line, a word, ...)
+type DiffOp struct {
+	Kind byte   `json:"-"`
+	Text string `json:"text"`
+}
+
+// diffTokens computes the shortest edit script between a and b (Myers' algorithm)
+func diffTokens(a, b []string) []DiffOp {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] holds the furthest x reached on diagonals -d-1..d+1 before round d
+	var trace [][]int
+	snapshot := func(d int) []int {
+		row := make([]int, 2*d+3)
+		copy(row, v[offset-d-1:offset+d+2])
+		return row
+	}
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, snapshot(d))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script
+	var ops []DiffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		row := trace[d]
+		at := func(k int) int { return row[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, DiffOp{Kind: DiffEqual, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, DiffOp{Kind: DiffInsert, Text: b[y-1]})
+				y--
+			} else {
+				ops = append(ops, DiffOp{Kind: DiffDelete, Text: a[x-1]})
+				x--
+			}
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// splitLines splits text into lines without their trailing newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unifiedDiff renders a unified diff (as produced by diff -u) between two texts
+func unifiedDiff(oldName, newName, oldText, newText string, context int) string {
+	ops := diffTokens(splitLines(oldText), splitLines(newText))
+
+	// Line numbers of each op in the old and new files
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.Kind != DiffInsert {
+			oldLine[i+1]++
+		}
+		if op.Kind != DiffDelete {
+			newLine[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	i := 0
+	for i < len(ops) {
+		for i < len(ops) && ops[i].Kind == DiffEqual {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are within 2*context lines of each other
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].Kind != DiffEqual {
+				end = j
+			} else if j-end > 2*context {
+				break
+			}
+		}
+		stop := end + context + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		oldCount := oldLine[stop] - oldLine[start]
+		newCount := newLine[stop] - newLine[start]
+		oldStart, newStart := oldLine[start]+1, newLine[start]+1
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, op := range ops[start:stop] {
+			out.WriteByte(op.Kind)
+			out.WriteString(op.Text)
+			out.WriteByte('\n')
+		}
+
+		i = stop
+	}
+
+	return out.String()
+}
+
+// diffStats counts inserted and deleted tokens in an edit script
+func diffStats(ops []DiffOp) (added, removed int) {
+	for _, op := range ops {
+		switch op.Kind {
+		case DiffInsert:
+			added++
+		case DiffDelete:
+			removed++
+		}
+	}
+	return added, removed
+}
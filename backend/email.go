@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"gorm.io/gorm"
+)
+
+// EmailMessage is a plain-text email
+type EmailMessage struct {
+	From    string
+	To      []string
+	ReplyTo string
+	Subject string
+	Text    string
+	Date    time.Time
+}
+
+// Mailer delivers email through a provider
+type Mailer interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+// EmailSettings select and configure the email driver. The environment provides the
+// defaults; a project can replace them with PUT /api/projects/:id/email.
+type EmailSettings struct {
+	Driver   string   `json:"driver"`             // smtp, mailgun or ses
+	From     string   `json:"from"`               // Sender of every email
+	Host     string   `json:"host,omitempty"`     // smtp
+	Port     string   `json:"port,omitempty"`     // smtp; default 587
+	Username string   `json:"username,omitempty"` // smtp user, or the ses access key
+	Secret   string   `json:"-"`                  // smtp password, mailgun API key or ses secret key
+	Domain   string   `json:"domain,omitempty"`   // mailgun sending domain
+	Region   string   `json:"region,omitempty"`   // mailgun: us or eu; ses: AWS region
+	FormTo   []string `json:"formTo,omitempty"`   // Recipients of form submissions
+
+	public bool // Set by a project: the SMTP server must have a public address
+}
+
+// ProjectEmailSettings are the email settings of a project
+type ProjectEmailSettings struct {
+	ProjectID string   `gorm:"primaryKey" json:"projectId"`
+	Driver    string   `json:"driver"`
+	From      string   `json:"from"`
+	Host      string   `json:"host,omitempty"`
+	Port      string   `json:"port,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Secret    string   `gorm:"type:text" json:"-"` // encryptSecret output
+	Domain    string   `json:"domain,omitempty"`
+	Region    string   `json:"region,omitempty"`
+	FormTo    []string `gorm:"serializer:json" json:"formTo"`
+	UpdatedAt int64    `json:"updatedAt"`
+}
+
+// EmailSettingsRequest is the body of PUT /api/projects/:id/email. An empty secret
+// keeps the stored one, so the settings can be changed without sending it again.
+type EmailSettingsRequest struct {
+	Driver   string   `json:"driver" validate:"required,oneof=smtp mailgun ses"`
+	From     string   `json:"from" validate:"required,max=320"`
+	Host     string   `json:"host,omitempty" validate:"omitempty,hostname_rfc1123|ip"`
+	Port     string   `json:"port,omitempty" validate:"omitempty,numeric"`
+	Username string   `json:"username,omitempty" validate:"max=320"`
+	Secret   string   `json:"secret,omitempty" validate:"max=4096"`
+	Domain   string   `json:"domain,omitempty" validate:"omitempty,fqdn"`
+	Region   string   `json:"region,omitempty" validate:"max=64"`
+	FormTo   []string `json:"formTo,omitempty" validate:"max=20,dive,email"`
+}
+
+// EmailTestRequest is the body of POST /api/projects/:id/email/test
+type EmailTestRequest struct {
+	To string `json:"to" validate:"required,email"`
+}
+
+// EmailSettingsResponse is the data of GET and PUT /api/projects/:id/email
+type EmailSettingsResponse struct {
+	ProjectID string        `json:"projectId"`
+	Source    string        `json:"source"` // project, environment, or none when email is off
+	Settings  EmailSettings `json:"settings"`
+	HasSecret bool          `json:"hasSecret"`
+	UpdatedAt int64         `json:"updatedAt,omitempty"`
+}
+
+// EmailTestResponse is the data of POST /api/projects/:id/email/test
+type EmailTestResponse struct {
+	To   string `json:"to"`
+	Sent bool   `json:"sent"`
+}
+
+var emailClient = &http.Client{Timeout: 15 * time.Second}
+
+// splitAddresses splits a comma-separated list of addresses
+func splitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// getEnvEmailSettings returns the email settings of the default workspace and of
+// projects without their own (email.*: EMAIL_DRIVER, EMAIL_FROM, FORM_EMAIL_TO and the
+// SMTP_*, MAILGUN_* or SES_* variables of the driver)
+func getEnvEmailSettings() EmailSettings {
+	return appConfig.Email.settings()
+}
+
+// settings returns the account of the configured driver as EmailSettings
+func (e EmailConfig) settings() EmailSettings {
+	settings := EmailSettings{Driver: e.Driver, From: e.From, FormTo: e.FormTo}
+	switch settings.Driver {
+	case "smtp":
+		settings.Host = e.SMTP.Host
+		settings.Port = strconv.Itoa(e.SMTP.Port)
+		settings.Username = e.SMTP.Username
+		settings.Secret = e.SMTP.Password
+		if settings.From == "" {
+			settings.From = settings.Username
+		}
+	case "mailgun":
+		settings.Domain = e.Mailgun.Domain
+		settings.Secret = e.Mailgun.APIKey
+		settings.Region = e.Mailgun.Region
+	case "ses":
+		settings.Region = e.SES.Region
+		settings.Username = e.SES.AccessKey
+		settings.Secret = e.SES.SecretKey
+	}
+	return settings
+}
+
+// validate checks the default email settings; without a driver, smtp is used when
+// smtp.host is set
+func (e *EmailConfig) validate() []error {
+	var errs []error
+	if e.Driver == "" && e.SMTP.Host != "" {
+		e.Driver = "smtp"
+	}
+	if e.SMTP.Port < 1 || e.SMTP.Port > 65535 {
+		errs = append(errs, fmt.Errorf("email.smtp.port must be between 1 and 65535, got %d", e.SMTP.Port))
+	}
+	if e.Mailgun.Region != "us" && e.Mailgun.Region != "eu" {
+		errs = append(errs, fmt.Errorf("email.mailgun.region must be us or eu, got %q", e.Mailgun.Region))
+	}
+	if e.Driver != "" {
+		if _, err := e.settings().mailer(); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+	return errs
+}
+
+// enabled reports whether email can be sent with the settings
+func (s EmailSettings) enabled() bool {
+	return s.Driver != "" && s.From != ""
+}
+
+// mailer returns the driver of the settings
+func (s EmailSettings) mailer() (Mailer, error) {
+	switch s.Driver {
+	case "smtp":
+		if s.Host == "" {
+			return nil, fmt.Errorf("smtp needs a host")
+		}
+		port := s.Port
+		if port == "" {
+			port = "587"
+		}
+		return smtpMailer{host: s.Host, port: port, username: s.Username, password: s.Secret, public: s.public}, nil
+	case "mailgun":
+		if s.Domain == "" || s.Secret == "" {
+			return nil, fmt.Errorf("mailgun needs a domain and an API key")
+		}
+		base := "https://api.mailgun.net"
+		if s.Region == "eu" {
+			base = "https://api.eu.mailgun.net"
+		}
+		return mailgunMailer{endpoint: base + "/v3/" + url.PathEscape(s.Domain) + "/messages", apiKey: s.Secret}, nil
+	case "ses":
+		if s.Region == "" || s.Username == "" || s.Secret == "" {
+			return nil, fmt.Errorf("ses needs a region, an access key and a secret key")
+		}
+		return sesMailer{region: s.Region, accessKey: s.Username, secretKey: s.Secret}, nil
+	}
+	return nil, fmt.Errorf("unknown email driver %q (use smtp, mailgun or ses)", s.Driver)
+}
+
+// smtpMailer sends through an SMTP server, upgrading to TLS when the server offers it
+type smtpMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	public   bool // Connect through publicDialer
+}
+
+func (m smtpMailer) Send(ctx context.Context, msg EmailMessage) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	sender := msg.From
+	if address, err := mail.ParseAddress(msg.From); err == nil {
+		sender = address.Address
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if m.public {
+		dialer = publicDialer
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, m.port))
+	if err != nil {
+		return err
+	}
+	// The SMTP client takes no context, so the deadline bounds the whole conversation
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	return sendSMTP(client, m.host, auth, sender, msg.To, mimeMessage(msg))
+}
+
+// sendSMTP delivers a message over a connected client like smtp.SendMail: it upgrades
+// to TLS when the server offers it and authenticates when it supports AUTH
+func sendSMTP(client *smtp.Client, host string, auth smtp.Auth, from string, to []string, message []byte) error {
+	if err := client.Hello("localhost"); err != nil {
+		return err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// mimeMessage renders a message with its headers for SMTP
+func mimeMessage(msg EmailMessage) []byte {
+	date := msg.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	if msg.ReplyTo != "" {
+		fmt.Fprintf(&b, "Reply-To: %s\r\n", msg.ReplyTo)
+	}
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Text, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// mailgunMailer sends through the Mailgun messages API
+type mailgunMailer struct {
+	endpoint string
+	apiKey   string
+}
+
+func (m mailgunMailer) Send(ctx context.Context, msg EmailMessage) error {
+	form := url.Values{}
+	form.Set("from", msg.From)
+	for _, to := range msg.To {
+		form.Add("to", to)
+	}
+	form.Set("subject", msg.Subject)
+	form.Set("text", msg.Text)
+	if msg.ReplyTo != "" {
+		form.Set("h:Reply-To", msg.ReplyTo)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("api", m.apiKey)
+	return doEmailRequest(req, "mailgun")
+}
+
+// sesMailer sends through the Amazon SES v2 API
+type sesMailer struct {
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func (m sesMailer) Send(ctx context.Context, msg EmailMessage) error {
+	content := func(data string) fiber.Map {
+		return fiber.Map{"Data": data, "Charset": "UTF-8"}
+	}
+	payload := fiber.Map{
+		"FromEmailAddress": msg.From,
+		"Destination":      fiber.Map{"ToAddresses": msg.To},
+		"Content": fiber.Map{"Simple": fiber.Map{
+			"Subject": content(msg.Subject),
+			"Body":    fiber.Map{"Text": content(msg.Text)},
+		}},
+	}
+	if msg.ReplyTo != "" {
+		payload["ReplyToAddresses"] = []string{msg.ReplyTo}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := "https://email." + m.region + ".amazonaws.com/v2/email/outbound-emails"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	return doEmailRequest(signer.SignV4WithServiceType(*req, m.accessKey, m.secretKey, "", m.region, "ses"), "ses")
+}
+
+// doEmailRequest sends an API request and turns an error status into an error
+func doEmailRequest(req *http.Request, driver string) error {
+	resp, err := emailClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("%s returned %s: %s", driver, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// settings returns the stored settings with the secret decrypted
+func (p ProjectEmailSettings) settings() (EmailSettings, error) {
+	settings := EmailSettings{
+		Driver:   p.Driver,
+		From:     p.From,
+		Host:     p.Host,
+		Port:     p.Port,
+		Username: p.Username,
+		Domain:   p.Domain,
+		Region:   p.Region,
+		FormTo:   p.FormTo,
+		public:   true,
+	}
+	if p.Secret != "" {
+		secret, err := decryptSecret(p.Secret)
+		if err != nil {
+			return EmailSettings{}, err
+		}
+		settings.Secret = secret
+	}
+	return settings, nil
+}
+
+// resolveEmailSettings returns the email settings of a project, falling back to the
+// environment for the default workspace and for projects without their own. source
+// is project or environment.
+func resolveEmailSettings(db *gorm.DB, projectID string) (EmailSettings, string, error) {
+	if projectID != "" {
+		var stored ProjectEmailSettings
+		err := db.First(&stored, "project_id = ?", projectID).Error
+		if err == nil {
+			settings, err := stored.settings()
+			return settings, "project", err
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return EmailSettings{}, "", err
+		}
+	}
+	return getEnvEmailSettings(), "environment", nil
+}
+
+// sendEmail sends a message with the email settings of a project. The sender is
+// filled in from the settings.
+func sendEmail(ctx context.Context, db *gorm.DB, projectID string, msg EmailMessage) error {
+	settings, _, err := resolveEmailSettings(db, projectID)
+	if err != nil {
+		return err
+	}
+	if !settings.enabled() {
+		return fmt.Errorf("email is not configured")
+	}
+	mailer, err := settings.mailer()
+	if err != nil {
+		return err
+	}
+	msg.From = settings.From
+	return mailer.Send(ctx, msg)
+}
+
+// emailSettingsResponse describes the settings in effect for a project without its secret
+func emailSettingsResponse(db *gorm.DB, projectID string) (EmailSettingsResponse, error) {
+	settings, source, err := resolveEmailSettings(db, projectID)
+	if err != nil {
+		return EmailSettingsResponse{}, err
+	}
+	response := EmailSettingsResponse{ProjectID: projectID, Source: source, Settings: settings, HasSecret: settings.Secret != ""}
+	if source == "project" {
+		var stored ProjectEmailSettings
+		db.Select("updated_at").First(&stored, "project_id = ?", projectID)
+		response.UpdatedAt = stored.UpdatedAt
+	} else if !settings.enabled() {
+		response.Source = "none"
+	}
+	return response, nil
+}
+
+// GetProjectEmail returns the email settings in effect for a project
+func GetProjectEmail(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("id")
+		if err := db.First(&Project{}, "id = ?", projectID).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+
+		response, err := emailSettingsResponse(db, projectID)
+		if err != nil {
+			return sendError(c, 500, "EMAIL_SETTINGS_ERROR", "Failed to load email settings", err.Error())
+		}
+		return c.JSON(APIResponse[EmailSettingsResponse]{Success: true, Data: response})
+	}
+}
+
+// PutProjectEmail stores the email settings of a project, replacing the environment's
+func PutProjectEmail(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("id")
+		if err := db.First(&Project{}, "id = ?", projectID).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+		req := validatedBody[EmailSettingsRequest](c)
+		if _, err := mail.ParseAddress(req.From); err != nil {
+			return sendError(c, 400, "INVALID_SENDER", "from must be an email address", err.Error())
+		}
+
+		var stored ProjectEmailSettings
+		err := db.First(&stored, "project_id = ?", projectID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load email settings", err.Error())
+		}
+		// The stored secret is only reused for the same account on the same server, so
+		// it can't be sent to a server the caller picks
+		username := strings.TrimSpace(req.Username)
+		if req.Secret == "" && (stored.Driver != req.Driver || stored.Host != req.Host || stored.Port != req.Port || stored.Username != username) {
+			stored.Secret = ""
+		}
+		if req.Driver == "smtp" && req.Host != "" {
+			if err := checkPublicHost(c.Context(), req.Host); err != nil {
+				return sendError(c, 400, "INVALID_EMAIL_SETTINGS", "The SMTP host must have a public address", err.Error())
+			}
+		}
+		if req.Secret != "" {
+			encrypted, err := encryptSecret(req.Secret)
+			if err != nil {
+				return sendError(c, 500, "ENCRYPTION_ERROR", "Failed to encrypt email secret", err.Error())
+			}
+			stored.Secret = encrypted
+		}
+
+		settings := ProjectEmailSettings{
+			ProjectID: projectID,
+			Driver:    req.Driver,
+			From:      strings.TrimSpace(req.From),
+			Host:      req.Host,
+			Port:      req.Port,
+			Username:  username,
+			Secret:    stored.Secret,
+			Domain:    req.Domain,
+			Region:    req.Region,
+			FormTo:    req.FormTo,
+			UpdatedAt: time.Now().Unix(),
+		}
+		check, err := settings.settings()
+		if err != nil {
+			return sendError(c, 500, "ENCRYPTION_ERROR", "Failed to decrypt email secret", err.Error())
+		}
+		if _, err := check.mailer(); err != nil {
+			return sendError(c, 400, "INVALID_EMAIL_SETTINGS", "Incomplete email settings", err.Error())
+		}
+		if err := db.Save(&settings).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to save email settings", err.Error())
+		}
+		log.Printf("✉️ Project %s sends email with %s", projectID, settings.Driver)
+
+		response, err := emailSettingsResponse(db, projectID)
+		if err != nil {
+			return sendError(c, 500, "EMAIL_SETTINGS_ERROR", "Failed to load email settings", err.Error())
+		}
+		return c.JSON(APIResponse[EmailSettingsResponse]{Success: true, Data: response})
+	}
+}
+
+// DeleteProjectEmail removes the email settings of a project, which then uses the environment's
+func DeleteProjectEmail(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result := db.Where("project_id = ?", c.Params("id")).Delete(&ProjectEmailSettings{})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete email settings", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "EMAIL_SETTINGS_NOT_FOUND", "The project has no email settings", "")
+		}
+
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: c.Params("id"), Deleted: true},
+		})
+	}
+}
+
+// TestProjectEmail sends a sample email so users can check the settings
+func TestProjectEmail(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("id")
+		if err := db.First(&Project{}, "id = ?", projectID).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+		req := validatedBody[EmailTestRequest](c)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := sendEmail(ctx, db, projectID, EmailMessage{
+			To:      []string{req.To},
+			Subject: "Test email from the site editor",
+			Text:    "This is a test email for project " + projectID + ". Email from the site editor reaches you.\n",
+		})
+		if err != nil {
+			return sendError(c, 502, "EMAIL_FAILED", "Failed to send test email", err.Error())
+		}
+
+		return c.JSON(APIResponse[EmailTestResponse]{
+			Success: true,
+			Data:    EmailTestResponse{To: req.To, Sent: true},
+		})
+	}
+}
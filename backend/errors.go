@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIError describes why a request failed. Every endpoint answers errors with it in an
+// APIErrorResponse; handlers either send it with sendError or return it and let
+// HandleError send it.
+type APIError struct {
+	Status  int         `json:"-"`
+	Code    string      `json:"code"` // Stable, machine-readable, e.g. COMMAND_NOT_FOUND
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// APIErrorResponse is the body of every failed request
+type APIErrorResponse struct {
+	Success bool     `json:"success"` // Always false
+	Error   APIError `json:"error"`
+}
+
+// Codes of errors that have no more specific code, e.g. those Fiber raises itself
+const (
+	ErrCodeInvalidRequest   = "INVALID_REQUEST"
+	ErrCodeUnauthorized     = "UNAUTHORIZED"
+	ErrCodeForbidden        = "FORBIDDEN"
+	ErrCodeNotFound         = "NOT_FOUND"
+	ErrCodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	ErrCodeConflict         = "CONFLICT"
+	ErrCodePayloadTooLarge  = "PAYLOAD_TOO_LARGE"
+	ErrCodeRateLimited      = "RATE_LIMITED"
+	ErrCodeInternal         = "INTERNAL_ERROR"
+	ErrCodeUnavailable      = "SERVICE_UNAVAILABLE"
+)
+
+// statusErrorCodes maps HTTP statuses to the code of errors raised without one
+var statusErrorCodes = map[int]string{
+	fiber.StatusBadRequest:            ErrCodeInvalidRequest,
+	fiber.StatusUnauthorized:          ErrCodeUnauthorized,
+	fiber.StatusForbidden:             ErrCodeForbidden,
+	fiber.StatusNotFound:              ErrCodeNotFound,
+	fiber.StatusMethodNotAllowed:      ErrCodeMethodNotAllowed,
+	fiber.StatusConflict:              ErrCodeConflict,
+	fiber.StatusRequestEntityTooLarge: ErrCodePayloadTooLarge,
+	fiber.StatusTooManyRequests:       ErrCodeRateLimited,
+	fiber.StatusInternalServerError:   ErrCodeInternal,
+	fiber.StatusServiceUnavailable:    ErrCodeUnavailable,
+}
+
+// errorCodeForStatus returns the generic code of an HTTP error status
+func errorCodeForStatus(status int) string {
+	if code, ok := statusErrorCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return ErrCodeInternal
+	}
+	return ErrCodeInvalidRequest
+}
+
+// newAPIError creates an error for a handler to return. details is omitted when nil
+// or an empty string.
+func newAPIError(status int, code, message string, details interface{}) *APIError {
+	if s, ok := details.(string); ok && s == "" {
+		details = nil
+	}
+	return &APIError{Status: status, Code: code, Message: message, Details: details}
+}
+
+// sendError answers the request with an error envelope
+func sendError(c *fiber.Ctx, status int, code, message string, details interface{}) error {
+	err := newAPIError(status, code, message, details)
+	return c.Status(status).JSON(APIErrorResponse{Error: *err})
+}
+
+// HandleError is the Fiber error handler. It answers errors returned by handlers and
+// middleware, including recovered panics, with an error envelope.
+func HandleError(c *fiber.Ctx, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return sendError(c, apiErr.Status, apiErr.Code, apiErr.Message, apiErr.Details)
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return sendError(c, fiberErr.Code, errorCodeForStatus(fiberErr.Code), fiberErr.Message, nil)
+	}
+
+	log.Printf("❌ %s %s: %v", c.Method(), c.Path(), err)
+	return sendError(c, fiber.StatusInternalServerError, ErrCodeInternal, "Internal server error", nil)
+}
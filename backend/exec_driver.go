@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Execution drivers selectable via AI_EXECUTION_DRIVER
+const (
+	ExecutionHost   = "host"   // Run the CLI directly on the backend host
+	ExecutionDocker = "docker" // Run the CLI in a short-lived container that can only write to the workspace
+)
+
+// dockerHome is HOME inside command containers, backed by AI_DOCKER_STATE_DIR
+const dockerHome = "/home/agent"
+
+// getExecutionDriver returns how AI CLIs are started (execution.driver, AI_EXECUTION_DRIVER)
+// Falls back to host
+func getExecutionDriver() string {
+	return appConfig.Execution.Driver
+}
+
+// getDockerImage returns the image command containers run (execution.dockerImage, AI_DOCKER_IMAGE)
+// It has no default: the image must contain the claude CLI
+func getDockerImage() string {
+	return appConfig.Execution.DockerImage
+}
+
+// getDockerStateDir returns the directory mounted as HOME in command containers (execution.dockerStateDir, AI_DOCKER_STATE_DIR)
+// Falls back to docker-home (relative to the working directory); it keeps CLI sessions across commands
+func getDockerStateDir() string {
+	return appConfig.Execution.DockerStateDir
+}
+
+// getDockerNetwork returns the network of command containers (execution.dockerNetwork, AI_DOCKER_NETWORK)
+// Falls back to Docker's default bridge network
+func getDockerNetwork() string {
+	return appConfig.Execution.DockerNetwork
+}
+
+// validate checks the execution settings
+func (e ExecutionConfig) validate() []error {
+	var errs []error
+	switch e.Driver {
+	case ExecutionHost:
+	case ExecutionDocker:
+		if e.DockerImage == "" {
+			errs = append(errs, errors.New("execution.dockerImage is required for the docker driver"))
+		}
+		if e.DockerStateDir == "" {
+			errs = append(errs, errors.New("execution.dockerStateDir must not be empty"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("execution.driver must be host or docker, got %q", e.Driver))
+	}
+	return errs
+}
+
+// getProcessKillGrace returns how long an interrupted or timed out process may take to exit after SIGTERM (timeouts.killGrace, PROCESS_KILL_GRACE)
+// Falls back to 5 seconds; whatever is left of it is then killed with SIGKILL
+func getProcessKillGrace() time.Duration {
+	return appConfig.Timeouts.KillGrace.Duration()
+}
+
+// killGroupOnCancel makes cancelling the context of cmd stop its whole process group,
+// so the tools and subprocesses it started do not outlive an interrupt or timeout:
+// SIGTERM first, SIGKILL once the grace period is over. cmd must lead its own group
+// (Setpgid or Setsid).
+func killGroupOnCancel(cmd *exec.Cmd) {
+	grace := getProcessKillGrace()
+	cmd.Cancel = func() error {
+		err := stopProcessGroup(cmd.Process.Pid, grace)
+		if errors.Is(err, syscall.ESRCH) {
+			return os.ErrProcessDone
+		}
+		return err
+	}
+	// Stop waiting for output held open by a process that left the group
+	cmd.WaitDelay = grace + 5*time.Second
+}
+
+// stopProcessGroup sends SIGTERM to the process group pgid, then SIGKILL once grace is over
+func stopProcessGroup(pgid int, grace time.Duration) error {
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	// Children that ignore SIGTERM keep the group alive after the leader exits
+	time.AfterFunc(grace, func() {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	})
+	return nil
+}
+
+// commandProcess returns the process that runs name in workDir for an AI command,
+// on the host or in a container depending on AI_EXECUTION_DRIVER, within the CPU and
+// memory limits of limiter. The returned func cleans up after the process has exited.
+func commandProcess(ctx context.Context, commandID, workDir string, env ChildEnv, limiter *processLimiter, name string, args ...string) (*exec.Cmd, func(), error) {
+	switch driver := getExecutionDriver(); driver {
+	case ExecutionHost:
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Dir = workDir
+		cmd.Env = env.Vars
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		killGroupOnCancel(cmd)
+		if err := limiter.prepare(cmd); err != nil {
+			return nil, nil, err
+		}
+		return cmd, limiter.release, nil
+
+	case ExecutionDocker:
+		return dockerCommandProcess(ctx, commandID, workDir, env, limiter, name, args)
+
+	default:
+		return nil, nil, fmt.Errorf("unknown AI_EXECUTION_DRIVER %q (use host or docker)", driver)
+	}
+}
+
+// dockerCommandProcess runs name in a container with the workspace bind-mounted
+// read-write at its host path and a read-only root filesystem. Only the workspace,
+// HOME (AI_DOCKER_STATE_DIR) and a fresh /tmp are writable.
+func dockerCommandProcess(ctx context.Context, commandID, workDir string, env ChildEnv, limiter *processLimiter, name string, args []string) (*exec.Cmd, func(), error) {
+	image := getDockerImage()
+	if image == "" {
+		return nil, nil, fmt.Errorf("AI_EXECUTION_DRIVER=docker needs AI_DOCKER_IMAGE")
+	}
+	workspace, err := filepath.Abs(workDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	home, err := filepath.Abs(getDockerStateDir())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create AI_DOCKER_STATE_DIR: %w", err)
+	}
+
+	container := strings.ReplaceAll(commandID, "_", "-") // cmd-<time>-<id>
+	dockerArgs := []string{
+		"run", "--rm", "-i", "--init", "--name", container,
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"--read-only", "--tmpfs", "/tmp",
+		"-v", workspace + ":" + workspace,
+		"-v", home + ":" + dockerHome,
+		"-w", workspace,
+		"-e", "HOME=" + dockerHome,
+	}
+	if network := getDockerNetwork(); network != "" {
+		dockerArgs = append(dockerArgs, "--network", network)
+	}
+	if limiter != nil {
+		dockerArgs = append(dockerArgs, dockerLimitArgs(limiter.limits)...)
+	}
+	// -e KEY takes the value from the docker client, so secrets stay off the command line
+	for _, kv := range env.Vars {
+		if key, _, _ := strings.Cut(kv, "="); !hostEnvKeys[key] {
+			dockerArgs = append(dockerArgs, "-e", key)
+		}
+	}
+	dockerArgs = append(append(dockerArgs, image, name), args...)
+
+	cmd := exec.CommandContext(ctx, "docker", dockerArgs...)
+	cmd.Dir = workspace
+	cmd.Env = env.Vars
+	// Killing the docker client would leave the container running, so the container is
+	// stopped instead; the client exits with it
+	grace := getProcessKillGrace()
+	cmd.Cancel = func() error {
+		go stopDockerContainer(container, grace)
+		return nil
+	}
+	cmd.WaitDelay = grace + 30*time.Second
+
+	log.Printf("🐳 Running %s for command [%s] in container %s (%s)", name, commandID, container, image)
+	return cmd, func() { removeDockerContainer(container) }, nil
+}
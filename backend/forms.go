@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Limits of a single form submission
+const (
+	maxFormFields     = 50
+	maxFormFieldName  = 100
+	maxFormFieldValue = 10000
+)
+
+// maxFormSubmissions caps the submissions returned by one listing request
+const maxFormSubmissions = 500
+
+// formIDPattern matches form IDs such as contact or newsletter-signup
+var formIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// FormSubmission is a message a visitor sent through a form of the site
+type FormSubmission struct {
+	ID          uint              `gorm:"primaryKey" json:"id"`
+	FormID      string            `gorm:"index" json:"formId"`
+	ProjectID   string            `gorm:"index" json:"projectId,omitempty"` // From ?projectId=; selects the email settings
+	Fields      map[string]string `gorm:"serializer:json" json:"fields"`
+	IP          string            `json:"ip,omitempty"`
+	UserAgent   string            `json:"userAgent,omitempty"`
+	Referrer    string            `json:"referrer,omitempty"`    // Page the form was sent from
+	ForwardedAt int64             `json:"forwardedAt,omitempty"` // When it was emailed to the form recipients
+	CreatedAt   int64             `gorm:"index" json:"createdAt"`
+}
+
+// FormSubmitted is the data of a successful POST /api/forms/:formId/submit
+type FormSubmitted struct {
+	ID        uint   `json:"id"`
+	FormID    string `json:"formId"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// FormSummary is a form in the response of GET /api/forms
+type FormSummary struct {
+	FormID          string `json:"formId"`
+	Submissions     int64  `json:"submissions"`
+	LastSubmittedAt int64  `json:"lastSubmittedAt"`
+}
+
+// FormList is the data of GET /api/forms
+type FormList struct {
+	Forms []FormSummary `json:"forms"`
+}
+
+// FormSubmissionList is the data of GET /api/forms/:formId/submissions, newest first
+type FormSubmissionList struct {
+	Submissions []FormSubmission `json:"submissions"`
+	NextBefore  uint             `json:"nextBefore,omitempty"` // Pass as ?before= for the next page
+}
+
+// getFormHoneypotField returns FORM_HONEYPOT_FIELD, the hidden field that only bots fill in
+func getFormHoneypotField() string {
+	return getEnvDefault("FORM_HONEYPOT_FIELD", "_gotcha")
+}
+
+// getFormAllowedIDs returns FORM_ALLOWED_IDS; when empty, any valid form ID is accepted
+func getFormAllowedIDs() map[string]bool {
+	allowed := make(map[string]bool)
+	for _, id := range strings.Split(os.Getenv("FORM_ALLOWED_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			allowed[id] = true
+		}
+	}
+	return allowed
+}
+
+// formFieldValue turns a JSON value into the text stored for a field
+func formFieldValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case bool, float64:
+		return fmt.Sprint(v), true
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			part, ok := formFieldValue(item)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, ", "), true
+	}
+	return "", false
+}
+
+// parseFormFields reads the fields of a submission sent as JSON, as a urlencoded form
+// or as a multipart form; files of multipart forms are ignored. Repeated fields such as
+// checkboxes are joined with commas.
+func parseFormFields(c *fiber.Ctx) (map[string]string, error) {
+	fields := make(map[string]string)
+	add := func(name, value string) {
+		if existing, ok := fields[name]; ok && existing != "" {
+			value = existing + ", " + value
+		}
+		fields[name] = value
+	}
+
+	contentType := strings.ToLower(c.Get(fiber.HeaderContentType))
+	switch {
+	case strings.HasPrefix(contentType, fiber.MIMEApplicationJSON):
+		var body map[string]interface{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return nil, newAPIError(400, "INVALID_SUBMISSION", "The submission is not a JSON object", err.Error())
+		}
+		for name, value := range body {
+			text, ok := formFieldValue(value)
+			if !ok {
+				return nil, newAPIError(400, "INVALID_SUBMISSION", "Field values must be strings, numbers, booleans or lists of them", name)
+			}
+			add(name, text)
+		}
+	case strings.HasPrefix(contentType, fiber.MIMEMultipartForm):
+		form, err := c.MultipartForm()
+		if err != nil {
+			return nil, newAPIError(400, "INVALID_SUBMISSION", "The multipart form could not be read", err.Error())
+		}
+		for name, values := range form.Value {
+			for _, value := range values {
+				add(name, value)
+			}
+		}
+	case strings.HasPrefix(contentType, fiber.MIMEApplicationForm):
+		c.Request().PostArgs().VisitAll(func(key, value []byte) {
+			add(string(key), string(value))
+		})
+	default:
+		return nil, newAPIError(415, "UNSUPPORTED_MEDIA_TYPE", "Send the form as JSON, urlencoded or multipart", contentType)
+	}
+
+	if len(fields) > maxFormFields {
+		return nil, newAPIError(400, "TOO_MANY_FIELDS", "A submission can have at most this many fields", maxFormFields)
+	}
+	for name, value := range fields {
+		if len(name) > maxFormFieldName || strings.ContainsAny(name, "\r\n\x00") {
+			return nil, newAPIError(400, "INVALID_FIELD", "Field names must be a single line of at most this many characters", maxFormFieldName)
+		}
+		if len(value) > maxFormFieldValue {
+			return nil, newAPIError(400, "FIELD_TOO_LONG", "Field values can have at most this many characters", name)
+		}
+	}
+	return fields, nil
+}
+
+// wantsJSON tells scripts, which get JSON, apart from browsers posting an HTML form,
+// which get redirected or a thank-you page
+func wantsJSON(c *fiber.Ctx) bool {
+	return strings.HasPrefix(strings.ToLower(c.Get(fiber.HeaderContentType)), fiber.MIMEApplicationJSON) ||
+		strings.Contains(c.Get(fiber.HeaderAccept), fiber.MIMEApplicationJSON)
+}
+
+// formRedirect returns the _redirect URL of a form when it points to the site the form
+// was sent from, so the endpoint cannot be used to redirect elsewhere
+func formRedirect(c *fiber.Ctx, target string) string {
+	redirect, err := url.Parse(target)
+	if err != nil || (redirect.Scheme != "http" && redirect.Scheme != "https") {
+		return ""
+	}
+	for _, header := range []string{fiber.HeaderOrigin, fiber.HeaderReferer} {
+		if source, err := url.Parse(c.Get(header)); err == nil && source.Host != "" && strings.EqualFold(source.Host, redirect.Host) {
+			return redirect.String()
+		}
+	}
+	return ""
+}
+
+// sendFormAccepted answers a submission, also when it was dropped as spam
+func sendFormAccepted(c *fiber.Ctx, submission FormSubmission, redirect string) error {
+	if wantsJSON(c) {
+		return c.Status(201).JSON(APIResponse[FormSubmitted]{
+			Success: true,
+			Data:    FormSubmitted{ID: submission.ID, FormID: submission.FormID, CreatedAt: submission.CreatedAt},
+		})
+	}
+	if target := formRedirect(c, redirect); target != "" {
+		return c.Redirect(target, fiber.StatusSeeOther)
+	}
+
+	back := ""
+	if referrer, err := url.Parse(c.Get(fiber.HeaderReferer)); err == nil && (referrer.Scheme == "http" || referrer.Scheme == "https") {
+		back = fmt.Sprintf(`<p><a href="%s">Back</a></p>`, html.EscapeString(referrer.String()))
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Status(200).SendString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Thank you</title></head>" +
+		"<body><p>Thank you, your message has been sent.</p>" + back + "</body></html>\n")
+}
+
+// SubmitForm stores a submission of a form of the site and forwards it by email when
+// the email settings of the project (?projectId=) name form recipients. Submissions that fill in the honeypot field are answered like
+// any other but dropped. Fields starting with _ control the endpoint and are not stored.
+func SubmitForm(db *gorm.DB) fiber.Handler {
+	honeypot := getFormHoneypotField()
+	allowed := getFormAllowedIDs()
+
+	return func(c *fiber.Ctx) error {
+		formID := c.Params("formId")
+		if !formIDPattern.MatchString(formID) || (len(allowed) > 0 && !allowed[formID]) {
+			return sendError(c, 404, "FORM_NOT_FOUND", "Form not found", formID)
+		}
+		projectID := c.Query("projectId")
+		if projectID != "" {
+			if err := db.First(&Project{}, "id = ?", projectID).Error; err != nil {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", projectID)
+			}
+		}
+		fields, err := parseFormFields(c)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().Unix()
+		submission := FormSubmission{
+			FormID:    formID,
+			ProjectID: projectID,
+			Fields:    make(map[string]string, len(fields)),
+			IP:        c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			Referrer:  c.Get(fiber.HeaderReferer),
+			CreatedAt: now,
+		}
+		redirect := fields["_redirect"]
+		if strings.TrimSpace(fields[honeypot]) != "" {
+			log.Printf("🍯 Dropped a submission to form %s from %s: honeypot filled in", formID, submission.IP)
+			return sendFormAccepted(c, submission, redirect)
+		}
+		for name, value := range fields {
+			if name != honeypot && !strings.HasPrefix(name, "_") {
+				submission.Fields[name] = value
+			}
+		}
+		if len(submission.Fields) == 0 {
+			return sendError(c, 400, "EMPTY_SUBMISSION", "The submission has no fields", nil)
+		}
+
+		if err := db.Create(&submission).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to save the submission", err.Error())
+		}
+		log.Printf("📨 Submission %d to form %s (%d field(s))", submission.ID, formID, len(submission.Fields))
+
+		if settings, _, err := resolveEmailSettings(db, submission.ProjectID); err == nil && settings.enabled() && len(settings.FormTo) > 0 {
+			go forwardFormSubmission(db, settings.FormTo, submission)
+		}
+		return sendFormAccepted(c, submission, redirect)
+	}
+}
+
+// ListForms returns every form that received submissions, most recently used first
+func ListForms(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		forms := []FormSummary{}
+		err := db.Model(&FormSubmission{}).
+			Select("form_id, COUNT(*) AS submissions, MAX(created_at) AS last_submitted_at").
+			Group("form_id").
+			Order("last_submitted_at DESC").
+			Scan(&forms).Error
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load forms", err.Error())
+		}
+		return c.JSON(APIResponse[FormList]{
+			Success: true,
+			Data:    FormList{Forms: forms},
+		})
+	}
+}
+
+// ListFormSubmissions returns the submissions of a form, newest first (?limit=,
+// ?before= to page)
+func ListFormSubmissions(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := db.Where("form_id = ?", c.Params("formId")).Order("id DESC")
+		if value := c.Query("before"); value != "" {
+			before, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return sendError(c, 400, "INVALID_CURSOR", "before must be a submission ID", value)
+			}
+			query = query.Where("id < ?", before)
+		}
+		limit := c.QueryInt("limit", 100)
+		if limit < 1 || limit > maxFormSubmissions {
+			limit = maxFormSubmissions
+		}
+
+		submissions := []FormSubmission{}
+		if err := query.Limit(limit).Find(&submissions).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load submissions", err.Error())
+		}
+		list := FormSubmissionList{Submissions: submissions}
+		if len(submissions) == limit {
+			list.NextBefore = submissions[len(submissions)-1].ID
+		}
+		return c.JSON(APIResponse[FormSubmissionList]{
+			Success: true,
+			Data:    list,
+		})
+	}
+}
+
+// ExportFormSubmissions downloads every submission of a form as CSV or JSON
+// (?format=csv|json). The CSV has a column for each field any submission has.
+func ExportFormSubmissions(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		format := c.Query("format", "csv")
+		if format != "csv" && format != "json" {
+			return sendError(c, 400, "INVALID_FORMAT", "format must be csv or json", nil)
+		}
+		formID := c.Params("formId")
+		var submissions []FormSubmission
+		if err := db.Where("form_id = ?", formID).Order("id").Find(&submissions).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load submissions", err.Error())
+		}
+
+		stamp := time.Now().Format("20060102-150405")
+		log.Printf("📤 Exporting %d submission(s) of form %s as %s", len(submissions), formID, format)
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="form-%s-%s.%s"`, formID, stamp, format))
+		if format == "json" {
+			return c.JSON(submissions)
+		}
+
+		seen := make(map[string]bool)
+		var names []string
+		for _, submission := range submissions {
+			for name := range submission.Fields {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+		sort.Strings(names)
+
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(append([]string{"id", "submitted_at"}, append(names, "ip", "referrer")...))
+		for _, submission := range submissions {
+			row := []string{strconv.FormatUint(uint64(submission.ID), 10), time.Unix(submission.CreatedAt, 0).UTC().Format(time.RFC3339)}
+			for _, name := range names {
+				row = append(row, csvSafe(submission.Fields[name]))
+			}
+			w.Write(append(row, submission.IP, csvSafe(submission.Referrer)))
+		}
+		w.Flush()
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		return c.Send(buf.Bytes())
+	}
+}
+
+// csvSafe keeps spreadsheets from running a visitor's value as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// DeleteFormSubmission removes a submission, e.g. when the visitor asks to be forgotten
+func DeleteFormSubmission(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return sendError(c, 404, "SUBMISSION_NOT_FOUND", "Submission not found", c.Params("id"))
+		}
+		var submission FormSubmission
+		if err := db.Limit(1).Find(&submission, "id = ? AND form_id = ?", id, c.Params("formId")).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load submission", err.Error())
+		}
+		if submission.ID == 0 {
+			return sendError(c, 404, "SUBMISSION_NOT_FOUND", "Submission not found", c.Params("id"))
+		}
+		if err := db.Delete(&FormSubmission{}, submission.ID).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete submission", err.Error())
+		}
+
+		// The audit event keeps the fact, not the personal data of the submission
+		recordAudit(db, c, AuditSubmissionDelete, c.Params("id"), nil, nil, submission.FormID)
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: c.Params("id"), Deleted: true},
+		})
+	}
+}
+
+// formEmail renders a submission as an email. A valid email field becomes the
+// Reply-To, so answering the email answers the visitor.
+func formEmail(to []string, submission FormSubmission) EmailMessage {
+	names := make([]string, 0, len(submission.Fields))
+	for name := range submission.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msg := EmailMessage{
+		To:      to,
+		Subject: "New submission to form " + submission.FormID,
+		Date:    time.Unix(submission.CreatedAt, 0),
+	}
+	for _, name := range []string{"email", "Email", "e-mail"} {
+		if address, err := mail.ParseAddress(submission.Fields[name]); err == nil {
+			msg.ReplyTo = address.String()
+			break
+		}
+	}
+
+	var text strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&text, "%s: %s\n", name, strings.ReplaceAll(submission.Fields[name], "\n", "\n  "))
+	}
+	fmt.Fprintf(&text, "\n--\nSubmission %d", submission.ID)
+	if submission.Referrer != "" {
+		fmt.Fprintf(&text, ", sent from %s", submission.Referrer)
+	}
+	text.WriteString("\n")
+	msg.Text = text.String()
+	return msg
+}
+
+// forwardFormSubmission emails a submission to the form recipients of its project; a
+// failure is logged and leaves the submission stored
+func forwardFormSubmission(db *gorm.DB, to []string, submission FormSubmission) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := sendEmail(ctx, db, submission.ProjectID, formEmail(to, submission)); err != nil {
+		log.Printf("⚠️ Failed to email submission %d of form %s: %v", submission.ID, submission.FormID, err)
+		return
+	}
+	db.Model(&FormSubmission{}).Where("id = ?", submission.ID).Update("forwarded_at", time.Now().Unix())
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	gitAuthorName  = "Site Editor AI"
+	gitAuthorEmail = "ai@site-editor.local"
+	gitTimeout     = 30 * time.Second
+)
+
+var gitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// GitCommit is a single entry of the workspace history
+type GitCommit struct {
+	SHA     string `json:"sha"`
+	Author  string `json:"author"`
+	Email   string `json:"email"`
+	Date    int64  `json:"date"`
+	Subject string `json:"subject"`
+}
+
+// GitFileStat is the line counts of one file in a diff
+type GitFileStat struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// isGitAutoCommitEnabled returns true if changes are committed after each command (git.autoCommit, AI_GIT_AUTOCOMMIT)
+func isGitAutoCommitEnabled() bool {
+	return appConfig.Git.AutoCommit
+}
+
+// runGit runs a git command in dir and returns its stdout
+func runGit(dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	// Identity is passed explicitly so commits work without a global git config
+	fullArgs := append([]string{"-c", "user.name=" + gitAuthorName, "-c", "user.email=" + gitAuthorEmail}, args...)
+	cmd := exec.CommandContext(ctx, "git", fullArgs...)
+	cmd.Dir = dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// isGitRepo reports whether dir is inside a git work tree
+func isGitRepo(dir string) bool {
+	out, err := runGit(dir, "rev-parse", "--is-inside-work-tree")
+	return err == nil && strings.TrimSpace(out) == "true"
+}
+
+// gitCommitAll stages every change in dir and commits it, returning the new SHA
+// Returns an empty SHA when there is nothing to commit
+func gitCommitAll(dir, message string) (string, error) {
+	if _, err := runGit(dir, "add", "-A"); err != nil {
+		return "", err
+	}
+
+	status, err := runGit(dir, "status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(status) == "" {
+		return "", nil
+	}
+
+	if _, err := runGit(dir, "commit", "-q", "-m", message); err != nil {
+		return "", err
+	}
+
+	sha, err := runGit(dir, "rev-parse", "HEAD")
+	return strings.TrimSpace(sha), err
+}
+
+// parseNumstat reads the output of git diff --numstat; binary files count 0 lines
+func parseNumstat(out string) []GitFileStat {
+	files := []GitFileStat{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		added, _ := strconv.Atoi(fields[0])
+		removed, _ := strconv.Atoi(fields[1])
+		files = append(files, GitFileStat{Path: fields[2], Added: added, Removed: removed})
+	}
+	return files
+}
+
+// commitCommandChanges commits the workspace after a successful AI command when
+// enabled. Commands on a branch of their own are always committed to it.
+func commitCommandChanges(command *AICommand, dir string) string {
+	if command.Branch == "" && (!isGitAutoCommitEnabled() || !isGitRepo(dir)) {
+		return ""
+	}
+
+	message := fmt.Sprintf("AI: %s\n\nCommand: %s\nScope: %s\nPage: %s", command.Prompt, command.ID, command.Scope, command.Page)
+	sha, err := gitCommitAll(dir, message)
+	if err != nil {
+		log.Printf("⚠️ Git commit failed [%s]: %v", command.ID, err)
+		return ""
+	}
+	if sha != "" {
+		log.Printf("📝 Committed changes for [%s]: %s", command.ID, sha)
+	}
+	return sha
+}
+
+// gitUnavailable responds with an error when the workspace is not a git repository
+func gitUnavailable(c *fiber.Ctx) error {
+	return sendError(c, 409, "NOT_A_GIT_REPOSITORY", "Workspace is not a git repository", nil)
+}
+
+// gitFailed responds with an error when a git command fails
+func gitFailed(c *fiber.Ctx, err error) error {
+	return sendError(c, 500, "GIT_ERROR", "Git command failed", err.Error())
+}
+
+// invalidSHA responds with an error when a commit SHA is malformed
+func invalidSHA(c *fiber.Ctx) error {
+	return sendError(c, 400, "INVALID_SHA", "Invalid commit SHA", nil)
+}
+
+// GetGitLog returns the recent commit history of the workspace (?limit=)
+func GetGitLog(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		dir, err := requestWorkspace(c, db, c.Query("projectId"))
+		if dir == "" {
+			return err
+		}
+		if !isGitRepo(dir) {
+			return gitUnavailable(c)
+		}
+
+		limit := c.QueryInt("limit", 50)
+		if limit <= 0 || limit > 500 {
+			limit = 50
+		}
+
+		out, err := runGit(dir, "log", fmt.Sprintf("-n%d", limit), "--format=%H%x1f%an%x1f%ae%x1f%at%x1f%s")
+		if err != nil {
+			// An empty repository has no HEAD yet
+			if strings.Contains(err.Error(), "does not have any commits") {
+				out = ""
+			} else {
+				return gitFailed(c, err)
+			}
+		}
+
+		commits := []GitCommit{}
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			fields := strings.Split(line, "\x1f")
+			if len(fields) != 5 {
+				continue
+			}
+			date, _ := strconv.ParseInt(fields[3], 10, 64)
+			commits = append(commits, GitCommit{
+				SHA:     fields[0],
+				Author:  fields[1],
+				Email:   fields[2],
+				Date:    date,
+				Subject: fields[4],
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"commits": commits,
+			},
+		})
+	}
+}
+
+// GetGitDiff returns the patch introduced by a commit
+func GetGitDiff(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sha := c.Params("sha")
+		if !gitSHAPattern.MatchString(sha) {
+			return invalidSHA(c)
+		}
+
+		dir, err := requestWorkspace(c, db, c.Query("projectId"))
+		if dir == "" {
+			return err
+		}
+		if !isGitRepo(dir) {
+			return gitUnavailable(c)
+		}
+
+		patch, err := runGit(dir, "show", "--format=", "--patch", sha)
+		if err != nil {
+			return gitFailed(c, err)
+		}
+		stat, err := runGit(dir, "show", "--format=", "--numstat", sha)
+		if err != nil {
+			return gitFailed(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"sha":   sha,
+				"files": parseNumstat(stat),
+				"diff":  patch,
+			},
+		})
+	}
+}
+
+// RevertGitCommit creates a new commit undoing the given commit
+func RevertGitCommit(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sha := c.Params("sha")
+		if !gitSHAPattern.MatchString(sha) {
+			return invalidSHA(c)
+		}
+
+		dir, err := requestWorkspace(c, db, c.Query("projectId"))
+		if dir == "" {
+			return err
+		}
+		if !isGitRepo(dir) {
+			return gitUnavailable(c)
+		}
+
+		// Merges of command branches are reverted against the branch they were merged into
+		args := []string{"revert", "--no-edit"}
+		if parents, err := runGit(dir, "rev-list", "--parents", "-n1", sha); err == nil && len(strings.Fields(parents)) > 2 {
+			args = append(args, "-m", "1")
+		}
+		if _, err := runGit(dir, append(args, sha)...); err != nil {
+			// Leave the work tree clean if the revert conflicted
+			runGit(dir, "revert", "--abort")
+			return sendError(c, 409, "REVERT_FAILED", "Could not revert commit cleanly", err.Error())
+		}
+
+		head, err := runGit(dir, "rev-parse", "HEAD")
+		if err != nil {
+			return gitFailed(c, err)
+		}
+
+		log.Printf("↩️ Reverted workspace commit %s", sha)
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"reverted": sha,
+				"sha":      strings.TrimSpace(head),
+			},
+		})
+	}
+}
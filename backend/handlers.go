@@ -1,6 +1,12 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,40 +14,318 @@ import (
 )
 
 type ContentRequest struct {
-	Content         string `json:"content"`          // The edited content
-	OriginalContent string `json:"original_content"` // Original HTML content (sent on first edit)
+	Content         string `json:"content"`                                      // The edited content
+	OriginalContent string `json:"original_content"`                             // Original HTML content (sent on first edit)
+	Page            string `json:"page,omitempty" validate:"omitempty,pagename"` // Optional page namespace (derived from the ID if omitted)
+	Version         int64  `json:"version,omitempty" validate:"min=0"`           // Version the edit is based on; a mismatch is rejected with 409
+	LockToken       string `json:"lock_token,omitempty" validate:"max=128"`      // Token of the caller's content lock, if any
+	Locale          string `json:"locale,omitempty" validate:"omitempty,locale"` // Translation to save; ?locale= or the default locale if omitted
+
+	Type   string          `json:"type,omitempty" validate:"omitempty,contenttype"` // Content type; kept when omitted, richtext for new blocks
+	Schema json.RawMessage `json:"schema,omitempty"`                                // JSON Schema for json blocks; null removes it
+}
+
+// BulkContentItem is a single content block in a bulk save request
+type BulkContentItem struct {
+	ID string `json:"id" validate:"required,contentid"`
+	ContentRequest
+}
+
+// BulkContentRequest represents a request to save many content blocks at once
+type BulkContentRequest struct {
+	Items []BulkContentItem `json:"items" validate:"required,dive"`
+}
+
+// maxBulkItems caps the number of blocks fetched or saved in one round trip
+const maxBulkItems = 500
+
+// Content states selectable via ?state= on content reads
+const (
+	ContentStateDraft     = "draft"
+	ContentStatePublished = "published"
+)
+
+// ContentConflictError is returned when an edit is based on an outdated version
+type ContentConflictError struct {
+	Current Content
+}
+
+func (e *ContentConflictError) Error() string {
+	return "content " + e.Current.ID + " was modified by someone else"
+}
+
+// ContentLockedError is returned when another editor holds the content lock
+type ContentLockedError struct {
+	Lock *ContentLock
+}
+
+func (e *ContentLockedError) Error() string {
+	return "content " + e.Lock.ContentID + " is locked by " + e.Lock.Holder
+}
+
+// ContentTrashedError is returned when an edit targets a block in the trash
+type ContentTrashedError struct {
+	ID string
+}
+
+func (e *ContentTrashedError) Error() string {
+	return "content " + e.ID + " is in the trash"
+}
+
+// draftContent returns the edited content if exists, otherwise original
+func draftContent(content Content) string {
+	if content.IsEdited {
+		return content.EditedContent
+	}
+	return content.OriginalContent
+}
+
+// liveContent returns what the live site shows: published content if any, otherwise original
+func liveContent(content Content) string {
+	if content.IsPublished {
+		return content.PublishedContent
+	}
+	return content.OriginalContent
+}
+
+// ContentResponse is the JSON representation of a content block
+type ContentResponse struct {
+	ID               string `json:"id"`
+	Locale           string `json:"locale"`
+	Page             string `json:"page"`
+	Type             string `json:"type"`
+	Content          string `json:"content"` // Follows ?state= (draft by default)
+	OriginalContent  string `json:"original_content"`
+	EditedContent    string `json:"edited_content"`
+	IsEdited         bool   `json:"is_edited"`
+	PublishedContent string `json:"published_content"`
+	IsPublished      bool   `json:"is_published"`
+	PublishedAt      int64  `json:"published_at"`
+	Version          int64  `json:"version"` // 0 for blocks that have never been saved
+	HasUnpublished   bool   `json:"has_unpublished"`
+	UpdatedAt        int64  `json:"updated_at"`
+
+	Fallback  bool   `json:"fallback,omitempty"`  // Served from another locale of the ?locale= chain
+	Rendered  string `json:"rendered,omitempty"`  // html when ?render=html turned Markdown into HTML
+	Sanitized *bool  `json:"sanitized,omitempty"` // Whether the sanitizer changed a saved block
+}
+
+// ContentListResponse is a list of content blocks; next_cursor is set when a
+// listing has more pages
+type ContentListResponse struct {
+	Items      []ContentResponse `json:"items"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// ContentBulkSaveResponse is the response of POST /api/content/bulk
+type ContentBulkSaveResponse struct {
+	Items []ContentResponse `json:"items"`
+	Saved int               `json:"saved"`
+}
+
+// contentResponse builds the JSON representation of a stored content block.
+// The "content" field follows the requested state (draft by default).
+func contentResponse(content Content, state string) ContentResponse {
+	displayContent := draftContent(content)
+	if state == ContentStatePublished {
+		displayContent = liveContent(content)
+	}
+
+	return ContentResponse{
+		ID:               content.ID,
+		Locale:           content.Locale,
+		Page:             content.Page,
+		Type:             content.Type,
+		Content:          displayContent,
+		OriginalContent:  content.OriginalContent,
+		EditedContent:    content.EditedContent,
+		IsEdited:         content.IsEdited,
+		PublishedContent: content.PublishedContent,
+		IsPublished:      content.IsPublished,
+		PublishedAt:      content.PublishedAt,
+		Version:          content.Version,
+		HasUnpublished:   content.IsEdited && (!content.IsPublished || content.EditedContent != content.PublishedContent),
+		UpdatedAt:        content.UpdatedAt,
+	}
+}
+
+// emptyContentResponse is returned for content IDs that have never been saved
+func emptyContentResponse(id string) ContentResponse {
+	return ContentResponse{ID: id}
+}
+
+// saveContent applies an edit to a content block in req.Locale, creating the block or
+// translation on first edit. Edits based on a stale version, blocked by another
+// editor's lock or not matching the block's type are rejected. sanitized reports
+// whether the sanitizer changed the submitted content.
+func saveContent(db *gorm.DB, id string, req ContentRequest) (content Content, sanitized bool, err error) {
+	if lock, locked := lockHeldByOther(id, req.LockToken); locked {
+		return Content{}, false, &ContentLockedError{Lock: lock}
+	}
+	locale := req.Locale
+	if locale == "" {
+		locale = getDefaultLocale()
+	}
+
+	result := db.Unscoped().Limit(1).Find(&content, "id = ? AND locale = ?", id, locale)
+	if result.Error != nil {
+		return content, false, result.Error
+	}
+	exists := content.ID != ""
+	if content.DeletedAt.Valid {
+		return Content{}, false, &ContentTrashedError{ID: id}
+	}
+
+	if exists && req.Version != 0 && req.Version != content.Version {
+		return content, false, &ContentConflictError{Current: content}
+	}
+
+	// A new translation takes the type, schema and page of the block in the default locale
+	if !exists && locale != getDefaultLocale() {
+		var source Content
+		if err := db.Limit(1).Find(&source, "id = ? AND locale = ?", id, getDefaultLocale()).Error; err != nil {
+			return content, false, err
+		}
+		content.Type, content.Schema, content.Page = source.Type, source.Schema, source.Page
+	}
+
+	contentType := content.Type
+	if req.Type != "" {
+		contentType = req.Type
+	} else if contentType == "" {
+		contentType = ContentTypeRichText
+	}
+	sanitized = sanitizeRequest(&req, contentType)
+
+	if !exists {
+		// First time - create new record with original content
+		content = Content{
+			ID:              id,
+			Locale:          locale,
+			Page:            content.Page,
+			Type:            contentType,
+			Schema:          content.Schema,
+			OriginalContent: req.OriginalContent,
+			EditedContent:   req.Content,
+			IsEdited:        true,
+			Version:         1,
+			UpdatedAt:       time.Now().Unix(),
+		}
+		if req.Page != "" {
+			content.Page = req.Page
+		}
+	} else {
+		// Update existing - only update edited content
+		content.Type = contentType
+		content.EditedContent = req.Content
+		content.IsEdited = true
+		content.UpdatedAt = time.Now().Unix()
+
+		// Set original content if provided and not already set
+		if req.OriginalContent != "" && content.OriginalContent == "" {
+			content.OriginalContent = req.OriginalContent
+		}
+		if req.Page != "" {
+			content.Page = req.Page
+		}
+	}
+
+	// A schema is replaced when sent and removed with "schema": null
+	if len(req.Schema) > 0 {
+		content.Schema = string(req.Schema)
+		if content.Schema == "null" {
+			content.Schema = ""
+		}
+	}
+	if problems := validateContent(db, content); len(problems) > 0 {
+		return content, sanitized, &ContentValidationError{ID: id, Type: contentType, Problems: problems}
+	}
+
+	if content.Page == "" {
+		content.Page = pageFromContentID(id)
+	}
+	if err := touchPage(db, content.Page); err != nil {
+		return content, sanitized, err
+	}
+
+	if !exists {
+		err := db.Create(&content).Error
+		return content, sanitized, err
+	}
+
+	// Only write if nobody saved in between, so concurrent edits cannot overwrite each other
+	previous := content.Version
+	content.Version++
+	result = db.Model(&Content{}).Where("id = ? AND locale = ? AND version = ?", id, locale, previous).Select("*").Updates(&content)
+	if result.Error != nil {
+		return content, sanitized, result.Error
+	}
+	if result.RowsAffected == 0 {
+		var current Content
+		if err := db.First(&current, "id = ? AND locale = ?", id, locale).Error; err != nil {
+			return content, sanitized, err
+		}
+		return current, sanitized, &ContentConflictError{Current: current}
+	}
+	return content, sanitized, nil
+}
+
+// saveErrorResponse maps a saveContent error to an HTTP response
+func saveErrorResponse(c *fiber.Ctx, err error) error {
+	var conflict *ContentConflictError
+	if errors.As(err, &conflict) {
+		return sendError(c, 409, "CONTENT_CONFLICT", "Content was modified by someone else", fiber.Map{
+			"id":      conflict.Current.ID,
+			"current": contentResponse(conflict.Current, ContentStateDraft),
+		})
+	}
+
+	var locked *ContentLockedError
+	if errors.As(err, &locked) {
+		return contentLockedError(c, 423, locked.Lock)
+	}
+
+	var invalid *ContentValidationError
+	if errors.As(err, &invalid) {
+		return sendError(c, 422, "INVALID_CONTENT", "Content does not match its type", fiber.Map{
+			"id":       invalid.ID,
+			"type":     invalid.Type,
+			"problems": invalid.Problems,
+		})
+	}
+
+	var trashed *ContentTrashedError
+	if errors.As(err, &trashed) {
+		return sendError(c, 410, "CONTENT_TRASHED", "Content is in the trash; restore it before editing", fiber.Map{
+			"id": trashed.ID,
+		})
+	}
+
+	return sendError(c, 500, "DATABASE_ERROR", "Failed to save content", nil)
 }
 
 func GetContent(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
-		var content Content
-		result := db.First(&content, "id = ?", id)
+		render := c.Query("render")
+		if !validRender(render) {
+			return sendError(c, 400, "INVALID_RENDER", "render must be html", nil)
+		}
 
-		if result.Error != nil {
-			// Return empty/not found
-			return c.JSON(fiber.Map{
-				"id":        id,
-				"content":   "",
-				"is_edited": false,
-			})
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
 		}
 
-		// Return edited content if exists, otherwise original
-		displayContent := content.EditedContent
-		if !content.IsEdited {
-			displayContent = content.OriginalContent
+		content, err := findLocalizedContent(db, id, localeFallbacks(locale))
+		if err != nil {
+			// Return empty/not found
+			return sendConditional(c, emptyContentResponse(id), 0)
 		}
 
-		return c.JSON(fiber.Map{
-			"id":               content.ID,
-			"content":          displayContent,
-			"original_content": content.OriginalContent,
-			"edited_content":   content.EditedContent,
-			"is_edited":        content.IsEdited,
-			"updated_at":       content.UpdatedAt,
-		})
+		response := localizedResponse(content, c.Query("state", ContentStateDraft), locale)
+		return sendConditional(c, renderContentResponse(response, content, render), contentLastModified(content))
 	}
 }
 
@@ -49,46 +333,303 @@ func PutContent(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
-		var req ContentRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(400).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
+		req := *validatedBody[ContentRequest](c)
+		locale, err := bodyLocale(c, req.Locale)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+		req.Locale = locale
+		if !canEditContent(c, db, id, req.Page) {
+			return forbidden(c, RoleEditor)
+		}
+
+		var before Content
+		db.Limit(1).Find(&before, "id = ? AND locale = ?", id, locale)
+
+		content, sanitized, err := saveContent(db, id, req)
+		if err != nil {
+			return saveErrorResponse(c, err)
+		}
+
+		recordAudit(db, c, AuditContentUpdate, id, draftContent(before), draftContent(content), localeDetail(content, fmt.Sprintf("version %d", content.Version)))
+		broadcastContent(ContentMsgUpdated, content, c.Get("X-Client-ID"))
+		response := contentResponse(content, ContentStateDraft)
+		response.Sanitized = &sanitized
+		return c.JSON(response)
+	}
+}
+
+// ResetContent discards the draft of a content block in ?locale= so it shows its
+// original content again. Like a save it honours content locks (?lock_token=) and,
+// when ?version= is given, rejects a reset based on an outdated version.
+func ResetContent(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+
+		if lock, locked := lockHeldByOther(id, c.Query("lock_token")); locked {
+			return contentLockedError(c, 423, lock)
 		}
 
 		var content Content
-		result := db.First(&content, "id = ?", id)
+		if err := db.First(&content, "id = ? AND locale = ?", id, locale).Error; err != nil {
+			return sendError(c, 404, "CONTENT_NOT_FOUND", "Content not found", nil)
+		}
+		if version := c.QueryInt("version"); version != 0 && int64(version) != content.Version {
+			return saveErrorResponse(c, &ContentConflictError{Current: content})
+		}
+		if !content.IsEdited {
+			return c.JSON(contentResponse(content, ContentStateDraft))
+		}
+
+		before := draftContent(content)
+		previous := content.Version
+		content.EditedContent = ""
+		content.IsEdited = false
+		content.Version++
+		content.UpdatedAt = time.Now().Unix()
 
+		result := db.Model(&Content{}).Where("id = ? AND locale = ? AND version = ?", id, locale, previous).Updates(map[string]interface{}{
+			"edited_content": "",
+			"is_edited":      false,
+			"version":        content.Version,
+			"updated_at":     content.UpdatedAt,
+		})
 		if result.Error != nil {
-			// First time - create new record with original content
-			content = Content{
-				ID:              id,
-				OriginalContent: req.OriginalContent,
-				EditedContent:   req.Content,
-				IsEdited:        true,
-				UpdatedAt:       time.Now().Unix(),
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to reset content", nil)
+		}
+		if result.RowsAffected == 0 {
+			var current Content
+			if err := db.First(&current, "id = ? AND locale = ?", id, locale).Error; err != nil {
+				return sendError(c, 404, "CONTENT_NOT_FOUND", "Content not found", nil)
+			}
+			return saveErrorResponse(c, &ContentConflictError{Current: current})
+		}
+
+		recordAudit(db, c, AuditContentReset, id, before, content.OriginalContent, localeDetail(content, fmt.Sprintf("version %d", content.Version)))
+		broadcastContent(ContentMsgUpdated, content, c.Get("X-Client-ID"))
+		return c.JSON(contentResponse(content, ContentStateDraft))
+	}
+}
+
+// GetContentBulk returns many content blocks in one request (?ids=a,b,c). Without
+// ids it lists every block instead (see listContent).
+func GetContentBulk(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var ids []string
+		for _, id := range strings.Split(c.Query("ids"), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+
+		if len(ids) == 0 {
+			return listContent(c, db)
+		}
+		if len(ids) > maxBulkItems {
+			return sendError(c, 400, "TOO_MANY_IDS", "Too many ids requested", nil)
+		}
+
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+		chain := localeFallbacks(locale)
+
+		var contents []Content
+		if err := db.Where("id IN ? AND locale IN ?", ids, chain).Find(&contents).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load content", nil)
+		}
+
+		found := make(map[string]Content, len(contents))
+		for _, content := range pickLocalized(contents, chain) {
+			found[content.ID] = content
+		}
+
+		state := c.Query("state", ContentStateDraft)
+		render := c.Query("render")
+		if !validRender(render) {
+			return sendError(c, 400, "INVALID_RENDER", "render must be html", nil)
+		}
+
+		// Keep the requested order and include empty entries for unknown IDs
+		items := make([]ContentResponse, 0, len(ids))
+		for _, id := range ids {
+			if content, ok := found[id]; ok {
+				items = append(items, renderContentResponse(localizedResponse(content, state, locale), content, render))
+			} else {
+				items = append(items, emptyContentResponse(id))
+			}
+		}
+
+		// No Last-Modified: a block that was removed would not move it forward
+		return sendConditional(c, ContentListResponse{Items: items}, 0)
+	}
+}
+
+// defaultContentPageSize is the number of blocks listed per page when ?limit= is omitted
+const defaultContentPageSize = 100
+
+// contentCursor is the position after the last block of a listing page
+type contentCursor struct {
+	UpdatedAt int64  `json:"u"`
+	ID        string `json:"i"`
+	Locale    string `json:"l,omitempty"`
+}
+
+func encodeContentCursor(content Content) string {
+	data, _ := json.Marshal(contentCursor{UpdatedAt: content.UpdatedAt, ID: content.ID, Locale: content.Locale})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeContentCursor(value string) (contentCursor, error) {
+	var cursor contentCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err == nil && cursor.ID == "" {
+		err = errors.New("empty cursor")
+	}
+	return cursor, err
+}
+
+// listContent enumerates content blocks, least recently updated first, so a client can
+// page through everything or sync what changed. ?edited=true|false, ?page= and
+// ?updatedAfter= (Unix seconds) filter, as does ?locale=, which lists only that
+// translation (no fallback). ?limit= sets the page size and ?cursor= takes the
+// next_cursor of the previous page.
+func listContent(c *fiber.Ctx, db *gorm.DB) error {
+	query := db.Model(&Content{})
+	if value := c.Query("locale"); value != "" {
+		locale, err := normalizeLocale(value)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+		query = query.Where("locale = ?", locale)
+	}
+	if value := c.Query("edited"); value != "" {
+		edited, err := strconv.ParseBool(value)
+		if err != nil {
+			return sendError(c, 400, "INVALID_FILTER", "edited must be true or false", nil)
+		}
+		query = query.Where("is_edited = ?", edited)
+	}
+	if page := c.Query("page"); page != "" {
+		query = query.Where("page = ?", page)
+	}
+	if value := c.Query("updatedAfter"); value != "" {
+		after, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return sendError(c, 400, "INVALID_FILTER", "updatedAfter must be a Unix timestamp in seconds", nil)
+		}
+		query = query.Where("updated_at > ?", after)
+	}
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeContentCursor(value)
+		if err != nil {
+			return sendError(c, 400, "INVALID_CURSOR", "Invalid cursor", nil)
+		}
+		query = query.Where("updated_at > ? OR (updated_at = ? AND (id > ? OR (id = ? AND locale > ?)))",
+			cursor.UpdatedAt, cursor.UpdatedAt, cursor.ID, cursor.ID, cursor.Locale)
+	}
+
+	limit := c.QueryInt("limit", defaultContentPageSize)
+	if limit < 1 || limit > maxBulkItems {
+		return sendError(c, 400, "INVALID_LIMIT", fmt.Sprintf("limit must be between 1 and %d", maxBulkItems), nil)
+	}
+
+	state := c.Query("state", ContentStateDraft)
+	render := c.Query("render")
+	if !validRender(render) {
+		return sendError(c, 400, "INVALID_RENDER", "render must be html", nil)
+	}
+
+	// One extra row tells whether there is a next page
+	var contents []Content
+	if err := query.Order("updated_at ASC, id ASC, locale ASC").Limit(limit + 1).Find(&contents).Error; err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to load content", nil)
+	}
+
+	var response ContentListResponse
+	if len(contents) > limit {
+		contents = contents[:limit]
+		response.NextCursor = encodeContentCursor(contents[limit-1])
+	}
+	response.Items = make([]ContentResponse, 0, len(contents))
+	for _, content := range contents {
+		response.Items = append(response.Items, renderContentResponse(contentResponse(content, state), content, render))
+	}
+	return c.JSON(response)
+}
+
+// PostContentBulk saves many content blocks in a single transaction
+func PostContentBulk(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[BulkContentRequest](c)
+		if len(req.Items) == 0 {
+			return sendError(c, 400, "MISSING_ITEMS", "At least one item is required", nil)
+		}
+		if len(req.Items) > maxBulkItems {
+			return sendError(c, 400, "TOO_MANY_ITEMS", "Too many items in request", nil)
+		}
+		for i, item := range req.Items {
+			locale, err := bodyLocale(c, item.Locale)
+			if err != nil {
+				return invalidLocale(c, err)
 			}
-		} else {
-			// Update existing - only update edited content
-			content.EditedContent = req.Content
-			content.IsEdited = true
-			content.UpdatedAt = time.Now().Unix()
-
-			// Set original content if provided and not already set
-			if req.OriginalContent != "" && content.OriginalContent == "" {
-				content.OriginalContent = req.OriginalContent
+			req.Items[i].Locale = locale
+			if !canEditContent(c, db, item.ID, item.Page) {
+				return forbidden(c, RoleEditor)
 			}
 		}
 
-		db.Save(&content)
+		ids := make([]string, 0, len(req.Items))
+		for _, item := range req.Items {
+			ids = append(ids, item.ID)
+		}
+		var previous []Content
+		db.Where("id IN ?", ids).Find(&previous)
+		before := make(map[string]string, len(previous))
+		for _, content := range previous {
+			before[content.ID+"/"+content.Locale] = draftContent(content)
+		}
+
+		saved := make([]Content, 0, len(req.Items))
+		sanitized := make(map[string]bool, len(req.Items))
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, item := range req.Items {
+				content, changed, err := saveContent(tx, item.ID, item.ContentRequest)
+				if err != nil {
+					return err
+				}
+				sanitized[item.ID+"/"+item.Locale] = changed
+				saved = append(saved, content)
+			}
+			return nil
+		})
+		if err != nil {
+			return saveErrorResponse(c, err)
+		}
+
+		// Notify subscribers only once the transaction has committed
+		items := make([]ContentResponse, 0, len(saved))
+		for _, content := range saved {
+			key := content.ID + "/" + content.Locale
+			recordAudit(db, c, AuditContentUpdate, content.ID, before[key], draftContent(content), localeDetail(content, fmt.Sprintf("version %d (bulk)", content.Version)))
+			broadcastContent(ContentMsgUpdated, content, c.Get("X-Client-ID"))
+			item := contentResponse(content, ContentStateDraft)
+			changed := sanitized[key]
+			item.Sanitized = &changed
+			items = append(items, item)
+		}
 
-		return c.JSON(fiber.Map{
-			"id":               content.ID,
-			"content":          content.EditedContent,
-			"original_content": content.OriginalContent,
-			"edited_content":   content.EditedContent,
-			"is_edited":        content.IsEdited,
-			"updated_at":       content.UpdatedAt,
+		return c.JSON(ContentBulkSaveResponse{
+			Items: items,
+			Saved: len(items),
 		})
 	}
 }
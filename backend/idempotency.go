@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+var (
+	// idempotencyInFlight holds the keys of submissions still being processed
+	idempotencyInFlight = make(map[string]bool)
+	idempotencyMu       sync.Mutex
+)
+
+// getIdempotencyWindow returns how long an Idempotency-Key is remembered from IDEMPOTENCY_WINDOW
+// Falls back to 24 hours
+func getIdempotencyWindow() time.Duration {
+	return getEnvDuration("IDEMPOTENCY_WINDOW", 24*time.Hour)
+}
+
+// idempotencyScope namespaces a key per caller so clients cannot collide with each other
+func idempotencyScope(c *fiber.Ctx, key string) string {
+	return rateLimitKey(c) + "|" + key
+}
+
+// requestHash fingerprints a request body to detect a key reused for a different command
+func requestHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// claimIdempotencyKey marks a key as being processed; false means another request holds it
+func claimIdempotencyKey(scoped string) bool {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+	if idempotencyInFlight[scoped] {
+		return false
+	}
+	idempotencyInFlight[scoped] = true
+	return true
+}
+
+func releaseIdempotencyKey(scoped string) {
+	idempotencyMu.Lock()
+	delete(idempotencyInFlight, scoped)
+	idempotencyMu.Unlock()
+}
+
+// findIdempotentCommand returns the command created for the key within the window, if any
+func findIdempotentCommand(db *gorm.DB, scoped string) (*AICommand, error) {
+	var command AICommand
+	since := time.Now().Add(-getIdempotencyWindow()).Unix()
+	err := db.Where("idempotency_key = ? AND created_at >= ?", scoped, since).Order("created_at DESC").First(&command).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+// idempotentReplay answers a repeated submission with the command created the first time
+func idempotentReplay(c *fiber.Ctx, command *AICommand, hash string) error {
+	if command.RequestHash != hash {
+		return sendError(c, 422, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was already used for a different request", "Original command: "+command.ID)
+	}
+
+	log.Printf("🔁 Idempotent replay of command [%s]", command.ID)
+	c.Set("Idempotent-Replayed", "true")
+	return c.JSON(APIResponse[QueuedCommand]{
+		Success: true,
+		Message: "Command already submitted",
+		Data: QueuedCommand{
+			CommandID:      command.ID,
+			ConversationID: command.ConversationID,
+			Status:         command.Status,
+			Replayed:       true,
+			Message:        "Connect to WebSocket to receive real-time updates",
+		}.withStream(c, command.UserID),
+	})
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm"
+)
+
+// Asset processing states
+const (
+	AssetProcessingPending    = "pending"
+	AssetProcessingProcessing = "processing"
+	AssetProcessingCompleted  = "completed"
+	AssetProcessingFailed     = "failed"
+	AssetProcessingSkipped    = "skipped" // Not an image we can resize
+)
+
+// AssetVariant is a resized and/or re-encoded copy of an image asset
+type AssetVariant struct {
+	ID          uint   `gorm:"primaryKey" json:"-"`
+	AssetID     string `gorm:"index" json:"-"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Format      string `json:"format"` // jpeg, png, webp, avif
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	StorageKey  string `json:"-"`
+	CreatedAt   int64  `json:"createdAt"`
+}
+
+// variantFormats maps output formats to their content type
+var variantFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+// externalEncoder converts a PNG file to a format the standard library cannot write
+type externalEncoder struct {
+	binary string
+	args   func(in, out string) []string
+}
+
+var externalEncoders = map[string]externalEncoder{
+	"webp": {binary: "cwebp", args: func(in, out string) []string { return []string{"-quiet", "-q", "80", in, "-o", out} }},
+	"avif": {binary: "avifenc", args: func(in, out string) []string { return []string{"-q", "60", in, out} }},
+}
+
+// assetJobs feeds asset IDs to the image workers
+var assetJobs = make(chan string, 100)
+
+// getVariantWidths returns the target widths, smallest first (assets.variantWidths, ASSET_VARIANT_WIDTHS)
+// Defaults to 320,640,1024,1920
+func getVariantWidths() []int {
+	return appConfig.Assets.VariantWidths
+}
+
+// getVariantFormats returns the extra formats (assets.variantFormats, ASSET_VARIANT_FORMATS)
+// Defaults to webp,avif; the original format is always generated
+func getVariantFormats() []string {
+	return appConfig.Assets.VariantFormats
+}
+
+// getMaxImagePixels returns the largest image, in pixels, that is decoded for resizing
+// (assets.maxPixels, ASSET_MAX_PIXELS)
+// Defaults to 50 megapixels
+func getMaxImagePixels() int64 {
+	return appConfig.Assets.MaxPixels
+}
+
+// validate checks the asset settings and sorts the variant widths
+func (a *AssetConfig) validate() []error {
+	var errs []error
+	switch a.Storage {
+	case "disk":
+		if a.Dir == "" {
+			errs = append(errs, errors.New("assets.dir must not be empty for disk storage"))
+		}
+	case "s3":
+	default:
+		errs = append(errs, fmt.Errorf("assets.storage must be disk or s3, got %q", a.Storage))
+	}
+	if a.MaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("assets.maxSize must be positive, got %d", a.MaxSize))
+	}
+	if a.MaxPixels <= 0 {
+		errs = append(errs, fmt.Errorf("assets.maxPixels must be positive, got %d", a.MaxPixels))
+	}
+	for _, w := range a.VariantWidths {
+		if w <= 0 {
+			errs = append(errs, fmt.Errorf("assets.variantWidths: invalid width %d", w))
+		}
+	}
+	sort.Ints(a.VariantWidths)
+	for _, format := range a.VariantFormats {
+		if variantFormats[format] == "" {
+			errs = append(errs, fmt.Errorf("assets.variantFormats: unknown format %q (use jpeg, png, webp or avif)", format))
+		}
+	}
+	if a.Workers <= 0 {
+		errs = append(errs, fmt.Errorf("assets.workers must be positive, got %d", a.Workers))
+	}
+	return errs
+}
+
+// checkImageSize rejects images whose declared size would take too much memory to decode
+func checkImageSize(width, height int) error {
+	if pixels := int64(width) * int64(height); pixels > getMaxImagePixels() {
+		return fmt.Errorf("image of %dx%d pixels is larger than ASSET_MAX_PIXELS (%d)", width, height, getMaxImagePixels())
+	}
+	return nil
+}
+
+// isResizableImage reports whether the asset can be decoded for resizing
+func isResizableImage(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// enqueueAssetProcessing schedules variant generation without blocking the upload
+func enqueueAssetProcessing(id string) {
+	select {
+	case assetJobs <- id:
+	default:
+		// Queue is full; the asset stays pending and is picked up on next startup
+		log.Printf("⚠️ Image queue full, asset %s left pending", id)
+	}
+}
+
+// StartAssetWorkers starts the image workers (assets.workers, ASSET_WORKERS, default 2) and
+// re-queues assets left unprocessed by a previous run (or uploaded before variants existed)
+func StartAssetWorkers(db *gorm.DB, store AssetStorage) {
+	workers := appConfig.Assets.Workers
+
+	for _, format := range getVariantFormats() {
+		if encoder, ok := externalEncoders[format]; ok {
+			if _, err := exec.LookPath(encoder.binary); err != nil {
+				log.Printf("⚠️ %s not found in PATH, %s variants will be skipped", encoder.binary, format)
+			}
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for id := range assetJobs {
+				if shuttingDown.Load() {
+					continue
+				}
+				activeRuns.Add(1)
+				processAsset(db, store, id)
+				activeRuns.Done()
+			}
+		}()
+	}
+
+	var pending []Asset
+	db.Where("processing_status IN ?", []string{"", AssetProcessingPending, AssetProcessingProcessing}).Find(&pending)
+	for _, asset := range pending {
+		enqueueAssetProcessing(asset.ID)
+	}
+}
+
+// processAsset generates the resized variants of one image asset
+func processAsset(db *gorm.DB, store AssetStorage, id string) {
+	var asset Asset
+	if err := db.First(&asset, "id = ?", id).Error; err != nil {
+		return
+	}
+	if !isResizableImage(asset.ContentType) {
+		db.Model(&asset).Update("processing_status", AssetProcessingSkipped)
+		return
+	}
+
+	db.Model(&asset).Update("processing_status", AssetProcessingProcessing)
+	start := time.Now()
+
+	variants, err := generateVariants(db, store, asset)
+	if err != nil {
+		log.Printf("❌ Failed to process asset %s: %v", asset.ID, err)
+		db.Model(&asset).Updates(map[string]interface{}{
+			"processing_status": AssetProcessingFailed,
+			"processing_error":  err.Error(),
+		})
+		return
+	}
+
+	db.Model(&asset).Updates(map[string]interface{}{
+		"processing_status": AssetProcessingCompleted,
+		"processing_error":  "",
+	})
+	log.Printf("✅ Generated %d variant(s) for asset %s in %s", variants, asset.ID, time.Since(start).Round(time.Millisecond))
+}
+
+// generateVariants writes every width/format combination and records it
+func generateVariants(db *gorm.DB, store AssetStorage, asset Asset) (int, error) {
+	ctx := context.Background()
+
+	r, err := store.Open(ctx, asset.StorageKey)
+	if err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return 0, err
+	}
+	// The header declares the size, so a small file can ask for gigabytes of pixels
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if err := checkImageSize(config.Width, config.Height); err != nil {
+		return 0, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	// Drop variants of a previous attempt
+	deleteAssetVariants(db, store, asset.ID)
+
+	bounds := src.Bounds()
+	var widths []int
+	for _, w := range getVariantWidths() {
+		if w < bounds.Dx() {
+			widths = append(widths, w)
+		}
+	}
+	widths = append(widths, bounds.Dx())
+
+	formats := append([]string{baseVariantFormat(asset.ContentType)}, getVariantFormats()...)
+
+	tmpDir, err := os.MkdirTemp("", "asset-"+asset.ID)
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	count := 0
+	seen := make(map[string]bool)
+	for _, width := range widths {
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		resized := src
+		if width != bounds.Dx() {
+			dst := image.NewRGBA(image.Rect(0, 0, width, height))
+			draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+			resized = dst
+		}
+
+		for _, format := range formats {
+			key := fmt.Sprintf("%s/w%d.%s", asset.ID, width, format)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			data, err := encodeVariant(ctx, resized, format, tmpDir)
+			if err == exec.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return count, fmt.Errorf("failed to encode %s at %dpx: %w", format, width, err)
+			}
+
+			if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), variantFormats[format]); err != nil {
+				return count, err
+			}
+			variant := AssetVariant{
+				AssetID:     asset.ID,
+				Width:       width,
+				Height:      height,
+				Format:      format,
+				ContentType: variantFormats[format],
+				Size:        int64(len(data)),
+				StorageKey:  key,
+				CreatedAt:   time.Now().Unix(),
+			}
+			if err := db.Create(&variant).Error; err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// encodeVariant encodes img in the given format. Formats without a Go encoder
+// are produced by an external tool; exec.ErrNotFound means it is not installed.
+func encodeVariant(ctx context.Context, img image.Image, format, tmpDir string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 82})
+		return buf.Bytes(), err
+	case "png":
+		err := png.Encode(&buf, img)
+		return buf.Bytes(), err
+	}
+
+	encoder, ok := externalEncoders[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+	if _, err := exec.LookPath(encoder.binary); err != nil {
+		return nil, exec.ErrNotFound
+	}
+
+	in, err := os.CreateTemp(tmpDir, "in-*.png")
+	if err != nil {
+		return nil, err
+	}
+	if err := png.Encode(in, img); err != nil {
+		in.Close()
+		return nil, err
+	}
+	in.Close()
+	out := filepath.Join(tmpDir, filepath.Base(in.Name())+"."+format)
+
+	runCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, encoder.binary, encoder.args(in.Name(), out)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", encoder.binary, err, strings.TrimSpace(string(output)))
+	}
+	return os.ReadFile(out)
+}
+
+// deleteAssetVariants removes the stored files and rows of an asset's variants
+func deleteAssetVariants(db *gorm.DB, store AssetStorage, assetID string) error {
+	var variants []AssetVariant
+	if err := db.Where("asset_id = ?", assetID).Find(&variants).Error; err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		if err := store.Delete(context.Background(), variant.StorageKey); err != nil {
+			return err
+		}
+	}
+	return db.Where("asset_id = ?", assetID).Delete(&AssetVariant{}).Error
+}
+
+// pickVariant chooses the smallest variant of format at least w pixels wide,
+// or the widest one when none is large enough; w == 0 picks the widest
+func pickVariant(variants []AssetVariant, format string, w int) *AssetVariant {
+	var best *AssetVariant
+	for i := range variants {
+		v := &variants[i]
+		if v.Format != format {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = v
+		case w > 0 && v.Width >= w && (best.Width < w || v.Width < best.Width):
+			best = v
+		case (w == 0 || best.Width < w) && v.Width > best.Width:
+			best = v
+		}
+	}
+	return best
+}
+
+// baseVariantFormat is the format variants keep when no other format is requested
+func baseVariantFormat(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "jpeg"
+	}
+	return "png"
+}
+
+// negotiateFormat resolves ?format=auto to the best format the client accepts
+func negotiateFormat(accept string, asset Asset) string {
+	available := make(map[string]bool)
+	for _, v := range asset.Variants {
+		available[v.Format] = true
+	}
+	for _, format := range []string{"avif", "webp"} {
+		if available[format] && strings.Contains(accept, variantFormats[format]) {
+			return format
+		}
+	}
+	return baseVariantFormat(asset.ContentType)
+}
+
+// openVariant opens the variant selected by w/format, falling back to the
+// original while variants are missing (still processing, or not an image)
+func openVariant(ctx context.Context, store AssetStorage, asset Asset, w int, format, accept string) (io.ReadCloser, string, int64, error) {
+	switch format {
+	case "auto":
+		format = negotiateFormat(accept, asset)
+	case "":
+		format = baseVariantFormat(asset.ContentType)
+	}
+
+	if variant := pickVariant(asset.Variants, format, w); variant != nil {
+		r, err := store.Open(ctx, variant.StorageKey)
+		return r, variant.ContentType, variant.Size, err
+	}
+	r, err := store.Open(ctx, asset.StorageKey)
+	return r, asset.ContentType, asset.Size, err
+}
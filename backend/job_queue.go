@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+)
+
+// Job queue: with AI_QUEUE=asynq, AI commands are not run by the instance that receives
+// them but enqueued as asynq tasks in the Redis of REDIS_URL. Workers, which may be
+// other instances with the CLI installed, take them by priority, start scheduled ones at
+// their runAt and retry failed ones. Streams follow a command from whichever worker runs
+// it, so API instances can set AI_QUEUE_WORKERS=0 and never run a command themselves.
+
+// commandQueue is the job queue of this instance; nil unless AI_QUEUE=asynq
+var commandQueue *jobQueue
+
+// commandTaskType is the asynq task that runs one AI command
+const commandTaskType = "command:run"
+
+// Priorities of commands; each has its own queue
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// priorityWeights is how often workers take from each queue: while all of them hold
+// jobs, six high priority commands start for every three normal and one low
+var priorityWeights = map[string]int{
+	PriorityHigh:   6,
+	PriorityNormal: 3,
+	PriorityLow:    1,
+}
+
+type jobQueue struct {
+	client    *asynq.Client
+	inspector *asynq.Inspector
+	server    *asynq.Server // nil when this instance does not run commands
+	prefix    string        // REDIS_PREFIX: prepended to the queue names
+}
+
+// commandTask is the payload of a command task
+type commandTask struct {
+	CommandID string `json:"commandId"`
+}
+
+// getQueueWorkers returns how many commands this instance runs from the job queue at
+// the same time (queue.workers, AI_QUEUE_WORKERS); 0 only enqueues
+// Falls back to 2
+func getQueueWorkers() int {
+	return appConfig.Queue.Workers
+}
+
+// getQueueMaxRetry returns how often a failed command is run again (queue.maxRetry, AI_QUEUE_MAX_RETRY)
+// Falls back to 2
+func getQueueMaxRetry() int {
+	return appConfig.Queue.MaxRetry
+}
+
+// validate checks the job queue settings
+func (q QueueConfig) validate() []error {
+	var errs []error
+	if q.Mode != "local" && q.Mode != "asynq" {
+		errs = append(errs, fmt.Errorf("queue.mode must be local or asynq, got %q", q.Mode))
+	}
+	if q.Workers < 0 {
+		errs = append(errs, fmt.Errorf("queue.workers must not be negative, got %d", q.Workers))
+	}
+	if q.MaxRetry < 0 {
+		errs = append(errs, fmt.Errorf("queue.maxRetry must not be negative, got %d", q.MaxRetry))
+	}
+	return errs
+}
+
+// StartJobQueue connects the job queue (AI_QUEUE) and starts the workers of this
+// instance. It must run after StartCluster, whose Redis connection it shares.
+func StartJobQueue(db *gorm.DB) error {
+	if appConfig.Queue.Mode == "local" {
+		return nil
+	}
+	if cluster == nil {
+		return errors.New("AI_QUEUE=asynq needs REDIS_URL")
+	}
+
+	q := &jobQueue{
+		client:    asynq.NewClientFromRedisClient(cluster.client),
+		inspector: asynq.NewInspectorFromRedisClient(cluster.client),
+		prefix:    cluster.prefix,
+	}
+	workers := getQueueWorkers()
+	if workers > 0 {
+		queues := make(map[string]int, len(priorityWeights))
+		for priority, weight := range priorityWeights {
+			queues[q.queue(priority)] = weight
+		}
+		q.server = asynq.NewServerFromRedisClient(cluster.client, asynq.Config{
+			Concurrency:     workers,
+			Queues:          queues,
+			ShutdownTimeout: 5 * time.Second, // Sessions are drained before the server is shut down
+			LogLevel:        asynq.WarnLevel,
+		})
+		mux := asynq.NewServeMux()
+		mux.HandleFunc(commandTaskType, handleCommandTask(db))
+		if err := q.server.Start(mux); err != nil {
+			return fmt.Errorf("failed to start the job queue workers: %w", err)
+		}
+	}
+
+	commandQueue = q
+	log.Printf("📬 Job queue: AI commands go through asynq, %d worker(s) on this instance", workers)
+	go requeueCommands(db)
+	return nil
+}
+
+// requeueCommands enqueues the queued commands whose task is missing, e.g. commands
+// created before the job queue was enabled or lost with the data of Redis. Commands
+// whose task still exists are left alone, so every instance can do this at startup.
+func requeueCommands(db *gorm.DB) {
+	var commands []AICommand
+	if err := db.Where("status = ?", "queued").Order("created_at").Find(&commands).Error; err != nil {
+		log.Printf("⚠️ Failed to load queued commands: %v", err)
+		return
+	}
+	dispatchCommands(db, commands)
+}
+
+// queue is the name of the queue of a priority
+func (q *jobQueue) queue(priority string) string {
+	if _, ok := priorityWeights[priority]; !ok {
+		priority = PriorityNormal
+	}
+	return q.prefix + "commands:" + priority
+}
+
+// enqueue hands a queued command to the workers. A command is enqueued at most once at
+// a time, so enqueueing it again while its task waits does nothing.
+func (q *jobQueue) enqueue(command *AICommand) error {
+	payload, err := json.Marshal(commandTask{CommandID: command.ID})
+	if err != nil {
+		return err
+	}
+	opts := []asynq.Option{
+		asynq.TaskID(command.ID),
+		asynq.Queue(q.queue(command.Priority)),
+		asynq.MaxRetry(getQueueMaxRetry()),
+		// The command enforces its own timeout; this only catches a hung worker
+		asynq.Timeout(getCommandTimeout() + 5*time.Minute),
+	}
+	if command.RunAt > time.Now().Unix() {
+		opts = append(opts, asynq.ProcessAt(time.Unix(command.RunAt, 0)))
+	}
+
+	_, err = q.client.Enqueue(asynq.NewTask(commandTaskType, payload), opts...)
+	if errors.Is(err, asynq.ErrTaskIDConflict) {
+		return nil
+	}
+	return err
+}
+
+// remove deletes the task of a command that will not run, if it still waits
+func (q *jobQueue) remove(command *AICommand) {
+	err := q.inspector.DeleteTask(q.queue(command.Priority), command.ID)
+	if err != nil && !errors.Is(err, asynq.ErrTaskNotFound) && !errors.Is(err, asynq.ErrQueueNotFound) {
+		log.Printf("⚠️ Failed to remove the job of command [%s]: %v", command.ID, err)
+	}
+}
+
+// stop stops taking new commands; the running ones are drained by HandleShutdown
+func (q *jobQueue) stop() {
+	if q.server != nil {
+		q.server.Stop()
+	}
+}
+
+// shutdown stops the workers once the sessions are drained
+func (q *jobQueue) shutdown() {
+	if q.server != nil {
+		q.server.Shutdown()
+	}
+	q.client.Close()
+}
+
+// dispatchCommand hands a queued command to the job queue. Without one, the command runs
+// when its stream is opened, so there is nothing to do. A command that cannot be
+// enqueued is failed.
+func dispatchCommand(db *gorm.DB, command *AICommand) error {
+	if commandQueue == nil || command.Status != "queued" {
+		return nil
+	}
+	if err := commandQueue.enqueue(command); err != nil {
+		log.Printf("❌ Failed to enqueue command [%s]: %v", command.ID, err)
+		command.Status = "failed"
+		command.ErrorMessage = "failed to enqueue the command: " + err.Error()
+		command.CompletedAt = time.Now().Unix()
+		db.Model(&AICommand{}).Where("id = ?", command.ID).Updates(map[string]interface{}{
+			"status":        command.Status,
+			"error_message": command.ErrorMessage,
+			"completed_at":  command.CompletedAt,
+		})
+		return err
+	}
+	return nil
+}
+
+// dispatchCommands hands several queued commands to the job queue. The batches of those
+// that cannot be enqueued are updated, since they failed.
+func dispatchCommands(db *gorm.DB, commands []AICommand) {
+	for i := range commands {
+		if err := dispatchCommand(db, &commands[i]); err != nil {
+			batchCommandChanged(db, commands[i].BatchID)
+		}
+	}
+}
+
+// queueErrorResponse answers a request whose command could not be enqueued
+func queueErrorResponse(c *fiber.Ctx, err error) error {
+	return sendError(c, 503, "QUEUE_UNAVAILABLE", "Failed to enqueue the command", err.Error())
+}
+
+// parseRunAt reads the runAt of a command request: an RFC 3339 time in the future, which
+// only the job queue can wait for. An empty value is 0, to run as soon as possible. Its
+// errors are APIErrors that handlers can return.
+func parseRunAt(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if commandQueue == nil {
+		return 0, newAPIError(400, "QUEUE_REQUIRED", "runAt needs the job queue (AI_QUEUE=asynq)", "Create a one-shot schedule with POST /api/ai/schedule instead")
+	}
+	runAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, newAPIError(400, "INVALID_RUN_AT", "runAt must be an RFC 3339 time", err.Error())
+	}
+	if !runAt.After(time.Now()) {
+		return 0, newAPIError(400, "INVALID_RUN_AT", "runAt must be in the future", nil)
+	}
+	return runAt.Unix(), nil
+}
+
+// awaitingWorker reports whether a command waits in the job queue, so streams keep
+// following it until a worker has run it
+func awaitingWorker(db *gorm.DB, commandID string) bool {
+	if commandQueue == nil {
+		return false
+	}
+	var status string
+	db.Model(&AICommand{}).Where("id = ?", commandID).Pluck("status", &status)
+	return status == "queued"
+}
+
+// handleCommandTask runs the command of a task to the end. Failures are returned to
+// asynq, which runs the command again after a delay until AI_QUEUE_MAX_RETRY is
+// reached; interrupted, timed out and rejected commands are final.
+func handleCommandTask(db *gorm.DB) asynq.HandlerFunc {
+	return func(ctx context.Context, task *asynq.Task) error {
+		var payload commandTask
+		if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+			return fmt.Errorf("invalid command task: %v: %w", err, asynq.SkipRetry)
+		}
+		var command AICommand
+		if err := db.Limit(1).Find(&command, "id = ?", payload.CommandID).Error; err != nil {
+			return err
+		}
+		if command.ID == "" {
+			return nil // Deleted while it waited
+		}
+
+		// A retry after the worker of the previous attempt died finds the command
+		// failed as orphaned, or still processing if that was not noticed yet
+		retried, _ := asynq.GetRetryCount(ctx)
+		maxRetry, _ := asynq.GetMaxRetry(ctx)
+		from := []string{"queued"}
+		if retried > 0 && !runsElsewhere(SessionKindCommand, command.ID) {
+			from = append(from, "failed", "processing")
+		}
+
+		// The budget may have run out while the command waited
+		if err := checkBudget(db, command.ProjectID); err != nil {
+			db.Model(&AICommand{}).Where("id = ? AND status IN ?", command.ID, from).Updates(map[string]interface{}{
+				"status":        "failed",
+				"error_message": err.Error(),
+				"completed_at":  time.Now().Unix(),
+			})
+			batchCommandChanged(db, command.BatchID)
+			return nil
+		}
+
+		// Take the command; a stream, another worker or an interrupt may have been first
+		result := db.Model(&AICommand{}).Where("id = ? AND status IN ?", command.ID, from).Updates(map[string]interface{}{
+			"status":        "processing",
+			"attempts":      gorm.Expr("attempts + 1"),
+			"error_message": "",
+			"completed_at":  0,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		if err := db.First(&command, "id = ?", command.ID).Error; err != nil {
+			return err
+		}
+
+		session, ok := newCommandSession(db, &command)
+		if !ok {
+			return nil
+		}
+		session.retry = retried < maxRetry
+		batchCommandChanged(db, command.BatchID)
+		log.Printf("📬 Worker runs command [%s], attempt %d of %d", command.ID, command.Attempts, maxRetry+1)
+
+		// asynq cancels the task when it outlives its timeout or the server shuts down
+		stop := context.AfterFunc(ctx, session.Cancel)
+		defer stop()
+
+		drained := make(chan struct{})
+		go func() {
+			for range session.progressQueue {
+			}
+			close(drained)
+		}()
+		activeRuns.Add(1)
+		processAICommand(session, db)
+		<-drained
+		cleanup(session)
+
+		// handleCommandError put the command back in the queue for the next attempt
+		if command.Status == "queued" {
+			return errors.New(command.ErrorMessage)
+		}
+		return nil
+	}
+}
+
+// interruptQueuedCommand cancels a command that waits in the job queue. A command a
+// worker has just taken is interrupted on that worker instead.
+func interruptQueuedCommand(c *fiber.Ctx, db *gorm.DB, commandID string) error {
+	var command AICommand
+	if err := db.Limit(1).Find(&command, "id = ?", commandID).Error; err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to load command", err.Error())
+	}
+	if command.ID == "" || command.Status != "queued" {
+		return sendError(c, 404, "SESSION_NOT_FOUND", "Command session not found or already completed", nil)
+	}
+	if !ownsOrAdmin(c, command.UserID) {
+		return sendError(c, 403, "NOT_COMMAND_OWNER", "Only the creator of the command or an admin can interrupt it", nil)
+	}
+
+	cancelled, err := cancelQueuedCommand(db, &command)
+	if err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to update command", err.Error())
+	}
+	if !cancelled {
+		if runsElsewhere(SessionKindCommand, commandID) {
+			return interruptRemoteCommand(c, db, commandID)
+		}
+		return sendError(c, 409, "COMMAND_STARTED", "Command has just been started; interrupt it again", nil)
+	}
+	recordAudit(db, c, AuditCommandInterrupt, commandID, nil, nil, "queued")
+
+	return c.JSON(APIResponse[CommandState]{
+		Success: true,
+		Message: "Command interrupted successfully",
+		Data: CommandState{
+			CommandID: commandID,
+			Status:    "interrupted",
+		},
+	})
+}
+
+// cancelQueuedCommand interrupts a command that waits in the job queue and removes its
+// task. It returns false when a worker has taken the command in the meantime.
+func cancelQueuedCommand(db *gorm.DB, command *AICommand) (bool, error) {
+	result := db.Model(&AICommand{}).Where("id = ? AND status = ?", command.ID, "queued").Updates(map[string]interface{}{
+		"status":       "interrupted",
+		"completed_at": time.Now().Unix(),
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	commandQueue.remove(command)
+	batchCommandChanged(db, command.BatchID)
+	log.Printf("⚠️ Queued command [%s] interrupted", command.ID)
+	return true, nil
+}
+
+// QueueStats is the data of GET /api/admin/queue
+type QueueStats struct {
+	Enabled bool                     `json:"enabled"` // AI_QUEUE=asynq
+	Workers int                      `json:"workers"` // Workers of the instance that answered
+	Queues  map[string]QueueCounters `json:"queues,omitempty"`
+}
+
+// QueueCounters counts the jobs of one priority
+type QueueCounters struct {
+	Pending   int `json:"pending"`   // Ready to run
+	Scheduled int `json:"scheduled"` // Waiting for their runAt
+	Active    int `json:"active"`    // Running on a worker
+	Retry     int `json:"retry"`     // Failed, waiting for the next attempt
+	Archived  int `json:"archived"`  // Failed after the last attempt
+	Processed int `json:"processedToday"`
+	Failed    int `json:"failedToday"`
+}
+
+// GetQueueStats reports how many commands wait, run and failed in each queue
+func GetQueueStats() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stats := QueueStats{Enabled: commandQueue != nil}
+		if commandQueue == nil {
+			return c.JSON(APIResponse[QueueStats]{Success: true, Data: stats})
+		}
+
+		// Queues only exist once something was enqueued with their priority
+		names, err := commandQueue.inspector.Queues()
+		if err != nil {
+			return sendError(c, 503, "QUEUE_UNAVAILABLE", "Failed to read the job queue", err.Error())
+		}
+		stats.Workers = getQueueWorkers()
+		stats.Queues = make(map[string]QueueCounters, len(priorityWeights))
+		for priority := range priorityWeights {
+			if !containsString(names, commandQueue.queue(priority)) {
+				stats.Queues[priority] = QueueCounters{}
+				continue
+			}
+			info, err := commandQueue.inspector.GetQueueInfo(commandQueue.queue(priority))
+			if err != nil {
+				return sendError(c, 503, "QUEUE_UNAVAILABLE", "Failed to read the job queue", err.Error())
+			}
+			stats.Queues[priority] = QueueCounters{
+				Pending:   info.Pending,
+				Scheduled: info.Scheduled,
+				Active:    info.Active,
+				Retry:     info.Retry,
+				Archived:  info.Archived,
+				Processed: info.Processed,
+				Failed:    info.Failed,
+			}
+		}
+		return c.JSON(APIResponse[QueueStats]{Success: true, Data: stats})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// getMaxLineSize returns the longest output line passed on in one piece, in bytes
+// (streams.maxLineSize, OUTPUT_MAX_LINE_SIZE). Falls back to 4MB
+func getMaxLineSize() int {
+	return appConfig.Streams.MaxLineSize
+}
+
+// lineReader reads process output line by line. Unlike bufio.Scanner it doesn't stop at
+// a line that is too long: such a line comes in chunks of at most max bytes, cut between
+// UTF-8 characters.
+type lineReader struct {
+	r        *bufio.Reader
+	max      int
+	buf      []byte
+	complete bool // buf holds the rest of a line whose end was read
+	chunk    int  // Number of chunks of the current line returned so far
+}
+
+func newLineReader(r io.Reader, max int) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, 64*1024), max: max}
+}
+
+// next returns the next line without its line ending, or the next chunk of a long line.
+// chunk counts the chunks of the line from 0, and more reports that the line goes on in
+// the next chunk. The error is io.EOF at the end of the output.
+func (l *lineReader) next() (line string, chunk int, more bool, err error) {
+	for !l.complete && len(l.buf) <= l.max {
+		slice, err := l.r.ReadSlice('\n')
+		l.buf = append(l.buf, slice...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == nil {
+			l.buf = bytes.TrimSuffix(bytes.TrimSuffix(l.buf, []byte("\n")), []byte("\r"))
+		} else if err != io.EOF || len(l.buf) == 0 {
+			return "", 0, false, err
+		}
+		l.complete = true // A last line without a line ending counts as well
+	}
+
+	chunk = l.chunk
+	if len(l.buf) > l.max {
+		cut := l.max
+		for cut > 0 && cut > l.max-utf8.UTFMax && !utf8.RuneStart(l.buf[cut]) {
+			cut--
+		}
+		if cut == 0 || !utf8.RuneStart(l.buf[cut]) {
+			cut = l.max
+		}
+		line = string(l.buf[:cut])
+		l.buf = append(l.buf[:0], l.buf[cut:]...)
+		l.chunk++
+		return line, chunk, true, nil
+	}
+
+	line = string(l.buf)
+	l.buf = l.buf[:0]
+	l.complete = false
+	l.chunk = 0
+	return line, chunk, false, nil
+}
+
+// readLines calls fn with each line or chunk of r, see lineReader.next, until the end of
+// the output or until fn returns false
+func readLines(r io.Reader, max int, fn func(line string, chunk int, more bool) bool) error {
+	reader := newLineReader(r, max)
+	for {
+		line, chunk, more, err := reader.next()
+		if err != nil {
+			return err
+		}
+		if !fn(line, chunk, more) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// localePattern matches a BCP 47 language tag such as en, fr-CA or zh-Hant-TW
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+// getDefaultLocale returns the locale of content saved without one (defaultLocale, DEFAULT_LOCALE)
+// Falls back to en
+func getDefaultLocale() string {
+	return appConfig.DefaultLocale
+}
+
+// normalizeLocale canonicalizes a language tag: fr_ca and FR-ca become fr-CA,
+// zh-hant-tw becomes zh-Hant-TW
+func normalizeLocale(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+	if !localePattern.MatchString(tag) {
+		return "", fmt.Errorf("invalid locale %q (use a language tag such as en or fr-CA)", tag)
+	}
+	parts := strings.FieldsFunc(tag, func(r rune) bool { return r == '-' || r == '_' })
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch {
+		case len(parts[i]) == 2 || (len(parts[i]) == 3 && parts[i][0] >= '0' && parts[i][0] <= '9'):
+			parts[i] = strings.ToUpper(parts[i]) // Region: CA, 419
+		case len(parts[i]) == 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:]) // Script: Hant
+		default:
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+// localeFallbacks returns the locales tried for a read, most specific first and
+// ending with the default locale: fr-CA gives fr-CA, fr, en
+func localeFallbacks(locale string) []string {
+	var chain []string
+	for tag := locale; tag != ""; {
+		chain = append(chain, tag)
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	if defaultLocale := getDefaultLocale(); !slices.Contains(chain, defaultLocale) {
+		chain = append(chain, defaultLocale)
+	}
+	return chain
+}
+
+// localeDetail adds the locale of a translation to an audit detail
+func localeDetail(content Content, detail string) string {
+	if content.Locale == getDefaultLocale() {
+		return detail
+	}
+	if detail == "" {
+		return "locale " + content.Locale
+	}
+	return detail + ", locale " + content.Locale
+}
+
+// requestLocale returns the normalized ?locale= of a request, or the default locale
+func requestLocale(c *fiber.Ctx) (string, error) {
+	if value := c.Query("locale"); value != "" {
+		return normalizeLocale(value)
+	}
+	return getDefaultLocale(), nil
+}
+
+// bodyLocale returns the normalized locale of a request body, falling back to requestLocale
+func bodyLocale(c *fiber.Ctx, locale string) (string, error) {
+	if locale != "" {
+		return normalizeLocale(locale)
+	}
+	return requestLocale(c)
+}
+
+// invalidLocale responds to a request with a malformed locale
+func invalidLocale(c *fiber.Ctx, err error) error {
+	return sendError(c, 400, "INVALID_LOCALE", err.Error(), nil)
+}
+
+// findLocalizedContent loads the block in the first locale of the chain that has it
+func findLocalizedContent(db *gorm.DB, id string, chain []string) (Content, error) {
+	var contents []Content
+	if err := db.Where("id = ? AND locale IN ?", id, chain).Find(&contents).Error; err != nil {
+		return Content{}, err
+	}
+	if picked := pickLocalized(contents, chain); len(picked) > 0 {
+		return picked[0], nil
+	}
+	return Content{}, gorm.ErrRecordNotFound
+}
+
+// pickLocalized keeps, for every block ID, the row in the earliest locale of the
+// chain. The order of the first row of each ID is kept.
+func pickLocalized(contents []Content, chain []string) []Content {
+	rank := make(map[string]int, len(chain))
+	for i, locale := range chain {
+		rank[locale] = i
+	}
+
+	best := make(map[string]int, len(contents)) // ID -> index into picked
+	picked := make([]Content, 0, len(contents))
+	for _, content := range contents {
+		i, seen := best[content.ID]
+		if !seen {
+			best[content.ID] = len(picked)
+			picked = append(picked, content)
+		} else if rank[content.Locale] < rank[picked[i].Locale] {
+			picked[i] = content
+		}
+	}
+	return picked
+}
+
+// localizedResponse is contentResponse for a read in the requested locale; fallback
+// is set when the block was served from another locale of the chain
+func localizedResponse(content Content, state, requested string) ContentResponse {
+	response := contentResponse(content, state)
+	response.Fallback = content.Locale != requested
+	return response
+}
+
+// ContentLocale is a translation of a content block
+type ContentLocale struct {
+	Locale         string `json:"locale"`
+	IsEdited       bool   `json:"is_edited"`
+	IsPublished    bool   `json:"is_published"`
+	HasUnpublished bool   `json:"has_unpublished"`
+	Version        int64  `json:"version"`
+	UpdatedAt      int64  `json:"updated_at"`
+}
+
+// ContentLocalesResponse is the response of GET /api/content/:id/locales
+type ContentLocalesResponse struct {
+	ID            string          `json:"id"`
+	DefaultLocale string          `json:"default_locale"`
+	Locales       []ContentLocale `json:"locales"`
+}
+
+// GetContentLocales lists the translations that exist of a content block
+func GetContentLocales(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		var contents []Content
+		if err := db.Where("id = ?", id).Order("locale").Find(&contents).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load content", nil)
+		}
+		if len(contents) == 0 {
+			return sendError(c, 404, "CONTENT_NOT_FOUND", "Content not found", nil)
+		}
+
+		locales := make([]ContentLocale, 0, len(contents))
+		for _, content := range contents {
+			locales = append(locales, ContentLocale{
+				Locale:         content.Locale,
+				IsEdited:       content.IsEdited,
+				IsPublished:    content.IsPublished,
+				HasUnpublished: contentResponse(content, ContentStateDraft).HasUnpublished,
+				Version:        content.Version,
+				UpdatedAt:      content.UpdatedAt,
+			})
+		}
+
+		return c.JSON(ContentLocalesResponse{
+			ID:            id,
+			DefaultLocale: getDefaultLocale(),
+			Locales:       locales,
+		})
+	}
+}
+
+// migrateContentLocale moves a contents table from before translations, keyed by ID
+// alone, to the (id, locale) key. Existing blocks get the default locale.
+func migrateContentLocale(db *gorm.DB, driver string) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&Content{}) || migrator.HasColumn(&Content{}, "locale") {
+		return nil
+	}
+	locale := getDefaultLocale()
+	log.Printf("🌐 Migrating content to translations; existing blocks get locale %s", locale)
+
+	switch driver {
+	case "postgres", "postgresql":
+		return db.Transaction(func(tx *gorm.DB) error {
+			for _, statement := range []string{
+				"ALTER TABLE contents ADD COLUMN locale varchar(35) NOT NULL DEFAULT " + quoteLocale(locale),
+				"ALTER TABLE contents DROP CONSTRAINT contents_pkey",
+				"ALTER TABLE contents ADD PRIMARY KEY (id, locale)",
+			} {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "mysql":
+		return db.Exec("ALTER TABLE contents ADD COLUMN locale varchar(35) NOT NULL DEFAULT " + quoteLocale(locale) +
+			", DROP PRIMARY KEY, ADD PRIMARY KEY (id, locale)").Error
+	}
+
+	// SQLite cannot change a primary key, so the table is rebuilt. Its indexes and
+	// triggers are dropped first; their names would clash with the new table's.
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE contents RENAME TO contents_legacy").Error; err != nil {
+			return err
+		}
+		var objects []struct {
+			Type string
+			Name string
+		}
+		if err := tx.Raw("SELECT type, name FROM sqlite_master WHERE tbl_name = 'contents_legacy' AND type IN ('index', 'trigger') AND sql IS NOT NULL").Scan(&objects).Error; err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if err := tx.Exec(fmt.Sprintf("DROP %s %q", strings.ToUpper(object.Type), object.Name)).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Migrator().CreateTable(&Content{}); err != nil {
+			return err
+		}
+		columns, err := tx.Migrator().ColumnTypes("contents_legacy")
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, column := range columns {
+			if tx.Migrator().HasColumn(&Content{}, column.Name()) {
+				names = append(names, fmt.Sprintf("%q", column.Name()))
+			}
+		}
+		list := strings.Join(names, ", ")
+		if err := tx.Exec("INSERT INTO contents ("+list+", locale) SELECT "+list+", ? FROM contents_legacy", locale).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DROP TABLE contents_legacy").Error
+	})
+}
+
+// quoteLocale quotes a validated locale as an SQL string literal
+func quoteLocale(locale string) string {
+	return "'" + strings.ReplaceAll(locale, "'", "''") + "'"
+}
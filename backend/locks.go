@@ -0,0 +1,206 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ContentLock marks a content block as being edited by one editor
+type ContentLock struct {
+	ContentID  string    `json:"contentId"`
+	Holder     string    `json:"holder"` // Free-form editor name shown to other editors
+	Token      string    `json:"token,omitempty"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// ContentLockRequest is the body of lock, heartbeat and release requests
+type ContentLockRequest struct {
+	Holder string `json:"holder"`
+	Token  string `json:"token"`
+}
+
+var (
+	contentLocks = make(map[string]*ContentLock)
+	lockMu       sync.Mutex
+)
+
+// getContentLockTTL returns how long a lock lives without a heartbeat (CONTENT_LOCK_TTL)
+// Defaults to 2 minutes
+func getContentLockTTL() time.Duration {
+	return getEnvDuration("CONTENT_LOCK_TTL", 2*time.Minute)
+}
+
+// activeLock returns the unexpired lock of a content block; callers hold lockMu
+func activeLock(id string) *ContentLock {
+	lock, ok := contentLocks[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(lock.ExpiresAt) {
+		delete(contentLocks, id)
+		return nil
+	}
+	return lock
+}
+
+// lockHeldByOther reports whether someone other than the bearer of token holds the lock
+func lockHeldByOther(id, token string) (*ContentLock, bool) {
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	lock := activeLock(id)
+	if lock == nil || lock.Token == token {
+		return nil, false
+	}
+	return publicLock(lock), true
+}
+
+// publicLock strips the token so it is only revealed to the holder
+func publicLock(lock *ContentLock) *ContentLock {
+	copied := *lock
+	copied.Token = ""
+	return &copied
+}
+
+// contentLockedError responds to a write of a block locked by someone else. Writes of
+// the content answer 423, requests for the lock itself 409.
+func contentLockedError(c *fiber.Ctx, status int, lock *ContentLock) error {
+	return sendError(c, status, "CONTENT_LOCKED", "Content is being edited by someone else", fiber.Map{
+		"id":   lock.ContentID,
+		"lock": lock,
+	})
+}
+
+// pruneContentLocks removes expired locks
+func pruneContentLocks() int {
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	expired := 0
+	for id, lock := range contentLocks {
+		if time.Now().After(lock.ExpiresAt) {
+			delete(contentLocks, id)
+			expired++
+		}
+	}
+	return expired
+}
+
+// ContentLockStatus is the response of the lock endpoints. The token is only
+// included for the editor holding the lock.
+type ContentLockStatus struct {
+	Locked bool         `json:"locked"`
+	Lock   *ContentLock `json:"lock,omitempty"`
+}
+
+// GetContentLock returns the current lock of a content block, if any
+func GetContentLock() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		lockMu.Lock()
+		lock := activeLock(id)
+		lockMu.Unlock()
+
+		if lock == nil {
+			return c.JSON(ContentLockStatus{Locked: false})
+		}
+		return c.JSON(ContentLockStatus{Locked: true, Lock: publicLock(lock)})
+	}
+}
+
+// AcquireContentLock locks a content block for the calling editor.
+// Re-acquiring with the same holder or token refreshes the lock.
+func AcquireContentLock() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		var req ContentLockRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", nil)
+		}
+		if req.Holder == "" {
+			return sendError(c, 400, "MISSING_HOLDER", "holder is required", nil)
+		}
+
+		lockMu.Lock()
+		defer lockMu.Unlock()
+
+		now := time.Now()
+		lock := activeLock(id)
+		if lock != nil && lock.Holder != req.Holder && lock.Token != req.Token {
+			return contentLockedError(c, 409, publicLock(lock))
+		}
+
+		if lock == nil {
+			lock = &ContentLock{
+				ContentID:  id,
+				Holder:     req.Holder,
+				Token:      uuid.New().String(),
+				AcquiredAt: now,
+			}
+			contentLocks[id] = lock
+		}
+		lock.ExpiresAt = now.Add(getContentLockTTL())
+
+		return c.JSON(ContentLockStatus{Locked: true, Lock: lock})
+	}
+}
+
+// HeartbeatContentLock extends a lock held by the bearer of the token
+func HeartbeatContentLock() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		var req ContentLockRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", nil)
+		}
+
+		lockMu.Lock()
+		defer lockMu.Unlock()
+
+		lock := activeLock(id)
+		if lock == nil {
+			return sendError(c, 404, "LOCK_NOT_FOUND", "Lock not found or expired", nil)
+		}
+		if lock.Token != req.Token {
+			return contentLockedError(c, 409, publicLock(lock))
+		}
+
+		lock.ExpiresAt = time.Now().Add(getContentLockTTL())
+		return c.JSON(ContentLockStatus{Locked: true, Lock: lock})
+	}
+}
+
+// ReleaseContentLock removes a lock held by the bearer of the token
+func ReleaseContentLock() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		var req ContentLockRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", nil)
+			}
+		}
+		if req.Token == "" {
+			req.Token = c.Query("token")
+		}
+
+		lockMu.Lock()
+		defer lockMu.Unlock()
+
+		lock := activeLock(id)
+		if lock != nil && lock.Token != req.Token {
+			return contentLockedError(c, 409, publicLock(lock))
+		}
+
+		delete(contentLocks, id)
+		return c.JSON(ContentLockStatus{Locked: false})
+	}
+}
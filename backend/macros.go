@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Macro is a saved prompt that runs as a normal AI command. Its prompt may contain
+// {{name}} placeholders that are filled in on every run.
+type Macro struct {
+	ID           string   `gorm:"primaryKey" json:"id"`
+	Name         string   `json:"name"`
+	Description  string   `gorm:"type:text" json:"description,omitempty"`
+	Prompt       string   `gorm:"type:text;serializer:encrypted" json:"prompt"`
+	Scope        string   `json:"scope"` // Default scope; a run may override it
+	Provider     string   `json:"provider,omitempty"`
+	Placeholders []string `gorm:"serializer:json" json:"placeholders"`
+	CreatedBy    string   `json:"createdBy,omitempty"`
+	CreatedAt    int64    `json:"createdAt"`
+	UpdatedAt    int64    `json:"updatedAt"`
+}
+
+// MacroRequest is the body of POST and PUT /api/ai/macros
+type MacroRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Prompt      string `json:"prompt"` // e.g. "Add a new blog post titled {{title}}"
+	Scope       string `json:"scope"`  // Defaults to current-page
+	Provider    string `json:"provider,omitempty"`
+}
+
+// MacroRunRequest is the body of POST /api/ai/macros/:id/run
+type MacroRunRequest struct {
+	Values   map[string]string `json:"values"`          // Placeholder values by name
+	Scope    string            `json:"scope,omitempty"` // Overrides the macro's scope
+	Provider string            `json:"provider,omitempty"`
+	Context  CommandContext    `json:"context"`
+
+	ConversationID string `json:"conversationId,omitempty"`
+}
+
+// macroPlaceholderPattern matches {{name}}, allowing spaces inside the braces
+var macroPlaceholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// macroPlaceholders lists the placeholder names of a prompt in order of appearance
+func macroPlaceholders(prompt string) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, match := range macroPlaceholderPattern.FindAllStringSubmatch(prompt, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// expandMacro fills in the placeholders of a prompt, returning the names that
+// have no value
+func expandMacro(prompt string, values map[string]string) (string, []string) {
+	var missing []string
+	for _, name := range macroPlaceholders(prompt) {
+		if strings.TrimSpace(values[name]) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", missing
+	}
+	return macroPlaceholderPattern.ReplaceAllStringFunc(prompt, func(placeholder string) string {
+		return strings.TrimSpace(values[macroPlaceholderPattern.FindStringSubmatch(placeholder)[1]])
+	}), nil
+}
+
+// validateMacroRequest checks and normalizes a macro body. It returns the error
+// code and message of the first problem, or an empty code when the body is valid.
+func validateMacroRequest(req *MacroRequest) (code, message, details string) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return "MISSING_NAME", "Name is required", ""
+	}
+	if strings.TrimSpace(req.Prompt) == "" {
+		return "MISSING_PROMPT", "Prompt is required", ""
+	}
+	if req.Scope == "" {
+		req.Scope = "current-page"
+	}
+	if req.Scope != "current-page" && req.Scope != "new-page" && req.Scope != "global" && req.Scope != "component" {
+		return "INVALID_SCOPE", "Invalid scope value provided", "Scope must be one of: current-page, new-page, global, component"
+	}
+	if req.Provider != "" {
+		if _, err := getProvider(req.Provider); err != nil {
+			return "INVALID_PROVIDER", "Invalid AI provider", err.Error()
+		}
+	}
+	return "", "", ""
+}
+
+// CreateMacro saves a reusable prompt
+func CreateMacro(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req MacroRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if code, message, details := validateMacroRequest(&req); code != "" {
+			return sendError(c, 400, code, message, details)
+		}
+
+		now := time.Now().Unix()
+		macro := Macro{
+			ID:           "macro_" + uuid.New().String()[:8],
+			Name:         req.Name,
+			Description:  req.Description,
+			Prompt:       req.Prompt,
+			Scope:        req.Scope,
+			Provider:     req.Provider,
+			Placeholders: macroPlaceholders(req.Prompt),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if user := currentUser(c); user != nil {
+			macro.CreatedBy = user.ID
+		}
+		if err := db.Create(&macro).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create macro", err.Error())
+		}
+
+		log.Printf("🧩 Macro %s created: %q", macro.ID, macro.Name)
+		return c.Status(201).JSON(APIResponse[Macro]{
+			Success: true,
+			Data:    macro,
+		})
+	}
+}
+
+// MacroList is the data of GET /api/ai/macros
+type MacroList struct {
+	Macros []Macro `json:"macros"`
+}
+
+// ListMacros returns every macro by name
+func ListMacros(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var macros []Macro
+		if err := db.Order("name, created_at").Find(&macros).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load macros", err.Error())
+		}
+
+		return c.JSON(APIResponse[MacroList]{
+			Success: true,
+			Data:    MacroList{Macros: macros},
+		})
+	}
+}
+
+// GetMacro returns a single macro
+func GetMacro(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var macro Macro
+		if err := db.First(&macro, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "MACRO_NOT_FOUND", "Macro not found", "")
+		}
+
+		return c.JSON(APIResponse[Macro]{
+			Success: true,
+			Data:    macro,
+		})
+	}
+}
+
+// UpdateMacro replaces the name, prompt and defaults of a macro
+func UpdateMacro(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var macro Macro
+		if err := db.First(&macro, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "MACRO_NOT_FOUND", "Macro not found", "")
+		}
+
+		var req MacroRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if code, message, details := validateMacroRequest(&req); code != "" {
+			return sendError(c, 400, code, message, details)
+		}
+
+		macro.Name = req.Name
+		macro.Description = req.Description
+		macro.Prompt = req.Prompt
+		macro.Scope = req.Scope
+		macro.Provider = req.Provider
+		macro.Placeholders = macroPlaceholders(req.Prompt)
+		macro.UpdatedAt = time.Now().Unix()
+		if err := db.Save(&macro).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update macro", err.Error())
+		}
+
+		return c.JSON(APIResponse[Macro]{
+			Success: true,
+			Data:    macro,
+		})
+	}
+}
+
+// DeleteMacro removes a macro; commands it already ran are kept
+func DeleteMacro(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result := db.Delete(&Macro{}, "id = ?", c.Params("id"))
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete macro", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "MACRO_NOT_FOUND", "Macro not found", "")
+		}
+
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: c.Params("id"), Deleted: true},
+		})
+	}
+}
+
+// RunMacro fills in the placeholders of a macro and submits the prompt as a normal
+// AI command, which responds like POST /api/ai/command
+func RunMacro(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var macro Macro
+		if err := db.First(&macro, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "MACRO_NOT_FOUND", "Macro not found", "")
+		}
+
+		var req MacroRunRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+			}
+		}
+
+		prompt, missing := expandMacro(macro.Prompt, req.Values)
+		if len(missing) > 0 {
+			return sendError(c, 400, "MISSING_VALUES", "Every placeholder needs a value", fmt.Sprintf("Missing: %s", strings.Join(missing, ", ")))
+		}
+
+		command := AICommandRequest{
+			Prompt:         prompt,
+			Scope:          macro.Scope,
+			Provider:       macro.Provider,
+			Context:        req.Context,
+			ConversationID: req.ConversationID,
+		}
+		if req.Scope != "" {
+			command.Scope = req.Scope
+		}
+		if req.Provider != "" {
+			command.Provider = req.Provider
+		}
+		// RequireScopeRole only sees the request body, not the macro's scope
+		if command.Scope == "global" && !currentRole(c).Allows(RoleAdmin) {
+			return forbidden(c, RoleAdmin)
+		}
+
+		log.Printf("🧩 Running macro %s (%q)", macro.ID, macro.Name)
+		return submitAICommand(c, db, command)
+	}
+}
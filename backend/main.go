@@ -1,63 +1,373 @@
 package main
 
 import (
+	"flag"
 	"log"
-	"os"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
 )
 
 func main() {
+	migrate := flag.Bool("migrate", false, "apply pending database migrations and exit")
+	rollback := flag.Int("rollback", 0, "roll back the last `n` database migrations and exit")
+	encrypt := flag.Bool("encrypt", false, "encrypt the sensitive columns of existing rows with SECRETS_KEY and exit")
+	permissionHook := flag.Bool("permission-hook", false, "answer a permission request of the Claude CLI (run by the CLI, not by hand)")
+	flag.Parse()
+
+	// The CLI runs the binary as its PreToolUse hook; this must not load the configuration
+	if *permissionHook {
+		if err := runPermissionHook(); err != nil {
+			log.Fatal("Permission hook failed: ", err)
+		}
+		return
+	}
+
+	// Load config.yaml and the environment; invalid settings stop the server here
+	cfg, file, overridden, err := loadConfig()
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+	appConfig, appConfigFile, appConfigEnv = cfg, file, overridden
+	if file != "" {
+		log.Printf("⚙️ Configuration loaded from %s", file)
+	}
+
 	// Check log level
-	if os.Getenv("LOG_LEVEL") == "HIGH" {
+	if isHighLogLevel() {
 		log.Printf("🔍 [HIGH LOG] ================================")
 		log.Printf("🔍 [HIGH LOG] HIGH LOGGING ENABLED")
 		log.Printf("🔍 [HIGH LOG] All Claude API calls and responses will be logged in detail")
 		log.Printf("🔍 [HIGH LOG] ================================")
 	}
 
+	if *migrate || *rollback > 0 {
+		if err := runMigrationCommand(*rollback); err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		return
+	}
+	if *encrypt {
+		if err := runEncryptCommand(); err != nil {
+			log.Fatal("Encryption failed: ", err)
+		}
+		return
+	}
+
 	// Initialize database
 	db, err := InitDB()
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	// Create Fiber app
-	app := fiber.New()
+	// Share sessions, streams and rate limits with the other instances (REDIS_URL)
+	if err := StartCluster(); err != nil {
+		log.Fatal("Failed to start Redis mode:", err)
+	}
+
+	// Settle the sessions of the previous run, then prune finished sessions and fail
+	// commands orphaned by a crash
+	RecoverSessions(db)
+
+	// Run AI commands from the job queue (AI_QUEUE), on this instance or on workers
+	if err := StartJobQueue(db); err != nil {
+		log.Fatal("Failed to start the job queue:", err)
+	}
+	go StartCleanupScheduler(db)
+	go StartScheduler(db)
+	go StartBackupScheduler(db)
+	failAbandonedBuilds(db)
+	failAbandonedDeployments(db)
+	ensureBootstrapAdmin(db)
+
+	// Asset storage (local disk or S3-compatible bucket)
+	store, err := NewAssetStorage()
+	if err != nil {
+		log.Fatal("Failed to initialize asset storage:", err)
+	}
+	StartAssetWorkers(db, store)
+	go StartBatchWorkers(db)
 
-	// Enable CORS - Allow all origins for development
+	// Create Fiber app; the body limit leaves headroom for multipart overhead on uploads
+	app := fiber.New(fiber.Config{
+		BodyLimit:    getAssetMaxSize() + 1<<20,
+		ErrorHandler: HandleError,
+	})
+
+	// Answer panics in handlers with a 500 error envelope instead of dropping the connection
+	app.Use(recover.New(recover.Config{EnableStackTrace: true}))
+
+	// Enable CORS for the configured origins (all by default, for development)
 	app.Use(cors.New(cors.Config{
-		AllowOrigins:     "*",
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
-		AllowMethods:     "GET, PUT, POST, DELETE, OPTIONS, HEAD",
-		AllowCredentials: false,
-		ExposeHeaders:    "Content-Length",
+		AllowOrigins:     strings.Join(appConfig.CORS.Origins, ","),
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Client-ID, Idempotency-Key, If-None-Match, If-Modified-Since",
+		AllowMethods:     strings.Join(appConfig.CORS.Methods, ", "),
+		AllowCredentials: appConfig.CORS.AllowCredentials,
+		ExposeHeaders:    "Content-Length, ETag, Last-Modified",
 		MaxAge:           3600,
 	}))
 
+	// Forms of the published site are sent by visitors, so they are accepted before
+	// authentication
+	app.Post("/api/forms/:formId/submit", RateLimitForms(), SubmitForm(db))
+
+	// Signing in comes before authentication, and users of any role manage their own
+	// password and sessions
+	app.Get("/api/auth/providers", ListAuthProviders())
+	app.Post("/api/auth/signup", RateLimitLogin(), ValidateBody[SignupRequest](), Signup(db))
+	app.Post("/api/auth/login", RateLimitLogin(), ValidateBody[LoginRequest](), Login(db))
+	app.Post("/api/auth/refresh", ValidateBody[RefreshRequest](), RefreshSession(db))
+	app.Get("/api/auth/oauth/:provider", StartOAuthLogin())
+	app.Get("/api/auth/oauth/:provider/callback", RateLimitLogin(), OAuthCallback(db))
+	app.Post("/api/auth/logout", RequireAccount(db), Logout(db))
+	app.Put("/api/me/password", RequireAccount(db), ValidateBody[PasswordRequest](), ChangePassword(db))
+	app.Get("/api/me/sessions", RequireAccount(db), ListMySessions(db))
+	app.Delete("/api/me/sessions/:id", RequireAccount(db), DeleteMySession(db))
+	app.Get("/api/me/usage", RequireAccount(db), GetMyUsage(db))
+	app.Get("/api/invites/:token", GetInvite(db))
+	app.Post("/api/invites/:token/accept", RateLimitLogin(), ValidateBody[AcceptInviteRequest](), AcceptInvite(db))
+
+	// Resolve the caller's role (AUTH_ENABLED); writes need at least an editor
+	app.Use(Authenticate(db))
+	app.Get("/api/me", GetMe(db))
+	app.Get("/api/auth/me", GetMe(db))
+
 	// Content API routes
+	app.Get("/api/content", GetContentBulk(db))
+	app.Post("/api/content/bulk", ValidateBody[BulkContentRequest](), PostContentBulk(db))
+	app.Get("/api/content/export", ExportContent(db, store))
+	app.Post("/api/content/import", ImportContent(db, store))
+	app.Get("/api/content/trash", ListTrash(db))
+	app.Get("/api/content/search", SearchContent(db))
 	app.Get("/api/content/:id", GetContent(db))
-	app.Put("/api/content/:id", PutContent(db))
+	app.Put("/api/content/:id", ValidateBody[ContentRequest](), PutContent(db))
+	app.Delete("/api/content/:id", DeleteContent(db))
+	app.Get("/api/content/:id/diff", GetContentDiff(db))
+	app.Get("/api/content/:id/locales", GetContentLocales(db))
+	app.Post("/api/content/:id/restore", RestoreContent(db))
+	app.Post("/api/content/:id/reset", ResetContent(db))
+	app.Post("/api/content/:id/publish", PublishContent(db))
+	app.Post("/api/content/:id/unpublish", UnpublishContent(db))
+	app.Get("/api/content/:id/lock", GetContentLock())
+	app.Post("/api/content/:id/lock", AcquireContentLock())
+	app.Post("/api/content/:id/lock/heartbeat", HeartbeatContentLock())
+	app.Delete("/api/content/:id/lock", ReleaseContentLock())
+	app.Get("/api/content/:id/subscribe", RequireWebSocket(), SubscribeContent(db))
 	app.Options("/api/content/:id", func(c *fiber.Ctx) error {
 		return c.SendStatus(204)
 	})
 
+	app.Post("/api/render/markdown", RenderMarkdown())
+
+	// Page namespace routes
+	app.Get("/api/pages", ListPages(db))
+	app.Post("/api/pages", ValidateBody[CreatePageRequest](), CreatePage(db))
+	app.Get("/api/pages/templates", ListPageTemplates(db))
+	app.Post("/api/templates", ValidateBody[TemplateRequest](), CreateTemplate(db))
+	app.Get("/api/templates", ListTemplates(db))
+	app.Get("/api/templates/:id", GetTemplate(db))
+	app.Put("/api/templates/:id", ValidateBody[TemplateRequest](), UpdateTemplate(db))
+	app.Delete("/api/templates/:id", DeleteTemplate(db))
+	app.Post("/api/templates/:id/instantiate", ValidateBody[InstantiateTemplateRequest](), InstantiateTemplate(db))
+	app.Get("/api/pages/:page/content", GetPageContent(db))
+	app.Get("/api/pages/:page/meta", GetPageMeta(db))
+	app.Put("/api/pages/:page/meta", ValidateBody[PageMetaRequest](), PutPageMeta(db))
+	app.Get("/api/pages/:page/screenshot", GetPageScreenshot(db))
+	app.Delete("/api/pages/:page", DeletePage(db))
+	app.Put("/api/pages/:page/project", RequireProjectRole(RoleAdmin), SetPageProject(db))
+
+	// Navigation menu routes
+	app.Get("/api/menus", ListMenus(db))
+	app.Post("/api/menus", ValidateBody[MenuRequest](), CreateMenu(db))
+	app.Get("/api/menus/:name", GetMenu(db))
+	app.Put("/api/menus/:name", ValidateBody[MenuTreeRequest](), UpdateMenu(db))
+	app.Delete("/api/menus/:name", DeleteMenu(db))
+	app.Post("/api/menus/:name/items", ValidateBody[MenuItemRequest](), CreateMenuItem(db))
+	app.Put("/api/menus/:name/items/:id", ValidateBody[MenuItemRequest](), UpdateMenuItem(db))
+	app.Delete("/api/menus/:name/items/:id", DeleteMenuItem(db))
+	app.Post("/api/menus/:name/reorder", ValidateBody[MenuReorderRequest](), ReorderMenu(db))
+
+	// Form submission routes; submissions hold personal data, so reading them needs an editor
+	app.Get("/api/forms", RequireRole(RoleEditor), ListForms(db))
+	app.Get("/api/forms/:formId/submissions", RequireRole(RoleEditor), ListFormSubmissions(db))
+	app.Get("/api/forms/:formId/submissions/export", RequireRole(RoleEditor), ExportFormSubmissions(db))
+	app.Delete("/api/forms/:formId/submissions/:id", DeleteFormSubmission(db))
+
+	// Asset routes
+	app.Post("/api/assets", UploadAsset(db, store))
+	app.Get("/api/assets", ListAssets(db))
+	app.Get("/api/assets/:id", GetAsset(db, store))
+	app.Delete("/api/assets/:id", DeleteAsset(db, store))
+
+	// Project routes
+	app.Get("/api/projects", ListProjects(db))
+	app.Post("/api/projects", RequireRole(RoleAdmin), CreateProject(db))
+	app.Get("/api/projects/:id", GetProject(db))
+	app.Put("/api/projects/:id", RequireRole(RoleAdmin), UpdateProject(db))
+	app.Delete("/api/projects/:id", RequireRole(RoleAdmin), DeleteProject(db))
+	app.Get("/api/projects/:id/env", ListProjectEnv(db))
+	app.Put("/api/projects/:id/env/:key", RequireRole(RoleAdmin), PutProjectEnv(db))
+	app.Delete("/api/projects/:id/env/:key", RequireRole(RoleAdmin), DeleteProjectEnv(db))
+	app.Get("/api/projects/:id/notifications", ListNotificationChannels(db))
+	app.Post("/api/projects/:id/notifications", RequireProjectRole(RoleAdmin), CreateNotificationChannel(db))
+	app.Delete("/api/projects/:id/notifications/:channelId", RequireProjectRole(RoleAdmin), DeleteNotificationChannel(db))
+	app.Post("/api/projects/:id/notifications/:channelId/test", RequireProjectRole(RoleAdmin), TestNotificationChannel(db))
+	app.Get("/api/projects/:id/email", GetProjectEmail(db))
+	app.Put("/api/projects/:id/email", RequireProjectRole(RoleAdmin), ValidateBody[EmailSettingsRequest](), PutProjectEmail(db))
+	app.Delete("/api/projects/:id/email", RequireProjectRole(RoleAdmin), DeleteProjectEmail(db))
+	app.Post("/api/projects/:id/email/test", RequireProjectRole(RoleAdmin), ValidateBody[EmailTestRequest](), TestProjectEmail(db))
+	app.Get("/api/projects/:id/team", RequireProjectRole(RoleAdmin), ListTeam(db))
+	app.Post("/api/projects/:id/team/invites", RequireProjectRole(RoleAdmin), ValidateBody[TeamInviteRequest](), InviteTeamMember(db))
+	app.Delete("/api/projects/:id/team/invites/:inviteId", RequireProjectRole(RoleAdmin), RevokeTeamInvite(db))
+	app.Put("/api/projects/:id/team/:userId", RequireProjectRole(RoleAdmin), ValidateBody[TeamRoleRequest](), UpdateTeamMember(db))
+	app.Delete("/api/projects/:id/team/:userId", RequireProjectRole(RoleAdmin), RemoveTeamMember(db))
+
+	// Workspace file browser routes
+	app.Get("/api/workspace/files", ListWorkspaceFiles(db))
+	app.Get("/api/workspace/file", GetWorkspaceFile(db))
+	app.Put("/api/workspace/file", PutWorkspaceFile(db))
+	app.Get("/api/workspace/file/diff", DiffWorkspaceFile(db))
+	app.Post("/api/workspace/file/diff", DiffWorkspaceFile(db))
+	app.Get("/api/workspace/git/log", GetGitLog(db))
+	app.Get("/api/workspace/git/diff/:sha", GetGitDiff(db))
+	app.Post("/api/workspace/git/revert/:sha", RevertGitCommit(db))
+
+	// SEO routes
+	app.Get("/api/seo/sitemap", GetSitemap(db))
+	app.Post("/api/seo/sitemap/generate", ValidateBody[SitemapRequest](), GenerateSitemap(db))
+
+	// Tool routes
+	app.Post("/api/tools/a11y", ValidateBody[A11yAuditRequest](), AuditAccessibility(db))
+	app.Get("/api/tools/a11y/reports", ListA11yReports(db))
+
 	// AI Command API routes (WebSocket-based)
-	app.Post("/api/ai/command", ExecuteAICommand(db))
-	app.Get("/api/ai/command/:commandId/stream", StreamAICommand(db))
+	app.Post("/api/ai/command", RejectWhenShuttingDown(), RequireScopeRole(), RateLimitAI(), ValidateBody[AICommandRequest](), ExecuteAICommand(db))
+	app.Get("/api/ai/command/:commandId/stream", RequireWebSocket(), RequireStreamToken(), RejectWhenShuttingDown(), StreamAICommand(db))
+	app.Post("/api/ai/command/:commandId/stream-token", IssueCommandStreamToken(db))
 	app.Get("/api/ai/command/:commandId/status", GetAICommandStatus(db))
-	app.Post("/api/ai/command/:commandId/interrupt", InterruptAICommand())
+	app.Get("/api/ai/command/:commandId/visual-diff", GetVisualDiff(db))
+	app.Get("/api/ai/command/:commandId/log", GetAICommandLog(db))
+	app.Get("/api/ai/command/:commandId/tools", GetCommandTools(db))
+	app.Get("/api/ai/result-schema", GetCommandResultSchema())
+	app.Post("/api/ai/translate", RejectWhenShuttingDown(), RateLimitAI(), TranslateContent(db))
+	app.Post("/api/ai/command/:commandId/interrupt", InterruptAICommand(db))
+	app.Post("/api/ai/command/:commandId/approve", RequireRole(RoleAdmin), ApproveAICommand(db))
+	app.Post("/api/ai/command/:commandId/reject", RequireRole(RoleAdmin), RejectAICommand(db))
+	app.Post("/api/ai/command/:commandId/rollback", RollbackAICommand(db))
+	app.Post("/api/ai/command/:commandId/undo", UndoAICommand(db))
+	app.Get("/api/ai/command/:commandId/diff", GetCommandDiff(db))
+	app.Post("/api/ai/command/:commandId/merge", MergeCommandBranch(db))
+	app.Post("/api/ai/command/:commandId/discard", DiscardCommandBranch(db))
+	app.Get("/api/ai/undo", GetUndoStack(db))
+	app.Post("/api/ai/undo", UndoPageCommands(db))
+	app.Post("/api/ai/command/:commandId/retry", RejectWhenShuttingDown(), RateLimitAI(), RetryAICommand(db))
+	app.Get("/api/ai/approvals", ListPendingApprovals(db))
+	app.Post("/api/ai/macros", RequireScopeRole(), CreateMacro(db))
+	app.Get("/api/ai/macros", ListMacros(db))
+	app.Get("/api/ai/macros/:id", GetMacro(db))
+	app.Put("/api/ai/macros/:id", RequireScopeRole(), UpdateMacro(db))
+	app.Delete("/api/ai/macros/:id", DeleteMacro(db))
+	app.Post("/api/ai/macros/:id/run", RejectWhenShuttingDown(), RateLimitAI(), RunMacro(db))
+	app.Get("/api/ai/prompts/recent", ListRecentPrompts(db))
+	app.Get("/api/ai/prompts/favorites", ListFavoritePrompts(db))
+	app.Post("/api/ai/prompts/favorites", AddFavoritePrompt(db))
+	app.Delete("/api/ai/prompts/favorites/:id", DeleteFavoritePrompt(db))
+	app.Post("/api/ai/batch", RejectWhenShuttingDown(), RateLimitAI(), CreateBatchCommand(db))
+	app.Get("/api/ai/batch/:id", GetBatchCommand(db))
+	app.Get("/api/ai/batch/:id/stream", RequireWebSocket(), RequireStreamToken(), StreamBatchCommand(db))
+	app.Post("/api/ai/batch/:id/interrupt", InterruptBatchCommand(db))
+	app.Get("/api/ai/usage", GetAIUsage(db))
+	app.Get("/api/ai/usage/budget", GetAIBudget(db))
+	app.Post("/api/ai/schedule", RequireScopeRole(), CreateSchedule(db))
+	app.Get("/api/ai/schedule", ListSchedules(db))
+	app.Get("/api/ai/schedule/:id", GetSchedule(db))
+	app.Delete("/api/ai/schedule/:id", DeleteSchedule(db))
+	app.Get("/api/ai/conversations", ListConversations(db))
+	app.Get("/api/ai/conversations/:conversationId", GetConversation(db))
 
 	// Generic AI Agent API routes (SSE-based for custom CLI commands)
-	app.Post("/api/agent/run", RunAgent())
+	// Builds and deployments also run as agent sessions, so reading sessions is left to
+	// editors; only the user who started a session and admins may interrupt it
+	app.Post("/api/agent/run", RequireRole(RoleAdmin), RejectWhenShuttingDown(), RateLimitAI(), ValidateBody[AgentRunRequest](), RunAgent(db))
 	app.Get("/api/agent/stream/:sessionId", StreamAgent())
-	app.Post("/api/agent/interrupt/:sessionId", InterruptAgent())
-	app.Get("/api/agent/status/:sessionId", GetAgentStatus())
-	app.Post("/api/agent/cleanup", CleanupSessions())
+	app.Post("/api/agent/interrupt/:sessionId", InterruptAgent(db))
+	app.Post("/api/agent/input/:sessionId", RequireRole(RoleAdmin), SendAgentInput())
+	app.Post("/api/agent/resize/:sessionId", RequireRole(RoleAdmin), ResizeAgent())
+	app.Get("/api/agent/output/:sessionId", GetAgentOutput())
+	app.Get("/api/agent/status/:sessionId", GetAgentStatus(db))
+	app.Post("/api/agent/cleanup", RequireRole(RoleAdmin), CleanupSessions(db))
+
+	// Build routes
+	app.Post("/api/build", RejectWhenShuttingDown(), StartBuild(db))
+	app.Get("/api/build/latest", GetLatestBuild(db))
+
+	// Preview server routes
+	app.Post("/api/preview/start", RejectWhenShuttingDown(), StartPreview(db))
+	app.Post("/api/preview/stop", StopPreview())
+	app.Get("/api/preview/status", GetPreviewStatus())
+	app.All("/preview/:projectId/*", PreviewProxy())
+
+	// Deployment routes
+	app.Post("/api/deploy", RejectWhenShuttingDown(), StartDeploy(db))
+	app.Post("/api/deploy/rollback", RejectWhenShuttingDown(), RollbackDeploy(db))
+	app.Get("/api/deploy", ListDeployments(db))
+	app.Get("/api/deploy/:id", GetDeployment(db))
+
+	// Activity feed of commands and their tools
+	app.Get("/api/activity", ListActivity(db))
+
+	// Admin routes
+	admin := app.Group("/api/admin", RequireRole(RoleAdmin))
+	admin.Get("/stats", GetAdminStats(db))
+	admin.Get("/config", GetConfig())
+	admin.Get("/users", ListUsers(db))
+	admin.Post("/users", CreateUser(db))
+	admin.Put("/users/:id", UpdateUser(db))
+	admin.Delete("/users/:id", DeleteUser(db))
+	admin.Post("/users/:id/token", RotateUserToken(db))
+	admin.Put("/users/:id/plan", ValidateBody[PlanAssignmentRequest](), SetUserPlan(db))
+	admin.Put("/projects/:id/plan", ValidateBody[PlanAssignmentRequest](), SetProjectPlan(db))
+	admin.Get("/plans", ListPlans(db))
+	admin.Post("/plans", ValidateBody[PlanRequest](), CreatePlan(db))
+	admin.Put("/plans/:id", ValidateBody[PlanRequest](), UpdatePlan(db))
+	admin.Delete("/plans/:id", DeletePlan(db))
+	admin.Get("/audit", ListAuditEvents(db))
+	admin.Post("/backup", CreateBackup(db))
+	admin.Get("/backups", ListBackups())
+	admin.Get("/backups/:name", DownloadBackup())
+	admin.Post("/restore", RestoreBackup(db))
+	admin.Get("/cleanup/stats", GetCleanupStats())
+	admin.Get("/queue", GetQueueStats())
+	admin.Get("/prompts", ListPrompts())
+	admin.Get("/prompts/:name", GetPrompt())
+	admin.Put("/prompts/:name", PutPrompt())
+	admin.Delete("/prompts/:name", DeletePrompt())
+
+	// API description, generated from the routes above
+	app.Get("/api/openapi.json", GetOpenAPISpec(app))
+	app.Get("/api/docs", GetAPIDocs())
+
+	// Drain sessions and stop gracefully on SIGINT/SIGTERM
+	shutdownDone := make(chan struct{})
+	go func() {
+		HandleShutdown(app)
+		close(shutdownDone)
+	}()
 
 	// Start server
-	port := ":9000"
-	log.Printf("Server started on %s\n", port)
-	log.Fatal(app.Listen(port))
+	ln, startRedirect, err := serverListener(appConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Server started on %s\n", ln.Addr())
+	startRedirect()
+	if err := app.Listener(ln); err != nil {
+		log.Fatal(err)
+	}
+
+	<-shutdownDone
+	closeDB(db)
+	log.Printf("👋 Server stopped")
 }
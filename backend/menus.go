@@ -0,0 +1,764 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxMenuItems caps the items of one menu, counting nested ones
+const maxMenuItems = 200
+
+// maxMenuDepth is the deepest nesting of a menu; top-level items are at depth 1
+const maxMenuDepth = 4
+
+// menuNamePattern matches menu names such as main or footer-links
+var menuNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Menu is a named navigation menu of the site, such as main or footer
+type Menu struct {
+	ID        string `gorm:"primaryKey" json:"id"`
+	Name      string `gorm:"uniqueIndex" json:"name"`
+	Title     string `json:"title,omitempty"`
+	CreatedBy string `json:"createdBy,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// MenuItem is one link of a menu. Items with a ParentID are nested under that item;
+// siblings are ordered by Position.
+type MenuItem struct {
+	ID        string `gorm:"primaryKey" json:"id"`
+	MenuID    string `gorm:"index" json:"menuId"`
+	ParentID  string `gorm:"index" json:"parentId,omitempty"` // Empty for top-level items
+	Position  int    `json:"position"`
+	Label     string `json:"label"`
+	URL       string `json:"url"`
+	Target    string `json:"target,omitempty"` // _self or _blank
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// MenuNode is an item of a rendered menu with its nested items
+type MenuNode struct {
+	ID       string     `json:"id"`
+	Label    string     `json:"label"`
+	URL      string     `json:"url"`
+	Target   string     `json:"target,omitempty"`
+	Children []MenuNode `json:"children"`
+}
+
+// MenuTree is a menu rendered as nested items, the response of GET /api/menus/:name
+type MenuTree struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Title     string     `json:"title,omitempty"`
+	UpdatedAt int64      `json:"updatedAt"`
+	Items     []MenuNode `json:"items"`
+}
+
+// MenuSummary is a menu in the response of GET /api/menus
+type MenuSummary struct {
+	Menu
+	ItemCount int64 `json:"itemCount"`
+}
+
+// MenuList is the data of GET /api/menus
+type MenuList struct {
+	Menus []MenuSummary `json:"menus"`
+}
+
+// MenuNodeRequest is an item of a menu tree sent as a whole. An ID of an item
+// already in the menu keeps that item; other items get new IDs.
+type MenuNodeRequest struct {
+	ID       string            `json:"id,omitempty" validate:"max=64"`
+	Label    string            `json:"label" validate:"required,max=200,singleline"`
+	URL      string            `json:"url" validate:"required,max=2048,singleline"`
+	Target   string            `json:"target,omitempty" validate:"omitempty,oneof=_self _blank"`
+	Children []MenuNodeRequest `json:"children,omitempty" validate:"max=200,dive"`
+}
+
+// MenuRequest is the body of POST /api/menus
+type MenuRequest struct {
+	Name  string            `json:"name" validate:"required,menuname"`
+	Title string            `json:"title,omitempty" validate:"max=200"`
+	Items []MenuNodeRequest `json:"items,omitempty" validate:"max=200,dive"`
+}
+
+// MenuTreeRequest is the body of PUT /api/menus/:name, which replaces the title and
+// every item of the menu
+type MenuTreeRequest struct {
+	Title string            `json:"title,omitempty" validate:"max=200"`
+	Items []MenuNodeRequest `json:"items" validate:"max=200,dive"`
+}
+
+// MenuItemRequest is the body of POST and PUT /api/menus/:name/items
+type MenuItemRequest struct {
+	Label    string `json:"label" validate:"required,max=200,singleline"`
+	URL      string `json:"url" validate:"required,max=2048,singleline"`
+	Target   string `json:"target,omitempty" validate:"omitempty,oneof=_self _blank"`
+	ParentID string `json:"parentId,omitempty" validate:"max=64"`          // Item to nest under; top level when empty
+	Position *int   `json:"position,omitempty" validate:"omitempty,min=0"` // Index among the siblings; last when omitted
+}
+
+// MenuOrder places one item in POST /api/menus/:name/reorder
+type MenuOrder struct {
+	ID       string `json:"id" validate:"required,max=64"`
+	ParentID string `json:"parentId,omitempty" validate:"max=64"`
+}
+
+// MenuReorderRequest is the body of POST /api/menus/:name/reorder. It lists every
+// item of the menu; siblings are ordered as they appear in the list.
+type MenuReorderRequest struct {
+	Items []MenuOrder `json:"items" validate:"required,max=200,dive"`
+}
+
+// menuLinkSchemes are the URL schemes a menu item may link to; relative URLs and
+// fragments have none
+var menuLinkSchemes = map[string]bool{"": true, "http": true, "https": true, "mailto": true, "tel": true}
+
+// checkMenuLink rejects URLs that could run scripts when the menu is rendered
+func checkMenuLink(link string) error {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || !menuLinkSchemes[strings.ToLower(parsed.Scheme)] {
+		return newAPIError(400, "INVALID_MENU_URL", "Menu links must be relative or use http, https, mailto or tel", link)
+	}
+	return nil
+}
+
+// checkMenuNodes checks the links, nesting and size of a menu tree
+func checkMenuNodes(nodes []MenuNodeRequest) error {
+	count := 0
+	seen := make(map[string]bool)
+	var walk func(nodes []MenuNodeRequest, depth int) error
+	walk = func(nodes []MenuNodeRequest, depth int) error {
+		if len(nodes) > 0 && depth > maxMenuDepth {
+			return newAPIError(400, "MENU_TOO_DEEP", "Menus can be nested at most this many levels", maxMenuDepth)
+		}
+		for _, node := range nodes {
+			if count++; count > maxMenuItems {
+				return newAPIError(400, "TOO_MANY_MENU_ITEMS", "A menu can have at most this many items", maxMenuItems)
+			}
+			if node.ID != "" {
+				if seen[node.ID] {
+					return newAPIError(400, "DUPLICATE_MENU_ITEM", "Every item of the menu needs an ID of its own", node.ID)
+				}
+				seen[node.ID] = true
+			}
+			if err := checkMenuLink(node.URL); err != nil {
+				return err
+			}
+			if err := walk(node.Children, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(nodes, 1)
+}
+
+// findMenu loads a menu by name
+func findMenu(db *gorm.DB, name string) (Menu, error) {
+	var menu Menu
+	if err := db.Limit(1).Find(&menu, "name = ?", name).Error; err != nil {
+		return menu, newAPIError(500, "DATABASE_ERROR", "Failed to load menu", err.Error())
+	}
+	if menu.ID == "" {
+		return menu, newAPIError(404, "MENU_NOT_FOUND", "Menu not found", name)
+	}
+	return menu, nil
+}
+
+// loadMenuItems returns the items of a menu ordered by parent and position
+func loadMenuItems(db *gorm.DB, menuID string) ([]MenuItem, error) {
+	var items []MenuItem
+	err := db.Where("menu_id = ?", menuID).Order("parent_id, position, created_at").Find(&items).Error
+	return items, err
+}
+
+// buildMenuTree nests the items of a menu under their parents
+func buildMenuTree(menu Menu, items []MenuItem) MenuTree {
+	children := make(map[string][]MenuItem)
+	for _, item := range items {
+		children[item.ParentID] = append(children[item.ParentID], item)
+	}
+	var nodes func(parentID string) []MenuNode
+	nodes = func(parentID string) []MenuNode {
+		siblings := children[parentID]
+		sort.SliceStable(siblings, func(i, j int) bool { return siblings[i].Position < siblings[j].Position })
+		result := make([]MenuNode, 0, len(siblings))
+		for _, item := range siblings {
+			result = append(result, MenuNode{
+				ID:       item.ID,
+				Label:    item.Label,
+				URL:      item.URL,
+				Target:   item.Target,
+				Children: nodes(item.ID),
+			})
+		}
+		return result
+	}
+	return MenuTree{
+		ID:        menu.ID,
+		Name:      menu.Name,
+		Title:     menu.Title,
+		UpdatedAt: menu.UpdatedAt,
+		Items:     nodes(""),
+	}
+}
+
+// loadMenuTree renders a menu as nested items
+func loadMenuTree(db *gorm.DB, menu Menu) (MenuTree, error) {
+	items, err := loadMenuItems(db, menu.ID)
+	if err != nil {
+		return MenuTree{}, err
+	}
+	return buildMenuTree(menu, items), nil
+}
+
+// replaceMenuItems replaces every item of a menu with a tree. Items keep their IDs
+// when the tree names them; the tree must have passed checkMenuNodes.
+func replaceMenuItems(tx *gorm.DB, menu *Menu, nodes []MenuNodeRequest) error {
+	existing, err := loadMenuItems(tx, menu.ID)
+	if err != nil {
+		return err
+	}
+	created := make(map[string]int64, len(existing))
+	for _, item := range existing {
+		created[item.ID] = item.CreatedAt
+	}
+
+	now := time.Now().Unix()
+	var items []MenuItem
+	var flatten func(nodes []MenuNodeRequest, parentID string)
+	flatten = func(nodes []MenuNodeRequest, parentID string) {
+		for i, node := range nodes {
+			item := MenuItem{
+				ID:        node.ID,
+				MenuID:    menu.ID,
+				ParentID:  parentID,
+				Position:  i,
+				Label:     strings.TrimSpace(node.Label),
+				URL:       strings.TrimSpace(node.URL),
+				Target:    node.Target,
+				CreatedAt: created[node.ID],
+				UpdatedAt: now,
+			}
+			if _, ok := created[node.ID]; !ok {
+				item.ID = newMenuItemID()
+				item.CreatedAt = now
+			}
+			items = append(items, item)
+			flatten(node.Children, item.ID)
+		}
+	}
+	flatten(nodes, "")
+
+	if err := tx.Where("menu_id = ?", menu.ID).Delete(&MenuItem{}).Error; err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		if err := tx.Create(&items).Error; err != nil {
+			return err
+		}
+	}
+	return touchMenu(tx, menu)
+}
+
+// touchMenu bumps the update time of a menu
+func touchMenu(tx *gorm.DB, menu *Menu) error {
+	menu.UpdatedAt = time.Now().Unix()
+	return tx.Model(&Menu{}).Where("id = ?", menu.ID).Update("updated_at", menu.UpdatedAt).Error
+}
+
+// newMenuItemID returns the ID of a new menu item
+func newMenuItemID() string {
+	return "mi_" + uuid.New().String()[:8]
+}
+
+// menuItemDepth returns the depth of an item, 1 for top-level items
+func menuItemDepth(byID map[string]MenuItem, id string) int {
+	depth := 0
+	for id != "" && depth <= maxMenuItems {
+		depth++
+		id = byID[id].ParentID
+	}
+	return depth
+}
+
+// menuSubtreeHeight returns the levels taken by an item and its nested items
+func menuSubtreeHeight(items []MenuItem, id string) int {
+	height := 1
+	for _, item := range items {
+		if item.ParentID == id {
+			if h := menuSubtreeHeight(items, item.ID) + 1; h > height {
+				height = h
+			}
+		}
+	}
+	return height
+}
+
+// placeMenuItem moves an item to position among the children of parentID, shifting
+// its new and old siblings; a nil position places it last
+func placeMenuItem(tx *gorm.DB, items []MenuItem, item *MenuItem, parentID string, position *int) error {
+	var siblings []MenuItem
+	oldParent := item.ParentID
+	for _, other := range items {
+		if other.ParentID == parentID && other.ID != item.ID {
+			siblings = append(siblings, other)
+		}
+	}
+	sort.SliceStable(siblings, func(i, j int) bool { return siblings[i].Position < siblings[j].Position })
+
+	index := len(siblings)
+	if position != nil && *position < index {
+		index = *position
+	}
+	item.ParentID = parentID
+	ordered := append(append(append([]MenuItem{}, siblings[:index]...), *item), siblings[index:]...)
+	for i, sibling := range ordered {
+		if sibling.ID == item.ID {
+			item.Position = i
+			continue
+		}
+		if sibling.Position != i {
+			if err := tx.Model(&MenuItem{}).Where("id = ?", sibling.ID).Update("position", i).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if oldParent != parentID && item.CreatedAt != 0 {
+		return renumberMenuSiblings(tx, items, oldParent, item.ID)
+	}
+	return nil
+}
+
+// renumberMenuSiblings closes the gap left among the children of parentID by an item
+// that moved away or was deleted
+func renumberMenuSiblings(tx *gorm.DB, items []MenuItem, parentID, removedID string) error {
+	var siblings []MenuItem
+	for _, other := range items {
+		if other.ParentID == parentID && other.ID != removedID {
+			siblings = append(siblings, other)
+		}
+	}
+	sort.SliceStable(siblings, func(i, j int) bool { return siblings[i].Position < siblings[j].Position })
+	for i, sibling := range siblings {
+		if sibling.Position != i {
+			if err := tx.Model(&MenuItem{}).Where("id = ?", sibling.ID).Update("position", i).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkMenuParent checks that an item of depth height can be nested under parentID
+func checkMenuParent(items []MenuItem, parentID, itemID string, height int) error {
+	if parentID == "" {
+		return nil
+	}
+	byID := make(map[string]MenuItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	if _, ok := byID[parentID]; !ok {
+		return newAPIError(400, "INVALID_PARENT", "The parent is not an item of this menu", parentID)
+	}
+	for id := parentID; id != ""; id = byID[id].ParentID {
+		if id == itemID {
+			return newAPIError(400, "INVALID_PARENT", "An item cannot be nested under itself", parentID)
+		}
+	}
+	if menuItemDepth(byID, parentID)+height > maxMenuDepth {
+		return newAPIError(400, "MENU_TOO_DEEP", "Menus can be nested at most this many levels", maxMenuDepth)
+	}
+	return nil
+}
+
+// sendMenuTree responds with the current tree of a menu
+func sendMenuTree(c *fiber.Ctx, db *gorm.DB, menu Menu, status int) error {
+	tree, err := loadMenuTree(db, menu)
+	if err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to load menu items", err.Error())
+	}
+	return c.Status(status).JSON(APIResponse[MenuTree]{
+		Success: true,
+		Data:    tree,
+	})
+}
+
+// ListMenus returns the menus by name with the number of items of each
+func ListMenus(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var menus []Menu
+		if err := db.Order("name").Find(&menus).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load menus", err.Error())
+		}
+		var counts []struct {
+			MenuID string
+			Count  int64
+		}
+		if err := db.Model(&MenuItem{}).Select("menu_id, COUNT(*) AS count").Group("menu_id").Scan(&counts).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to count menu items", err.Error())
+		}
+		itemCounts := make(map[string]int64, len(counts))
+		for _, count := range counts {
+			itemCounts[count.MenuID] = count.Count
+		}
+
+		summaries := make([]MenuSummary, 0, len(menus))
+		for _, menu := range menus {
+			summaries = append(summaries, MenuSummary{Menu: menu, ItemCount: itemCounts[menu.ID]})
+		}
+		return c.JSON(APIResponse[MenuList]{
+			Success: true,
+			Data:    MenuList{Menus: summaries},
+		})
+	}
+}
+
+// CreateMenu adds a menu, optionally with its items
+func CreateMenu(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[MenuRequest](c)
+		if err := checkMenuNodes(req.Items); err != nil {
+			return err
+		}
+		if existing, err := findMenu(db, req.Name); err == nil {
+			return sendError(c, 409, "MENU_EXISTS", "A menu with this name already exists", existing.Name)
+		}
+
+		now := time.Now().Unix()
+		menu := Menu{
+			ID:        "menu_" + uuid.New().String()[:8],
+			Name:      req.Name,
+			Title:     strings.TrimSpace(req.Title),
+			CreatedBy: currentUserID(c),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&menu).Error; err != nil {
+				return err
+			}
+			return replaceMenuItems(tx, &menu, req.Items)
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create menu", err.Error())
+		}
+
+		log.Printf("🧭 Menu %s created", menu.Name)
+		return sendMenuTree(c, db, menu, 201)
+	}
+}
+
+// GetMenu returns a menu rendered as nested items
+func GetMenu(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		menu, err := findMenu(db, c.Params("name"))
+		if err != nil {
+			return err
+		}
+		return sendMenuTree(c, db, menu, 200)
+	}
+}
+
+// UpdateMenu replaces the title and every item of a menu
+func UpdateMenu(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		menu, err := findMenu(db, c.Params("name"))
+		if err != nil {
+			return err
+		}
+		req := validatedBody[MenuTreeRequest](c)
+		if err := checkMenuNodes(req.Items); err != nil {
+			return err
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			menu.Title = strings.TrimSpace(req.Title)
+			if err := tx.Model(&Menu{}).Where("id = ?", menu.ID).Update("title", menu.Title).Error; err != nil {
+				return err
+			}
+			return replaceMenuItems(tx, &menu, req.Items)
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update menu", err.Error())
+		}
+		return sendMenuTree(c, db, menu, 200)
+	}
+}
+
+// DeleteMenu removes a menu and its items
+func DeleteMenu(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		menu, err := findMenu(db, c.Params("name"))
+		if err != nil {
+			return err
+		}
+		before, err := loadMenuTree(db, menu)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load menu items", err.Error())
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("menu_id = ?", menu.ID).Delete(&MenuItem{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&Menu{}, "id = ?", menu.ID).Error
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete menu", err.Error())
+		}
+
+		recordAudit(db, c, AuditMenuDelete, menu.Name, before, nil, menu.Title)
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: menu.ID, Deleted: true},
+		})
+	}
+}
+
+// CreateMenuItem adds an item to a menu
+func CreateMenuItem(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		menu, err := findMenu(db, c.Params("name"))
+		if err != nil {
+			return err
+		}
+		req := validatedBody[MenuItemRequest](c)
+		if err := checkMenuLink(req.URL); err != nil {
+			return err
+		}
+		items, err := loadMenuItems(db, menu.ID)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load menu items", err.Error())
+		}
+		if len(items) >= maxMenuItems {
+			return sendError(c, 400, "TOO_MANY_MENU_ITEMS", "A menu can have at most this many items", maxMenuItems)
+		}
+		if err := checkMenuParent(items, req.ParentID, "", 1); err != nil {
+			return err
+		}
+
+		now := time.Now().Unix()
+		item := MenuItem{
+			ID:        newMenuItemID(),
+			MenuID:    menu.ID,
+			Label:     strings.TrimSpace(req.Label),
+			URL:       strings.TrimSpace(req.URL),
+			Target:    req.Target,
+			UpdatedAt: now,
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := placeMenuItem(tx, items, &item, req.ParentID, req.Position); err != nil {
+				return err
+			}
+			item.CreatedAt = now
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+			return touchMenu(tx, &menu)
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to add menu item", err.Error())
+		}
+
+		return c.Status(201).JSON(APIResponse[MenuItem]{
+			Success: true,
+			Data:    item,
+		})
+	}
+}
+
+// UpdateMenuItem changes an item of a menu; a new parentId or position moves it
+func UpdateMenuItem(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		menu, err := findMenu(db, c.Params("name"))
+		if err != nil {
+			return err
+		}
+		req := validatedBody[MenuItemRequest](c)
+		if err := checkMenuLink(req.URL); err != nil {
+			return err
+		}
+		items, err := loadMenuItems(db, menu.ID)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load menu items", err.Error())
+		}
+		var item *MenuItem
+		for i := range items {
+			if items[i].ID == c.Params("id") {
+				item = &items[i]
+			}
+		}
+		if item == nil {
+			return sendError(c, 404, "MENU_ITEM_NOT_FOUND", "Menu item not found", c.Params("id"))
+		}
+		moved := req.ParentID != item.ParentID || req.Position != nil
+		if moved {
+			if err := checkMenuParent(items, req.ParentID, item.ID, menuSubtreeHeight(items, item.ID)); err != nil {
+				return err
+			}
+		}
+
+		item.Label = strings.TrimSpace(req.Label)
+		item.URL = strings.TrimSpace(req.URL)
+		item.Target = req.Target
+		item.UpdatedAt = time.Now().Unix()
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if moved {
+				if err := placeMenuItem(tx, items, item, req.ParentID, req.Position); err != nil {
+					return err
+				}
+			}
+			if err := tx.Save(item).Error; err != nil {
+				return err
+			}
+			return touchMenu(tx, &menu)
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update menu item", err.Error())
+		}
+
+		return c.JSON(APIResponse[MenuItem]{
+			Success: true,
+			Data:    *item,
+		})
+	}
+}
+
+// DeleteMenuItem removes an item of a menu together with the items nested under it
+func DeleteMenuItem(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		menu, err := findMenu(db, c.Params("name"))
+		if err != nil {
+			return err
+		}
+		items, err := loadMenuItems(db, menu.ID)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load menu items", err.Error())
+		}
+		id := c.Params("id")
+		var item *MenuItem
+		for i := range items {
+			if items[i].ID == id {
+				item = &items[i]
+			}
+		}
+		if item == nil {
+			return sendError(c, 404, "MENU_ITEM_NOT_FOUND", "Menu item not found", id)
+		}
+
+		// Collect the nested items level by level
+		removed := []string{id}
+		for i := 0; i < len(removed); i++ {
+			for _, other := range items {
+				if other.ParentID == removed[i] {
+					removed = append(removed, other.ID)
+				}
+			}
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("id IN ?", removed).Delete(&MenuItem{}).Error; err != nil {
+				return err
+			}
+			if err := renumberMenuSiblings(tx, items, item.ParentID, id); err != nil {
+				return err
+			}
+			return touchMenu(tx, &menu)
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete menu item", err.Error())
+		}
+
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: id, Deleted: true},
+		})
+	}
+}
+
+// ReorderMenu rearranges every item of a menu at once, as after a drag and drop
+func ReorderMenu(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		menu, err := findMenu(db, c.Params("name"))
+		if err != nil {
+			return err
+		}
+		req := validatedBody[MenuReorderRequest](c)
+		items, err := loadMenuItems(db, menu.ID)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load menu items", err.Error())
+		}
+
+		byID := make(map[string]MenuItem, len(items))
+		for _, item := range items {
+			byID[item.ID] = item
+		}
+		placed := make(map[string]MenuItem, len(req.Items))
+		positions := make(map[string]int)
+		for _, order := range req.Items {
+			item, ok := byID[order.ID]
+			if !ok {
+				return sendError(c, 400, "MENU_ITEM_NOT_FOUND", "The menu has no such item", order.ID)
+			}
+			if _, dup := placed[order.ID]; dup {
+				return sendError(c, 400, "DUPLICATE_MENU_ITEM", "Every item must be listed once", order.ID)
+			}
+			if _, ok := byID[order.ParentID]; order.ParentID != "" && !ok {
+				return sendError(c, 400, "INVALID_PARENT", "The parent is not an item of this menu", order.ParentID)
+			}
+			item.ParentID = order.ParentID
+			item.Position = positions[order.ParentID]
+			positions[order.ParentID]++
+			placed[order.ID] = item
+		}
+		if len(placed) != len(items) {
+			missing := []string{}
+			for _, item := range items {
+				if _, ok := placed[item.ID]; !ok {
+					missing = append(missing, item.ID)
+				}
+			}
+			return sendError(c, 400, "REORDER_INCOMPLETE", "Every item of the menu must be listed", missing)
+		}
+		for id := range placed {
+			if menuItemDepth(placed, id) > maxMenuDepth {
+				if menuItemDepth(placed, id) > len(placed) {
+					return sendError(c, 400, "INVALID_PARENT", "An item cannot be nested under itself", id)
+				}
+				return sendError(c, 400, "MENU_TOO_DEEP", "Menus can be nested at most this many levels", maxMenuDepth)
+			}
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, item := range placed {
+				old := byID[item.ID]
+				if old.ParentID == item.ParentID && old.Position == item.Position {
+					continue
+				}
+				err := tx.Model(&MenuItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+					"parent_id": item.ParentID,
+					"position":  item.Position,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return touchMenu(tx, &menu)
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to reorder menu", err.Error())
+		}
+		return sendMenuTree(c, db, menu, 200)
+	}
+}
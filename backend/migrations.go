@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaMigration records a migration that was applied to the database
+type SchemaMigration struct {
+	ID        string `gorm:"primaryKey;size:191"`
+	AppliedAt int64
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migration is one versioned change of the schema. Rollback is nil for a migration that
+// can't be undone.
+type migration struct {
+	ID          string // Date and a short name, e.g. 20261014_baseline
+	Description string
+	Migrate     func(tx *gorm.DB) error
+	Rollback    func(tx *gorm.DB) error
+}
+
+// baselineModels are the tables of the schema before versioned migrations. Tables added
+// later get a migration of their own instead of joining this list.
+var baselineModels = []interface{}{
+	&Content{}, &AICommand{}, &Page{}, &Conversation{}, &Asset{}, &AssetVariant{}, &Project{}, &ProjectEnvVar{},
+	&CommandLogEntry{}, &ScheduledCommand{}, &NotificationChannel{}, &Build{}, &Deployment{}, &User{}, &AuditEvent{},
+	&BatchCommand{}, &PromptFavorite{}, &Macro{}, &CommandUndoEntry{}, &SessionRecord{}, &Template{}, &Menu{},
+	&MenuItem{}, &FormSubmission{}, &ProjectEmailSettings{}, &PageMeta{}, &A11yReport{}, &VisualDiff{},
+}
+
+// migrations lists the schema changes in the order they are applied. Append new ones and
+// never change one that has shipped. The baseline creates its tables from the current
+// models, so on a new database a later change to one of them is already there; check
+// with HasColumn before adding a column to a baseline table.
+var migrations = []migration{
+	{
+		ID:          "20261014_content_locale",
+		Description: "Key content blocks by ID and locale",
+		Migrate: func(tx *gorm.DB) error {
+			return migrateContentLocale(tx, getDBDriver())
+		},
+	},
+	{
+		ID:          "20261014_baseline",
+		Description: "Create the tables of the baseline schema",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(baselineModels...)
+		},
+	},
+	{
+		ID:          "20261014_activity_events",
+		Description: "Create the table of the activity feed",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ActivityEvent{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&ActivityEvent{})
+		},
+	},
+	{
+		ID:          "20261014_tool_events",
+		Description: "Create the table of the tools commands ran",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ToolEvent{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&ToolEvent{})
+		},
+	},
+	{
+		ID:          "20261014_user_accounts",
+		Description: "Add passwords and linked OAuth accounts to users and create the table of sessions",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&User{}, &AuthSession{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			for _, column := range []string{"PasswordHash", "EmailVerified", "GitHubID", "GoogleID", "AvatarURL", "LastLoginAt"} {
+				if tx.Migrator().HasColumn(&User{}, column) {
+					if err := tx.Migrator().DropColumn(&User{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Migrator().DropTable(&AuthSession{})
+		},
+	},
+	{
+		ID:          "20261014_teams",
+		Description: "Create the tables of project teams and invites and record the project of pages",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Page{}, &TeamMember{}, &TeamInvite{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Page{}, "ProjectID") {
+				if err := tx.Migrator().DropColumn(&Page{}, "ProjectID"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&TeamMember{}, &TeamInvite{})
+		},
+	},
+	{
+		ID:          "20261014_quota_plans",
+		Description: "Create the table of quota plans, give them to users and projects and record the CLI time of commands",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Plan{}, &User{}, &Project{}, &AICommand{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			columns := []struct {
+				model  interface{}
+				column string
+			}{{&User{}, "PlanID"}, {&Project{}, "PlanID"}, {&AICommand{}, "RunMs"}}
+			for _, c := range columns {
+				if tx.Migrator().HasColumn(c.model, c.column) {
+					if err := tx.Migrator().DropColumn(c.model, c.column); err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Migrator().DropTable(&Plan{})
+		},
+	},
+}
+
+// isAutoMigrateEnabled returns false when DB_AUTO_MIGRATE is set to false
+func isAutoMigrateEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("DB_AUTO_MIGRATE"))
+	return err != nil || enabled
+}
+
+// appliedMigrations returns the IDs of the migrations recorded in the database
+func appliedMigrations(db *gorm.DB) ([]string, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var ids []string
+	err := db.Model(&SchemaMigration{}).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// pendingMigrations returns the migrations that are not applied yet, in order
+func pendingMigrations(applied []string) []migration {
+	var pending []migration
+	for _, m := range migrations {
+		if !slices.Contains(applied, m.ID) {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// unknownMigrations returns the applied migrations this build doesn't have, which means
+// a newer version migrated the database
+func unknownMigrations(applied []string) []string {
+	var unknown []string
+	for _, id := range applied {
+		if !slices.ContainsFunc(migrations, func(m migration) bool { return m.ID == id }) {
+			unknown = append(unknown, id)
+		}
+	}
+	return unknown
+}
+
+// migrationState returns the migrations that are not applied yet, in order, and the IDs
+// of the applied ones. A database with migrations this build doesn't know was migrated
+// by a newer version and is refused.
+func migrationState(db *gorm.DB) ([]migration, []string, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if unknown := unknownMigrations(applied); len(unknown) > 0 {
+		return nil, nil, fmt.Errorf("the database has migrations this version doesn't know (%s); use a newer version", strings.Join(unknown, ", "))
+	}
+	return pendingMigrations(applied), applied, nil
+}
+
+// checkMigrations brings the schema up to date on startup. Pending migrations run unless
+// DB_AUTO_MIGRATE is false, when the server refuses to start until they were applied
+// with --migrate.
+func checkMigrations(db *gorm.DB) error {
+	if isAutoMigrateEnabled() {
+		_, err := applyMigrations(db)
+		return err
+	}
+	pending, _, err := migrationState(db)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	ids := make([]string, len(pending))
+	for i, m := range pending {
+		ids[i] = m.ID
+	}
+	return fmt.Errorf("%d pending migration(s) (%s); apply them with --migrate", len(pending), strings.Join(ids, ", "))
+}
+
+// applyMigrations applies the pending migrations, each in a transaction of its own, and
+// returns how many ran. An existing SQLite database is backed up first.
+func applyMigrations(db *gorm.DB) (int, error) {
+	pending, _, err := migrationState(db)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	if db.Migrator().HasTable(&Content{}) {
+		if path, err := backupSQLite(db, pending[0].ID); err != nil {
+			return 0, fmt.Errorf("failed to back up the database before migrating: %w", err)
+		} else if path != "" {
+			log.Printf("💾 Database backed up to %s before migrating", path)
+		}
+	}
+
+	for _, m := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Migrate(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{ID: m.ID, AppliedAt: time.Now().Unix()}).Error
+		})
+		if err != nil {
+			return 0, fmt.Errorf("migration %s failed: %w", m.ID, err)
+		}
+		log.Printf("📦 Applied migration %s: %s", m.ID, m.Description)
+	}
+	return len(pending), nil
+}
+
+// rollbackMigrations undoes the last n applied migrations, newest first. It stops at a
+// migration without a rollback.
+func rollbackMigrations(db *gorm.DB, n int) error {
+	_, applied, err := migrationState(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && n > 0; i-- {
+		m := migrations[i]
+		if !slices.Contains(applied, m.ID) {
+			continue
+		}
+		if m.Rollback == nil {
+			return fmt.Errorf("migration %s (%s) can't be rolled back", m.ID, m.Description)
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Rollback(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{ID: m.ID}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rollback of %s failed: %w", m.ID, err)
+		}
+		log.Printf("↩️ Rolled back migration %s: %s", m.ID, m.Description)
+		n--
+	}
+	if n > 0 {
+		return errors.New("no more migrations to roll back")
+	}
+	return nil
+}
+
+// backupSQLite copies a SQLite database file next to itself before the migration and
+// returns the path of the copy; other databases are left to their own backups
+func backupSQLite(db *gorm.DB, before string) (string, error) {
+	driver := getDBDriver()
+	if driver != "sqlite" && driver != "sqlite3" {
+		return "", nil
+	}
+	dsn := getDatabaseURL()
+	if isMemorySQLite(dsn) {
+		return "", nil
+	}
+	file, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	path := fmt.Sprintf("%s.before-%s.bak", file, before)
+	os.Remove(path) // VACUUM INTO refuses to overwrite
+	return path, db.Exec("VACUUM INTO ?", path).Error
+}
+
+// runMigrationCommand handles --migrate and --rollback, which change the schema and exit
+// without starting the server
+func runMigrationCommand(rollback int) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+
+	if rollback > 0 {
+		return rollbackMigrations(db, rollback)
+	}
+	n, err := applyMigrations(db)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		log.Printf("📦 The database schema is up to date")
+	}
+	return nil
+}
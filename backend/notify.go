@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// NotificationSummary describes a finished command for chat notifications
+type NotificationSummary struct {
+	CommandID    string
+	Prompt       string
+	Status       string
+	Scope        string
+	Page         string
+	ProjectID    string
+	Duration     time.Duration
+	ChangedFiles []string
+	Error        string
+}
+
+// Notifier delivers a summary to a chat service
+type Notifier interface {
+	Send(ctx context.Context, summary NotificationSummary) error
+}
+
+// NotificationChannel is a chat webhook that receives summaries of a project's commands
+type NotificationChannel struct {
+	ID          uint     `gorm:"primaryKey" json:"id"`
+	ProjectID   string   `gorm:"index" json:"projectId"`
+	Driver      string   `json:"driver"`                          // slack, discord or email
+	WebhookURL  string   `gorm:"type:text" json:"-"`              // encryptSecret output; a mailto: list for email
+	MinDuration int      `json:"minDurationSeconds"`              // Only commands running at least this long are reported
+	Statuses    []string `gorm:"serializer:json" json:"statuses"` // Final statuses to report; empty means all
+	CreatedAt   int64    `json:"createdAt"`
+}
+
+// NotificationChannelRequest is the body of POST /api/projects/:id/notifications
+type NotificationChannelRequest struct {
+	Driver      string   `json:"driver"`
+	WebhookURL  string   `json:"webhookUrl,omitempty"`
+	To          []string `json:"to,omitempty"`                 // Recipients of the email driver
+	MinDuration *int     `json:"minDurationSeconds,omitempty"` // Defaults to NOTIFY_MIN_DURATION
+	Statuses    []string `json:"statuses,omitempty"`
+}
+
+// publicDialer refuses to connect to internal addresses, so a destination that users
+// configure and whose name resolves (or later re-resolves, or redirects) to the host's
+// own network is never reached
+var publicDialer = &net.Dialer{
+	Timeout: 5 * time.Second,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || isInternalIP(ip) {
+			return fmt.Errorf("address %s is not a public address", host)
+		}
+		return nil
+	},
+}
+
+// notifyClient posts to webhooks through publicDialer
+var notifyClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         publicDialer.DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// isInternalIP reports whether ip is a loopback, private, link-local or otherwise
+// non-public address that webhooks and project mail servers must not reach
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// checkPublicHost resolves a host that users configure, such as that of a webhook URL,
+// and fails when any of its addresses is internal
+func checkPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s", host)
+	}
+	for _, addr := range addrs {
+		if isInternalIP(addr.IP) {
+			return fmt.Errorf("%s resolves to the internal address %s", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// truncateRunes cuts s to max characters, ending it with "..." when it was longer
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// webhookNotifier posts a JSON message built by payload to a webhook URL
+type webhookNotifier struct {
+	url     string
+	payload func(text string) interface{}
+}
+
+func (n webhookNotifier) Send(ctx context.Context, summary NotificationSummary) error {
+	body, err := json.Marshal(n.payload(formatNotification(summary)))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// emailNotifier emails the summary with the email settings of the project
+type emailNotifier struct {
+	db        *gorm.DB
+	projectID string
+	to        []string
+}
+
+func (n emailNotifier) Send(ctx context.Context, summary NotificationSummary) error {
+	prompt := truncateRunes(strings.ReplaceAll(summary.Prompt, "\n", " "), 60)
+	return sendEmail(ctx, n.db, n.projectID, EmailMessage{
+		To:      n.to,
+		Subject: fmt.Sprintf("AI command %s: %s", strings.ReplaceAll(summary.Status, "_", " "), prompt),
+		Text:    formatNotification(summary) + "\n",
+	})
+}
+
+// newNotifier returns the driver for a channel of a project. The target is the webhook
+// URL, or a mailto: list of recipients for email.
+func newNotifier(db *gorm.DB, projectID, driver, target string) (Notifier, error) {
+	webhookURL := target
+	switch driver {
+	case "email":
+		return emailNotifier{db: db, projectID: projectID, to: splitAddresses(strings.TrimPrefix(target, "mailto:"))}, nil
+	case "slack":
+		return webhookNotifier{url: webhookURL, payload: func(text string) interface{} {
+			return fiber.Map{"text": text}
+		}}, nil
+	case "discord":
+		return webhookNotifier{url: webhookURL, payload: func(text string) interface{} {
+			// Discord rejects messages over 2000 characters
+			return fiber.Map{"content": truncateRunes(text, 2000)}
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown notification driver %q (use slack, discord or email)", driver)
+}
+
+// formatNotification renders the summary as a short chat message
+func formatNotification(s NotificationSummary) string {
+	icon := "✅"
+	switch s.Status {
+	case "failed", "timed_out", StatusResourceLimit, "rejected":
+		icon = "❌"
+	case "interrupted":
+		icon = "⏹️"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s AI command %s in %s\n", icon, strings.ReplaceAll(s.Status, "_", " "), s.Duration.Round(time.Second))
+	fmt.Fprintf(&b, "> %s\n", strings.ReplaceAll(truncateRunes(s.Prompt, 300), "\n", " "))
+
+	details := []string{"Scope: " + s.Scope}
+	if s.ProjectID != "" {
+		details = append(details, "Project: "+s.ProjectID)
+	}
+	if s.Page != "" {
+		details = append(details, "Page: "+s.Page)
+	}
+	details = append(details, "Command: "+s.CommandID)
+	b.WriteString(strings.Join(details, " · "))
+
+	if len(s.ChangedFiles) > 0 {
+		files := s.ChangedFiles
+		more := ""
+		if len(files) > 10 {
+			more = fmt.Sprintf(" and %d more", len(files)-10)
+			files = files[:10]
+		}
+		fmt.Fprintf(&b, "\nChanged files: %s%s", strings.Join(files, ", "), more)
+	}
+	if s.Error != "" {
+		fmt.Fprintf(&b, "\nError: %s", s.Error)
+	}
+	return b.String()
+}
+
+// getNotifyMinDuration returns the default reporting threshold (notify.minDuration, NOTIFY_MIN_DURATION)
+// Falls back to 60 seconds, so quick edits do not flood the channel
+func getNotifyMinDuration() time.Duration {
+	return appConfig.Notify.MinDuration.Duration()
+}
+
+// commandSummary collects what a notification reports about a finished command
+func commandSummary(command *AICommand, duration time.Duration) NotificationSummary {
+	summary := NotificationSummary{
+		CommandID: command.ID,
+		Prompt:    command.Prompt,
+		Status:    command.Status,
+		Scope:     command.Scope,
+		Page:      command.Page,
+		ProjectID: command.ProjectID,
+		Duration:  duration,
+		Error:     command.ErrorMessage,
+	}
+
+	if command.Result != "" {
+		if result, err := parseCommandResult(command.Result); err == nil {
+			for _, change := range result.Changes {
+				summary.ChangedFiles = append(summary.ChangedFiles, change.Target)
+			}
+		}
+	}
+	return summary
+}
+
+// notifyCommandFinished posts the summary to every matching channel of the command's project
+func notifyCommandFinished(db *gorm.DB, command *AICommand, duration time.Duration) {
+	if command.ProjectID == "" {
+		return
+	}
+
+	var channels []NotificationChannel
+	if err := db.Where("project_id = ?", command.ProjectID).Find(&channels).Error; err != nil || len(channels) == 0 {
+		return
+	}
+
+	summary := commandSummary(command, duration)
+	for _, channel := range channels {
+		if duration < time.Duration(channel.MinDuration)*time.Second {
+			continue
+		}
+		if len(channel.Statuses) > 0 && !containsString(channel.Statuses, summary.Status) {
+			continue
+		}
+		if err := sendToChannel(db, channel, summary); err != nil {
+			log.Printf("⚠️ Failed to notify %s channel %d of command [%s]: %v", channel.Driver, channel.ID, command.ID, err)
+		}
+	}
+}
+
+func sendToChannel(db *gorm.DB, channel NotificationChannel, summary NotificationSummary) error {
+	target, err := decryptSecret(channel.WebhookURL)
+	if err != nil {
+		return err
+	}
+	notifier, err := newNotifier(db, channel.ProjectID, channel.Driver, target)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return notifier.Send(ctx, summary)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// notificationChannelResponse hides the webhook path, which acts as the credential.
+// Email channels list their recipients.
+func notificationChannelResponse(channel NotificationChannel) fiber.Map {
+	item := fiber.Map{
+		"id":                 channel.ID,
+		"projectId":          channel.ProjectID,
+		"driver":             channel.Driver,
+		"minDurationSeconds": channel.MinDuration,
+		"statuses":           channel.Statuses,
+		"createdAt":          channel.CreatedAt,
+	}
+	if target, err := decryptSecret(channel.WebhookURL); err == nil && channel.Driver == "email" {
+		item["to"] = splitAddresses(strings.TrimPrefix(target, "mailto:"))
+	} else if err == nil {
+		if u, err := url.Parse(target); err == nil {
+			item["webhookHost"] = u.Host
+		}
+	}
+	return item
+}
+
+// ListNotificationChannels returns the channels of a project
+func ListNotificationChannels(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("id")
+		if err := db.First(&Project{}, "id = ?", projectID).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+
+		var channels []NotificationChannel
+		if err := db.Where("project_id = ?", projectID).Order("id").Find(&channels).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load notification channels", err.Error())
+		}
+
+		items := make([]fiber.Map, 0, len(channels))
+		for _, channel := range channels {
+			items = append(items, notificationChannelResponse(channel))
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"channels": items,
+			},
+		})
+	}
+}
+
+// CreateNotificationChannel adds a Slack or Discord webhook, or email recipients, to a project
+func CreateNotificationChannel(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("id")
+		if err := db.First(&Project{}, "id = ?", projectID).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+
+		var req NotificationChannelRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		target := req.WebhookURL
+		if req.Driver == "email" {
+			if len(req.To) == 0 || len(req.To) > 20 {
+				return sendError(c, 400, "INVALID_RECIPIENTS", "to must list 1 to 20 email addresses", "")
+			}
+			for _, to := range req.To {
+				if address, err := mail.ParseAddress(to); err != nil || address.Address != strings.TrimSpace(to) {
+					return sendError(c, 400, "INVALID_RECIPIENTS", "to must list email addresses", to)
+				}
+			}
+			target = "mailto:" + strings.Join(req.To, ",")
+		}
+		if _, err := newNotifier(db, projectID, req.Driver, target); err != nil {
+			return sendError(c, 400, "INVALID_DRIVER", "Invalid notification driver", err.Error())
+		}
+		if req.Driver != "email" {
+			u, err := url.Parse(target)
+			if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+				return sendError(c, 400, "INVALID_WEBHOOK", "webhookUrl must be an https URL", "")
+			}
+			if err := checkPublicHost(c.Context(), u.Hostname()); err != nil {
+				return sendError(c, 400, "INVALID_WEBHOOK", "webhookUrl must point to a public host", err.Error())
+			}
+		}
+
+		minDuration := int(getNotifyMinDuration().Seconds())
+		if req.MinDuration != nil {
+			if *req.MinDuration < 0 {
+				return sendError(c, 400, "INVALID_REQUEST", "minDurationSeconds must not be negative", "")
+			}
+			minDuration = *req.MinDuration
+		}
+
+		encrypted, err := encryptSecret(target)
+		if err != nil {
+			return sendError(c, 500, "ENCRYPTION_ERROR", "Failed to encrypt webhook URL", err.Error())
+		}
+
+		channel := NotificationChannel{
+			ProjectID:   projectID,
+			Driver:      req.Driver,
+			WebhookURL:  encrypted,
+			MinDuration: minDuration,
+			Statuses:    req.Statuses,
+			CreatedAt:   time.Now().Unix(),
+		}
+		if err := db.Create(&channel).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to save notification channel", err.Error())
+		}
+
+		return c.Status(201).JSON(fiber.Map{
+			"success": true,
+			"data":    notificationChannelResponse(channel),
+		})
+	}
+}
+
+// DeleteNotificationChannel removes a channel from a project
+func DeleteNotificationChannel(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result := db.Where("project_id = ? AND id = ?", c.Params("id"), c.Params("channelId")).Delete(&NotificationChannel{})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete notification channel", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "CHANNEL_NOT_FOUND", "Notification channel not found", "")
+		}
+
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: c.Params("channelId"), Deleted: true},
+		})
+	}
+}
+
+// TestNotificationChannel sends a sample message so users can check the webhook
+func TestNotificationChannel(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var channel NotificationChannel
+		if err := db.First(&channel, "project_id = ? AND id = ?", c.Params("id"), c.Params("channelId")).Error; err != nil {
+			return sendError(c, 404, "CHANNEL_NOT_FOUND", "Notification channel not found", "")
+		}
+
+		err := sendToChannel(db, channel, NotificationSummary{
+			CommandID:    "test",
+			Prompt:       "Test notification from the site editor",
+			Status:       "completed",
+			Scope:        "current-page",
+			ProjectID:    channel.ProjectID,
+			Duration:     90 * time.Second,
+			ChangedFiles: []string{"index.html"},
+		})
+		if err != nil {
+			return sendError(c, 502, "NOTIFICATION_FAILED", "Failed to send test notification", err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"id":   channel.ID,
+				"sent": true,
+			},
+		})
+	}
+}
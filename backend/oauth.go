@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// oauthStateCookie carries the provider and state of a sign-in between the redirect to
+// the provider and its callback, so the callback can't be forged from another browser
+const oauthStateCookie = "se_oauth_state"
+
+// oauthClient calls the token and profile endpoints of the providers
+var oauthClient = &http.Client{Timeout: 15 * time.Second}
+
+// oauthProvider is an OAuth 2.0 provider users can sign in with
+type oauthProvider struct {
+	Name         string
+	AuthURL      string
+	TokenURL     string
+	Scopes       []string
+	ClientID     string
+	ClientSecret string
+	profile      func(ctx context.Context, token string) (*oauthProfile, error)
+}
+
+// oauthProfile is who signed in, as the provider tells
+type oauthProfile struct {
+	ID            string
+	Email         string
+	EmailVerified bool
+	Name          string
+	AvatarURL     string
+}
+
+// oauthProviderNames lists the supported providers
+var oauthProviderNames = []string{"github", "google"}
+
+// getOAuthProvider returns a provider configured with AUTH_<NAME>_CLIENT_ID and
+// AUTH_<NAME>_CLIENT_SECRET, or nil. GitHub Enterprise is reached with AUTH_GITHUB_URL
+// and AUTH_GITHUB_API_URL.
+func getOAuthProvider(name string) *oauthProvider {
+	prefix := "AUTH_" + strings.ToUpper(name) + "_"
+	id, secret := os.Getenv(prefix+"CLIENT_ID"), os.Getenv(prefix+"CLIENT_SECRET")
+	if id == "" || secret == "" {
+		return nil
+	}
+	switch name {
+	case "github":
+		base := strings.TrimRight(getEnvDefault("AUTH_GITHUB_URL", "https://github.com"), "/")
+		api := strings.TrimRight(getEnvDefault("AUTH_GITHUB_API_URL", "https://api.github.com"), "/")
+		return &oauthProvider{
+			Name: name, ClientID: id, ClientSecret: secret,
+			AuthURL:  base + "/login/oauth/authorize",
+			TokenURL: base + "/login/oauth/access_token",
+			Scopes:   []string{"read:user", "user:email"},
+			profile: func(ctx context.Context, token string) (*oauthProfile, error) {
+				return githubProfile(ctx, api, token)
+			},
+		}
+	case "google":
+		return &oauthProvider{
+			Name: name, ClientID: id, ClientSecret: secret,
+			AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+			TokenURL: "https://oauth2.googleapis.com/token",
+			Scopes:   []string{"openid", "email", "profile"},
+			profile:  googleProfile,
+		}
+	}
+	return nil
+}
+
+// oauthGet fetches a JSON document from a provider with the user's access token
+func oauthGet(ctx context.Context, url, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// githubProfile reads the GitHub user and their primary email, which is only listed
+// separately when it is private
+func githubProfile(ctx context.Context, api, token string) (*oauthProfile, error) {
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := oauthGet(ctx, api+"/user", token, &user); err != nil {
+		return nil, err
+	}
+	profile := &oauthProfile{ID: strconv.FormatInt(user.ID, 10), Name: user.Name, AvatarURL: user.AvatarURL}
+	if profile.Name == "" {
+		profile.Name = user.Login
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := oauthGet(ctx, api+"/user/emails", token, &emails); err != nil {
+		return nil, err
+	}
+	for _, email := range emails {
+		if email.Primary {
+			profile.Email, profile.EmailVerified = email.Email, email.Verified
+		}
+	}
+	return profile, nil
+}
+
+// googleProfile reads the OpenID Connect profile of a Google account
+func googleProfile(ctx context.Context, token string) (*oauthProfile, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+	}
+	if err := oauthGet(ctx, "https://openidconnect.googleapis.com/v1/userinfo", token, &info); err != nil {
+		return nil, err
+	}
+	return &oauthProfile{ID: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified, Name: info.Name, AvatarURL: info.Picture}, nil
+}
+
+// exchangeCode trades the code of the callback for an access token
+func (p *oauthProvider) exchangeCode(ctx context.Context, code, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response (%s): %w", resp.Status, err)
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("no access token: %s %s", body.Error, body.ErrorDescription)
+	}
+	return body.AccessToken, nil
+}
+
+// oauthRedirectURI returns the callback URL registered with the provider, from
+// accounts.publicUrl (AUTH_PUBLIC_URL) or the address the request came to
+func oauthRedirectURI(c *fiber.Ctx, provider string) string {
+	base := appConfig.Accounts.PublicURL
+	if base == "" {
+		base = c.BaseURL()
+	}
+	return strings.TrimRight(base, "/") + "/api/auth/oauth/" + provider + "/callback"
+}
+
+// getLoginRedirect returns the editor page OAuth sign-ins return to (accounts.loginRedirect,
+// AUTH_LOGIN_REDIRECT). The tokens are passed in the URL fragment. Without it the
+// callback answers with the tokens as JSON.
+func getLoginRedirect() string {
+	return appConfig.Accounts.LoginRedirect
+}
+
+// errAccountNotFound is returned for a sign-in without a matching account while
+// AUTH_SIGNUP is off
+var errAccountNotFound = errors.New("no account matches this sign-in and signing up is disabled")
+
+// oauthAccount finds the user of a provider profile. A user already linked to the
+// provider account wins; otherwise the account with the same verified email is linked,
+// or a new one is created when AUTH_SIGNUP is set. An account whose email was never
+// verified loses its password and sessions when it is linked, since whoever signed it
+// up may not own the address.
+func oauthAccount(db *gorm.DB, provider string, profile *oauthProfile) (*User, error) {
+	column := provider + "_id"
+	var user User
+	if err := db.Limit(1).Find(&user, column+" = ?", profile.ID).Error; err != nil {
+		return nil, err
+	}
+	if user.ID != "" {
+		return &user, nil
+	}
+
+	if profile.EmailVerified && profile.Email != "" {
+		existing, err := userByEmail(db, profile.Email)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			updates := map[string]interface{}{column: profile.ID, "email_verified": true, "updated_at": time.Now().Unix()}
+			if existing.AvatarURL == "" {
+				updates["avatar_url"] = profile.AvatarURL
+			}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if !existing.EmailVerified {
+					updates["password_hash"] = ""
+					if err := tx.Where("user_id = ?", existing.ID).Delete(&AuthSession{}).Error; err != nil {
+						return err
+					}
+				}
+				return tx.Model(existing).Updates(updates).Error
+			})
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("🔗 Linked %s account %s to user %s", provider, profile.ID, existing.ID)
+			return existing, nil
+		}
+	}
+
+	if !isSignupEnabled() {
+		return nil, errAccountNotFound
+	}
+	user = User{Name: profile.Name, Email: normalizeEmail(profile.Email), EmailVerified: profile.EmailVerified, AvatarURL: profile.AvatarURL}
+	if user.Name == "" {
+		user.Name = user.Email
+	}
+	switch provider {
+	case "github":
+		user.GitHubID = profile.ID
+	case "google":
+		user.GoogleID = profile.ID
+	}
+	if err := newAccount(db, &user, getSignupRole()); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListAuthProviders tells the sign-in page which ways to sign in are available
+func ListAuthProviders() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		providers := []string{"password"}
+		for _, name := range oauthProviderNames {
+			if getOAuthProvider(name) != nil {
+				providers = append(providers, name)
+			}
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"providers": providers,
+				"signup":    isSignupEnabled(),
+			},
+		})
+	}
+}
+
+// StartOAuthLogin redirects the browser to the sign-in page of a provider
+func StartOAuthLogin() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		provider := getOAuthProvider(c.Params("provider"))
+		if provider == nil {
+			return sendError(c, 404, "PROVIDER_NOT_CONFIGURED", "This sign-in provider is not configured", c.Params("provider"))
+		}
+		state, err := randomToken("", 16)
+		if err != nil {
+			return sendError(c, 500, "TOKEN_ERROR", "Failed to generate state", err.Error())
+		}
+		c.Cookie(&fiber.Cookie{
+			Name:     oauthStateCookie,
+			Value:    provider.Name + ":" + state,
+			Path:     "/api/auth/oauth",
+			MaxAge:   600,
+			HTTPOnly: true,
+			Secure:   c.Protocol() == "https",
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+
+		query := url.Values{
+			"response_type": {"code"},
+			"client_id":     {provider.ClientID},
+			"redirect_uri":  {oauthRedirectURI(c, provider.Name)},
+			"scope":         {strings.Join(provider.Scopes, " ")},
+			"state":         {state},
+		}
+		return c.Redirect(provider.AuthURL+"?"+query.Encode(), fiber.StatusFound)
+	}
+}
+
+// oauthFail ends a sign-in with an error, back on the editor page when there is one
+func oauthFail(c *fiber.Ctx, status int, code, message string) error {
+	if redirect := getLoginRedirect(); redirect != "" {
+		fragment := url.Values{"error": {code}, "message": {message}}
+		return c.Redirect(redirect+"#"+fragment.Encode(), fiber.StatusFound)
+	}
+	return sendError(c, status, code, message, "")
+}
+
+// OAuthCallback finishes a sign-in: it checks the state, gets the user's profile from
+// the provider and signs the matching account in
+func OAuthCallback(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		provider := getOAuthProvider(c.Params("provider"))
+		if provider == nil {
+			return sendError(c, 404, "PROVIDER_NOT_CONFIGURED", "This sign-in provider is not configured", c.Params("provider"))
+		}
+		cookie := c.Cookies(oauthStateCookie)
+		c.Cookie(&fiber.Cookie{Name: oauthStateCookie, Path: "/api/auth/oauth", MaxAge: -1})
+
+		if reason := c.Query("error"); reason != "" {
+			return oauthFail(c, 401, "OAUTH_DENIED", "The sign-in was cancelled: "+reason)
+		}
+		state := c.Query("state")
+		if state == "" || cookie != provider.Name+":"+state {
+			return oauthFail(c, 400, "INVALID_OAUTH_STATE", "The sign-in expired or was started elsewhere; try again")
+		}
+
+		ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+		defer cancel()
+		token, err := provider.exchangeCode(ctx, c.Query("code"), oauthRedirectURI(c, provider.Name))
+		if err != nil {
+			log.Printf("⚠️ %s sign-in failed: %v", provider.Name, err)
+			return oauthFail(c, 502, "OAUTH_FAILED", "The provider did not accept the sign-in")
+		}
+		profile, err := provider.profile(ctx, token)
+		if err != nil || profile.ID == "" {
+			log.Printf("⚠️ %s sign-in failed to read the profile: %v", provider.Name, err)
+			return oauthFail(c, 502, "OAUTH_FAILED", "Failed to read the profile from the provider")
+		}
+
+		user, err := oauthAccount(db, provider.Name, profile)
+		if errors.Is(err, errAccountNotFound) {
+			return oauthFail(c, 403, "ACCOUNT_NOT_FOUND", "No account uses this email; ask an admin to create one")
+		}
+		if err != nil {
+			return oauthFail(c, 500, "DATABASE_ERROR", "Failed to find the account")
+		}
+		tokens, err := startSession(c, db, user, provider.Name)
+		if err != nil {
+			return oauthFail(c, 500, "SESSION_ERROR", "Failed to sign in")
+		}
+
+		if redirect := getLoginRedirect(); redirect != "" {
+			fragment := url.Values{
+				"accessToken":  {tokens.AccessToken},
+				"refreshToken": {tokens.RefreshToken},
+				"tokenType":    {tokens.TokenType},
+				"expiresIn":    {strconv.FormatInt(tokens.ExpiresIn, 10)},
+			}
+			return c.Redirect(redirect+"#"+fragment.Encode(), fiber.StatusFound)
+		}
+		return c.JSON(APIResponse[AuthTokens]{Success: true, Data: *tokens})
+	}
+}
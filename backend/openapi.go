@@ -0,0 +1,645 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// apiParam is a query parameter of an operation
+type apiParam struct {
+	Name        string
+	Type        string // string (default), integer or boolean
+	Description string
+}
+
+// apiOperation documents a route in the OpenAPI document. Request and Response hold a
+// zero value of the body types; the schemas are derived from their fields.
+type apiOperation struct {
+	Method    string
+	Path      string // Fiber syntax, /api/content/:id
+	Tag       string
+	Summary   string
+	Query     []apiParam
+	Request   interface{}
+	Response  interface{}
+	Status    int    // Success status when not 200
+	Consumes  string // Request media type when not JSON
+	Produces  string // Response media type when not JSON
+	WebSocket bool   // Upgrades to a WebSocket
+}
+
+var localeParam = apiParam{Name: "locale", Description: "Language tag; defaults to the default locale"}
+var stateParam = apiParam{Name: "state", Description: "draft (default) or published"}
+var renderParam = apiParam{Name: "render", Description: "html renders Markdown blocks"}
+var projectParam = apiParam{Name: "projectId", Description: "Project; the default workspace when omitted"}
+
+// apiOperations lists the documented routes. Routes missing here still appear in the
+// document, without schemas; see openAPIDocument.
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/api/auth/me", Tag: "auth", Summary: "Get the caller and their role", Response: CurrentUserInfo{}},
+	{Method: "GET", Path: "/api/me", Tag: "auth", Summary: "Get the signed-in user, their role and how they sign in", Response: CurrentUserInfo{}},
+	{Method: "GET", Path: "/api/auth/providers", Tag: "auth", Summary: "List the ways to sign in"},
+	{Method: "POST", Path: "/api/auth/signup", Tag: "auth", Summary: "Create an account with a password (AUTH_SIGNUP)", Request: SignupRequest{}, Response: AuthTokens{}, Status: 201},
+	{Method: "POST", Path: "/api/auth/login", Tag: "auth", Summary: "Sign in with an email and password", Request: LoginRequest{}, Response: AuthTokens{}},
+	{Method: "POST", Path: "/api/auth/refresh", Tag: "auth", Summary: "Trade a refresh token for new tokens", Request: RefreshRequest{}, Response: AuthTokens{}},
+	{Method: "POST", Path: "/api/auth/logout", Tag: "auth", Summary: "End the session of the access token"},
+	{Method: "GET", Path: "/api/auth/oauth/:provider", Tag: "auth", Summary: "Start signing in with github or google"},
+	{Method: "GET", Path: "/api/auth/oauth/:provider/callback", Tag: "auth", Summary: "Finish signing in with a provider", Response: AuthTokens{}},
+	{Method: "PUT", Path: "/api/me/password", Tag: "auth", Summary: "Set your password and sign out your other sessions", Request: PasswordRequest{}},
+	{Method: "GET", Path: "/api/me/sessions", Tag: "auth", Summary: "List your sessions", Response: []AuthSession{}},
+	{Method: "DELETE", Path: "/api/me/sessions/:id", Tag: "auth", Summary: "Sign out one of your sessions"},
+	{Method: "GET", Path: "/api/me/usage", Tag: "auth", Summary: "Get your usage against the quotas of your plan and teams", Response: APIResponse[MyUsage]{}},
+	{Method: "GET", Path: "/api/invites/:token", Tag: "auth", Summary: "Get the project and role of a team invite", Response: InviteInfo{}},
+	{Method: "POST", Path: "/api/invites/:token/accept", Tag: "auth", Summary: "Join a team, creating the account of the invited email when not signed in", Request: AcceptInviteRequest{}},
+
+	{Method: "GET", Path: "/api/content", Tag: "content", Summary: "Get blocks by ID (?ids=), or list every block", Response: ContentListResponse{},
+		Query: []apiParam{{Name: "ids", Description: "Comma-separated block IDs; lists all blocks when omitted"}, localeParam, stateParam, renderParam,
+			{Name: "edited", Type: "boolean"}, {Name: "page"}, {Name: "updatedAfter", Type: "integer", Description: "Unix seconds"},
+			{Name: "limit", Type: "integer"}, {Name: "cursor", Description: "next_cursor of the previous page"}}},
+	{Method: "POST", Path: "/api/content/bulk", Tag: "content", Summary: "Save many blocks in one transaction", Request: BulkContentRequest{}, Response: ContentBulkSaveResponse{}},
+	{Method: "GET", Path: "/api/content/export", Tag: "content", Summary: "Export content as a JSON archive", Response: ContentExport{}},
+	{Method: "POST", Path: "/api/content/import", Tag: "content", Summary: "Import a content export", Consumes: "multipart/form-data", Response: ImportResult{}},
+	{Method: "GET", Path: "/api/content/trash", Tag: "content", Summary: "List deleted blocks", Response: TrashListResponse{}, Query: []apiParam{{Name: "page"}}},
+	{Method: "GET", Path: "/api/content/search", Tag: "content", Summary: "Search blocks", Response: SearchResponse{},
+		Query: []apiParam{{Name: "q", Description: "Words to find"}, {Name: "page"}, localeParam, {Name: "edited", Type: "boolean"}, {Name: "limit", Type: "integer"}}},
+	{Method: "GET", Path: "/api/content/:id", Tag: "content", Summary: "Get a block, falling back along the locale chain", Response: ContentResponse{},
+		Query: []apiParam{localeParam, stateParam, renderParam}},
+	{Method: "PUT", Path: "/api/content/:id", Tag: "content", Summary: "Save a block", Request: ContentRequest{}, Response: ContentResponse{}, Query: []apiParam{localeParam}},
+	{Method: "DELETE", Path: "/api/content/:id", Tag: "content", Summary: "Move a block to the trash", Response: ContentDeletedResponse{},
+		Query: []apiParam{localeParam, {Name: "lock_token"}}},
+	{Method: "GET", Path: "/api/content/:id/diff", Tag: "content", Summary: "Diff two states of a block", Response: ContentDiffResponse{},
+		Query: []apiParam{localeParam, {Name: "from", Description: "original (default), edited or published"}, {Name: "to", Description: "edited (default), original or published"}, {Name: "mode", Description: "word or html"}}},
+	{Method: "GET", Path: "/api/content/:id/locales", Tag: "content", Summary: "List the translations of a block", Response: ContentLocalesResponse{}},
+	{Method: "POST", Path: "/api/content/:id/restore", Tag: "content", Summary: "Restore a block from the trash", Response: ContentResponse{}, Query: []apiParam{localeParam}},
+	{Method: "POST", Path: "/api/content/:id/reset", Tag: "content", Summary: "Discard the draft of a block", Response: ContentResponse{},
+		Query: []apiParam{localeParam, {Name: "lock_token"}, {Name: "version", Type: "integer"}}},
+	{Method: "POST", Path: "/api/content/:id/publish", Tag: "content", Summary: "Publish the draft of a block", Response: ContentResponse{}, Query: []apiParam{localeParam}},
+	{Method: "POST", Path: "/api/content/:id/unpublish", Tag: "content", Summary: "Take a block off the live site", Response: ContentResponse{}, Query: []apiParam{localeParam}},
+	{Method: "GET", Path: "/api/content/:id/lock", Tag: "content", Summary: "Get the lock of a block", Response: ContentLockStatus{}},
+	{Method: "POST", Path: "/api/content/:id/lock", Tag: "content", Summary: "Lock a block for editing", Request: ContentLockRequest{}, Response: ContentLockStatus{}},
+	{Method: "POST", Path: "/api/content/:id/lock/heartbeat", Tag: "content", Summary: "Extend a lock", Request: ContentLockRequest{}, Response: ContentLockStatus{}},
+	{Method: "DELETE", Path: "/api/content/:id/lock", Tag: "content", Summary: "Release a lock", Request: ContentLockRequest{}, Response: ContentLockStatus{}},
+	{Method: "GET", Path: "/api/content/:id/subscribe", Tag: "content", Summary: "Stream changes of a block", WebSocket: true, Query: []apiParam{localeParam}},
+	{Method: "POST", Path: "/api/render/markdown", Tag: "content", Summary: "Render Markdown to sanitized HTML", Request: RenderMarkdownRequest{}, Response: RenderMarkdownResponse{}},
+
+	{Method: "GET", Path: "/api/pages", Tag: "pages", Summary: "List pages", Response: PageListResponse{}},
+	{Method: "POST", Path: "/api/pages", Tag: "pages", Summary: "Create a page from a template", Request: CreatePageRequest{}, Response: PageCreatedResponse{}, Status: 201},
+	{Method: "GET", Path: "/api/pages/templates", Tag: "pages", Summary: "List the templates pages can be created from", Response: PageTemplateListResponse{}},
+	{Method: "POST", Path: "/api/templates", Tag: "templates", Summary: "Add a template to the library", Request: TemplateRequest{}, Response: APIResponse[Template]{}, Status: 201},
+	{Method: "GET", Path: "/api/templates", Tag: "templates", Summary: "List templates", Response: APIResponse[TemplateList]{}, Query: []apiParam{{Name: "kind", Description: "page or section"}}},
+	{Method: "GET", Path: "/api/templates/:id", Tag: "templates", Summary: "Get a template", Response: APIResponse[Template]{}},
+	{Method: "PUT", Path: "/api/templates/:id", Tag: "templates", Summary: "Replace a template", Request: TemplateRequest{}, Response: APIResponse[Template]{}},
+	{Method: "DELETE", Path: "/api/templates/:id", Tag: "templates", Summary: "Delete a template", Response: APIResponse[DeletedResponse]{}},
+	{Method: "POST", Path: "/api/templates/:id/instantiate", Tag: "templates", Summary: "Create a page, or add a section to a page, from a template", Request: InstantiateTemplateRequest{}, Response: SectionInstance{}, Status: 201},
+	{Method: "GET", Path: "/api/pages/:page/content", Tag: "pages", Summary: "Get every block of a page", Response: PageContentResponse{}, Query: []apiParam{localeParam, stateParam}},
+	{Method: "GET", Path: "/api/pages/:page/meta", Tag: "pages", Summary: "Get the SEO metadata of a page", Response: PageMeta{}},
+	{Method: "PUT", Path: "/api/pages/:page/meta", Tag: "pages", Summary: "Set the SEO metadata of a page", Request: PageMetaRequest{}, Response: PageMeta{}},
+	{Method: "GET", Path: "/api/pages/:page/screenshot", Tag: "pages", Summary: "Render a page to PNG with headless Chromium", Produces: "image/png",
+		Query: []apiParam{{Name: "viewport", Description: "desktop (default), tablet, mobile or WIDTHxHEIGHT"}, {Name: "width", Description: "Scale the image down to this width"}, {Name: "refresh", Description: "true renders the page again instead of using the cache"}}},
+	{Method: "DELETE", Path: "/api/pages/:page", Tag: "pages", Summary: "Purge a page and its blocks", Response: PageDeletedResponse{}},
+	{Method: "PUT", Path: "/api/pages/:page/project", Tag: "pages", Summary: "Move a page to a project, whose team may then edit it", Response: Page{}},
+	{Method: "GET", Path: "/api/menus", Tag: "menus", Summary: "List menus", Response: APIResponse[MenuList]{}},
+	{Method: "POST", Path: "/api/menus", Tag: "menus", Summary: "Create a menu", Request: MenuRequest{}, Response: APIResponse[MenuTree]{}, Status: 201},
+	{Method: "GET", Path: "/api/menus/:name", Tag: "menus", Summary: "Get a menu as nested items", Response: APIResponse[MenuTree]{}},
+	{Method: "PUT", Path: "/api/menus/:name", Tag: "menus", Summary: "Replace the title and items of a menu", Request: MenuTreeRequest{}, Response: APIResponse[MenuTree]{}},
+	{Method: "DELETE", Path: "/api/menus/:name", Tag: "menus", Summary: "Delete a menu", Response: APIResponse[DeletedResponse]{}},
+	{Method: "POST", Path: "/api/menus/:name/items", Tag: "menus", Summary: "Add a menu item", Request: MenuItemRequest{}, Response: APIResponse[MenuItem]{}, Status: 201},
+	{Method: "PUT", Path: "/api/menus/:name/items/:id", Tag: "menus", Summary: "Replace or move a menu item", Request: MenuItemRequest{}, Response: APIResponse[MenuItem]{}},
+	{Method: "DELETE", Path: "/api/menus/:name/items/:id", Tag: "menus", Summary: "Delete a menu item and the items nested under it", Response: APIResponse[DeletedResponse]{}},
+	{Method: "POST", Path: "/api/menus/:name/reorder", Tag: "menus", Summary: "Rearrange every item of a menu", Request: MenuReorderRequest{}, Response: APIResponse[MenuTree]{}},
+	{Method: "POST", Path: "/api/forms/:formId/submit", Tag: "forms", Summary: "Submit a form of the site", Query: []apiParam{{Name: "projectId", Description: "Project whose email settings forward the submission"}}, Consumes: "application/x-www-form-urlencoded", Response: APIResponse[FormSubmitted]{}, Status: 201},
+	{Method: "GET", Path: "/api/forms", Tag: "forms", Summary: "List forms that received submissions", Response: APIResponse[FormList]{}},
+	{Method: "GET", Path: "/api/forms/:formId/submissions", Tag: "forms", Summary: "List the submissions of a form", Response: APIResponse[FormSubmissionList]{},
+		Query: []apiParam{{Name: "limit", Description: "Page size, 1 to 500 (default 100)"}, {Name: "before", Description: "nextBefore of the previous page"}}},
+	{Method: "GET", Path: "/api/forms/:formId/submissions/export", Tag: "forms", Summary: "Download the submissions of a form", Produces: "text/csv", Query: []apiParam{{Name: "format", Description: "csv (default) or json"}}},
+	{Method: "DELETE", Path: "/api/forms/:formId/submissions/:id", Tag: "forms", Summary: "Delete a submission", Response: APIResponse[DeletedResponse]{}},
+
+	{Method: "POST", Path: "/api/assets", Tag: "assets", Summary: "Upload an asset", Consumes: "multipart/form-data", Status: 201},
+	{Method: "GET", Path: "/api/assets", Tag: "assets", Summary: "List assets", Query: []apiParam{{Name: "page"}}},
+	{Method: "GET", Path: "/api/assets/:id", Tag: "assets", Summary: "Download an asset, optionally resized", Produces: "*/*",
+		Query: []apiParam{{Name: "w", Type: "integer"}, {Name: "h", Type: "integer"}, {Name: "format", Description: "webp or avif"}, {Name: "meta", Type: "boolean"}}},
+	{Method: "DELETE", Path: "/api/assets/:id", Tag: "assets", Summary: "Delete an asset", Response: APIResponse[DeletedResponse]{}},
+
+	{Method: "GET", Path: "/api/projects", Tag: "projects", Summary: "List projects", Response: APIResponse[ProjectList]{}},
+	{Method: "POST", Path: "/api/projects", Tag: "projects", Summary: "Create a project", Request: ProjectRequest{}, Response: APIResponse[Project]{}, Status: 201},
+	{Method: "GET", Path: "/api/projects/:id", Tag: "projects", Summary: "Get a project", Response: APIResponse[Project]{}},
+	{Method: "PUT", Path: "/api/projects/:id", Tag: "projects", Summary: "Update a project", Request: ProjectRequest{}, Response: APIResponse[Project]{}},
+	{Method: "DELETE", Path: "/api/projects/:id", Tag: "projects", Summary: "Delete a project", Response: APIResponse[DeletedResponse]{}},
+	{Method: "GET", Path: "/api/projects/:id/env", Tag: "projects", Summary: "List project variables"},
+	{Method: "PUT", Path: "/api/projects/:id/env/:key", Tag: "projects", Summary: "Set a project variable", Request: ProjectEnvRequest{}},
+	{Method: "DELETE", Path: "/api/projects/:id/env/:key", Tag: "projects", Summary: "Delete a project variable"},
+	{Method: "GET", Path: "/api/projects/:id/notifications", Tag: "projects", Summary: "List notification channels"},
+	{Method: "POST", Path: "/api/projects/:id/notifications", Tag: "projects", Summary: "Add a notification channel", Request: NotificationChannelRequest{}, Status: 201},
+	{Method: "DELETE", Path: "/api/projects/:id/notifications/:channelId", Tag: "projects", Summary: "Remove a notification channel", Response: APIResponse[DeletedResponse]{}},
+	{Method: "POST", Path: "/api/projects/:id/notifications/:channelId/test", Tag: "projects", Summary: "Send a test notification"},
+	{Method: "GET", Path: "/api/projects/:id/email", Tag: "projects", Summary: "Get the email settings in effect", Response: APIResponse[EmailSettingsResponse]{}},
+	{Method: "PUT", Path: "/api/projects/:id/email", Tag: "projects", Summary: "Set the email settings of a project", Request: EmailSettingsRequest{}, Response: APIResponse[EmailSettingsResponse]{}},
+	{Method: "DELETE", Path: "/api/projects/:id/email", Tag: "projects", Summary: "Use the environment's email settings again", Response: APIResponse[DeletedResponse]{}},
+	{Method: "POST", Path: "/api/projects/:id/email/test", Tag: "projects", Summary: "Send a test email", Request: EmailTestRequest{}, Response: APIResponse[EmailTestResponse]{}},
+	{Method: "GET", Path: "/api/projects/:id/team", Tag: "projects", Summary: "List the team and pending invites of a project", Response: TeamList{}},
+	{Method: "POST", Path: "/api/projects/:id/team/invites", Tag: "projects", Summary: "Invite someone to the team by email", Request: TeamInviteRequest{}, Status: 201},
+	{Method: "DELETE", Path: "/api/projects/:id/team/invites/:inviteId", Tag: "projects", Summary: "Revoke a pending invite"},
+	{Method: "PUT", Path: "/api/projects/:id/team/:userId", Tag: "projects", Summary: "Change the role of a team member", Request: TeamRoleRequest{}, Response: TeamMember{}},
+	{Method: "DELETE", Path: "/api/projects/:id/team/:userId", Tag: "projects", Summary: "Remove a team member"},
+
+	{Method: "GET", Path: "/api/workspace/files", Tag: "workspace", Summary: "List workspace files", Query: []apiParam{projectParam}},
+	{Method: "GET", Path: "/api/workspace/file", Tag: "workspace", Summary: "Read a workspace file", Query: []apiParam{{Name: "path"}, projectParam}},
+	{Method: "PUT", Path: "/api/workspace/file", Tag: "workspace", Summary: "Write a workspace file", Request: WorkspaceFileRequest{}},
+	{Method: "GET", Path: "/api/workspace/file/diff", Tag: "workspace", Summary: "Diff a workspace file against git", Query: []apiParam{{Name: "path"}, projectParam}},
+	{Method: "POST", Path: "/api/workspace/file/diff", Tag: "workspace", Summary: "Diff new content against a workspace file", Request: WorkspaceFileRequest{}},
+	{Method: "GET", Path: "/api/workspace/git/log", Tag: "workspace", Summary: "List workspace commits", Query: []apiParam{projectParam}},
+	{Method: "GET", Path: "/api/workspace/git/diff/:sha", Tag: "workspace", Summary: "Show a commit", Query: []apiParam{projectParam}},
+	{Method: "POST", Path: "/api/workspace/git/revert/:sha", Tag: "workspace", Summary: "Revert a commit", Query: []apiParam{projectParam}},
+
+	{Method: "GET", Path: "/api/seo/sitemap", Tag: "seo", Summary: "Preview sitemap.xml and robots.txt", Response: APIResponse[SitemapPreview]{}, Query: []apiParam{projectParam, {Name: "baseUrl", Description: "Public address of the site; defaults to the siteUrl setting, then SITE_URL"}, {Name: "exclude", Description: "Comma-separated page patterns to leave out"}}},
+	{Method: "POST", Path: "/api/seo/sitemap/generate", Tag: "seo", Summary: "Write sitemap.xml and robots.txt", Request: SitemapRequest{}, Response: APIResponse[SitemapGenerated]{}},
+	{Method: "POST", Path: "/api/tools/a11y", Tag: "tools", Summary: "Audit pages for accessibility problems; streams findings with Accept: text/event-stream", Request: A11yAuditRequest{}, Response: APIResponse[A11yAuditResult]{}},
+	{Method: "GET", Path: "/api/tools/a11y/reports", Tag: "tools", Summary: "Latest accessibility report of each page", Response: APIResponse[A11yReportList]{}, Query: []apiParam{projectParam, {Name: "path", Description: "Page file; includes its findings"}}},
+
+	{Method: "POST", Path: "/api/ai/command", Tag: "ai", Summary: "Queue an AI command", Request: AICommandRequest{}, Response: APIResponse[QueuedCommand]{}},
+	{Method: "GET", Path: "/api/ai/command/:commandId/stream", Tag: "ai", Summary: "Run a command and stream its progress", WebSocket: true,
+		Query: []apiParam{{Name: "streamToken", Description: "Stream token from wsUrl; required with AUTH_ENABLED"}}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/stream-token", Tag: "ai", Summary: "Issue a new stream token for a command", Response: APIResponse[StreamTokenResponse]{}},
+	{Method: "GET", Path: "/api/ai/command/:commandId/status", Tag: "ai", Summary: "Get the status and result of a command", Response: APIResponse[CommandStatus]{}},
+	{Method: "GET", Path: "/api/ai/command/:commandId/visual-diff", Tag: "ai", Summary: "Screenshots of the page before and after a command, with a pixel diff", Response: APIResponse[VisualDiffResponse]{},
+		Query: []apiParam{{Name: "image", Description: "before, after or diff returns the PNG instead"}}},
+	{Method: "GET", Path: "/api/ai/command/:commandId/log", Tag: "ai", Summary: "Get the stored progress of a command"},
+	{Method: "GET", Path: "/api/ai/command/:commandId/tools", Tag: "ai", Summary: "List the tools a command ran", Response: APIResponse[CommandTools]{},
+		Query: []apiParam{{Name: "name", Description: "Comma-separated tool names, e.g. Edit,Bash"}}},
+	{Method: "GET", Path: "/api/ai/result-schema", Tag: "ai", Summary: "Get the JSON Schema of command result files", Produces: "application/schema+json"},
+	{Method: "POST", Path: "/api/ai/translate", Tag: "ai", Summary: "Queue a translation of a block or page", Request: TranslateRequest{}, Response: APIResponse[QueuedTranslation]{}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/interrupt", Tag: "ai", Summary: "Interrupt a running command", Response: APIResponse[CommandState]{}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/approve", Tag: "ai", Summary: "Approve a pending command", Request: ReviewRequest{}, Response: APIResponse[ReviewResult]{}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/reject", Tag: "ai", Summary: "Reject a pending command", Request: ReviewRequest{}, Response: APIResponse[ReviewResult]{}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/rollback", Tag: "ai", Summary: "Restore the workspace snapshot taken before a command", Request: RollbackRequest{}, Response: APIResponse[RollbackResult]{}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/undo", Tag: "ai", Summary: "Undo the files and blocks a command changed", Request: UndoRequest{}, Response: APIResponse[UndoResponse]{}},
+	{Method: "GET", Path: "/api/ai/command/:commandId/diff", Tag: "ai", Summary: "Show the changes a command made on its branch", Response: APIResponse[BranchDiff]{}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/merge", Tag: "ai", Summary: "Merge the branch of a command into the workspace", Response: APIResponse[BranchResult]{}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/discard", Tag: "ai", Summary: "Delete the branch of a command without merging it", Response: APIResponse[BranchResult]{}},
+	{Method: "GET", Path: "/api/ai/undo", Tag: "ai", Summary: "List the commands on a page that can be undone", Response: APIResponse[UndoStack]{}, Query: []apiParam{{Name: "page"}, projectParam}},
+	{Method: "POST", Path: "/api/ai/undo", Tag: "ai", Summary: "Undo the last commands on a page", Request: PageUndoRequest{}, Response: APIResponse[UndoResponse]{}},
+	{Method: "POST", Path: "/api/ai/command/:commandId/retry", Tag: "ai", Summary: "Run a command again", Response: APIResponse[QueuedCommand]{}, Status: 201},
+	{Method: "GET", Path: "/api/ai/approvals", Tag: "ai", Summary: "List commands waiting for approval", Response: APIResponse[PendingApprovals]{}},
+	{Method: "POST", Path: "/api/ai/macros", Tag: "ai", Summary: "Create a macro", Request: MacroRequest{}, Response: APIResponse[Macro]{}, Status: 201},
+	{Method: "GET", Path: "/api/ai/macros", Tag: "ai", Summary: "List macros", Response: APIResponse[MacroList]{}},
+	{Method: "GET", Path: "/api/ai/macros/:id", Tag: "ai", Summary: "Get a macro", Response: APIResponse[Macro]{}},
+	{Method: "PUT", Path: "/api/ai/macros/:id", Tag: "ai", Summary: "Replace a macro", Request: MacroRequest{}, Response: APIResponse[Macro]{}},
+	{Method: "DELETE", Path: "/api/ai/macros/:id", Tag: "ai", Summary: "Delete a macro", Response: APIResponse[DeletedResponse]{}},
+	{Method: "POST", Path: "/api/ai/macros/:id/run", Tag: "ai", Summary: "Run a macro", Request: MacroRunRequest{}},
+	{Method: "GET", Path: "/api/ai/prompts/recent", Tag: "ai", Summary: "List recent prompts"},
+	{Method: "GET", Path: "/api/ai/prompts/favorites", Tag: "ai", Summary: "List favorite prompts"},
+	{Method: "POST", Path: "/api/ai/prompts/favorites", Tag: "ai", Summary: "Add a favorite prompt", Request: PromptFavoriteRequest{}},
+	{Method: "DELETE", Path: "/api/ai/prompts/favorites/:id", Tag: "ai", Summary: "Remove a favorite prompt"},
+	{Method: "POST", Path: "/api/ai/batch", Tag: "ai", Summary: "Run a prompt on many pages or blocks", Request: BatchCommandRequest{}},
+	{Method: "GET", Path: "/api/ai/batch/:id", Tag: "ai", Summary: "Get the progress of a batch"},
+	{Method: "GET", Path: "/api/ai/batch/:id/stream", Tag: "ai", Summary: "Stream the progress of a batch", WebSocket: true,
+		Query: []apiParam{{Name: "streamToken", Description: "Stream token from wsUrl; required with AUTH_ENABLED"}}},
+	{Method: "POST", Path: "/api/ai/batch/:id/interrupt", Tag: "ai", Summary: "Interrupt a batch"},
+	{Method: "GET", Path: "/api/ai/usage", Tag: "ai", Summary: "Report token usage and cost",
+		Query: []apiParam{{Name: "from"}, {Name: "to"}, {Name: "userId"}, projectParam, {Name: "groupBy", Description: "day, user, project, provider or model"}}},
+	{Method: "GET", Path: "/api/ai/usage/budget", Tag: "ai", Summary: "Report spend against budgets", Query: []apiParam{projectParam}},
+	{Method: "POST", Path: "/api/ai/schedule", Tag: "ai", Summary: "Schedule a recurring command", Request: ScheduleRequest{}, Response: APIResponse[ScheduledCommand]{}, Status: 201},
+	{Method: "GET", Path: "/api/ai/schedule", Tag: "ai", Summary: "List schedules", Response: APIResponse[ScheduleList]{}},
+	{Method: "GET", Path: "/api/ai/schedule/:id", Tag: "ai", Summary: "Get a schedule", Response: APIResponse[ScheduledCommand]{}},
+	{Method: "DELETE", Path: "/api/ai/schedule/:id", Tag: "ai", Summary: "Delete a schedule", Response: APIResponse[DeletedResponse]{}},
+	{Method: "GET", Path: "/api/ai/conversations", Tag: "ai", Summary: "List conversations"},
+	{Method: "GET", Path: "/api/ai/conversations/:conversationId", Tag: "ai", Summary: "Get a conversation and its commands"},
+
+	{Method: "POST", Path: "/api/agent/run", Tag: "agent", Summary: "Start an agent session", Request: AgentRunRequest{}},
+	{Method: "GET", Path: "/api/agent/stream/:sessionId", Tag: "agent", Summary: "Stream the output of a session", Produces: "text/event-stream"},
+	{Method: "POST", Path: "/api/agent/interrupt/:sessionId", Tag: "agent", Summary: "Interrupt a session"},
+	{Method: "POST", Path: "/api/agent/input/:sessionId", Tag: "agent", Summary: "Write to the input of a session", Request: AgentInputRequest{}},
+	{Method: "POST", Path: "/api/agent/resize/:sessionId", Tag: "agent", Summary: "Resize the terminal of a session", Request: AgentResizeRequest{}},
+	{Method: "GET", Path: "/api/agent/output/:sessionId", Tag: "agent", Summary: "Get the output of a session"},
+	{Method: "GET", Path: "/api/agent/status/:sessionId", Tag: "agent", Summary: "Get the status of a session"},
+	{Method: "POST", Path: "/api/agent/cleanup", Tag: "agent", Summary: "Remove finished sessions"},
+
+	{Method: "POST", Path: "/api/build", Tag: "deploy", Summary: "Build a project", Request: BuildRequest{}},
+	{Method: "GET", Path: "/api/build/latest", Tag: "deploy", Summary: "Get the latest build", Query: []apiParam{projectParam}},
+	{Method: "POST", Path: "/api/preview/start", Tag: "deploy", Summary: "Start the preview server of a project", Request: PreviewRequest{}},
+	{Method: "POST", Path: "/api/preview/stop", Tag: "deploy", Summary: "Stop a preview server", Request: PreviewRequest{}},
+	{Method: "GET", Path: "/api/preview/status", Tag: "deploy", Summary: "Get the status of a preview server", Query: []apiParam{projectParam}},
+	{Method: "POST", Path: "/api/deploy", Tag: "deploy", Summary: "Deploy a project", Request: DeployRequest{}},
+	{Method: "POST", Path: "/api/deploy/rollback", Tag: "deploy", Summary: "Redeploy an earlier deployment", Request: DeployRequest{}},
+	{Method: "GET", Path: "/api/deploy", Tag: "deploy", Summary: "List deployments", Query: []apiParam{projectParam}},
+	{Method: "GET", Path: "/api/deploy/:id", Tag: "deploy", Summary: "Get a deployment"},
+
+	{Method: "GET", Path: "/api/activity", Tag: "activity", Summary: "List the activity feed", Query: []apiParam{
+		{Name: "type", Description: "command, file or tool; comma separated"}, {Name: "commandId"}, projectParam,
+		{Name: "limit", Type: "integer", Description: "Page size, 1 to 500 (default 50)"}, {Name: "before", Description: "nextBefore of the previous page"},
+		{Name: "format", Description: "json (default) or markdown"},
+	}},
+
+	{Method: "GET", Path: "/api/admin/stats", Tag: "admin", Summary: "Get usage statistics"},
+	{Method: "GET", Path: "/api/admin/config", Tag: "admin", Summary: "Get the effective configuration"},
+	{Method: "GET", Path: "/api/admin/users", Tag: "admin", Summary: "List users"},
+	{Method: "POST", Path: "/api/admin/users", Tag: "admin", Summary: "Create a user", Request: UserRequest{}, Status: 201},
+	{Method: "PUT", Path: "/api/admin/users/:id", Tag: "admin", Summary: "Update a user", Request: UserRequest{}},
+	{Method: "DELETE", Path: "/api/admin/users/:id", Tag: "admin", Summary: "Delete a user"},
+	{Method: "POST", Path: "/api/admin/users/:id/token", Tag: "admin", Summary: "Issue a new API token"},
+	{Method: "PUT", Path: "/api/admin/users/:id/plan", Tag: "admin", Summary: "Give a user a quota plan", Request: PlanAssignmentRequest{}},
+	{Method: "PUT", Path: "/api/admin/projects/:id/plan", Tag: "admin", Summary: "Give a project's team a quota plan", Request: PlanAssignmentRequest{}, Response: APIResponse[Project]{}},
+	{Method: "GET", Path: "/api/admin/plans", Tag: "admin", Summary: "List quota plans", Response: APIResponse[[]Plan]{}},
+	{Method: "POST", Path: "/api/admin/plans", Tag: "admin", Summary: "Create a quota plan", Request: PlanRequest{}, Response: APIResponse[Plan]{}, Status: 201},
+	{Method: "PUT", Path: "/api/admin/plans/:id", Tag: "admin", Summary: "Change the limits of a quota plan", Request: PlanRequest{}, Response: APIResponse[Plan]{}},
+	{Method: "DELETE", Path: "/api/admin/plans/:id", Tag: "admin", Summary: "Delete a quota plan no one has"},
+	{Method: "GET", Path: "/api/admin/audit", Tag: "admin", Summary: "List audit events"},
+	{Method: "POST", Path: "/api/admin/backup", Tag: "admin", Summary: "Back up the database, workspaces and assets", Request: BackupRequest{}, Response: APIResponse[BackupInfo]{}, Status: 201},
+	{Method: "GET", Path: "/api/admin/backups", Tag: "admin", Summary: "List backups", Response: APIResponse[[]BackupInfo]{}},
+	{Method: "GET", Path: "/api/admin/backups/:name", Tag: "admin", Summary: "Download a backup", Produces: "application/gzip"},
+	{Method: "POST", Path: "/api/admin/restore", Tag: "admin", Summary: "Restore a backup", Query: []apiParam{{Name: "name", Description: "Backup in BACKUP_DIR to restore instead of an uploaded file"}, {Name: "dryRun", Description: "Only report what would change"}}, Response: APIResponse[RestorePlan]{}},
+	{Method: "GET", Path: "/api/admin/cleanup/stats", Tag: "admin", Summary: "Get cleanup statistics"},
+	{Method: "GET", Path: "/api/admin/queue", Tag: "admin", Summary: "Get job queue statistics", Response: APIResponse[QueueStats]{}},
+	{Method: "GET", Path: "/api/admin/prompts", Tag: "admin", Summary: "List prompt templates"},
+	{Method: "GET", Path: "/api/admin/prompts/:name", Tag: "admin", Summary: "Get a prompt template"},
+	{Method: "PUT", Path: "/api/admin/prompts/:name", Tag: "admin", Summary: "Replace a prompt template", Request: PromptRequest{}},
+	{Method: "DELETE", Path: "/api/admin/prompts/:name", Tag: "admin", Summary: "Restore the built-in prompt template"},
+
+	{Method: "GET", Path: "/api/openapi.json", Tag: "meta", Summary: "Get this OpenAPI document"},
+	{Method: "GET", Path: "/api/docs", Tag: "meta", Summary: "Browse the API with Swagger UI", Produces: "text/html"},
+}
+
+// openAPISchemas collects the component schemas of the types an operation uses
+type openAPISchemas struct {
+	components map[string]interface{}
+}
+
+// typeNamePattern matches the package of a type name, including generic type arguments
+var typeNamePattern = regexp.MustCompile(`[A-Za-z0-9_/.-]*\.`)
+
+// schemaName names the component of a struct type: APIResponse[main.Project] becomes APIResponseProject
+func schemaName(t reflect.Type) string {
+	name := typeNamePattern.ReplaceAllString(t.Name(), "")
+	return strings.NewReplacer("[", "", "]", "", "*", "", ",", "").Replace(name)
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	deletedAtType  = reflect.TypeOf(gorm.DeletedAt{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schema returns the JSON Schema of a Go type as encoding/json would marshal it.
+// Named structs are added to the components and referenced.
+func (s *openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case deletedAtType:
+		return map[string]interface{}{"type": "string", "format": "date-time", "nullable": true}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema := map[string]interface{}{"type": "integer"}
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			schema["format"] = "int64"
+		}
+		return schema
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := s.components[name]; !ok {
+			s.components[name] = nil // Placeholder for recursive types
+			s.components[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{} // interface{}: any value
+}
+
+// structSchema describes the JSON object of a struct. Embedded structs without a JSON
+// name are flattened into it, like encoding/json does.
+func (s *openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					addFields(embedded)
+					continue
+				}
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := s.schema(field.Type)
+			rules := field.Tag.Get("validate")
+			if rules != "" {
+				// OpenAPI 3.0 ignores keywords next to a $ref
+				if _, ok := property["$ref"]; !ok {
+					applyValidation(property, field.Type, rules)
+				}
+			}
+			properties[name] = property
+			// Validated requests list the fields they need; responses the ones always present
+			if strings.Contains(","+expandValidation(rules)+",", ",required,") || (rules == "" && !strings.Contains(options, "omitempty")) {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// expandValidation replaces the aliases in a validate tag with their rules
+func expandValidation(rules string) string {
+	var expanded []string
+	for _, rule := range strings.Split(rules, ",") {
+		if alias, ok := validationAliases[rule]; ok {
+			rule = alias
+		}
+		expanded = append(expanded, rule)
+	}
+	return strings.Join(expanded, ",")
+}
+
+// applyValidation adds the rules of a validate tag that JSON Schema can express to the
+// schema of a field. Rules after dive apply to the elements and are left out.
+func applyValidation(schema map[string]interface{}, t reflect.Type, rules string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for _, rule := range strings.Split(expandValidation(rules), ",") {
+		tag, param, _ := strings.Cut(rule, "=")
+		if tag == "dive" {
+			return
+		}
+		if pattern, ok := validationPatterns[tag]; ok {
+			schema["pattern"] = pattern.String()
+			continue
+		}
+		switch tag {
+		case "oneof":
+			schema["enum"] = strings.Fields(param)
+		case "contenttype":
+			schema["enum"] = []string{ContentTypeRichText, ContentTypePlainText, ContentTypeMarkdown, ContentTypeJSON, ContentTypeImageRef}
+		case "min", "max":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			keyword := map[string]string{"min": "minimum", "max": "maximum"}[tag]
+			switch t.Kind() {
+			case reflect.String:
+				keyword = tag + "Length"
+			case reflect.Slice, reflect.Array:
+				keyword = tag + "Items"
+			case reflect.Map:
+				keyword = tag + "Properties"
+			}
+			schema[keyword] = n
+		}
+	}
+}
+
+// fiberPathPattern matches the parameters of a Fiber route
+var fiberPathPattern = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// openAPIOperation builds the OpenAPI operation object of a route
+func (s *openAPISchemas) openAPIOperation(op apiOperation) map[string]interface{} {
+	var parameters []interface{}
+	for _, match := range fiberPathPattern.FindAllStringSubmatch(op.Path, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, param := range op.Query {
+		kind := param.Type
+		if kind == "" {
+			kind = "string"
+		}
+		parameter := map[string]interface{}{
+			"name":   param.Name,
+			"in":     "query",
+			"schema": map[string]interface{}{"type": kind},
+		}
+		if param.Description != "" {
+			parameter["description"] = param.Description
+		}
+		parameters = append(parameters, parameter)
+	}
+
+	operation := map[string]interface{}{
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+	}
+	if op.Tag != "" {
+		operation["tags"] = []string{op.Tag}
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if op.Request != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(op.Request))},
+			},
+		}
+	} else if op.Consumes != "" {
+		operation["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{
+				op.Consumes: map[string]interface{}{"schema": map[string]interface{}{"type": "object"}},
+			},
+		}
+	}
+
+	responses := map[string]interface{}{}
+	if op.WebSocket {
+		responses["101"] = map[string]interface{}{"description": "Switching to the WebSocket protocol"}
+	} else {
+		status := op.Status
+		if status == 0 {
+			status = 200
+		}
+		mediaType, schema := "application/json", map[string]interface{}{"type": "object"}
+		if op.Produces != "" {
+			mediaType, schema = op.Produces, map[string]interface{}{}
+		}
+		if op.Response != nil {
+			schema = s.schema(reflect.TypeOf(op.Response))
+		}
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": "Success",
+			"content":     map[string]interface{}{mediaType: map[string]interface{}{"schema": schema}},
+		}
+	}
+	responses["default"] = map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(APIErrorResponse{}))},
+		},
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+// operationID derives a unique ID from the method and path: GET /api/content/:id/diff
+// becomes getContentIdDiff
+func operationID(op apiOperation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(op.Path, "/api"), func(r rune) bool {
+		return r == '/' || r == ':' || r == '.' || r == '-' || r == '_'
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// openAPIDocument builds the OpenAPI 3 document of the routes registered on app.
+// Routes without an entry in apiOperations are listed without schemas, so the
+// document is never missing an endpoint.
+func openAPIDocument(app *fiber.App) map[string]interface{} {
+	s := &openAPISchemas{components: map[string]interface{}{}}
+
+	documented := make(map[string]apiOperation, len(apiOperations))
+	for _, op := range apiOperations {
+		documented[op.Method+" "+op.Path] = op
+	}
+	operations := make(map[string]apiOperation)
+	for _, route := range app.GetRoutes(true) {
+		if !strings.HasPrefix(route.Path, "/api/") || route.Method == fiber.MethodHead || route.Method == fiber.MethodOptions {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		if op, ok := documented[key]; ok {
+			operations[key] = op
+		} else {
+			operations[key] = apiOperation{Method: route.Method, Path: route.Path}
+		}
+	}
+
+	keys := make([]string, 0, len(operations))
+	for key := range operations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	paths := map[string]interface{}{}
+	for _, key := range keys {
+		op := operations[key]
+		path := fiberPathPattern.ReplaceAllString(op.Path, "{$1}")
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = s.openAPIOperation(op)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Site Editor API",
+			"version":     "1.0.0",
+			"description": "Content, AI command and workspace API of the site editor backend",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": s.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// Tokens are only required with AUTH_ENABLED
+		"security": []interface{}{map[string]interface{}{}, map[string]interface{}{"bearerAuth": []string{}}},
+	}
+}
+
+// GetOpenAPISpec serves the OpenAPI document of the API. It is built on the first
+// request, once every route has been registered.
+func GetOpenAPISpec(app *fiber.App) fiber.Handler {
+	var once sync.Once
+	var spec []byte
+	return func(c *fiber.Ctx) error {
+		once.Do(func() {
+			var err error
+			if spec, err = json.Marshal(openAPIDocument(app)); err != nil {
+				log.Printf("⚠️ Failed to build the OpenAPI document: %v", err)
+			}
+		})
+		if spec == nil {
+			return sendError(c, 500, "OPENAPI_UNAVAILABLE", "Failed to build the OpenAPI document", nil)
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(spec)
+	}
+}
+
+// apiDocsPage loads Swagger UI from a CDN and points it at /api/openapi.json
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Site Editor API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// GetAPIDocs serves Swagger UI for the OpenAPI document
+func GetAPIDocs() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.SendString(apiDocsPage)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// getOutputBatchInterval returns how long output lines are held to be sent together
+// from WS_OUTPUT_BATCH_INTERVAL. Falls back to 75ms
+func getOutputBatchInterval() time.Duration {
+	return getEnvDuration("WS_OUTPUT_BATCH_INTERVAL", 75*time.Millisecond)
+}
+
+// getOutputBatchLines returns the most lines of one output_batch message from
+// WS_OUTPUT_BATCH_LINES. Falls back to 50
+func getOutputBatchLines() int {
+	if n, err := strconv.Atoi(getEnvDefault("WS_OUTPUT_BATCH_LINES", "50")); err == nil && n > 0 {
+		return n
+	}
+	return 50
+}
+
+// outputBatcher coalesces the output messages of a command stream, so a burst of
+// stdout lines reaches the client as one output_batch message instead of a frame per
+// line. Other messages flush the held lines first, which keeps the order of the stream.
+// A line that arrives alone, and each part of a line too long to send at once, is sent
+// as a plain output message.
+type outputBatcher struct {
+	conn     *websocket.Conn
+	enabled  bool
+	maxLines int
+	interval time.Duration
+	held     []ProgressUpdate
+	timer    *time.Timer
+	due      <-chan time.Time // Fires when the held lines must go; nil while none are held
+}
+
+// newOutputBatcher batches the output of a stream unless the client connected with
+// ?outputBatch=false
+func newOutputBatcher(conn *websocket.Conn) *outputBatcher {
+	enabled, err := strconv.ParseBool(conn.Query("outputBatch", "true"))
+	batcher := &outputBatcher{
+		conn:     conn,
+		enabled:  err != nil || enabled,
+		maxLines: getOutputBatchLines(),
+		interval: getOutputBatchInterval(),
+		timer:    time.NewTimer(time.Hour),
+	}
+	batcher.timer.Stop()
+	return batcher
+}
+
+// send passes an update on to the client, holding output lines back to batch them
+func (b *outputBatcher) send(update ProgressUpdate) error {
+	if !b.enabled {
+		return sendWSMessage(b.conn, update)
+	}
+	if update.Type != WSMsgTypeOutput || update.Continued {
+		if err := b.flush(); err != nil {
+			return err
+		}
+		return sendWSMessage(b.conn, update)
+	}
+
+	b.held = append(b.held, update)
+	if len(b.held) >= b.maxLines {
+		return b.flush()
+	}
+	if len(b.held) == 1 {
+		b.timer.Reset(b.interval)
+		b.due = b.timer.C
+	}
+	return nil
+}
+
+// flush sends the held output lines
+func (b *outputBatcher) flush() error {
+	if len(b.held) == 0 {
+		return nil
+	}
+	held := b.held
+	b.held = nil
+	if !b.timer.Stop() {
+		select {
+		case <-b.timer.C:
+		default:
+		}
+	}
+	b.due = nil
+
+	if len(held) == 1 {
+		return sendWSMessage(b.conn, held[0])
+	}
+	lines := make([]interface{}, len(held))
+	for i, update := range held {
+		lines[i] = update.Data
+	}
+	return sendWSMessage(b.conn, ProgressUpdate{
+		Type:      WSMsgTypeOutputBatch,
+		Timestamp: held[0].Timestamp,
+		Data:      fiber.Map{"lines": lines},
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// PageMeta holds the search and link preview metadata of a page, written into the
+// <head> of its HTML file when the page is published or built. It belongs to the
+// project of the page, and its path to that project's workspace.
+type PageMeta struct {
+	Page        string `gorm:"primaryKey" json:"page"`
+	Path        string `json:"path"` // HTML file relative to the workspace of the page's project
+	Title       string `json:"title,omitempty"`
+	Description string `gorm:"type:text" json:"description,omitempty"`
+	OGImage     string `json:"ogImage,omitempty"`
+	Canonical   string `json:"canonical,omitempty"`
+	UpdatedBy   string `json:"updatedBy,omitempty"`
+	UpdatedAt   int64  `json:"updatedAt,omitempty"`
+}
+
+// PageMetaRequest is the body of PUT /api/pages/:page/meta. Empty fields leave the
+// tags of the page as they are.
+type PageMetaRequest struct {
+	Path        string `json:"path,omitempty" validate:"max=512"` // <page>.html when omitted
+	Title       string `json:"title,omitempty" validate:"max=200"`
+	Description string `json:"description,omitempty" validate:"max=1000"`
+	OGImage     string `json:"ogImage,omitempty" validate:"max=2048"`
+	Canonical   string `json:"canonical,omitempty" validate:"omitempty,url,max=2048"`
+}
+
+var (
+	titleTagPattern = regexp.MustCompile(`(?is)<title\b[^>]*>.*?</title\s*>`)
+	linkTagPattern  = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+	headEndPattern  = regexp.MustCompile(`(?i)</head\s*>`)
+	tagAttrPattern  = regexp.MustCompile(`(?is)\b(name|property|rel)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// removedTag stands in for a duplicate tag until it is removed with the indentation
+// of its line
+const removedTag = "\x00"
+
+var removedTagPattern = regexp.MustCompile(`\n[ \t]*\x00|\x00`)
+
+// tagKey returns the name, property or rel attribute that identifies a meta or link tag
+func tagKey(tag string) string {
+	m := tagAttrPattern.FindStringSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(m[2] + m[3] + m[4]))
+}
+
+// pageMetaFile returns the HTML file of a page, relative to the workspace
+func pageMetaFile(meta PageMeta) string {
+	if meta.Path != "" {
+		return meta.Path
+	}
+	return meta.Page + ".html"
+}
+
+// lookupPageFile returns the HTML file of a page from its metadata, otherwise <page>.html
+func lookupPageFile(db *gorm.DB, page string) (string, error) {
+	meta := PageMeta{Page: page}
+	if err := db.Limit(1).Find(&meta, "page = ?", page).Error; err != nil {
+		return "", err
+	}
+	return pageMetaFile(meta), nil
+}
+
+// injectPageMeta returns the HTML of a page with its metadata tags replaced or, when
+// missing, added at the end of the head. The title also fills og:title and the
+// description og:description. Pages without </head> are returned unchanged.
+func injectPageMeta(page string, meta PageMeta) (string, bool) {
+	end := headEndPattern.FindStringIndex(page)
+	if end == nil {
+		return page, false
+	}
+	head, rest := page[:end[0]], page[end[0]:]
+
+	var added []string
+	setTag := func(pattern *regexp.Regexp, key, tag string) {
+		found := false
+		head = pattern.ReplaceAllStringFunc(head, func(existing string) string {
+			if key != "" && tagKey(existing) != key {
+				return existing
+			}
+			if found {
+				return removedTag // A duplicate of the tag
+			}
+			found = true
+			return tag
+		})
+		if !found {
+			added = append(added, tag)
+		}
+	}
+	metaTag := func(attr, key, value string) {
+		if value != "" {
+			setTag(metaTagPattern, key, fmt.Sprintf(`<meta %s="%s" content="%s">`, attr, key, html.EscapeString(value)))
+		}
+	}
+
+	if meta.Title != "" {
+		setTag(titleTagPattern, "", "<title>"+html.EscapeString(meta.Title)+"</title>")
+	}
+	metaTag("name", "description", meta.Description)
+	metaTag("property", "og:title", meta.Title)
+	metaTag("property", "og:description", meta.Description)
+	metaTag("property", "og:image", meta.OGImage)
+	if meta.Canonical != "" {
+		setTag(linkTagPattern, "canonical", `<link rel="canonical" href="`+html.EscapeString(meta.Canonical)+`">`)
+	}
+
+	head = removedTagPattern.ReplaceAllString(head, "")
+	if len(added) > 0 {
+		if i := strings.LastIndex(head, "\n"); i >= 0 && strings.TrimSpace(head[i:]) == "" {
+			head = head[:i]
+		}
+		head += "\n  " + strings.Join(added, "\n  ") + "\n"
+	}
+	patched := head + rest
+	return patched, patched != page
+}
+
+// applyPageMeta patches the metadata of a page into its HTML file in the workspace of
+// the page's project. Pages without metadata, or whose file the workspace does not
+// have, are skipped.
+func applyPageMeta(db *gorm.DB, page string) error {
+	var meta PageMeta
+	if err := db.Limit(1).Find(&meta, "page = ?", page).Error; err != nil || meta.Page == "" {
+		return err
+	}
+	workspaceDir, err := resolveWorkspaceDir(db, pageProject(db, page))
+	if err != nil {
+		return err
+	}
+	_, err = writePageMeta(workspaceDir, meta)
+	return err
+}
+
+// applyAllPageMeta patches the metadata of the pages of a project ("" for the global
+// workspace) into its workspace and returns how many files changed
+func applyAllPageMeta(db *gorm.DB, projectID, workspaceDir string) (int, error) {
+	pages := db.Model(&Page{}).Select("name").Where("COALESCE(project_id, '') = ?", projectID)
+	var metas []PageMeta
+	if err := db.Where("page IN (?)", pages).Order("page").Find(&metas).Error; err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, meta := range metas {
+		written, err := writePageMeta(workspaceDir, meta)
+		if err != nil {
+			log.Printf("⚠️ Failed to write the metadata of page %s: %v", meta.Page, err)
+		} else if written {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// writePageMeta patches the HTML file of a page and reports whether it changed
+func writePageMeta(workspaceDir string, meta PageMeta) (bool, error) {
+	file, err := resolvePathInDir(workspaceDir, pageMetaFile(meta))
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	patched, changed := injectPageMeta(string(data), meta)
+	if !changed {
+		return false, nil
+	}
+	if err := writeFileAtomic(file, []byte(patched)); err != nil {
+		return false, err
+	}
+	log.Printf("🔖 Metadata of page %s written to %s", meta.Page, pageMetaFile(meta))
+	return true, nil
+}
+
+// checkMetaImage accepts an absolute http(s) URL or a path on the site
+func checkMetaImage(image string) error {
+	if image == "" {
+		return nil
+	}
+	u, err := url.Parse(image)
+	if err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") || (u.Scheme == "" && u.Host != "") {
+		return newAPIError(400, "INVALID_OG_IMAGE", "ogImage must be an http or https URL or a path on the site", image)
+	}
+	return nil
+}
+
+// GetPageMeta returns the metadata of a page; fields never set are empty
+func GetPageMeta(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page := c.Params("page")
+		var count int64
+		if err := db.Model(&Page{}).Where("name = ?", page).Count(&count).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load pages", nil)
+		}
+		if count == 0 {
+			return sendError(c, 404, "PAGE_NOT_FOUND", "Page not found", page)
+		}
+
+		meta := PageMeta{Page: page}
+		if err := db.Limit(1).Find(&meta, "page = ?", page).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load page metadata", nil)
+		}
+		meta.Path = pageMetaFile(meta)
+		return c.JSON(meta)
+	}
+}
+
+// PutPageMeta replaces the metadata of a page. It reaches the HTML file the next time
+// a block of the page is published or the site is built.
+func PutPageMeta(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page := c.Params("page")
+		req := validatedBody[PageMetaRequest](c)
+
+		var count int64
+		if err := db.Model(&Page{}).Where("name = ?", page).Count(&count).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load pages", nil)
+		}
+		if count == 0 {
+			return sendError(c, 404, "PAGE_NOT_FOUND", "Page not found", page)
+		}
+		if err := checkMetaImage(req.OGImage); err != nil {
+			return err
+		}
+		rel := ""
+		if req.Path != "" {
+			if ext := strings.ToLower(path.Ext(req.Path)); ext != ".html" && ext != ".htm" {
+				return sendError(c, 400, "INVALID_PATH", "The path must be an .html or .htm file", req.Path)
+			}
+			workspaceDir, err := resolveWorkspaceDir(db, pageProject(db, page))
+			if err != nil {
+				return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+			}
+			if _, err := resolvePathInDir(workspaceDir, req.Path); err != nil {
+				return workspacePathError(c, err)
+			}
+			rel = path.Clean("/" + req.Path)[1:]
+		}
+
+		meta := PageMeta{
+			Page:        page,
+			Path:        rel,
+			Title:       strings.TrimSpace(req.Title),
+			Description: strings.TrimSpace(req.Description),
+			OGImage:     strings.TrimSpace(req.OGImage),
+			Canonical:   req.Canonical,
+			UpdatedBy:   currentUserID(c),
+			UpdatedAt:   time.Now().Unix(),
+		}
+		if err := db.Save(&meta).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to save page metadata", nil)
+		}
+
+		log.Printf("🔖 Metadata of page %s updated", page)
+		meta.Path = pageMetaFile(meta)
+		return c.JSON(meta)
+	}
+}
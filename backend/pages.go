@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Page groups the content blocks that belong to one page of the site
+type Page struct {
+	Name      string `gorm:"primaryKey" json:"name"`
+	ProjectID string `gorm:"index" json:"project_id,omitempty"` // Project the page was created in; its team may edit the page
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// PageSummary is a page in the response of GET /api/pages
+type PageSummary struct {
+	Name         string `json:"name"`
+	ContentCount int64  `json:"content_count"` // Blocks on the page, counting each translated block once
+	ProjectID    string `json:"project_id,omitempty"`
+	CreatedAt    int64  `json:"created_at"`
+	UpdatedAt    int64  `json:"updated_at"`
+}
+
+// PageListResponse is the response of GET /api/pages
+type PageListResponse struct {
+	Pages []PageSummary `json:"pages"`
+}
+
+// PageContentResponse is the response of GET /api/pages/:page/content
+type PageContentResponse struct {
+	Page  string            `json:"page"`
+	Items []ContentResponse `json:"items"`
+}
+
+// PageDeletedResponse is the response of DELETE /api/pages/:page
+type PageDeletedResponse struct {
+	Page    string `json:"page"`
+	Deleted int64  `json:"deleted"` // Content blocks purged with the page
+}
+
+// pageFromContentID derives the page namespace from a "page:element" content ID
+func pageFromContentID(id string) string {
+	if i := strings.Index(id, ":"); i > 0 {
+		return id[:i]
+	}
+	return ""
+}
+
+// touchPage creates the page record if needed and bumps its update time
+func touchPage(db *gorm.DB, name string) error {
+	if name == "" {
+		return nil
+	}
+	now := time.Now().Unix()
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"updated_at": now}),
+	}).Create(&Page{Name: name, CreatedAt: now, UpdatedAt: now}).Error
+}
+
+// backfillContentPages assigns a page to content rows saved before pages existed
+func backfillContentPages(db *gorm.DB) {
+	var contents []Content
+	if err := db.Where("page = ? OR page IS NULL", "").Find(&contents).Error; err != nil {
+		log.Printf("⚠️ Failed to backfill content pages: %v", err)
+		return
+	}
+
+	for _, content := range contents {
+		page := pageFromContentID(content.ID)
+		if page == "" {
+			continue
+		}
+		db.Model(&Content{}).Where("id = ?", content.ID).Update("page", page)
+		touchPage(db, page)
+	}
+}
+
+// ListPages returns all known pages with their content block counts. ?projectId= keeps
+// the pages of one project.
+func ListPages(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := db.Order("name")
+		if projectID := c.Query("projectId"); projectID != "" {
+			query = query.Where("project_id = ?", projectID)
+		}
+		var pages []Page
+		if err := query.Find(&pages).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load pages", nil)
+		}
+
+		type pageCount struct {
+			Page  string
+			Count int64
+		}
+		var counts []pageCount
+		db.Model(&Content{}).Select("page, count(DISTINCT id) as count").Group("page").Scan(&counts)
+
+		countByPage := make(map[string]int64, len(counts))
+		for _, pc := range counts {
+			countByPage[pc.Page] = pc.Count
+		}
+
+		items := make([]PageSummary, 0, len(pages))
+		for _, page := range pages {
+			items = append(items, PageSummary{
+				Name:         page.Name,
+				ContentCount: countByPage[page.Name],
+				ProjectID:    page.ProjectID,
+				CreatedAt:    page.CreatedAt,
+				UpdatedAt:    page.UpdatedAt,
+			})
+		}
+
+		return c.JSON(PageListResponse{Pages: items})
+	}
+}
+
+// GetPageContent returns every content block belonging to a page, each in the
+// best locale of the ?locale= fallback chain
+func GetPageContent(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page := c.Params("page")
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+		chain := localeFallbacks(locale)
+
+		var contents []Content
+		if err := db.Where("page = ? AND locale IN ?", page, chain).Order("id").Find(&contents).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load content", nil)
+		}
+
+		state := c.Query("state", ContentStateDraft)
+		contents = pickLocalized(contents, chain)
+		items := make([]ContentResponse, 0, len(contents))
+		for _, content := range contents {
+			items = append(items, localizedResponse(content, state, locale))
+		}
+
+		return sendConditional(c, PageContentResponse{
+			Page:  page,
+			Items: items,
+		}, 0)
+	}
+}
+
+// DeletePage purges a page and all of its content blocks
+func DeletePage(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page := c.Params("page")
+
+		var deleted int64
+		var blocks []Content
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("page = ?", page).Order("id").Find(&blocks).Error; err != nil {
+				return err
+			}
+			// Purged, not trashed: the page goes away together with its blocks
+			result := tx.Unscoped().Where("page = ?", page).Delete(&Content{})
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted = result.RowsAffected
+			if err := tx.Delete(&PageMeta{}, "page = ?", page).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&Page{}, "name = ?", page).Error
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete page", nil)
+		}
+
+		log.Printf("🗑️ Page deleted: %s (%d content blocks)", page, deleted)
+		recordAudit(db, c, AuditPageDelete, page, blocks, nil, fmt.Sprintf("%d content blocks", deleted))
+
+		return c.JSON(PageDeletedResponse{
+			Page:    page,
+			Deleted: deleted,
+		})
+	}
+}
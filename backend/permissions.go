@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// WebSocket messages of permission prompts
+const (
+	WSMsgTypePermissionRequest = "permission_request" // A tool waits for approve or deny
+	WSMsgTypeApprove           = "approve"
+	WSMsgTypeDeny              = "deny"
+)
+
+// Answers to a permission request
+const (
+	PermissionApproved = "approved"
+	PermissionDenied   = "denied"
+	PermissionTimedOut = "timed_out" // Nobody answered within AI_PERMISSION_TIMEOUT
+)
+
+var errPermissionNotFound = errors.New("no permission request with this ID is waiting")
+
+// isPermissionPromptEnabled returns true when the permission mode (permissions.mode,
+// AI_PERMISSION_MODE) is prompt: tools that match the permission policy wait for the
+// client to approve them
+func isPermissionPromptEnabled() bool {
+	return appConfig.Permissions.Mode == "prompt"
+}
+
+// getPermissionTools returns the tools that always need approval (permissions.tools,
+// AI_PERMISSION_TOOLS; * for every tool)
+// Falls back to Bash
+func getPermissionTools() []string {
+	return appConfig.Permissions.Tools
+}
+
+// getPermissionWrites returns which file changes need approval (permissions.writes,
+// AI_PERMISSION_WRITES): outside-page, all or none
+// Falls back to outside-page
+func getPermissionWrites() string {
+	return appConfig.Permissions.Writes
+}
+
+// getPermissionTimeout returns how long a tool waits for an answer before it is denied
+// (permissions.timeout, AI_PERMISSION_TIMEOUT)
+// Falls back to 5 minutes
+func getPermissionTimeout() time.Duration {
+	return appConfig.Permissions.Timeout.Duration()
+}
+
+// validate checks the permission prompt settings
+func (p PermissionConfig) validate() []error {
+	var errs []error
+	if p.Mode != "off" && p.Mode != "prompt" {
+		errs = append(errs, fmt.Errorf("permissions.mode must be off or prompt, got %q", p.Mode))
+	}
+	switch p.Writes {
+	case "outside-page", "all", "none":
+	default:
+		errs = append(errs, fmt.Errorf("permissions.writes must be outside-page, all or none, got %q", p.Writes))
+	}
+	if p.Timeout <= 0 {
+		errs = append(errs, errors.New("permissions.timeout must be positive"))
+	}
+	return errs
+}
+
+// permissionPolicy decides which tools of a command need approval
+type permissionPolicy struct {
+	tools   []string
+	writes  string
+	workDir string
+	pageDir string // Directory of the command's page, relative to workDir
+}
+
+// newPermissionPolicy returns the policy for a command. A command without a page may
+// change any file of the workspace without asking under outside-page.
+func newPermissionPolicy(db *gorm.DB, command *AICommand, workDir string) permissionPolicy {
+	policy := permissionPolicy{tools: getPermissionTools(), writes: getPermissionWrites(), workDir: workDir, pageDir: "."}
+	if command.Page != "" {
+		if file, err := lookupPageFile(db, command.Page); err == nil {
+			policy.pageDir = filepath.Dir(file)
+		}
+	}
+	return policy
+}
+
+// check returns why a tool needs approval, or "" when it may run
+func (p permissionPolicy) check(tool string, input json.RawMessage) string {
+	if slices.Contains(p.tools, "*") || slices.Contains(p.tools, tool) {
+		if slices.Contains(shellTools, tool) {
+			return "Runs a shell command"
+		}
+		return fmt.Sprintf("%s needs approval", tool)
+	}
+	if !slices.Contains(fileChangeTools, tool) {
+		return ""
+	}
+
+	switch p.writes {
+	case "all":
+		return "Changes a file"
+	case "outside-page":
+		var fields map[string]interface{}
+		json.Unmarshal(input, &fields)
+		for _, key := range []string{"file_path", "notebook_path"} {
+			path, _ := fields[key].(string)
+			if path == "" {
+				continue
+			}
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(p.workDir, path)
+			}
+			rel, err := filepath.Rel(filepath.Join(p.workDir, p.pageDir), path)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return "Changes a file outside the page directory"
+			}
+		}
+	}
+	return ""
+}
+
+// permissionPrompts holds the permission requests of a command that wait for an answer
+type permissionPrompts struct {
+	mu      sync.Mutex
+	seq     int
+	waiting map[string]chan permissionAnswer
+}
+
+type permissionAnswer struct {
+	approved bool
+	reason   string
+}
+
+// open registers a new request and returns its ID and the channel of its answer
+func (p *permissionPrompts) open() (string, chan permissionAnswer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.waiting == nil {
+		p.waiting = make(map[string]chan permissionAnswer)
+	}
+	p.seq++
+	id := fmt.Sprintf("perm_%d", p.seq)
+	answer := make(chan permissionAnswer, 1)
+	p.waiting[id] = answer
+	return id, answer
+}
+
+// remove drops a request that was answered or gave up
+func (p *permissionPrompts) remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.waiting, id)
+}
+
+// answer passes the client's decision on to a waiting request
+func (p *permissionPrompts) answer(id string, approved bool, reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	answer, ok := p.waiting[id]
+	if !ok {
+		return errPermissionNotFound
+	}
+	delete(p.waiting, id)
+	answer <- permissionAnswer{approved: approved, reason: reason}
+	return nil
+}
+
+// askPermission returns the ProviderRequest.Permission callback of a command: tools
+// the policy lets through run, the others are sent to the client as a
+// permission_request and wait until it approves or denies them, the command ends or
+// AI_PERMISSION_TIMEOUT passes
+func askPermission(session *AICommandSession, policy permissionPolicy) func(ctx context.Context, tool string, input json.RawMessage) PermissionDecision {
+	return func(ctx context.Context, tool string, input json.RawMessage) PermissionDecision {
+		reason := policy.check(tool, input)
+		if reason == "" {
+			return PermissionDecision{}
+		}
+
+		summary := toolSummary(input, policy.workDir)
+		timeout := getPermissionTimeout()
+		id, answer := session.permissions.open()
+		defer session.permissions.remove(id)
+		session.publish(ProgressUpdate{
+			Type:      WSMsgTypePermissionRequest,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   strings.TrimSpace(fmt.Sprintf("Allow %s %s?", tool, summary)),
+			Data: fiber.Map{
+				"id":         id,
+				"tool":       tool,
+				"summary":    summary,
+				"input":      input,
+				"reason":     reason,
+				"timeoutSec": int(timeout.Seconds()),
+			},
+		})
+
+		decision := PermissionDecision{Decided: true}
+		outcome := PermissionDenied
+		select {
+		case a := <-answer:
+			decision.Allow, decision.Reason = a.approved, a.reason
+			if a.approved {
+				outcome = PermissionApproved
+			} else if decision.Reason == "" {
+				decision.Reason = "The user denied this tool"
+			}
+		case <-time.After(timeout):
+			outcome = PermissionTimedOut
+			decision.Reason = fmt.Sprintf("Nobody approved the tool within %s", timeout)
+		case <-ctx.Done():
+			decision.Reason = "The command was interrupted"
+		}
+
+		session.publish(ProgressUpdate{
+			Type:      WSMsgTypeStatus,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   fmt.Sprintf("%s %s", tool, strings.ReplaceAll(outcome, "_", " ")),
+			Data:      fiber.Map{"permission": id, "decision": outcome},
+		})
+		return decision
+	}
+}
+
+// answerPermission handles an approve or deny message of the client
+func answerPermission(session *AICommandSession, msgType string, msg map[string]interface{}) error {
+	id, _ := msg["id"].(string)
+	reason, _ := msg["reason"].(string)
+	return session.permissions.answer(id, msgType == WSMsgTypeApprove, reason)
+}
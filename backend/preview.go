@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// previewStableAfter is how long a preview server must stay up before its crash count resets
+const previewStableAfter = time.Minute
+
+// PreviewServer is a project's dev server, run as an agent session and restarted when it dies
+type PreviewServer struct {
+	ProjectID string `json:"projectId"`
+	Command   string `json:"command"`
+	Port      int    `json:"port"`
+	Status    string `json:"status"` // starting, running, restarting, stopping, stopped, crashed
+	Restarts  int    `json:"restarts"`
+	SessionID string `json:"sessionId"`
+	StartedAt int64  `json:"startedAt"`
+	LastExit  string `json:"lastExit,omitempty"`
+
+	workDir  string
+	env      ChildEnv
+	db       *gorm.DB
+	session  *AgentSession
+	failures int // Consecutive crashes, for the restart backoff
+	stopped  bool
+}
+
+// PreviewRequest is the body of POST /api/preview/start and /stop
+type PreviewRequest struct {
+	ProjectID string `json:"projectId"`
+}
+
+var (
+	// previews holds the preview server of each project, including stopped ones
+	previews  = make(map[string]*PreviewServer)
+	previewMu sync.Mutex
+)
+
+// getPreviewCommand returns the project's dev server command from its "previewCommand" setting,
+// then PREVIEW_COMMAND. Falls back to npm run dev on $PORT
+func getPreviewCommand(project *Project) string {
+	if command, ok := project.Settings["previewCommand"].(string); ok && strings.TrimSpace(command) != "" {
+		return command
+	}
+	return getEnvDefault("PREVIEW_COMMAND", "npm run dev -- --port $PORT")
+}
+
+// getPreviewPortRange returns the ports handed to preview servers from PREVIEW_PORTS
+// Falls back to 5200-5299
+func getPreviewPortRange() (int, int) {
+	low, high, ok := strings.Cut(getEnvDefault("PREVIEW_PORTS", "5200-5299"), "-")
+	if ok {
+		from, errFrom := strconv.Atoi(strings.TrimSpace(low))
+		to, errTo := strconv.Atoi(strings.TrimSpace(high))
+		if errFrom == nil && errTo == nil && from > 0 && from <= to && to <= 65535 {
+			return from, to
+		}
+	}
+	return 5200, 5299
+}
+
+// getPreviewMaxRestarts returns how many crashes in a row are restarted from PREVIEW_MAX_RESTARTS
+// Falls back to 5
+func getPreviewMaxRestarts() int {
+	if n, err := strconv.Atoi(getEnvDefault("PREVIEW_MAX_RESTARTS", "5")); err == nil && n >= 0 {
+		return n
+	}
+	return 5
+}
+
+// allocatePreviewPort returns a port in the range that no other preview holds and
+// nothing is listening on. The caller holds previewMu.
+func allocatePreviewPort() (int, error) {
+	used := make(map[int]bool)
+	for _, p := range previews {
+		if !p.stopped {
+			used[p.Port] = true
+		}
+	}
+
+	from, to := getPreviewPortRange()
+	for port := from; port <= to; port++ {
+		if used[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free port in %d-%d", from, to)
+}
+
+// launch starts a new process for the preview. The caller holds previewMu.
+func (p *PreviewServer) launch() {
+	session := &AgentSession{
+		Kind:    SessionKindPreview,
+		Command: "sh",
+		Args:    []string{"-c", p.Command},
+		WorkDir: p.workDir,
+		Env:     p.env,
+		db:      p.db,
+	}
+	session.onExit = func(err error) {
+		handlePreviewExit(p, session, err)
+	}
+	launchAgent(session)
+
+	p.session = session
+	p.SessionID = session.ID
+	p.Status = "starting"
+	p.StartedAt = session.StartTime.Unix()
+	go waitForPreviewPort(p, session)
+}
+
+// waitForPreviewPort marks the preview running once its port accepts connections
+func waitForPreviewPort(p *PreviewServer, session *AgentSession) {
+	addr := fmt.Sprintf("127.0.0.1:%d", p.Port)
+	for session.Context.Err() == nil {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			previewMu.Lock()
+			if p.session == session && p.Status == "starting" {
+				p.Status = "running"
+				log.Printf("🖥️ Preview for project %s is up on port %d", p.ProjectID, p.Port)
+			}
+			previewMu.Unlock()
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// handlePreviewExit restarts a preview server that died on its own, backing off
+// exponentially, and gives up after PREVIEW_MAX_RESTARTS crashes in a row
+func handlePreviewExit(p *PreviewServer, session *AgentSession, err error) {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+
+	if p.session != session {
+		return
+	}
+	if err != nil {
+		p.LastExit = err.Error()
+	} else {
+		p.LastExit = "exited"
+	}
+	if p.stopped {
+		p.Status = "stopped"
+		log.Printf("🖥️ Preview for project %s stopped", p.ProjectID)
+		return
+	}
+
+	if time.Since(session.StartTime) >= previewStableAfter {
+		p.failures = 0
+	}
+	p.failures++
+	if p.failures > getPreviewMaxRestarts() {
+		p.Status = "crashed"
+		log.Printf("❌ Preview for project %s crashed %d times in a row, giving up: %s", p.ProjectID, p.failures, p.LastExit)
+		return
+	}
+
+	delay := min(time.Second<<(p.failures-1), 30*time.Second)
+	p.Status = "restarting"
+	log.Printf("⚠️ Preview for project %s exited (%s), restarting in %s", p.ProjectID, p.LastExit, delay)
+
+	time.AfterFunc(delay, func() {
+		previewMu.Lock()
+		defer previewMu.Unlock()
+		if p.stopped || p.session != session || shuttingDown.Load() {
+			return
+		}
+		p.Restarts++
+		p.launch()
+	})
+}
+
+// stopPreview stops a project's preview server; false means none was active
+func stopPreview(projectID string) bool {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+
+	p, exists := previews[projectID]
+	if !exists || p.stopped {
+		return false
+	}
+	p.stopped = true
+	if p.Status == "crashed" || p.Status == "restarting" {
+		// No process is running; a pending restart sees the stopped flag
+		p.Status = "stopped"
+	} else {
+		p.Status = "stopping"
+	}
+	p.session.Cancel()
+	return true
+}
+
+// stopAllPreviews stops every preview server, e.g. on shutdown
+func stopAllPreviews() int {
+	previewMu.Lock()
+	ids := make([]string, 0, len(previews))
+	for id := range previews {
+		ids = append(ids, id)
+	}
+	previewMu.Unlock()
+
+	count := 0
+	for _, id := range ids {
+		if stopPreview(id) {
+			count++
+		}
+	}
+	return count
+}
+
+// previewSnapshot copies the public state of a preview under the lock
+func previewSnapshot(p *PreviewServer) PreviewServer {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	return PreviewServer{
+		ProjectID: p.ProjectID,
+		Command:   p.Command,
+		Port:      p.Port,
+		Status:    p.Status,
+		Restarts:  p.Restarts,
+		SessionID: p.SessionID,
+		StartedAt: p.StartedAt,
+		LastExit:  p.LastExit,
+	}
+}
+
+func previewResponse(p *PreviewServer) fiber.Map {
+	snapshot := previewSnapshot(p)
+	return fiber.Map{
+		"preview":   snapshot,
+		"url":       fmt.Sprintf("http://localhost:%d", snapshot.Port),
+		"streamUrl": "/api/agent/stream/" + snapshot.SessionID,
+	}
+}
+
+// StartPreview starts the project's dev server, or returns the one already running
+func StartPreview(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req PreviewRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if req.ProjectID == "" {
+			return sendError(c, 400, "MISSING_PROJECT", "projectId is required", "")
+		}
+
+		var project Project
+		if err := db.First(&project, "id = ?", req.ProjectID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", req.ProjectID)
+			}
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load project", err.Error())
+		}
+
+		env, err := buildChildEnv(db, req.ProjectID)
+		if err != nil {
+			return sendError(c, 500, "ENV_ERROR", "Failed to prepare environment", err.Error())
+		}
+
+		previewMu.Lock()
+		if existing, ok := previews[req.ProjectID]; ok && !existing.stopped {
+			previewMu.Unlock()
+			return c.JSON(fiber.Map{
+				"success": true,
+				"message": "Preview already running",
+				"data":    previewResponse(existing),
+			})
+		}
+
+		port, err := allocatePreviewPort()
+		if err != nil {
+			previewMu.Unlock()
+			return sendError(c, 503, "NO_PREVIEW_PORT", "No port available for the preview server", err.Error())
+		}
+
+		p := &PreviewServer{
+			ProjectID: req.ProjectID,
+			Command:   getPreviewCommand(&project),
+			Port:      port,
+			workDir:   project.WorkspacePath,
+			env:       env.withOverrides(map[string]string{"PORT": strconv.Itoa(port)}),
+			db:        db,
+		}
+		previews[req.ProjectID] = p
+		p.launch()
+		previewMu.Unlock()
+
+		log.Printf("🖥️ Preview for project %s starting on port %d: %s", req.ProjectID, port, p.Command)
+		return c.Status(202).JSON(fiber.Map{
+			"success": true,
+			"data":    previewResponse(p),
+		})
+	}
+}
+
+// StopPreview stops the project's dev server and its restarts
+func StopPreview() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req PreviewRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if !stopPreview(req.ProjectID) {
+			return sendError(c, 404, "PREVIEW_NOT_RUNNING", "No preview is running for this project", req.ProjectID)
+		}
+
+		previewMu.Lock()
+		p := previews[req.ProjectID]
+		previewMu.Unlock()
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    previewResponse(p),
+		})
+	}
+}
+
+// GetPreviewStatus returns the state of the project's dev server (?projectId=)
+func GetPreviewStatus() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Query("projectId")
+
+		previewMu.Lock()
+		p, exists := previews[projectID]
+		previewMu.Unlock()
+		if !exists {
+			return sendError(c, 404, "PREVIEW_NOT_FOUND", "No preview has been started for this project", projectID)
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    previewResponse(p),
+		})
+	}
+}
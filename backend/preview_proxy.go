@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	fws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/proxy"
+	"github.com/gofiber/websocket/v2"
+)
+
+// previewPort returns the port of a project's preview server unless it is stopped or gave up
+func previewPort(projectID string) (int, bool) {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+
+	p, exists := previews[projectID]
+	if !exists || p.stopped || p.Status == "crashed" {
+		return 0, false
+	}
+	return p.Port, true
+}
+
+// PreviewProxy serves /preview/:projectId/* from the project's preview server, so the
+// editor iframe loads it from the same origin. The /preview/:projectId prefix is stripped
+// and passed on in X-Forwarded-Prefix. WebSocket upgrades (dev server HMR) are proxied too.
+func PreviewProxy() fiber.Handler {
+	allowed := getWSAllowedOrigins()
+
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("projectId")
+		port, ok := previewPort(projectID)
+		if !ok {
+			return sendError(c, 503, "PREVIEW_NOT_RUNNING", "No preview is running for this project", projectID)
+		}
+
+		prefix := "/preview/" + projectID
+		query := ""
+		if qs := c.Request().URI().QueryString(); len(qs) > 0 {
+			query = "?" + string(qs)
+		}
+
+		// Relative asset URLs only resolve below the prefix with a trailing slash
+		rest := c.Params("*")
+		if rest == "" && !strings.HasSuffix(c.Path(), "/") {
+			return c.Redirect(prefix+"/"+query, fiber.StatusMovedPermanently)
+		}
+		target := fmt.Sprintf("127.0.0.1:%d/%s%s", port, rest, query)
+
+		if websocket.IsWebSocketUpgrade(c) {
+			if !wsOriginAllowed(c.Get("Origin"), allowed) {
+				return sendError(c, 403, "ORIGIN_NOT_ALLOWED", "WebSocket connections from this origin are not allowed", c.Get("Origin"))
+			}
+			return proxyPreviewWebSocket(c, "ws://"+target)
+		}
+
+		c.Request().Header.Set("X-Forwarded-Prefix", prefix)
+		c.Request().Header.Set("X-Forwarded-Host", c.Hostname())
+		c.Request().Header.Set("X-Forwarded-Proto", c.Protocol())
+		c.Request().Header.Set("X-Forwarded-For", c.IP())
+		if err := proxy.Do(c, "http://"+target); err != nil {
+			return sendError(c, 502, "PREVIEW_UNREACHABLE", "Preview server did not answer", err.Error())
+		}
+
+		// Keep redirects from the dev server inside the prefix
+		if location := string(c.Response().Header.Peek(fiber.HeaderLocation)); strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+			c.Response().Header.Set(fiber.HeaderLocation, prefix+location)
+		}
+		return nil
+	}
+}
+
+// proxyPreviewWebSocket connects to the preview server first, so the subprotocol it picks
+// (e.g. vite-hmr) can be offered to the browser, then relays messages both ways
+func proxyPreviewWebSocket(c *fiber.Ctx, target string) error {
+	header := http.Header{}
+	if protocols := c.Get(fiber.HeaderSecWebSocketProtocol); protocols != "" {
+		header.Set(fiber.HeaderSecWebSocketProtocol, protocols)
+	}
+
+	upstream, _, err := fws.DefaultDialer.Dial(target, header)
+	if err != nil {
+		return sendError(c, 502, "PREVIEW_UNREACHABLE", "Preview server refused the WebSocket", err.Error())
+	}
+
+	var subprotocols []string
+	if protocol := upstream.Subprotocol(); protocol != "" {
+		subprotocols = []string{protocol}
+	}
+
+	return websocket.New(func(conn *websocket.Conn) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			relayWebSocket(conn.Conn, upstream)
+		}()
+		relayWebSocket(upstream, conn.Conn)
+
+		// Either side closing ends both directions
+		upstream.Close()
+		conn.Close()
+		<-done
+	}, websocket.Config{Subprotocols: subprotocols})(c)
+}
+
+// relayWebSocket copies messages from src to dst until src closes, then passes the close on
+func relayWebSocket(dst, src *fws.Conn) {
+	for {
+		messageType, message, err := src.ReadMessage()
+		if err != nil {
+			code := fws.CloseNormalClosure
+			if closeErr, ok := err.(*fws.CloseError); ok {
+				code = closeErr.Code
+			}
+			if code == fws.CloseNoStatusReceived {
+				code = fws.CloseNormalClosure
+			}
+			dst.WriteMessage(fws.CloseMessage, fws.FormatCloseMessage(code, ""))
+			return
+		}
+		if err := dst.WriteMessage(messageType, message); err != nil {
+			return
+		}
+	}
+}
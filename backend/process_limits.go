@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StatusResourceLimit is the final status of a command or agent run that was killed
+// for exceeding PROCESS_MEMORY_LIMIT or PROCESS_OUTPUT_LIMIT
+const StatusResourceLimit = "resource_limit_exceeded"
+
+// ProcessLimits caps the resources of an AI command or agent process. Zero means unlimited.
+type ProcessLimits struct {
+	CPU    float64 // Cores, enforced with the cgroup cpu.max (throttled, never killed)
+	Memory int64   // Bytes, enforced with the cgroup memory.max (OOM killed)
+	Output int64   // Bytes of stdout and stderr after which the process is killed
+}
+
+// ResourceLimitError reports the limit a process ran into
+type ResourceLimitError struct {
+	Limit  string // memory or output
+	Detail string
+}
+
+func (e *ResourceLimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded: %s", e.Limit, e.Detail)
+}
+
+// getProcessLimits returns the limits of spawned processes (processes.*, PROCESS_CPU_LIMIT,
+// PROCESS_MEMORY_LIMIT and PROCESS_OUTPUT_LIMIT)
+// Falls back to no limits
+func getProcessLimits() ProcessLimits {
+	p := appConfig.Processes
+	return ProcessLimits{CPU: p.CPULimit, Memory: int64(p.MemoryLimit), Output: int64(p.OutputLimit)}
+}
+
+// getProcessCgroupRoot returns the cgroup v2 directory the per-process cgroups are created in (processes.cgroupRoot, PROCESS_CGROUP_ROOT)
+// Falls back to /sys/fs/cgroup/site-editor; it must be writable by the backend (a delegated subtree)
+func getProcessCgroupRoot() string {
+	return appConfig.Processes.CgroupRoot
+}
+
+// validate checks the process limits
+func (p ProcessConfig) validate() []error {
+	var errs []error
+	if p.CPULimit < 0 {
+		errs = append(errs, fmt.Errorf("processes.cpuLimit must not be negative, got %g", p.CPULimit))
+	}
+	if p.CgroupRoot == "" {
+		errs = append(errs, errors.New("processes.cgroupRoot must not be empty"))
+	}
+	return errs
+}
+
+// parseByteSize parses a size such as 1048576, 512K, 256M or 2G
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "B"))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// dockerLimitArgs maps the CPU and memory limits to docker run flags; containers get
+// their own cgroup, so a cgroup around the docker client would not limit them
+func dockerLimitArgs(limits ProcessLimits) []string {
+	var args []string
+	if limits.CPU > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(limits.CPU, 'f', -1, 64))
+	}
+	if limits.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(limits.Memory, 10), "--memory-swap", strconv.FormatInt(limits.Memory, 10))
+	}
+	return args
+}
+
+// processLimiter enforces ProcessLimits on a single command or agent process. A nil
+// limiter enforces nothing.
+type processLimiter struct {
+	name   string // Name of the cgroup
+	limits ProcessLimits
+	kill   func() // Stops the process once the output limit is hit
+
+	mu       sync.Mutex
+	output   int64
+	exceeded *ResourceLimitError
+	cgroup   *processCgroup
+}
+
+// newProcessLimiter returns a limiter for the process called name, or nil when there
+// are no limits
+func newProcessLimiter(name string, limits ProcessLimits, kill func()) *processLimiter {
+	if limits == (ProcessLimits{}) {
+		return nil
+	}
+	return &processLimiter{name: name, limits: limits, kill: kill}
+}
+
+// prepare places cmd in a cgroup with the CPU and memory limits; call it right before Start
+func (l *processLimiter) prepare(cmd *exec.Cmd) error {
+	if l == nil || (l.limits.CPU <= 0 && l.limits.Memory <= 0) {
+		return nil
+	}
+	cgroup, err := newProcessCgroup(l.name, l.limits)
+	if err != nil {
+		return fmt.Errorf("failed to apply resource limits: %w", err)
+	}
+	cgroup.attach(cmd)
+
+	l.mu.Lock()
+	l.cgroup = cgroup
+	l.mu.Unlock()
+	return nil
+}
+
+// allowOutput counts n bytes of output and reports whether they may still be shown.
+// The first write past the limit kills the process.
+func (l *processLimiter) allowOutput(n int) bool {
+	if l == nil || l.limits.Output <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	if l.exceeded != nil {
+		l.mu.Unlock()
+		return false
+	}
+	l.output += int64(n)
+	if l.output <= l.limits.Output {
+		l.mu.Unlock()
+		return true
+	}
+	l.exceeded = &ResourceLimitError{Limit: "output", Detail: fmt.Sprintf("process wrote more than %d bytes", l.limits.Output)}
+	l.mu.Unlock()
+
+	log.Printf("🛑 %s exceeded the output limit of %d bytes", l.name, l.limits.Output)
+	l.kill()
+	return false
+}
+
+// err returns the limit the process ran into, or nil
+func (l *processLimiter) err() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.checkMemory()
+	if l.exceeded != nil {
+		return l.exceeded
+	}
+	return nil
+}
+
+// checkMemory records an OOM kill in the cgroup; l.mu must be held
+func (l *processLimiter) checkMemory() {
+	if l.exceeded == nil && l.cgroup != nil && l.cgroup.oomKilled() {
+		l.exceeded = &ResourceLimitError{Limit: "memory", Detail: fmt.Sprintf("process used more than %d bytes", l.limits.Memory)}
+	}
+}
+
+// release removes the cgroup, killing whatever is left in it. err still reports an
+// OOM kill afterwards.
+func (l *processLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.checkMemory()
+	cgroup := l.cgroup
+	l.cgroup = nil
+	l.mu.Unlock()
+	if cgroup != nil {
+		cgroup.remove()
+	}
+}
@@ -0,0 +1,142 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupCPUPeriod is the cpu.max period in microseconds
+const cgroupCPUPeriod = 100000
+
+// cgroup2Magic is the filesystem type of a cgroup v2 mount (CGROUP2_SUPER_MAGIC)
+const cgroup2Magic = 0x63677270
+
+// processCgroup is the cgroup v2 a single process tree runs in
+type processCgroup struct {
+	path string
+	dir  *os.File // Kept open for SysProcAttr.CgroupFD
+}
+
+// newProcessCgroup creates a cgroup below PROCESS_CGROUP_ROOT with the CPU and memory limits
+func newProcessCgroup(name string, limits ProcessLimits) (*processCgroup, error) {
+	root := getProcessCgroupRoot()
+	// Check the parent first so a missing root is not created on some other filesystem
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Dir(root), &fs); err != nil {
+		return nil, fmt.Errorf("PROCESS_CGROUP_ROOT: %w", err)
+	}
+	if fs.Type != cgroup2Magic {
+		return nil, fmt.Errorf("PROCESS_CGROUP_ROOT %s is not on a cgroup v2 filesystem", root)
+	}
+	if err := os.Mkdir(root, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("PROCESS_CGROUP_ROOT: %w", err)
+	}
+	// Let the child cgroups use the controllers; fails when they are not delegated
+	if err := writeCgroupFile(filepath.Join(root, "cgroup.subtree_control"), "+cpu +memory"); err != nil {
+		return nil, fmt.Errorf("failed to enable the cpu and memory controllers in %s: %w", root, err)
+	}
+
+	path := filepath.Join(root, sandboxNameUnsafe.ReplaceAllString(name, "_"))
+	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	cgroup := &processCgroup{path: path}
+
+	if limits.CPU > 0 {
+		quota := int64(limits.CPU * cgroupCPUPeriod)
+		if err := cgroup.write("cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)); err != nil {
+			cgroup.remove()
+			return nil, err
+		}
+	}
+	if limits.Memory > 0 {
+		if err := cgroup.write("memory.max", strconv.FormatInt(limits.Memory, 10)); err != nil {
+			cgroup.remove()
+			return nil, err
+		}
+		cgroup.write("memory.swap.max", "0") // Missing when swap accounting is off
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		cgroup.remove()
+		return nil, err
+	}
+	cgroup.dir = dir
+	return cgroup, nil
+}
+
+// attach starts cmd directly inside the cgroup, so not even its first instructions run unlimited
+func (g *processCgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(g.dir.Fd())
+}
+
+func (g *processCgroup) write(file, value string) error {
+	if err := writeCgroupFile(filepath.Join(g.path, file), value); err != nil {
+		return fmt.Errorf("failed to set %s: %w", file, err)
+	}
+	return nil
+}
+
+// writeCgroupFile writes a cgroup interface file, which the kernel creates with the cgroup
+func writeCgroupFile(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// oomKilled reports whether the kernel killed a process of the cgroup for exceeding memory.max
+func (g *processCgroup) oomKilled() bool {
+	f, err := os.Open(filepath.Join(g.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), " "); ok && key == "oom_kill" {
+			n, _ := strconv.Atoi(value)
+			return n > 0
+		}
+	}
+	return false
+}
+
+// remove kills what is left in the cgroup and deletes it
+func (g *processCgroup) remove() {
+	if g.dir != nil {
+		g.dir.Close()
+	}
+	writeCgroupFile(filepath.Join(g.path, "cgroup.kill"), "1") // Linux 5.14+
+
+	// rmdir fails with EBUSY until the killed processes are gone
+	for i := 0; i < 50; i++ {
+		err := os.Remove(g.path)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	log.Printf("⚠️ Failed to remove cgroup %s", g.path)
+}
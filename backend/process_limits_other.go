@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// processCgroup is unavailable outside Linux; only the output limit is enforced there
+type processCgroup struct{}
+
+func newProcessCgroup(name string, limits ProcessLimits) (*processCgroup, error) {
+	return nil, errors.New("PROCESS_CPU_LIMIT and PROCESS_MEMORY_LIMIT need Linux cgroups")
+}
+
+func (g *processCgroup) attach(cmd *exec.Cmd) {}
+
+func (g *processCgroup) oomKilled() bool { return false }
+
+func (g *processCgroup) remove() {}
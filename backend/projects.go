@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Project is a site served by this backend, with its own workspace directory
+type Project struct {
+	ID            string                 `gorm:"primaryKey" json:"id"`
+	Name          string                 `json:"name"`
+	WorkspacePath string                 `json:"workspacePath"` // Working directory of AI commands and agents
+	Settings      map[string]interface{} `gorm:"serializer:json" json:"settings"`
+	PlanID        string                 `gorm:"index" json:"planId,omitempty"` // Quota plan of the project's team
+	CreatedAt     int64                  `json:"createdAt"`
+	UpdatedAt     int64                  `json:"updatedAt"`
+}
+
+// ProjectRequest is the body of create and update requests
+type ProjectRequest struct {
+	ID            string                 `json:"id,omitempty"` // Optional slug; generated when empty
+	Name          string                 `json:"name"`
+	WorkspacePath string                 `json:"workspacePath"`
+	Settings      map[string]interface{} `json:"settings,omitempty"`
+}
+
+// projectIDPattern restricts client-chosen project IDs to URL-safe slugs
+var projectIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,63}$`)
+
+// errProjectNotFound is returned when a command references an unknown project
+var errProjectNotFound = errors.New("project not found")
+
+// resolveWorkspaceDir returns the working directory for a project,
+// or the global workspace when no project is given
+func resolveWorkspaceDir(db *gorm.DB, projectID string) (string, error) {
+	if projectID == "" {
+		return getWorkspaceDir(), nil
+	}
+
+	var project Project
+	if err := db.First(&project, "id = ?", projectID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("%w: %s", errProjectNotFound, projectID)
+		}
+		return "", err
+	}
+	return project.WorkspacePath, nil
+}
+
+// validateWorkspacePath checks that a project workspace is an existing absolute directory
+func validateWorkspacePath(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("workspacePath is required")
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("workspacePath must be absolute")
+	}
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("workspacePath does not exist: %v", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("workspacePath is not a directory")
+	}
+	return path, nil
+}
+
+// ProjectList is the data of GET /api/projects
+type ProjectList struct {
+	Projects []Project `json:"projects"`
+}
+
+// ListProjects returns every project
+func ListProjects(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var projects []Project
+		if err := db.Order("name").Find(&projects).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load projects", err.Error())
+		}
+
+		return c.JSON(APIResponse[ProjectList]{
+			Success: true,
+			Data:    ProjectList{Projects: projects},
+		})
+	}
+}
+
+// GetProject returns a single project
+func GetProject(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var project Project
+		if err := db.First(&project, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+
+		return c.JSON(APIResponse[Project]{
+			Success: true,
+			Data:    project,
+		})
+	}
+}
+
+// CreateProject registers a new project
+func CreateProject(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req ProjectRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if req.Name == "" {
+			return sendError(c, 400, "MISSING_NAME", "Project name is required", "")
+		}
+		if req.ID == "" {
+			req.ID = "proj_" + uuid.New().String()[:8]
+		} else if !projectIDPattern.MatchString(req.ID) {
+			return sendError(c, 400, "INVALID_ID", "Invalid project id", "IDs may contain letters, digits, - and _")
+		}
+
+		path, err := validateWorkspacePath(req.WorkspacePath)
+		if err != nil {
+			return sendError(c, 400, "INVALID_WORKSPACE", "Invalid workspace path", err.Error())
+		}
+
+		now := time.Now().Unix()
+		project := Project{
+			ID:            req.ID,
+			Name:          req.Name,
+			WorkspacePath: path,
+			Settings:      req.Settings,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+
+		var existing int64
+		db.Model(&Project{}).Where("id = ?", project.ID).Count(&existing)
+		if existing > 0 {
+			return sendError(c, 409, "PROJECT_EXISTS", "A project with this id already exists", "")
+		}
+		if err := db.Create(&project).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create project", err.Error())
+		}
+
+		return c.Status(201).JSON(APIResponse[Project]{
+			Success: true,
+			Data:    project,
+		})
+	}
+}
+
+// UpdateProject changes the name, workspace or settings of a project
+func UpdateProject(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var project Project
+		if err := db.First(&project, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+
+		var req ProjectRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+
+		if req.Name != "" {
+			project.Name = req.Name
+		}
+		if req.WorkspacePath != "" {
+			path, err := validateWorkspacePath(req.WorkspacePath)
+			if err != nil {
+				return sendError(c, 400, "INVALID_WORKSPACE", "Invalid workspace path", err.Error())
+			}
+			project.WorkspacePath = path
+		}
+		if req.Settings != nil {
+			project.Settings = req.Settings
+		}
+		project.UpdatedAt = time.Now().Unix()
+
+		if err := db.Save(&project).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update project", err.Error())
+		}
+
+		return c.JSON(APIResponse[Project]{
+			Success: true,
+			Data:    project,
+		})
+	}
+}
+
+// DeleteProject removes a project and its variables; its workspace directory is left untouched
+func DeleteProject(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var project Project
+		db.Limit(1).Find(&project, "id = ?", c.Params("id"))
+
+		result := db.Delete(&Project{}, "id = ?", c.Params("id"))
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete project", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+		db.Where("project_id = ?", c.Params("id")).Delete(&ProjectEnvVar{})
+		db.Where("project_id = ?", c.Params("id")).Delete(&NotificationChannel{})
+		deleteTeams(db, c.Params("id"))
+		stopPreview(c.Params("id"))
+		recordAudit(db, c, AuditProjectDelete, c.Params("id"), project, nil, project.Name)
+
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: c.Params("id"), Deleted: true},
+		})
+	}
+}
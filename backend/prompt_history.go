@@ -0,0 +1,263 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// PromptFavorite is a prompt pinned by a user for the command bar
+type PromptFavorite struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	UserID    string `gorm:"index" json:"userId,omitempty"`
+	Prompt    string `gorm:"type:text;serializer:encrypted" json:"prompt"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// PromptFavoriteRequest is the body of POST /api/ai/prompts/favorites
+type PromptFavoriteRequest struct {
+	Prompt string `json:"prompt"`
+	UserID string `json:"userId,omitempty"`
+}
+
+// PromptSuggestion is one entry of the command bar typeahead
+type PromptSuggestion struct {
+	Prompt     string  `json:"prompt"`
+	Uses       int     `json:"uses"`
+	LastUsedAt int64   `json:"lastUsedAt,omitempty"`
+	Favorite   bool    `json:"favorite"`
+	FavoriteID uint    `json:"favoriteId,omitempty"`
+	Score      float64 `json:"score"`
+}
+
+// promptHistoryScan caps the most recent commands whose prompts are ranked per request
+const promptHistoryScan = 2000
+
+// promptHalfLife is how long it takes a prompt's weight to halve, so a prompt used
+// often last month ranks below one used a few times this week
+const promptHalfLife = 7 * 24 * time.Hour
+
+// promptOwner returns the user whose prompts are listed: the signed-in user, or
+// the given userId when auth is disabled
+func promptOwner(c *fiber.Ctx, userID string) string {
+	if user := currentUser(c); user != nil {
+		return user.ID
+	}
+	return userID
+}
+
+// promptScore weighs how often a prompt was used by how recently
+func promptScore(uses int, lastUsedAt int64, now time.Time) float64 {
+	age := now.Sub(time.Unix(lastUsedAt, 0))
+	if age < 0 {
+		age = 0
+	}
+	return float64(uses) * math.Pow(0.5, float64(age)/float64(promptHalfLife))
+}
+
+// ListRecentPrompts returns a user's past prompts for typeahead, favorites first and
+// the rest ranked by frequency and recency (?userId=&q=&limit=)
+func ListRecentPrompts(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := promptOwner(c, c.Query("userId"))
+		q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+
+		limit := c.QueryInt("limit", 10)
+		if limit <= 0 || limit > 50 {
+			limit = 10
+		}
+
+		// Prompts are encrypted at rest, so they are matched and counted here rather
+		// than by the database. Retries and batch commands repeat a prompt the user
+		// typed once.
+		var pinned []PromptFavorite
+		if err := db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&pinned).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load favorite prompts", err.Error())
+		}
+		var favorites []PromptFavorite
+		for _, favorite := range pinned {
+			if q == "" || strings.Contains(strings.ToLower(favorite.Prompt), q) {
+				favorites = append(favorites, favorite)
+			}
+		}
+
+		var commands []AICommand
+		err := db.Select("prompt", "created_at").
+			Where("user_id = ? AND retry_of = '' AND batch_id = ''", userID).
+			Order("created_at DESC").Limit(promptHistoryScan).Find(&commands).Error
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load prompt history", err.Error())
+		}
+		type promptUse struct {
+			Prompt     string
+			Uses       int
+			LastUsedAt int64
+		}
+		var history []promptUse
+		seen := make(map[string]int)
+		for _, command := range commands {
+			if q != "" && !strings.Contains(strings.ToLower(command.Prompt), q) {
+				continue
+			}
+			i, ok := seen[command.Prompt]
+			if !ok {
+				i = len(history)
+				seen[command.Prompt] = i
+				history = append(history, promptUse{Prompt: command.Prompt, LastUsedAt: command.CreatedAt})
+			}
+			history[i].Uses++
+		}
+
+		// Prompts that differ only in case or surrounding spaces are merged, showing
+		// the spelling used most
+		now := time.Now()
+		suggestions := make(map[string]*PromptSuggestion)
+		spellingUses := make(map[string]int)
+		var order []*PromptSuggestion
+		for _, h := range history {
+			prompt := strings.TrimSpace(h.Prompt)
+			key := strings.ToLower(prompt)
+			s, ok := suggestions[key]
+			if !ok {
+				s = &PromptSuggestion{}
+				suggestions[key] = s
+				order = append(order, s)
+			}
+			s.Uses += h.Uses
+			if h.LastUsedAt > s.LastUsedAt {
+				s.LastUsedAt = h.LastUsedAt
+			}
+			if h.Uses > spellingUses[key] {
+				s.Prompt = prompt
+				spellingUses[key] = h.Uses
+			}
+		}
+		for _, s := range order {
+			s.Score = promptScore(s.Uses, s.LastUsedAt, now)
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return order[i].Score > order[j].Score
+		})
+
+		results := make([]*PromptSuggestion, 0, limit)
+		for _, f := range favorites {
+			key := strings.ToLower(strings.TrimSpace(f.Prompt))
+			s, ok := suggestions[key]
+			if !ok {
+				s = &PromptSuggestion{Prompt: f.Prompt}
+				suggestions[key] = s
+			}
+			if s.Favorite {
+				continue
+			}
+			s.Favorite = true
+			s.FavoriteID = f.ID
+			results = append(results, s)
+		}
+		for _, s := range order {
+			if !s.Favorite {
+				results = append(results, s)
+			}
+		}
+		if len(results) > limit {
+			results = results[:limit]
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"prompts": results,
+			},
+		})
+	}
+}
+
+// ListFavoritePrompts returns a user's pinned prompts (?userId=)
+func ListFavoritePrompts(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var favorites []PromptFavorite
+		if err := db.Where("user_id = ?", promptOwner(c, c.Query("userId"))).Order("created_at DESC, id DESC").Find(&favorites).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load favorite prompts", err.Error())
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"favorites": favorites,
+			},
+		})
+	}
+}
+
+// AddFavoritePrompt pins a prompt. Pinning a prompt twice returns the existing favorite.
+func AddFavoritePrompt(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req PromptFavoriteRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		req.Prompt = strings.TrimSpace(req.Prompt)
+		if req.Prompt == "" {
+			return sendError(c, 400, "MISSING_PROMPT", "Prompt is required", "")
+		}
+		userID := promptOwner(c, req.UserID)
+
+		// Prompts are encrypted at rest, so the existing favorite is found here
+		var pinned []PromptFavorite
+		if err := db.Where("user_id = ?", userID).Order("id").Find(&pinned).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load favorite prompts", err.Error())
+		}
+		for _, existing := range pinned {
+			if existing.Prompt == req.Prompt {
+				return c.JSON(fiber.Map{
+					"success": true,
+					"data":    existing,
+				})
+			}
+		}
+
+		favorite := PromptFavorite{
+			UserID:    userID,
+			Prompt:    req.Prompt,
+			CreatedAt: time.Now().Unix(),
+		}
+		if err := db.Create(&favorite).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to save favorite prompt", err.Error())
+		}
+
+		return c.Status(201).JSON(fiber.Map{
+			"success": true,
+			"data":    favorite,
+		})
+	}
+}
+
+// DeleteFavoritePrompt unpins a prompt
+func DeleteFavoritePrompt(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return sendError(c, 400, "INVALID_ID", "Invalid favorite ID", err.Error())
+		}
+
+		query := db.Where("id = ?", id)
+		if user := currentUser(c); user != nil {
+			query = query.Where("user_id = ?", user.ID)
+		}
+		result := query.Delete(&PromptFavorite{})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete favorite prompt", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "FAVORITE_NOT_FOUND", "Favorite prompt not found", "")
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Favorite prompt deleted",
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PromptData is the data available to prompt templates
+type PromptData struct {
+	Prompt         string // Prompt as typed by the user
+	Scope          string
+	Page           string
+	UserID         string
+	ProjectID      string
+	ConversationID string
+	Provider       string
+	ComponentID    string // Targeted block (component scope)
+	Selector       string // CSS selector of the targeted block (component scope)
+}
+
+// defaultPromptTemplate reproduces the built-in prompt used when a scope has no template file
+const defaultPromptTemplate = `{{if eq .Scope "component"}}Scope: component | Page: {{.Page}} | Component: {{.ComponentID}}{{if .Selector}} (selector: {{.Selector}}){{end}} | Only modify this component. | Task: {{else if and .Scope .Page}}Scope: {{.Scope}} | Page: {{.Page}} | Task: {{end}}{{.Prompt}}`
+
+// promptNamePattern restricts template names to safe file names
+var promptNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// cachedPrompt is a parsed template file and the modification time it was parsed at
+type cachedPrompt struct {
+	modTime time.Time
+	tmpl    *template.Template
+}
+
+var (
+	promptCache   = make(map[string]cachedPrompt)
+	promptCacheMu sync.Mutex
+	builtinPrompt = template.Must(template.New("default").Parse(defaultPromptTemplate))
+)
+
+// getPromptsDir returns the template directory from PROMPTS_DIR
+// Falls back to prompts (relative to the working directory)
+func getPromptsDir() string {
+	return getEnvDefault("PROMPTS_DIR", "prompts")
+}
+
+func promptPath(name string) string {
+	return filepath.Join(getPromptsDir(), name+".tmpl")
+}
+
+// parsePrompt parses template content, reporting syntax errors and unknown fields
+func parsePrompt(name, content string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	// Execute against sample data so references to unknown fields fail on save, not at run time
+	if err := tmpl.Execute(&bytes.Buffer{}, PromptData{Prompt: "sample", Scope: name, Page: "home"}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// loadPrompt returns the template for name, re-parsing the file when it changed on disk.
+// It returns nil when there is no usable template file.
+func loadPrompt(name string) *template.Template {
+	promptCacheMu.Lock()
+	defer promptCacheMu.Unlock()
+
+	info, err := os.Stat(promptPath(name))
+	if err != nil {
+		delete(promptCache, name)
+		return nil
+	}
+
+	cached, ok := promptCache[name]
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.tmpl
+	}
+
+	data, err := os.ReadFile(promptPath(name))
+	if err != nil {
+		return cached.tmpl
+	}
+	tmpl, err := parsePrompt(name, string(data))
+	if err != nil {
+		// Keep serving the last good version until the file is fixed
+		log.Printf("⚠️ Invalid prompt template %s: %v", name, err)
+		return cached.tmpl
+	}
+
+	log.Printf("🔄 Loaded prompt template %s", name)
+	promptCache[name] = cachedPrompt{modTime: info.ModTime(), tmpl: tmpl}
+	return tmpl
+}
+
+// renderPrompt builds the prompt for a command from the template of its scope,
+// falling back to default.tmpl and then to the built-in template
+func renderPrompt(command *AICommand) string {
+	data := PromptData{
+		Prompt:         command.Prompt,
+		Scope:          command.Scope,
+		Page:           command.Page,
+		UserID:         command.UserID,
+		ProjectID:      command.ProjectID,
+		ConversationID: command.ConversationID,
+		Provider:       command.Provider,
+		ComponentID:    command.ComponentID,
+		Selector:       command.Selector,
+	}
+
+	tmpl := builtinPrompt
+	for _, name := range []string{command.Scope, "default"} {
+		if !promptNamePattern.MatchString(name) {
+			continue
+		}
+		if loaded := loadPrompt(name); loaded != nil {
+			tmpl = loaded
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("⚠️ Failed to render prompt template %s: %v", tmpl.Name(), err)
+		buf.Reset()
+		builtinPrompt.Execute(&buf, data)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// promptSource describes one template for the admin API
+func promptSource(name string) fiber.Map {
+	data, err := os.ReadFile(promptPath(name))
+	if err != nil {
+		return fiber.Map{
+			"name":    name,
+			"source":  "builtin",
+			"content": defaultPromptTemplate,
+		}
+	}
+
+	info, _ := os.Stat(promptPath(name))
+	item := fiber.Map{
+		"name":     name,
+		"source":   "file",
+		"content":  string(data),
+		"modified": info.ModTime().Unix(),
+	}
+	if _, err := parsePrompt(name, string(data)); err != nil {
+		item["error"] = err.Error()
+	}
+	return item
+}
+
+// ListPrompts returns the templates for every scope plus any extra template files
+func ListPrompts() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		names := map[string]bool{"current-page": true, "new-page": true, "global": true, "component": true, "default": true}
+		if entries, err := os.ReadDir(getPromptsDir()); err == nil {
+			for _, entry := range entries {
+				name := strings.TrimSuffix(entry.Name(), ".tmpl")
+				if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".tmpl") && promptNamePattern.MatchString(name) {
+					names[name] = true
+				}
+			}
+		}
+
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		items := make([]fiber.Map, 0, len(sorted))
+		for _, name := range sorted {
+			items = append(items, promptSource(name))
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"directory": getPromptsDir(),
+				"prompts":   items,
+			},
+		})
+	}
+}
+
+// GetPrompt returns a single template
+func GetPrompt() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		if !promptNamePattern.MatchString(name) {
+			return sendError(c, 400, "INVALID_NAME", "Invalid template name", "Names may contain a-z, 0-9, - and _")
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    promptSource(name),
+		})
+	}
+}
+
+// PromptRequest is the body of PUT /api/admin/prompts/:name
+type PromptRequest struct {
+	Content string `json:"content"` // Template source
+}
+
+// PutPrompt validates and saves a template; it takes effect on the next command
+func PutPrompt() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		if !promptNamePattern.MatchString(name) {
+			return sendError(c, 400, "INVALID_NAME", "Invalid template name", "Names may contain a-z, 0-9, - and _")
+		}
+
+		var req PromptRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if strings.TrimSpace(req.Content) == "" {
+			return sendError(c, 400, "MISSING_CONTENT", "Template content is required", "")
+		}
+		if _, err := parsePrompt(name, req.Content); err != nil {
+			return sendError(c, 400, "INVALID_TEMPLATE", "Invalid template", err.Error())
+		}
+
+		if err := os.MkdirAll(getPromptsDir(), 0755); err != nil {
+			return sendError(c, 500, "WRITE_FAILED", "Failed to create prompts directory", err.Error())
+		}
+		if err := writeFileAtomic(promptPath(name), []byte(req.Content)); err != nil {
+			return sendError(c, 500, "WRITE_FAILED", "Failed to save template", err.Error())
+		}
+
+		log.Printf("📝 Prompt template %s updated", name)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    promptSource(name),
+		})
+	}
+}
+
+// DeletePrompt removes a template file so the scope falls back to the default
+func DeletePrompt() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		if !promptNamePattern.MatchString(name) {
+			return sendError(c, 400, "INVALID_NAME", "Invalid template name", "Names may contain a-z, 0-9, - and _")
+		}
+
+		if err := os.Remove(promptPath(name)); err != nil {
+			if os.IsNotExist(err) {
+				return sendError(c, 404, "TEMPLATE_NOT_FOUND", "Template not found", "")
+			}
+			return sendError(c, 500, "DELETE_FAILED", "Failed to delete template", err.Error())
+		}
+
+		log.Printf("📝 Prompt template %s deleted", name)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    promptSource(name),
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Provider names accepted in AICommandRequest.Provider and AI_PROVIDER
+const (
+	ProviderClaudeCLI    = "claude-cli"
+	ProviderAnthropicAPI = "anthropic"
+	ProviderOpenAI       = "openai"
+)
+
+// ProviderRequest is everything a provider needs to run one AI command
+type ProviderRequest struct {
+	CommandID      string
+	Prompt         string // Full prompt including scope/page context
+	OriginalPrompt string // Prompt as typed by the user
+	Scope          string
+	Page           string
+	WorkDir        string
+	SessionID      string          // Conversation session to create or continue
+	Resume         bool            // True when SessionID already exists
+	Env            ChildEnv        // Environment of the CLI process (see buildChildEnv)
+	Limiter        *processLimiter // CPU and memory limits of the CLI process; nil for none
+
+	// AttachStdin receives the process stdin when the provider accepts interactive input
+	AttachStdin func(io.WriteCloser)
+	// Started receives the process group of the CLI once it runs, for providers that start one
+	Started func(pid int)
+	// Permission decides whether a tool may run (AI_PERMISSION_MODE=prompt); nil lets the
+	// provider apply its own rules
+	Permission func(ctx context.Context, tool string, input json.RawMessage) PermissionDecision
+}
+
+// PermissionDecision is the answer to a tool waiting for permission
+type PermissionDecision struct {
+	Decided bool   `json:"decided"` // False leaves the decision to the provider
+	Allow   bool   `json:"allow"`
+	Reason  string `json:"reason,omitempty"` // Passed on to the model when the tool is denied
+}
+
+// ProviderEvent is a single line of output produced by a provider
+type ProviderEvent struct {
+	Stream    string // stdout or stderr
+	Text      string
+	Continued bool           // Text is part of a line too long to send at once; the next event goes on with it
+	Part      int            // Position of Text among the parts of such a line, from 0
+	Usage     *ProviderUsage // Set instead of Text when the provider reports token usage
+	Warning   string         // Set instead of Text when output can't be passed on as it came
+	Tool      *ProviderTool  // Set instead of Text when the provider starts or finishes a tool
+}
+
+// ProviderTool is a tool the provider runs for a command, such as reading a file or
+// running a shell command: either its start, with its input, or its end, with its result
+type ProviderTool struct {
+	ID     string          // Pairs the end with the start
+	Name   string          // e.g. Read, Edit, Bash; start only
+	Input  json.RawMessage // JSON arguments; start only
+	Done   bool
+	Failed bool   // The tool reported an error; end only
+	Output string // end only
+}
+
+// ProviderUsage is the token usage of a command as reported by the provider
+type ProviderUsage struct {
+	Model        string
+	InputTokens  int64
+	OutputTokens int64
+	CostUSD      float64 // Reported cost; 0 when the provider only reports tokens
+}
+
+// Provider runs an AI command and streams its output line by line through emit.
+// Run blocks until the command finishes; it must stop promptly when ctx is done.
+type Provider interface {
+	Name() string
+	Run(ctx context.Context, req ProviderRequest, emit func(ProviderEvent)) error
+}
+
+// providers registers the available AI backends by name
+var providers = map[string]Provider{
+	ProviderClaudeCLI:    &ClaudeCLIProvider{},
+	ProviderAnthropicAPI: &AnthropicAPIProvider{},
+	ProviderOpenAI:       &OpenAIProvider{},
+}
+
+// getDefaultProvider returns the provider from AI_PROVIDER
+// Falls back to the Claude CLI if AI_PROVIDER is not set
+func getDefaultProvider() string {
+	if name := os.Getenv("AI_PROVIDER"); name != "" {
+		return name
+	}
+	return ProviderClaudeCLI
+}
+
+// getProvider resolves a provider by name, using the default when name is empty
+func getProvider(name string) (Provider, error) {
+	if name == "" {
+		name = getDefaultProvider()
+	}
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown AI provider %q (expected one of: %s)", name, strings.Join(providerNames(), ", "))
+	}
+	return provider, nil
+}
+
+// providerNames returns the registered provider names in sorted order
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lineBuffer turns streamed text deltas into complete lines
+type lineBuffer struct {
+	pending strings.Builder
+	emit    func(ProviderEvent)
+}
+
+// Write appends a delta and emits every completed line
+func (b *lineBuffer) Write(text string) {
+	for {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			b.pending.WriteString(text)
+			return
+		}
+		b.pending.WriteString(text[:i])
+		b.emit(ProviderEvent{Stream: "stdout", Text: b.pending.String()})
+		b.pending.Reset()
+		text = text[i+1:]
+	}
+}
+
+// Flush emits any trailing partial line
+func (b *lineBuffer) Flush() {
+	if b.pending.Len() > 0 {
+		b.emit(ProviderEvent{Stream: "stdout", Text: b.pending.String()})
+		b.pending.Reset()
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ClaudeCLIProvider runs the claude binary in the workspace directory
+type ClaudeCLIProvider struct{}
+
+// claudeStreamJSON reports whether the CLI should print its transcript as JSON lines
+// (CLAUDE_OUTPUT_FORMAT=stream-json), which carry token usage and cost.
+// Falls back to plain text, which keeps clarifying questions on stdin working
+func claudeStreamJSON() bool {
+	return os.Getenv("CLAUDE_OUTPUT_FORMAT") == "stream-json"
+}
+
+// claudeStreamMessage is the part of a stream-json line the backend uses
+type claudeStreamMessage struct {
+	Type    string `json:"type"`
+	Model   string `json:"model"` // system init
+	Message struct {
+		Content []struct {
+			Type      string          `json:"type"`
+			Text      string          `json:"text"`
+			ID        string          `json:"id"`          // tool_use
+			Name      string          `json:"name"`        // tool_use
+			Input     json.RawMessage `json:"input"`       // tool_use
+			ToolUseID string          `json:"tool_use_id"` // tool_result
+			IsError   bool            `json:"is_error"`    // tool_result
+			Content   json.RawMessage `json:"content"`     // tool_result: a string or text blocks
+		} `json:"content"`
+	} `json:"message"` // assistant, and user for tool results
+	TotalCostUSD float64 `json:"total_cost_usd"` // result
+	Usage        struct {
+		InputTokens              int64 `json:"input_tokens"`
+		CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+		OutputTokens             int64 `json:"output_tokens"`
+	} `json:"usage"` // result
+}
+
+// emitClaudeStreamLine turns one stream-json line into output lines and a usage report.
+// Lines that are not JSON are passed through unchanged.
+func emitClaudeStreamLine(line string, model *string, emit func(ProviderEvent)) {
+	var msg claudeStreamMessage
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &msg) != nil {
+		emit(ProviderEvent{Stream: "stdout", Text: line})
+		return
+	}
+
+	switch msg.Type {
+	case "system":
+		if msg.Model != "" {
+			*model = msg.Model
+		}
+	case "assistant":
+		for _, block := range msg.Message.Content {
+			switch block.Type {
+			case "text":
+				for _, text := range strings.Split(strings.TrimRight(block.Text, "\n"), "\n") {
+					emit(ProviderEvent{Stream: "stdout", Text: text})
+				}
+			case "tool_use":
+				emit(ProviderEvent{Stream: "stdout", Tool: &ProviderTool{ID: block.ID, Name: block.Name, Input: block.Input}})
+			}
+		}
+	case "user":
+		for _, block := range msg.Message.Content {
+			if block.Type == "tool_result" {
+				emit(ProviderEvent{Stream: "stdout", Tool: &ProviderTool{
+					ID:     block.ToolUseID,
+					Done:   true,
+					Failed: block.IsError,
+					Output: claudeToolOutput(block.Content),
+				}})
+			}
+		}
+	case "result":
+		emit(ProviderEvent{Stream: "stdout", Usage: &ProviderUsage{
+			Model:        *model,
+			InputTokens:  msg.Usage.InputTokens + msg.Usage.CacheCreationInputTokens + msg.Usage.CacheReadInputTokens,
+			OutputTokens: msg.Usage.OutputTokens,
+			CostUSD:      msg.TotalCostUSD,
+		}})
+	}
+}
+
+// claudeToolOutput returns the text of a tool result, which is either a string or a
+// list of content blocks
+func claudeToolOutput(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(content, &blocks)
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (p *ClaudeCLIProvider) Name() string {
+	return "Claude CLI"
+}
+
+func (p *ClaudeCLIProvider) Run(ctx context.Context, req ProviderRequest, emit func(ProviderEvent)) error {
+	// Keep conversation context across follow-up prompts
+	var args []string
+	if req.SessionID != "" {
+		if req.Resume {
+			args = append(args, "--resume", req.SessionID)
+		} else {
+			args = append(args, "--session-id", req.SessionID)
+		}
+	}
+	jsonOutput := claudeStreamJSON()
+	if jsonOutput {
+		args = append(args, "-p", "--output-format", "stream-json", "--verbose")
+	}
+	env := req.Env
+	if req.Permission != nil {
+		hookArgs, hookEnv, stopHook, err := startClaudePermissionHook(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer stopHook()
+		args = append(args, hookArgs...)
+		env.Vars = append(slices.Clone(env.Vars), hookEnv)
+	}
+	args = append(args, req.Prompt)
+
+	// Create command with context for cancellation, on the host or in a container
+	cmd, cleanup, err := commandProcess(ctx, req.CommandID, req.WorkDir, env, req.Limiter, "claude", args...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// High-level logging: log full Claude command details
+	if isHighLogLevel() {
+		loggedArgs := slices.Clone(args)
+		loggedArgs[len(loggedArgs)-1] = highLogText(req.Prompt)
+		log.Printf("🔍 [HIGH LOG] ================================")
+		log.Printf("🔍 [HIGH LOG] CLAUDE CLI COMMAND DETAILS")
+		log.Printf("🔍 [HIGH LOG] ================================")
+		log.Printf("🔍 [HIGH LOG] Command ID: %s", req.CommandID)
+		log.Printf("🔍 [HIGH LOG] Executable: claude (%s)", getExecutionDriver())
+		log.Printf("🔍 [HIGH LOG] Arguments: %q", loggedArgs)
+		log.Printf("🔍 [HIGH LOG] Working Directory: %s", req.WorkDir)
+		log.Printf("🔍 [HIGH LOG] Full Command: claude %s", strings.Join(loggedArgs, " "))
+		log.Printf("🔍 [HIGH LOG] Original Prompt: %s", highLogText(req.OriginalPrompt))
+		log.Printf("🔍 [HIGH LOG] Scope: %s", req.Scope)
+		log.Printf("🔍 [HIGH LOG] Page: %s", req.Page)
+		log.Printf("🔍 [HIGH LOG] Environment Variables:")
+		for _, env := range req.Env.Redacted() {
+			log.Printf("🔍 [HIGH LOG]   %s", env)
+		}
+		log.Printf("🔍 [HIGH LOG] ================================")
+	}
+
+	// Create pipes for stdout and stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	// Start the command
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start Claude CLI: %w", err)
+	}
+
+	log.Printf("✅ Claude CLI process started")
+
+	if req.Started != nil {
+		req.Started(cmd.Process.Pid)
+	}
+
+	if req.AttachStdin != nil {
+		req.AttachStdin(stdin)
+	}
+
+	// Read stdout and stderr concurrently
+	var wg sync.WaitGroup
+	readStream := func(name string, r io.Reader) {
+		defer wg.Done()
+		var model string
+		maxLine := getMaxLineSize()
+		err := readLines(r, maxLine, func(line string, chunk int, more bool) bool {
+			switch {
+			case chunk == 0 && more:
+				emit(ProviderEvent{Stream: name, Warning: fmt.Sprintf("Output line longer than %d bytes, sending it in parts", maxLine)})
+				fallthrough
+			case chunk > 0:
+				// Parts of a stream-json line can't be parsed, so they are passed through as text
+				emit(ProviderEvent{Stream: name, Text: line, Continued: more, Part: chunk})
+			case jsonOutput && name == "stdout":
+				emitClaudeStreamLine(line, &model, emit)
+			default:
+				emit(ProviderEvent{Stream: name, Text: line})
+			}
+			return ctx.Err() == nil
+		})
+		if err != nil && err != io.EOF {
+			log.Printf("❌ Error reading %s: %v", name, err)
+		}
+	}
+
+	wg.Add(2)
+	go readStream("stdout", stdout)
+	go readStream("stderr", stderr)
+
+	// Drain the pipes before waiting: Wait closes them and would drop buffered output
+	wg.Wait()
+	return cmd.Wait()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// getEnvDefault returns the environment variable key, or fallback when unset
+func getEnvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getMaxTokens returns the response token limit for hosted APIs from AI_MAX_TOKENS
+func getMaxTokens() int {
+	if n, err := strconv.Atoi(os.Getenv("AI_MAX_TOKENS")); err == nil && n > 0 {
+		return n
+	}
+	return 4096
+}
+
+// streamSSE posts body to url and calls onData with the payload of every "data:" line
+func streamSSE(ctx context.Context, url string, headers map[string]string, body interface{}, onData func(data string) error) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	for key, value := range headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), getMaxLineSize())
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		if err := onData(strings.TrimSpace(strings.TrimPrefix(line, "data:"))); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// anthropicUsage is the usage object of Messages API stream events
+type anthropicUsage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+}
+
+// total counts cached prompt tokens as input too
+func (u anthropicUsage) total() int64 {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// AnthropicAPIProvider calls the Anthropic Messages API with streaming
+type AnthropicAPIProvider struct{}
+
+func (p *AnthropicAPIProvider) Name() string {
+	return "Anthropic API"
+}
+
+func (p *AnthropicAPIProvider) Run(ctx context.Context, req ProviderRequest, emit func(ProviderEvent)) error {
+	apiKey := os.Getenv("ANTHROPIC_API_KEY")
+	if apiKey == "" {
+		return fmt.Errorf("ANTHROPIC_API_KEY is not set")
+	}
+
+	url := strings.TrimSuffix(getEnvDefault("ANTHROPIC_BASE_URL", "https://api.anthropic.com"), "/") + "/v1/messages"
+	model := getEnvDefault("ANTHROPIC_MODEL", "claude-sonnet-4-5")
+	log.Printf("🌐 Anthropic API request [%s] | Model: %s", req.CommandID, model)
+
+	body := map[string]interface{}{
+		"model":      model,
+		"max_tokens": getMaxTokens(),
+		"stream":     true,
+		"messages": []map[string]string{
+			{"role": "user", "content": req.Prompt},
+		},
+	}
+	headers := map[string]string{
+		"x-api-key":         apiKey,
+		"anthropic-version": "2023-06-01",
+	}
+
+	lines := &lineBuffer{emit: emit}
+	defer lines.Flush()
+
+	// Input tokens arrive with message_start, output tokens with message_delta
+	usage := &ProviderUsage{Model: model}
+	defer func() {
+		if usage.InputTokens > 0 || usage.OutputTokens > 0 {
+			emit(ProviderEvent{Stream: "stdout", Usage: usage})
+		}
+	}()
+
+	return streamSSE(ctx, url, headers, body, func(data string) error {
+		var event struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Message struct {
+				Model string         `json:"model"`
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			Usage anthropicUsage `json:"usage"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message.Model != "" {
+				usage.Model = event.Message.Model
+			}
+			usage.InputTokens = event.Message.Usage.total()
+			usage.OutputTokens = event.Message.Usage.OutputTokens
+		case "message_delta":
+			if event.Usage.OutputTokens > 0 {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				lines.Write(event.Delta.Text)
+			}
+		case "error":
+			return fmt.Errorf("Anthropic API error: %s", event.Error.Message)
+		}
+		return nil
+	})
+}
+
+// OpenAIProvider calls any OpenAI-compatible chat completions API with streaming
+type OpenAIProvider struct{}
+
+func (p *OpenAIProvider) Name() string {
+	return "OpenAI API"
+}
+
+func (p *OpenAIProvider) Run(ctx context.Context, req ProviderRequest, emit func(ProviderEvent)) error {
+	url := strings.TrimSuffix(getEnvDefault("OPENAI_BASE_URL", "https://api.openai.com/v1"), "/") + "/chat/completions"
+	model := getEnvDefault("OPENAI_MODEL", "gpt-4o-mini")
+	log.Printf("🌐 OpenAI-compatible API request [%s] | Model: %s", req.CommandID, model)
+
+	body := map[string]interface{}{
+		"model":      model,
+		"max_tokens": getMaxTokens(),
+		"stream":     true,
+		"messages": []map[string]string{
+			{"role": "user", "content": req.Prompt},
+		},
+		// Ask for a final chunk with the token counts
+		"stream_options": map[string]bool{"include_usage": true},
+	}
+	headers := map[string]string{}
+	if apiKey := os.Getenv("OPENAI_API_KEY"); apiKey != "" {
+		headers["Authorization"] = "Bearer " + apiKey
+	}
+
+	lines := &lineBuffer{emit: emit}
+	defer lines.Flush()
+
+	return streamSSE(ctx, url, headers, body, func(data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk struct {
+			Model   string `json:"model"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *struct {
+				PromptTokens     int64 `json:"prompt_tokens"`
+				CompletionTokens int64 `json:"completion_tokens"`
+			} `json:"usage"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("OpenAI API error: %s", chunk.Error.Message)
+		}
+		for _, choice := range chunk.Choices {
+			lines.Write(choice.Delta.Content)
+		}
+		if chunk.Usage != nil {
+			if chunk.Model != "" {
+				model = chunk.Model
+			}
+			lines.Flush()
+			emit(ProviderEvent{Stream: "stdout", Usage: &ProviderUsage{
+				Model:        model,
+				InputTokens:  chunk.Usage.PromptTokens,
+				OutputTokens: chunk.Usage.CompletionTokens,
+			}})
+		}
+		return nil
+	})
+}
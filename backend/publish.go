@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// PublishContent copies the current draft of a content block to the live site
+func PublishContent(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+
+		var content Content
+		if err := db.First(&content, "id = ? AND locale = ?", id, locale).Error; err != nil {
+			return sendError(c, 404, "CONTENT_NOT_FOUND", "Content not found", nil)
+		}
+
+		before := content.PublishedContent
+		content.PublishedContent = draftContent(content)
+		content.IsPublished = true
+		content.PublishedAt = time.Now().Unix()
+
+		if err := db.Save(&content).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to publish content", nil)
+		}
+
+		recordAudit(db, c, AuditContentPublish, id, before, content.PublishedContent, localeDetail(content, ""))
+		if err := applyPageMeta(db, content.Page); err != nil {
+			log.Printf("⚠️ Failed to write the metadata of page %s: %v", content.Page, err)
+		}
+		broadcastContent(ContentMsgPublished, content, c.Get("X-Client-ID"))
+		return c.JSON(contentResponse(content, ContentStatePublished))
+	}
+}
+
+// UnpublishContent removes published content so the live site falls back to the original
+func UnpublishContent(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		locale, err := requestLocale(c)
+		if err != nil {
+			return invalidLocale(c, err)
+		}
+
+		var content Content
+		if err := db.First(&content, "id = ? AND locale = ?", id, locale).Error; err != nil {
+			return sendError(c, 404, "CONTENT_NOT_FOUND", "Content not found", nil)
+		}
+
+		before := content.PublishedContent
+		content.PublishedContent = ""
+		content.IsPublished = false
+		content.PublishedAt = 0
+
+		if err := db.Save(&content).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to unpublish content", nil)
+		}
+
+		recordAudit(db, c, AuditContentUnpublish, id, before, nil, localeDetail(content, ""))
+		broadcastContent(ContentMsgUnpublished, content, c.Get("X-Client-ID"))
+		return c.JSON(contentResponse(content, ContentStatePublished))
+	}
+}
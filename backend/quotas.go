@@ -0,0 +1,479 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Plan is a tier of quotas on AI commands. Admins give plans to users and to project
+// teams; a limit of 0 means no limit.
+type Plan struct {
+	ID              string  `gorm:"primaryKey" json:"id"`
+	Name            string  `json:"name"`
+	CommandsPerDay  int64   `json:"commandsPerDay"`  // Commands submitted per UTC day
+	MinutesPerMonth float64 `json:"minutesPerMonth"` // CLI run time per calendar month
+	TokensPerMonth  int64   `json:"tokensPerMonth"`  // Input and output tokens per calendar month
+	CreatedAt       int64   `json:"createdAt"`
+	UpdatedAt       int64   `json:"updatedAt"`
+}
+
+// PlanRequest is the body of POST and PUT /api/admin/plans
+type PlanRequest struct {
+	ID              string  `json:"id,omitempty" validate:"omitempty,planid"` // POST only; generated when empty
+	Name            string  `json:"name" validate:"required,max=100,singleline"`
+	CommandsPerDay  int64   `json:"commandsPerDay" validate:"min=0"`
+	MinutesPerMonth float64 `json:"minutesPerMonth" validate:"min=0"`
+	TokensPerMonth  int64   `json:"tokensPerMonth" validate:"min=0"`
+}
+
+// PlanAssignmentRequest is the body of PUT /api/admin/users/:id/plan and
+// PUT /api/admin/projects/:id/plan; an empty plan removes the assignment
+type PlanAssignmentRequest struct {
+	Plan string `json:"plan" validate:"omitempty,planid"`
+}
+
+// QuotaUsage is the use of a user or a team against the limits of their plan
+type QuotaUsage struct {
+	Scope            string   `json:"scope"` // user or team
+	SubjectID        string   `json:"subjectId"`
+	Name             string   `json:"name,omitempty"`
+	Plan             *Plan    `json:"plan"` // nil when no plan applies
+	CommandsToday    int64    `json:"commandsToday"`
+	MinutesThisMonth float64  `json:"minutesThisMonth"`
+	TokensThisMonth  int64    `json:"tokensThisMonth"`
+	Exceeded         []string `json:"exceeded"` // Limits that block new commands
+	DayResetsAt      int64    `json:"dayResetsAt"`
+	MonthResetsAt    int64    `json:"monthResetsAt"`
+}
+
+// MyUsage is the answer of GET /api/me/usage
+type MyUsage struct {
+	User  QuotaUsage   `json:"user"`
+	Teams []QuotaUsage `json:"teams"`
+}
+
+// errQuotaExceeded is wrapped by checkQuota when a limit of a plan has been reached
+var errQuotaExceeded = errors.New("plan quota exceeded")
+
+// QuotaError names the limit a command went over
+type QuotaError struct {
+	Usage     QuotaUsage
+	Limit     string
+	Requested int64 // Commands the rejected submission would have created
+}
+
+func (e *QuotaError) Error() string {
+	subject := "your"
+	if e.Usage.Scope == "team" {
+		subject = fmt.Sprintf("the %s team's", e.Usage.SubjectID)
+	}
+	plan := e.Usage.Plan
+	switch e.Limit {
+	case "commandsPerDay":
+		if e.Requested > 1 {
+			return fmt.Sprintf("%s plan %q allows %d commands a day (%d used, %d requested)", subject, plan.Name, plan.CommandsPerDay, e.Usage.CommandsToday, e.Requested)
+		}
+		return fmt.Sprintf("%s plan %q allows %d commands a day (%d used)", subject, plan.Name, plan.CommandsPerDay, e.Usage.CommandsToday)
+	case "minutesPerMonth":
+		return fmt.Sprintf("%s plan %q allows %g CLI minutes a month (%.1f used)", subject, plan.Name, plan.MinutesPerMonth, e.Usage.MinutesThisMonth)
+	}
+	return fmt.Sprintf("%s plan %q allows %d tokens a month (%d used)", subject, plan.Name, plan.TokensPerMonth, e.Usage.TokensThisMonth)
+}
+
+func (e *QuotaError) Unwrap() error { return errQuotaExceeded }
+
+// resetsAt returns when the exceeded limit starts over
+func (e *QuotaError) resetsAt() int64 {
+	if e.Limit == "commandsPerDay" {
+		return e.Usage.DayResetsAt
+	}
+	return e.Usage.MonthResetsAt
+}
+
+// getDefaultPlan returns the plan of users without one (quotas.defaultPlan, QUOTA_DEFAULT_PLAN)
+// Falls back to none (no limits)
+func getDefaultPlan() string {
+	return appConfig.Quotas.DefaultPlan
+}
+
+// dayBounds returns the start of the UTC day containing t and the start of the next
+func dayBounds(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// findPlan loads a plan; an empty ID or a deleted plan returns nil
+func findPlan(db *gorm.DB, id string) (*Plan, error) {
+	if id == "" {
+		return nil, nil
+	}
+	var plan Plan
+	if err := db.Limit(1).Find(&plan, "id = ?", id).Error; err != nil || plan.ID == "" {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// quotaUsage measures the commands of a user or a project team against a plan. Rejected
+// commands don't count, and CLI time counts once a run is over.
+func quotaUsage(db *gorm.DB, scope, subjectID string, plan *Plan) (QuotaUsage, error) {
+	now := time.Now()
+	dayStart, dayEnd := dayBounds(now)
+	monthStart, monthEnd := monthBounds(now)
+	usage := QuotaUsage{
+		Scope: scope, SubjectID: subjectID, Plan: plan, Exceeded: []string{},
+		DayResetsAt: dayEnd.Unix(), MonthResetsAt: monthEnd.Unix(),
+	}
+
+	column := "user_id"
+	if scope == "team" {
+		column = "project_id"
+	}
+	commands := func() *gorm.DB {
+		return db.Model(&AICommand{}).Where(column+" = ? AND status <> ?", subjectID, "rejected")
+	}
+
+	if err := commands().Where("created_at >= ?", dayStart.Unix()).Count(&usage.CommandsToday).Error; err != nil {
+		return usage, err
+	}
+	var month struct {
+		RunMs  int64
+		Tokens int64
+	}
+	err := commands().Where("created_at >= ?", monthStart.Unix()).
+		Select("COALESCE(SUM(run_ms), 0) AS run_ms, COALESCE(SUM(input_tokens + output_tokens), 0) AS tokens").
+		Scan(&month).Error
+	if err != nil {
+		return usage, err
+	}
+	usage.MinutesThisMonth = math.Round(float64(month.RunMs)/60000*10) / 10
+	usage.TokensThisMonth = month.Tokens
+
+	if plan != nil {
+		if plan.CommandsPerDay > 0 && usage.CommandsToday >= plan.CommandsPerDay {
+			usage.Exceeded = append(usage.Exceeded, "commandsPerDay")
+		}
+		if plan.MinutesPerMonth > 0 && float64(month.RunMs) >= plan.MinutesPerMonth*60000 {
+			usage.Exceeded = append(usage.Exceeded, "minutesPerMonth")
+		}
+		if plan.TokensPerMonth > 0 && usage.TokensThisMonth >= plan.TokensPerMonth {
+			usage.Exceeded = append(usage.Exceeded, "tokensPerMonth")
+		}
+	}
+	return usage, nil
+}
+
+// userQuotaUsage measures a user against their plan, or QUOTA_DEFAULT_PLAN
+func userQuotaUsage(db *gorm.DB, user *User) (QuotaUsage, error) {
+	planID := user.PlanID
+	if planID == "" {
+		planID = getDefaultPlan()
+	}
+	plan, err := findPlan(db, planID)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	usage, err := quotaUsage(db, "user", user.ID, plan)
+	usage.Name = user.Name
+	return usage, err
+}
+
+// teamQuotaUsage measures all commands of a project against the plan of its team
+func teamQuotaUsage(db *gorm.DB, project *Project) (QuotaUsage, error) {
+	plan, err := findPlan(db, project.PlanID)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	usage, err := quotaUsage(db, "team", project.ID, plan)
+	usage.Name = project.Name
+	return usage, err
+}
+
+// checkQuota returns a *QuotaError wrapping errQuotaExceeded when the plan of the user
+// or of the project's team allows no more commands. Anonymous commands only count
+// against the team.
+func checkQuota(db *gorm.DB, userID, projectID string) error {
+	return checkQuotaFor(db, userID, projectID, 1)
+}
+
+// checkQuotaFor is checkQuota for a submission that creates count commands at once,
+// such as a batch. The daily command limit must leave room for all of them.
+func checkQuotaFor(db *gorm.DB, userID, projectID string, count int64) error {
+	var usages []QuotaUsage
+	if userID != "" {
+		var user User
+		if err := db.Limit(1).Find(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		if user.ID != "" {
+			usage, err := userQuotaUsage(db, &user)
+			if err != nil {
+				return err
+			}
+			usages = append(usages, usage)
+		}
+	}
+	if projectID != "" {
+		var project Project
+		if err := db.Limit(1).Find(&project, "id = ?", projectID).Error; err != nil {
+			return err
+		}
+		if project.ID != "" {
+			usage, err := teamQuotaUsage(db, &project)
+			if err != nil {
+				return err
+			}
+			usages = append(usages, usage)
+		}
+	}
+
+	for _, usage := range usages {
+		if plan := usage.Plan; plan != nil && plan.CommandsPerDay > 0 && usage.CommandsToday+count > plan.CommandsPerDay {
+			return &QuotaError{Usage: usage, Limit: "commandsPerDay", Requested: count}
+		}
+		for _, limit := range usage.Exceeded {
+			if limit != "commandsPerDay" {
+				return &QuotaError{Usage: usage, Limit: limit, Requested: count}
+			}
+		}
+	}
+	return nil
+}
+
+// quotaErrorResponse rejects a command submission because of checkQuota
+func quotaErrorResponse(c *fiber.Ctx, err error) error {
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to check quotas", err.Error())
+	}
+	retryAfter := quotaErr.resetsAt() - time.Now().Unix()
+	c.Set("Retry-After", strconv.FormatInt(max(retryAfter, 1), 10))
+	return sendError(c, 429, "QUOTA_EXCEEDED", "Quota exceeded: "+quotaErr.Error(), fiber.Map{
+		"scope":     quotaErr.Usage.Scope,
+		"subjectId": quotaErr.Usage.SubjectID,
+		"plan":      quotaErr.Usage.Plan.ID,
+		"limit":     quotaErr.Limit,
+		"resetsAt":  quotaErr.resetsAt(),
+	})
+}
+
+// recordRunTime adds the time the CLI ran to a command's total for CLI minute quotas
+func recordRunTime(db *gorm.DB, command *AICommand, elapsed time.Duration) {
+	command.RunMs += elapsed.Milliseconds()
+	if err := db.Model(&AICommand{}).Where("id = ?", command.ID).Update("run_ms", command.RunMs).Error; err != nil {
+		log.Printf("⚠️ Failed to record the run time of command [%s]: %v", command.ID, err)
+	}
+}
+
+// GetMyUsage reports the signed-in user's commands today, CLI minutes and tokens this
+// month against their plan, and the same for each of their teams
+func GetMyUsage(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		usage := MyUsage{Teams: []QuotaUsage{}}
+		var err error
+		if usage.User, err = userQuotaUsage(db, user); err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load usage", err.Error())
+		}
+		for _, team := range userTeams(db, user.ID) {
+			project := Project{ID: team.ProjectID}
+			if err := db.Limit(1).Find(&project, "id = ?", team.ProjectID).Error; err != nil {
+				return sendError(c, 500, "DATABASE_ERROR", "Failed to load project", err.Error())
+			}
+			teamUsage, err := teamQuotaUsage(db, &project)
+			if err != nil {
+				return sendError(c, 500, "DATABASE_ERROR", "Failed to load usage", err.Error())
+			}
+			usage.Teams = append(usage.Teams, teamUsage)
+		}
+		return c.JSON(APIResponse[MyUsage]{Success: true, Data: usage})
+	}
+}
+
+// ListPlans returns the plans, with the one users without a plan get
+func ListPlans(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		plans := []Plan{}
+		if err := db.Order("name").Find(&plans).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load plans", err.Error())
+		}
+		return c.JSON(fiber.Map{
+			"success":     true,
+			"data":        plans,
+			"defaultPlan": getDefaultPlan(),
+		})
+	}
+}
+
+// CreatePlan adds a plan tier
+func CreatePlan(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[PlanRequest](c)
+		if req.ID == "" {
+			req.ID = "plan_" + uuid.New().String()[:8]
+		}
+		existing, err := findPlan(db, req.ID)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load plans", err.Error())
+		}
+		if existing != nil {
+			return sendError(c, 409, "PLAN_EXISTS", "A plan with this id already exists", req.ID)
+		}
+
+		now := time.Now().Unix()
+		plan := Plan{
+			ID:              req.ID,
+			Name:            strings.TrimSpace(req.Name),
+			CommandsPerDay:  req.CommandsPerDay,
+			MinutesPerMonth: req.MinutesPerMonth,
+			TokensPerMonth:  req.TokensPerMonth,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		}
+		if err := db.Create(&plan).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create plan", err.Error())
+		}
+		recordAudit(db, c, AuditPlanCreate, plan.ID, nil, plan, plan.Name)
+		return c.Status(201).JSON(APIResponse[Plan]{Success: true, Data: plan})
+	}
+}
+
+// loadPlan fetches the plan named by :id, answering 404 itself when it does not exist
+func loadPlan(c *fiber.Ctx, db *gorm.DB) (*Plan, error) {
+	plan, err := findPlan(db, c.Params("id"))
+	if err != nil {
+		return nil, sendError(c, 500, "DATABASE_ERROR", "Failed to load plan", err.Error())
+	}
+	if plan == nil {
+		return nil, sendError(c, 404, "PLAN_NOT_FOUND", "Plan not found", c.Params("id"))
+	}
+	return plan, nil
+}
+
+// UpdatePlan changes the name and limits of a plan; the new limits apply to the next command
+func UpdatePlan(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[PlanRequest](c)
+		plan, err := loadPlan(c, db)
+		if plan == nil {
+			return err
+		}
+
+		before := *plan
+		plan.Name = strings.TrimSpace(req.Name)
+		plan.CommandsPerDay = req.CommandsPerDay
+		plan.MinutesPerMonth = req.MinutesPerMonth
+		plan.TokensPerMonth = req.TokensPerMonth
+		plan.UpdatedAt = time.Now().Unix()
+		if err := db.Save(plan).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update plan", err.Error())
+		}
+		recordAudit(db, c, AuditPlanUpdate, plan.ID, before, plan, plan.Name)
+		return c.JSON(APIResponse[Plan]{Success: true, Data: *plan})
+	}
+}
+
+// DeletePlan removes a plan no user or team has; the default plan can't be removed
+func DeletePlan(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		plan, err := loadPlan(c, db)
+		if plan == nil {
+			return err
+		}
+		if plan.ID == getDefaultPlan() {
+			return sendError(c, 409, "PLAN_IN_USE", "The plan is QUOTA_DEFAULT_PLAN", plan.ID)
+		}
+		var users, projects int64
+		db.Model(&User{}).Where("plan_id = ?", plan.ID).Count(&users)
+		db.Model(&Project{}).Where("plan_id = ?", plan.ID).Count(&projects)
+		if users+projects > 0 {
+			return sendError(c, 409, "PLAN_IN_USE", "The plan is given to users or teams",
+				fmt.Sprintf("%d user(s), %d team(s)", users, projects))
+		}
+
+		if err := db.Delete(plan).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete plan", err.Error())
+		}
+		recordAudit(db, c, AuditPlanDelete, plan.ID, plan, nil, plan.Name)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Plan deleted",
+		})
+	}
+}
+
+// assignedPlan loads the plan of a PlanAssignmentRequest, answering 404 itself for an
+// unknown plan. An empty plan returns a Plan without ID.
+func assignedPlan(c *fiber.Ctx, db *gorm.DB) (*Plan, error) {
+	id := validatedBody[PlanAssignmentRequest](c).Plan
+	if id == "" {
+		return &Plan{}, nil
+	}
+	plan, err := findPlan(db, id)
+	if err != nil {
+		return nil, sendError(c, 500, "DATABASE_ERROR", "Failed to load plan", err.Error())
+	}
+	if plan == nil {
+		return nil, sendError(c, 404, "PLAN_NOT_FOUND", "Plan not found", id)
+	}
+	return plan, nil
+}
+
+// SetUserPlan gives a user a plan; without one QUOTA_DEFAULT_PLAN applies
+func SetUserPlan(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		plan, err := assignedPlan(c, db)
+		if plan == nil {
+			return err
+		}
+		user, err := loadUser(c, db)
+		if user == nil {
+			return err
+		}
+
+		before := user.PlanID
+		user.PlanID = plan.ID
+		user.UpdatedAt = time.Now().Unix()
+		if err := db.Model(user).Updates(map[string]interface{}{"plan_id": plan.ID, "updated_at": user.UpdatedAt}).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update user", err.Error())
+		}
+		recordAudit(db, c, AuditPlanAssign, user.ID, before, plan.ID, "user")
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    user,
+		})
+	}
+}
+
+// SetProjectPlan gives a project's team a plan, which limits all commands of the project
+func SetProjectPlan(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		plan, err := assignedPlan(c, db)
+		if plan == nil {
+			return err
+		}
+		project, err := loadProject(c, db)
+		if project == nil {
+			return err
+		}
+
+		before := project.PlanID
+		project.PlanID = plan.ID
+		project.UpdatedAt = time.Now().Unix()
+		if err := db.Model(project).Updates(map[string]interface{}{"plan_id": plan.ID, "updated_at": project.UpdatedAt}).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update project", err.Error())
+		}
+		recordAudit(db, c, AuditPlanAssign, project.ID, before, plan.ID, "team")
+		return c.JSON(APIResponse[Project]{Success: true, Data: *project})
+	}
+}
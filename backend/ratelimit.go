@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	tokens   float64
+	lastFill time.Time
+}
+
+// RateLimiter is an in-memory token bucket limiter keyed by user or IP
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	rate    float64 // Tokens added per second
+	burst   float64 // Bucket capacity
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests with the given burst
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	return &RateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+	}
+}
+
+// Allow takes a token for key, returning how long to wait when none is available
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, lastFill: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.lastFill).Seconds()*l.rate)
+	bucket.lastFill = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// Prune drops buckets that have refilled completely and are therefore idle
+func (l *RateLimiter) Prune() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	pruned := 0
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.lastFill).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// redisRateLimiter keeps the token buckets in Redis, so every instance takes from the
+// same bucket (Redis mode)
+type redisRateLimiter struct {
+	cluster *redisCluster
+	rate    float64
+	burst   float64
+}
+
+func newRedisRateLimiter(cluster *redisCluster, perMinute, burst int) *redisRateLimiter {
+	return &redisRateLimiter{cluster: cluster, rate: float64(perMinute) / 60, burst: float64(burst)}
+}
+
+// tokenBucketScript refills the bucket in KEYS[1] with ARGV[1] tokens per second up to
+// ARGV[2] and takes one. It returns 1 and 0, or 0 and the milliseconds to wait.
+var tokenBucketScript = redis.NewScript(`
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+if allowed == 1 then
+	return {1, 0}
+end
+return {0, math.ceil((1 - tokens) / rate * 1000)}
+`)
+
+func (l *redisRateLimiter) Allow(key string) (bool, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	result, err := tokenBucketScript.Run(ctx, l.cluster.client, []string{l.cluster.key("ratelimit", key)}, l.rate, l.burst).Int64Slice()
+	if err != nil || len(result) != 2 {
+		// Rather let requests through than fail every AI request while Redis is away
+		log.Printf("⚠️ Rate limit check in Redis failed: %v", err)
+		return true, 0
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond
+}
+
+// Prune has nothing to do: idle buckets expire in Redis once they are full again
+func (l *redisRateLimiter) Prune() int {
+	return 0
+}
+
+// getRateLimitSettings reads AI_RATE_LIMIT_PER_MINUTE and AI_RATE_LIMIT_BURST
+// Defaults to 10 requests per minute with a burst of 5; a rate of 0 disables limiting
+func getRateLimitSettings() (perMinute, burst int) {
+	perMinute, burst = 10, 5
+	if n, err := strconv.Atoi(os.Getenv("AI_RATE_LIMIT_PER_MINUTE")); err == nil && n >= 0 {
+		perMinute = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AI_RATE_LIMIT_BURST")); err == nil && n > 0 {
+		burst = n
+	}
+	return perMinute, burst
+}
+
+// AIRateLimiter takes a token from the bucket of a caller, returning how long to wait
+// when none is left
+type AIRateLimiter interface {
+	Allow(key string) (bool, time.Duration)
+	Prune() int // Drops idle buckets and returns how many
+}
+
+// aiRateLimiter is shared by every endpoint that spawns AI processes. It is replaced by
+// a redisRateLimiter in Redis mode.
+var aiRateLimiter AIRateLimiter = NewRateLimiter(getRateLimitSettings())
+
+// rateLimitKey identifies the caller by the signed-in user, else by IP
+func rateLimitKey(c *fiber.Ctx) string {
+	if user := currentUser(c); user != nil {
+		return "user:" + user.ID
+	}
+	return "ip:" + c.IP()
+}
+
+// RateLimitAI rejects callers that exceed their AI command budget with 429
+func RateLimitAI() fiber.Handler {
+	perMinute, _ := getRateLimitSettings()
+	return func(c *fiber.Ctx) error {
+		if perMinute == 0 {
+			return c.Next()
+		}
+
+		allowed, wait := aiRateLimiter.Allow(rateLimitKey(c))
+		if allowed {
+			return c.Next()
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Set("Retry-After", strconv.Itoa(retryAfter))
+		return sendError(c, 429, ErrCodeRateLimited, "Too many AI requests, please slow down", fiber.Map{
+			"retryAfter": retryAfter,
+		})
+	}
+}
+
+// getFormRateLimitSettings reads FORM_RATE_LIMIT_PER_MINUTE and FORM_RATE_LIMIT_BURST
+// Defaults to 5 submissions per minute with a burst of 3; a rate of 0 disables limiting
+func getFormRateLimitSettings() (perMinute, burst int) {
+	perMinute, burst = 5, 3
+	if n, err := strconv.Atoi(os.Getenv("FORM_RATE_LIMIT_PER_MINUTE")); err == nil && n >= 0 {
+		perMinute = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FORM_RATE_LIMIT_BURST")); err == nil && n > 0 {
+		burst = n
+	}
+	return perMinute, burst
+}
+
+// formRateLimiter limits form submissions per visitor IP. It is replaced by a
+// redisRateLimiter in Redis mode.
+var formRateLimiter AIRateLimiter = NewRateLimiter(getFormRateLimitSettings())
+
+// RateLimitForms rejects visitors that send forms too often with 429
+func RateLimitForms() fiber.Handler {
+	perMinute, _ := getFormRateLimitSettings()
+	return func(c *fiber.Ctx) error {
+		if perMinute == 0 {
+			return c.Next()
+		}
+
+		allowed, wait := formRateLimiter.Allow("form:" + c.IP())
+		if allowed {
+			return c.Next()
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Set("Retry-After", strconv.Itoa(retryAfter))
+		return sendError(c, 429, ErrCodeRateLimited, "Too many submissions, please try again later", fiber.Map{
+			"retryAfter": retryAfter,
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RenderMarkdownRequest is the body of POST /api/render/markdown
+type RenderMarkdownRequest struct {
+	Markdown string `json:"markdown"`
+}
+
+// RenderMarkdownResponse is the response of POST /api/render/markdown
+type RenderMarkdownResponse struct {
+	HTML string `json:"html"`
+}
+
+// renderMarkdown compiles Markdown and cleans the HTML with the same policy as
+// rich text blocks (CONTENT_SANITIZER)
+func renderMarkdown(source string) (string, error) {
+	rendered, err := compileMarkdown(source)
+	if err != nil {
+		return "", err
+	}
+	if policy := contentPolicy(); policy != nil {
+		rendered = policy.Sanitize(rendered)
+	}
+	return rendered, nil
+}
+
+// renderContentResponse replaces "content" of a Markdown block with its HTML when
+// the client asked for ?render=html. Other types are returned as they are.
+func renderContentResponse(response ContentResponse, content Content, render string) ContentResponse {
+	if render != "html" || content.Type != ContentTypeMarkdown {
+		return response
+	}
+	rendered, err := renderMarkdown(response.Content)
+	if err != nil {
+		return response
+	}
+	response.Content = rendered
+	response.Rendered = "html"
+	return response
+}
+
+// validRender reports whether ?render= holds a supported value
+func validRender(render string) bool {
+	return render == "" || render == "html"
+}
+
+// RenderMarkdown turns Markdown into sanitized HTML. The source is sent as
+// {"markdown": "..."} or as a text/markdown (or text/plain) body.
+func RenderMarkdown() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req RenderMarkdownRequest
+		if contentType := c.Get(fiber.HeaderContentType); strings.HasPrefix(contentType, "text/") {
+			req.Markdown = string(c.Body())
+		} else if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", nil)
+		}
+
+		rendered, err := renderMarkdown(req.Markdown)
+		if err != nil {
+			return sendError(c, 422, "RENDER_FAILED", "Failed to render Markdown: "+err.Error(), nil)
+		}
+
+		return c.JSON(RenderMarkdownResponse{HTML: rendered})
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+// APIResponse is the envelope of the AI, project and admin endpoints. Errors of
+// every endpoint are answered with an APIErrorResponse.
+type APIResponse[T any] struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Data    T      `json:"data"`
+}
+
+// DeletedResponse is the data of a response to a DELETE of a resource
+type DeletedResponse struct {
+	ID      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Sanitizer policies selectable via CONTENT_SANITIZER
+const (
+	SanitizerHTML   = "html"   // Formatting, links and images; no scripts, event handlers or javascript: URLs
+	SanitizerStrict = "strict" // Strips every tag, leaving text
+	SanitizerOff    = "off"    // Stores content as submitted
+)
+
+var (
+	sanitizerPolicies     map[string]*bluemonday.Policy
+	sanitizerPoliciesOnce sync.Once
+)
+
+// getSanitizer returns the policy applied to saved content from CONTENT_SANITIZER
+// Falls back to html
+func getSanitizer() string {
+	switch policy := os.Getenv("CONTENT_SANITIZER"); policy {
+	case SanitizerHTML, SanitizerStrict, SanitizerOff:
+		return policy
+	case "":
+	default:
+		log.Printf("⚠️ Unknown CONTENT_SANITIZER %q, using %s", policy, SanitizerHTML)
+	}
+	return SanitizerHTML
+}
+
+// htmlPolicy allows what editors put into a page. Unlike bluemonday's UGC policy it
+// keeps styling hooks and does not force rel="nofollow", since the content is the
+// site's own.
+func htmlPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowStyling()
+	p.AllowDataAttributes()
+	p.AllowAttrs("id").Globally()
+	p.AllowAttrs("target").Matching(bluemonday.Paragraph).OnElements("a")
+	p.RequireNoFollowOnLinks(false)
+	return p
+}
+
+// contentPolicy returns the sanitizer policy for content, or nil when sanitizing is off
+func contentPolicy() *bluemonday.Policy {
+	sanitizerPoliciesOnce.Do(func() {
+		// Policies are safe for concurrent use once built
+		sanitizerPolicies = map[string]*bluemonday.Policy{
+			SanitizerHTML:   htmlPolicy(),
+			SanitizerStrict: bluemonday.StrictPolicy(),
+		}
+	})
+	return sanitizerPolicies[getSanitizer()]
+}
+
+// sanitizeContent applies the configured policy to rich text and reports whether
+// anything was removed. Other types are validated instead, or like Markdown
+// sanitized when they are rendered.
+func sanitizeContent(contentType, content string) (string, bool) {
+	if contentType != ContentTypeRichText {
+		return content, false
+	}
+	policy := contentPolicy()
+	if policy == nil || content == "" {
+		return content, false
+	}
+	clean := policy.Sanitize(content)
+	return clean, clean != content
+}
+
+// sanitizeRequest cleans the submitted content of a save in place
+func sanitizeRequest(req *ContentRequest, contentType string) bool {
+	var edited, original bool
+	req.Content, edited = sanitizeContent(contentType, req.Content)
+	req.OriginalContent, original = sanitizeContent(contentType, req.OriginalContent)
+	return edited || original
+}
+
+// sanitizeImported cleans every content field of an imported block in place
+func sanitizeImported(content *Content) bool {
+	var changed [3]bool
+	content.OriginalContent, changed[0] = sanitizeContent(content.Type, content.OriginalContent)
+	content.EditedContent, changed[1] = sanitizeContent(content.Type, content.EditedContent)
+	content.PublishedContent, changed[2] = sanitizeContent(content.Type, content.PublishedContent)
+	return changed[0] || changed[1] || changed[2]
+}
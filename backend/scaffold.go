@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pageTemplateHead opens the document of every built-in template
+const pageTemplateHead = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Title | html}}</title>
+</head>
+<body>
+`
+
+// builtinPageTemplates are the page templates every workspace can use
+var builtinPageTemplates = []Template{
+	{
+		ID:          "blank",
+		Name:        "Blank page",
+		Kind:        TemplateKindPage,
+		Builtin:     true,
+		Description: "A title and one block of text",
+		HTML: pageTemplateHead + `  <main>
+    <h1 {{editable "title"}}>{{content "title"}}</h1>
+    <div {{editable "body"}}>{{content "body"}}</div>
+  </main>
+</body>
+</html>
+`,
+		Blocks: []TemplateBlock{
+			{Key: "title", Type: ContentTypePlainText, Content: "{{.Title}}"},
+			{Key: "body", Content: "<p>Start writing here.</p>"},
+		},
+	},
+	{
+		ID:          "landing",
+		Name:        "Landing page",
+		Kind:        TemplateKindPage,
+		Builtin:     true,
+		Description: "A hero with a call to action, followed by three features",
+		HTML: pageTemplateHead + `  <header class="hero">
+    <h1 {{editable "hero_title"}}>{{content "hero_title"}}</h1>
+    <p {{editable "hero_subtitle"}}>{{content "hero_subtitle"}}</p>
+    <a class="button" href="#features" {{editable "cta"}}>{{content "cta"}}</a>
+  </header>
+  <section id="features" class="features">
+    <div {{editable "feature_1"}}>{{content "feature_1"}}</div>
+    <div {{editable "feature_2"}}>{{content "feature_2"}}</div>
+    <div {{editable "feature_3"}}>{{content "feature_3"}}</div>
+  </section>
+</body>
+</html>
+`,
+		Blocks: []TemplateBlock{
+			{Key: "hero_title", Type: ContentTypePlainText, Content: "{{.Title}}"},
+			{Key: "hero_subtitle", Type: ContentTypePlainText, Content: "Say in one sentence what this page offers."},
+			{Key: "cta", Type: ContentTypePlainText, Content: "Learn more"},
+			{Key: "feature_1", Content: "<h2>First feature</h2><p>Describe it here.</p>"},
+			{Key: "feature_2", Content: "<h2>Second feature</h2><p>Describe it here.</p>"},
+			{Key: "feature_3", Content: "<h2>Third feature</h2><p>Describe it here.</p>"},
+		},
+	},
+	{
+		ID:          "article",
+		Name:        "Article",
+		Kind:        TemplateKindPage,
+		Builtin:     true,
+		Description: "A headline, a lead paragraph and a Markdown body",
+		HTML: pageTemplateHead + `  <article>
+    <h1 {{editable "title"}}>{{content "title"}}</h1>
+    <p class="lead" {{editable "lead"}}>{{content "lead"}}</p>
+    <div {{editable "body"}}>{{content "body"}}</div>
+  </article>
+</body>
+</html>
+`,
+		Blocks: []TemplateBlock{
+			{Key: "title", Type: ContentTypePlainText, Content: "{{.Title}}"},
+			{Key: "lead", Type: ContentTypePlainText, Content: "A short summary of the article."},
+			{Key: "body", Type: ContentTypeMarkdown, Content: "Write the article in **Markdown**."},
+		},
+	},
+	{
+		ID:          "contact",
+		Name:        "Contact page",
+		Kind:        TemplateKindPage,
+		Builtin:     true,
+		Description: "An introduction with the address, email and phone number",
+		HTML: pageTemplateHead + `  <main>
+    <h1 {{editable "title"}}>{{content "title"}}</h1>
+    <div {{editable "intro"}}>{{content "intro"}}</div>
+    <address>
+      <p {{editable "address"}}>{{content "address"}}</p>
+      <p {{editable "email"}}>{{content "email"}}</p>
+      <p {{editable "phone"}}>{{content "phone"}}</p>
+    </address>
+  </main>
+</body>
+</html>
+`,
+		Blocks: []TemplateBlock{
+			{Key: "title", Type: ContentTypePlainText, Content: "{{.Title}}"},
+			{Key: "intro", Content: "<p>We would love to hear from you.</p>"},
+			{Key: "address", Type: ContentTypePlainText, Content: "1 Main Street, Springfield"},
+			{Key: "email", Type: ContentTypePlainText, Content: "hello@example.com"},
+			{Key: "phone", Type: ContentTypePlainText, Content: "+1 555 0100"},
+		},
+	},
+}
+
+// renderedTemplate is a template rendered for one page
+type renderedTemplate struct {
+	HTML   string
+	Blocks []Content // Blocks the HTML places, in order
+}
+
+// renderTemplate fills in a template for a page, naming its blocks <page>:<prefix><key>.
+// Plain text and Markdown blocks are escaped in the HTML; rich text is placed as it is.
+func renderTemplate(tmpl Template, page, title, prefix string) (*renderedTemplate, error) {
+	data := struct{ Page, Title string }{page, title}
+	defaults := make(map[string]Content, len(tmpl.Blocks))
+	for _, block := range tmpl.Blocks {
+		contentType := block.Type
+		if contentType == "" {
+			contentType = ContentTypeRichText
+		}
+		var content strings.Builder
+		parsed, err := template.New(block.Key).Parse(block.Content)
+		if err == nil {
+			err = parsed.Execute(&content, data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %w", block.Key, err)
+		}
+		defaults[block.Key] = Content{
+			ID:              page + ":" + prefix + block.Key,
+			Page:            page,
+			Type:            contentType,
+			OriginalContent: content.String(),
+		}
+	}
+
+	rendered := &renderedTemplate{}
+	placed := map[string]bool{}
+	lookup := func(key string) (Content, error) {
+		block, ok := defaults[key]
+		if !ok {
+			return Content{}, fmt.Errorf("the template has no block %q", key)
+		}
+		if !placed[key] {
+			placed[key] = true
+			rendered.Blocks = append(rendered.Blocks, block)
+		}
+		return block, nil
+	}
+	funcs := template.FuncMap{
+		"editable": func(key string) (string, error) {
+			block, err := lookup(key)
+			return `data-editable="` + template.HTMLEscapeString(block.ID) + `"`, err
+		},
+		"content": func(key string) (string, error) {
+			block, err := lookup(key)
+			if block.Type == ContentTypeRichText {
+				return block.OriginalContent, err
+			}
+			return template.HTMLEscapeString(block.OriginalContent), err
+		},
+	}
+
+	parsed, err := template.New(tmpl.ID).Funcs(funcs).Parse(tmpl.HTML)
+	if err != nil {
+		return nil, err
+	}
+	var html strings.Builder
+	if err := parsed.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	rendered.HTML = html.String()
+	return rendered, nil
+}
+
+// pageTitleFromName turns the last part of a page name into a title: blog/my-post
+// becomes "My post"
+func pageTitleFromName(name string) string {
+	title := strings.NewReplacer("-", " ", "_", " ", ".", " ").Replace(filepath.Base(name))
+	if title == "" {
+		return name
+	}
+	return strings.ToUpper(title[:1]) + title[1:]
+}
+
+// CreatePageRequest is the body of POST /api/pages
+type CreatePageRequest struct {
+	Name      string `json:"name" validate:"required,pagename"`
+	Template  string `json:"template,omitempty" validate:"max=64"` // Template ID; blank when omitted
+	Title     string `json:"title,omitempty" validate:"max=200"`   // Derived from the name when omitted
+	Path      string `json:"path,omitempty" validate:"max=512"`    // HTML file to create, relative to the workspace; <name>.html when omitted
+	ProjectID string `json:"projectId,omitempty"`
+}
+
+// PageCreatedResponse is the response of POST /api/pages
+type PageCreatedResponse struct {
+	Page     string            `json:"page"`
+	Template string            `json:"template"`
+	Path     string            `json:"path"` // Created file, relative to the workspace
+	Items    []ContentResponse `json:"items"`
+}
+
+// PageTemplateListResponse is the response of GET /api/pages/templates
+type PageTemplateListResponse struct {
+	Templates []Template `json:"templates"`
+}
+
+// ListPageTemplates returns the templates pages can be created from: the built-in ones,
+// then those of the template library
+func ListPageTemplates(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var stored []Template
+		if err := db.Where("kind = ?", TemplateKindPage).Order("name, created_at").Find(&stored).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load templates", err.Error())
+		}
+		return c.JSON(PageTemplateListResponse{Templates: append(append([]Template{}, builtinPageTemplates...), stored...)})
+	}
+}
+
+// CreatePage writes a new page into the workspace from a template and registers its
+// editable blocks, without going through the AI
+func CreatePage(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[CreatePageRequest](c)
+		if req.Template == "" {
+			req.Template = "blank"
+		}
+		tmpl, err := findTemplate(db, req.Template)
+		if err != nil {
+			return err
+		}
+		if tmpl.Kind != TemplateKindPage {
+			return sendError(c, 400, "NOT_A_PAGE_TEMPLATE", "This template is a section; instantiate it on a page instead", req.Template)
+		}
+		return createPageFromTemplate(c, db, tmpl, *req)
+	}
+}
+
+// createPageFromTemplate writes the file of a new page and registers its blocks
+func createPageFromTemplate(c *fiber.Ctx, db *gorm.DB, tmpl Template, req CreatePageRequest) error {
+	if req.Title == "" {
+		req.Title = pageTitleFromName(req.Name)
+	}
+	if req.Path == "" {
+		req.Path = req.Name + ".html"
+	}
+	if ext := strings.ToLower(filepath.Ext(req.Path)); ext != ".html" && ext != ".htm" {
+		return sendError(c, 400, "INVALID_PATH", "Templates create HTML pages; the path must end in .html or .htm", req.Path)
+	}
+
+	var existing int64
+	if err := db.Model(&Page{}).Where("name = ?", req.Name).Count(&existing).Error; err != nil {
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to load pages", nil)
+	}
+	if existing > 0 {
+		return sendError(c, 409, "PAGE_EXISTS", "A page with this name already exists", req.Name)
+	}
+
+	workspaceDir, err := resolveWorkspaceDir(db, req.ProjectID)
+	if err != nil {
+		if errors.Is(err, errProjectNotFound) {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", err.Error())
+		}
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+	}
+	file, err := resolvePathInDir(workspaceDir, req.Path)
+	if err != nil {
+		return workspacePathError(c, err)
+	}
+
+	rendered, err := renderTemplate(tmpl, req.Name, req.Title, "")
+	if err != nil {
+		return sendError(c, 500, "TEMPLATE_ERROR", "Failed to render the template", err.Error())
+	}
+
+	// The file is created first so an existing one is never overwritten
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return workspacePathError(c, err)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return sendError(c, 409, "FILE_EXISTS", "The page file already exists", req.Path)
+	}
+	if err != nil {
+		return workspacePathError(c, err)
+	}
+	_, err = f.WriteString(rendered.HTML)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return sendError(c, 500, "WORKSPACE_ERROR", "Failed to write the page file", err.Error())
+	}
+
+	// Blocks that already exist, for example from an earlier page of the same name,
+	// keep their content
+	items, err := registerTemplateBlocks(db, req.Name, rendered.Blocks)
+	if err != nil {
+		os.Remove(file)
+		return sendError(c, 500, "DATABASE_ERROR", "Failed to register the page content", err.Error())
+	}
+	if req.ProjectID != "" {
+		db.Model(&Page{}).Where("name = ?", req.Name).Update("project_id", req.ProjectID)
+	}
+	rel := path.Clean("/" + req.Path)[1:]
+
+	log.Printf("📄 Page created: %s from template %s (%s, %d content blocks)", req.Name, tmpl.ID, rel, len(items))
+	recordAudit(db, c, AuditPageCreate, req.Name, nil, rendered.HTML, fmt.Sprintf("template %s, %s", tmpl.ID, rel))
+
+	return c.Status(201).JSON(PageCreatedResponse{
+		Page:     req.Name,
+		Template: tmpl.ID,
+		Path:     rel,
+		Items:    items,
+	})
+}
+
+// registerTemplateBlocks creates the blocks of a rendered template in the default
+// locale and returns them as stored; blocks that already exist are left as they are
+func registerTemplateBlocks(db *gorm.DB, page string, blocks []Content) ([]ContentResponse, error) {
+	now := time.Now().Unix()
+	locale := getDefaultLocale()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := touchPage(tx, page); err != nil {
+			return err
+		}
+		for i := range blocks {
+			block := &blocks[i]
+			block.Locale, block.Version, block.UpdatedAt = locale, 1, now
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(block).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		ids = append(ids, block.ID)
+	}
+	var stored []Content
+	if err := db.Where("id IN ? AND locale = ?", ids, locale).Order("id").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	items := make([]ContentResponse, 0, len(stored))
+	for _, block := range stored {
+		items = append(items, contentResponse(block, ContentStateDraft))
+	}
+	return items, nil
+}
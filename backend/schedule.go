@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// ScheduledCommand creates an AI command on a cron schedule or once at a given time
+type ScheduledCommand struct {
+	ID       string         `gorm:"primaryKey" json:"id"`
+	Name     string         `json:"name"`
+	Prompt   string         `gorm:"type:text;serializer:encrypted" json:"prompt"`
+	Scope    string         `json:"scope"`
+	Provider string         `json:"provider,omitempty"`
+	Context  CommandContext `gorm:"serializer:json" json:"context"`
+
+	Cron  string `json:"cron,omitempty"`  // Standard 5-field expression or descriptor (@daily), in server time
+	RunAt int64  `json:"runAt,omitempty"` // One-shot Unix time when Cron is empty
+
+	Enabled       bool   `json:"enabled"`
+	NextRunAt     int64  `gorm:"index" json:"nextRunAt,omitempty"` // 0 once a one-shot schedule has fired
+	LastRunAt     int64  `json:"lastRunAt,omitempty"`
+	LastCommandID string `json:"lastCommandId,omitempty"`
+	LastError     string `gorm:"type:text" json:"lastError,omitempty"`
+	CreatedAt     int64  `json:"createdAt"`
+	UpdatedAt     int64  `json:"updatedAt"`
+}
+
+// ScheduleRequest is the body of POST /api/ai/schedule
+type ScheduleRequest struct {
+	Name     string         `json:"name"`
+	Prompt   string         `json:"prompt"`
+	Scope    string         `json:"scope"`
+	Provider string         `json:"provider,omitempty"`
+	Context  CommandContext `json:"context"`
+	Cron     string         `json:"cron,omitempty"`  // e.g. "0 3 * * *" for every night at 03:00
+	RunAt    string         `json:"runAt,omitempty"` // RFC 3339 time for a one-shot run
+}
+
+// getSchedulerInterval returns how often due schedules are checked from SCHEDULER_INTERVAL
+// Falls back to 30 seconds
+func getSchedulerInterval() time.Duration {
+	return getEnvDuration("SCHEDULER_INTERVAL", 30*time.Second)
+}
+
+// nextScheduledRun returns the first run of a schedule strictly after from; 0 means never
+func nextScheduledRun(s *ScheduledCommand, from time.Time) (int64, error) {
+	if s.Cron == "" {
+		if s.RunAt > from.Unix() {
+			return s.RunAt, nil
+		}
+		return 0, nil
+	}
+	schedule, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return 0, err
+	}
+	return schedule.Next(from).Unix(), nil
+}
+
+// StartScheduler periodically turns due schedules into AI commands
+func StartScheduler(db *gorm.DB) {
+	interval := getSchedulerInterval()
+	log.Printf("⏰ Command scheduler running every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if shuttingDown.Load() {
+			return
+		}
+		runDueSchedules(db, time.Now())
+	}
+}
+
+// runDueSchedules fires every enabled schedule whose next run has passed.
+// Runs missed while the backend was down are fired once, not caught up.
+func runDueSchedules(db *gorm.DB, now time.Time) {
+	var due []ScheduledCommand
+	if err := db.Where("enabled = ? AND next_run_at > 0 AND next_run_at <= ?", true, now.Unix()).Find(&due).Error; err != nil {
+		log.Printf("⚠️ Scheduler failed to query due schedules: %v", err)
+		return
+	}
+
+	for i := range due {
+		s := &due[i]
+		next, err := nextScheduledRun(s, now)
+		if err != nil {
+			log.Printf("⚠️ Schedule %s has an invalid cron expression: %v", s.ID, err)
+			next = 0
+		}
+
+		// Claim the run so it fires once even if ticks overlap
+		claim := db.Model(&ScheduledCommand{}).Where("id = ? AND next_run_at = ?", s.ID, s.NextRunAt).Updates(map[string]interface{}{
+			"next_run_at": next,
+			"enabled":     next > 0,
+			"last_run_at": now.Unix(),
+			"updated_at":  now.Unix(),
+		})
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+
+		command, err := startScheduledCommand(db, s)
+		updates := map[string]interface{}{"last_error": ""}
+		if err != nil {
+			log.Printf("❌ Schedule %s failed to start: %v", s.ID, err)
+			updates["last_error"] = err.Error()
+		} else {
+			log.Printf("⏰ Schedule %s started command [%s] (%s)", s.ID, command.ID, command.Status)
+			updates["last_command_id"] = command.ID
+		}
+		db.Model(&ScheduledCommand{}).Where("id = ?", s.ID).Updates(updates)
+	}
+}
+
+// startScheduledCommand creates a normal AICommand for the schedule and runs it.
+// Commands caught by the approval policy wait for a reviewer like any other command.
+func startScheduledCommand(db *gorm.DB, s *ScheduledCommand) (*AICommand, error) {
+	req := AICommandRequest{
+		Prompt:   s.Prompt,
+		Scope:    s.Scope,
+		Provider: s.Provider,
+		Context:  s.Context,
+	}
+	if req.Provider == "" {
+		req.Provider = getDefaultProvider()
+	}
+	if err := checkBudget(db, req.Context.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := checkQuota(db, req.Context.UserID, req.Context.ProjectID); err != nil {
+		return nil, err
+	}
+
+	conversation, err := resolveConversation(db, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+
+	status := "queued"
+	reasons := approvalReasons(req)
+	if len(reasons) > 0 {
+		status = "pending_approval"
+	}
+
+	command := &AICommand{
+		ID:        fmt.Sprintf("cmd_%d_%s", time.Now().Unix(), uuid.New().String()[:8]),
+		Prompt:    req.Prompt,
+		Scope:     req.Scope,
+		Provider:  req.Provider,
+		Page:      req.Context.Page,
+		UserID:    req.Context.UserID,
+		ProjectID: req.Context.ProjectID,
+		Status:    status,
+		CreatedAt: time.Now().Unix(),
+
+		ConversationID: conversation.ID,
+		ComponentID:    req.Context.ComponentID,
+		Selector:       req.Context.Selector,
+		ApprovalReason: strings.Join(reasons, "; "),
+		Priority:       PriorityLow,
+	}
+	if err := db.Create(command).Error; err != nil {
+		return nil, err
+	}
+
+	if status != "queued" {
+		return command, nil
+	}
+	if commandQueue != nil {
+		return command, dispatchCommand(db, command)
+	}
+	runCommandHeadless(db, command)
+	return command, nil
+}
+
+// CreateSchedule registers a cron or one-shot AI command
+func CreateSchedule(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req ScheduleRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if req.Prompt == "" {
+			return sendError(c, 400, "MISSING_PROMPT", "Prompt is required", "")
+		}
+		req.Context.UserID = currentUserID(c)
+		if req.Scope != "current-page" && req.Scope != "new-page" && req.Scope != "global" && req.Scope != "component" {
+			return sendError(c, 400, "INVALID_SCOPE", "Invalid scope value provided", "Scope must be one of: current-page, new-page, global, component")
+		}
+		if req.Scope == "component" {
+			if err := validateComponentTarget(req.Context); err != nil {
+				return sendError(c, 400, "INVALID_COMPONENT", "Invalid component target", err.Error())
+			}
+		}
+		if req.Provider != "" {
+			if _, err := getProvider(req.Provider); err != nil {
+				return sendError(c, 400, "INVALID_PROVIDER", "Invalid AI provider", err.Error())
+			}
+		}
+		if _, err := resolveWorkspaceDir(db, req.Context.ProjectID); err != nil {
+			if errors.Is(err, errProjectNotFound) {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", err.Error())
+			}
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+
+		now := time.Now()
+		schedule := ScheduledCommand{
+			ID:        "sched_" + uuid.New().String()[:8],
+			Name:      req.Name,
+			Prompt:    req.Prompt,
+			Scope:     req.Scope,
+			Provider:  req.Provider,
+			Context:   req.Context,
+			Cron:      strings.TrimSpace(req.Cron),
+			Enabled:   true,
+			CreatedAt: now.Unix(),
+			UpdatedAt: now.Unix(),
+		}
+
+		switch {
+		case schedule.Cron != "" && req.RunAt != "":
+			return sendError(c, 400, "INVALID_SCHEDULE", "Give either cron or runAt, not both", "")
+		case schedule.Cron != "":
+			if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+				return sendError(c, 400, "INVALID_CRON", "Invalid cron expression", err.Error())
+			}
+		case req.RunAt != "":
+			runAt, err := time.Parse(time.RFC3339, req.RunAt)
+			if err != nil {
+				return sendError(c, 400, "INVALID_RUN_AT", "runAt must be an RFC 3339 time", err.Error())
+			}
+			if !runAt.After(now) {
+				return sendError(c, 400, "INVALID_RUN_AT", "runAt must be in the future", "")
+			}
+			schedule.RunAt = runAt.Unix()
+		default:
+			return sendError(c, 400, "INVALID_SCHEDULE", "cron or runAt is required", "")
+		}
+
+		next, _ := nextScheduledRun(&schedule, now)
+		schedule.NextRunAt = next
+
+		if err := db.Create(&schedule).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create schedule", err.Error())
+		}
+
+		log.Printf("⏰ Schedule %s created, next run at %s", schedule.ID, time.Unix(next, 0).Format(time.RFC3339))
+		return c.Status(201).JSON(APIResponse[ScheduledCommand]{
+			Success: true,
+			Data:    schedule,
+		})
+	}
+}
+
+// ScheduleList is the data of GET /api/ai/schedule
+type ScheduleList struct {
+	Schedules []ScheduledCommand `json:"schedules"`
+}
+
+// ListSchedules returns every schedule, soonest first
+func ListSchedules(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var schedules []ScheduledCommand
+		if err := db.Order("enabled DESC, next_run_at").Find(&schedules).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load schedules", err.Error())
+		}
+
+		return c.JSON(APIResponse[ScheduleList]{
+			Success: true,
+			Data:    ScheduleList{Schedules: schedules},
+		})
+	}
+}
+
+// GetSchedule returns a single schedule
+func GetSchedule(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var schedule ScheduledCommand
+		if err := db.First(&schedule, "id = ?", c.Params("id")).Error; err != nil {
+			return sendError(c, 404, "SCHEDULE_NOT_FOUND", "Schedule not found", "")
+		}
+
+		return c.JSON(APIResponse[ScheduledCommand]{
+			Success: true,
+			Data:    schedule,
+		})
+	}
+}
+
+// DeleteSchedule removes a schedule; commands it already created are kept
+func DeleteSchedule(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result := db.Delete(&ScheduledCommand{}, "id = ?", c.Params("id"))
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete schedule", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "SCHEDULE_NOT_FOUND", "Schedule not found", "")
+		}
+
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: c.Params("id"), Deleted: true},
+		})
+	}
+}
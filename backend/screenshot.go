@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/draw"
+	"gorm.io/gorm"
+)
+
+// screenshotViewport is the browser window a page is rendered in
+type screenshotViewport struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// screenshotViewports are the named viewports of ?viewport=; others are given as WIDTHxHEIGHT
+var screenshotViewports = map[string]screenshotViewport{
+	"desktop": {Name: "desktop", Width: 1440, Height: 900},
+	"tablet":  {Name: "tablet", Width: 768, Height: 1024},
+	"mobile":  {Name: "mobile", Width: 390, Height: 844},
+}
+
+// screenshotBrowsers are looked up in PATH when SCREENSHOT_BROWSER is not set
+var screenshotBrowsers = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "headless_shell"}
+
+// screenshotSlots limits how many browsers run at once
+var screenshotSlots = make(chan struct{}, 2)
+
+var errNoScreenshotBrowser = errors.New("no headless Chromium found; install chromium or set SCREENSHOT_BROWSER")
+
+// getScreenshotBrowser returns the Chromium binary from SCREENSHOT_BROWSER, then the first
+// of screenshotBrowsers in PATH. Returns "" when there is none.
+func getScreenshotBrowser() string {
+	if browser := os.Getenv("SCREENSHOT_BROWSER"); browser != "" {
+		return browser
+	}
+	for _, name := range screenshotBrowsers {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// getScreenshotDir returns where rendered screenshots are cached from SCREENSHOT_DIR
+// Falls back to ./screenshots
+func getScreenshotDir() string {
+	return getEnvDefault("SCREENSHOT_DIR", "screenshots")
+}
+
+// parseViewport reads ?viewport=: desktop (default), tablet, mobile or WIDTHxHEIGHT
+func parseViewport(raw string) (screenshotViewport, error) {
+	if raw == "" {
+		return screenshotViewports["desktop"], nil
+	}
+	if viewport, ok := screenshotViewports[strings.ToLower(raw)]; ok {
+		return viewport, nil
+	}
+	w, h, ok := strings.Cut(strings.ToLower(raw), "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil || width < 200 || height < 200 || width > 3840 || height > 3840 {
+		return screenshotViewport{}, newAPIError(400, "INVALID_VIEWPORT", "viewport must be desktop, tablet, mobile or WIDTHxHEIGHT between 200 and 3840 pixels", raw)
+	}
+	return screenshotViewport{Name: fmt.Sprintf("%dx%d", width, height), Width: width, Height: height}, nil
+}
+
+// siteVersion returns the newest modification time of the files of a workspace, so
+// a cached screenshot is rendered again when the page or any stylesheet, script or
+// image changes
+func siteVersion(workspaceDir string) int64 {
+	var newest int64
+	filepath.WalkDir(workspaceDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if file != workspaceDir && (strings.HasPrefix(entry.Name(), ".") || snapshotSkipDirs[entry.Name()]) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().UnixNano() > newest {
+			newest = info.ModTime().UnixNano()
+		}
+		return nil
+	})
+	return newest
+}
+
+// serveSiteDir serves the files of a workspace on a loopback port for the browser, so
+// root-relative links of the page resolve. Hidden files are not served.
+func serveSiteDir(workspaceDir string) (string, func(), error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	files := http.FileServer(http.Dir(workspaceDir))
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, part := range strings.Split(r.URL.Path, "/") {
+				if strings.HasPrefix(part, ".") {
+					http.NotFound(w, r)
+					return
+				}
+			}
+			files.ServeHTTP(w, r)
+		}),
+	}
+	go server.Serve(listener)
+	return "http://" + listener.Addr().String(), func() { server.Close() }, nil
+}
+
+// capturePage renders a page file of a workspace with headless Chromium and returns the PNG
+func capturePage(ctx context.Context, workspaceDir, rel string, viewport screenshotViewport) ([]byte, error) {
+	browser := getScreenshotBrowser()
+	if browser == "" {
+		return nil, errNoScreenshotBrowser
+	}
+
+	select {
+	case screenshotSlots <- struct{}{}:
+		defer func() { <-screenshotSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	baseURL, stop, err := serveSiteDir(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	tmpDir, err := os.MkdirTemp("", "screenshot-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	out := filepath.Join(tmpDir, "page.png")
+
+	args := []string{
+		"--headless", "--disable-gpu", "--disable-dev-shm-usage", "--hide-scrollbars", "--mute-audio",
+		"--no-first-run", "--no-default-browser-check", "--user-data-dir=" + filepath.Join(tmpDir, "profile"),
+		fmt.Sprintf("--window-size=%d,%d", viewport.Width, viewport.Height),
+		"--virtual-time-budget=5000", "--screenshot=" + out,
+		baseURL + (&url.URL{Path: "/" + filepath.ToSlash(rel)}).EscapedPath(),
+	}
+	if os.Geteuid() == 0 {
+		// Chromium refuses to start its sandbox as root, as in most containers
+		args = append([]string{"--no-sandbox"}, args...)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, getEnvDuration("SCREENSHOT_TIMEOUT", 30*time.Second))
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, browser, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", filepath.Base(browser), err, lastLines(string(output), 5))
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, fmt.Errorf("%s wrote no screenshot", filepath.Base(browser))
+	}
+	return data, nil
+}
+
+// lastLines returns the last n non-empty lines of s
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// resizeScreenshot scales a PNG down to width pixels, keeping its aspect ratio
+func resizeScreenshot(data []byte, width int) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	if width <= 0 || width >= bounds.Dx() {
+		return data, nil
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pageScreenshot returns the screenshot of a page file from the cache, rendering it when
+// the workspace changed since or refresh is set. The name of the cached file changes with
+// the workspace, which makes it the ETag of the image.
+func pageScreenshot(ctx context.Context, workspaceDir, rel string, viewport screenshotViewport, width int, refresh bool) ([]byte, string, bool, error) {
+	abs, _ := filepath.Abs(workspaceDir)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%d", abs, rel, viewport.Name, width)))
+	prefix := hex.EncodeToString(sum[:8])
+	name := fmt.Sprintf("%s-%x.png", prefix, siteVersion(workspaceDir))
+	file := filepath.Join(getScreenshotDir(), name)
+
+	if !refresh {
+		if data, err := os.ReadFile(file); err == nil {
+			return data, name, true, nil
+		}
+	}
+
+	data, err := capturePage(ctx, workspaceDir, rel, viewport)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if data, err = resizeScreenshot(data, width); err != nil {
+		return nil, "", false, err
+	}
+
+	if err := os.MkdirAll(getScreenshotDir(), 0755); err != nil {
+		return nil, "", false, err
+	}
+	stale, _ := filepath.Glob(filepath.Join(getScreenshotDir(), prefix+"-*.png"))
+	for _, old := range stale {
+		os.Remove(old)
+	}
+	if err := writeFileAtomic(file, data); err != nil {
+		log.Printf("⚠️ Failed to cache the screenshot of %s: %v", rel, err)
+	}
+	log.Printf("📸 Rendered %s at %s (%d bytes)", rel, viewport.Name, len(data))
+	return data, name, false, nil
+}
+
+// GetPageScreenshot returns a PNG of a page rendered with headless Chromium
+// (?viewport=desktop|tablet|mobile|WIDTHxHEIGHT, ?width= to scale it down for
+// thumbnails, ?refresh=true to render it again). Screenshots are cached until a file
+// of the workspace changes.
+func GetPageScreenshot(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page := c.Params("page")
+		var count int64
+		if err := db.Model(&Page{}).Where("name = ?", page).Count(&count).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load pages", nil)
+		}
+		if count == 0 {
+			return sendError(c, 404, "PAGE_NOT_FOUND", "Page not found", page)
+		}
+
+		viewport, err := parseViewport(c.Query("viewport"))
+		if err != nil {
+			return err
+		}
+		width := c.QueryInt("width")
+		if width < 0 || width > viewport.Width {
+			return sendError(c, 400, "INVALID_WIDTH", fmt.Sprintf("width must be between 1 and the viewport width (%d)", viewport.Width), c.Query("width"))
+		}
+		rel, err := lookupPageFile(db, page)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load page metadata", nil)
+		}
+		workspaceDir := getWorkspaceDir()
+		file, err := resolvePathInDir(workspaceDir, rel)
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+		if _, err := os.Stat(file); err != nil {
+			return sendError(c, 404, "PAGE_FILE_NOT_FOUND", "The page has no HTML file in the workspace", rel)
+		}
+
+		data, name, cached, err := pageScreenshot(c.Context(), workspaceDir, rel, viewport, width, c.QueryBool("refresh"))
+		if errors.Is(err, errNoScreenshotBrowser) {
+			return sendError(c, 503, "SCREENSHOT_UNAVAILABLE", "Screenshots need headless Chromium", err.Error())
+		}
+		if err != nil {
+			log.Printf("❌ Screenshot of %s failed: %v", rel, err)
+			return sendError(c, 502, "SCREENSHOT_FAILED", "Failed to render the page", err.Error())
+		}
+
+		etag := `"` + strings.TrimSuffix(name, ".png") + `"`
+		c.Set(fiber.HeaderETag, etag)
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		if cached {
+			c.Set("X-Screenshot-Cache", "hit")
+		} else {
+			c.Set("X-Screenshot-Cache", "miss")
+		}
+		if notModified(c, etag, 0) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		c.Set(fiber.HeaderContentType, "image/png")
+		return c.Send(data)
+	}
+}
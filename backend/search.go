@@ -0,0 +1,295 @@
+package main
+
+import (
+	"html"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Content search backends, chosen once at startup
+const (
+	SearchModeFTS5     = "fts5"     // SQLite FTS5 index kept in sync by triggers
+	SearchModeTSVector = "tsvector" // Postgres GIN index over to_tsvector
+	SearchModeLike     = "like"     // Substring match, used when neither is available
+)
+
+var contentSearchMode = SearchModeLike
+
+// maxSearchResults caps one page of GET /api/content/search
+const maxSearchResults = 100
+
+// snippetRadius is how many characters of context surround the first match
+const snippetRadius = 80
+
+// contentSearchVector must match the expression of the Postgres index exactly,
+// otherwise the planner will not use it
+const contentSearchVector = "to_tsvector('simple', coalesce(contents.original_content, '') || ' ' || coalesce(contents.edited_content, ''))"
+
+// setupContentSearch prepares the full-text index for the configured driver.
+// SQLite needs a driver built with FTS5 (go build -tags sqlite_fts5); without it,
+// and on MySQL, search falls back to substring matching.
+func setupContentSearch(db *gorm.DB, driver string) {
+	switch driver {
+	case "sqlite", "sqlite3":
+		if err := setupFTS5(db); err != nil {
+			log.Printf("⚠️ Full-text search unavailable, falling back to substring search (build with -tags sqlite_fts5): %v", err)
+			return
+		}
+		contentSearchMode = SearchModeFTS5
+	case "postgres", "postgresql":
+		err := db.Exec("CREATE INDEX IF NOT EXISTS idx_contents_search ON contents USING GIN (" + contentSearchVector + ")").Error
+		if err != nil {
+			log.Printf("⚠️ Failed to create the content search index, falling back to substring search: %v", err)
+			return
+		}
+		contentSearchMode = SearchModeTSVector
+	}
+	log.Printf("🔎 Content search: %s", contentSearchMode)
+}
+
+// setupFTS5 creates an external-content FTS5 table over contents. Triggers keep
+// it in sync with every write; it is rebuilt on startup in case rowids changed.
+func setupFTS5(db *gorm.DB) error {
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS content_fts USING fts5(original_content, edited_content, content='contents', content_rowid='rowid')`,
+		`CREATE TRIGGER IF NOT EXISTS contents_fts_insert AFTER INSERT ON contents BEGIN
+			INSERT INTO content_fts(rowid, original_content, edited_content) VALUES (new.rowid, new.original_content, new.edited_content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS contents_fts_delete AFTER DELETE ON contents BEGIN
+			INSERT INTO content_fts(content_fts, rowid, original_content, edited_content) VALUES ('delete', old.rowid, old.original_content, old.edited_content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS contents_fts_update AFTER UPDATE OF original_content, edited_content ON contents BEGIN
+			INSERT INTO content_fts(content_fts, rowid, original_content, edited_content) VALUES ('delete', old.rowid, old.original_content, old.edited_content);
+			INSERT INTO content_fts(rowid, original_content, edited_content) VALUES (new.rowid, new.original_content, new.edited_content);
+		END`,
+		`INSERT INTO content_fts(content_fts) VALUES ('rebuild')`,
+	}
+	// A missing fts5 module is expected without the build tag; it is reported by the caller
+	quiet := db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	return quiet.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// searchTerms splits a query into lowercase words. Only letters and digits are
+// kept, so the terms are safe to embed in FTS5 and tsquery syntax.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchQuery builds the query for the active search mode. Every term must match;
+// terms match as prefixes so results appear while the editor is still typing.
+func searchQuery(db *gorm.DB, terms []string) *gorm.DB {
+	switch contentSearchMode {
+	case SearchModeFTS5:
+		match := make([]string, len(terms))
+		for i, term := range terms {
+			match[i] = `"` + term + `"*`
+		}
+		return db.Table("content_fts").
+			Select("contents.*, -bm25(content_fts) AS score").
+			Joins("JOIN contents ON contents.rowid = content_fts.rowid").
+			Where("content_fts MATCH ?", strings.Join(match, " ")).
+			Order("score DESC")
+	case SearchModeTSVector:
+		match := make([]string, len(terms))
+		for i, term := range terms {
+			match[i] = term + ":*"
+		}
+		tsquery := strings.Join(match, " & ")
+		return db.Table("contents").
+			Select("contents.*, ts_rank("+contentSearchVector+", to_tsquery('simple', ?)) AS score", tsquery).
+			Where(contentSearchVector+" @@ to_tsquery('simple', ?)", tsquery).
+			Order("score DESC")
+	default:
+		query := db.Table("contents").Select("contents.*, 0 AS score")
+		for _, term := range terms {
+			pattern := "%" + term + "%"
+			query = query.Where("(LOWER(contents.original_content) LIKE ? OR LOWER(contents.edited_content) LIKE ?)", pattern, pattern)
+		}
+		return query.Order("contents.updated_at DESC")
+	}
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// plainText reduces stored HTML to the text a visitor reads
+func plainText(content string) string {
+	text := html.UnescapeString(htmlTagPattern.ReplaceAllString(content, " "))
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// searchSnippet cuts the text around the first word starting with one of terms and
+// wraps every such word in <mark>. The text is HTML-escaped, so the snippet can be
+// rendered as it is. ok is false when no word of text matches.
+func searchSnippet(content string, terms []string) (snippet string, ok bool) {
+	text := []rune(plainText(content))
+
+	type span struct{ start, end int }
+	var matches []span
+	for start := 0; start < len(text); {
+		if !unicode.IsLetter(text[start]) && !unicode.IsDigit(text[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(text) && (unicode.IsLetter(text[end]) || unicode.IsDigit(text[end])) {
+			end++
+		}
+		word := strings.ToLower(string(text[start:end]))
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				matches = append(matches, span{start, end})
+				break
+			}
+		}
+		start = end
+	}
+
+	from, to := 0, len(text)
+	if len(matches) > 0 {
+		from = matches[0].start - snippetRadius
+		to = matches[0].end + snippetRadius
+	} else {
+		to = 2 * snippetRadius
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to > len(text) {
+		to = len(text)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, m := range matches {
+		if m.start < from || m.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(text[pos:m.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(text[m.start:m.end])))
+		b.WriteString("</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(text[pos:to])))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String(), len(matches) > 0
+}
+
+type contentSearchRow struct {
+	Content
+	Score float64
+}
+
+// SearchResult is a content block matching a search
+type SearchResult struct {
+	ID        string  `json:"id"`
+	Locale    string  `json:"locale"`
+	Page      string  `json:"page"`
+	IsEdited  bool    `json:"is_edited"`
+	Field     string  `json:"field"`   // original or edited: the content the snippet is from
+	Snippet   string  `json:"snippet"` // Text around the match, with the matched words in <mark>
+	Score     float64 `json:"score"`
+	UpdatedAt int64   `json:"updated_at"`
+}
+
+// SearchResponse is the response of GET /api/content/search
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Mode    string         `json:"mode"` // fts5, tsvector or like
+	Results []SearchResult `json:"results"`
+}
+
+// SearchContent finds content blocks containing every word of ?q= in their original
+// or edited content, best match first. ?page=, ?locale= and ?edited=true|false narrow
+// the search, ?limit= caps the results (default 20).
+func SearchContent(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q := c.Query("q")
+		terms := searchTerms(q)
+		if len(terms) == 0 {
+			return sendError(c, 400, "MISSING_QUERY", "q must contain at least one word", nil)
+		}
+
+		query := searchQuery(db, terms).Where("contents.deleted_at IS NULL")
+		if page := c.Query("page"); page != "" {
+			query = query.Where("contents.page = ?", page)
+		}
+		if value := c.Query("locale"); value != "" {
+			locale, err := normalizeLocale(value)
+			if err != nil {
+				return invalidLocale(c, err)
+			}
+			query = query.Where("contents.locale = ?", locale)
+		}
+		if value := c.Query("edited"); value != "" {
+			edited, err := strconv.ParseBool(value)
+			if err != nil {
+				return sendError(c, 400, "INVALID_FILTER", "edited must be true or false", nil)
+			}
+			query = query.Where("contents.is_edited = ?", edited)
+		}
+
+		limit := c.QueryInt("limit", 20)
+		if limit < 1 || limit > maxSearchResults {
+			limit = maxSearchResults
+		}
+
+		var rows []contentSearchRow
+		if err := query.Limit(limit).Scan(&rows).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to search content", nil)
+		}
+
+		results := make([]SearchResult, 0, len(rows))
+		for _, row := range rows {
+			// Prefer the text the editor sees; the index may also have matched markup
+			// or the original content behind an edit
+			field, snippet, ok := "edited", "", false
+			if row.IsEdited {
+				snippet, ok = searchSnippet(row.EditedContent, terms)
+			}
+			if !ok {
+				if original, found := searchSnippet(row.OriginalContent, terms); found || !row.IsEdited {
+					field, snippet = "original", original
+				}
+			}
+
+			results = append(results, SearchResult{
+				ID:        row.ID,
+				Locale:    row.Locale,
+				Page:      row.Page,
+				IsEdited:  row.IsEdited,
+				Field:     field,
+				Snippet:   snippet,
+				Score:     row.Score,
+				UpdatedAt: row.UpdatedAt,
+			})
+		}
+
+		return c.JSON(SearchResponse{
+			Query:   q,
+			Mode:    contentSearchMode,
+			Results: results,
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// secretPrefix marks values encrypted by encryptSecret (versioned for key rotation)
+const secretPrefix = "enc:v1:"
+
+var errSecretsKeyMissing = errors.New("SECRETS_KEY is not set")
+
+// readSecretsKeyFile reads SECRETS_KEY_FILE once, e.g. a key a KMS agent or the
+// orchestrator mounts as a file
+var readSecretsKeyFile = sync.OnceValues(func() (string, error) {
+	data, err := os.ReadFile(os.Getenv("SECRETS_KEY_FILE"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read SECRETS_KEY_FILE: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+})
+
+// getSecretsKey derives the AES-256 key from SECRETS_KEY, or from the file named by
+// SECRETS_KEY_FILE. A base64-encoded 32-byte key is used as is; any other value is hashed.
+func getSecretsKey() ([]byte, error) {
+	raw := os.Getenv("SECRETS_KEY")
+	if raw == "" && os.Getenv("SECRETS_KEY_FILE") != "" {
+		var err error
+		if raw, err = readSecretsKeyFile(); err != nil {
+			return nil, err
+		}
+	}
+	if raw == "" {
+		return nil, errSecretsKeyMissing
+	}
+	if key, err := base64.StdEncoding.DecodeString(raw); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:], nil
+}
+
+func secretsCipher() (cipher.AEAD, error) {
+	key, err := getSecretsKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret encrypts a value with AES-GCM for storage in the database
+func encryptSecret(plain string) (string, error) {
+	aead, err := secretsCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret reverses encryptSecret
+func decryptSecret(stored string) (string, error) {
+	if !strings.HasPrefix(stored, secretPrefix) {
+		return "", fmt.Errorf("value is not encrypted")
+	}
+	aead, err := secretsCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, secretPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value (wrong SECRETS_KEY?)")
+	}
+	return string(plain), nil
+}
+
+// ProjectEnvVar is an environment variable injected into a project's AI processes.
+// Values are always encrypted at rest; secret values are never returned by the API.
+type ProjectEnvVar struct {
+	ID        uint   `gorm:"primaryKey" json:"-"`
+	ProjectID string `gorm:"uniqueIndex:idx_project_env_key" json:"projectId"`
+	Key       string `gorm:"uniqueIndex:idx_project_env_key" json:"key"`
+	Value     string `gorm:"type:text" json:"-"` // encryptSecret output
+	Secret    bool   `json:"secret"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// envKeyPattern accepts POSIX environment variable names
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// sensitiveEnvPattern matches variable names whose values are redacted in logs
+var sensitiveEnvPattern = regexp.MustCompile(`(?i)(KEY|SECRET|TOKEN|PASSWORD|PASSWD|CREDENTIAL|DSN|DATABASE_URL|REDIS_URL)`)
+
+// defaultEnvPassthrough is the part of the backend environment children inherit
+const defaultEnvPassthrough = "PATH,HOME,USER,LOGNAME,SHELL,LANG,LC_*,TZ,TMPDIR,TERM,NODE_*,NPM_*,ANTHROPIC_*,CLAUDE_*"
+
+// envPassthroughPatterns returns childEnvPassthrough (CHILD_ENV_PASSTHROUGH) as a list
+// of names and prefix patterns ending in *; "*" passes the whole backend environment
+func envPassthroughPatterns() []string {
+	return appConfig.ChildEnvPassthrough
+}
+
+func envPassesThrough(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ChildEnv is the environment of an AI CLI or agent process
+type ChildEnv struct {
+	Vars    []string        // KEY=value pairs for exec.Cmd.Env
+	Secrets map[string]bool // Keys whose values must never be logged
+}
+
+// buildChildEnv starts from the allow-listed backend environment and overlays
+// the decrypted variables of the project
+func buildChildEnv(db *gorm.DB, projectID string) (ChildEnv, error) {
+	env := make(map[string]string)
+	patterns := envPassthroughPatterns()
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if envPassesThrough(key, patterns) {
+			env[key] = value
+		}
+	}
+
+	secrets := make(map[string]bool)
+	if projectID != "" {
+		var vars []ProjectEnvVar
+		if err := db.Where("project_id = ?", projectID).Find(&vars).Error; err != nil {
+			return ChildEnv{}, err
+		}
+		for _, v := range vars {
+			value, err := decryptSecret(v.Value)
+			if err != nil {
+				return ChildEnv{}, fmt.Errorf("project variable %s: %w", v.Key, err)
+			}
+			env[v.Key] = value
+			if v.Secret {
+				secrets[v.Key] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	vars := make([]string, 0, len(keys))
+	for _, key := range keys {
+		vars = append(vars, key+"="+env[key])
+	}
+	return ChildEnv{Vars: vars, Secrets: secrets}, nil
+}
+
+// Lookup returns the value of a variable, or "" when it is not set
+func (e ChildEnv) Lookup(key string) string {
+	for _, kv := range e.Vars {
+		if k, v, _ := strings.Cut(kv, "="); k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// Redacted returns the environment with secret and sensitive-looking values masked
+func (e ChildEnv) Redacted() []string {
+	out := make([]string, 0, len(e.Vars))
+	for _, kv := range e.Vars {
+		key, _, _ := strings.Cut(kv, "=")
+		if e.Secrets[key] || sensitiveEnvPattern.MatchString(key) {
+			out = append(out, key+"=<redacted>")
+		} else {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// projectEnvResponse describes a variable, revealing the value only for non-secrets
+func projectEnvResponse(v ProjectEnvVar) fiber.Map {
+	item := fiber.Map{
+		"key":       v.Key,
+		"secret":    v.Secret,
+		"updatedAt": v.UpdatedAt,
+	}
+	if !v.Secret {
+		if value, err := decryptSecret(v.Value); err == nil {
+			item["value"] = value
+		} else {
+			item["error"] = err.Error()
+		}
+	}
+	return item
+}
+
+// ListProjectEnv returns the variables of a project (secret values are masked)
+func ListProjectEnv(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("id")
+		if err := db.First(&Project{}, "id = ?", projectID).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+
+		var vars []ProjectEnvVar
+		if err := db.Where("project_id = ?", projectID).Order("key").Find(&vars).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load variables", err.Error())
+		}
+
+		items := make([]fiber.Map, 0, len(vars))
+		for _, v := range vars {
+			items = append(items, projectEnvResponse(v))
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"variables": items,
+			},
+		})
+	}
+}
+
+// ProjectEnvRequest is the body of PUT /api/projects/:id/env/:key
+type ProjectEnvRequest struct {
+	Value  string `json:"value"`
+	Secret bool   `json:"secret"` // Never returned once saved
+}
+
+// PutProjectEnv creates or replaces a project variable
+func PutProjectEnv(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("id")
+		key := c.Params("key")
+		if !envKeyPattern.MatchString(key) {
+			return sendError(c, 400, "INVALID_KEY", "Invalid variable name", "Names may contain letters, digits and _ and must not start with a digit")
+		}
+		if err := db.First(&Project{}, "id = ?", projectID).Error; err != nil {
+			return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", "")
+		}
+
+		var req ProjectEnvRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+
+		encrypted, err := encryptSecret(req.Value)
+		if err != nil {
+			return sendError(c, 500, "ENCRYPTION_ERROR", "Failed to encrypt value", err.Error())
+		}
+
+		var v ProjectEnvVar
+		db.Where("project_id = ? AND key = ?", projectID, key).Limit(1).Find(&v)
+		v.ProjectID = projectID
+		v.Key = key
+		v.Value = encrypted
+		v.Secret = req.Secret
+		v.UpdatedAt = time.Now().Unix()
+		if err := db.Save(&v).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to save variable", err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    projectEnvResponse(v),
+		})
+	}
+}
+
+// DeleteProjectEnv removes a project variable
+func DeleteProjectEnv(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result := db.Where("project_id = ? AND key = ?", c.Params("id"), c.Params("key")).Delete(&ProjectEnvVar{})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete variable", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "VARIABLE_NOT_FOUND", "Variable not found", "")
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"key":     c.Params("key"),
+				"deleted": true,
+			},
+		})
+	}
+}
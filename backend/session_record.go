@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of session records
+const (
+	SessionKindCommand = "command" // An AI command
+	SessionKindAgent   = "agent"   // A POST /api/agent/run session
+	SessionKindBuild   = "build"
+	SessionKindDeploy  = "deploy"
+	SessionKindPreview = "preview"
+)
+
+// Statuses of session records besides the outcome a finished session ends with
+const (
+	SessionRunning  = "running"
+	SessionAdopted  = "adopted"  // Process outlived a restart and is watched by the new backend
+	SessionOrphaned = "orphaned" // Backend restarted while the session ran; the process is gone or was stopped
+)
+
+// SessionRecord persists the metadata of a command or agent session. The sessions
+// themselves only live in memory, so after a restart the records tell which processes
+// were left behind and what became of the sessions that were running.
+type SessionRecord struct {
+	ID        string `gorm:"primaryKey" json:"id"` // Agent session ID, or the command ID
+	Kind      string `gorm:"index" json:"kind"`
+	UserID    string `json:"userId,omitempty"`
+	Instance  string `json:"instance"`                        // Backend that runs the session (INSTANCE_ID)
+	Command   string `json:"command,omitempty"`               // Program of agent sessions
+	PID       int    `gorm:"column:pid" json:"pid,omitempty"` // Leader of the process group; 0 until started and for in-process tasks
+	Status    string `gorm:"index" json:"status"`
+	StartedAt int64  `json:"startedAt"`
+	Deadline  int64  `json:"deadline,omitempty"` // When the session times out; 0 for none
+	EndedAt   int64  `json:"endedAt,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// saveSessionRecord stores a session that has just started
+func saveSessionRecord(db *gorm.DB, record *SessionRecord) {
+	record.Status = SessionRunning
+	record.Instance = getInstanceID()
+	if record.StartedAt == 0 {
+		record.StartedAt = time.Now().Unix()
+	}
+	if err := db.Save(record).Error; err != nil {
+		log.Printf("⚠️ Failed to record %s session %s: %v", record.Kind, record.ID, err)
+	}
+}
+
+// recordSessionPID stores the process group of a session once its process has started
+func recordSessionPID(db *gorm.DB, id string, pid int) {
+	if err := db.Model(&SessionRecord{}).Where("id = ?", id).Update("pid", pid).Error; err != nil {
+		log.Printf("⚠️ Failed to record the process of session %s: %v", id, err)
+	}
+}
+
+// finishSessionRecord stores the outcome of a session that has ended
+func finishSessionRecord(db *gorm.DB, id, status string) {
+	err := db.Model(&SessionRecord{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":   status,
+		"ended_at": time.Now().Unix(),
+	}).Error
+	if err != nil {
+		log.Printf("⚠️ Failed to record the outcome of session %s: %v", id, err)
+	}
+}
+
+// processGroupAlive reports whether any process of the group pgid is still running
+func processGroupAlive(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// RecoverSessions settles the sessions a previous backend process left running. It runs
+// at startup, before anything can have started here, so every running record of this
+// instance is a leftover:
+//   - agent sessions whose process group still runs are re-adopted: they can be
+//     followed, interrupted and time out again, but their earlier output is lost
+//   - commands, builds, deployments and previews cannot report back once their backend
+//     is gone, so a process that still runs is stopped and the record is marked orphaned
+//
+// Commands are failed with a message saying so; builds and deployments are failed by
+// failAbandonedBuilds and failAbandonedDeployments. In Redis mode, sessions that another
+// live instance runs are left alone.
+func RecoverSessions(db *gorm.DB) {
+	var records []SessionRecord
+	if err := db.Where("status IN ?", []string{SessionRunning, SessionAdopted}).Find(&records).Error; err != nil {
+		log.Printf("⚠️ Failed to load the sessions of the previous run: %v", err)
+		return
+	}
+
+	adopted, orphaned := 0, 0
+	for _, record := range records {
+		if runsElsewhere(record.Kind, record.ID) {
+			continue
+		}
+		// Process IDs only mean something on the host that started them
+		own := record.Instance == "" || record.Instance == getInstanceID()
+		alive := own && record.PID > 0 && processGroupAlive(record.PID)
+		if alive && record.Kind == SessionKindAgent {
+			db.Model(&SessionRecord{}).Where("id = ?", record.ID).Update("status", SessionAdopted)
+			adoptAgentSession(db, record)
+			adopted++
+			continue
+		}
+
+		reason := "backend restarted while the session was running"
+		if alive {
+			stopProcessGroup(record.PID, getProcessKillGrace())
+			reason = fmt.Sprintf("backend restarted while the session was running; its process group %d was stopped", record.PID)
+		} else if !own {
+			reason = fmt.Sprintf("instance %s stopped while the session was running", record.Instance)
+		}
+		orphanSession(db, record, reason)
+		orphaned++
+	}
+
+	if adopted+orphaned > 0 {
+		log.Printf("🧹 Sessions of the previous run: re-adopted %d agent session(s), marked %d orphaned", adopted, orphaned)
+	}
+}
+
+// orphanSession marks a session whose backend is gone as orphaned, and fails it if it
+// is a command
+func orphanSession(db *gorm.DB, record SessionRecord, reason string) {
+	db.Model(&SessionRecord{}).Where("id = ?", record.ID).Updates(map[string]interface{}{
+		"status":   SessionOrphaned,
+		"error":    reason,
+		"ended_at": time.Now().Unix(),
+	})
+	if record.Kind == SessionKindCommand {
+		db.Model(&AICommand{}).Where("id = ? AND status = ?", record.ID, "processing").Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": "command was orphaned: " + reason,
+			"completed_at":  time.Now().Unix(),
+		})
+	}
+}
+
+// orphanLostSessions marks the sessions of instances that stopped without settling them
+// as orphaned (Redis mode). A session is lost once no instance refreshes its entry in
+// the registry; its process, if any, is on another host and is left alone.
+func orphanLostSessions(db *gorm.DB) int {
+	if cluster == nil {
+		return 0
+	}
+	var records []SessionRecord
+	err := db.Where("status IN ? AND instance <> ? AND started_at < ?", []string{SessionRunning, SessionAdopted},
+		cluster.instance, time.Now().Add(-clusterSessionTTL).Unix()).Find(&records).Error
+	if err != nil {
+		log.Printf("⚠️ Cleanup failed to query running sessions: %v", err)
+		return 0
+	}
+
+	lost := 0
+	for _, record := range records {
+		if cluster.owner(record.Kind, record.ID) != "" {
+			continue
+		}
+		orphanSession(db, record, fmt.Sprintf("instance %s stopped while the session was running", record.Instance))
+		lost++
+	}
+	return lost
+}
+
+// adoptAgentSession registers an agent session for a process group that outlived a
+// restart. Its output went to the previous backend, so the session only reports when
+// the group exits, and stops it when interrupted or timed out.
+func adoptAgentSession(db *gorm.DB, record SessionRecord) {
+	session := &AgentSession{
+		ID:         record.ID,
+		Kind:       record.Kind,
+		UserID:     record.UserID,
+		Command:    record.Command,
+		db:         db,
+		adoptedPID: record.PID,
+		run:        watchAdoptedProcess,
+	}
+	if record.Deadline > 0 {
+		session.Timeout = max(time.Until(time.Unix(record.Deadline, 0)), time.Second)
+	}
+	launchAgent(session)
+	log.Printf("♻️ Re-adopted agent session %s (process group %d)", record.ID, record.PID)
+}
+
+// watchAdoptedProcess waits for the process group of an adopted session to exit
+func watchAdoptedProcess(session *AgentSession) error {
+	pgid := session.adoptedPID
+	session.emit(fmt.Sprintf("[ADOPTED] Process group %d outlived a backend restart; its earlier output is not available", pgid))
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for processGroupAlive(pgid) {
+		select {
+		case <-session.Context.Done():
+			stopProcessGroup(pgid, getProcessKillGrace())
+			return session.Context.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// pruneSessionRecords deletes the records of sessions that ended more than retention ago
+func pruneSessionRecords(db *gorm.DB, retention time.Duration) int {
+	result := db.Where("ended_at > 0 AND ended_at < ?", time.Now().Add(-retention).Unix()).Delete(&SessionRecord{})
+	if result.Error != nil {
+		log.Printf("⚠️ Cleanup failed to prune session records: %v", result.Error)
+		return 0
+	}
+	return int(result.RowsAffected)
+}
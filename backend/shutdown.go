@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// shuttingDown is set once a termination signal has been received
+	shuttingDown atomic.Bool
+
+	// activeRuns tracks running Claude CLI and agent processes
+	activeRuns sync.WaitGroup
+)
+
+// getShutdownTimeout returns how long to wait for running sessions (timeouts.shutdown, SHUTDOWN_TIMEOUT)
+// Falls back to 30 seconds
+func getShutdownTimeout() time.Duration {
+	return appConfig.Timeouts.Shutdown.Duration()
+}
+
+// RejectWhenShuttingDown refuses to start new work once shutdown has begun
+func RejectWhenShuttingDown() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if shuttingDown.Load() {
+			return sendError(c, 503, "SHUTTING_DOWN", "Server is shutting down, not accepting new commands", nil)
+		}
+		return c.Next()
+	}
+}
+
+// waitForRuns waits until all running sessions finish or the timeout elapses
+func waitForRuns(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		activeRuns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// interruptAllSessions cancels every running AI command and agent session
+func interruptAllSessions() int {
+	count := 0
+
+	commandMu.RLock()
+	for _, session := range commandSessions {
+		session.Cancel()
+		count++
+	}
+	commandMu.RUnlock()
+
+	sessMu.RLock()
+	for _, session := range sessions {
+		session.mu.Lock()
+		if session.isRunning {
+			session.Cancel()
+			count++
+		}
+		session.mu.Unlock()
+	}
+	sessMu.RUnlock()
+
+	return count
+}
+
+// HandleShutdown drains running sessions and stops the server on SIGINT/SIGTERM
+func HandleShutdown(app *fiber.App) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-quit
+	shuttingDown.Store(true)
+
+	timeout := getShutdownTimeout()
+	log.Printf("🛑 Received %s, draining running sessions (timeout %s)", sig, timeout)
+
+	// Workers stop taking commands from the job queue; other workers pick them up
+	if commandQueue != nil {
+		commandQueue.stop()
+	}
+
+	// Preview servers never finish on their own
+	if count := stopAllPreviews(); count > 0 {
+		log.Printf("🛑 Stopped %d preview server(s)", count)
+	}
+
+	if !waitForRuns(timeout) {
+		// Deadline reached: interrupt what is left so it gets persisted as interrupted
+		count := interruptAllSessions()
+		log.Printf("⚠️ Shutdown deadline reached, interrupted %d session(s)", count)
+		if !waitForRuns(5 * time.Second) {
+			log.Printf("⚠️ Some sessions did not stop in time")
+		}
+	}
+
+	if commandQueue != nil {
+		commandQueue.shutdown()
+	}
+
+	if count := closeAllSubscribers(); count > 0 {
+		log.Printf("🛑 Disconnected %d content subscriber(s)", count)
+	}
+	if count := closeAllBatchStreams(); count > 0 {
+		log.Printf("🛑 Disconnected %d batch stream(s)", count)
+	}
+
+	log.Printf("🛑 Stopping HTTP server")
+	if err := app.ShutdownWithTimeout(5 * time.Second); err != nil {
+		log.Printf("❌ Error during server shutdown: %v", err)
+	}
+}
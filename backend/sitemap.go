@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxSitemapURLs is the limit of the sitemap protocol for one file
+const maxSitemapURLs = 50000
+
+// sitemapMarker marks the files written by the generator; files without it were
+// written by hand and are only replaced when forced
+const sitemapMarker = "Generated by the site editor"
+
+// sitemapHeadSize is how much of a page is read to find its robots meta tag
+const sitemapHeadSize = 64 * 1024
+
+// metaTagPattern finds the meta tags of a page
+var metaTagPattern = regexp.MustCompile(`(?is)<meta\b[^>]*>`)
+
+// SitemapRequest is the body of POST /api/seo/sitemap/generate
+type SitemapRequest struct {
+	ProjectID string   `json:"projectId,omitempty" validate:"omitempty,projectid"`
+	BaseURL   string   `json:"baseUrl,omitempty" validate:"omitempty,url,max=2048"` // Defaults to the project's siteUrl setting, then SITE_URL
+	Exclude   []string `json:"exclude,omitempty" validate:"max=100,dive,max=512"`   // Patterns of page paths to leave out, e.g. drafts/*
+	Disallow  []string `json:"disallow,omitempty" validate:"max=100,dive,max=512"`  // Paths robots.txt asks crawlers to skip
+	Force     bool     `json:"force,omitempty"`                                     // Replace hand-written sitemap.xml and robots.txt
+}
+
+// SitemapURL is one page in the sitemap
+type SitemapURL struct {
+	Loc     string `json:"loc" xml:"loc"`
+	LastMod string `json:"lastmod" xml:"lastmod"`
+	Path    string `json:"path" xml:"-"` // Page file relative to the workspace
+}
+
+// SitemapPreview is the data of GET /api/seo/sitemap
+type SitemapPreview struct {
+	BaseURL string       `json:"baseUrl"`
+	URLs    []SitemapURL `json:"urls"`
+	Sitemap string       `json:"sitemap"` // sitemap.xml as it would be written
+	Robots  string       `json:"robots"`  // robots.txt as it would be written
+}
+
+// SitemapGenerated is the data of POST /api/seo/sitemap/generate
+type SitemapGenerated struct {
+	SitemapPreview
+	Written []string `json:"written"`
+	Skipped []string `json:"skipped,omitempty"` // Hand-written files left alone; pass force to replace them
+}
+
+// sitemapURLSet is the XML document of a sitemap
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+// getSiteURL returns the public address of the site from the project's "siteUrl"
+// setting, then SITE_URL
+func getSiteURL(project *Project) string {
+	if project != nil {
+		if siteURL, ok := project.Settings["siteUrl"].(string); ok && strings.TrimSpace(siteURL) != "" {
+			return strings.TrimSpace(siteURL)
+		}
+	}
+	return os.Getenv("SITE_URL")
+}
+
+// checkSiteURL normalizes the base URL of the sitemap, which must be an absolute
+// http(s) address without query
+func checkSiteURL(raw string) (string, error) {
+	if raw == "" {
+		return "", newAPIError(400, "MISSING_SITE_URL", "The public address of the site is unknown", "Pass baseUrl, or set the project's siteUrl setting or SITE_URL")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", newAPIError(400, "INVALID_SITE_URL", "The site address must be an http or https URL without query", raw)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// pageIsNoindex reports whether a page asks not to be indexed in its robots meta tag
+func pageIsNoindex(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head, _ := io.ReadAll(io.LimitReader(f, sitemapHeadSize))
+	for _, tag := range metaTagPattern.FindAll(head, -1) {
+		lower := strings.ToLower(string(tag))
+		if (strings.Contains(lower, `name="robots"`) || strings.Contains(lower, `name='robots'`)) && strings.Contains(lower, "noindex") {
+			return true
+		}
+	}
+	return false
+}
+
+// pageLoc returns the address of a page file: index pages stand for their directory
+func pageLoc(baseURL, rel string) string {
+	if path.Base(rel) == "index.html" || path.Base(rel) == "index.htm" {
+		rel = strings.TrimSuffix(rel, path.Base(rel))
+	}
+	return baseURL + (&url.URL{Path: "/" + rel}).EscapedPath()
+}
+
+// walkSitePages calls fn with the slash-separated relative path of every HTML file in
+// the workspace, leaving out hidden directories and those of snapshotSkipDirs
+func walkSitePages(workspaceDir string, fn func(file, rel string, entry fs.DirEntry) error) error {
+	return filepath.WalkDir(workspaceDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if file != workspaceDir && (strings.HasPrefix(entry.Name(), ".") || snapshotSkipDirs[entry.Name()]) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if !entry.Type().IsRegular() || (ext != ".html" && ext != ".htm") || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		rel, _ := filepath.Rel(workspaceDir, file)
+		return fn(file, filepath.ToSlash(rel), entry)
+	})
+}
+
+// sitemapPages walks the workspace for the HTML pages the site publishes. Error pages,
+// pages marked noindex and those matching exclude are left out.
+func sitemapPages(workspaceDir, baseURL string, exclude []string) ([]SitemapURL, error) {
+	urls := []SitemapURL{}
+	err := walkSitePages(workspaceDir, func(file, rel string, entry fs.DirEntry) error {
+		switch strings.TrimSuffix(path.Base(rel), path.Ext(rel)) {
+		case "404", "500":
+			return nil
+		}
+		for _, pattern := range exclude {
+			if matched, _ := path.Match(strings.Trim(pattern, "/"), rel); matched || strings.HasPrefix(rel, strings.Trim(pattern, "/")+"/") {
+				return nil
+			}
+		}
+		if pageIsNoindex(file) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if len(urls) == maxSitemapURLs {
+			return fmt.Errorf("the site has more than %d pages", maxSitemapURLs)
+		}
+		urls = append(urls, SitemapURL{Loc: pageLoc(baseURL, rel), LastMod: info.ModTime().UTC().Format("2006-01-02"), Path: rel})
+		return nil
+	})
+	sort.Slice(urls, func(i, j int) bool { return urls[i].Loc < urls[j].Loc })
+	return urls, err
+}
+
+// renderSitemap renders sitemap.xml
+func renderSitemap(urls []SitemapURL) (string, error) {
+	data, err := xml.MarshalIndent(sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: urls}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + "<!-- " + sitemapMarker + " -->\n" + string(data) + "\n", nil
+}
+
+// renderRobots renders robots.txt, pointing crawlers at the sitemap
+func renderRobots(baseURL string, disallow []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\nUser-agent: *\n", sitemapMarker)
+	rules := 0
+	for _, rule := range disallow {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			fmt.Fprintf(&b, "Disallow: /%s\n", strings.TrimPrefix(rule, "/"))
+			rules++
+		}
+	}
+	if rules == 0 {
+		b.WriteString("Allow: /\n")
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s/sitemap.xml\n", baseURL)
+	return b.String()
+}
+
+// buildSitemap resolves the workspace and site address of a request and renders
+// both files
+func buildSitemap(db *gorm.DB, req SitemapRequest) (string, SitemapPreview, error) {
+	var project *Project
+	if req.ProjectID != "" {
+		project = &Project{}
+		if err := db.First(project, "id = ?", req.ProjectID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", SitemapPreview{}, newAPIError(404, "PROJECT_NOT_FOUND", "Project not found", req.ProjectID)
+			}
+			return "", SitemapPreview{}, newAPIError(500, "DATABASE_ERROR", "Failed to load project", err.Error())
+		}
+	}
+	workspaceDir := getWorkspaceDir()
+	if project != nil {
+		workspaceDir = project.WorkspacePath
+	}
+
+	baseURL := req.BaseURL
+	if baseURL == "" {
+		baseURL = getSiteURL(project)
+	}
+	baseURL, err := checkSiteURL(baseURL)
+	if err != nil {
+		return "", SitemapPreview{}, err
+	}
+
+	urls, err := sitemapPages(workspaceDir, baseURL, req.Exclude)
+	if err != nil {
+		return "", SitemapPreview{}, newAPIError(500, "WORKSPACE_ERROR", "Failed to list the pages of the site", err.Error())
+	}
+	sitemap, err := renderSitemap(urls)
+	if err != nil {
+		return "", SitemapPreview{}, newAPIError(500, "SITEMAP_ERROR", "Failed to render the sitemap", err.Error())
+	}
+	return workspaceDir, SitemapPreview{BaseURL: baseURL, URLs: urls, Sitemap: sitemap, Robots: renderRobots(baseURL, req.Disallow)}, nil
+}
+
+// writeSitemapFile writes a generated file at the workspace root unless a hand-written
+// one is there and force is not set. It reports whether the file was written.
+func writeSitemapFile(workspaceDir, name, content string, force bool) (bool, error) {
+	file := filepath.Join(workspaceDir, name)
+	if existing, err := os.ReadFile(file); err == nil && !force && !strings.Contains(string(existing), sitemapMarker) {
+		return false, nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return true, writeFileAtomic(file, []byte(content))
+}
+
+// GetSitemap previews the sitemap.xml and robots.txt of the workspace (?projectId=,
+// ?baseUrl=, ?exclude= as a comma-separated list) without writing them
+func GetSitemap(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := SitemapRequest{ProjectID: c.Query("projectId"), BaseURL: c.Query("baseUrl")}
+		if exclude := c.Query("exclude"); exclude != "" {
+			req.Exclude = strings.Split(exclude, ",")
+		}
+		_, preview, err := buildSitemap(db, req)
+		if err != nil {
+			return err
+		}
+		return c.JSON(APIResponse[SitemapPreview]{Success: true, Data: preview})
+	}
+}
+
+// GenerateSitemap writes sitemap.xml and robots.txt at the root of the workspace
+func GenerateSitemap(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[SitemapRequest](c)
+		workspaceDir, preview, err := buildSitemap(db, *req)
+		if err != nil {
+			return err
+		}
+
+		generated := SitemapGenerated{SitemapPreview: preview, Written: []string{}}
+		for _, file := range []struct{ name, content string }{{"sitemap.xml", preview.Sitemap}, {"robots.txt", preview.Robots}} {
+			written, err := writeSitemapFile(workspaceDir, file.name, file.content, req.Force)
+			if err != nil {
+				return sendError(c, 500, "WORKSPACE_ERROR", "Failed to write "+file.name, err.Error())
+			}
+			if written {
+				generated.Written = append(generated.Written, file.name)
+			} else {
+				generated.Skipped = append(generated.Skipped, file.name)
+			}
+		}
+
+		log.Printf("🗺️ Sitemap generated for %s: %d page(s), wrote %s", preview.BaseURL, len(preview.URLs), strings.Join(generated.Written, ", "))
+		return c.JSON(APIResponse[SitemapGenerated]{Success: true, Data: generated})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// RollbackRequest is the optional body of POST /api/ai/command/:commandId/rollback
+type RollbackRequest struct {
+	Force bool `json:"force"` // Also discard the changes of later commands
+}
+
+// RollbackResult reports what a rollback restored
+type RollbackResult struct {
+	CommandID    string `json:"commandId"`
+	Restored     int    `json:"restored"` // Files written back from the snapshot
+	Deleted      int    `json:"deleted"`  // Files created since the snapshot
+	RolledBackAt int64  `json:"rolledBackAt"`
+}
+
+// getSnapshotDir returns where pre-command workspace archives are kept from SNAPSHOT_DIR
+// Falls back to ./snapshots
+func getSnapshotDir() string {
+	return getEnvDefault("SNAPSHOT_DIR", "snapshots")
+}
+
+// getSnapshotKeep returns how many snapshots are kept from SNAPSHOT_KEEP; 0 disables them
+// Falls back to 20
+func getSnapshotKeep() int {
+	if n, err := strconv.Atoi(getEnvDefault("SNAPSHOT_KEEP", "20")); err == nil && n >= 0 {
+		return n
+	}
+	return 20
+}
+
+// archiveWorkspace saves the workspace before a command runs. Directories skipped by
+// change tracking (.git, node_modules, ...) are left out. Returns "" when snapshots are off.
+func archiveWorkspace(commandID, dir string) (string, error) {
+	if getSnapshotKeep() == 0 {
+		return "", nil
+	}
+	path := filepath.Join(getSnapshotDir(), commandID+".tar.gz")
+	if err := archiveDir(dir, path, snapshotSkipDirs); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func snapshotExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// pruneSnapshots deletes all but the newest SNAPSHOT_KEEP snapshots
+func pruneSnapshots(db *gorm.DB) {
+	var old []AICommand
+	db.Select("id", "snapshot_path").Where("snapshot_path <> ''").
+		Order("created_at DESC").Offset(getSnapshotKeep()).Find(&old)
+	for _, command := range old {
+		if err := os.Remove(command.SnapshotPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ Failed to remove snapshot %s: %v", command.SnapshotPath, err)
+			continue
+		}
+		db.Model(&AICommand{}).Where("id = ?", command.ID).Update("snapshot_path", "")
+	}
+}
+
+// archivedFiles lists the files in a snapshot archive
+func archivedFiles(archive string) (map[string]bool, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			files[header.Name] = true
+		}
+	}
+}
+
+// restoreWorkspace puts the workspace back to the archive: files created since are
+// deleted, except in skipDirs, and every archived file is written back. Returns the
+// restored and deleted counts.
+func restoreWorkspace(archive, dir string, skipDirs map[string]bool) (int, int, error) {
+	files, err := archivedFiles(archive)
+	if err != nil {
+		return 0, 0, err
+	}
+	stale, err := staleFiles(dir, files, skipDirs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	deleted := 0
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return 0, deleted, err
+		}
+		deleted++
+	}
+
+	if err := extractArchive(archive, dir); err != nil {
+		return 0, deleted, err
+	}
+	return len(files), deleted, nil
+}
+
+// staleFiles lists the files of dir that are not in an archive, which restoring it
+// deletes. The directories in skipDirs, which the archive left out, are left alone.
+func staleFiles(dir string, files map[string]bool, skipDirs map[string]bool) ([]string, error) {
+	var stale []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && skipDirs[entry.Name()] {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if !files[filepath.ToSlash(rel)] {
+			stale = append(stale, path)
+		}
+		return nil
+	})
+	return stale, err
+}
+
+// workspaceBusy reports whether a command is running in the project's workspace
+func workspaceBusy(projectID string) bool {
+	commandMu.RLock()
+	defer commandMu.RUnlock()
+	for _, session := range commandSessions {
+		session.mu.RLock()
+		// Commands on a branch of their own work in a worktree
+		busy := session.isProcessing && session.Command.ProjectID == projectID && session.Command.Branch == ""
+		session.mu.RUnlock()
+		if busy {
+			return true
+		}
+	}
+	return false
+}
+
+// RollbackAICommand restores the workspace to the snapshot taken before the command ran.
+// Later commands in the same workspace are rolled back with it, so they must be
+// acknowledged with {"force": true}.
+func RollbackAICommand(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandID := c.Params("commandId")
+
+		var req RollbackRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+			}
+		}
+
+		var command AICommand
+		if err := db.First(&command, "id = ?", commandID).Error; err != nil {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", "")
+		}
+		if command.RolledBackAt != 0 {
+			return sendError(c, 409, "ALREADY_ROLLED_BACK", "This command has already been rolled back", "")
+		}
+		if command.SnapshotPath == "" || !snapshotExists(command.SnapshotPath) {
+			return sendError(c, 404, "SNAPSHOT_NOT_FOUND", "No workspace snapshot exists for this command", "")
+		}
+		if workspaceBusy(command.ProjectID) {
+			return sendError(c, 409, "WORKSPACE_BUSY", "A command is running in this workspace", "")
+		}
+
+		var later []AICommand
+		db.Select("id").Where("project_id = ? AND created_at >= ? AND id <> ? AND snapshot_path <> '' AND rolled_back_at = 0",
+			command.ProjectID, command.CreatedAt, command.ID).Find(&later)
+		if len(later) > 0 && !req.Force {
+			ids := make([]string, 0, len(later))
+			for _, l := range later {
+				ids = append(ids, l.ID)
+			}
+			return sendError(c, 409, "NEWER_COMMANDS", "Later commands changed this workspace; send {\"force\": true} to discard their changes too", ids)
+		}
+
+		workspaceDir, err := resolveWorkspaceDir(db, command.ProjectID)
+		if err != nil {
+			if errors.Is(err, errProjectNotFound) {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", err.Error())
+			}
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+
+		restored, deleted, err := restoreWorkspace(command.SnapshotPath, workspaceDir, snapshotSkipDirs)
+		if err != nil {
+			log.Printf("❌ Rollback of command [%s] failed: %v", command.ID, err)
+			return sendError(c, 500, "ROLLBACK_FAILED", "Failed to restore the workspace", err.Error())
+		}
+
+		now := time.Now().Unix()
+		db.Model(&AICommand{}).Where("id = ?", command.ID).Update("rolled_back_at", now)
+		for _, l := range later {
+			db.Model(&AICommand{}).Where("id = ?", l.ID).Update("rolled_back_at", now)
+		}
+
+		log.Printf("⏪ Rolled back command [%s]: %d file(s) restored, %d deleted", command.ID, restored, deleted)
+		return c.JSON(APIResponse[RollbackResult]{
+			Success: true,
+			Data: RollbackResult{
+				CommandID:    command.ID,
+				Restored:     restored,
+				Deleted:      deleted,
+				RolledBackAt: now,
+			},
+		})
+	}
+}
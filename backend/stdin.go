@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+var (
+	errStdinUnavailable = errors.New("process does not accept input")
+	errStdinClosed      = errors.New("process input has been closed")
+)
+
+// stdinWriter forwards user input to a running child process
+type stdinWriter struct {
+	mu     sync.Mutex
+	w      io.WriteCloser
+	closed bool
+}
+
+// attach connects the writer to the stdin pipe of a started process
+func (s *stdinWriter) attach(w io.WriteCloser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w = w
+	s.closed = false
+}
+
+// detach drops the pipe once the process has exited
+func (s *stdinWriter) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w = nil
+}
+
+// WriteLine sends text to the process, terminated by a newline
+func (s *stdinWriter) WriteLine(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.w == nil {
+		return errStdinUnavailable
+	}
+	if s.closed {
+		return errStdinClosed
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, err := io.WriteString(s.w, text)
+	return err
+}
+
+// Close sends EOF to the process
+func (s *stdinWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.w == nil {
+		return errStdinUnavailable
+	}
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.w.Close()
+}
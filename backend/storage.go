@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// AssetStorage stores uploaded asset files by key ("<assetId>/<name>")
+type AssetStorage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewAssetStorage creates the storage backend selected by assets.storage (ASSET_STORAGE: disk, s3)
+// Falls back to disk storage in assets.dir (ASSET_DIR)
+func NewAssetStorage() (AssetStorage, error) {
+	switch backend := appConfig.Assets.Storage; backend {
+	case "disk":
+		dir := appConfig.Assets.Dir
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create asset directory: %w", err)
+		}
+		return &diskStorage{dir: dir}, nil
+
+	case "s3":
+		return newS3Storage("ASSET_S3")
+
+	default:
+		return nil, fmt.Errorf("unsupported ASSET_STORAGE %q (expected disk or s3)", backend)
+	}
+}
+
+// diskStorage keeps assets below a local directory
+type diskStorage struct {
+	dir string
+}
+
+// path maps a key to a file below the storage directory
+func (s *diskStorage) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !isWithinDir(filepath.Clean(s.dir), path) || path == filepath.Clean(s.dir) {
+		return "", fmt.Errorf("invalid asset key %q", key)
+	}
+	return path, nil
+}
+
+func (s *diskStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *diskStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *diskStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Drop the per-asset directory once it is empty
+	os.Remove(filepath.Dir(path))
+	return nil
+}
+
+// s3Storage keeps assets in an S3-compatible bucket (AWS S3, MinIO, R2, ...)
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// newS3Storage connects to the bucket configured by the <env>_* variables, e.g.
+// ASSET_S3_ENDPOINT for env ASSET_S3
+func newS3Storage(env string) (*s3Storage, error) {
+	endpoint := os.Getenv(env + "_ENDPOINT")
+	bucket := os.Getenv(env + "_BUCKET")
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("%s_ENDPOINT and %s_BUCKET are required for s3 storage", env, env)
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv(env+"_ACCESS_KEY"), os.Getenv(env+"_SECRET_KEY"), ""),
+		Secure: os.Getenv(env+"_USE_SSL") != "false",
+		Region: os.Getenv(env + "_REGION"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(context.Background(), bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach S3 bucket %s: %w", bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("S3 bucket %s does not exist", bucket)
+	}
+
+	return &s3Storage{
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(os.Getenv(env+"_PREFIX"), "/"),
+	}, nil
+}
+
+func (s *s3Storage) object(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.object(key), r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.object(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat surfaces missing objects before streaming starts
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.object(key), minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WSMsgTypeOutputDropped tells a client that output lines were left out of its stream
+const WSMsgTypeOutputDropped = "output_dropped"
+
+// getWSSendTimeout returns how long a WebSocket write may block (streams.sendTimeout, WS_SEND_TIMEOUT)
+// Falls back to 10s
+func getWSSendTimeout() time.Duration {
+	return appConfig.Streams.SendTimeout.Duration()
+}
+
+// isSendTimeout reports whether a stream write failed because the client stopped reading
+func isSendTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// publish records an update in the command log and queues it for the stream. Output
+// lines that find the queue full are dropped rather than stalling the provider; they
+// stay in the command log, and the stream gets an output_dropped marker in their place
+// once there is room. Other updates wait for room, which the send timeout of the
+// stream bounds.
+func (s *AICommandSession) publish(update ProgressUpdate) {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	update = s.record(update)
+	seq := s.logSeq - 1
+	if update.Type != WSMsgTypeOutput {
+		if s.dropped > 0 {
+			s.enqueue(s.droppedMarker())
+			s.dropped = 0
+		}
+		s.enqueue(update)
+		return
+	}
+
+	if s.dropped > 0 {
+		select {
+		case s.progressQueue <- s.droppedMarker():
+			s.dropped = 0
+		default:
+			s.dropped++
+			s.droppedTo = seq
+			return
+		}
+	}
+	select {
+	case s.progressQueue <- update:
+	default:
+		if s.dropped == 0 {
+			s.droppedFrom = seq
+		}
+		s.dropped++
+		s.droppedTo = seq
+	}
+}
+
+// enqueue waits for room in the queue unless the session was cancelled, e.g. because
+// its client went away
+func (s *AICommandSession) enqueue(update ProgressUpdate) {
+	select {
+	case s.progressQueue <- update:
+		return
+	default:
+	}
+	select {
+	case s.progressQueue <- update:
+	case <-s.Context.Done():
+	}
+}
+
+// droppedMarker returns the output_dropped update for the lines dropped since the last
+// marker. The lines can be read back from the command log.
+func (s *AICommandSession) droppedMarker() ProgressUpdate {
+	marker := ProgressUpdate{
+		Type:      WSMsgTypeOutputDropped,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Message:   fmt.Sprintf("%d output line(s) were left out because the connection is too slow", s.dropped),
+		Data: fiber.Map{
+			"dropped": s.dropped,
+			"fromSeq": s.droppedFrom,
+			"toSeq":   s.droppedTo,
+			"log":     fmt.Sprintf("/api/ai/command/%s/log?offset=%d&limit=%d", s.ID, s.droppedFrom, s.droppedTo-s.droppedFrom+1),
+		},
+	}
+	return marker
+}
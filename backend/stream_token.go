@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Stream tokens let a client attach to the WebSocket of a command it created without
+// putting its API token in the URL. With AUTH_ENABLED they are issued with every
+// queued command, open only the stream they were issued for and expire after
+// STREAM_TOKEN_TTL.
+
+// streamTokenQuery is the query parameter that carries a stream token
+const streamTokenQuery = "streamToken"
+
+var (
+	errStreamTokenInvalid = errors.New("stream token is malformed or its signature does not match")
+	errStreamTokenExpired = errors.New("stream token has expired")
+	errStreamTokenOther   = errors.New("stream token was issued for another stream")
+)
+
+// StreamToken is a signed, short-lived grant to open one stream
+type StreamToken struct {
+	Token     string
+	ExpiresAt int64
+}
+
+// streamClaims is what a stream token grants: the stream (command:<id> or batch:<id>)
+// and the user it was issued to, until ExpiresAt
+type streamClaims struct {
+	Subject   string
+	UserID    string
+	ExpiresAt int64
+}
+
+// path is the only URL the token opens
+func (claims *streamClaims) path() string {
+	kind, id, _ := strings.Cut(claims.Subject, ":")
+	switch kind {
+	case "command":
+		return "/api/ai/command/" + id + "/stream"
+	case "batch":
+		return "/api/ai/batch/" + id + "/stream"
+	}
+	return ""
+}
+
+// getStreamTokenTTL returns how long a stream token is valid (streams.tokenTtl, STREAM_TOKEN_TTL)
+// Falls back to 10m
+func getStreamTokenTTL() time.Duration {
+	return appConfig.Streams.TokenTTL.Duration()
+}
+
+// streamTokenKey signs stream tokens. Without STREAM_TOKEN_SECRET a random key is used,
+// so tokens do not survive a restart and are not accepted by other instances.
+var streamTokenKey = sync.OnceValue(func() []byte {
+	if secret := appConfig.Streams.TokenSecret; secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		return sum[:]
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate the stream token key: %v", err)
+	}
+	if getAuthEnabled() {
+		log.Printf("⚠️ STREAM_TOKEN_SECRET is not set; stream tokens are only valid until the server restarts")
+	}
+	return key
+})
+
+func signStreamPayload(payload string) string {
+	mac := hmac.New(sha256.New, streamTokenKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueStreamToken signs a token for the stream of subject, owned by userID
+func issueStreamToken(subject, userID string) StreamToken {
+	expiresAt := time.Now().Add(getStreamTokenTTL()).Unix()
+	payload := subject + "\n" + userID + "\n" + strconv.FormatInt(expiresAt, 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return StreamToken{Token: encoded + "." + signStreamPayload(payload), ExpiresAt: expiresAt}
+}
+
+// parseStreamToken checks the signature and expiry of a stream token
+func parseStreamToken(token string) (*streamClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errStreamTokenInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errStreamTokenInvalid
+	}
+	payload := string(raw)
+	if !hmac.Equal([]byte(signature), []byte(signStreamPayload(payload))) {
+		return nil, errStreamTokenInvalid
+	}
+
+	parts := strings.Split(payload, "\n")
+	if len(parts) != 3 {
+		return nil, errStreamTokenInvalid
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, errStreamTokenInvalid
+	}
+	if time.Now().Unix() >= expiresAt {
+		return nil, errStreamTokenExpired
+	}
+	return &streamClaims{Subject: parts[0], UserID: parts[1], ExpiresAt: expiresAt}, nil
+}
+
+// streamURL returns the WebSocket URL of the stream of subject. With AUTH_ENABLED it
+// carries a stream token for userID, which is also returned; otherwise the token is empty.
+func streamURL(c *fiber.Ctx, subject, userID string) (string, StreamToken) {
+	claims := streamClaims{Subject: subject}
+	url := wsURL(c, claims.path())
+	if !getAuthEnabled() {
+		return url, StreamToken{}
+	}
+	token := issueStreamToken(subject, userID)
+	return url + "?" + streamTokenQuery + "=" + token.Token, token
+}
+
+// authenticateStreamToken resolves the user of a request that carries a stream token
+// instead of an API token. The token is only accepted on the stream it was issued for.
+func authenticateStreamToken(c *fiber.Ctx, db *gorm.DB, token string) (*User, error) {
+	claims, err := parseStreamToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if c.Path() != claims.path() {
+		return nil, errStreamTokenOther
+	}
+	c.Locals("streamClaims", claims)
+	if claims.UserID == "" {
+		return nil, nil // Issued to an anonymous caller
+	}
+
+	var user User
+	if err := db.Limit(1).Find(&user, "id = ?", claims.UserID).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == "" {
+		return nil, errStreamTokenInvalid // The user has been removed
+	}
+	return &user, nil
+}
+
+// RequireStreamToken only lets the creator of a command or batch attach to its stream:
+// with AUTH_ENABLED the upgrade must carry the stream token issued with it.
+func RequireStreamToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !getAuthEnabled() {
+			return c.Next()
+		}
+		if _, ok := c.Locals("streamClaims").(*streamClaims); !ok {
+			return sendError(c, 401, "STREAM_TOKEN_REQUIRED", "A stream token is required to open this stream",
+				"Connect to the wsUrl returned when the command was created, or request a new one from POST "+strings.TrimSuffix(c.Path(), "/stream")+"/stream-token")
+		}
+		return c.Next()
+	}
+}
+
+// StreamTokenResponse is the data of a response that issued a stream token
+type StreamTokenResponse struct {
+	CommandID   string `json:"commandId"`
+	WSURL       string `json:"wsUrl"`
+	StreamToken string `json:"streamToken,omitempty"`
+	ExpiresAt   int64  `json:"expiresAt,omitempty"`
+}
+
+// IssueCommandStreamToken issues a new stream token for a command, for example once a
+// command that waited for approval may run. Only its creator and admins get one.
+func IssueCommandStreamToken(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandID := c.Params("commandId")
+		var command AICommand
+		if err := db.Limit(1).Find(&command, "id = ?", commandID).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load command", err.Error())
+		}
+		if command.ID == "" {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", nil)
+		}
+
+		if !ownsOrAdmin(c, command.UserID) {
+			return sendError(c, 403, "NOT_COMMAND_OWNER", "Only the creator of the command can open its stream", nil)
+		}
+
+		url, token := streamURL(c, "command:"+command.ID, currentUserID(c))
+		return c.JSON(APIResponse[StreamTokenResponse]{
+			Success: true,
+			Data: StreamTokenResponse{
+				CommandID:   command.ID,
+				WSURL:       url,
+				StreamToken: token.Token,
+				ExpiresAt:   token.ExpiresAt,
+			},
+		})
+	}
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TeamMember gives a user a role on one project. On the routes of the project the user
+// acts with the higher of this role and their own, so an agency can make a client an
+// editor of their site only.
+type TeamMember struct {
+	ProjectID string `gorm:"primaryKey;size:64" json:"projectId"`
+	UserID    string `gorm:"primaryKey;size:64;index" json:"userId"`
+	Role      Role   `json:"role"`
+	InvitedBy string `json:"invitedBy,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// TeamInvite asks someone by email to join the team of a project. The link in the
+// email carries a token that works once, until the invite expires; only its hash is
+// stored.
+type TeamInvite struct {
+	ID         string `gorm:"primaryKey" json:"id"`
+	ProjectID  string `gorm:"index" json:"projectId"`
+	Email      string `json:"email"`
+	Role       Role   `json:"role"`
+	TokenHash  string `gorm:"uniqueIndex" json:"-"`
+	InvitedBy  string `json:"invitedBy,omitempty"`
+	ExpiresAt  int64  `gorm:"index" json:"expiresAt"`
+	AcceptedAt int64  `json:"acceptedAt,omitempty"`
+	AcceptedBy string `json:"acceptedBy,omitempty"`
+	CreatedAt  int64  `json:"createdAt"`
+}
+
+// inviteTokenPrefix tells invite tokens from the other tokens
+const inviteTokenPrefix = "sei_"
+
+// TeamInviteRequest is the body of POST /api/projects/:id/team/invites
+type TeamInviteRequest struct {
+	Email string `json:"email" validate:"required,email,max=320"`
+	Role  Role   `json:"role" validate:"required,oneof=viewer editor admin"`
+}
+
+// TeamRoleRequest is the body of PUT /api/projects/:id/team/:userId
+type TeamRoleRequest struct {
+	Role Role `json:"role" validate:"required,oneof=viewer editor admin"`
+}
+
+// AcceptInviteRequest is the body of POST /api/invites/:token/accept. Without a token,
+// name and password create the account of the invited email.
+type AcceptInviteRequest struct {
+	Name     string `json:"name,omitempty" validate:"max=200"`
+	Password string `json:"password,omitempty" validate:"omitempty,min=8,max=72"`
+}
+
+// TeamMemberInfo is a member in the answer of GET /api/projects/:id/team
+type TeamMemberInfo struct {
+	TeamMember
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+// TeamList is the answer of GET /api/projects/:id/team
+type TeamList struct {
+	Members []TeamMemberInfo `json:"members"`
+	Invites []TeamInvite     `json:"invites"` // Pending ones
+}
+
+// TeamMembership is a team of the signed-in user in GET /api/me
+type TeamMembership struct {
+	ProjectID   string `json:"projectId"`
+	ProjectName string `json:"projectName"`
+	Role        Role   `json:"role"`
+}
+
+// InviteInfo is the answer of GET /api/invites/:token
+type InviteInfo struct {
+	ProjectID     string `json:"projectId"`
+	ProjectName   string `json:"projectName"`
+	Email         string `json:"email"`
+	Role          Role   `json:"role"`
+	ExpiresAt     int64  `json:"expiresAt"`
+	AccountExists bool   `json:"accountExists"` // Sign in to accept instead of creating an account
+}
+
+// getTeamInviteTTL returns how long an invite can be accepted (teams.inviteTtl, TEAM_INVITE_TTL)
+// Falls back to 7 days
+func getTeamInviteTTL() time.Duration {
+	return appConfig.Teams.InviteTTL.Duration()
+}
+
+// getTeamInviteURL returns the editor page invite links open (teams.inviteUrl,
+// TEAM_INVITE_URL); the token is added as ?invite=
+// Falls back to the page the invite was sent from
+func getTeamInviteURL() string {
+	return appConfig.Teams.InviteURL
+}
+
+// teamRole returns the role of a user on a project's team, or "" for non-members
+func teamRole(db *gorm.DB, projectID, userID string) Role {
+	var member TeamMember
+	if err := db.Limit(1).Find(&member, "project_id = ? AND user_id = ?", projectID, userID).Error; err != nil {
+		log.Printf("⚠️ Failed to look up the team role of user %s on project %s: %v", userID, projectID, err)
+	}
+	return member.Role
+}
+
+// higherRole returns the role with more permissions
+func higherRole(a, b Role) Role {
+	if roleRanks[b] > roleRanks[a] {
+		return b
+	}
+	return a
+}
+
+// pageProject returns the project a page was created in, or "" for pages of the global
+// workspace
+func pageProject(db *gorm.DB, page string) string {
+	var projectID string
+	db.Model(&Page{}).Where("name = ?", page).Limit(1).Pluck("project_id", &projectID)
+	return projectID
+}
+
+// contentProject returns the project of a content block: that of the page it was saved
+// on, or of the page its ID names while it has never been saved
+func contentProject(db *gorm.DB, id string) string {
+	var page string
+	db.Unscoped().Model(&Content{}).Where("id = ? AND page <> ''", id).Limit(1).Pluck("page", &page)
+	if page == "" {
+		page = pageFromContentID(id)
+	}
+	return pageProject(db, page)
+}
+
+// canEditContent reports whether the request may save a content block: it needs the
+// editor role on the block's project and, when page moves the block, on the project
+// of page as well
+func canEditContent(c *fiber.Ctx, db *gorm.DB, id, page string) bool {
+	projectID := contentProject(db, id)
+	if !projectRole(c, db, projectID).Allows(RoleEditor) {
+		return false
+	}
+	if page == "" {
+		return true
+	}
+	if target := pageProject(db, page); target != projectID {
+		return projectRole(c, db, target).Allows(RoleEditor)
+	}
+	return true
+}
+
+// Content routes whose second segment is not a block ID
+var contentCollectionRoutes = map[string]bool{"bulk": true, "export": true, "import": true, "trash": true, "search": true}
+
+// requestProject returns the project the route of a request acts on, for the routes
+// team roles apply to: the routes under a project and its previews, the blocks and
+// pages of a project, and the AI commands that run in it. ok is false on every other route, and
+// for routes of the global workspace the project is "".
+func requestProject(c *fiber.Ctx, db *gorm.DB) (projectID string, ok bool) {
+	segments := strings.Split(strings.Trim(c.Path(), "/"), "/")
+	segment := func(i int) string {
+		if i < len(segments) {
+			if value, err := url.PathUnescape(segments[i]); err == nil {
+				return value
+			}
+		}
+		return ""
+	}
+
+	switch {
+	case segment(0) == "preview" && segment(1) != "":
+		return segment(1), true
+	case segment(0) != "api":
+		return "", false
+	case segment(1) == "projects" && segment(2) != "":
+		// Changing or deleting the project itself, such as its workspace path, stays
+		// with the user's own role
+		return segment(2), len(segments) > 3 || c.Method() == fiber.MethodGet
+	case segment(1) == "content" && segment(2) != "" && !contentCollectionRoutes[segment(2)]:
+		return contentProject(db, segment(2)), true
+	case segment(1) == "pages" && segment(2) != "" && segment(2) != "templates":
+		return pageProject(db, segment(2)), true
+	case segment(1) == "pages" && len(segments) == 2 && c.Method() == fiber.MethodPost:
+		var body struct {
+			ProjectID string `json:"projectId"`
+		}
+		json.Unmarshal(c.Body(), &body)
+		return body.ProjectID, true
+	case segment(1) == "ai" && segment(2) == "command" && len(segments) == 3 && c.Method() == fiber.MethodPost:
+		var body struct {
+			Context struct {
+				ProjectID string `json:"projectId"`
+			} `json:"context"`
+		}
+		json.Unmarshal(c.Body(), &body)
+		return body.Context.ProjectID, true
+	case segment(1) == "ai" && segment(2) == "command" && segment(3) != "":
+		db.Model(&AICommand{}).Where("id = ?", segment(3)).Limit(1).Pluck("project_id", &projectID)
+		return projectID, true
+	}
+	return "", false
+}
+
+// projectRole returns the role a request has on a project, or on the global workspace
+// for "": the higher of the user's own role and their team role. With auth disabled
+// everyone is an admin.
+func projectRole(c *fiber.Ctx, db *gorm.DB, projectID string) Role {
+	user := currentUser(c)
+	if !getAuthEnabled() || user == nil {
+		return currentRole(c)
+	}
+	if projectID == "" {
+		return user.Role
+	}
+	return higherRole(user.Role, teamRole(db, projectID, user.ID))
+}
+
+// userTeams returns the teams a user belongs to
+func userTeams(db *gorm.DB, userID string) []TeamMembership {
+	teams := []TeamMembership{}
+	err := db.Table("team_members").
+		Select("team_members.project_id, projects.name AS project_name, team_members.role").
+		Joins("JOIN projects ON projects.id = team_members.project_id").
+		Where("team_members.user_id = ?", userID).
+		Order("projects.name").
+		Scan(&teams).Error
+	if err != nil {
+		log.Printf("⚠️ Failed to load the teams of user %s: %v", userID, err)
+	}
+	return teams
+}
+
+// loadProject fetches the project named by :id, answering 404 itself when it does not exist
+func loadProject(c *fiber.Ctx, db *gorm.DB) (*Project, error) {
+	var project Project
+	if err := db.First(&project, "id = ?", c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", c.Params("id"))
+		}
+		return nil, sendError(c, 500, "DATABASE_ERROR", "Failed to load project", err.Error())
+	}
+	return &project, nil
+}
+
+// addTeamMember adds a user to a team or changes their role on it
+func addTeamMember(db *gorm.DB, member TeamMember) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "updated_at"}),
+	}).Create(&member).Error
+}
+
+// deleteTeams removes the memberships and invites of a deleted project
+func deleteTeams(db *gorm.DB, projectID string) {
+	db.Where("project_id = ?", projectID).Delete(&TeamMember{})
+	db.Where("project_id = ?", projectID).Delete(&TeamInvite{})
+}
+
+// pruneTeamInvites deletes invites that expired without being accepted
+func pruneTeamInvites(db *gorm.DB) int {
+	result := db.Where("accepted_at = 0 AND expires_at <= ?", time.Now().Unix()).Delete(&TeamInvite{})
+	if result.Error != nil {
+		log.Printf("⚠️ Cleanup failed to prune expired invites: %v", result.Error)
+		return 0
+	}
+	return int(result.RowsAffected)
+}
+
+// teamInviteEmail invites someone to a project's team. The link opens the editor page
+// the admin sent the invite from, or TEAM_INVITE_URL; without either the email carries
+// the token.
+func teamInviteEmail(project Project, invite TeamInvite, inviter, token, editorURL string) EmailMessage {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Hello,\n\n%s invited you to work on %s as %s.\n\n", inviter, project.Name, invite.Role)
+	if editorURL != "" {
+		link := editorURL
+		if strings.Contains(link, "?") {
+			link += "&invite=" + token
+		} else {
+			link += "?invite=" + token
+		}
+		fmt.Fprintf(&text, "Accept the invite here:\n\n    %s\n\n", link)
+	} else {
+		fmt.Fprintf(&text, "Accept the invite in the site editor with this code:\n\n    %s\n\n", token)
+	}
+	fmt.Fprintf(&text, "The invite expires on %s.\n", time.Unix(invite.ExpiresAt, 0).UTC().Format("January 2, 2006"))
+	return EmailMessage{
+		To:      []string{invite.Email},
+		Subject: "Join " + project.Name + " in the site editor",
+		Text:    text.String(),
+	}
+}
+
+// ListTeam returns the members of a project's team and its pending invites
+func ListTeam(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		project, err := loadProject(c, db)
+		if project == nil {
+			return err
+		}
+		list := TeamList{Members: []TeamMemberInfo{}, Invites: []TeamInvite{}}
+		err = db.Table("team_members").
+			Select("team_members.*, users.name, users.email").
+			Joins("JOIN users ON users.id = team_members.user_id").
+			Where("team_members.project_id = ?", project.ID).
+			Order("team_members.created_at").
+			Scan(&list.Members).Error
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load the team", err.Error())
+		}
+		err = db.Where("project_id = ? AND accepted_at = 0 AND expires_at > ?", project.ID, time.Now().Unix()).
+			Order("created_at").Find(&list.Invites).Error
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load the invites", err.Error())
+		}
+		return c.JSON(APIResponse[TeamList]{Success: true, Data: list})
+	}
+}
+
+// InviteTeamMember emails an invite to join a project's team. A pending invite of the
+// same email is replaced. The token is returned once, for the admin to pass on when the
+// email fails.
+func InviteTeamMember(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		project, err := loadProject(c, db)
+		if project == nil {
+			return err
+		}
+		req := validatedBody[TeamInviteRequest](c)
+
+		token, err := randomToken(inviteTokenPrefix, 24)
+		if err != nil {
+			return sendError(c, 500, "TOKEN_ERROR", "Failed to generate token", err.Error())
+		}
+		now := time.Now()
+		invite := TeamInvite{
+			ID:        "inv_" + uuid.New().String()[:8],
+			ProjectID: project.ID,
+			Email:     normalizeEmail(req.Email),
+			Role:      req.Role,
+			TokenHash: hashToken(token),
+			InvitedBy: currentUserID(c),
+			ExpiresAt: now.Add(getTeamInviteTTL()).Unix(),
+			CreatedAt: now.Unix(),
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("project_id = ? AND email = ? AND accepted_at = 0", invite.ProjectID, invite.Email).Delete(&TeamInvite{}).Error; err != nil {
+				return err
+			}
+			return tx.Create(&invite).Error
+		})
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create the invite", err.Error())
+		}
+		log.Printf("✉️ Invited %s to project %s as %s", invite.Email, project.ID, invite.Role)
+		recordAudit(db, c, AuditTeamInvite, project.ID, nil, invite, invite.Email+" as "+string(invite.Role))
+
+		inviter := "An administrator"
+		if user := currentUser(c); user != nil {
+			inviter = user.Name
+		}
+		editorURL := getTeamInviteURL()
+		if editorURL == "" {
+			editorURL = c.Get(fiber.HeaderOrigin)
+		}
+
+		// The invite exists either way; a failed email is reported for the admin to pass the token on
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		data := fiber.Map{"invite": invite, "token": token}
+		if err := sendEmail(ctx, db, project.ID, teamInviteEmail(*project, invite, inviter, token, editorURL)); err != nil {
+			log.Printf("⚠️ Failed to email the invite %s: %v", invite.ID, err)
+			data["inviteError"] = err.Error()
+			data["invited"] = false
+		} else {
+			data["invited"] = true
+		}
+		return c.Status(201).JSON(fiber.Map{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
+
+// RevokeTeamInvite deletes a pending invite; its link stops working
+func RevokeTeamInvite(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result := db.Where("id = ? AND project_id = ? AND accepted_at = 0", c.Params("inviteId"), c.Params("id")).Delete(&TeamInvite{})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to revoke the invite", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "INVITE_NOT_FOUND", "No pending invite with this ID", c.Params("inviteId"))
+		}
+		recordAudit(db, c, AuditTeamRemove, c.Params("id"), nil, nil, "invite "+c.Params("inviteId")+" revoked")
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Invite revoked",
+		})
+	}
+}
+
+// UpdateTeamMember changes the role of a team member
+func UpdateTeamMember(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[TeamRoleRequest](c)
+		var member TeamMember
+		if err := db.Limit(1).Find(&member, "project_id = ? AND user_id = ?", c.Params("id"), c.Params("userId")).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load the team", err.Error())
+		}
+		if member.UserID == "" {
+			return sendError(c, 404, "MEMBER_NOT_FOUND", "The user is not on this team", c.Params("userId"))
+		}
+
+		before := member
+		member.Role = req.Role
+		member.UpdatedAt = time.Now().Unix()
+		if err := db.Save(&member).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update the team", err.Error())
+		}
+		recordAudit(db, c, AuditTeamUpdate, member.ProjectID, before, member, member.UserID+" as "+string(member.Role))
+		return c.JSON(APIResponse[TeamMember]{Success: true, Data: member})
+	}
+}
+
+// RemoveTeamMember takes a user off a project's team
+func RemoveTeamMember(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result := db.Where("project_id = ? AND user_id = ?", c.Params("id"), c.Params("userId")).Delete(&TeamMember{})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update the team", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 404, "MEMBER_NOT_FOUND", "The user is not on this team", c.Params("userId"))
+		}
+		log.Printf("👥 Removed user %s from project %s", c.Params("userId"), c.Params("id"))
+		recordAudit(db, c, AuditTeamRemove, c.Params("id"), nil, nil, c.Params("userId"))
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Member removed",
+		})
+	}
+}
+
+// findInvite returns the invite of a token, answering itself when it can't be used
+func findInvite(c *fiber.Ctx, db *gorm.DB, token string) (*TeamInvite, *Project, error) {
+	var invite TeamInvite
+	if err := db.Limit(1).Find(&invite, "token_hash = ?", hashToken(token)).Error; err != nil {
+		return nil, nil, sendError(c, 500, "DATABASE_ERROR", "Failed to load the invite", err.Error())
+	}
+	if invite.ID == "" {
+		return nil, nil, sendError(c, 404, "INVITE_NOT_FOUND", "The invite is not valid or was revoked", "")
+	}
+	if invite.AcceptedAt != 0 {
+		return nil, nil, sendError(c, 410, "INVITE_USED", "The invite was already accepted", "")
+	}
+	if invite.ExpiresAt <= time.Now().Unix() {
+		return nil, nil, sendError(c, 410, "INVITE_EXPIRED", "The invite has expired; ask for a new one", "")
+	}
+	var project Project
+	if err := db.First(&project, "id = ?", invite.ProjectID).Error; err != nil {
+		return nil, nil, sendError(c, 404, "INVITE_NOT_FOUND", "The project of the invite no longer exists", invite.ProjectID)
+	}
+	return &invite, &project, nil
+}
+
+// GetInvite tells the invite page which project and role an invite is for
+func GetInvite(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		invite, project, err := findInvite(c, db, c.Params("token"))
+		if invite == nil {
+			return err
+		}
+		existing, err := userByEmail(db, invite.Email)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to look up user", err.Error())
+		}
+		return c.JSON(APIResponse[InviteInfo]{Success: true, Data: InviteInfo{
+			ProjectID:     project.ID,
+			ProjectName:   project.Name,
+			Email:         invite.Email,
+			Role:          invite.Role,
+			ExpiresAt:     invite.ExpiresAt,
+			AccountExists: existing != nil,
+		}})
+	}
+}
+
+// AcceptInvite adds the invited user to the team. A signed-in user must have the
+// invited email; otherwise name and password create their account, even with
+// AUTH_SIGNUP off, and sign it in. Either way the email counts as verified, since the
+// token was sent to it.
+func AcceptInvite(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		invite, project, err := findInvite(c, db, c.Params("token"))
+		if invite == nil {
+			return err
+		}
+		req := validatedBody[AcceptInviteRequest](c)
+
+		var user *User
+		if token := requestToken(c); token != "" {
+			user, _, err = userForToken(db, token)
+			if err != nil {
+				return err
+			}
+			if normalizeEmail(user.Email) != invite.Email {
+				return sendError(c, 403, "INVITE_EMAIL_MISMATCH", "The invite was sent to another email; sign in with that account", invite.Email)
+			}
+		} else {
+			existing, err := userByEmail(db, invite.Email)
+			if err != nil {
+				return sendError(c, 500, "DATABASE_ERROR", "Failed to look up user", err.Error())
+			}
+			if existing != nil {
+				return sendError(c, 409, "EMAIL_TAKEN", "An account with this email already exists; sign in to accept the invite", "")
+			}
+			if strings.TrimSpace(req.Name) == "" || req.Password == "" {
+				return sendError(c, 400, "MISSING_ACCOUNT", "name and password are required to create the account", "")
+			}
+			hash, err := hashPassword(req.Password)
+			if err != nil {
+				return sendError(c, 500, "PASSWORD_ERROR", "Failed to hash the password", err.Error())
+			}
+			user = &User{Name: strings.TrimSpace(req.Name), Email: invite.Email, PasswordHash: hash, EmailVerified: true}
+			// The account can only reach the projects of its teams
+			if err := newAccount(db, user, RoleNone); err != nil {
+				return sendError(c, 500, "DATABASE_ERROR", "Failed to create user", err.Error())
+			}
+			recordAudit(db, c, AuditUserCreate, user.ID, nil, user, "invite "+invite.ID)
+		}
+		c.Locals("user", user)
+
+		// Only the first of concurrent accepts of the same invite wins
+		now := time.Now().Unix()
+		result := db.Model(&TeamInvite{}).Where("id = ? AND accepted_at = 0", invite.ID).
+			Updates(map[string]interface{}{"accepted_at": now, "accepted_by": user.ID})
+		if result.Error != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to accept the invite", result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return sendError(c, 410, "INVITE_USED", "The invite was already accepted", "")
+		}
+		member := TeamMember{ProjectID: project.ID, UserID: user.ID, Role: invite.Role, InvitedBy: invite.InvitedBy, CreatedAt: now, UpdatedAt: now}
+		if err := addTeamMember(db, member); err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to join the team", err.Error())
+		}
+		if !user.EmailVerified {
+			db.Model(user).Update("email_verified", true)
+		}
+		log.Printf("👥 User %s joined project %s as %s", user.ID, project.ID, member.Role)
+		recordAudit(db, c, AuditTeamJoin, project.ID, nil, member, user.ID+" as "+string(member.Role))
+
+		data := fiber.Map{"member": member, "project": TeamMembership{ProjectID: project.ID, ProjectName: project.Name, Role: member.Role}}
+		if requestToken(c) == "" {
+			tokens, err := startSession(c, db, user, "password")
+			if err != nil {
+				return sendError(c, 500, "SESSION_ERROR", "Failed to sign in", err.Error())
+			}
+			data["tokens"] = tokens
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
+
+// SetPageProject moves a page to another project's team, or to the global workspace
+// with an empty projectId. The caller needs to be an admin of both.
+func SetPageProject(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			ProjectID string `json:"projectId"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		var page Page
+		if err := db.First(&page, "name = ?", c.Params("page")).Error; err != nil {
+			return sendError(c, 404, "PAGE_NOT_FOUND", "Page not found", c.Params("page"))
+		}
+		if req.ProjectID != "" {
+			if err := db.First(&Project{}, "id = ?", req.ProjectID).Error; err != nil {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", req.ProjectID)
+			}
+		}
+		if !projectRole(c, db, req.ProjectID).Allows(RoleAdmin) {
+			return forbidden(c, RoleAdmin)
+		}
+
+		before := page
+		page.ProjectID = req.ProjectID
+		if err := db.Model(&page).Update("project_id", page.ProjectID).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update page", err.Error())
+		}
+		recordAudit(db, c, AuditPageUpdate, page.Name, before, page, "project "+page.ProjectID)
+		return c.JSON(APIResponse[Page]{Success: true, Data: page})
+	}
+}
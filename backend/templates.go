@@ -0,0 +1,315 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Kinds of templates
+const (
+	TemplateKindPage    = "page"    // A whole HTML document, created as a new page
+	TemplateKindSection = "section" // A fragment whose blocks are added to an existing page
+)
+
+// Template is a reusable page or section. Its HTML is a Go text/template with .Page
+// and .Title; {{content "key"}} places the default content of a block and
+// {{editable "key"}} its data-editable marker. Every block the HTML places is
+// registered as <page>:<key> when the template is instantiated.
+type Template struct {
+	ID          string          `gorm:"primaryKey" json:"id"`
+	Name        string          `json:"name"`
+	Kind        string          `gorm:"index" json:"kind"`
+	Description string          `gorm:"type:text" json:"description,omitempty"`
+	HTML        string          `gorm:"type:text" json:"html"`
+	Blocks      []TemplateBlock `gorm:"serializer:json" json:"blocks"`
+	Thumbnail   string          `json:"thumbnail,omitempty"` // Asset ID or URL of a preview image
+	Builtin     bool            `gorm:"-" json:"builtin,omitempty"`
+	CreatedBy   string          `json:"createdBy,omitempty"`
+	CreatedAt   int64           `json:"createdAt,omitempty"`
+	UpdatedAt   int64           `json:"updatedAt,omitempty"`
+}
+
+// TemplateBlock is the default content of one editable region of a template. Content
+// may use .Page and .Title like the HTML.
+type TemplateBlock struct {
+	Key     string `json:"key" validate:"required,max=64"`                  // Element part of the content ID
+	Type    string `json:"type,omitempty" validate:"omitempty,contenttype"` // Content type; richtext when empty
+	Content string `json:"content"`
+}
+
+// TemplateRequest is the body of POST and PUT /api/templates
+type TemplateRequest struct {
+	Name        string          `json:"name" validate:"required,max=200"`
+	Kind        string          `json:"kind,omitempty" validate:"omitempty,oneof=page section"` // Defaults to page
+	Description string          `json:"description,omitempty" validate:"max=2000"`
+	HTML        string          `json:"html" validate:"required,max=262144"`
+	Blocks      []TemplateBlock `json:"blocks" validate:"max=100,dive"`
+	Thumbnail   string          `json:"thumbnail,omitempty" validate:"max=2048"`
+}
+
+// InstantiateTemplateRequest is the body of POST /api/templates/:id/instantiate. For a
+// page template, page names the new page; for a section, the page the blocks are added to.
+type InstantiateTemplateRequest struct {
+	Page      string `json:"page" validate:"required,pagename"`
+	Title     string `json:"title,omitempty" validate:"max=200"`
+	Path      string `json:"path,omitempty" validate:"max=512"`  // Page templates: file to create
+	ProjectID string `json:"projectId,omitempty"`                // Page templates: workspace of the file
+	Prefix    string `json:"prefix,omitempty" validate:"max=64"` // Sections: prepended to the block keys
+}
+
+// SectionInstance is the response of instantiating a section template. The HTML is
+// not written anywhere; it is for the caller to place on the page.
+type SectionInstance struct {
+	Page     string            `json:"page"`
+	Template string            `json:"template"`
+	HTML     string            `json:"html"`
+	Items    []ContentResponse `json:"items"`
+}
+
+// TemplateList is the data of GET /api/templates
+type TemplateList struct {
+	Templates []Template `json:"templates"`
+}
+
+// templateKeyPattern restricts block keys and prefixes to characters content IDs use
+var templateKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// findTemplate returns a built-in template or one of the library
+func findTemplate(db *gorm.DB, id string) (Template, error) {
+	for _, tmpl := range builtinPageTemplates {
+		if tmpl.ID == id {
+			return tmpl, nil
+		}
+	}
+	var tmpl Template
+	if err := db.Limit(1).Find(&tmpl, "id = ?", id).Error; err != nil {
+		return tmpl, newAPIError(500, "DATABASE_ERROR", "Failed to load template", err.Error())
+	}
+	if tmpl.ID == "" {
+		return tmpl, newAPIError(404, "TEMPLATE_NOT_FOUND", "Template not found", id)
+	}
+	return tmpl, nil
+}
+
+// storedTemplate loads a template of the library for a change; built-in templates
+// cannot be changed
+func storedTemplate(db *gorm.DB, id string) (Template, error) {
+	tmpl, err := findTemplate(db, id)
+	if err == nil && tmpl.Builtin {
+		return tmpl, newAPIError(409, "BUILTIN_TEMPLATE", "Built-in templates cannot be changed", id)
+	}
+	return tmpl, err
+}
+
+// applyTemplateRequest checks a template body and copies it into tmpl
+func applyTemplateRequest(db *gorm.DB, tmpl *Template, req TemplateRequest) error {
+	if req.Kind == "" {
+		req.Kind = TemplateKindPage
+	}
+	seen := make(map[string]bool, len(req.Blocks))
+	for _, block := range req.Blocks {
+		if !templateKeyPattern.MatchString(block.Key) {
+			return newAPIError(400, "INVALID_BLOCK_KEY", "Block keys may only contain letters, digits, _ . and -", block.Key)
+		}
+		if seen[block.Key] {
+			return newAPIError(400, "DUPLICATE_BLOCK_KEY", "Every block needs a key of its own", block.Key)
+		}
+		seen[block.Key] = true
+	}
+	if req.Thumbnail != "" && !strings.HasPrefix(req.Thumbnail, "https://") && !strings.HasPrefix(req.Thumbnail, "http://") {
+		var assets int64
+		if err := db.Model(&Asset{}).Where("id = ?", req.Thumbnail).Count(&assets).Error; err != nil {
+			return newAPIError(500, "DATABASE_ERROR", "Failed to load assets", err.Error())
+		}
+		if assets == 0 {
+			return newAPIError(400, "INVALID_THUMBNAIL", "The thumbnail must be an asset ID or an http(s) URL", req.Thumbnail)
+		}
+	}
+
+	tmpl.Name = strings.TrimSpace(req.Name)
+	tmpl.Kind = req.Kind
+	tmpl.Description = req.Description
+	tmpl.HTML = req.HTML
+	tmpl.Blocks = req.Blocks
+	tmpl.Thumbnail = req.Thumbnail
+	if tmpl.Blocks == nil {
+		tmpl.Blocks = []TemplateBlock{}
+	}
+
+	// Render once so broken templates are rejected when saved rather than when used
+	if _, err := renderTemplate(*tmpl, "preview", "Preview", ""); err != nil {
+		return newAPIError(400, "INVALID_TEMPLATE", "The template does not render", err.Error())
+	}
+	return nil
+}
+
+// CreateTemplate adds a template to the library
+func CreateTemplate(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := validatedBody[TemplateRequest](c)
+		now := time.Now().Unix()
+		tmpl := Template{
+			ID:        "tpl_" + uuid.New().String()[:8],
+			CreatedBy: currentUserID(c),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := applyTemplateRequest(db, &tmpl, *req); err != nil {
+			return err
+		}
+		if err := db.Create(&tmpl).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create template", err.Error())
+		}
+
+		log.Printf("🧱 Template %s created: %q (%s)", tmpl.ID, tmpl.Name, tmpl.Kind)
+		return c.Status(201).JSON(APIResponse[Template]{
+			Success: true,
+			Data:    tmpl,
+		})
+	}
+}
+
+// ListTemplates returns the built-in templates and the library by name (?kind=)
+func ListTemplates(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		kind := c.Query("kind")
+		query := db.Order("name, created_at")
+		if kind != "" {
+			query = query.Where("kind = ?", kind)
+		}
+		var stored []Template
+		if err := query.Find(&stored).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load templates", err.Error())
+		}
+
+		templates := []Template{}
+		if kind == "" || kind == TemplateKindPage {
+			templates = append(templates, builtinPageTemplates...)
+		}
+		return c.JSON(APIResponse[TemplateList]{
+			Success: true,
+			Data:    TemplateList{Templates: append(templates, stored...)},
+		})
+	}
+}
+
+// GetTemplate returns a single template
+func GetTemplate(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tmpl, err := findTemplate(db, c.Params("id"))
+		if err != nil {
+			return err
+		}
+		return c.JSON(APIResponse[Template]{
+			Success: true,
+			Data:    tmpl,
+		})
+	}
+}
+
+// UpdateTemplate replaces a template of the library; pages and blocks created from it
+// are kept as they are
+func UpdateTemplate(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tmpl, err := storedTemplate(db, c.Params("id"))
+		if err != nil {
+			return err
+		}
+		if err := applyTemplateRequest(db, &tmpl, *validatedBody[TemplateRequest](c)); err != nil {
+			return err
+		}
+		tmpl.UpdatedAt = time.Now().Unix()
+		if err := db.Save(&tmpl).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to update template", err.Error())
+		}
+
+		return c.JSON(APIResponse[Template]{
+			Success: true,
+			Data:    tmpl,
+		})
+	}
+}
+
+// DeleteTemplate removes a template from the library; pages and blocks created from it
+// are kept
+func DeleteTemplate(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tmpl, err := storedTemplate(db, c.Params("id"))
+		if err != nil {
+			return err
+		}
+		if err := db.Delete(&Template{}, "id = ?", tmpl.ID).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to delete template", err.Error())
+		}
+
+		recordAudit(db, c, AuditTemplateDelete, tmpl.ID, tmpl, nil, tmpl.Name)
+		return c.JSON(APIResponse[DeletedResponse]{
+			Success: true,
+			Data:    DeletedResponse{ID: tmpl.ID, Deleted: true},
+		})
+	}
+}
+
+// InstantiateTemplate creates a page from a page template, responding like POST
+// /api/pages, or adds the blocks of a section template to a page and returns its HTML
+func InstantiateTemplate(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tmpl, err := findTemplate(db, c.Params("id"))
+		if err != nil {
+			return err
+		}
+		req := validatedBody[InstantiateTemplateRequest](c)
+		if tmpl.Kind == TemplateKindPage {
+			return createPageFromTemplate(c, db, tmpl, CreatePageRequest{
+				Name:      req.Page,
+				Template:  tmpl.ID,
+				Title:     req.Title,
+				Path:      req.Path,
+				ProjectID: req.ProjectID,
+			})
+		}
+
+		if req.Prefix != "" && !templateKeyPattern.MatchString(req.Prefix) {
+			return sendError(c, 400, "INVALID_PREFIX", "The prefix may only contain letters, digits, _ . and -", req.Prefix)
+		}
+		if req.Title == "" {
+			req.Title = tmpl.Name
+		}
+		rendered, err := renderTemplate(tmpl, req.Page, req.Title, req.Prefix)
+		if err != nil {
+			return sendError(c, 500, "TEMPLATE_ERROR", "Failed to render the template", err.Error())
+		}
+
+		// Adding a section twice would share its blocks, so existing ones are refused
+		ids := make([]string, 0, len(rendered.Blocks))
+		for _, block := range rendered.Blocks {
+			ids = append(ids, block.ID)
+		}
+		var taken []string
+		if err := db.Unscoped().Model(&Content{}).Where("id IN ?", ids).Distinct().Pluck("id", &taken).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load content", err.Error())
+		}
+		if len(taken) > 0 {
+			return sendError(c, 409, "CONTENT_EXISTS", "The page already has blocks with these IDs; send another prefix", taken)
+		}
+
+		items, err := registerTemplateBlocks(db, req.Page, rendered.Blocks)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to register the section content", err.Error())
+		}
+
+		log.Printf("🧱 Section template %s added to page %s (%d content blocks)", tmpl.ID, req.Page, len(items))
+		return c.Status(201).JSON(SectionInstance{
+			Page:     req.Page,
+			Template: tmpl.ID,
+			HTML:     rendered.HTML,
+			Items:    items,
+		})
+	}
+}
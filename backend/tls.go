@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// enabled reports whether the server listens with TLS
+func (t TLSConfig) enabled() bool {
+	return t.CertFile != "" || len(t.AutocertDomains) > 0
+}
+
+// validate checks the TLS settings; port is the HTTPS port
+func (t TLSConfig) validate(port int) []error {
+	var errs []error
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls.certFile and tls.keyFile must be set together"))
+	}
+	if t.CertFile != "" && len(t.AutocertDomains) > 0 {
+		errs = append(errs, fmt.Errorf("use either tls.certFile or tls.autocertDomains, not both"))
+	}
+	if t.CertFile != "" && t.KeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls: %w", err))
+		}
+	}
+	for _, domain := range t.AutocertDomains {
+		if !hostnamePattern.MatchString(domain) || net.ParseIP(domain) != nil {
+			errs = append(errs, fmt.Errorf("tls.autocertDomains: invalid domain %q", domain))
+		}
+	}
+	if len(t.AutocertDomains) > 0 && t.AutocertCacheDir == "" {
+		errs = append(errs, fmt.Errorf("tls.autocertCacheDir must not be empty"))
+	}
+	if t.RedirectPort != 0 {
+		if !t.enabled() {
+			errs = append(errs, fmt.Errorf("tls.redirectPort needs a certificate or autocert domains"))
+		}
+		if t.RedirectPort < 1 || t.RedirectPort > 65535 || t.RedirectPort == port {
+			errs = append(errs, fmt.Errorf("tls.redirectPort must be between 1 and 65535 and differ from port, got %d", t.RedirectPort))
+		}
+	}
+	// Let's Encrypt validates on port 443 (tls-alpn-01) or port 80 (http-01)
+	if len(t.AutocertDomains) > 0 && port != 443 && t.RedirectPort != 80 {
+		log.Printf("⚠️ Autocert needs port 443 or tls.redirectPort 80 reachable from the internet to obtain certificates")
+	}
+	return errs
+}
+
+// serverListener opens the listen address, wrapped in TLS when it is configured. The
+// returned func starts the HTTP redirect server once the main server is up.
+func serverListener(cfg *Config) (net.Listener, func(), error) {
+	ln, err := net.Listen("tcp", cfg.listenAddress())
+	if err != nil {
+		return nil, nil, err
+	}
+	t := cfg.TLS
+	if !t.enabled() {
+		return ln, func() {}, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// fasthttp only speaks HTTP/1.1, so h2 is not offered
+		NextProtos: []string{"http/1.1"},
+	}
+	var challenges http.Handler
+	if len(t.AutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.AutocertDomains...),
+			Cache:      autocert.DirCache(t.AutocertCacheDir),
+			Email:      t.AutocertEmail,
+		}
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		challenges = manager.HTTPHandler(nil)
+		log.Printf("🔒 TLS with Let's Encrypt certificates for %v (cache %s)", t.AutocertDomains, t.AutocertCacheDir)
+	} else {
+		reloader, err := newCertReloader(t.CertFile, t.KeyFile)
+		if err != nil {
+			ln.Close()
+			return nil, nil, err
+		}
+		tlsConfig.GetCertificate = reloader.getCertificate
+		log.Printf("🔒 TLS with certificate %s", t.CertFile)
+	}
+
+	startRedirect := func() {
+		if t.RedirectPort != 0 {
+			go serveHTTPSRedirect(cfg.Host, t.RedirectPort, cfg.Port, challenges)
+		}
+	}
+	return tls.NewListener(ln, tlsConfig), startRedirect, nil
+}
+
+// serveHTTPSRedirect answers plain HTTP on redirectPort with a redirect to the HTTPS
+// port, and ACME http-01 challenges when autocert is on
+func serveHTTPSRedirect(host string, redirectPort, httpsPort int, challenges http.Handler) {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			target = r.Host
+		}
+		if httpsPort != 443 {
+			target = net.JoinHostPort(target, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+target+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	var handler http.Handler = redirect
+	if challenges != nil {
+		handler = challenges // Falls back to redirecting everything that is not a challenge
+	}
+
+	server := &http.Server{
+		Addr:              net.JoinHostPort(host, strconv.Itoa(redirectPort)),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("↪️ Redirecting HTTP on %s to HTTPS", server.Addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("⚠️ HTTP redirect server stopped: %v", err)
+	}
+}
+
+// certReloader serves a certificate from disk and reloads it when the file changes,
+// so renewed certificates are picked up without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = info.ModTime()
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate, checking the file at most once a minute
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) > time.Minute {
+		r.checked = time.Now()
+		if info, err := os.Stat(r.certFile); err == nil && !info.ModTime().Equal(r.modTime) {
+			if err := r.load(); err != nil {
+				log.Printf("⚠️ Keeping the old certificate, failed to reload %s: %v", r.certFile, err)
+			} else {
+				log.Printf("🔒 Reloaded certificate %s", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ToolEvent is one tool a command's provider ran, such as reading a file, editing it or
+// running a shell command
+type ToolEvent struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	CommandID  string `gorm:"index" json:"commandId"`
+	ToolUseID  string `json:"toolUseId"`                                     // ID the provider gave the call
+	Name       string `json:"name"`                                          // e.g. Read, Edit, Bash
+	Summary    string `gorm:"type:text;serializer:encrypted" json:"summary"` // File, shell command or pattern the tool was run on
+	Input      string `gorm:"type:text;serializer:encrypted" json:"-"`       // JSON arguments
+	Output     string `gorm:"type:text;serializer:encrypted" json:"output,omitempty"`
+	Status     string `json:"status"`    // running, succeeded, failed or unfinished
+	StartedAt  int64  `json:"startedAt"` // Unix milliseconds
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Statuses of tool events
+const (
+	ToolRunning    = "running"
+	ToolSucceeded  = "succeeded"
+	ToolFailed     = "failed"
+	ToolUnfinished = "unfinished" // The command ended before the tool reported a result
+)
+
+// maxToolOutput caps the stored output of a tool
+const maxToolOutput = 4096
+
+// Tools that read or change workspace files and run shell commands
+var (
+	fileReadTools   = []string{"Read", "NotebookRead"}
+	fileChangeTools = []string{"Edit", "MultiEdit", "Write", "NotebookEdit"}
+	shellTools      = []string{"Bash"}
+)
+
+// toolSummaryKeys are the input fields that say what a tool was run on, in order of
+// preference
+var toolSummaryKeys = []string{"file_path", "notebook_path", "command", "pattern", "path", "url", "query", "description"}
+
+// toolSummary returns what a tool was run on from its input, with paths relative to the
+// workspace
+func toolSummary(input json.RawMessage, workDir string) string {
+	var fields map[string]interface{}
+	if json.Unmarshal(input, &fields) != nil {
+		return ""
+	}
+	for _, key := range toolSummaryKeys {
+		value, ok := fields[key].(string)
+		if !ok || value == "" {
+			continue
+		}
+		if strings.HasSuffix(key, "_path") || key == "path" {
+			if rel, err := filepath.Rel(workDir, value); err == nil && !strings.HasPrefix(rel, "..") {
+				value = rel
+			}
+		}
+		return value
+	}
+	return ""
+}
+
+// truncateToolOutput cuts output to maxToolOutput bytes without splitting a character
+func truncateToolOutput(output string) string {
+	if len(output) <= maxToolOutput {
+		return output
+	}
+	cut := maxToolOutput
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut] + "…"
+}
+
+// toolTracker stores the tool events of one run of a command. Providers report output
+// from several goroutines, so it keeps the running tools under a lock.
+type toolTracker struct {
+	db      *gorm.DB
+	command *AICommand
+	workDir string
+
+	mu      sync.Mutex
+	running map[string]*ToolEvent // By tool use ID
+}
+
+func newToolTracker(db *gorm.DB, command *AICommand, workDir string) *toolTracker {
+	return &toolTracker{db: db, command: command, workDir: workDir, running: make(map[string]*ToolEvent)}
+}
+
+// start records a tool the provider started and returns its event
+func (t *toolTracker) start(tool *ProviderTool) *ToolEvent {
+	event := &ToolEvent{
+		CommandID: t.command.ID,
+		ToolUseID: tool.ID,
+		Name:      tool.Name,
+		Summary:   toolSummary(tool.Input, t.workDir),
+		Input:     string(tool.Input),
+		Status:    ToolRunning,
+		StartedAt: time.Now().UnixMilli(),
+	}
+	if err := t.db.Create(event).Error; err != nil {
+		log.Printf("⚠️ Failed to record tool %s of command [%s]: %v", tool.Name, t.command.ID, err)
+	}
+
+	// A tool without an ID can't get a result but is still closed as unfinished
+	key := tool.ID
+	if key == "" {
+		key = fmt.Sprintf("#%d", event.ID)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running[key] = event
+	return event
+}
+
+// finish records the result of a tool and returns its event, or nil for a result that
+// doesn't belong to a started tool
+func (t *toolTracker) finish(tool *ProviderTool) *ToolEvent {
+	t.mu.Lock()
+	event := t.running[tool.ID]
+	delete(t.running, tool.ID)
+	t.mu.Unlock()
+	if event == nil {
+		return nil
+	}
+
+	event.Status = ToolSucceeded
+	if tool.Failed {
+		event.Status = ToolFailed
+	}
+	event.Output = truncateToolOutput(tool.Output)
+	event.DurationMs = time.Now().UnixMilli() - event.StartedAt
+	t.save(event)
+	recordActivity(t.db, ActivityEvent{
+		Type:      ActivityTool,
+		Name:      event.Name,
+		Action:    event.Status,
+		Target:    event.Summary,
+		CommandID: t.command.ID,
+		ProjectID: t.command.ProjectID,
+	})
+	return event
+}
+
+// close marks the tools that never reported a result as unfinished
+func (t *toolTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, event := range t.running {
+		event.Status = ToolUnfinished
+		event.DurationMs = time.Now().UnixMilli() - event.StartedAt
+		t.save(event)
+		delete(t.running, id)
+	}
+}
+
+func (t *toolTracker) save(event *ToolEvent) {
+	if event.ID == 0 {
+		return
+	}
+	if err := t.db.Select("status", "output", "duration_ms").Updates(event).Error; err != nil {
+		log.Printf("⚠️ Failed to update tool %s of command [%s]: %v", event.Name, t.command.ID, err)
+	}
+}
+
+// CommandTool is a tool event with its input
+type CommandTool struct {
+	ToolEvent
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// CommandTools is the tool timeline of a command with the files it touched and the
+// shell commands it ran
+type CommandTools struct {
+	CommandID     string        `json:"commandId"`
+	Status        string        `json:"status"`
+	Tools         []CommandTool `json:"tools"`
+	FilesRead     []string      `json:"filesRead"`
+	FilesChanged  []string      `json:"filesChanged"`
+	ShellCommands []string      `json:"shellCommands"`
+}
+
+// GetCommandTools returns the tools a command ran, in the order they started. ?name=
+// (comma separated) keeps only the given tools.
+func GetCommandTools(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandID := c.Params("commandId")
+
+		var command AICommand
+		if err := db.First(&command, "id = ?", commandID).Error; err != nil {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", nil)
+		}
+
+		query := db.Where("command_id = ?", commandID).Order("id")
+		if names := c.Query("name"); names != "" {
+			query = query.Where("name IN ?", strings.Split(names, ","))
+		}
+		var events []ToolEvent
+		if err := query.Find(&events).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load command tools", err.Error())
+		}
+
+		data := CommandTools{
+			CommandID:     command.ID,
+			Status:        command.Status,
+			Tools:         make([]CommandTool, 0, len(events)),
+			FilesRead:     []string{},
+			FilesChanged:  []string{},
+			ShellCommands: []string{},
+		}
+		for _, event := range events {
+			tool := CommandTool{ToolEvent: event}
+			if json.Valid([]byte(event.Input)) {
+				tool.Input = json.RawMessage(event.Input)
+			}
+			data.Tools = append(data.Tools, tool)
+
+			if event.Summary == "" {
+				continue
+			}
+			switch {
+			case slices.Contains(fileReadTools, event.Name):
+				data.FilesRead = appendUnique(data.FilesRead, event.Summary)
+			case slices.Contains(fileChangeTools, event.Name):
+				data.FilesChanged = appendUnique(data.FilesChanged, event.Summary)
+			case slices.Contains(shellTools, event.Name):
+				data.ShellCommands = append(data.ShellCommands, event.Summary)
+			}
+		}
+
+		return c.JSON(APIResponse[CommandTools]{Success: true, Data: data})
+	}
+}
+
+// appendUnique appends value unless list already holds it
+func appendUnique(list []string, value string) []string {
+	if slices.Contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
+// publishTool records a tool the provider started or finished and streams it to the
+// client as a tool_use or tool_result message
+func publishTool(session *AICommandSession, tools *toolTracker, tool *ProviderTool) {
+	if !tool.Done {
+		event := tools.start(tool)
+		log.Printf("🔧 %s %s", event.Name, event.Summary)
+		session.publish(ProgressUpdate{
+			Type:      WSMsgTypeToolUse,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   strings.TrimSpace(event.Name + " " + event.Summary),
+			Data:      fiber.Map{"id": event.ToolUseID, "name": event.Name, "action": event.Summary},
+		})
+		return
+	}
+
+	event := tools.finish(tool)
+	if event == nil {
+		return
+	}
+	session.publish(ProgressUpdate{
+		Type:      WSMsgTypeToolResult,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Data: fiber.Map{
+			"id":         event.ToolUseID,
+			"name":       event.Name,
+			"status":     event.Status,
+			"durationMs": event.DurationMs,
+		},
+	})
+}
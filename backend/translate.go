@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ScopeTranslate is the scope of commands created by POST /api/ai/translate. They
+// answer with translated text instead of editing the workspace.
+const ScopeTranslate = "translate"
+
+// maxTranslateBlocks caps the blocks translated by one command
+const maxTranslateBlocks = 100
+
+// TranslateRequest asks for a block, or every block of a page, to be translated
+type TranslateRequest struct {
+	ContentID    string `json:"contentId,omitempty"`    // Block to translate
+	Page         string `json:"page,omitempty"`         // Page whose blocks are translated, instead of contentId
+	Locale       string `json:"locale"`                 // Target locale
+	SourceLocale string `json:"sourceLocale,omitempty"` // Defaults to the default locale
+	Provider     string `json:"provider,omitempty"`     // claude-cli, anthropic, openai (defaults to AI_PROVIDER)
+	ProjectID    string `json:"projectId,omitempty"`
+}
+
+// TranslationResult is the translation part of a translate command's result
+type TranslationResult struct {
+	SourceLocale string              `json:"sourceLocale"`
+	Locale       string              `json:"locale"`
+	Translated   int                 `json:"translated"`
+	Skipped      []ValidationProblem `json:"skipped"` // Blocks that were not written, by ID
+}
+
+// QueuedTranslation is the data of the response of POST /api/ai/translate
+type QueuedTranslation struct {
+	QueuedCommand
+	Locale       string `json:"locale"`
+	SourceLocale string `json:"sourceLocale"`
+	Blocks       int    `json:"blocks"` // Blocks to translate
+}
+
+// TranslateContent queues a command that translates a content block (contentId) or
+// all blocks of a page into a locale. Like other commands it runs once a client
+// connects to its stream; the translations are saved as drafts of that locale.
+func TranslateContent(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req TranslateRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if (req.ContentID == "") == (req.Page == "") {
+			return sendError(c, 400, "INVALID_TARGET", "Either contentId or page is required", "")
+		}
+
+		locale, err := normalizeLocale(req.Locale)
+		if err != nil {
+			return sendError(c, 400, "INVALID_LOCALE", "Invalid target locale", err.Error())
+		}
+		source := getDefaultLocale()
+		if req.SourceLocale != "" {
+			if source, err = normalizeLocale(req.SourceLocale); err != nil {
+				return sendError(c, 400, "INVALID_LOCALE", "Invalid source locale", err.Error())
+			}
+		}
+		if source == locale {
+			return sendError(c, 400, "INVALID_LOCALE", "The target locale must differ from the source locale", "")
+		}
+
+		if req.Provider == "" {
+			req.Provider = getDefaultProvider()
+		}
+		if _, err := getProvider(req.Provider); err != nil {
+			return sendError(c, 400, "INVALID_PROVIDER", "Invalid AI provider", err.Error())
+		}
+		if _, err := resolveWorkspaceDir(db, req.ProjectID); err != nil {
+			if errors.Is(err, errProjectNotFound) {
+				return sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", err.Error())
+			}
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+		}
+		if err := checkBudget(db, req.ProjectID); err != nil {
+			return budgetErrorResponse(c, err)
+		}
+		if err := checkQuota(db, currentUserID(c), req.ProjectID); err != nil {
+			return quotaErrorResponse(c, err)
+		}
+
+		command := &AICommand{
+			ID:           fmt.Sprintf("cmd_%d_%s", time.Now().Unix(), uuid.New().String()[:8]),
+			Scope:        ScopeTranslate,
+			Provider:     req.Provider,
+			Page:         req.Page,
+			ProjectID:    req.ProjectID,
+			Status:       "queued",
+			Priority:     PriorityNormal,
+			CreatedAt:    time.Now().Unix(),
+			ComponentID:  req.ContentID,
+			Locale:       locale,
+			SourceLocale: source,
+		}
+		if user := currentUser(c); user != nil {
+			command.UserID = user.ID
+		}
+
+		sources, err := translationSources(db, command)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load content", err.Error())
+		}
+		if len(sources) == 0 {
+			return sendError(c, 404, "CONTENT_NOT_FOUND", "No content to translate in locale "+source, "")
+		}
+		if len(sources) > maxTranslateBlocks {
+			return sendError(c, 400, "TOO_MANY_BLOCKS", fmt.Sprintf("A translation covers at most %d blocks", maxTranslateBlocks), "")
+		}
+
+		target := req.Page
+		if req.ContentID != "" {
+			target = req.ContentID
+			command.Page = sources[0].Page
+		}
+		command.Prompt = fmt.Sprintf("Translate %s from %s to %s", target, source, locale)
+
+		if err := db.Create(command).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to create command", err.Error())
+		}
+		if err := dispatchCommand(db, command); err != nil {
+			return queueErrorResponse(c, err)
+		}
+		log.Printf("🌐 Translation [%s] queued: %d block(s) of %s, %s -> %s", command.ID, len(sources), target, source, locale)
+		recordAudit(db, c, AuditCommandExecute, command.ID, nil, command.Prompt, fmt.Sprintf("scope %s, %d block(s)", ScopeTranslate, len(sources)))
+
+		return c.JSON(APIResponse[QueuedTranslation]{
+			Success: true,
+			Message: "Translation queued successfully",
+			Data: QueuedTranslation{
+				QueuedCommand: QueuedCommand{
+					CommandID: command.ID,
+					Status:    command.Status,
+					Message:   "Connect to WebSocket to receive real-time updates",
+				}.withStream(c, command.UserID),
+				Locale:       locale,
+				SourceLocale: source,
+				Blocks:       len(sources),
+			},
+		})
+	}
+}
+
+// translationSources loads the blocks a translate command covers in its source locale.
+// Blocks without any text are left out. One more than maxTranslateBlocks is loaded
+// so callers can tell when there are too many.
+func translationSources(db *gorm.DB, command *AICommand) ([]Content, error) {
+	query := db.Where("locale = ?", command.SourceLocale)
+	if command.ComponentID != "" {
+		query = query.Where("id = ?", command.ComponentID)
+	} else {
+		query = query.Where("page = ?", command.Page)
+	}
+
+	var contents []Content
+	if err := query.Order("id").Limit(maxTranslateBlocks + 1).Find(&contents).Error; err != nil {
+		return nil, err
+	}
+	sources := contents[:0]
+	for _, content := range contents {
+		if strings.TrimSpace(draftContent(content)) != "" {
+			sources = append(sources, content)
+		}
+	}
+	return sources, nil
+}
+
+// prepareTranslation loads the blocks of a translate command and builds its prompt
+func prepareTranslation(db *gorm.DB, command *AICommand) ([]Content, string, error) {
+	sources, err := translationSources(db, command)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(sources) == 0 {
+		return nil, "", fmt.Errorf("no content to translate in locale %s", command.SourceLocale)
+	}
+	if len(sources) > maxTranslateBlocks {
+		sources = sources[:maxTranslateBlocks]
+	}
+	return sources, translationPrompt(command, sources), nil
+}
+
+// translationPrompt asks the model to answer with a JSON object of translations
+func translationPrompt(command *AICommand, sources []Content) string {
+	type block struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Content string `json:"content"`
+	}
+	blocks := make([]block, 0, len(sources))
+	for _, content := range sources {
+		blocks = append(blocks, block{ID: content.ID, Type: content.Type, Content: draftContent(content)})
+	}
+	data, _ := json.MarshalIndent(blocks, "", "  ")
+
+	return fmt.Sprintf("Translate the content blocks below from %s to %s for a website. "+
+		"Translate only human-readable text: keep HTML tags and attributes, entities, Markdown syntax, URLs and placeholders unchanged, "+
+		"and for json blocks keep the keys and translate only string values. Do not edit any files and do not ask questions. "+
+		"Answer with a single JSON object that maps every block id to its translated content, and nothing else.\n\n%s",
+		command.SourceLocale, command.Locale, data)
+}
+
+// parseTranslations extracts the JSON object of translations from the model's answer,
+// which may be wrapped in a code fence or surrounded by text
+func parseTranslations(answer string) (map[string]string, error) {
+	start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("the answer contains no JSON object")
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("the answer is not a JSON object of translations: %w", err)
+	}
+
+	translations := make(map[string]string, len(raw))
+	for id, value := range raw {
+		var text string
+		if json.Unmarshal(value, &text) != nil {
+			text = string(value) // A json block answered as a value instead of a string
+		}
+		translations[id] = text
+	}
+	return translations, nil
+}
+
+// applyTranslations saves the translations in the model's answer as drafts of the
+// target locale. Blocks that are missing from the answer, locked or rejected by
+// their type are skipped and listed in the result.
+func applyTranslations(db *gorm.DB, command *AICommand, sources []Content, answer string) (*CommandResult, error) {
+	translations, err := parseTranslations(answer)
+	if err != nil {
+		return nil, err
+	}
+
+	translation := &TranslationResult{
+		SourceLocale: command.SourceLocale,
+		Locale:       command.Locale,
+		Skipped:      []ValidationProblem{},
+	}
+	result := &CommandResult{
+		AffectedPages: []string{},
+		Changes:       []FileChange{},
+		PagesCreated:  []CreatedPage{},
+		ContentBlocks: []ContentBlockChange{},
+		FollowUps:     []string{},
+		Translation:   translation,
+	}
+	pages := make(map[string]bool)
+	var undo []CommandUndoEntry
+	for _, source := range sources {
+		text, ok := translations[source.ID]
+		if !ok || strings.TrimSpace(text) == "" {
+			translation.Skipped = append(translation.Skipped, ValidationProblem{Field: source.ID, Code: "missing", Message: "not in the model's answer"})
+			continue
+		}
+
+		var previous Content
+		if err := db.Limit(1).Find(&previous, "id = ? AND locale = ?", source.ID, command.Locale).Error; err != nil {
+			return nil, err
+		}
+		content, _, err := saveContent(db, source.ID, ContentRequest{Content: text, Locale: command.Locale})
+		if err != nil {
+			translation.Skipped = append(translation.Skipped, translationSkip(source.ID, err))
+			continue
+		}
+		undo = append(undo, contentUndoEntry(command.ID, previous, content))
+		broadcastContent(ContentMsgUpdated, content, command.ID)
+
+		action := "updated"
+		if content.Version == 1 {
+			action = "created"
+		}
+		result.ContentBlocks = append(result.ContentBlocks, ContentBlockChange{
+			ID:          content.ID,
+			Page:        content.Page,
+			Action:      action,
+			Description: fmt.Sprintf("Translated from %s to %s", command.SourceLocale, command.Locale),
+		})
+		if content.Page != "" && !pages[content.Page] {
+			pages[content.Page] = true
+			result.AffectedPages = append(result.AffectedPages, content.Page)
+		}
+		translation.Translated++
+	}
+
+	saveUndoEntries(db, command.ID, undo)
+	if translation.Translated == 0 {
+		return nil, fmt.Errorf("no block could be translated (%d skipped)", len(translation.Skipped))
+	}
+	result.Action = fmt.Sprintf("Translated %d of %d block(s) from %s to %s", translation.Translated, len(sources), command.SourceLocale, command.Locale)
+	for _, skip := range translation.Skipped {
+		log.Printf("⚠️ Translation [%s] skipped %s: %s", command.ID, skip.Field, skip.Message)
+	}
+	return result, nil
+}
+
+// translationSkip describes why saving a translated block failed
+func translationSkip(id string, err error) ValidationProblem {
+	var locked *ContentLockedError
+	var invalid *ContentValidationError
+	var trashed *ContentTrashedError
+	switch {
+	case errors.As(err, &locked):
+		return ValidationProblem{Field: id, Code: "locked", Message: "locked by " + locked.Lock.Holder}
+	case errors.As(err, &invalid):
+		return ValidationProblem{Field: id, Code: "invalid", Message: "the translation does not match the block's type"}
+	case errors.As(err, &trashed):
+		return ValidationProblem{Field: id, Code: "trashed", Message: "the translation is in the trash"}
+	}
+	return ValidationProblem{Field: id, Code: "save_failed", Message: err.Error()}
+}
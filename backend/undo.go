@@ -0,0 +1,564 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Kinds of CommandUndoEntry
+const (
+	UndoKindFile    = "file"
+	UndoKindContent = "content"
+)
+
+// maxUndoCount caps the commands undone by one POST /api/ai/undo
+const maxUndoCount = 20
+
+// CommandUndoEntry is a workspace file or content block a command modified, with
+// what it looked like before so the command can be undone
+type CommandUndoEntry struct {
+	ID         uint   `gorm:"primaryKey"`
+	CommandID  string `gorm:"index"`
+	Kind       string // file, content
+	Target     string // Workspace path or content block ID
+	Locale     string // Translation of a content block
+	Existed    bool   // False when the command created it
+	Before     string `gorm:"type:text"` // File content, or the JSON of the content row
+	InSnapshot bool   // The file was too large to keep here and is restored from the workspace snapshot
+	AfterHash  string // State the command left behind; "" when it deleted the file
+}
+
+// UndoRequest is the optional body of POST /api/ai/command/:commandId/undo
+type UndoRequest struct {
+	Chain bool `json:"chain"` // Also undo the later commands on the same page, newest first
+	Force bool `json:"force"` // Overwrite changes made by hand since the command ran
+}
+
+// PageUndoRequest is the body of POST /api/ai/undo
+type PageUndoRequest struct {
+	Page      string `json:"page"`
+	ProjectID string `json:"projectId,omitempty"`
+	Count     int    `json:"count"` // Commands to undo, newest first (default 1)
+	Force     bool   `json:"force"`
+}
+
+// UndoResult reports what undoing one command restored
+type UndoResult struct {
+	CommandID string `json:"commandId"`
+	Files     int    `json:"files"`
+	Blocks    int    `json:"blocks"`
+	UndoneAt  int64  `json:"undoneAt"`
+}
+
+// UndoResponse is the data of the undo endpoints: the commands undone, newest first
+type UndoResponse struct {
+	Page   string        `json:"page,omitempty"`
+	Undone []*UndoResult `json:"undone"`
+}
+
+// UndoStackEntry is a command that can be undone
+type UndoStackEntry struct {
+	CommandID   string `json:"commandId"`
+	Prompt      string `json:"prompt"`
+	Scope       string `json:"scope"`
+	CompletedAt int64  `json:"completedAt"`
+	Files       int    `json:"files"`  // Files the command changed
+	Blocks      int    `json:"blocks"` // Content blocks the command changed
+}
+
+// UndoStack is the data of GET /api/ai/undo
+type UndoStack struct {
+	Page     string           `json:"page"`
+	Commands []UndoStackEntry `json:"commands"`
+}
+
+// UndoConflictError is returned when files or blocks changed since the command ran
+type UndoConflictError struct {
+	Targets []string
+}
+
+func (e *UndoConflictError) Error() string {
+	return fmt.Sprintf("%d file(s) or block(s) changed since the command ran", len(e.Targets))
+}
+
+// errUndoUnavailable is returned when a large file's snapshot has been pruned
+var errUndoUnavailable = errors.New("the workspace snapshot needed to restore a large file no longer exists")
+
+// fileHash fingerprints a workspace file like snapshotWorkspace; "" when it does not exist
+func fileHash(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// contentStateHash fingerprints the draft of a content block; "" when there is none
+func contentStateHash(content Content) string {
+	if content.ID == "" {
+		return ""
+	}
+	return auditHash(struct {
+		Content  string `json:"content"`
+		IsEdited bool   `json:"is_edited"`
+	}{draftContent(content), content.IsEdited})
+}
+
+// fileUndoEntries records the files a command changed with their state before it ran
+func fileUndoEntries(commandID string, before, after WorkspaceSnapshot, changes []FileChange) []CommandUndoEntry {
+	entries := make([]CommandUndoEntry, 0, len(changes))
+	for _, change := range changes {
+		entry := CommandUndoEntry{
+			CommandID: commandID,
+			Kind:      UndoKindFile,
+			Target:    change.Target,
+			Existed:   change.Type != "created",
+			AfterHash: after[change.Target].Hash,
+		}
+		if entry.Existed {
+			if state := before[change.Target]; state.Content != nil {
+				entry.Before = *state.Content
+			} else {
+				entry.InSnapshot = true
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// contentUndoEntry records a content block as it was before a command saved it
+func contentUndoEntry(commandID string, previous, saved Content) CommandUndoEntry {
+	entry := CommandUndoEntry{
+		CommandID: commandID,
+		Kind:      UndoKindContent,
+		Target:    saved.ID,
+		Locale:    saved.Locale,
+		Existed:   previous.ID != "",
+		AfterHash: contentStateHash(saved),
+	}
+	if entry.Existed {
+		data, _ := json.Marshal(previous)
+		entry.Before = string(data)
+	}
+	return entry
+}
+
+// saveUndoEntries stores the undo entries of a command; failures only cost the undo
+func saveUndoEntries(db *gorm.DB, commandID string, entries []CommandUndoEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := db.Create(&entries).Error; err != nil {
+		log.Printf("⚠️ Failed to record undo entries for command [%s]: %v", commandID, err)
+	}
+}
+
+// undoableCommands returns the commands on a page that can still be undone, newest
+// first. Commands are ordered by when their changes were recorded, which is finer
+// than their timestamps.
+func undoableCommands(db *gorm.DB, projectID, page string, after int64, limit int) ([]AICommand, error) {
+	var commands []AICommand
+	err := db.Where("project_id = ? AND page = ? AND status = ? AND undone_at = 0 AND rolled_back_at = 0 AND created_at >= ?",
+		projectID, page, "completed", after).
+		Where("id IN (?)", db.Model(&CommandUndoEntry{}).Select("command_id")).
+		Order("(SELECT MAX(e.id) FROM command_undo_entries e WHERE e.command_id = ai_commands.id) DESC").
+		Limit(limit).Find(&commands).Error
+	return commands, err
+}
+
+// archivedFile reads one file from a workspace snapshot
+func archivedFile(archive, name string) ([]byte, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, os.ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && header.Name == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// undoCommand puts the files and content blocks a command modified back to what they
+// were before it ran. Unless force is set, nothing is restored when any of them
+// changed since.
+func undoCommand(db *gorm.DB, command *AICommand, workspaceDir string, force bool) (*UndoResult, error) {
+	var entries []CommandUndoEntry
+	if err := db.Where("command_id = ?", command.ID).Order("id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	// Check everything before touching anything
+	var conflicts []string
+	for _, entry := range entries {
+		var current string
+		switch entry.Kind {
+		case UndoKindFile:
+			target := filepath.Join(workspaceDir, filepath.FromSlash(entry.Target))
+			if !isWithinDir(workspaceDir, target) {
+				return nil, fmt.Errorf("undo entry %s escapes the workspace", entry.Target)
+			}
+			if entry.InSnapshot && !snapshotExists(command.SnapshotPath) {
+				return nil, errUndoUnavailable
+			}
+			current = fileHash(target)
+		case UndoKindContent:
+			if lock, locked := lockHeldByOther(entry.Target, ""); locked {
+				return nil, &ContentLockedError{Lock: lock}
+			}
+			var content Content
+			if err := db.Limit(1).Find(&content, "id = ? AND locale = ?", entry.Target, entry.Locale).Error; err != nil {
+				return nil, err
+			}
+			current = contentStateHash(content)
+		}
+		if current != entry.AfterHash {
+			conflicts = append(conflicts, entry.Target)
+		}
+	}
+	if len(conflicts) > 0 && !force {
+		return nil, &UndoConflictError{Targets: conflicts}
+	}
+
+	result := &UndoResult{CommandID: command.ID, UndoneAt: time.Now().Unix()}
+	var restored []Content
+	var removed []Content
+	for _, entry := range entries {
+		if entry.Kind != UndoKindFile {
+			continue
+		}
+		target := filepath.Join(workspaceDir, filepath.FromSlash(entry.Target))
+		if !entry.Existed {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			result.Files++
+			continue
+		}
+		data := []byte(entry.Before)
+		if entry.InSnapshot {
+			var err error
+			if data, err = archivedFile(command.SnapshotPath, entry.Target); err != nil {
+				return nil, fmt.Errorf("failed to read %s from the snapshot: %w", entry.Target, err)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return nil, err
+		}
+		result.Files++
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, entry := range entries {
+			if entry.Kind != UndoKindContent {
+				continue
+			}
+			var content Content
+			if err := tx.Limit(1).Find(&content, "id = ? AND locale = ?", entry.Target, entry.Locale).Error; err != nil {
+				return err
+			}
+			if content.ID == "" {
+				continue // Deleted since; there is nothing left to undo
+			}
+			if !entry.Existed {
+				// A translation the command created goes away with it
+				if err := tx.Unscoped().Delete(&content).Error; err != nil {
+					return err
+				}
+				removed = append(removed, content)
+				result.Blocks++
+				continue
+			}
+
+			var previous Content
+			if err := json.Unmarshal([]byte(entry.Before), &previous); err != nil {
+				return err
+			}
+			content.EditedContent = previous.EditedContent
+			content.IsEdited = previous.IsEdited
+			content.Version++
+			content.UpdatedAt = time.Now().Unix()
+			if err := tx.Model(&Content{}).Where("id = ? AND locale = ?", content.ID, content.Locale).Updates(map[string]interface{}{
+				"edited_content": content.EditedContent,
+				"is_edited":      content.IsEdited,
+				"version":        content.Version,
+				"updated_at":     content.UpdatedAt,
+			}).Error; err != nil {
+				return err
+			}
+			restored = append(restored, content)
+			result.Blocks++
+		}
+		return tx.Model(&AICommand{}).Where("id = ?", command.ID).Update("undone_at", result.UndoneAt).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	command.UndoneAt = result.UndoneAt
+
+	// Notify subscribers only once the transaction has committed
+	for _, content := range restored {
+		broadcastContent(ContentMsgUpdated, content, command.ID)
+	}
+	for _, content := range removed {
+		broadcastContent(ContentMsgDeleted, content, command.ID)
+	}
+	if result.Files > 0 && isGitAutoCommitEnabled() && isGitRepo(workspaceDir) {
+		if _, err := gitCommitAll(workspaceDir, fmt.Sprintf("Undo AI: %s\n\nCommand: %s", command.Prompt, command.ID)); err != nil {
+			log.Printf("⚠️ Git commit of undo failed [%s]: %v", command.ID, err)
+		}
+	}
+	log.Printf("↩️ Undid command [%s]: %d file(s), %d block(s)", command.ID, result.Files, result.Blocks)
+	return result, nil
+}
+
+// undoCommands undoes commands in the given order (newest first), stopping at the
+// first failure. It returns the commands undone so far.
+func undoCommands(c *fiber.Ctx, db *gorm.DB, commands []AICommand, force bool) ([]*UndoResult, error) {
+	results := []*UndoResult{}
+	for i := range commands {
+		command := &commands[i]
+		workspaceDir, err := resolveWorkspaceDir(db, command.ProjectID)
+		if err != nil {
+			return results, err
+		}
+		result, err := undoCommand(db, command, workspaceDir, force)
+		if err != nil {
+			return results, fmt.Errorf("command %s: %w", command.ID, err)
+		}
+		recordAudit(db, c, AuditCommandUndo, command.ID, nil, nil, fmt.Sprintf("%d file(s), %d block(s)", result.Files, result.Blocks))
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// undoErrorResponse maps an undoCommands error to an HTTP response, listing the
+// commands undone before it
+func undoErrorResponse(c *fiber.Ctx, err error, undone []*UndoResult) error {
+	status, code, message := 500, "UNDO_FAILED", "Failed to undo the command"
+	var details interface{} = err.Error()
+
+	var conflict *UndoConflictError
+	var locked *ContentLockedError
+	switch {
+	case errors.As(err, &conflict):
+		status, code = 409, "UNDO_CONFLICT"
+		message = "Files or content blocks changed since the command ran; send {\"force\": true} to overwrite them"
+		details = conflict.Targets
+	case errors.As(err, &locked):
+		status, code, message = 423, "CONTENT_LOCKED", "A content block of the command is being edited by someone else"
+	case errors.Is(err, errUndoUnavailable):
+		status, code, message = 409, "UNDO_UNAVAILABLE", "The command can no longer be undone"
+	case errors.Is(err, errProjectNotFound):
+		status, code, message = 404, "PROJECT_NOT_FOUND", "Project not found"
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   newAPIError(status, code, message, details),
+		"data": fiber.Map{
+			"undone": undone,
+		},
+	})
+}
+
+// UndoAICommand restores what a command changed. Later commands on the same page
+// that can still be undone must be undone with it ({"chain": true}).
+func UndoAICommand(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandID := c.Params("commandId")
+
+		var req UndoRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+			}
+		}
+
+		var command AICommand
+		if err := db.First(&command, "id = ?", commandID).Error; err != nil {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", "")
+		}
+		if command.UndoneAt != 0 {
+			return sendError(c, 409, "ALREADY_UNDONE", "This command has already been undone", "")
+		}
+		if command.RolledBackAt != 0 {
+			return sendError(c, 409, "ALREADY_ROLLED_BACK", "This command has been rolled back", "")
+		}
+		if command.Status != "completed" {
+			return sendError(c, 409, "NOT_UNDOABLE", "Only completed commands can be undone", "Current status: "+command.Status)
+		}
+		if workspaceBusy(command.ProjectID) {
+			return sendError(c, 409, "WORKSPACE_BUSY", "A command is running in this workspace", "")
+		}
+
+		var entries int64
+		if err := db.Model(&CommandUndoEntry{}).Where("command_id = ?", command.ID).Count(&entries).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load the undo stack", err.Error())
+		}
+		if entries == 0 {
+			return sendError(c, 409, "NOTHING_TO_UNDO", "The command did not change any files or content blocks", "")
+		}
+		stack, err := undoableCommands(db, command.ProjectID, command.Page, command.CreatedAt, maxUndoCount+1)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load the undo stack", err.Error())
+		}
+		var chain []AICommand
+		found := false
+		for _, entry := range stack {
+			chain = append(chain, entry)
+			if entry.ID == command.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return sendError(c, 409, "TOO_MANY_COMMANDS", fmt.Sprintf("More than %d later commands changed this page", maxUndoCount), "")
+		}
+		if len(chain) > 1 && !req.Chain {
+			ids := make([]string, 0, len(chain)-1)
+			for _, later := range chain[:len(chain)-1] {
+				ids = append(ids, later.ID)
+			}
+			return sendError(c, 409, "NEWER_COMMANDS", "Later commands changed this page; send {\"chain\": true} to undo them too", ids)
+		}
+
+		undone, err := undoCommands(c, db, chain, req.Force)
+		if err != nil {
+			log.Printf("❌ Undo of command [%s] failed: %v", command.ID, err)
+			return undoErrorResponse(c, err, undone)
+		}
+		return c.JSON(APIResponse[UndoResponse]{
+			Success: true,
+			Data:    UndoResponse{Undone: undone},
+		})
+	}
+}
+
+// UndoPageCommands undoes the last count commands on a page, newest first
+func UndoPageCommands(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req PageUndoRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if req.Page == "" {
+			return sendError(c, 400, "MISSING_PAGE", "page is required", "")
+		}
+		if req.Count == 0 {
+			req.Count = 1
+		}
+		if req.Count < 1 || req.Count > maxUndoCount {
+			return sendError(c, 400, "INVALID_COUNT", fmt.Sprintf("count must be between 1 and %d", maxUndoCount), "")
+		}
+		if workspaceBusy(req.ProjectID) {
+			return sendError(c, 409, "WORKSPACE_BUSY", "A command is running in this workspace", "")
+		}
+
+		stack, err := undoableCommands(db, req.ProjectID, req.Page, 0, req.Count)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load the undo stack", err.Error())
+		}
+		if len(stack) == 0 {
+			return sendError(c, 409, "NOTHING_TO_UNDO", "No command on this page can be undone", "")
+		}
+
+		undone, err := undoCommands(c, db, stack, req.Force)
+		if err != nil {
+			log.Printf("❌ Undo on page %s failed: %v", req.Page, err)
+			return undoErrorResponse(c, err, undone)
+		}
+		return c.JSON(APIResponse[UndoResponse]{
+			Success: true,
+			Data:    UndoResponse{Page: req.Page, Undone: undone},
+		})
+	}
+}
+
+// GetUndoStack lists the commands on a page that can be undone, newest first
+// (?page=&projectId=)
+func GetUndoStack(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page := c.Query("page")
+		if page == "" {
+			return sendError(c, 400, "MISSING_PAGE", "page is required", "")
+		}
+
+		stack, err := undoableCommands(db, c.Query("projectId"), page, 0, maxUndoCount)
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load the undo stack", err.Error())
+		}
+
+		type entryCount struct {
+			CommandID string
+			Kind      string
+			Count     int
+		}
+		ids := make([]string, 0, len(stack))
+		for _, command := range stack {
+			ids = append(ids, command.ID)
+		}
+		var counts []entryCount
+		db.Model(&CommandUndoEntry{}).Select("command_id, kind, count(*) as count").
+			Where("command_id IN ?", ids).Group("command_id, kind").Scan(&counts)
+		files := make(map[string]int, len(stack))
+		blocks := make(map[string]int, len(stack))
+		for _, count := range counts {
+			if count.Kind == UndoKindFile {
+				files[count.CommandID] = count.Count
+			} else {
+				blocks[count.CommandID] = count.Count
+			}
+		}
+
+		items := make([]UndoStackEntry, 0, len(stack))
+		for _, command := range stack {
+			items = append(items, UndoStackEntry{
+				CommandID:   command.ID,
+				Prompt:      command.Prompt,
+				Scope:       command.Scope,
+				CompletedAt: command.CompletedAt,
+				Files:       files[command.ID],
+				Blocks:      blocks[command.ID],
+			})
+		}
+		return c.JSON(APIResponse[UndoStack]{
+			Success: true,
+			Data: UndoStack{
+				Page:     page,
+				Commands: items,
+			},
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// modelPrice is the list price of a model in USD per million tokens
+type modelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// modelPrices is used to estimate the cost of providers that only report tokens.
+// Keys are model name prefixes; the longest match wins.
+var modelPrices = map[string]modelPrice{
+	"claude-opus-4-5":  {5, 25},
+	"claude-opus-4":    {15, 75},
+	"claude-sonnet-4":  {3, 15},
+	"claude-haiku-4":   {1, 5},
+	"claude-3-5-haiku": {0.8, 4},
+	"gpt-4o-mini":      {0.15, 0.6},
+	"gpt-4o":           {2.5, 10},
+	"gpt-4.1-mini":     {0.4, 1.6},
+	"gpt-4.1":          {2, 8},
+}
+
+// UsageTotals sums the usage of a set of commands
+type UsageTotals struct {
+	Key          string  `json:"key,omitempty"` // Group value when grouped
+	Commands     int     `json:"commands"`
+	InputTokens  int64   `json:"inputTokens"`
+	OutputTokens int64   `json:"outputTokens"`
+	CostUSD      float64 `json:"costUsd"`
+}
+
+func (t *UsageTotals) add(command AICommand) {
+	t.Commands++
+	t.InputTokens += command.InputTokens
+	t.OutputTokens += command.OutputTokens
+	t.CostUSD += command.CostUSD
+}
+
+// BudgetStatus is the spend of the current month against a monthly limit
+type BudgetStatus struct {
+	Scope     string  `json:"scope"` // global or project
+	ProjectID string  `json:"projectId,omitempty"`
+	LimitUSD  float64 `json:"limitUsd"`
+	SpentUSD  float64 `json:"spentUsd"`
+	Exceeded  bool    `json:"exceeded"`
+	ResetsAt  int64   `json:"resetsAt"`
+}
+
+// errBudgetExceeded is wrapped by checkBudget when a monthly limit has been reached
+var errBudgetExceeded = errors.New("monthly AI budget exceeded")
+
+// getPriceOverride returns a per-million-token price from key, or -1 when unset
+func getPriceOverride(key string) float64 {
+	if price, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && price >= 0 {
+		return price
+	}
+	return -1
+}
+
+// priceForModel returns the price of a model. AI_PRICE_INPUT_PER_MTOK and
+// AI_PRICE_OUTPUT_PER_MTOK override the built-in table for every model.
+func priceForModel(model string) (modelPrice, bool) {
+	var price modelPrice
+	match := ""
+	for prefix, p := range modelPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(match) {
+			price, match = p, prefix
+		}
+	}
+
+	known := match != ""
+	if input := getPriceOverride("AI_PRICE_INPUT_PER_MTOK"); input >= 0 {
+		price.Input, known = input, true
+	}
+	if output := getPriceOverride("AI_PRICE_OUTPUT_PER_MTOK"); output >= 0 {
+		price.Output, known = output, true
+	}
+	return price, known
+}
+
+// estimateCost prefers the cost reported by the provider and otherwise prices the tokens
+func estimateCost(usage *ProviderUsage) float64 {
+	if usage.CostUSD > 0 {
+		return usage.CostUSD
+	}
+	price, ok := priceForModel(usage.Model)
+	if !ok {
+		return 0
+	}
+	return (float64(usage.InputTokens)*price.Input + float64(usage.OutputTokens)*price.Output) / 1e6
+}
+
+// recordCommandUsage stores the usage reported for a command
+func recordCommandUsage(db *gorm.DB, command *AICommand, usage *ProviderUsage) {
+	command.Model = usage.Model
+	command.InputTokens = usage.InputTokens
+	command.OutputTokens = usage.OutputTokens
+	command.CostUSD = estimateCost(usage)
+
+	err := db.Model(&AICommand{}).Where("id = ?", command.ID).Updates(map[string]interface{}{
+		"model":         command.Model,
+		"input_tokens":  command.InputTokens,
+		"output_tokens": command.OutputTokens,
+		"cost_usd":      command.CostUSD,
+	}).Error
+	if err != nil {
+		log.Printf("⚠️ Failed to record usage of command [%s]: %v", command.ID, err)
+		return
+	}
+	log.Printf("🪙 Command [%s] used %d input / %d output tokens (%s, $%.4f)",
+		command.ID, command.InputTokens, command.OutputTokens, command.Model, command.CostUSD)
+}
+
+// getMonthlyBudget returns the spend limit for all commands from AI_MONTHLY_BUDGET_USD
+// Falls back to 0 (no limit)
+func getMonthlyBudget() float64 {
+	if budget, err := strconv.ParseFloat(os.Getenv("AI_MONTHLY_BUDGET_USD"), 64); err == nil && budget > 0 {
+		return budget
+	}
+	return 0
+}
+
+// projectMonthlyBudget returns the project's "monthlyBudgetUsd" setting, 0 when unset
+func projectMonthlyBudget(project *Project) float64 {
+	switch budget := project.Settings["monthlyBudgetUsd"].(type) {
+	case float64:
+		return budget
+	case string:
+		if n, err := strconv.ParseFloat(budget, 64); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// monthBounds returns the start of the month containing t and the start of the next, in UTC
+func monthBounds(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// spentSince sums the cost of commands created since the given time; projectID nil means all projects
+func spentSince(db *gorm.DB, since time.Time, projectID *string) (float64, error) {
+	query := db.Model(&AICommand{}).Where("created_at >= ?", since.Unix())
+	if projectID != nil {
+		query = query.Where("project_id = ?", *projectID)
+	}
+	var spent float64
+	err := query.Select("COALESCE(SUM(cost_usd), 0)").Scan(&spent).Error
+	return spent, err
+}
+
+// budgetStatuses returns the global budget and, for a project, its own budget, if set
+func budgetStatuses(db *gorm.DB, projectID string) ([]BudgetStatus, error) {
+	start, end := monthBounds(time.Now())
+	var statuses []BudgetStatus
+
+	if limit := getMonthlyBudget(); limit > 0 {
+		spent, err := spentSince(db, start, nil)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, BudgetStatus{
+			Scope: "global", LimitUSD: limit, SpentUSD: spent, Exceeded: spent >= limit, ResetsAt: end.Unix(),
+		})
+	}
+
+	if projectID != "" {
+		var project Project
+		if err := db.Limit(1).Find(&project, "id = ?", projectID).Error; err != nil {
+			return nil, err
+		}
+		if limit := projectMonthlyBudget(&project); project.ID != "" && limit > 0 {
+			spent, err := spentSince(db, start, &projectID)
+			if err != nil {
+				return nil, err
+			}
+			statuses = append(statuses, BudgetStatus{
+				Scope: "project", ProjectID: projectID, LimitUSD: limit, SpentUSD: spent, Exceeded: spent >= limit, ResetsAt: end.Unix(),
+			})
+		}
+	}
+	return statuses, nil
+}
+
+// checkBudget returns an error wrapping errBudgetExceeded when a new command of the
+// project would go over a monthly budget
+func checkBudget(db *gorm.DB, projectID string) error {
+	statuses, err := budgetStatuses(db, projectID)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Exceeded {
+			return fmt.Errorf("%w: %s budget of $%.2f reached ($%.2f spent this month)",
+				errBudgetExceeded, status.Scope, status.LimitUSD, status.SpentUSD)
+		}
+	}
+	return nil
+}
+
+// budgetErrorResponse rejects a command submission because of checkBudget
+func budgetErrorResponse(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errBudgetExceeded) {
+		return sendError(c, 402, "BUDGET_EXCEEDED", "Monthly AI budget exceeded", err.Error())
+	}
+	return sendError(c, 500, "DATABASE_ERROR", "Failed to check AI budget", err.Error())
+}
+
+// parseUsageTime accepts RFC 3339 timestamps and YYYY-MM-DD dates (UTC midnight)
+func parseUsageTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// usageGroups lists the accepted values of ?groupBy=
+var usageGroups = map[string]bool{"day": true, "user": true, "project": true, "provider": true, "model": true}
+
+// usageGroupKey returns the value a command is grouped by
+func usageGroupKey(command AICommand, groupBy string) string {
+	switch groupBy {
+	case "day":
+		return time.Unix(command.CreatedAt, 0).UTC().Format("2006-01-02")
+	case "user":
+		return command.UserID
+	case "project":
+		return command.ProjectID
+	case "provider":
+		return command.Provider
+	case "model":
+		return command.Model
+	}
+	return ""
+}
+
+// GetAIUsage aggregates token usage and cost of commands between ?from= and ?to=
+// (default: the current month), optionally filtered by ?userId= and ?projectId= and
+// broken down with ?groupBy= (day, user, project, provider, model)
+func GetAIUsage(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		from, _ := monthBounds(time.Now())
+		to := time.Now()
+		var err error
+		if value := c.Query("from"); value != "" {
+			if from, err = parseUsageTime(value); err != nil {
+				return sendError(c, 400, "INVALID_RANGE", "from must be an RFC 3339 time or YYYY-MM-DD date", value)
+			}
+		}
+		if value := c.Query("to"); value != "" {
+			if to, err = parseUsageTime(value); err != nil {
+				return sendError(c, 400, "INVALID_RANGE", "to must be an RFC 3339 time or YYYY-MM-DD date", value)
+			}
+			if !strings.Contains(value, "T") {
+				to = to.AddDate(0, 0, 1) // A date includes the whole day
+			}
+		}
+		if !to.After(from) {
+			return sendError(c, 400, "INVALID_RANGE", "to must be after from", "")
+		}
+
+		groupBy := c.Query("groupBy")
+		if groupBy != "" && !usageGroups[groupBy] {
+			return sendError(c, 400, "INVALID_GROUP", "groupBy must be day, user, project, provider or model", groupBy)
+		}
+
+		query := db.Model(&AICommand{}).
+			Select("created_at", "user_id", "project_id", "provider", "model", "input_tokens", "output_tokens", "cost_usd").
+			Where("created_at >= ? AND created_at < ?", from.Unix(), to.Unix())
+		if userID := c.Query("userId"); userID != "" {
+			query = query.Where("user_id = ?", userID)
+		}
+		projectID := c.Query("projectId")
+		if projectID != "" {
+			query = query.Where("project_id = ?", projectID)
+		}
+
+		var commands []AICommand
+		if err := query.Find(&commands).Error; err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load commands", err.Error())
+		}
+
+		var totals UsageTotals
+		groups := make(map[string]*UsageTotals)
+		for _, command := range commands {
+			totals.add(command)
+			if groupBy == "" {
+				continue
+			}
+			key := usageGroupKey(command, groupBy)
+			if groups[key] == nil {
+				groups[key] = &UsageTotals{Key: key}
+			}
+			groups[key].add(command)
+		}
+
+		data := fiber.Map{
+			"from":   from.Unix(),
+			"to":     to.Unix(),
+			"totals": totals,
+		}
+		if groupBy != "" {
+			breakdown := make([]*UsageTotals, 0, len(groups))
+			for _, group := range groups {
+				breakdown = append(breakdown, group)
+			}
+			sort.Slice(breakdown, func(i, j int) bool {
+				if groupBy == "day" {
+					return breakdown[i].Key < breakdown[j].Key
+				}
+				return breakdown[i].CostUSD > breakdown[j].CostUSD
+			})
+			data["groupBy"] = groupBy
+			data["groups"] = breakdown
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
+
+// GetAIBudget reports this month's spend against the global budget and, with
+// ?projectId=, the project's budget
+func GetAIBudget(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		statuses, err := budgetStatuses(db, c.Query("projectId"))
+		if err != nil {
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load budgets", err.Error())
+		}
+		if statuses == nil {
+			statuses = []BudgetStatus{}
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    statuses,
+		})
+	}
+}
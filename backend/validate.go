@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPromptLength caps the prompt of a command, in characters
+const maxPromptLength = 20000
+
+// validatedBodyKey is the Locals key of a body checked by ValidateBody
+const validatedBodyKey = "validatedBody"
+
+var (
+	// contentIDPattern matches block IDs such as home:hero-title
+	contentIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]{0,254}$`)
+	// pageNamePattern matches page namespaces, the part of a block ID before the colon
+	pageNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_./-]{0,127}$`)
+)
+
+// validationAliases are tags that stand for a list of rules, so limits kept in
+// constants can be used in validate tags
+var validationAliases = map[string]string{
+	"prompt": fmt.Sprintf("required,max=%d", maxPromptLength),
+}
+
+// validationPatterns are the validate tags that match a string against a pattern
+var validationPatterns = map[string]*regexp.Regexp{
+	"contentid": contentIDPattern,
+	"pagename":  pageNamePattern,
+	"projectid": projectIDPattern,
+	"planid":    projectIDPattern,
+	"locale":    localePattern,
+	"menuname":  menuNamePattern,
+}
+
+// requestValidator checks the validate tags of request bodies. Problems are reported
+// with the JSON names of the fields; embedded structs add no name, as in JSON.
+var requestValidator = newRequestValidator()
+
+func newRequestValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled(), validator.WithTagNameFuncBlankOmit())
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" && field.Anonymous {
+			return ""
+		}
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+
+	for tag, pattern := range validationPatterns {
+		v.RegisterValidation(tag, func(fl validator.FieldLevel) bool {
+			return pattern.MatchString(fl.Field().String())
+		})
+	}
+	v.RegisterValidation("contenttype", func(fl validator.FieldLevel) bool {
+		return contentTypes[fl.Field().String()]
+	})
+	v.RegisterValidation("singleline", func(fl validator.FieldLevel) bool {
+		return !strings.ContainsAny(fl.Field().String(), "\r\n\x00")
+	})
+
+	for alias, tags := range validationAliases {
+		v.RegisterAlias(alias, tags)
+	}
+	return v
+}
+
+// validateRequest checks the validate tags of a request body and describes every
+// field that fails them
+func validateRequest(req interface{}) []ValidationProblem {
+	err := requestValidator.Struct(req)
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return nil
+	}
+
+	problems := make([]ValidationProblem, 0, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		// Namespace starts with the Go name of the request type: AICommandRequest.context.page
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		problems = append(problems, ValidationProblem{
+			Field:   field,
+			Code:    fe.ActualTag(), // The rule that failed, not the alias it came from
+			Message: validationMessage(field, fe),
+		})
+	}
+	return problems
+}
+
+// validationMessage explains a failed rule to a person
+func validationMessage(field string, fe validator.FieldError) string {
+	switch fe.ActualTag() {
+	case "required":
+		return field + " is required"
+	case "max", "min":
+		bound := "at most"
+		if fe.ActualTag() == "min" {
+			bound = "at least"
+		}
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("%s must be %s %s characters", field, bound, fe.Param())
+		case reflect.Slice, reflect.Map:
+			return fmt.Sprintf("%s must have %s %s items", field, bound, fe.Param())
+		}
+		return fmt.Sprintf("%s must be %s %s", field, bound, fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "contentid":
+		return field + " must be a block ID such as home:hero-title"
+	case "pagename":
+		return field + " must be a page name of letters, digits, _ . / and -"
+	case "projectid":
+		return field + " must be a project ID of letters, digits, _ and -"
+	case "planid":
+		return field + " must be a plan ID of letters, digits, _ and -"
+	case "locale":
+		return field + " must be a language tag such as en or pt-BR"
+	case "menuname":
+		return field + " must be a menu name of lowercase letters, digits, _ and -"
+	case "contenttype":
+		return field + " must be richtext, plaintext, markdown, json or image-ref"
+	case "singleline":
+		return field + " must be a single line"
+	}
+	return fmt.Sprintf("%s failed the %s rule", field, fe.ActualTag())
+}
+
+// ValidateBody parses the JSON body of a request into a T and checks its validate tags
+// before the handler runs. Requests that fail are answered with 400 VALIDATION_FAILED
+// and the problems of every field; handlers read the body with validatedBody.
+func ValidateBody[T any]() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := new(T)
+		if err := c.BodyParser(req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+		if problems := validateRequest(req); len(problems) > 0 {
+			return sendError(c, 400, "VALIDATION_FAILED", "Request body is not valid", problems)
+		}
+		c.Locals(validatedBodyKey, req)
+		return c.Next()
+	}
+}
+
+// validatedBody returns the body checked by ValidateBody[T], which must run before the
+// handler
+func validatedBody[T any](c *fiber.Ctx) *T {
+	return c.Locals(validatedBodyKey).(*T)
+}
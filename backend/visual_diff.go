@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// visualDiffThreshold is how far a color channel may move before a pixel counts as changed
+const visualDiffThreshold = 24
+
+// VisualDiff holds the screenshots of a page taken before and after an AI command
+type VisualDiff struct {
+	CommandID     string  `gorm:"primaryKey" json:"commandId"`
+	Page          string  `json:"page"`
+	Path          string  `json:"path"` // Page file relative to the workspace
+	Viewport      string  `json:"viewport"`
+	Before        string  `json:"-"` // PNG files in SCREENSHOT_DIR
+	After         string  `json:"-"`
+	Diff          string  `json:"-"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	ChangedPixels int     `json:"changedPixels"`
+	ChangedRatio  float64 `json:"changedRatio"` // Share of the pixels that changed, 0 to 1
+	Error         string  `json:"error,omitempty"`
+	CreatedAt     int64   `json:"createdAt"`
+	UpdatedAt     int64   `json:"updatedAt"`
+}
+
+// VisualDiffResponse is the data of GET /api/ai/command/:commandId/visual-diff. The
+// images are served by the same endpoint with ?image=.
+type VisualDiffResponse struct {
+	VisualDiff
+	BeforeURL string `json:"beforeUrl,omitempty"`
+	AfterURL  string `json:"afterUrl,omitempty"`
+	DiffURL   string `json:"diffUrl,omitempty"`
+}
+
+// isVisualDiffEnabled returns false when AI_VISUAL_DIFF is set to false, and when no
+// headless Chromium is available
+func isVisualDiffEnabled() bool {
+	if enabled, err := strconv.ParseBool(os.Getenv("AI_VISUAL_DIFF")); err == nil && !enabled {
+		return false
+	}
+	return getScreenshotBrowser() != ""
+}
+
+// getVisualDiffKeep returns how many commands keep their screenshots from VISUAL_DIFF_KEEP
+// Falls back to 50
+func getVisualDiffKeep() int {
+	if n, err := strconv.Atoi(getEnvDefault("VISUAL_DIFF_KEEP", "50")); err == nil && n >= 0 {
+		return n
+	}
+	return 50
+}
+
+// visualDiffFile returns where one image of a command's visual diff is stored
+func visualDiffFile(commandID, image string) string {
+	return filepath.Join(getScreenshotDir(), "commands", commandID+"-"+image+".png")
+}
+
+// captureCommandScreenshot renders the page of a command in its working directory and
+// stores it as the before or after image. Failures never fail the command; those of the
+// after image are recorded on the visual diff. Reports whether the images were compared.
+func captureCommandScreenshot(ctx context.Context, db *gorm.DB, command *AICommand, workDir, stage string) bool {
+	rel, err := lookupPageFile(db, command.Page)
+	if err != nil {
+		log.Printf("⚠️ Failed to find the page file of command [%s]: %v", command.ID, err)
+		return false
+	}
+	viewport := screenshotViewports["desktop"]
+
+	diff := VisualDiff{CommandID: command.ID, Page: command.Page, Path: rel, Viewport: viewport.Name, CreatedAt: time.Now().Unix()}
+	if stage == "after" {
+		if err := db.First(&diff, "command_id = ?", command.ID).Error; err != nil || diff.Before == "" {
+			return false // Nothing to compare with
+		}
+	}
+	diff.UpdatedAt = time.Now().Unix()
+
+	file, err := resolvePathInDir(workDir, rel)
+	if err == nil {
+		_, err = os.Stat(file)
+	}
+	var data []byte
+	if err == nil {
+		data, err = capturePage(ctx, workDir, rel, viewport)
+	}
+	if err == nil {
+		target := visualDiffFile(command.ID, stage)
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+			err = writeFileAtomic(target, data)
+		}
+		if stage == "before" {
+			diff.Before = target
+		} else {
+			diff.After = target
+		}
+	}
+
+	if err == nil && stage == "after" {
+		err = diffScreenshots(&diff)
+	}
+	if err != nil {
+		if stage == "after" || !os.IsNotExist(err) {
+			log.Printf("⚠️ Failed to capture the %s screenshot of command [%s]: %v", stage, command.ID, err)
+		}
+		if stage == "before" {
+			return false // The page does not exist yet, or cannot be rendered
+		}
+		diff.Error = err.Error()
+	}
+	if err := db.Save(&diff).Error; err != nil {
+		log.Printf("⚠️ Failed to save the visual diff of command [%s]: %v", command.ID, err)
+		return false
+	}
+	if stage == "after" && diff.Error == "" {
+		log.Printf("📸 Visual diff of command [%s]: %.1f%% of %s changed", command.ID, diff.ChangedRatio*100, rel)
+		go pruneVisualDiffs(db)
+		return true
+	}
+	return false
+}
+
+// diffScreenshots compares the before and after images of a visual diff and writes an
+// image of the after state, faded, with the changed pixels in red. Images of different
+// sizes are compared over the larger one; pixels only one of them has count as changed.
+func diffScreenshots(diff *VisualDiff) error {
+	before, err := readPNG(diff.Before)
+	if err != nil {
+		return err
+	}
+	after, err := readPNG(diff.After)
+	if err != nil {
+		return err
+	}
+
+	bb, ab := before.Bounds(), after.Bounds()
+	width, height := max(bb.Dx(), ab.Dx()), max(bb.Dy(), ab.Dy())
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	highlight := color.RGBA{R: 230, G: 20, B: 60, A: 255}
+	changed := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			inBefore := x < bb.Dx() && y < bb.Dy()
+			inAfter := x < ab.Dx() && y < ab.Dy()
+			if !inBefore || !inAfter {
+				out.SetRGBA(x, y, highlight)
+				changed++
+				continue
+			}
+			r1, g1, b1, _ := before.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			r2, g2, b2, _ := after.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			if channelDelta(r1, r2) > visualDiffThreshold || channelDelta(g1, g2) > visualDiffThreshold || channelDelta(b1, b2) > visualDiffThreshold {
+				out.SetRGBA(x, y, highlight)
+				changed++
+				continue
+			}
+			// Unchanged pixels are shown as a light gray version of the page
+			gray := uint8(((r2>>8)*299 + (g2>>8)*587 + (b2>>8)*114) / 1000)
+			gray = 255 - (255-gray)/4
+			out.SetRGBA(x, y, color.RGBA{R: gray, G: gray, B: gray, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return err
+	}
+	diff.Diff = visualDiffFile(diff.CommandID, "diff")
+	if err := writeFileAtomic(diff.Diff, buf.Bytes()); err != nil {
+		return err
+	}
+	diff.Width, diff.Height = width, height
+	diff.ChangedPixels = changed
+	if width*height > 0 {
+		diff.ChangedRatio = float64(changed) / float64(width*height)
+	}
+	return nil
+}
+
+// channelDelta returns the difference of two 16-bit color channels on an 8-bit scale
+func channelDelta(a, b uint32) uint32 {
+	if a > b {
+		return (a - b) >> 8
+	}
+	return (b - a) >> 8
+}
+
+func readPNG(file string) (image.Image, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// pruneVisualDiffs deletes the screenshots of all but the VISUAL_DIFF_KEEP most recent commands
+func pruneVisualDiffs(db *gorm.DB) {
+	var old []VisualDiff
+	db.Order("created_at DESC").Offset(getVisualDiffKeep()).Limit(1000).Find(&old)
+	for _, diff := range old {
+		for _, file := range []string{diff.Before, diff.After, diff.Diff} {
+			if file == "" {
+				continue
+			}
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Printf("⚠️ Failed to remove screenshot %s: %v", file, err)
+			}
+		}
+		db.Delete(&VisualDiff{}, "command_id = ?", diff.CommandID)
+	}
+}
+
+// GetVisualDiff returns the before and after screenshots of a command that targeted a
+// page, with the share of the page that changed. ?image=before|after|diff returns the
+// PNG itself.
+func GetVisualDiff(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandID := c.Params("commandId")
+		var command AICommand
+		if err := db.Select("id").First(&command, "id = ?", commandID).Error; err != nil {
+			return sendError(c, 404, "COMMAND_NOT_FOUND", "Command not found", nil)
+		}
+
+		var diff VisualDiff
+		if err := db.First(&diff, "command_id = ?", commandID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return sendError(c, 404, "VISUAL_DIFF_NOT_FOUND", "No screenshots were taken for this command", "Screenshots are taken for commands that target a page, when headless Chromium is available")
+			}
+			return sendError(c, 500, "DATABASE_ERROR", "Failed to load the visual diff", nil)
+		}
+
+		if kind := c.Query("image"); kind != "" {
+			file, ok := map[string]string{"before": diff.Before, "after": diff.After, "diff": diff.Diff}[kind]
+			if !ok {
+				return sendError(c, 400, "INVALID_IMAGE", "image must be before, after or diff", kind)
+			}
+			data, err := os.ReadFile(file)
+			if file == "" || err != nil {
+				return sendError(c, 404, "IMAGE_NOT_FOUND", fmt.Sprintf("The %s image of this command is not available", kind), diff.Error)
+			}
+			c.Set(fiber.HeaderCacheControl, "private, max-age=3600")
+			c.Set(fiber.HeaderContentType, "image/png")
+			return c.Send(data)
+		}
+
+		base := "/api/ai/command/" + commandID + "/visual-diff?image="
+		response := VisualDiffResponse{VisualDiff: diff}
+		if diff.Before != "" {
+			response.BeforeURL = base + "before"
+		}
+		if diff.After != "" {
+			response.AfterURL = base + "after"
+		}
+		if diff.Diff != "" {
+			response.DiffURL = base + "diff"
+		}
+		return c.JSON(APIResponse[VisualDiffResponse]{Success: true, Data: response})
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxWorkspaceFileSize caps the size of files served by the file browser
+const maxWorkspaceFileSize = 2 * 1024 * 1024
+
+var errPathOutsideWorkspace = errors.New("path escapes the workspace directory")
+
+// errPathInGitDir is returned for paths inside the workspace's .git directory, whose hooks
+// and config the auto-commit would run
+var errPathInGitDir = errors.New("path is inside the .git directory")
+
+// WorkspaceFileRequest represents a request to write or diff a workspace file
+type WorkspaceFileRequest struct {
+	Path       string `json:"path"`
+	Content    string `json:"content"`
+	CreateDirs bool   `json:"createDirs,omitempty"` // Create missing parent directories on write
+	ProjectID  string `json:"projectId,omitempty"`  // Workspace of this project instead of the default one
+}
+
+// WorkspaceEntry describes a file or directory in the workspace
+type WorkspaceEntry struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"` // Relative to the workspace root
+	IsDir    bool   `json:"isDir"`
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"`
+}
+
+// resolvePathInDir maps a client-supplied relative path to an absolute path inside the
+// workspace at dir, rejecting anything that escapes it (.., absolute paths, symlinks) or
+// reaches into .git
+func resolvePathInDir(dir, rel string) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	target := filepath.Join(root, filepath.FromSlash("/"+strings.TrimPrefix(rel, "/")))
+	if !isWithinDir(root, target) {
+		return "", errPathOutsideWorkspace
+	}
+	if isInGitDir(root, target) {
+		return "", errPathInGitDir
+	}
+
+	// Resolve symlinks of the deepest existing ancestor so links cannot point outside
+	existing, rest := target, ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			if !isWithinDir(root, resolved) {
+				return "", errPathOutsideWorkspace
+			}
+			if isInGitDir(root, resolved) {
+				return "", errPathInGitDir
+			}
+			return filepath.Join(resolved, rest), nil
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return target, nil
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+}
+
+// isWithinDir reports whether path is root or a descendant of root
+func isWithinDir(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// isInGitDir reports whether path is root's .git directory or inside it
+func isInGitDir(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	first, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return strings.EqualFold(first, ".git")
+}
+
+// requestWorkspace returns the workspace the file and git routes act on: the workspace of
+// projectID, or the default one. It answers unknown projects itself.
+func requestWorkspace(c *fiber.Ctx, db *gorm.DB, projectID string) (string, error) {
+	dir, err := resolveWorkspaceDir(db, projectID)
+	if errors.Is(err, errProjectNotFound) {
+		return "", sendError(c, 404, "PROJECT_NOT_FOUND", "Project not found", projectID)
+	}
+	if err != nil {
+		return "", sendError(c, 500, "DATABASE_ERROR", "Failed to resolve project workspace", err.Error())
+	}
+	return dir, nil
+}
+
+// workspaceRelPath returns the slash-separated path of abs relative to the workspace root
+func workspaceRelPath(root, abs string) string {
+	root, _ = filepath.Abs(root)
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return abs
+	}
+	return filepath.ToSlash(rel)
+}
+
+// workspacePathError converts a path resolution error into an HTTP response
+func workspacePathError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errPathOutsideWorkspace) {
+		return sendError(c, 400, "INVALID_PATH", "Path must stay inside the workspace", nil)
+	}
+	if errors.Is(err, errPathInGitDir) {
+		return sendError(c, 400, "INVALID_PATH", "Paths inside .git can't be accessed", nil)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return sendError(c, 404, "FILE_NOT_FOUND", "File or directory not found", nil)
+	}
+	return sendError(c, 500, "WORKSPACE_ERROR", "Failed to access workspace", err.Error())
+}
+
+// ListWorkspaceFiles lists the entries of a workspace directory (?path=)
+func ListWorkspaceFiles(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		root, err := requestWorkspace(c, db, c.Query("projectId"))
+		if root == "" {
+			return err
+		}
+		dir, err := resolvePathInDir(root, c.Query("path"))
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+
+		showHidden := c.QueryBool("hidden", false)
+		entries := make([]WorkspaceEntry, 0, len(dirEntries))
+		for _, entry := range dirEntries {
+			if !showHidden && strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			entries = append(entries, WorkspaceEntry{
+				Name:     entry.Name(),
+				Path:     workspaceRelPath(root, filepath.Join(dir, entry.Name())),
+				IsDir:    entry.IsDir(),
+				Size:     info.Size(),
+				Modified: info.ModTime().Unix(),
+			})
+		}
+
+		// Directories first, then alphabetical
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].IsDir != entries[j].IsDir {
+				return entries[i].IsDir
+			}
+			return entries[i].Name < entries[j].Name
+		})
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"path":    workspaceRelPath(root, dir),
+				"entries": entries,
+			},
+		})
+	}
+}
+
+// GetWorkspaceFile returns the contents of a workspace file (?path=)
+func GetWorkspaceFile(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		root, err := requestWorkspace(c, db, c.Query("projectId"))
+		if root == "" {
+			return err
+		}
+		path, err := resolvePathInDir(root, c.Query("path"))
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+		if info.IsDir() {
+			return sendError(c, 400, "IS_DIRECTORY", "Path is a directory, use /api/workspace/files to list it", nil)
+		}
+		if info.Size() > maxWorkspaceFileSize {
+			return sendError(c, 413, "FILE_TOO_LARGE", "File is too large to display", nil)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"path":        workspaceRelPath(root, path),
+				"size":        info.Size(),
+				"modified":    info.ModTime().Unix(),
+				"contentType": http.DetectContentType(data),
+				"content":     string(data),
+			},
+		})
+	}
+}
+
+// writeFileAtomic writes data to path via a temporary file and rename, keeping existing permissions
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PutWorkspaceFile writes a file in the workspace (hand edits from the editor)
+func PutWorkspaceFile(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req WorkspaceFileRequest
+		if err := c.BodyParser(&req); err != nil {
+			return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+		}
+
+		if strings.Trim(req.Path, "/") == "" {
+			return sendError(c, 400, "MISSING_PATH", "Path is required", nil)
+		}
+		if len(req.Content) > maxWorkspaceFileSize {
+			return sendError(c, 413, "FILE_TOO_LARGE", "File content is too large", nil)
+		}
+
+		if req.ProjectID == "" {
+			req.ProjectID = c.Query("projectId")
+		}
+		root, err := requestWorkspace(c, db, req.ProjectID)
+		if root == "" {
+			return err
+		}
+		path, err := resolvePathInDir(root, req.Path)
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return sendError(c, 400, "IS_DIRECTORY", "Path is a directory", nil)
+		}
+
+		if req.CreateDirs {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return workspacePathError(c, err)
+			}
+		}
+
+		_, statErr := os.Stat(path)
+		created := os.IsNotExist(statErr)
+
+		if err := writeFileAtomic(path, []byte(req.Content)); err != nil {
+			return workspacePathError(c, err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+
+		log.Printf("✏️ Workspace file written: %s (%d bytes)", workspaceRelPath(root, path), info.Size())
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"path":     workspaceRelPath(root, path),
+				"size":     info.Size(),
+				"modified": info.ModTime().Unix(),
+				"created":  created,
+			},
+		})
+	}
+}
+
+// DiffWorkspaceFile returns a unified diff between a workspace file and the supplied content
+func DiffWorkspaceFile(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req WorkspaceFileRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return sendError(c, 400, "INVALID_REQUEST", "Invalid request body", err.Error())
+			}
+		}
+		if req.Path == "" {
+			req.Path = c.Query("path")
+		}
+
+		if req.ProjectID == "" {
+			req.ProjectID = c.Query("projectId")
+		}
+		root, err := requestWorkspace(c, db, req.ProjectID)
+		if root == "" {
+			return err
+		}
+		path, err := resolvePathInDir(root, req.Path)
+		if err != nil {
+			return workspacePathError(c, err)
+		}
+
+		// A missing file diffs as empty so new files show up as all additions
+		current, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return workspacePathError(c, err)
+		}
+
+		rel := workspaceRelPath(root, path)
+		diff := unifiedDiff("a/"+rel, "b/"+rel, string(current), req.Content, 3)
+		added, removed := diffStats(diffTokens(splitLines(string(current)), splitLines(req.Content)))
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"path":      rel,
+				"diff":      diff,
+				"identical": diff == "",
+				"added":     added,
+				"removed":   removed,
+			},
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// WebSocket subprotocols of the command, batch and live update streams. Clients that
+// ask for none get JSON text frames, as before.
+const (
+	wsProtocolJSON    = "site-editor.json"
+	wsProtocolMsgPack = "site-editor.msgpack" // The same messages as MessagePack binary frames
+)
+
+var errInvalidWSMessage = errors.New("client message is not an object")
+
+// isWSCompressionEnabled returns false when compression is turned off (streams.compression, WS_COMPRESSION)
+func isWSCompressionEnabled() bool {
+	return appConfig.Streams.Compression
+}
+
+// streamWSConfig lets stream clients negotiate MessagePack with Sec-WebSocket-Protocol
+// and permessage-deflate with Sec-WebSocket-Extensions. The client's order of
+// subprotocols decides.
+func streamWSConfig() websocket.Config {
+	return websocket.Config{
+		Subprotocols:      []string{wsProtocolMsgPack, wsProtocolJSON},
+		EnableCompression: isWSCompressionEnabled(),
+	}
+}
+
+// writeWSValue sends v as a JSON text frame, or as a MessagePack binary frame on
+// connections that negotiated it. A write that a client doesn't read within
+// WS_SEND_TIMEOUT fails instead of blocking the stream.
+func writeWSValue(conn *websocket.Conn, v interface{}) error {
+	conn.SetWriteDeadline(time.Now().Add(getWSSendTimeout()))
+	if conn.Subprotocol() != wsProtocolMsgPack {
+		return conn.WriteJSON(v)
+	}
+	data, err := encodeMsgPack(v)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// encodeMsgPack encodes v as MessagePack with the field names and omitted fields of
+// its JSON encoding, so both modes carry the same messages
+func encodeMsgPack(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return msgp.AppendIntf(nil, generic)
+}
+
+// readWSMessage reads a client message: JSON in text frames, MessagePack in binary frames
+func readWSMessage(conn *websocket.Conn) (map[string]interface{}, error) {
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if messageType != websocket.BinaryMessage {
+		var msg map[string]interface{}
+		err := json.Unmarshal(data, &msg)
+		return msg, err
+	}
+	value, _, err := msgp.ReadIntfBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	msg, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errInvalidWSMessage
+	}
+	return msg, nil
+}
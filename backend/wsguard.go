@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// getWSAllowedOrigins returns the origins allowed to open WebSockets (wsOrigins, WS_ALLOWED_ORIGINS)
+// Falls back to * (any origin), matching the CORS policy
+func getWSAllowedOrigins() []string {
+	origins := make([]string, 0, len(appConfig.WSOrigins))
+	for _, origin := range appConfig.WSOrigins {
+		origins = append(origins, strings.TrimSuffix(strings.ToLower(origin), "/"))
+	}
+	return origins
+}
+
+// wsURL returns the WebSocket URL of path on the host the request was sent to, with
+// wss:// when it came in over HTTPS (directly or through a proxy setting X-Forwarded-Proto)
+func wsURL(c *fiber.Ctx, path string) string {
+	scheme := "ws"
+	if c.Protocol() == "https" {
+		scheme = "wss"
+	}
+	return scheme + "://" + c.Hostname() + path
+}
+
+// wsOriginAllowed checks the Origin header of an upgrade request. Requests without an
+// Origin are not sent by browsers and are allowed. Entries may be exact origins
+// (https://editor.example.com) or match subdomains (https://*.example.com).
+func wsOriginAllowed(origin string, allowed []string) bool {
+	if origin == "" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(pattern, "://*."); ok {
+			if strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequireWebSocket answers plain HTTP requests to WebSocket routes with 426 and
+// rejects upgrades from origins not listed in WS_ALLOWED_ORIGINS
+func RequireWebSocket() fiber.Handler {
+	allowed := getWSAllowedOrigins()
+
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			c.Set("Upgrade", "websocket")
+			return sendError(c, fiber.StatusUpgradeRequired, "UPGRADE_REQUIRED", "This endpoint only accepts WebSocket connections", nil)
+		}
+
+		if !wsOriginAllowed(c.Get("Origin"), allowed) {
+			return sendError(c, 403, "ORIGIN_NOT_ALLOWED", "WebSocket connections from this origin are not allowed", c.Get("Origin"))
+		}
+		return c.Next()
+	}
+}